@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// AdminAPI adds router endpoints to Caddy's admin API, so operators can take providers out of
+// rotation for planned maintenance and promote or roll back canaries without reloading the config:
+//
+//	GET    /ai_router/<router>/providers                  maintenance state of every provider
+//	GET    /ai_router/<router>/providers/<provider>       maintenance state of one provider
+//	PUT    /ai_router/<router>/providers/<provider>       override it: {"disabled", "drain_until", "drain_for"}
+//	DELETE /ai_router/<router>/providers/<provider>       drop the override, back to the config
+//	GET    /ai_router/<router>/canaries                   state and per-arm stats of every canary
+//	GET    /ai_router/<router>/canaries/<canary>          state and per-arm stats of one canary
+//	POST   /ai_router/<router>/canaries/<canary>/promote  send all its traffic to the target
+//	POST   /ai_router/<router>/canaries/<canary>/rollback send none of its traffic to the target
+//	DELETE /ai_router/<router>/canaries/<canary>          back to the configured percent, stats reset
+type AdminAPI struct{}
+
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.ai_router",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+func (a *AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{Pattern: "/ai_router/", Handler: caddy.AdminHandlerFunc(a.handle)},
+	}
+}
+
+// providerStatus is a provider's maintenance state and degraded models as the admin API reports them.
+type providerStatus struct {
+	Provider   string `json:"provider"`
+	Disabled   bool   `json:"disabled"`
+	DrainUntil string `json:"drain_until,omitempty"`
+	InRotation bool   `json:"in_rotation"`
+	// Models whose last warm-up failed
+	DegradedModels []string `json:"degraded_models,omitempty"`
+	// "admin" if set through the admin API, otherwise "config"
+	Source string `json:"source"`
+}
+
+// maintenanceUpdate is the body of a PUT. drain_for is relative to now and wins over drain_until.
+type maintenanceUpdate struct {
+	Disabled   bool   `json:"disabled"`
+	DrainUntil string `json:"drain_until,omitempty"`
+	DrainFor   string `json:"drain_for,omitempty"`
+}
+
+func (a *AdminAPI) handle(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/ai_router/"), "/"), "/")
+	if len(parts) < 2 {
+		return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("unknown path %s", r.URL.Path)}
+	}
+	cr, ok := getRouter(parts[0])
+	if !ok {
+		return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("router '%s' not found", parts[0])}
+	}
+	switch {
+	case parts[1] == "providers" && len(parts) <= 3:
+		return a.handleProviders(w, r, cr, parts[2:])
+	case parts[1] == "canaries" && len(parts) <= 4:
+		return a.handleCanaries(w, r, cr, parts[2:])
+	}
+	return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("unknown path %s", r.URL.Path)}
+}
+
+// handleProviders serves /providers[/<provider>].
+func (a *AdminAPI) handleProviders(w http.ResponseWriter, r *http.Request, cr *AICoreRouter, parts []string) error {
+	if len(parts) == 0 {
+		if r.Method != http.MethodGet {
+			return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+		}
+		cr.mu.RLock()
+		providers := make([]*ProviderConfig, 0, len(cr.Providers))
+		for _, p := range cr.Providers {
+			providers = append(providers, p)
+		}
+		cr.mu.RUnlock()
+		sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+		statuses := make([]providerStatus, 0, len(providers))
+		for _, p := range providers {
+			statuses = append(statuses, cr.providerStatus(r, p))
+		}
+		return writeAdminJSON(w, statuses)
+	}
+
+	cr.mu.RLock()
+	p, ok := cr.Providers[strings.ToLower(parts[0])]
+	cr.mu.RUnlock()
+	if !ok {
+		return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("provider '%s' not found on router '%s'", parts[0], cr.Name)}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var update maintenanceUpdate
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		if err := json.Unmarshal(body, &update); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("invalid body: %v", err)}
+		}
+		m := providerMaintenance{Disabled: update.Disabled}
+		if m.DrainUntil, err = parseDrainUntil(update.DrainUntil); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		if update.DrainFor != "" {
+			drainFor, err := caddy.ParseDuration(update.DrainFor)
+			if err != nil || drainFor <= 0 {
+				return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("invalid drain_for '%s'", update.DrainFor)}
+			}
+			m.DrainUntil = time.Now().Add(drainFor)
+		}
+		if err := cr.setMaintenance(r.Context(), p, m); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+		}
+		cr.logMaintenance(p, m)
+	case http.MethodDelete:
+		if err := cr.clearMaintenance(r.Context(), p); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+		}
+		m, _ := cr.maintenance(r.Context(), p)
+		cr.logMaintenance(p, m)
+	default:
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+	return writeAdminJSON(w, cr.providerStatus(r, p))
+}
+
+// handleCanaries serves /canaries[/<canary>[/promote|/rollback]].
+func (a *AdminAPI) handleCanaries(w http.ResponseWriter, r *http.Request, cr *AICoreRouter, parts []string) error {
+	if len(parts) == 0 {
+		if r.Method != http.MethodGet {
+			return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+		}
+		statuses := make([]canaryStatus, 0, len(cr.Canaries))
+		for _, c := range cr.Canaries {
+			status, err := cr.canaryStatus(r.Context(), c)
+			if err != nil {
+				return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+			}
+			statuses = append(statuses, status)
+		}
+		return writeAdminJSON(w, statuses)
+	}
+
+	c := cr.canaryNamed(parts[0])
+	if c == nil {
+		return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("canary '%s' not found on router '%s'", parts[0], cr.Name)}
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+	case action == "" && r.Method == http.MethodDelete:
+		if err := cr.resetCanary(r.Context(), c); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+		}
+		cr.logCanaryState(c, "")
+	case (action == "promote" || action == "rollback") && r.Method == http.MethodPost:
+		state := CanaryStatePromoted
+		if action == "rollback" {
+			state = CanaryStateRolledBack
+		}
+		if err := cr.setCanaryState(r.Context(), c, state); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+		}
+		cr.logCanaryState(c, state)
+	case action != "" && action != "promote" && action != "rollback":
+		return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("unknown canary action '%s'", action)}
+	default:
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+	status, err := cr.canaryStatus(r.Context(), c)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+	}
+	return writeAdminJSON(w, status)
+}
+
+func (cr *AICoreRouter) providerStatus(r *http.Request, p *ProviderConfig) providerStatus {
+	m, overridden := cr.maintenance(r.Context(), p)
+	status := providerStatus{
+		Provider:   p.Name,
+		Disabled:   m.Disabled,
+		InRotation: !m.active(time.Now()),
+		Source:     "config",
+	}
+	status.DegradedModels = cr.degradedModels(r.Context(), p)
+	if !m.DrainUntil.IsZero() {
+		status.DrainUntil = m.DrainUntil.UTC().Format(time.RFC3339)
+	}
+	if overridden {
+		status.Source = "admin"
+	}
+	return status
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+var (
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+)
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// Alert rule types.
+const (
+	AlertDailySpend = "daily_spend"
+	AlertErrorRate  = "error_rate"
+)
+
+// What daily spend is summed per.
+const (
+	AlertPerUser     = "user"
+	AlertPerProvider = "provider"
+)
+
+const (
+	defaultAlertWindow      = 5 * time.Minute
+	defaultAlertMinRequests = 20
+	alertQueueSize          = 64
+)
+
+// AlertsConfig posts a webhook when the daily spend of a user or provider, or the error rate
+// of a provider, crosses a threshold. The payload's text field makes it a valid Slack message.
+type AlertsConfig struct {
+	// Where alerts are posted
+	WebhookURL string `json:"webhook_url"`
+	// Extra headers for webhook requests
+	Headers map[string]string `json:"headers,omitempty"`
+	// Window error rates are measured over (default 5m)
+	Window caddy.Duration `json:"window,omitempty"`
+	// Thresholds; each fires at most once per day (spend) or window (error rate) and subject
+	Rules []*AlertRule `json:"rules,omitempty"`
+}
+
+// AlertRule is one alert threshold.
+type AlertRule struct {
+	// daily_spend or error_rate
+	Type string `json:"type"`
+	// What daily spend is summed per: user or provider. Error rates are always per provider.
+	Per string `json:"per,omitempty"`
+	// USD for daily_spend, a percentage of failed requests for error_rate
+	Threshold float64 `json:"threshold"`
+	// Requests a window needs before its error rate is checked (default 20)
+	MinRequests int `json:"min_requests,omitempty"`
+}
+
+func (c *AlertsConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.WebhookURL == "" {
+		return fmt.Errorf("alerts: webhook url is required")
+	}
+	if c.Window < 0 || (c.Window > 0 && time.Duration(c.Window) < time.Second) {
+		return fmt.Errorf("alerts: window must be at least 1s")
+	}
+	for i, rule := range c.Rules {
+		switch rule.Type {
+		case AlertDailySpend:
+			if rule.Per != AlertPerUser && rule.Per != AlertPerProvider {
+				return fmt.Errorf("alerts: rule %d: daily_spend is summed per user or provider, not '%s'", i, rule.Per)
+			}
+		case AlertErrorRate:
+			if rule.Threshold > 100 {
+				return fmt.Errorf("alerts: rule %d: error_rate threshold is a percentage", i)
+			}
+		default:
+			return fmt.Errorf("alerts: rule %d: unsupported type '%s'", i, rule.Type)
+		}
+		if rule.Threshold <= 0 {
+			return fmt.Errorf("alerts: rule %d: threshold must be positive", i)
+		}
+		if rule.MinRequests < 0 {
+			return fmt.Errorf("alerts: rule %d: min_requests must not be negative", i)
+		}
+	}
+	return nil
+}
+
+func (c *AlertsConfig) window() time.Duration {
+	if c.Window > 0 {
+		return time.Duration(c.Window)
+	}
+	return defaultAlertWindow
+}
+
+// alert is the webhook payload of a fired rule.
+type alert struct {
+	Text      string    `json:"text"`
+	Router    string    `json:"router"`
+	Type      string    `json:"type"`
+	Per       string    `json:"per"`
+	Subject   string    `json:"subject"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// alerter aggregates accounted usage into counters in the router store and delivers alerts
+// from a background goroutine. Counters are shared, so with a shared store thresholds apply
+// across instances and each alert is sent by one of them.
+type alerter struct {
+	cr     *AICoreRouter
+	config *AlertsConfig
+	client *http.Client
+	queue  chan alert
+	stop   chan struct{}
+}
+
+func newAlerter(cr *AICoreRouter) *alerter {
+	return &alerter{
+		cr:     cr,
+		config: cr.Alerts,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan alert, alertQueueSize),
+		stop:   make(chan struct{}),
+	}
+}
+
+// observe adds a request's usage to the counters the rules read. Spend thresholds are
+// checked right away: the increment that crosses one fires it.
+func (a *alerter) observe(ctx context.Context, record usageRecord) {
+	ctx = context.WithoutCancel(ctx)
+	day := record.Time.UTC().Format(time.DateOnly)
+	countedWindow := false
+	for i, rule := range a.config.Rules {
+		switch rule.Type {
+		case AlertDailySpend:
+			if record.Cost == nil || *record.Cost <= 0 {
+				continue
+			}
+			subject := record.Provider
+			if rule.Per == AlertPerUser {
+				if subject = record.UserID; subject == "" {
+					subject = record.APIKeyID
+				}
+			}
+			if subject == "" {
+				continue
+			}
+			micros := int64(math.Round(*record.Cost * 1e6))
+			total, err := a.cr.store.IncrBy(ctx, a.cr.storeKey("alert_spend", rule.Per, subject, day), micros, 48*time.Hour)
+			if err != nil {
+				a.cr.logger.Warn("Failed to count spend for alerts", zap.Error(err))
+				continue
+			}
+			threshold := int64(math.Round(rule.Threshold * 1e6))
+			if total >= threshold && total-micros < threshold {
+				a.fire(ctx, i, rule, subject, day, float64(total)/1e6)
+			}
+		case AlertErrorRate:
+			if countedWindow {
+				continue
+			}
+			countedWindow = true
+			bucket := a.bucket(record.Time)
+			ttl := 3 * a.config.window()
+			a.cr.store.IncrBy(ctx, a.cr.storeKey("alert_requests", record.Provider, bucket), 1, ttl)
+			if record.Failed {
+				a.cr.store.IncrBy(ctx, a.cr.storeKey("alert_errors", record.Provider, bucket), 1, ttl)
+			}
+		}
+	}
+}
+
+// bucket names the error rate window a time falls in.
+func (a *alerter) bucket(t time.Time) string {
+	return strconv.FormatInt(t.Unix()/int64(a.config.window().Seconds()), 10)
+}
+
+// checkErrorRates fires the error_rate rules crossed by providers in the last complete window.
+func (a *alerter) checkErrorRates() {
+	window := a.config.window()
+	bucket := a.bucket(time.Now().Add(-window))
+	ctx, cancel := context.WithTimeout(context.Background(), window/4)
+	defer cancel()
+
+	a.cr.mu.RLock()
+	providerNames := append([]string(nil), a.cr.ProviderOrder...)
+	a.cr.mu.RUnlock()
+	for _, name := range providerNames {
+		requests, err := a.cr.store.IncrBy(ctx, a.cr.storeKey("alert_requests", name, bucket), 0, 3*window)
+		if err != nil || requests == 0 {
+			continue
+		}
+		failed, err := a.cr.store.IncrBy(ctx, a.cr.storeKey("alert_errors", name, bucket), 0, 3*window)
+		if err != nil {
+			continue
+		}
+		rate := float64(failed) / float64(requests) * 100
+		for i, rule := range a.config.Rules {
+			minRequests := rule.MinRequests
+			if minRequests == 0 {
+				minRequests = defaultAlertMinRequests
+			}
+			if rule.Type == AlertErrorRate && requests >= int64(minRequests) && rate > rule.Threshold {
+				a.fire(ctx, i, rule, name, bucket, rate)
+			}
+		}
+	}
+}
+
+// fire queues an alert unless it was already sent for this rule, subject and period.
+func (a *alerter) fire(ctx context.Context, index int, rule *AlertRule, subject, period string, value float64) {
+	claimed, err := a.cr.store.IncrBy(ctx, a.cr.storeKey("alert_fired", strconv.Itoa(index), subject, period), 1, 48*time.Hour)
+	if err != nil || claimed != 1 {
+		return
+	}
+	fired := alert{
+		Router:    a.cr.Name,
+		Type:      rule.Type,
+		Per:       rule.Per,
+		Subject:   subject,
+		Value:     value,
+		Threshold: rule.Threshold,
+		Time:      time.Now(),
+	}
+	switch rule.Type {
+	case AlertDailySpend:
+		fired.Text = fmt.Sprintf("AI router %s: daily spend of %s %s reached $%.2f (threshold $%.2f)", a.cr.Name, rule.Per, subject, value, rule.Threshold)
+	case AlertErrorRate:
+		fired.Per = AlertPerProvider
+		fired.Text = fmt.Sprintf("AI router %s: error rate of provider %s was %.1f%% over the last %s (threshold %.1f%%)", a.cr.Name, subject, value, a.config.window(), rule.Threshold)
+	}
+	select {
+	case a.queue <- fired:
+	default:
+		a.cr.logger.Warn("Alert queue full, dropping alert", zap.String("alert", fired.Text))
+	}
+}
+
+// run delivers queued alerts and checks error rates until the alerter is stopped.
+func (a *alerter) run() {
+	ticker := time.NewTicker(a.config.window() / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case fired := <-a.queue:
+			a.send(fired)
+		case <-ticker.C:
+			a.checkErrorRates()
+		}
+	}
+}
+
+func (a *alerter) send(fired alert) {
+	payload, err := json.Marshal(fired)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, a.config.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		a.cr.logger.Error("Failed to create alert webhook request", zap.Error(err))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	for name, value := range a.config.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		a.cr.logger.Warn("Failed to send alert", zap.String("alert", fired.Text), zap.Error(err))
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		a.cr.logger.Warn("Alert webhook rejected alert", zap.String("alert", fired.Text), zap.Int("status", resp.StatusCode))
+		return
+	}
+	a.cr.logger.Info("Sent alert", zap.String("alert", fired.Text))
+}
+
+// Start launches the background delivery and error rate loop.
+func (a *alerter) Start() {
+	go a.run()
+}
+
+// Stop terminates the background loop; queued alerts are dropped.
+func (a *alerter) Stop() {
+	close(a.stop)
+}
+
+// parseAlertsCaddyfile parses an `alerts [<webhook_url>] { ... }` block.
+func parseAlertsCaddyfile(d *caddyfile.Dispenser) (*AlertsConfig, error) {
+	cfg := &AlertsConfig{}
+	if d.NextArg() {
+		cfg.WebhookURL = d.Val()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "webhook":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.WebhookURL = d.Val()
+		case "header":
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				return nil, d.Errf("alerts header expects <name> <value>")
+			}
+			if cfg.Headers == nil {
+				cfg.Headers = make(map[string]string)
+			}
+			cfg.Headers[args[0]] = args[1]
+		case "window":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			window, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid alerts window '%s': %v", d.Val(), err)
+			}
+			cfg.Window = caddy.Duration(window)
+		case AlertDailySpend:
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				return nil, d.Errf("daily_spend expects <user|provider> <usd>")
+			}
+			threshold, err := strconv.ParseFloat(strings.TrimPrefix(args[1], "$"), 64)
+			if err != nil {
+				return nil, d.Errf("invalid daily_spend threshold '%s'", args[1])
+			}
+			cfg.Rules = append(cfg.Rules, &AlertRule{Type: AlertDailySpend, Per: strings.ToLower(args[0]), Threshold: threshold})
+		case AlertErrorRate:
+			args := d.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return nil, d.Errf("error_rate expects <percent> [<min_requests>]")
+			}
+			threshold, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "%"), 64)
+			if err != nil {
+				return nil, d.Errf("invalid error_rate threshold '%s'", args[0])
+			}
+			rule := &AlertRule{Type: AlertErrorRate, Threshold: threshold}
+			if len(args) == 2 {
+				if rule.MinRequests, err = strconv.Atoi(args[1]); err != nil {
+					return nil, d.Errf("invalid error_rate min_requests '%s'", args[1])
+				}
+			}
+			cfg.Rules = append(cfg.Rules, rule)
+		default:
+			return nil, d.Errf("unrecognized alerts option '%s'", d.Val())
+		}
+	}
+	return cfg, nil
+}
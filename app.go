@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(RouterApp{})
+	httpcaddyfile.RegisterGlobalOption("ai_router", parseRouterAppCaddyfile)
+}
+
+// RouterApp is the `ai_router` app. Routers declared in it are provisioned once per config,
+// independently of any site, and endpoint handlers in every site reference them by name.
+type RouterApp struct {
+	Routers []*AICoreRouter `json:"routers,omitempty"`
+
+	scope *routerScope // Every router of the config, global or declared by a handler
+}
+
+func (RouterApp) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "ai_router",
+		New: func() caddy.Module { return new(RouterApp) },
+	}
+}
+
+func (app *RouterApp) Provision(ctx caddy.Context) error {
+	app.scope = newRouterScope()
+	seen := make(map[string]bool, len(app.Routers))
+	for _, cr := range app.Routers {
+		name := strings.ToLower(strings.TrimSpace(cr.Name))
+		if name == "" {
+			name = "default"
+		}
+		if seen[name] {
+			return fmt.Errorf("ai_router: router '%s' declared more than once", name)
+		}
+		seen[name] = true
+		cr.scope = app.scope
+		if err := cr.Provision(ctx); err != nil {
+			return fmt.Errorf("ai_router: router '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (app *RouterApp) Validate() error {
+	for _, cr := range app.Routers {
+		if err := cr.Validate(); err != nil {
+			return fmt.Errorf("ai_router: router '%s': %v", cr.Name, err)
+		}
+	}
+	return nil
+}
+
+// Start is a no-op; routers serve requests through the endpoint handlers.
+func (app *RouterApp) Start() error {
+	return nil
+}
+
+func (app *RouterApp) Stop() error {
+	return nil
+}
+
+// Cleanup stops the routers' background work when the config is unloaded.
+func (app *RouterApp) Cleanup() error {
+	for _, cr := range app.Routers {
+		cr.Cleanup()
+	}
+	return nil
+}
+
+// parseRouterAppCaddyfile parses `ai_router [name] { ... }` global options. The block takes
+// the same options as the ai_router handler; it may be repeated to declare several routers.
+func parseRouterAppCaddyfile(d *caddyfile.Dispenser, existingVal any) (any, error) {
+	app := &RouterApp{}
+	if existing, ok := existingVal.(httpcaddyfile.App); ok {
+		if err := json.Unmarshal(existing.Value, app); err != nil {
+			return nil, d.Errf("existing ai_router value of unexpected shape: %v", err)
+		}
+	}
+
+	cr := &AICoreRouter{}
+	if err := cr.UnmarshalCaddyfile(d); err != nil {
+		return nil, err
+	}
+	if cr.Name == "" {
+		cr.Name = "default"
+	}
+	for _, other := range app.Routers {
+		if other.Name == cr.Name {
+			return nil, d.Errf("ai_router '%s' already declared in global options", cr.Name)
+		}
+	}
+	app.Routers = append(app.Routers, cr)
+
+	return httpcaddyfile.App{
+		Name:  "ai_router",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+var (
+	_ caddy.App          = (*RouterApp)(nil)
+	_ caddy.Provisioner  = (*RouterApp)(nil)
+	_ caddy.Validator    = (*RouterApp)(nil)
+	_ caddy.CleanerUpper = (*RouterApp)(nil)
+)
@@ -0,0 +1,521 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBatchConcurrency = 8
+	defaultBatchMaxItems    = 10000
+	defaultBatchMaxSize     = 64 << 20
+	defaultBatchJobTTL      = 24 * time.Hour
+	// How often a running async job's progress is written to the router store
+	batchProgressInterval = time.Second
+)
+
+// Batch job states, as in OpenAI's batch objects.
+const (
+	BatchStatusInProgress = "in_progress"
+	BatchStatusCompleted  = "completed"
+)
+
+func init() {
+	caddy.RegisterModule(BatchHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_batch", parseBatchHandlerCaddyfile)
+}
+
+// BatchHandler runs many chat completion requests in one call. It accepts a JSON array of
+// chat requests, a {"requests": [...]} object, or OpenAI batch-format JSONL, fans the items
+// out through the router with bounded concurrency, and answers synchronously or, with
+// ?async=true, with a job that can be polled at <path>/<job id>.
+type BatchHandler struct {
+	Router string `json:"router,omitempty"`
+	// Items of one batch run in parallel (defaults to 8)
+	Concurrency int `json:"concurrency,omitempty"`
+	// Maximum number of items in a batch (defaults to 10000)
+	MaxItems int `json:"max_items,omitempty"`
+	// Maximum batch body size in bytes (defaults to 64MB)
+	MaxBatchSize int64 `json:"max_batch_size,omitempty"`
+	// How long async job results can be polled (defaults to 24h)
+	JobTTL caddy.Duration `json:"job_ttl,omitempty"`
+	RouteOptions
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (BatchHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_batch",
+		New: func() caddy.Module { return new(BatchHandler) },
+	}
+}
+
+func (h *BatchHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	if h.routers, err = routerScopeOf(ctx); err != nil {
+		return err
+	}
+	if h.Concurrency <= 0 {
+		h.Concurrency = defaultBatchConcurrency
+	}
+	if h.MaxItems <= 0 {
+		h.MaxItems = defaultBatchMaxItems
+	}
+	if h.MaxBatchSize <= 0 {
+		h.MaxBatchSize = defaultBatchMaxSize
+	}
+	if h.JobTTL <= 0 {
+		h.JobTTL = caddy.Duration(defaultBatchJobTTL)
+	}
+	if err := h.RouteOptions.provision(h.logger); err != nil {
+		return fmt.Errorf("ai_batch: %v", err)
+	}
+	if h.Heartbeat > 0 {
+		return fmt.Errorf("ai_batch: heartbeat only applies to SSE endpoints")
+	}
+	return nil
+}
+
+// batchItem is one request of a batch.
+type batchItem struct {
+	CustomID string          `json:"custom_id"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// BatchResult is the outcome of one batch item, in OpenAI's batch output format.
+type BatchResult struct {
+	ID       string               `json:"id"`
+	CustomID string               `json:"custom_id"`
+	Response *BatchResultResponse `json:"response"`
+	Error    *OpenAIError         `json:"error"`
+}
+
+// BatchResultResponse is the upstream answer to a batch item.
+type BatchResultResponse struct {
+	StatusCode int             `json:"status_code"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// BatchRequestCounts tallies the items of a batch job.
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchJob describes a batch and, once it completes, its results.
+type BatchJob struct {
+	ID            string             `json:"id"`
+	Object        string             `json:"object"`
+	Status        string             `json:"status"`
+	CreatedAt     int64              `json:"created_at"`
+	CompletedAt   int64              `json:"completed_at,omitempty"`
+	RequestCounts BatchRequestCounts `json:"request_counts"`
+	Results       []BatchResult      `json:"results,omitempty"`
+}
+
+func newRandomID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+// parseBatchItems reads the items of a batch body in any of the accepted shapes.
+// It reports whether the input was JSONL, so results can be answered in kind.
+func parseBatchItems(body []byte) ([]batchItem, bool, error) {
+	var raws []json.RawMessage
+	jsonl := false
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case len(trimmed) == 0:
+		return nil, false, errors.New("empty batch")
+	case trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &raws); err != nil {
+			return nil, false, err
+		}
+	default:
+		var wrapper struct {
+			Requests []json.RawMessage `json:"requests"`
+		}
+		if err := json.Unmarshal(trimmed, &wrapper); err == nil && wrapper.Requests != nil {
+			raws = wrapper.Requests
+			break
+		}
+		jsonl = true
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(trimmed)+1)
+		for line := 1; scanner.Scan(); line++ {
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			if !json.Valid(text) {
+				return nil, false, fmt.Errorf("line %d is not valid JSON", line)
+			}
+			raws = append(raws, append(json.RawMessage(nil), text...))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	items := make([]batchItem, 0, len(raws))
+	for i, raw := range raws {
+		var item batchItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, false, fmt.Errorf("item %d: %v", i, err)
+		}
+		if len(item.Body) == 0 {
+			item.Body = raw // A bare chat request rather than a batch-format line
+		}
+		if item.CustomID == "" {
+			item.CustomID = "request-" + strconv.Itoa(i)
+		}
+		items = append(items, item)
+	}
+	return items, jsonl, nil
+}
+
+// batchResponseWriter captures the response to one batch item.
+type batchResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *batchResponseWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+func (w *batchResponseWriter) Flush() {}
+
+// runItem routes one batch item like a non-streaming chat completion.
+func (h *BatchHandler) runItem(ctx context.Context, cr *AICoreRouter, r *http.Request, item batchItem) BatchResult {
+	result := BatchResult{ID: newRandomID("batch_req_"), CustomID: item.CustomID}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(item.Body, &payload); err != nil {
+		result.Error = &OpenAIError{Message: "Invalid JSON request body", Type: ErrorTypeInvalidRequest}
+		return result
+	}
+	payload["stream"] = json.RawMessage("false") // Batch results are always complete responses
+	delete(payload, "stream_options")
+	body, _ := json.Marshal(payload)
+
+	req := r.Clone(ctx)
+	req.Method = http.MethodPost
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Del("Content-Length")
+	req.Header.Del("Accept-Encoding") // Results are embedded as JSON, so upstream must not compress them
+	req.Header.Del(RequestIDHeader)   // Every item gets its own request ID
+
+	w := &batchResponseWriter{header: make(http.Header)}
+	noop := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error { return nil })
+	if err := cr.handlePostInferenceRequest(w, req, noop, cr.apiKeyServiceFor(req), &h.RouteOptions); err != nil {
+		h.logger.Debug("Batch item failed", zap.String("custom_id", item.CustomID), zap.Error(err))
+	}
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusInternalServerError
+	}
+
+	respBody := bytes.TrimSpace(w.body.Bytes())
+	if !json.Valid(respBody) {
+		respBody, _ = json.Marshal(string(respBody))
+	}
+	if w.statusCode >= 400 {
+		var envelope OpenAIErrorResponse
+		if err := json.Unmarshal(respBody, &envelope); err == nil && envelope.Error.Message != "" {
+			result.Error = &envelope.Error
+		}
+	}
+	result.Response = &BatchResultResponse{StatusCode: w.statusCode, Body: respBody}
+	return result
+}
+
+// runBatch executes all items with bounded concurrency, reporting each finished item to progress.
+func (h *BatchHandler) runBatch(ctx context.Context, cr *AICoreRouter, r *http.Request, items []batchItem, progress func(done int, failed bool)) []BatchResult {
+	results := make([]BatchResult, len(items))
+	sem := make(chan struct{}, h.Concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for i, item := range items {
+		if ctx.Err() != nil {
+			results[i] = BatchResult{ID: newRandomID("batch_req_"), CustomID: item.CustomID, Error: &OpenAIError{Message: "Batch cancelled", Type: ErrorTypeAPI}}
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, item batchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = h.runItem(ctx, cr, r, item)
+
+			mu.Lock()
+			done++
+			if progress != nil {
+				progress(done, results[i].Error != nil)
+			}
+			mu.Unlock()
+		}(i, item)
+	}
+	wg.Wait()
+	return results
+}
+
+func countFailed(results []BatchResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Error != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_batch: router '%s' not found", routerName))
+		return nil
+	}
+	defer cr.track()()
+
+	cr.firePageviewEvent(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		jobID := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if !strings.HasPrefix(jobID, "batch_") {
+			return next.ServeHTTP(w, r)
+		}
+		return h.serveJob(w, r, cr, jobID)
+	case http.MethodPost:
+	default:
+		return next.ServeHTTP(w, r)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.MaxBatchSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, ErrorTypeInvalidRequest, "request_too_large",
+				fmt.Sprintf("Batch body exceeds the maximum allowed size of %d bytes", maxBytesErr.Limit))
+			return err
+		}
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Failed to read request body")
+		return err
+	}
+	r.Body.Close()
+
+	items, jsonl, err := parseBatchItems(body)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_batch", "Invalid batch: "+err.Error())
+		return err
+	}
+	if len(items) > h.MaxItems {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "batch_too_large",
+			fmt.Sprintf("Batch has %d items, exceeding the maximum of %d", len(items), h.MaxItems))
+		return fmt.Errorf("batch has %d items, max %d", len(items), h.MaxItems)
+	}
+
+	job := &BatchJob{
+		ID:            newRandomID("batch_"),
+		Object:        "batch",
+		Status:        BatchStatusInProgress,
+		CreatedAt:     time.Now().Unix(),
+		RequestCounts: BatchRequestCounts{Total: len(items)},
+	}
+	h.logger.Info("Running batch",
+		zap.String("batch_id", job.ID),
+		zap.Int("items", len(items)),
+		zap.Bool("async", r.URL.Query().Get("async") == "true"),
+	)
+
+	if r.URL.Query().Get("async") == "true" {
+		if err := h.saveJob(r.Context(), cr, job); err != nil {
+			writeOpenAIError(w, http.StatusServiceUnavailable, ErrorTypeAPI, "storage_unavailable", "Failed to store batch job")
+			return err
+		}
+		done := cr.track() // The job keeps this router version busy until it finishes
+		go func() {
+			defer done()
+			h.runAsync(context.WithoutCancel(r.Context()), cr, r, job, items)
+		}()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", strings.TrimRight(r.URL.Path, "/")+"/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		return json.NewEncoder(w).Encode(job)
+	}
+
+	results := h.runBatch(r.Context(), cr, r, items, nil)
+	if jsonl {
+		w.Header().Set("Content-Type", "application/jsonl")
+		enc := json.NewEncoder(w)
+		for _, result := range results {
+			if err := enc.Encode(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	job.Status = BatchStatusCompleted
+	job.CompletedAt = time.Now().Unix()
+	job.RequestCounts.Failed = countFailed(results)
+	job.RequestCounts.Completed = len(results) - job.RequestCounts.Failed
+	job.Results = results
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(job)
+}
+
+// runAsync executes a batch in the background, keeping the stored job up to date.
+func (h *BatchHandler) runAsync(ctx context.Context, cr *AICoreRouter, r *http.Request, job *BatchJob, items []batchItem) {
+	var lastSave time.Time
+	progress := func(done int, failed bool) {
+		if failed {
+			job.RequestCounts.Failed++
+		} else {
+			job.RequestCounts.Completed++
+		}
+		if time.Since(lastSave) >= batchProgressInterval {
+			lastSave = time.Now()
+			if err := h.saveJob(ctx, cr, job); err != nil {
+				h.logger.Warn("Failed to store batch progress", zap.String("batch_id", job.ID), zap.Error(err))
+			}
+		}
+	}
+	results := h.runBatch(ctx, cr, r, items, progress)
+
+	job.Status = BatchStatusCompleted
+	job.CompletedAt = time.Now().Unix()
+	job.Results = results
+	if err := h.saveJob(ctx, cr, job); err != nil {
+		h.logger.Error("Failed to store batch results", zap.String("batch_id", job.ID), zap.Error(err))
+		return
+	}
+	h.logger.Info("Batch completed",
+		zap.String("batch_id", job.ID),
+		zap.Int("completed", job.RequestCounts.Completed),
+		zap.Int("failed", job.RequestCounts.Failed),
+	)
+}
+
+// saveJob writes a job to the router store, so any instance can answer polls for it.
+func (h *BatchHandler) saveJob(ctx context.Context, cr *AICoreRouter, job *BatchJob) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return cr.store.Set(ctx, cr.storeKey("batch", job.ID), value, time.Duration(h.JobTTL))
+}
+
+// serveJob answers a poll for an async job.
+func (h *BatchHandler) serveJob(w http.ResponseWriter, r *http.Request, cr *AICoreRouter, jobID string) error {
+	value, ok, err := cr.store.Get(r.Context(), cr.storeKey("batch", jobID))
+	if err != nil {
+		writeOpenAIError(w, http.StatusServiceUnavailable, ErrorTypeAPI, "storage_unavailable", "Failed to load batch job")
+		return err
+	}
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, ErrorTypeNotFound, "batch_not_found", fmt.Sprintf("Batch %s not found", jobID))
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(value)
+	return err
+}
+
+func parseBatchHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var bh BatchHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				bh.Router = h.Val()
+			case "concurrency", "max_items":
+				option := h.Val()
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				n, err := strconv.Atoi(h.Val())
+				if err != nil || n <= 0 {
+					return nil, h.Errf("invalid %s '%s'", option, h.Val())
+				}
+				if option == "concurrency" {
+					bh.Concurrency = n
+				} else {
+					bh.MaxItems = n
+				}
+			case "max_batch_size":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				size, err := humanize.ParseBytes(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid max_batch_size '%s': %v", h.Val(), err)
+				}
+				bh.MaxBatchSize = int64(size)
+			case "job_ttl":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				ttl, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid job_ttl '%s': %v", h.Val(), err)
+				}
+				bh.JobTTL = caddy.Duration(ttl)
+			default:
+				if ok, err := bh.RouteOptions.unmarshalCaddyfileOption(h.Dispenser); err != nil {
+					return nil, err
+				} else if !ok {
+					return nil, h.Errf("unrecognized ai_batch option '%s'", h.Val())
+				}
+			}
+		}
+	}
+	return &bh, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*BatchHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*BatchHandler)(nil)
+)
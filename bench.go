@@ -0,0 +1,273 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+// benchConfig is the router benchmarked unless --config is given: one mock provider, so
+// nothing leaves the machine and the numbers are the router's own.
+const benchConfig = `ai_router {
+	provider mock {
+		style mock
+		models {
+			gpt-mock
+		}
+	}
+}
+`
+
+func aiRouterBenchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench [--config <path>] [--requests <n>] [--concurrency <n>] [--stream | --route-only] [--max-p99 <duration>] [--max-allocs <n>] [--max-bytes <n>]",
+		Short: "Load-tests the request path of a router in process",
+		Long: `
+Runs a number of chat completions requests, some at a time, through the router
+in process, without any listener, and reports throughput, latency percentiles,
+time to first byte for streams, and allocations per request.
+
+Without --config, a router with one mock provider (model gpt-mock) is used,
+so the numbers cover routing, transformation and proxying but no network. With
+--config, requests go to the config's providers, so point it at mock or local
+providers. --route-only stops at the routing decision, as a dry run does.
+
+--max-p99, --max-allocs and --max-bytes set baselines: the command fails if the
+run exceeds one, or if any request fails, so it can guard a CI pipeline.
+`,
+		RunE:         caddycmd.WrapCommandFuncForCobra(cmdAIRouterBench),
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringP("config", "c", "", "Config file or fragment (default: a router with a mock provider)")
+	cmd.Flags().StringP("adapter", "a", "", "Name of config adapter")
+	cmd.Flags().StringP("router", "r", "", "Router to benchmark (default: the ai_chat_completions handler's, or default)")
+	cmd.Flags().String("request", "", "JSON request body file, or - for stdin")
+	cmd.Flags().StringP("model", "m", "", "Model of the requests (default with the mock router: gpt-mock)")
+	cmd.Flags().String("message", "Hello", "User message of the requests")
+	cmd.Flags().Bool("stream", false, "Make the requests streams")
+	cmd.Flags().StringArrayP("header", "H", nil, "Request header, e.g. 'X-AI-Provider: openai' (repeatable)")
+	cmd.Flags().String("path", "/v1/chat/completions", "Request path")
+	cmd.Flags().IntP("requests", "n", 1000, "Requests to measure")
+	cmd.Flags().IntP("concurrency", "j", 16, "Requests in flight at a time")
+	cmd.Flags().Int("warmup", 50, "Requests to run before measuring")
+	cmd.Flags().Bool("route-only", false, "Stop at the routing decision instead of proxying")
+	cmd.Flags().Duration("max-p99", 0, "Fail if the 99th percentile latency exceeds this")
+	cmd.Flags().Int("max-allocs", 0, "Fail if requests allocate more than this many objects each on average")
+	cmd.Flags().Int("max-bytes", 0, "Fail if requests allocate more than this many bytes each on average")
+	cmd.Flags().String("log-level", "ERROR", "Log level of the routers")
+	return cmd
+}
+
+// benchSample is the outcome of one request.
+type benchSample struct {
+	status    int
+	latency   time.Duration
+	firstByte time.Duration
+}
+
+func cmdAIRouterBench(fl caddycmd.Flags) (int, error) {
+	var cfgJSON []byte
+	var err error
+	if fl.String("config") == "" {
+		if fl.String("request") == "" && fl.String("model") == "" {
+			fl.Set("model", "gpt-mock")
+		}
+		cfgJSON, err = adaptAIRouterFragment([]byte(benchConfig), "bench")
+	} else {
+		cfgJSON, err = loadAIRouterTestConfig(fl.String("config"), fl.String("adapter"))
+	}
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	body, err := aiRouterTestRequest(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	requests, concurrency := fl.Int("requests"), fl.Int("concurrency")
+	if requests < 1 || concurrency < 1 {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("--requests and --concurrency must be at least 1")
+	}
+	// Model lists aren't fetched, so they don't skew the run; requests are proxied
+	handler, err := loadAIRouterTestHandler(fl, cfgJSON, true)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer caddy.Stop()
+
+	run := func(n int) ([]benchSample, error) {
+		samples := make([]benchSample, n)
+		var next atomic.Int64
+		var wg sync.WaitGroup
+		var firstErr error
+		var errOnce sync.Once
+		for i := 0; i < min(concurrency, n); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					i := int(next.Add(1)) - 1
+					if i >= n {
+						return
+					}
+					r, err := newAIRouterTestRequest(fl, body)
+					if err != nil {
+						errOnce.Do(func() { firstErr = err })
+						return
+					}
+					if fl.Bool("route-only") {
+						r.Header.Set(DebugHeader, debugRoute)
+					}
+					w := &benchWriter{header: http.Header{}, start: time.Now()}
+					if err := handler.ServeHTTP(w, r, noopHandler); err != nil && w.status == 0 {
+						w.status = http.StatusInternalServerError
+					}
+					samples[i] = benchSample{status: w.statusCode(), latency: time.Since(w.start), firstByte: w.firstByte}
+				}
+			}()
+		}
+		wg.Wait()
+		return samples, firstErr
+	}
+
+	if warmup := fl.Int("warmup"); warmup > 0 {
+		if _, err := run(warmup); err != nil {
+			return caddy.ExitCodeFailedStartup, err
+		}
+	}
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	samples, err := run(requests)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	allocs := (after.Mallocs - before.Mallocs) / uint64(requests)
+	allocBytes := (after.TotalAlloc - before.TotalAlloc) / uint64(requests)
+	failed := printAIRouterBench(fl, samples, elapsed, allocs, allocBytes)
+
+	var exceeded []string
+	if failed > 0 {
+		exceeded = append(exceeded, fmt.Sprintf("%d requests failed", failed))
+	}
+	if maxP99 := fl.Duration("max-p99"); maxP99 > 0 {
+		if p99 := benchPercentile(samples, 0.99, func(s benchSample) time.Duration { return s.latency }); p99 > maxP99 {
+			exceeded = append(exceeded, fmt.Sprintf("p99 latency %s exceeds %s", p99, maxP99))
+		}
+	}
+	if maxAllocs := fl.Int("max-allocs"); maxAllocs > 0 && allocs > uint64(maxAllocs) {
+		exceeded = append(exceeded, fmt.Sprintf("%d allocs/req exceeds %d", allocs, maxAllocs))
+	}
+	if maxBytes := fl.Int("max-bytes"); maxBytes > 0 && allocBytes > uint64(maxBytes) {
+		exceeded = append(exceeded, fmt.Sprintf("%d B/req exceeds %d", allocBytes, maxBytes))
+	}
+	if len(exceeded) > 0 {
+		return caddy.ExitCodeFailedQuit, fmt.Errorf("baseline not met: %s", strings.Join(exceeded, "; "))
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// printAIRouterBench prints the results of a run and returns how many requests failed.
+func printAIRouterBench(fl caddycmd.Flags, samples []benchSample, elapsed time.Duration, allocs, allocBytes uint64) int {
+	mode := "completions"
+	switch {
+	case fl.Bool("route-only"):
+		mode = "routing only"
+	case fl.Bool("stream"):
+		mode = "streams"
+	}
+	statuses := map[int]int{}
+	failed := 0
+	for _, s := range samples {
+		statuses[s.status]++
+		if s.status >= http.StatusBadRequest {
+			failed++
+		}
+	}
+	codes := make([]string, 0, len(statuses))
+	for status, n := range statuses {
+		codes = append(codes, fmt.Sprintf("%d×%d", n, status))
+	}
+	sort.Strings(codes)
+
+	latency := func(s benchSample) time.Duration { return s.latency }
+	firstByte := func(s benchSample) time.Duration { return s.firstByte }
+	out := os.Stdout
+	fmt.Fprintf(out, "%d requests (%s), %d at a time, in %s\n", len(samples), mode, min(fl.Int("concurrency"), len(samples)), elapsed.Round(time.Millisecond))
+	fmt.Fprintf(out, "  Statuses:     %s\n", strings.Join(codes, ", "))
+	fmt.Fprintf(out, "  Throughput:   %.1f req/s\n", float64(len(samples))/elapsed.Seconds())
+	fmt.Fprintf(out, "  Latency:      %s\n", benchPercentiles(samples, latency))
+	if fl.Bool("stream") && !fl.Bool("route-only") {
+		fmt.Fprintf(out, "  First byte:   %s\n", benchPercentiles(samples, firstByte))
+	}
+	fmt.Fprintf(out, "  Allocations:  %d allocs/req, %.1f KiB/req\n", allocs, float64(allocBytes)/1024)
+	return failed
+}
+
+func benchPercentiles(samples []benchSample, value func(benchSample) time.Duration) string {
+	parts := make([]string, 0, 4)
+	for _, p := range []struct {
+		name string
+		q    float64
+	}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}, {"max", 1}} {
+		parts = append(parts, fmt.Sprintf("%s %s", p.name, benchPercentile(samples, p.q, value).Round(time.Microsecond)))
+	}
+	return strings.Join(parts, "  ")
+}
+
+func benchPercentile(samples []benchSample, q float64, value func(benchSample) time.Duration) time.Duration {
+	values := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		values[i] = value(s)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values[int(q*float64(len(values)-1))]
+}
+
+// benchWriter discards a response, noting its status and when its first byte was written.
+type benchWriter struct {
+	header    http.Header
+	status    int
+	start     time.Time
+	firstByte time.Duration
+}
+
+func (w *benchWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *benchWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *benchWriter) Write(p []byte) (int, error) {
+	if w.firstByte == 0 {
+		w.firstByte = time.Since(w.start)
+	}
+	w.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
+
+func (w *benchWriter) Flush() {}
+
+func (w *benchWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+)
+
+// Allocations per request of the mock router's request path, with headroom for Go and
+// dependency upgrades. A change that raises them past these should say why.
+const (
+	maxRouteAllocs  = 600
+	maxChatAllocs   = 1000
+	maxStreamAllocs = 1700
+)
+
+// newBenchHandler loads the router of the bench command, one mock provider, as the command does.
+func newBenchHandler(tb testing.TB) (*ChatCompletionsHandler, caddycmd.Flags) {
+	tb.Helper()
+	fl := caddycmd.Flags{FlagSet: aiRouterBenchCommand().Flags()}
+	cfgJSON, err := adaptAIRouterFragment([]byte(benchConfig), "bench")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	handler, err := loadAIRouterTestHandler(fl, cfgJSON, true)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { caddy.Stop() })
+	return handler, fl
+}
+
+// benchRequestBody is a chat request to the mock model.
+func benchRequestBody(tb testing.TB, stream bool) []byte {
+	tb.Helper()
+	body, err := json.Marshal(map[string]any{
+		"model": "gpt-mock",
+		"messages": []map[string]any{
+			{"role": "system", "content": "You are a helpful assistant."},
+			{"role": "user", "content": "Hello"},
+		},
+		"stream": stream,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return body
+}
+
+// serveBenchRequest runs one request through the handler and fails unless it succeeds.
+func serveBenchRequest(tb testing.TB, handler *ChatCompletionsHandler, fl caddycmd.Flags, body []byte, routeOnly bool) {
+	r, err := newAIRouterTestRequest(fl, body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if routeOnly {
+		r.Header.Set(DebugHeader, debugRoute)
+	}
+	w := &benchWriter{header: http.Header{}, start: time.Now()}
+	if err := handler.ServeHTTP(w, r, noopHandler); err != nil {
+		tb.Fatal(err)
+	}
+	if status := w.statusCode(); status != http.StatusOK {
+		tb.Fatalf("status %d", status)
+	}
+}
+
+func benchmarkRequests(b *testing.B, stream, routeOnly bool) {
+	handler, fl := newBenchHandler(b)
+	body := benchRequestBody(b, stream)
+	serveBenchRequest(b, handler, fl, body, routeOnly)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		serveBenchRequest(b, handler, fl, body, routeOnly)
+	}
+}
+
+// BenchmarkRouteResolution stops at the routing decision, as a dry run does.
+func BenchmarkRouteResolution(b *testing.B) {
+	benchmarkRequests(b, false, true)
+}
+
+// BenchmarkChatCompletions proxies requests to the mock provider.
+func BenchmarkChatCompletions(b *testing.B) {
+	benchmarkRequests(b, false, false)
+}
+
+// BenchmarkChatCompletionsStream proxies streams from the mock provider.
+func BenchmarkChatCompletionsStream(b *testing.B) {
+	benchmarkRequests(b, true, false)
+}
+
+func TestRequestAllocationBaselines(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocation baselines skipped in short mode")
+	}
+	handler, fl := newBenchHandler(t)
+	for _, tc := range []struct {
+		name      string
+		stream    bool
+		routeOnly bool
+		max       float64
+	}{
+		{"route", false, true, maxRouteAllocs},
+		{"chat", false, false, maxChatAllocs},
+		{"stream", true, false, maxStreamAllocs},
+	} {
+		body := benchRequestBody(t, tc.stream)
+		serveBenchRequest(t, handler, fl, body, tc.routeOnly)
+		allocs := testing.AllocsPerRun(50, func() {
+			serveBenchRequest(t, handler, fl, body, tc.routeOnly)
+		})
+		t.Logf("%s: %.0f allocs/request", tc.name, allocs)
+		if allocs > tc.max {
+			t.Errorf("%s: %.0f allocs/request exceeds the baseline of %.0f", tc.name, allocs, tc.max)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// Canary arms recorded in the X-AI-Canary header, observability events and canary stats.
+const (
+	CanaryArmStable = "stable"
+	CanaryArmCanary = "canary"
+)
+
+const CanaryHeader = "X-AI-Canary"
+
+// CanaryContextKeyString carries the canaryAssignment of an inference request.
+const CanaryContextKeyString string = "ai_canary"
+
+// Canary states set through the admin API; without one the configured percent applies.
+const (
+	CanaryStatePromoted   = "promoted"    // Every request goes to the target
+	CanaryStateRolledBack = "rolled_back" // No request goes to the target
+)
+
+// canaryStatsRetention is how long canary stats are kept after the last request.
+const canaryStatsRetention = 30 * 24 * time.Hour
+
+// Canary sends a percentage of the requests for a model to a new version of it (optionally
+// with a "provider/model" prefix), and keeps latency and usage stats per arm so the two can be
+// compared before the canary is promoted or rolled back through the admin API. Unlike an
+// experiment, requests are split at random rather than by user.
+type Canary struct {
+	Name string `json:"name"`
+	// Requested model the canary takes traffic from
+	Model string `json:"model"`
+	// Model the canary share of the traffic goes to
+	Target string `json:"target"`
+	// Share of requests, 0-100, sent to the target
+	Percent float64 `json:"percent"`
+}
+
+// canaryAssignment is the arm a request was served by.
+type canaryAssignment struct {
+	canary *Canary
+	arm    string
+}
+
+func (c *Canary) validate() error {
+	if c.Model == "" || c.Target == "" {
+		return fmt.Errorf("canary %s: model and target are required", c.Name)
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return fmt.Errorf("canary %s: percent must be between 0 and 100, got %v", c.Name, c.Percent)
+	}
+	return nil
+}
+
+func (cr *AICoreRouter) canaryStateKey(c *Canary) string {
+	return cr.storeKey("canary", c.Name, "state")
+}
+
+func (cr *AICoreRouter) canaryStatsKey(c *Canary) string {
+	return cr.storeKey("canary", c.Name, "stats")
+}
+
+// canaryStateCacheTTL is how long a canary's state is reused before the store is read again, as
+// every request for the canary's model checks it. Changes made through another instance's admin
+// API take this long to apply here.
+const canaryStateCacheTTL = time.Second
+
+// canaryStateCache holds recently read canary states by canary name.
+type canaryStateCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedCanaryState
+}
+
+type cachedCanaryState struct {
+	state     string
+	expiresAt time.Time
+}
+
+func (c *canaryStateCache) get(name string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	return entry.state, ok && now.Before(entry.expiresAt)
+}
+
+func (c *canaryStateCache) put(name string, entry cachedCanaryState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedCanaryState)
+	}
+	c.entries[name] = entry
+}
+
+func (c *canaryStateCache) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// canaryState returns the state set through the admin API, or "" if the configured percent applies.
+func (cr *AICoreRouter) canaryState(ctx context.Context, c *Canary) string {
+	now := time.Now()
+	if state, ok := cr.canaryCache.get(c.Name, now); ok {
+		return state
+	}
+	state := cr.loadCanaryState(ctx, c)
+	cr.canaryCache.put(c.Name, cachedCanaryState{state: state, expiresAt: now.Add(canaryStateCacheTTL)})
+	return state
+}
+
+// loadCanaryState reads a canary's state from the router store.
+func (cr *AICoreRouter) loadCanaryState(ctx context.Context, c *Canary) string {
+	raw, ok, err := cr.store.Get(ctx, cr.canaryStateKey(c))
+	if err != nil {
+		cr.requestLogger(ctx).Warn("Failed to check canary state", zap.Error(err), zap.String("canary", c.Name))
+		return ""
+	}
+	if !ok {
+		return ""
+	}
+	return string(raw)
+}
+
+// setCanaryState promotes or rolls back a canary on every instance sharing the router store;
+// an empty state returns it to the configured percent.
+func (cr *AICoreRouter) setCanaryState(ctx context.Context, c *Canary, state string) error {
+	defer cr.canaryCache.forget(c.Name)
+	if state == "" {
+		return cr.store.Delete(ctx, cr.canaryStateKey(c))
+	}
+	return cr.store.Set(ctx, cr.canaryStateKey(c), []byte(state), 0)
+}
+
+// canaryPercent is the share of requests a canary currently sends to its target.
+func (cr *AICoreRouter) canaryPercent(ctx context.Context, c *Canary) float64 {
+	switch cr.canaryState(ctx, c) {
+	case CanaryStatePromoted:
+		return 100
+	case CanaryStateRolledBack:
+		return 0
+	}
+	return c.Percent
+}
+
+// assignCanary returns the arm of the first canary for the requested model, picked at random.
+func (cr *AICoreRouter) assignCanary(ctx context.Context, requestedModel string) *canaryAssignment {
+	for _, c := range cr.Canaries {
+		if !strings.EqualFold(c.Model, requestedModel) {
+			continue
+		}
+		arm := CanaryArmStable
+		if rand.Float64()*100 < cr.canaryPercent(ctx, c) {
+			arm = CanaryArmCanary
+		}
+		return &canaryAssignment{canary: c, arm: arm}
+	}
+	return nil
+}
+
+func canaryFrom(ctx context.Context) *canaryAssignment {
+	a, _ := ctx.Value(CanaryContextKeyString).(*canaryAssignment)
+	return a
+}
+
+// headerValue renders the assignment for the X-AI-Canary header.
+func (a *canaryAssignment) headerValue() string {
+	return a.canary.Name + "; arm=" + a.arm
+}
+
+// observabilityProps adds the assignment to observability event properties.
+func (a *canaryAssignment) observabilityProps(props map[string]any) map[string]any {
+	if a != nil {
+		props["canary"] = a.canary.Name
+		props["canary_arm"] = a.arm
+	}
+	return props
+}
+
+// Canary stats kept per arm.
+const (
+	canaryMetricRequests         = "requests"
+	canaryMetricErrors           = "errors"
+	canaryMetricLatencyMillis    = "latency_ms" // Sum over requests
+	canaryMetricPromptTokens     = "prompt_tokens"
+	canaryMetricCompletionTokens = "completion_tokens"
+	canaryMetricCostMicros       = "cost_micros"
+)
+
+// recordCanary adds a finished request to the stats of the arm that served it.
+func (cr *AICoreRouter) recordCanary(ctx context.Context, a *canaryAssignment, record usageRecord) {
+	ctx = context.WithoutCancel(ctx)
+	metrics := map[string]int64{
+		canaryMetricRequests:         1,
+		canaryMetricLatencyMillis:    record.Latency.Milliseconds(),
+		canaryMetricPromptTokens:     int64(record.PromptTokens),
+		canaryMetricCompletionTokens: int64(record.CompletionTokens),
+	}
+	if record.Failed {
+		metrics[canaryMetricErrors] = 1
+	}
+	if record.Cost != nil {
+		metrics[canaryMetricCostMicros] = int64(math.Round(*record.Cost * 1e6))
+	}
+	deltas := make(map[string]int64, len(metrics))
+	for metric, delta := range metrics {
+		if delta != 0 {
+			deltas[a.arm+":"+metric] = delta
+		}
+	}
+	if err := cr.store.HIncrByFields(ctx, cr.canaryStatsKey(a.canary), deltas, canaryStatsRetention); err != nil {
+		cr.logger.Warn("Failed to record canary stats", zap.String("canary", a.canary.Name), zap.Error(err))
+	}
+}
+
+// canaryArmStats compares the arms of a canary.
+type canaryArmStats struct {
+	Model               string  `json:"model"`
+	Requests            int64   `json:"requests"`
+	Errors              int64   `json:"errors"`
+	ErrorRate           float64 `json:"error_rate"`
+	AvgLatencyMillis    float64 `json:"avg_latency_ms"`
+	PromptTokens        int64   `json:"prompt_tokens"`
+	CompletionTokens    int64   `json:"completion_tokens"`
+	AvgCompletionTokens float64 `json:"avg_completion_tokens"`
+	Cost                float64 `json:"cost_usd"`
+	AvgCostPerRequest   float64 `json:"avg_cost_usd"`
+}
+
+// canaryStatus is a canary's state and stats as the admin API reports them.
+type canaryStatus struct {
+	Name    string                    `json:"name"`
+	Model   string                    `json:"model"`
+	Target  string                    `json:"target"`
+	Percent float64                   `json:"percent"` // In effect
+	State   string                    `json:"state"`   // configured, promoted or rolled_back
+	Arms    map[string]canaryArmStats `json:"arms"`
+}
+
+func (cr *AICoreRouter) canaryStatus(ctx context.Context, c *Canary) (canaryStatus, error) {
+	status := canaryStatus{
+		Name:    c.Name,
+		Model:   c.Model,
+		Target:  c.Target,
+		Percent: cr.canaryPercent(ctx, c),
+		State:   cr.canaryState(ctx, c),
+		Arms: map[string]canaryArmStats{
+			CanaryArmStable: {Model: c.Model},
+			CanaryArmCanary: {Model: c.Target},
+		},
+	}
+	if status.State == "" {
+		status.State = "configured"
+	}
+	fields, err := cr.store.HGetAll(ctx, cr.canaryStatsKey(c))
+	if err != nil {
+		return status, fmt.Errorf("loading stats of canary %s: %w", c.Name, err)
+	}
+	latency := make(map[string]int64)
+	for field, value := range fields {
+		arm, metric, _ := strings.Cut(field, ":")
+		stats, ok := status.Arms[arm]
+		if !ok {
+			continue
+		}
+		switch metric {
+		case canaryMetricRequests:
+			stats.Requests = value
+		case canaryMetricErrors:
+			stats.Errors = value
+		case canaryMetricLatencyMillis:
+			latency[arm] = value
+		case canaryMetricPromptTokens:
+			stats.PromptTokens = value
+		case canaryMetricCompletionTokens:
+			stats.CompletionTokens = value
+		case canaryMetricCostMicros:
+			stats.Cost = float64(value) / 1e6
+		}
+		status.Arms[arm] = stats
+	}
+	for arm, stats := range status.Arms {
+		if stats.Requests > 0 {
+			n := float64(stats.Requests)
+			stats.ErrorRate = float64(stats.Errors) / n
+			stats.AvgLatencyMillis = float64(latency[arm]) / n
+			stats.AvgCompletionTokens = float64(stats.CompletionTokens) / n
+			stats.AvgCostPerRequest = stats.Cost / n
+		}
+		status.Arms[arm] = stats
+	}
+	return status, nil
+}
+
+// resetCanary returns a canary to its configured percent and drops its stats.
+func (cr *AICoreRouter) resetCanary(ctx context.Context, c *Canary) error {
+	if err := cr.setCanaryState(ctx, c, ""); err != nil {
+		return err
+	}
+	return cr.store.Delete(ctx, cr.canaryStatsKey(c))
+}
+
+// logCanaryState records a promotion, roll back or reset made through the admin API.
+func (cr *AICoreRouter) logCanaryState(c *Canary, state string) {
+	if state == "" {
+		state = "configured"
+	}
+	cr.logger.Info("Canary state changed", zap.String("canary", c.Name), zap.String("model", c.Model), zap.String("target", c.Target), zap.String("state", state))
+}
+
+func (cr *AICoreRouter) canaryNamed(name string) *Canary {
+	for _, c := range cr.Canaries {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// parseCanaryCaddyfile parses a `canary <name> { ... }` block.
+func parseCanaryCaddyfile(d *caddyfile.Dispenser) (*Canary, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	c := &Canary{Name: d.Val()}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "model", "target":
+			option := d.Val()
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			if option == "model" {
+				c.Model = d.Val()
+			} else {
+				c.Target = d.Val()
+			}
+		case "percent":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(d.Val(), "%"), 64)
+			if err != nil {
+				return nil, d.Errf("canary %s: invalid percent '%s'", c.Name, d.Val())
+			}
+			c.Percent = percent
+		default:
+			return nil, d.Errf("unrecognized canary option '%s'", d.Val())
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return c, nil
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"go.uber.org/zap"
+)
+
+// Model capabilities tracked by the capability matrix and listed by the models endpoint.
+const (
+	CapabilityVision    = "vision"
+	CapabilityAudio     = "audio"
+	CapabilityTools     = "tools"
+	CapabilityJSONMode  = "json_mode"
+	CapabilityReasoning = "reasoning"
+	CapabilityStreaming = "streaming"
+	// CapabilityContext is missing when the prompt doesn't fit the model's context window.
+	CapabilityContext = "context_length"
+)
+
+// Capability check modes for requests that need a capability the resolved model lacks.
+const (
+	CapabilityCheckReroute = "reroute"
+	CapabilityCheckReject  = "reject"
+	CapabilityCheckOff     = "off"
+)
+
+func (cr *AICoreRouter) capabilityCheck() string {
+	if cr.CapabilityCheck == "" {
+		return CapabilityCheckReroute
+	}
+	return cr.CapabilityCheck
+}
+
+func validateCapabilityCheck(mode string) error {
+	switch mode {
+	case "", CapabilityCheckReroute, CapabilityCheckReject, CapabilityCheckOff:
+		return nil
+	}
+	return fmt.Errorf("unknown capability_check '%s' (expected reroute, reject or off)", mode)
+}
+
+// requiredCapabilities lists the capabilities a chat completion request relies on.
+func requiredCapabilities(body []byte) []string {
+	var probe struct {
+		Stream         bool              `json:"stream"`
+		Tools          []json.RawMessage `json:"tools"`
+		Functions      []json.RawMessage `json:"functions"`
+		ResponseFormat *struct {
+			Type string `json:"type"`
+		} `json:"response_format"`
+		Messages []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil
+	}
+
+	var required []string
+	if probe.Stream {
+		required = append(required, CapabilityStreaming)
+	}
+	if len(probe.Tools) > 0 || len(probe.Functions) > 0 {
+		required = append(required, CapabilityTools)
+	}
+	if probe.ResponseFormat != nil && (probe.ResponseFormat.Type == "json_object" || probe.ResponseFormat.Type == "json_schema") {
+		required = append(required, CapabilityJSONMode)
+	}
+	for _, msg := range probe.Messages {
+		var parts []struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(msg.Content, &parts) != nil {
+			continue // Plain string content
+		}
+		for _, part := range parts {
+			switch part.Type {
+			case "image_url", "image":
+				if !contains(required, CapabilityVision) {
+					required = append(required, CapabilityVision)
+				}
+			case "input_audio":
+				if !contains(required, CapabilityAudio) {
+					required = append(required, CapabilityAudio)
+				}
+			}
+		}
+	}
+	return required
+}
+
+// missingCapabilities returns the required capabilities a model's metadata rules out.
+// Capabilities the metadata says nothing about are assumed to be supported, so models
+// from providers with bare model lists are never rejected.
+func missingCapabilities(model map[string]any, required []string, promptTokens int) []string {
+	info, ok := normalizeProviderModel(model)
+	if !ok {
+		return nil
+	}
+	declared := declaresCapabilities(model)
+	_, hasParameters := model["supported_parameters"]
+	hasModalities := model["input_modalities"] != nil
+	if architecture, ok := model["architecture"].(map[string]any); ok && architecture["input_modalities"] != nil {
+		hasModalities = true
+	}
+
+	var missing []string
+	for _, capability := range required {
+		known := declared
+		switch capability {
+		case CapabilityTools, CapabilityJSONMode:
+			known = known || hasParameters
+		case CapabilityVision, CapabilityAudio:
+			known = known || hasModalities
+		}
+		if known && !contains(info.Capabilities, capability) {
+			missing = append(missing, capability)
+		}
+	}
+	if info.ContextLength > 0 && promptTokens > info.ContextLength {
+		missing = append(missing, CapabilityContext)
+	}
+	return missing
+}
+
+// findModel returns the metadata of a model in a provider's (cached) model list.
+func (cr *AICoreRouter) findModel(p *ProviderConfig, apiKey string, modelName string) (map[string]any, bool) {
+	models, err := cr.modelsCache.Get(p, apiKey)
+	if err != nil {
+		return nil, false
+	}
+	for _, model := range models {
+		if info, ok := normalizeProviderModel(model); ok && info.ID == modelName {
+			return model, true
+		}
+	}
+	return nil, false
+}
+
+// checkCapabilities makes sure the resolved model supports what the request uses. In reroute
+// mode a request that wasn't pinned to a provider by prefix moves to another provider serving
+// the same model with the needed capabilities; otherwise it is rejected with a 400.
+func (cr *AICoreRouter) checkCapabilities(w http.ResponseWriter, r *http.Request, requestedModel, providerName, actualModelName string, body []byte, apiKeyService auth.ExternalAPIKeyProvider, userID string) (string, error) {
+	logger := cr.requestLogger(r.Context())
+	mode := cr.capabilityCheck()
+	if mode == CapabilityCheckOff {
+		return providerName, nil
+	}
+	required := requiredCapabilities(body)
+	promptTokens := cr.requestPromptTokens(body)
+
+	cr.mu.RLock()
+	providerConfigs := make(map[string]*ProviderConfig, len(cr.Providers))
+	for name, p := range cr.Providers {
+		providerConfigs[name] = p
+	}
+	candidates := cr.routeModel(r, requestedModel).providers
+	if len(candidates) == 0 {
+		candidates = cr.ProviderOrder
+	}
+	cr.mu.RUnlock()
+
+	missingOn := func(p *ProviderConfig) ([]string, bool) {
+		apiKey, err := discoveryAPIKey(apiKeyService, p, userID)
+		if err != nil {
+			return nil, false
+		}
+		model, ok := cr.findModel(p, apiKey, actualModelName)
+		if !ok {
+			return nil, false
+		}
+		return missingCapabilities(model, required, promptTokens), true
+	}
+
+	missing, _ := missingOn(providerConfigs[providerName])
+	if len(missing) == 0 {
+		return providerName, nil
+	}
+
+	pinned := strings.HasPrefix(strings.ToLower(requestedModel), providerName+"/") || cr.pinnedProvider(r) == providerName
+	if mode == CapabilityCheckReroute && !pinned {
+		for _, candidate := range candidates {
+			p, ok := providerConfigs[candidate]
+			if !ok || candidate == providerName || !cr.modelAllowed(p, actualModelName) || cr.inMaintenance(r.Context(), p) || cr.coolingDown(r.Context(), candidate, actualModelName) {
+				continue
+			}
+			if candidateMissing, found := missingOn(p); found && len(candidateMissing) == 0 {
+				logger.Info("Rerouting request to a provider supporting its capabilities",
+					zap.String("model", actualModelName),
+					zap.String("from_provider", providerName),
+					zap.String("to_provider", candidate),
+					zap.Strings("capabilities", missing),
+				)
+				return candidate, nil
+			}
+		}
+	}
+
+	writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "unsupported_capability",
+		fmt.Sprintf("Model %s on provider %s does not support: %s", actualModelName, providerName, strings.Join(missing, ", ")))
+	return "", fmt.Errorf("model %s on provider %s does not support %s", actualModelName, providerName, strings.Join(missing, ", "))
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"go.uber.org/zap"
+)
+
+// A cassette is a directory of recorded upstream interactions, one JSON file each. A provider
+// with `record <dir>` saves what it sends and receives there; one with `replay <dir>` answers
+// from the recordings instead of contacting the upstream, so the whole pipeline can be tested
+// hermetically and a bug report can ship with the exchanges that reproduce it.
+
+// cassetteInteraction is the file a request and its response are recorded in.
+type cassetteInteraction struct {
+	Request  cassetteMessage `json:"request"`
+	Response cassetteMessage `json:"response"`
+}
+
+// cassetteMessage holds its body in one of three forms: as JSON when it is JSON, as text
+// (e.g. SSE streams), or base64 encoded when it is binary.
+type cassetteMessage struct {
+	Method     string          `json:"method,omitempty"`
+	URL        string          `json:"url,omitempty"`
+	Status     int             `json:"status,omitempty"`
+	Header     http.Header     `json:"header,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	BodyText   string          `json:"body_text,omitempty"`
+	BodyBase64 string          `json:"body_base64,omitempty"`
+}
+
+// redactedValue replaces credentials in recordings.
+const redactedValue = "REDACTED"
+
+// validateCassette checks a provider's record and replay options and prepares the directory.
+func validateCassette(p *ProviderConfig) error {
+	switch {
+	case p.Record != "" && p.Replay != "":
+		return fmt.Errorf("record and replay are mutually exclusive")
+	case p.Record != "":
+		if err := os.MkdirAll(p.Record, 0o755); err != nil {
+			return fmt.Errorf("record: %v", err)
+		}
+	case p.Replay != "":
+		if info, err := os.Stat(p.Replay); err != nil || !info.IsDir() {
+			return fmt.Errorf("replay: '%s' is not a directory of recordings", p.Replay)
+		}
+	}
+	return nil
+}
+
+// useCassette records the provider's upstream interactions, or replays them, including model
+// discovery.
+func (cr *AICoreRouter) useCassette(p *ProviderConfig) {
+	base := p.proxy.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &cassetteTransport{router: cr, provider: p, base: base, dir: p.Record, secretHeaders: cassetteSecretHeaders(p)}
+	if p.Replay != "" {
+		t.dir, t.replay = p.Replay, true
+		cr.logger.Warn("Replaying recorded upstream interactions", zap.String("provider", p.Name), zap.String("dir", p.Replay))
+	} else {
+		cr.logger.Warn("Recording upstream interactions", zap.String("provider", p.Name), zap.String("dir", p.Record))
+	}
+	p.proxy.Transport = t
+	p.client = &http.Client{Timeout: cr.httpClient.Timeout, Transport: t}
+}
+
+type cassetteTransport struct {
+	router        *AICoreRouter
+	provider      *ProviderConfig
+	base          http.RoundTripper
+	dir           string
+	replay        bool
+	secretHeaders map[string]bool // Lowercase names of the headers the provider is configured to send
+}
+
+// cassetteSecretHeaders names the headers a provider adds with extra_headers or header_up. They
+// are how custom credentials are usually sent, so their values are never recorded.
+func cassetteSecretHeaders(p *ProviderConfig) map[string]bool {
+	secret := make(map[string]bool)
+	for name := range p.ExtraHeaders {
+		secret[strings.ToLower(name)] = true
+	}
+	if ops := p.HeadersUp; ops != nil {
+		for _, header := range []http.Header{ops.Add, ops.Set} {
+			for name := range header {
+				secret[strings.ToLower(name)] = true
+			}
+		}
+		for name := range ops.Replace {
+			secret[strings.ToLower(name)] = true
+		}
+	}
+	return secret
+}
+
+func (t *cassetteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	path := filepath.Join(t.dir, cassetteKey(r, body)+".json")
+	logger := t.router.requestLogger(r.Context())
+
+	if t.replay {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("replay: no recording of %s %s in %s", r.Method, r.URL.Path, t.dir)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("replay: %v", err)
+		}
+		var interaction cassetteInteraction
+		if err := json.Unmarshal(data, &interaction); err != nil {
+			return nil, fmt.Errorf("replay: %s: %v", path, err)
+		}
+		respBody, err := interaction.Response.body()
+		if err != nil {
+			return nil, fmt.Errorf("replay: %s: %v", path, err)
+		}
+		logger.Debug("Replayed upstream interaction", zap.String("provider", t.provider.Name), zap.String("file", path))
+		header := interaction.Response.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("Content-Length", strconv.Itoa(len(respBody)))
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+			StatusCode:    interaction.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(respBody)),
+			ContentLength: int64(len(respBody)),
+			Request:       r,
+		}, nil
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	interaction := cassetteInteraction{
+		Request:  cassetteMessage{Method: r.Method, URL: sanitizeCassetteURL(r.URL), Header: t.sanitizeHeader(r.Header)},
+		Response: cassetteMessage{Status: resp.StatusCode, Header: t.sanitizeHeader(resp.Header)},
+	}
+	interaction.Request.setBody(body)
+	interaction.Response.Header.Del("Content-Length")
+	// The response is saved once it has been read to the end, so streams still stream
+	resp.Body = &recordingBody{ReadCloser: resp.Body, done: func(respBody []byte) {
+		interaction.Response.setBody(respBody)
+		data, err := json.MarshalIndent(interaction, "", "  ")
+		if err == nil {
+			err = os.WriteFile(path, append(data, '\n'), 0o600)
+		}
+		if err != nil {
+			logger.Error("Failed to record upstream interaction", zap.String("provider", t.provider.Name), zap.Error(err))
+			return
+		}
+		logger.Debug("Recorded upstream interaction", zap.String("provider", t.provider.Name), zap.String("file", path))
+	}}
+	return resp, nil
+}
+
+// recordingBody keeps a copy of what is read and hands it to done at EOF. A body closed early
+// isn't recorded, as it would replay as a truncated response.
+type recordingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done func([]byte)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
+
+// cassetteKey identifies a request by its method, path, query and body, so a replayed request
+// finds the recording of the same request. JSON bodies are compared with their keys sorted.
+func cassetteKey(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, sanitizeCassetteQuery(r.URL.Query()))
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if dec.Decode(&v) == nil {
+		canonical, _ := json.Marshal(v)
+		h.Write(canonical)
+	} else {
+		h.Write(body)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))[:16]
+	return strings.ToLower(r.Method) + "-" + strings.Trim(strings.ReplaceAll(r.URL.Path, "/", "_"), "_") + "-" + sum
+}
+
+// sanitizeHeader replaces the values of credential headers, and credentials recognized in others.
+func (t *cassetteTransport) sanitizeHeader(header http.Header) http.Header {
+	sanitized := header.Clone()
+	for name, values := range sanitized {
+		if common.IsSecretKey(name) || t.secretHeaders[strings.ToLower(name)] {
+			sanitized[name] = []string{redactedValue}
+			continue
+		}
+		for i, value := range values {
+			values[i] = common.RedactSecrets(value)
+		}
+	}
+	return sanitized
+}
+
+// sanitizeCassetteQuery encodes a query with its credentials redacted and its keys sorted.
+func sanitizeCassetteQuery(query url.Values) string {
+	return strings.TrimPrefix(common.RedactSecrets("?"+query.Encode()), "?")
+}
+
+func sanitizeCassetteURL(u *url.URL) string {
+	sanitized := *u
+	sanitized.User = nil
+	sanitized.RawQuery = sanitizeCassetteQuery(u.Query())
+	return sanitized.String()
+}
+
+// setBody stores a body with the credentials recognized in it redacted, unless it is binary.
+func (m *cassetteMessage) setBody(body []byte) {
+	switch {
+	case len(body) == 0:
+	case json.Valid(body):
+		m.Body = json.RawMessage(common.RedactSecrets(string(body)))
+	case utf8.Valid(body):
+		m.BodyText = common.RedactSecrets(string(body))
+	default:
+		m.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+}
+
+func (m *cassetteMessage) body() ([]byte, error) {
+	switch {
+	case len(m.Body) > 0:
+		return m.Body, nil
+	case m.BodyBase64 != "":
+		return base64.StdEncoding.DecodeString(m.BodyBase64)
+	}
+	return []byte(m.BodyText), nil
+}
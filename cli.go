@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "ai-router",
+		Short: "Tools for authoring AI router configs",
+		CobraFunc: func(cmd *cobra.Command) {
+			cmd.AddCommand(aiRouterTestCommand())
+			cmd.AddCommand(aiRouterBenchCommand())
+		},
+	})
+}
+
+func aiRouterTestCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test [--config <path>] [--adapter <name>] [--router <name>] (--request <file> | --model <model> [--message <text>]) [--header <name: value>] [--discover | --live]",
+		Short: "Shows how a router would handle a sample chat completions request",
+		Long: `
+Loads the routers of a config without starting any server, runs a sample chat
+completions request through them and prints the routing decision: the routed
+and actual model, the provider, the upstream URL and the body as it would be
+sent upstream.
+
+The config may be a full Caddyfile or a fragment of ai_router blocks. Options
+of the first ai_chat_completions handler (defaults, system prompt, limits)
+apply to the request. The request comes from --request (a JSON file, or - for
+stdin) or is built from --model and --message.
+
+By default nothing leaves the machine: provider model lists are not fetched,
+so models are matched against static manifests only, and router state lives
+in memory. --discover fetches model lists from the providers; --live also
+sends the request and prints the provider's response.
+`,
+		RunE:         caddycmd.WrapCommandFuncForCobra(cmdAIRouterTest),
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringP("config", "c", "", "Config file or fragment (default: ./Caddyfile)")
+	cmd.Flags().StringP("adapter", "a", "", "Name of config adapter")
+	cmd.Flags().StringP("router", "r", "", "Router to test (default: the ai_chat_completions handler's, or default)")
+	cmd.Flags().String("request", "", "JSON request body file, or - for stdin")
+	cmd.Flags().StringP("model", "m", "", "Model of the sample request")
+	cmd.Flags().String("message", "Hello", "User message of the sample request")
+	cmd.Flags().Bool("stream", false, "Make the sample request a stream")
+	cmd.Flags().StringArrayP("header", "H", nil, "Request header, e.g. 'X-AI-Provider: openai' (repeatable)")
+	cmd.Flags().String("path", "/v1/chat/completions", "Request path")
+	cmd.Flags().Bool("discover", false, "Fetch model lists from the providers")
+	cmd.Flags().Bool("live", false, "Send the request to the provider and print its response")
+	cmd.Flags().String("log-level", "WARN", "Log level of the routers")
+	return cmd
+}
+
+func cmdAIRouterTest(fl caddycmd.Flags) (int, error) {
+	live := fl.Bool("live")
+	offline := !live && !fl.Bool("discover")
+
+	body, err := aiRouterTestRequest(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	cfgJSON, err := loadAIRouterTestConfig(fl.String("config"), fl.String("adapter"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	handler, err := loadAIRouterTestHandler(fl, cfgJSON, offline)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer caddy.Stop()
+
+	r, err := newAIRouterTestRequest(fl, body)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	if !live {
+		r.Header.Set(DebugHeader, debugRoute)
+	}
+
+	w := httptest.NewRecorder()
+	if err := handler.ServeHTTP(w, r, noopHandler); err != nil && w.Code < http.StatusBadRequest {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	printAIRouterTestResponse(os.Stdout, w, live)
+	if w.Code >= http.StatusBadRequest {
+		return caddy.ExitCodeFailedQuit, fmt.Errorf("request failed with status %d", w.Code)
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+var noopHandler = caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error { return nil })
+
+// loadAIRouterTestHandler loads the routers of a config with only the ai_router app: no
+// listeners, no admin endpoint. The caller stops Caddy when done.
+func loadAIRouterTestHandler(fl caddycmd.Flags, cfgJSON []byte, offline bool) (*ChatCompletionsHandler, error) {
+	routers, handler, err := aiRouterTestModules(cfgJSON, offline)
+	if err != nil {
+		return nil, err
+	}
+	if name := fl.String("router"); name != "" {
+		handler.Router = name
+	}
+
+	testCfg, err := json.Marshal(map[string]any{
+		"admin":   map[string]any{"disabled": true},
+		"logging": map[string]any{"logs": map[string]any{"default": map[string]any{"level": fl.String("log-level")}}},
+		"apps":    map[string]any{"ai_router": map[string]any{"routers": routers}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := caddy.Load(testCfg, true); err != nil {
+		return nil, fmt.Errorf("loading routers: %v", err)
+	}
+	if err := handler.Provision(caddy.ActiveContext()); err != nil {
+		caddy.Stop()
+		return nil, fmt.Errorf("ai_chat_completions: %v", err)
+	}
+	if offline {
+		for _, cr := range handler.routers.routers {
+			cr.httpClient.Transport = offlineTransport{}
+		}
+	}
+	return handler, nil
+}
+
+// newAIRouterTestRequest builds a sample request as the handler gets it from Caddy.
+func newAIRouterTestRequest(fl caddycmd.Flags, body []byte) (*http.Request, error) {
+	ctx := context.WithValue(context.Background(), caddyhttp.VarsCtxKey, make(map[string]any))
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fl.String("path"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.RemoteAddr = "127.0.0.1:0"
+	r.Header.Set("Content-Type", "application/json")
+	headers, _ := fl.GetStringArray("header")
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header '%s', expected 'Name: value'", header)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Host") {
+			r.Host = strings.TrimSpace(value)
+			continue
+		}
+		r.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	caddyhttp.NewTestReplacer(r)
+	return r, nil
+}
+
+// aiRouterTestRequest reads the sample request body, or builds one from --model and --message.
+func aiRouterTestRequest(fl caddycmd.Flags) ([]byte, error) {
+	switch path := fl.String("request"); path {
+	case "":
+	case "-":
+		return io.ReadAll(os.Stdin)
+	default:
+		return os.ReadFile(path)
+	}
+	if fl.String("model") == "" {
+		return nil, fmt.Errorf("a sample request is required: use --request or --model")
+	}
+	return json.Marshal(map[string]any{
+		"model":    fl.String("model"),
+		"messages": []map[string]any{{"role": "user", "content": fl.String("message")}},
+		"stream":   fl.Bool("stream"),
+	})
+}
+
+// loadAIRouterTestConfig adapts the config to JSON. A Caddyfile made of ai_router blocks only
+// is read as global options, which take the same syntax.
+func loadAIRouterTestConfig(path, adapterName string) ([]byte, error) {
+	if path != "" && path != "-" && (adapterName == "" || adapterName == "caddyfile") {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %v", err)
+		}
+		if tokens, err := caddyfile.Tokenize(body, path); err == nil && len(tokens) > 0 && tokens[0].Text == "ai_router" {
+			return adaptAIRouterFragment(body, path)
+		}
+	}
+	cfgJSON, _, err := caddycmd.LoadConfig(path, adapterName)
+	if err == nil && len(cfgJSON) == 0 {
+		err = fmt.Errorf("no config file given and no Caddyfile in the current directory")
+	}
+	return cfgJSON, err
+}
+
+// adaptAIRouterFragment adapts ai_router blocks by reading them as global options.
+func adaptAIRouterFragment(body []byte, filename string) ([]byte, error) {
+	wrapped := caddyfile.Format(append(append([]byte("{\n"), body...), []byte("\n}\n")...))
+	cfgJSON, warnings, err := caddyconfig.GetAdapter("caddyfile").Adapt(wrapped, map[string]any{"filename": filename})
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "[WARNING]", warning.String())
+	}
+	return cfgJSON, err
+}
+
+// aiRouterTestModules collects the routers a config declares, globally or with ai_router
+// handlers, and its first ai_chat_completions handler. Routers keep their state in memory;
+// offline, they skip model discovery.
+func aiRouterTestModules(cfgJSON []byte, offline bool) ([]map[string]any, *ChatCompletionsHandler, error) {
+	var cfg struct {
+		Apps map[string]json.RawMessage `json:"apps"`
+	}
+	if err := json.Unmarshal(cfgJSON, &cfg); err != nil {
+		return nil, nil, err
+	}
+	var routers []map[string]any
+	var handlerCfg map[string]any
+	if raw, ok := cfg.Apps["ai_router"]; ok {
+		var app struct {
+			Routers []map[string]any `json:"routers"`
+		}
+		if err := json.Unmarshal(raw, &app); err != nil {
+			return nil, nil, err
+		}
+		routers = app.Routers
+	}
+	if raw, ok := cfg.Apps["http"]; ok {
+		var httpApp any
+		if err := json.Unmarshal(raw, &httpApp); err != nil {
+			return nil, nil, err
+		}
+		walkHandlers(httpApp, func(handler map[string]any) {
+			switch handler["handler"] {
+			case "ai_router":
+				delete(handler, "handler")
+				routers = append(routers, handler)
+			case "ai_chat_completions":
+				if handlerCfg == nil {
+					delete(handler, "handler")
+					handlerCfg = handler
+				}
+			}
+		})
+	}
+	if len(routers) == 0 {
+		return nil, nil, fmt.Errorf("the config declares no ai_router")
+	}
+	for _, router := range routers {
+		delete(router, "storage")
+		if providers, ok := router["providers"].(map[string]any); ok && offline {
+			for _, p := range providers {
+				if p, ok := p.(map[string]any); ok {
+					p["disable_models_discovery"] = true
+				}
+			}
+		}
+	}
+
+	handler := new(ChatCompletionsHandler)
+	if handlerCfg != nil {
+		raw, err := json.Marshal(handlerCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(raw, handler); err != nil {
+			return nil, nil, fmt.Errorf("ai_chat_completions: %v", err)
+		}
+	}
+	return routers, handler, nil
+}
+
+// walkHandlers calls fn for every handler object in a config tree, depth first.
+func walkHandlers(node any, fn func(map[string]any)) {
+	switch node := node.(type) {
+	case map[string]any:
+		if _, ok := node["handler"].(string); ok {
+			fn(node)
+		}
+		keys := make([]string, 0, len(node))
+		for key := range node {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys) // Servers are a map; keep "first handler" stable
+		for _, key := range keys {
+			walkHandlers(node[key], fn)
+		}
+	case []any:
+		for _, item := range node {
+			walkHandlers(item, fn)
+		}
+	}
+}
+
+// offlineTransport fails every request a router makes on its own, like model discovery.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return nil, errors.New("offline: use --discover or --live to contact providers")
+}
+
+// printAIRouterTestResponse prints the routing decision, or in live mode the response with
+// the router's X-AI-* headers.
+func printAIRouterTestResponse(out io.Writer, w *httptest.ResponseRecorder, live bool) {
+	body := w.Body.Bytes()
+	if live || w.Code != http.StatusOK {
+		fmt.Fprintf(out, "HTTP %d %s\n", w.Code, http.StatusText(w.Code))
+		names := make([]string, 0, len(w.Header()))
+		for name := range w.Header() {
+			if strings.HasPrefix(strings.ToLower(name), "x-ai-") || name == "Content-Type" || name == "Retry-After" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "%s: %s\n", name, strings.Join(w.Header()[name], ", "))
+		}
+		fmt.Fprintln(out)
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") == nil {
+		body = indented.Bytes()
+	}
+	out.Write(bytes.TrimRight(body, "\n"))
+	fmt.Fprintln(out)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultQueueTimeout = 30 * time.Second
+
+var (
+	errQueueFull    = errors.New("provider queue is full")
+	errQueueTimeout = errors.New("timed out waiting in provider queue")
+	errQueueShed    = errors.New("shed from provider queue for higher-priority traffic")
+)
+
+// concurrencyLimiter caps in-flight requests to a provider. Requests over the cap wait in a
+// bounded queue ordered by priority, first come first served within a priority; a freed slot
+// is handed directly to the first waiter. When the queue is full, a request of higher priority
+// than the last waiter takes its place and the last waiter is shed.
+type concurrencyLimiter struct {
+	max          int
+	queueSize    int
+	queueTimeout time.Duration
+
+	mu      sync.Mutex
+	active  int
+	waiters []*queuedRequest
+
+	inFlight   prometheus.Gauge
+	queueDepth prometheus.Gauge
+	wait       prometheus.ObserverVec
+}
+
+func newConcurrencyLimiter(routerName string, p *ProviderConfig) *concurrencyLimiter {
+	timeout := time.Duration(p.QueueTimeout)
+	if timeout <= 0 {
+		timeout = defaultQueueTimeout
+	}
+	labels := prometheus.Labels{"router": routerName, "provider": p.Name}
+	l := &concurrencyLimiter{
+		max:          p.MaxConcurrentRequests,
+		queueSize:    p.QueueSize,
+		queueTimeout: timeout,
+		inFlight:     providerInFlight.With(labels),
+		queueDepth:   providerQueueDepth.With(labels),
+		wait:         providerQueueWait.MustCurryWith(labels),
+	}
+	l.inFlight.Set(0)
+	l.queueDepth.Set(0)
+	return l
+}
+
+// queuedRequest is a request waiting for a slot.
+type queuedRequest struct {
+	priority int
+	ready    chan struct{} // Closed once the request got a slot or was shed
+	shed     bool          // Set before ready is closed
+}
+
+// acquire takes a slot, queueing with a priority if none is free. It returns how long the
+// request waited; on success the caller must call release.
+func (l *concurrencyLimiter) acquire(ctx context.Context, priority int) (time.Duration, error) {
+	l.mu.Lock()
+	if l.active < l.max && len(l.waiters) == 0 {
+		l.active++
+		l.inFlight.Set(float64(l.active))
+		l.mu.Unlock()
+		return 0, nil
+	}
+	if len(l.waiters) >= l.queueSize {
+		if len(l.waiters) == 0 || l.waiters[len(l.waiters)-1].priority >= priority {
+			l.mu.Unlock()
+			l.wait.WithLabelValues("rejected").Observe(0)
+			return 0, errQueueFull
+		}
+		last := l.waiters[len(l.waiters)-1]
+		l.waiters = l.waiters[:len(l.waiters)-1]
+		last.shed = true
+		close(last.ready)
+	}
+	q := &queuedRequest{priority: priority, ready: make(chan struct{})}
+	i := len(l.waiters)
+	for i > 0 && l.waiters[i-1].priority < priority {
+		i--
+	}
+	l.waiters = append(l.waiters, nil)
+	copy(l.waiters[i+1:], l.waiters[i:])
+	l.waiters[i] = q
+	l.queueDepth.Set(float64(len(l.waiters)))
+	l.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-q.ready:
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	waited := time.Since(start)
+
+	if err != nil && !l.dequeue(q) {
+		err = nil // The slot was handed over while we gave up; take it rather than leak it
+	}
+	if err == nil && q.shed {
+		l.wait.WithLabelValues("shed").Observe(waited.Seconds())
+		return waited, errQueueShed
+	}
+	if errors.Is(err, errQueueTimeout) {
+		l.wait.WithLabelValues("timeout").Observe(waited.Seconds())
+		return waited, err
+	}
+	if err != nil {
+		l.wait.WithLabelValues("cancelled").Observe(waited.Seconds()) // The client went away while queued
+		return waited, err
+	}
+	l.wait.WithLabelValues("acquired").Observe(waited.Seconds())
+	return waited, nil
+}
+
+// tryAcquire takes a slot only if one is free right away; on success the caller must call release.
+func (l *concurrencyLimiter) tryAcquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active >= l.max || len(l.waiters) > 0 {
+		return false
+	}
+	l.active++
+	l.inFlight.Set(float64(l.active))
+	return true
+}
+
+// dequeue removes a waiter that gave up. It returns false if the waiter already got a slot
+// or was shed.
+func (l *concurrencyLimiter) dequeue(q *queuedRequest) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.waiters {
+		if w == q {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			l.queueDepth.Set(float64(len(l.waiters)))
+			return true
+		}
+	}
+	return false
+}
+
+// release frees a slot, passing it to the first waiter if there is one.
+func (l *concurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.waiters) > 0 {
+		next := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.queueDepth.Set(float64(len(l.waiters)))
+		close(next.ready)
+		return
+	}
+	l.active--
+	l.inFlight.Set(float64(l.active))
+}
+
+// retryAfter suggests how many seconds a rejected client should wait before retrying.
+func (l *concurrencyLimiter) retryAfter() int {
+	seconds := int(l.queueTimeout / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// writeProviderOverloaded answers a request that got no slot at a provider.
+func writeProviderOverloaded(w http.ResponseWriter, p *ProviderConfig, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(p.limiter.retryAfter()))
+	if errors.Is(err, errQueueShed) {
+		writeOpenAIError(w, http.StatusTooManyRequests, ErrorTypeRateLimit, "request_shed",
+			fmt.Sprintf("Provider %s is busy with higher-priority traffic, please retry later", p.Name))
+		return
+	}
+	writeOpenAIError(w, http.StatusTooManyRequests, ErrorTypeRateLimit, "provider_overloaded",
+		fmt.Sprintf("Too many concurrent requests to provider %s, please retry later", p.Name))
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestLimiter returns a limiter whose metrics aren't shared with other tests.
+func newTestLimiter(t *testing.T, max, queueSize int, queueTimeout time.Duration) *concurrencyLimiter {
+	t.Helper()
+	return newConcurrencyLimiter(t.Name(), &ProviderConfig{
+		Name:                  "test",
+		MaxConcurrentRequests: max,
+		QueueSize:             queueSize,
+		QueueTimeout:          caddy.Duration(queueTimeout),
+	})
+}
+
+// queued returns how many requests wait in the limiter's queue.
+func (l *concurrencyLimiter) queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.waiters)
+}
+
+// acquireAsync starts acquiring a slot and returns the outcome once it is known.
+func acquireAsync(ctx context.Context, l *concurrencyLimiter, priority int) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.acquire(ctx, priority)
+		done <- err
+	}()
+	return done
+}
+
+// waitQueued waits until n requests are queued.
+func waitQueued(t *testing.T, l *concurrencyLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for l.queued() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", l.queued(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// assertIdle fails unless every slot is free and nobody waits.
+func assertIdle(t *testing.T, l *concurrencyLimiter) {
+	t.Helper()
+	l.mu.Lock()
+	active, queued := l.active, len(l.waiters)
+	l.mu.Unlock()
+	if active != 0 || queued != 0 {
+		t.Fatalf("%d slots taken and %d requests queued, want none", active, queued)
+	}
+}
+
+// waitCount is how many acquisitions ended with the outcome; the metric outlives the limiter.
+func waitCount(t *testing.T, l *concurrencyLimiter, outcome string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := l.wait.WithLabelValues(outcome).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestConcurrencyLimiterHandoverRacingTimeout(t *testing.T) {
+	l := newTestLimiter(t, 1, 1, time.Millisecond)
+	for i := 0; i < 200; i++ {
+		if _, err := l.acquire(context.Background(), 0); err != nil {
+			t.Fatal(err)
+		}
+		done := acquireAsync(context.Background(), l, 0)
+		time.Sleep(time.Duration(i%5) * time.Millisecond / 2) // Release around the queue timeout
+		l.release()
+		switch err := <-done; {
+		case err == nil:
+			l.release()
+		case !errors.Is(err, errQueueTimeout):
+			t.Fatalf("acquire: %v", err)
+		}
+		assertIdle(t, l)
+	}
+}
+
+func TestConcurrencyLimiterCancelledWhileQueued(t *testing.T) {
+	l := newTestLimiter(t, 1, 1, time.Minute)
+	cancelled, timedOut := waitCount(t, l, "cancelled"), waitCount(t, l, "timeout")
+	if _, err := l.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := acquireAsync(ctx, l, 0)
+	waitQueued(t, l, 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire: %v, want context.Canceled", err)
+	}
+	if n := waitCount(t, l, "cancelled") - cancelled; n != 1 {
+		t.Errorf("%d cancelled waits recorded, want 1", n)
+	}
+	if n := waitCount(t, l, "timeout") - timedOut; n != 0 {
+		t.Errorf("%d timed out waits recorded, want 0", n)
+	}
+	l.release()
+	assertIdle(t, l)
+}
+
+func TestConcurrencyLimiterShedsLowestPriority(t *testing.T) {
+	l := newTestLimiter(t, 1, 2, time.Minute)
+	if _, err := l.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	low := acquireAsync(context.Background(), l, 0)
+	waitQueued(t, l, 1)
+	mid := acquireAsync(context.Background(), l, 1)
+	waitQueued(t, l, 2)
+
+	// The queue is full: equal priority is turned away, higher priority sheds the lowest waiter
+	if _, err := l.acquire(context.Background(), 0); !errors.Is(err, errQueueFull) {
+		t.Fatalf("acquire at the lowest queued priority: %v, want errQueueFull", err)
+	}
+	high := acquireAsync(context.Background(), l, 2)
+	if err := <-low; !errors.Is(err, errQueueShed) {
+		t.Fatalf("lowest priority waiter: %v, want errQueueShed", err)
+	}
+	waitQueued(t, l, 2)
+
+	// Freed slots go to the highest priority first
+	l.release()
+	if err := <-high; err != nil {
+		t.Fatalf("highest priority waiter: %v", err)
+	}
+	select {
+	case err := <-mid:
+		t.Fatalf("lower priority waiter got through before a slot was freed: %v", err)
+	default:
+	}
+	l.release()
+	if err := <-mid; err != nil {
+		t.Fatalf("remaining waiter: %v", err)
+	}
+	l.release()
+	assertIdle(t, l)
+}
+
+func TestConcurrencyLimiterReleaseAfterShed(t *testing.T) {
+	l := newTestLimiter(t, 1, 1, time.Minute)
+	if _, err := l.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	low := acquireAsync(context.Background(), l, 0)
+	waitQueued(t, l, 1)
+	high := acquireAsync(context.Background(), l, 1)
+	if err := <-low; !errors.Is(err, errQueueShed) {
+		t.Fatalf("lowest priority waiter: %v, want errQueueShed", err)
+	}
+	waitQueued(t, l, 1)
+
+	// The shed waiter holds no slot, so the released one goes to the waiter that took its place
+	l.release()
+	if err := <-high; err != nil {
+		t.Fatalf("remaining waiter: %v", err)
+	}
+	l.release()
+	assertIdle(t, l)
+	if !l.tryAcquire() {
+		t.Fatal("no slot free after every request released")
+	}
+	l.release()
+	assertIdle(t, l)
+}
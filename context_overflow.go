@@ -0,0 +1,269 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/tokenizer"
+	"go.uber.org/zap"
+)
+
+// TruncatedMessagesHeader reports how many messages were dropped to fit the context window.
+const TruncatedMessagesHeader = "X-AI-Truncated-Messages"
+
+// Context overflow modes for prompts that don't fit the resolved model's context window.
+const (
+	ContextOverflowReject   = "reject"
+	ContextOverflowTruncate = "truncate"
+	ContextOverflowEscalate = "escalate"
+)
+
+// ContextOverflowConfig sets what happens when a prompt exceeds the resolved model's context
+// window, as known from discovered or manifest model metadata.
+type ContextOverflowConfig struct {
+	// reject, truncate (drop the oldest non-system messages) or escalate (to a larger model)
+	Mode string `json:"mode,omitempty"`
+	// Larger-context models to try in order, by requested model
+	Escalation map[string][]string `json:"escalation,omitempty"`
+	// Tokens kept free for the completion when the request doesn't set max_tokens
+	ReserveTokens int `json:"reserve_tokens,omitempty"`
+}
+
+func (c *ContextOverflowConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Mode {
+	case ContextOverflowReject, ContextOverflowTruncate, ContextOverflowEscalate:
+	default:
+		return fmt.Errorf("unknown context_overflow mode '%s' (expected reject, truncate or escalate)", c.Mode)
+	}
+	if c.Mode == ContextOverflowEscalate && len(c.Escalation) == 0 {
+		return fmt.Errorf("context_overflow escalate requires at least one escalate list")
+	}
+	return nil
+}
+
+// contextLength returns a model's known context window, or 0 if its metadata doesn't say.
+func (cr *AICoreRouter) contextLength(p *ProviderConfig, apiKeyService auth.ExternalAPIKeyProvider, userID string, modelName string) int {
+	apiKey, err := discoveryAPIKey(apiKeyService, p, userID)
+	if err != nil {
+		return 0
+	}
+	model, ok := cr.findModel(p, apiKey, modelName)
+	if !ok {
+		return 0
+	}
+	info, _ := normalizeProviderModel(model)
+	return info.ContextLength
+}
+
+// requestTokens counts the prompt and the completion budget of a chat request.
+func (cr *AICoreRouter) requestTokens(body []byte) int {
+	var budget struct {
+		MaxTokens           int `json:"max_tokens"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+	}
+	_ = json.Unmarshal(body, &budget)
+	completion := budget.MaxCompletionTokens
+	if completion == 0 {
+		completion = budget.MaxTokens
+	}
+	if completion == 0 && cr.ContextOverflow != nil {
+		completion = cr.ContextOverflow.ReserveTokens
+	}
+	return cr.requestPromptTokens(body) + completion
+}
+
+// handleContextOverflow applies the context overflow policy to a resolved chat request. It
+// returns the (possibly truncated) body and the (possibly escalated) route; on rejection it
+// writes an OpenAI-style 400 error and returns a non-nil error.
+func (cr *AICoreRouter) handleContextOverflow(w http.ResponseWriter, r *http.Request, requestedModel, providerName, actualModelName string, body []byte, apiKeyService auth.ExternalAPIKeyProvider, userID string) ([]byte, string, string, error) {
+	logger := cr.requestLogger(r.Context())
+	if cr.ContextOverflow == nil {
+		return body, providerName, actualModelName, nil
+	}
+	cr.mu.RLock()
+	p, ok := cr.Providers[providerName]
+	cr.mu.RUnlock()
+	if !ok {
+		return body, providerName, actualModelName, nil
+	}
+	limit := cr.contextLength(p, apiKeyService, userID, actualModelName)
+	tokens := cr.requestTokens(body)
+	if limit <= 0 || tokens <= limit {
+		return body, providerName, actualModelName, nil
+	}
+
+	switch cr.ContextOverflow.Mode {
+	case ContextOverflowTruncate:
+		if truncated, dropped, ok := cr.truncateMessages(body, limit); ok {
+			logger.Info("Truncated prompt to fit the context window",
+				zap.String("model", actualModelName),
+				zap.String("provider", providerName),
+				zap.Int("context_length", limit),
+				zap.Int("dropped_messages", dropped),
+			)
+			w.Header().Set(TruncatedMessagesHeader, strconv.Itoa(dropped))
+			return truncated, providerName, actualModelName, nil
+		}
+	case ContextOverflowEscalate:
+		escalation, ok := cr.ContextOverflow.Escalation[requestedModel]
+		if !ok {
+			escalation = cr.ContextOverflow.Escalation[actualModelName]
+		}
+		for _, candidate := range escalation {
+			candidateProvider, candidateModel := cr.resolveProviderAndModel(r.Context(), cr.routeModel(r, candidate), "")
+			if pinned := cr.pinnedProvider(r); pinned != "" && candidateProvider != pinned {
+				continue
+			}
+			cr.mu.RLock()
+			cp, ok := cr.Providers[candidateProvider]
+			cr.mu.RUnlock()
+			if !ok || !cr.modelAllowed(cp, candidateModel) || cr.inMaintenance(r.Context(), cp) {
+				continue
+			}
+			// Escalation lists are curated, so models without context metadata are trusted to fit
+			if candidateLimit := cr.contextLength(cp, apiKeyService, userID, candidateModel); candidateLimit > 0 && tokens > candidateLimit {
+				continue
+			}
+			logger.Info("Escalated request to a larger-context model",
+				zap.String("from_model", actualModelName),
+				zap.String("to_model", candidateModel),
+				zap.String("provider", candidateProvider),
+				zap.Int("tokens", tokens),
+				zap.Int("context_length", limit),
+			)
+			return body, candidateProvider, candidateModel, nil
+		}
+	}
+
+	writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "context_length_exceeded",
+		fmt.Sprintf("This model's maximum context length is %d tokens, but the request needs an estimated %d tokens.", limit, tokens))
+	return nil, "", "", fmt.Errorf("request needs %d tokens, model %s allows %d", tokens, actualModelName, limit)
+}
+
+// truncateMessages drops the oldest non-system messages until the request fits the limit,
+// always keeping the last message. It reports false if the request can't be made to fit.
+func (cr *AICoreRouter) truncateMessages(body []byte, limit int) ([]byte, int, bool) {
+	var bodyMap map[string]json.RawMessage
+	if err := json.Unmarshal(body, &bodyMap); err != nil {
+		return nil, 0, false
+	}
+	var messages []json.RawMessage
+	if err := json.Unmarshal(bodyMap["messages"], &messages); err != nil || len(messages) < 2 {
+		return nil, 0, false
+	}
+
+	roles := make([]string, len(messages))
+	counted := make([]tokenizer.Message, len(messages))
+	for i, raw := range messages {
+		var msg struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		}
+		_ = json.Unmarshal(raw, &msg)
+		roles[i] = msg.Role
+		counted[i] = tokenizer.Message{Role: msg.Role, Content: msg.Content}
+	}
+	completion := cr.requestTokens(body) - cr.requestPromptTokens(body)
+
+	keep := make([]bool, len(messages))
+	for i := range keep {
+		keep[i] = true
+	}
+	dropped := 0
+	for i := 0; i < len(messages)-1; i++ {
+		kept := make([]tokenizer.Message, 0, len(messages))
+		for j, k := range keep {
+			if k {
+				kept = append(kept, counted[j])
+			}
+		}
+		if tokenizer.CountMessages(cr.tokenizer, kept)+completion <= limit {
+			break
+		}
+		if roles[i] == "system" || roles[i] == "developer" {
+			continue
+		}
+		keep[i] = false
+		dropped++
+	}
+
+	kept := make([]json.RawMessage, 0, len(messages)-dropped)
+	counts := make([]tokenizer.Message, 0, len(messages)-dropped)
+	for i, k := range keep {
+		if k {
+			kept = append(kept, messages[i])
+			counts = append(counts, counted[i])
+		}
+	}
+	if tokenizer.CountMessages(cr.tokenizer, counts)+completion > limit {
+		return nil, 0, false
+	}
+	// A dangling tool result without the assistant message that called it is rejected upstream
+	for len(kept) > 1 && roleOf(kept[0]) == "tool" {
+		kept = kept[1:]
+		dropped++
+	}
+
+	encoded, err := json.Marshal(kept)
+	if err != nil {
+		return nil, 0, false
+	}
+	bodyMap["messages"] = encoded
+	truncated, err := json.Marshal(bodyMap)
+	if err != nil {
+		return nil, 0, false
+	}
+	return truncated, dropped, true
+}
+
+func roleOf(message json.RawMessage) string {
+	var msg struct {
+		Role string `json:"role"`
+	}
+	_ = json.Unmarshal(message, &msg)
+	return msg.Role
+}
+
+// parseContextOverflowCaddyfile parses `context_overflow <mode> { escalate <model> <larger>...; reserve_tokens <n> }`.
+func parseContextOverflowCaddyfile(d *caddyfile.Dispenser) (*ContextOverflowConfig, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	cfg := &ContextOverflowConfig{Mode: strings.ToLower(d.Val())}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "escalate":
+			args := d.RemainingArgs()
+			if len(args) < 2 {
+				return nil, d.ArgErr()
+			}
+			if cfg.Escalation == nil {
+				cfg.Escalation = make(map[string][]string)
+			}
+			cfg.Escalation[args[0]] = args[1:]
+		case "reserve_tokens":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil || n < 0 {
+				return nil, d.Errf("invalid reserve_tokens '%s'", d.Val())
+			}
+			cfg.ReserveTokens = n
+		default:
+			return nil, d.Errf("unrecognized context_overflow option '%s'", d.Val())
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return cfg, nil
+}
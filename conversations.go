@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"go.uber.org/zap"
+)
+
+// ConversationMessagesHeader reports how many stored messages were added to a request.
+const ConversationMessagesHeader = "X-AI-Conversation-Messages"
+
+const (
+	defaultConversationTTL         = 24 * time.Hour
+	defaultConversationMaxMessages = 100
+	maxConversationIDLength        = 128
+)
+
+// ConversationConfig enables server-side conversation history. Requests naming a
+// conversation_id get its stored messages placed before their own, and each successful
+// exchange is appended to it. History lives in the router store, per user.
+type ConversationConfig struct {
+	// How long a conversation is kept after its last exchange (defaults to 24h)
+	TTL caddy.Duration `json:"ttl,omitempty"`
+	// Messages kept per conversation, oldest dropped first (defaults to 100)
+	MaxMessages int `json:"max_messages,omitempty"`
+	// Token budget of an assembled prompt when the model's context window is unknown (0 = none)
+	MaxTokens int `json:"max_tokens,omitempty"`
+}
+
+func (c *ConversationConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxMessages < 0 || c.MaxTokens < 0 {
+		return fmt.Errorf("conversations max_messages and max_tokens must not be negative")
+	}
+	return nil
+}
+
+func (c *ConversationConfig) ttl() time.Duration {
+	if c.TTL > 0 {
+		return time.Duration(c.TTL)
+	}
+	return defaultConversationTTL
+}
+
+func (c *ConversationConfig) maxMessages() int {
+	if c.MaxMessages > 0 {
+		return c.MaxMessages
+	}
+	return defaultConversationMaxMessages
+}
+
+// conversation is the stored history of one request's conversation and the messages the
+// request adds to it.
+type conversation struct {
+	key      string
+	history  []json.RawMessage
+	incoming []json.RawMessage // The request's own non-system messages
+}
+
+// loadConversation assembles the prompt of a request naming a conversation_id: its system
+// messages, then the stored history, then its other messages. The field is removed from the
+// body. It returns a nil conversation for requests without one or when conversations are off;
+// on failure, including requests without an authenticated user, it writes an OpenAI-style
+// error and returns a non-nil error.
+func (cr *AICoreRouter) loadConversation(w http.ResponseWriter, r *http.Request, body []byte, userID string) ([]byte, *conversation, error) {
+	if cr.Conversations == nil {
+		return body, nil, nil
+	}
+	var bodyMap map[string]json.RawMessage
+	if err := json.Unmarshal(body, &bodyMap); err != nil {
+		return body, nil, nil
+	}
+	raw, ok := bodyMap["conversation_id"]
+	if !ok {
+		return body, nil, nil
+	}
+	if userID == "" {
+		// Without a user, every caller would share one set of conversation IDs
+		writeOpenAIError(w, http.StatusUnauthorized, ErrorTypeAuthentication, "conversation_requires_auth",
+			"'conversation_id' requires an authenticated request")
+		return nil, nil, fmt.Errorf("conversation_id without an authenticated user")
+	}
+	var id string
+	if err := json.Unmarshal(raw, &id); err != nil || !validRequestID(id) || len(id) > maxConversationIDLength {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_conversation_id",
+			"'conversation_id' must be a string of letters, digits, '-', '_', '.' or ':'")
+		return nil, nil, fmt.Errorf("invalid conversation_id")
+	}
+	delete(bodyMap, "conversation_id")
+
+	var messages []json.RawMessage
+	if err := json.Unmarshal(bodyMap["messages"], &messages); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request body")
+		return nil, nil, err
+	}
+	conv := &conversation{key: cr.storeKey("conversation", userID, id)}
+	stored, found, err := cr.store.Get(r.Context(), conv.key)
+	if err != nil {
+		cr.requestLogger(r.Context()).Error("Failed to load conversation", zap.Error(err), zap.String("conversation_id", id))
+		writeOpenAIError(w, http.StatusServiceUnavailable, ErrorTypeAPI, "conversation_unavailable", "Service Unavailable: could not load the conversation.")
+		return nil, nil, err
+	}
+	if found {
+		if err := json.Unmarshal(stored, &conv.history); err != nil {
+			cr.requestLogger(r.Context()).Warn("Discarding unreadable conversation", zap.Error(err), zap.String("conversation_id", id))
+			conv.history = nil
+		}
+	}
+
+	assembled := make([]json.RawMessage, 0, len(conv.history)+len(messages))
+	for _, msg := range messages {
+		if role := roleOf(msg); role == "system" || role == "developer" {
+			assembled = append(assembled, msg)
+		} else {
+			conv.incoming = append(conv.incoming, msg)
+		}
+	}
+	assembled = append(assembled, conv.history...)
+	assembled = append(assembled, conv.incoming...)
+	encoded, err := json.Marshal(assembled)
+	if err != nil {
+		return nil, nil, err
+	}
+	bodyMap["messages"] = encoded
+	if body, err = json.Marshal(bodyMap); err != nil {
+		return nil, nil, err
+	}
+	w.Header().Set(ConversationMessagesHeader, strconv.Itoa(len(conv.history)))
+	return body, conv, nil
+}
+
+// fitConversation drops the oldest messages of an assembled prompt until it fits the context
+// window of the resolved model, or max_tokens when the window is unknown.
+func (cr *AICoreRouter) fitConversation(w http.ResponseWriter, r *http.Request, conv *conversation, p *ProviderConfig, actualModelName string, body []byte, apiKeyService auth.ExternalAPIKeyProvider, userID string) []byte {
+	if conv == nil {
+		return body
+	}
+	limit := cr.contextLength(p, apiKeyService, userID, actualModelName)
+	if limit <= 0 {
+		limit = cr.Conversations.MaxTokens
+	}
+	if limit <= 0 || cr.requestTokens(body) <= limit {
+		return body
+	}
+	truncated, dropped, ok := cr.truncateMessages(body, limit)
+	if !ok {
+		return body // Left to the context overflow policy
+	}
+	cr.requestLogger(r.Context()).Debug("Trimmed conversation history to fit the context window",
+		zap.String("provider", p.Name),
+		zap.String("model", actualModelName),
+		zap.Int("context_length", limit),
+		zap.Int("dropped_messages", dropped),
+	)
+	w.Header().Set(TruncatedMessagesHeader, strconv.Itoa(dropped))
+	return truncated
+}
+
+// recordConversation appends the request's messages and the assistant's reply to the conversation. Failed
+// and aborted requests, and replies without text (e.g. only tool calls), aren't recorded.
+func (cr *AICoreRouter) recordConversation(r *http.Request, conv *conversation, status int, tracker *usageTracker) {
+	if conv == nil || tracker == nil || status >= 400 || r.Context().Err() != nil {
+		return
+	}
+	reply := tracker.completionText()
+	if reply == "" {
+		return
+	}
+	assistant, _ := json.Marshal(map[string]string{"role": "assistant", "content": reply})
+	history := append(conv.history, conv.incoming...)
+	history = append(history, assistant)
+	if limit := cr.Conversations.maxMessages(); len(history) > limit {
+		history = history[len(history)-limit:]
+		for len(history) > 1 && roleOf(history[0]) != "user" {
+			history = history[1:] // Start on a user turn, as providers expect
+		}
+	}
+	value, err := json.Marshal(history)
+	if err != nil {
+		return
+	}
+	if err := cr.store.Set(context.WithoutCancel(r.Context()), conv.key, value, cr.Conversations.ttl()); err != nil {
+		cr.requestLogger(r.Context()).Error("Failed to store conversation", zap.Error(err))
+	}
+}
+
+// conversationStatusWriter records the status code sent to the client for recordConversation.
+type conversationStatusWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+	status int
+}
+
+func (w *conversationStatusWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriterWrapper.WriteHeader(statusCode)
+}
+
+func (w *conversationStatusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriterWrapper.Write(p)
+}
+
+// parseConversationsCaddyfile parses a `conversations { ttl <d>; max_messages <n>; max_tokens <n> }` block.
+func parseConversationsCaddyfile(d *caddyfile.Dispenser) (*ConversationConfig, error) {
+	cfg := &ConversationConfig{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch option := d.Val(); option {
+		case "ttl":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			ttl, err := caddy.ParseDuration(d.Val())
+			if err != nil || ttl <= 0 {
+				return nil, d.Errf("invalid conversations ttl '%s'", d.Val())
+			}
+			cfg.TTL = caddy.Duration(ttl)
+		case "max_messages", "max_tokens":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil || n <= 0 {
+				return nil, d.Errf("invalid conversations %s '%s'", option, d.Val())
+			}
+			if option == "max_messages" {
+				cfg.MaxMessages = n
+			} else {
+				cfg.MaxTokens = n
+			}
+		default:
+			return nil, d.Errf("unrecognized conversations option '%s'", option)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+)
+
+// DebugHeader asks for debug output instead of a normal response. With the value "route",
+// the router describes its routing decision without contacting the provider.
+const DebugHeader = "X-AI-Debug"
+
+const debugRoute = "route"
+
+// routeDecision is the dry-run description of how a request would be routed.
+type routeDecision struct {
+	Object         string          `json:"object"`
+	RequestID      string          `json:"request_id"`
+	Router         string          `json:"router"`
+	RequestedModel string          `json:"requested_model"`
+	RoutedModel    string          `json:"routed_model"` // After experiments, canaries and class routing
+	PromptClass    string          `json:"prompt_class,omitempty"`
+	Rule           string          `json:"rule,omitempty"` // Routing rule that matched the routed model
+	Provider       string          `json:"provider"`
+	Style          string          `json:"style"`
+	ActualModel    string          `json:"actual_model"`
+	Stream         bool            `json:"stream"`
+	Method         string          `json:"method"`
+	TargetURL      string          `json:"target_url"`
+	KeySource      string          `json:"key_source"`
+	KeyFound       bool            `json:"key_found"`
+	KeyError       string          `json:"key_error,omitempty"`
+	Experiment     string          `json:"experiment,omitempty"`
+	Canary         string          `json:"canary,omitempty"`
+	UpstreamBody   json.RawMessage `json:"upstream_body,omitempty"`
+}
+
+// isDryRun reports whether the client asked for the routing decision only, with an
+// X-AI-Debug: route header or a dry_run=true query parameter.
+func isDryRun(r *http.Request) bool {
+	if strings.EqualFold(strings.TrimSpace(r.Header.Get(DebugHeader)), debugRoute) {
+		return true
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return dryRun
+}
+
+// keySource describes where the upstream key for a provider comes from.
+func keySource(apiKeyService auth.ExternalAPIKeyProvider, p *ProviderConfig) string {
+	if p.passthroughKey() {
+		return "client"
+	}
+	if p.keyless() {
+		return "none"
+	}
+	if len(p.APIKeys) > 0 {
+		return fmt.Sprintf("config:api_keys[%d]", len(p.APIKeys))
+	}
+	switch svc := apiKeyService.(type) {
+	case nil:
+		return "none"
+	case *auth.DefaultEnvAPIKeyProvider:
+		return "env:" + svc.EnvVarName(strings.ToLower(p.Name))
+	default:
+		return fmt.Sprintf("%T", svc)
+	}
+}
+
+// writeRouteDecision answers a dry-run request with the routing decision. The upstream
+// request is built exactly as it would be proxied, but never sent.
+func (cr *AICoreRouter) writeRouteDecision(w http.ResponseWriter, r *http.Request, decision routeDecision, p *ProviderConfig, apiKeyService auth.ExternalAPIKeyProvider, userID string, body []byte) error {
+	decision.Object = "route.decision"
+	decision.RequestID = requestID(r.Context())
+	decision.Router = cr.Name
+	if cr.pinnedProvider(r) == "" {
+		decision.Rule = cr.routeModel(r, decision.RoutedModel).rule
+	}
+	decision.Provider = p.Name
+	if p.Provider != nil {
+		decision.Style = p.Provider.Name()
+	}
+	decision.KeySource = keySource(apiKeyService, p)
+
+	apiKey, err := providerAPIKey(r, apiKeyService, p, userID)
+	if err != nil {
+		decision.KeyError = err.Error()
+	}
+	decision.KeyFound = apiKey != ""
+
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, ProviderNameContextKeyString, p.Name)
+	ctx = context.WithValue(ctx, ActualModelNameContextKeyString, decision.ActualModel)
+	ctx = context.WithValue(ctx, ExternalAPIKeyProviderContextKeyString, apiKey)
+	ctx = context.WithValue(ctx, common.StreamContextKeyString, decision.Stream)
+	upstream := r.Clone(ctx)
+	if query := upstream.URL.Query(); query.Has("dry_run") {
+		query.Del("dry_run")
+		upstream.URL.RawQuery = query.Encode()
+	}
+	upstream.Body = io.NopCloser(bytes.NewReader(body))
+	upstream.ContentLength = int64(len(body))
+	upstream.Header.Set("Authorization", "Bearer "+apiKey)
+	cr.rewriteUpstreamRequest(p, upstream, cr.requestLogger(ctx))
+
+	upstreamURL := *upstream.URL
+	if query := upstreamURL.Query(); query.Has("key") {
+		query.Set("key", "REDACTED") // Google-style keys travel in the query
+		upstreamURL.RawQuery = query.Encode()
+	}
+	decision.Method = upstream.Method
+	decision.TargetURL = upstreamURL.String()
+	if upstream.Body != nil {
+		if upstreamBody, err := io.ReadAll(upstream.Body); err == nil && json.Valid(upstreamBody) {
+			decision.UpstreamBody = upstreamBody
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(decision)
+}
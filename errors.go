@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"go.uber.org/zap"
+)
+
+// OpenAI error types used in error envelopes.
+const (
+	ErrorTypeInvalidRequest = "invalid_request_error"
+	ErrorTypeAuthentication = "authentication_error"
+	ErrorTypePermission     = "permission_error"
+	ErrorTypeNotFound       = "not_found_error"
+	ErrorTypeRateLimit      = "rate_limit_error"
+	ErrorTypeAPI            = "api_error"
+)
+
+// Normalized codes of upstream errors, the same whichever provider failed, so clients can
+// decide on retries by code.
+const (
+	ErrorCodeRateLimitExceeded     = "rate_limit_exceeded"
+	ErrorCodeInsufficientQuota     = "insufficient_quota"
+	ErrorCodeContextLengthExceeded = "context_length_exceeded"
+	ErrorCodeInvalidAPIKey         = "invalid_api_key"
+	ErrorCodePermissionDenied      = "permission_denied"
+	ErrorCodeNotFound              = "not_found"
+	ErrorCodeInvalidRequest        = "invalid_request"
+	ErrorCodeOverloaded            = "overloaded"
+	ErrorCodeTimeout               = "timeout"
+	ErrorCodeUpstream              = "upstream_error"
+)
+
+// OpenAIError is the body of an OpenAI-compatible error response.
+type OpenAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+	// The provider's original error body, for upstream errors
+	Upstream json.RawMessage `json:"upstream,omitempty"`
+}
+
+// OpenAIErrorResponse is the envelope OpenAI SDK clients expect on errors.
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+// errorTypeForStatus picks the OpenAI error type matching an HTTP status code.
+func errorTypeForStatus(statusCode int) string {
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		return ErrorTypeAuthentication
+	case statusCode == http.StatusForbidden:
+		return ErrorTypePermission
+	case statusCode == http.StatusNotFound:
+		return ErrorTypeNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorTypeRateLimit
+	case statusCode >= 500:
+		return ErrorTypeAPI
+	}
+	return ErrorTypeInvalidRequest
+}
+
+// openAIErrorBody renders an OpenAI-compatible error envelope.
+func openAIErrorBody(errType string, code string, message string) []byte {
+	apiErr := OpenAIError{Message: message, Type: errType}
+	if code != "" {
+		apiErr.Code = &code
+	}
+	body, _ := json.Marshal(OpenAIErrorResponse{Error: apiErr})
+	return append(body, '\n')
+}
+
+// writeOpenAIError writes an OpenAI-compatible JSON error response.
+func writeOpenAIError(w http.ResponseWriter, statusCode int, errType string, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	w.Write(openAIErrorBody(errType, code, message))
+}
+
+// writeOpenAIParamError writes an OpenAI-compatible JSON error response about a request
+// parameter, named in the error's param.
+func writeOpenAIParamError(w http.ResponseWriter, statusCode int, errType string, code string, param string, message string) {
+	apiErr := OpenAIError{Message: message, Type: errType, Param: &param}
+	if code != "" {
+		apiErr.Code = &code
+	}
+	body, _ := json.Marshal(OpenAIErrorResponse{Error: apiErr})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	w.Write(append(body, '\n'))
+}
+
+// upstreamError is what the router understands of a provider error body.
+type upstreamError struct {
+	message string
+	param   string
+	kinds   []string // Provider error types, statuses and codes, lowercased
+}
+
+// parseUpstreamError reads a provider error body in the common shapes: OpenAI's error.code and
+// error.type, Anthropic's error.type, Google's error.status and Cloudflare's errors[].
+func parseUpstreamError(body []byte) upstreamError {
+	var parsed upstreamError
+	var payload struct {
+		Error  json.RawMessage `json:"error"`
+		Errors []struct {
+			Message string          `json:"message"`
+			Code    json.RawMessage `json:"code"`
+		} `json:"errors"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		parsed.message = strings.TrimSpace(string(body))
+		return parsed
+	}
+	if len(payload.Error) > 0 {
+		var nested struct {
+			Message string          `json:"message"`
+			Type    string          `json:"type"`
+			Status  string          `json:"status"`
+			Code    json.RawMessage `json:"code"`
+			Param   *string         `json:"param"`
+			Details []struct {
+				Reason string `json:"reason"`
+			} `json:"details"`
+		}
+		var flat string
+		if json.Unmarshal(payload.Error, &nested) == nil {
+			parsed.message = nested.Message // OpenAI, Anthropic and Google
+			parsed.kinds = append(parsed.kinds, nested.Type, nested.Status, errorCodeString(nested.Code))
+			for _, detail := range nested.Details {
+				parsed.kinds = append(parsed.kinds, detail.Reason)
+			}
+			if nested.Param != nil {
+				parsed.param = *nested.Param
+			}
+		} else if json.Unmarshal(payload.Error, &flat) == nil {
+			parsed.message = flat
+		}
+	}
+	if parsed.message == "" && len(payload.Errors) > 0 {
+		parsed.message = payload.Errors[0].Message // Cloudflare
+		parsed.kinds = append(parsed.kinds, errorCodeString(payload.Errors[0].Code))
+	}
+	if parsed.message == "" {
+		parsed.message = payload.Message
+	}
+	if parsed.message == "" {
+		parsed.message = strings.TrimSpace(string(body))
+	}
+	for i, kind := range parsed.kinds {
+		parsed.kinds[i] = strings.ToLower(kind)
+	}
+	return parsed
+}
+
+// errorCodeString returns a string error code; numeric codes merely repeat the status.
+func errorCodeString(raw json.RawMessage) string {
+	var code string
+	if json.Unmarshal(raw, &code) == nil {
+		return code
+	}
+	return ""
+}
+
+// upstreamErrorMessage extracts a human readable message from a provider error body.
+// It understands the common provider shapes and falls back to the raw body.
+func upstreamErrorMessage(body []byte) string {
+	return parseUpstreamError(body).message
+}
+
+// contextLengthMarkers are phrases of provider messages rejecting prompts that are too long.
+var contextLengthMarkers = []string{"context length", "context_length", "context window", "maximum context", "prompt is too long", "input token count", "input is too long"}
+
+// code maps a provider error onto the normalized codes, from its status, type and message.
+func (e upstreamError) code(statusCode int) string {
+	is := func(kinds ...string) bool {
+		for _, kind := range kinds {
+			if slices.Contains(e.kinds, kind) {
+				return true
+			}
+		}
+		return false
+	}
+	message := strings.ToLower(e.message)
+	switch {
+	case is("insufficient_quota", "billing_hard_limit_reached") || strings.Contains(message, "credit balance"):
+		return ErrorCodeInsufficientQuota
+	case statusCode == http.StatusTooManyRequests || is("rate_limit_error", "rate_limit_exceeded", "resource_exhausted"):
+		return ErrorCodeRateLimitExceeded
+	case is("context_length_exceeded") || slices.ContainsFunc(contextLengthMarkers, func(marker string) bool { return strings.Contains(message, marker) }):
+		return ErrorCodeContextLengthExceeded
+	case statusCode == http.StatusUnauthorized || is("authentication_error", "invalid_api_key", "unauthenticated", "api_key_invalid"):
+		return ErrorCodeInvalidAPIKey
+	case statusCode == http.StatusForbidden || is("permission_error", "permission_denied"):
+		return ErrorCodePermissionDenied
+	case statusCode == http.StatusNotFound || is("not_found_error", "not_found", "model_not_found"):
+		return ErrorCodeNotFound
+	case statusCode == http.StatusServiceUnavailable || statusCode == 529 || is("overloaded_error", "unavailable"):
+		return ErrorCodeOverloaded
+	case statusCode == http.StatusGatewayTimeout || is("deadline_exceeded", "timeout"):
+		return ErrorCodeTimeout
+	case statusCode >= 500:
+		return ErrorCodeUpstream
+	}
+	return ErrorCodeInvalidRequest
+}
+
+// errorTypeForCode picks the OpenAI error type of a normalized upstream error.
+func errorTypeForCode(statusCode int, code string) string {
+	switch code {
+	case ErrorCodeInvalidAPIKey:
+		return ErrorTypeAuthentication
+	case ErrorCodeRateLimitExceeded:
+		return ErrorTypeRateLimit
+	}
+	return errorTypeForStatus(statusCode)
+}
+
+// normalizeUpstreamError rewrites a provider error response into the OpenAI error envelope with
+// a normalized code. The original body is kept under error.upstream unless providers are hidden.
+func (cr *AICoreRouter) normalizeUpstreamError(resp *http.Response, providerName string) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+		parsed := parseUpstreamError(body)
+		if parsed.message == "" {
+			parsed.message = http.StatusText(resp.StatusCode)
+		}
+		code := parsed.code(resp.StatusCode)
+		cr.logger.Debug("Normalizing upstream error response",
+			zap.String("provider", providerName),
+			zap.Int("status_code", resp.StatusCode),
+			zap.String("code", code),
+			zap.ByteString("body", bytes.TrimSpace(body)),
+		)
+
+		apiErr := OpenAIError{Message: parsed.message, Type: errorTypeForCode(resp.StatusCode, code), Code: &code}
+		if parsed.param != "" {
+			apiErr.Param = &parsed.param
+		}
+		if !cr.HideProvider.enabled() {
+			if original := bytes.TrimSpace(body); json.Valid(original) {
+				apiErr.Upstream = original
+			} else if len(original) > 0 {
+				apiErr.Upstream, _ = json.Marshal(string(original))
+			}
+		}
+		normalized, err := json.Marshal(OpenAIErrorResponse{Error: apiErr})
+		if err != nil {
+			return body, err
+		}
+		resp.Header.Set("Content-Type", "application/json")
+		resp.Header.Del("Content-Length")
+		return append(normalized, '\n'), nil
+	})
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Experiment arms recorded in the X-AI-Experiment header and observability events.
+const (
+	ExperimentArmControl = "control"
+	ExperimentArmVariant = "variant"
+)
+
+const ExperimentHeader = "X-AI-Experiment"
+
+// Experiment routes a stable percentage of users to an alternative model (optionally with a
+// "provider/model" prefix). Users are assigned by hashing their ai_user_id with the experiment
+// name, so a user stays in the same arm for as long as the percentage is unchanged.
+type Experiment struct {
+	Name string `json:"name"`
+	// Requested model taking part in the experiment; empty matches every model
+	Model string `json:"model,omitempty"`
+	// Share of users, 0-100, routed to the variant
+	Percent float64 `json:"percent"`
+	Variant string  `json:"variant"`
+}
+
+// experimentAssignment is the outcome of experiment assignment for one request.
+type experimentAssignment struct {
+	experiment *Experiment
+	arm        string
+}
+
+func (e *Experiment) validate() error {
+	if e.Variant == "" {
+		return fmt.Errorf("experiment %s: variant is required", e.Name)
+	}
+	if e.Percent < 0 || e.Percent > 100 {
+		return fmt.Errorf("experiment %s: percent must be between 0 and 100, got %v", e.Name, e.Percent)
+	}
+	return nil
+}
+
+// bucket maps a user onto [0, 10000) for this experiment.
+func (e *Experiment) bucket(userID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(e.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(userID))
+	return h.Sum64() % 10000
+}
+
+// assignExperiment returns the first experiment matching the requested model and the user's arm.
+// Requests without a user ID are never enrolled.
+func (cr *AICoreRouter) assignExperiment(requestedModel string, userID string) *experimentAssignment {
+	if userID == "" {
+		return nil
+	}
+	for _, e := range cr.Experiments {
+		if e.Model != "" && e.Model != requestedModel {
+			continue
+		}
+		arm := ExperimentArmControl
+		if float64(e.bucket(userID)) < e.Percent*100 {
+			arm = ExperimentArmVariant
+		}
+		return &experimentAssignment{experiment: e, arm: arm}
+	}
+	return nil
+}
+
+// headerValue renders the assignment for the X-AI-Experiment header.
+func (a *experimentAssignment) headerValue() string {
+	return a.experiment.Name + "; arm=" + a.arm
+}
+
+// observabilityProps adds the assignment to observability event properties.
+func (a *experimentAssignment) observabilityProps(props map[string]any) map[string]any {
+	if a != nil {
+		props["experiment"] = a.experiment.Name
+		props["experiment_arm"] = a.arm
+	}
+	return props
+}
+
+// parseExperimentCaddyfile parses an `experiment <name> { ... }` block.
+func parseExperimentCaddyfile(d *caddyfile.Dispenser) (*Experiment, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	e := &Experiment{Name: d.Val()}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "model":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			e.Model = d.Val()
+		case "percent":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			percent, err := strconv.ParseFloat(d.Val(), 64)
+			if err != nil {
+				return nil, d.Errf("experiment %s: invalid percent '%s'", e.Name, d.Val())
+			}
+			e.Percent = percent
+		case "variant":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			e.Variant = d.Val()
+		default:
+			return nil, d.Errf("unrecognized experiment option '%s'", d.Val())
+		}
+	}
+	if err := e.validate(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return e, nil
+}
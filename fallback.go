@@ -0,0 +1,412 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"go.uber.org/zap"
+)
+
+// FallbackHeader tells the client which provider/model served a request after its fallback chain
+// moved past the requested model.
+const FallbackHeader = "X-AI-Fallback"
+
+// Failures that move a request to the next model of its fallback chain.
+const (
+	// FallbackOnError covers 429 and 5xx responses, connection errors included.
+	FallbackOnError = "error"
+	// FallbackOnTimeout covers attempts whose response doesn't start within the chain's timeout.
+	FallbackOnTimeout = "timeout"
+	// FallbackOnRefusal covers content-policy refusals: a 400 blaming the content policy, or a
+	// non-streaming completion stopped with finish_reason "content_filter".
+	FallbackOnRefusal = "refusal"
+)
+
+// Markers of content-policy refusals in provider error messages.
+var refusalMarkers = []string{"content policy", "content_policy", "content management policy", "content filter", "content_filter", "safety"}
+
+// FallbackChain is an ordered list of models, each usually on its own provider, that a request
+// for a matching model falls back to when the model before it fails. Unlike provider lists,
+// every hop names its own model, since the same model is rarely served everywhere.
+type FallbackChain struct {
+	// Glob pattern ("*" and "?") matched against the requested model
+	Model string `json:"model"`
+	// Models tried in order after the requested one, as "provider/model" or names routing rules resolve
+	Chain []string `json:"chain"`
+	// Failures that trigger a fallback (defaults to all of error, timeout and refusal)
+	On []string `json:"on,omitempty"`
+	// Time a hop has for its response to start before the chain moves on (0 = no timeout)
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	model *regexp.Regexp
+	on    map[string]bool
+}
+
+func (c *FallbackChain) provision() error {
+	if c.Model == "" || len(c.Chain) == 0 {
+		return fmt.Errorf("fallback chain requires a model and at least one fallback model")
+	}
+	c.model = globPattern(c.Model)
+	c.on = make(map[string]bool)
+	triggers := c.On
+	if len(triggers) == 0 {
+		triggers = []string{FallbackOnError, FallbackOnTimeout, FallbackOnRefusal}
+	}
+	for _, trigger := range triggers {
+		switch trigger {
+		case FallbackOnError, FallbackOnTimeout, FallbackOnRefusal:
+			c.on[trigger] = true
+		default:
+			return fmt.Errorf("fallback %s: invalid trigger '%s' (expected error, timeout or refusal)", c.Model, trigger)
+		}
+	}
+	return nil
+}
+
+// holds reports whether a response with the given status must be held back, because it may
+// trigger a fallback. Non-streaming responses are all held when refusals trigger fallbacks, so
+// their finish_reason can be checked.
+func (c *FallbackChain) holds(status int, stream bool) bool {
+	switch {
+	case c.on[FallbackOnRefusal] && (!stream || status == http.StatusBadRequest):
+		return true
+	case c.on[FallbackOnError] && (status == http.StatusTooManyRequests || status >= 500):
+		return true
+	}
+	return false
+}
+
+// reason returns why a held response triggers a fallback, or "" if it doesn't.
+func (c *FallbackChain) reason(resp *bufferedResponse) string {
+	if c.on[FallbackOnError] && (resp.status == http.StatusTooManyRequests || resp.status >= 500) {
+		return FallbackOnError
+	}
+	if c.on[FallbackOnRefusal] && isContentRefusal(resp) {
+		return FallbackOnRefusal
+	}
+	return ""
+}
+
+// isContentRefusal reports whether a (normalized) response is a content-policy refusal.
+func isContentRefusal(resp *bufferedResponse) bool {
+	switch resp.status {
+	case http.StatusBadRequest:
+		message := strings.ToLower(upstreamErrorMessage(resp.body.Bytes()))
+		for _, marker := range refusalMarkers {
+			if strings.Contains(message, marker) {
+				return true
+			}
+		}
+	case http.StatusOK:
+		var completion struct {
+			Choices []struct {
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if json.Unmarshal(resp.body.Bytes(), &completion) == nil {
+			for _, choice := range completion.Choices {
+				if choice.FinishReason == "content_filter" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// fallbackChain returns the first chain matching a requested model, if any.
+func (cr *AICoreRouter) fallbackChain(requestedModel string) *FallbackChain {
+	for _, c := range cr.Fallbacks {
+		if c.model.MatchString(requestedModel) {
+			return c
+		}
+	}
+	return nil
+}
+
+// fallbackWriter holds back the response of a hop until it is known whether the chain moves
+// past it. Responses that can't trigger a fallback are passed straight through, so streams
+// aren't delayed.
+type fallbackWriter struct {
+	w       http.ResponseWriter
+	resp    bufferedResponse
+	hold    func(status int) bool
+	start   func() bool // Called once the response starts; false if the hop already timed out
+	passing bool
+}
+
+func (f *fallbackWriter) Header() http.Header {
+	if f.passing {
+		return f.w.Header()
+	}
+	return f.resp.header
+}
+
+func (f *fallbackWriter) WriteHeader(status int) {
+	if f.resp.status != 0 {
+		return
+	}
+	f.resp.status = status
+	if !f.start() || f.hold(status) {
+		return
+	}
+	f.passing = true
+	for name, values := range f.resp.header {
+		f.w.Header()[name] = values
+	}
+	f.w.WriteHeader(status)
+}
+
+func (f *fallbackWriter) Write(p []byte) (int, error) {
+	if f.resp.status == 0 {
+		f.WriteHeader(http.StatusOK)
+	}
+	if f.passing {
+		return f.w.Write(p)
+	}
+	return f.resp.body.Write(p)
+}
+
+func (f *fallbackWriter) Flush() {
+	if f.passing {
+		http.NewResponseController(f.w).Flush()
+	}
+}
+
+// proxyUpstream sends a request to a provider, with retries for non-streaming requests when
+// they are enabled and n > 1 fanned out for providers that can't honour it.
+func (cr *AICoreRouter) proxyUpstream(w http.ResponseWriter, r *http.Request, p *ProviderConfig, body []byte, stream bool) {
+	setDroppedParams(w, p, body)
+	if n := requestedChoices(body); n > 1 && p.emulatesChoices() {
+		cr.proxyFanOut(w, r, p, body, stream, n)
+		return
+	}
+	r.ContentLength = int64(len(body))
+	if cr.Retry.enabled() && !stream {
+		cr.proxyWithRetry(w, r, p, body)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	p.proxy.ServeHTTP(w, r)
+}
+
+// proxyWithFallback proxies a request to its resolved provider and, while the hop fails in a way
+// the chain falls back on, to the next model of the chain. Hops that can't be resolved without
+// model matching, have no key or whose provider is at its concurrency limit are skipped. The
+// client gets the first response that doesn't trigger a fallback or fail the quality guard, or
+// the last failure.
+// clientKey is the key the client sent, for passthrough providers down the chain.
+func (cr *AICoreRouter) proxyWithFallback(w http.ResponseWriter, r *http.Request, chain *FallbackChain, p *ProviderConfig, body []byte, stream bool, clientKey string, apiKeyService auth.ExternalAPIKeyProvider, userID string) {
+	logger := cr.requestLogger(r.Context())
+	var last *bufferedResponse
+	var guardRetries int
+	hops := chain.Chain
+	release := func() {} // Concurrency slot of the current hop; the first one is the caller's
+	defer func() { release() }()
+	for hop := 0; ; hop++ {
+		final := len(hops) == 0
+		resp, timedOut := cr.fallbackHop(w, r, chain, p, body, stream, final)
+		if resp == nil || r.Context().Err() != nil {
+			return // Passed through, or the client is gone
+		}
+		reason := chain.reason(resp)
+		if timedOut {
+			reason = FallbackOnTimeout
+		} else {
+			last = resp
+		}
+		if reason == "" && guardRetries < cr.QualityGuard.maxRetries() {
+			if reason = cr.QualityGuard.check(resp); reason != "" {
+				guardRetries++
+			}
+		}
+		if reason == "" {
+			resp.writeTo(w)
+			return
+		}
+
+		failedProvider := p.Name
+		var next *ProviderConfig
+		for next == nil && len(hops) > 0 {
+			model := hops[0]
+			hops = hops[1:]
+			if next, r = cr.fallbackTarget(r, model, clientKey, apiKeyService, userID); next == nil || next.limiter == nil {
+				continue
+			}
+			if _, err := next.limiter.acquire(r.Context(), cr.requestPriority(r)); err != nil {
+				if r.Context().Err() != nil {
+					return
+				}
+				logger.Warn("Skipping fallback model, provider at its concurrency limit", zap.String("provider", next.Name))
+				next = nil
+			}
+		}
+		release()
+		release = func() {}
+		if next == nil {
+			break
+		}
+		if next.limiter != nil {
+			release = next.limiter.release
+		}
+
+		upstreamFallbacks.WithLabelValues(cr.Name, failedProvider, reason).Inc()
+		actualModel, _ := r.Context().Value(ActualModelNameContextKeyString).(string)
+		logger.Info("Falling back to next model of chain",
+			zap.String("failed_provider", failedProvider),
+			zap.String("reason", reason),
+			zap.Int("status_code", resp.status),
+			zap.String("provider", next.Name),
+			zap.String("actual_model", actualModel),
+			zap.Int("hop", hop+1),
+		)
+		w.Header().Set(FallbackHeader, cr.HideProvider.servedBy(next.Name, actualModel))
+		accessRecordFrom(r.Context()).fellBack(next.Name, actualModel)
+		setServedPlaceholders(r, next.Name, actualModel)
+		p = next
+	}
+
+	w.Header().Del(FallbackHeader)
+	if last != nil {
+		last.writeTo(w)
+		return
+	}
+	writeOpenAIError(w, http.StatusGatewayTimeout, ErrorTypeAPI, "upstream_timeout", "The upstream provider did not respond in time")
+}
+
+// fallbackHop proxies one hop of a chain. It returns the held response, or nil if the response
+// was passed through to the client; timedOut is set if the hop ran out of time first.
+func (cr *AICoreRouter) fallbackHop(w http.ResponseWriter, r *http.Request, chain *FallbackChain, p *ProviderConfig, body []byte, stream bool, final bool) (resp *bufferedResponse, timedOut bool) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	const pending, started, expired = 0, 1, 2
+	var state atomic.Int32
+	if chain.Timeout > 0 && chain.on[FallbackOnTimeout] && !final {
+		timer := time.AfterFunc(time.Duration(chain.Timeout), func() {
+			if state.CompareAndSwap(pending, expired) {
+				cancel()
+			}
+		})
+		defer timer.Stop()
+	}
+	fw := &fallbackWriter{
+		w:    w,
+		resp: bufferedResponse{header: make(http.Header)},
+		hold: func(status int) bool {
+			return !final && (chain.holds(status, stream) || cr.QualityGuard.holds(status, stream))
+		},
+		start: func() bool {
+			return state.CompareAndSwap(pending, started) || state.Load() == started
+		},
+	}
+	cr.proxyUpstream(fw, r.WithContext(ctx), p, body, stream)
+	if fw.passing {
+		return nil, false
+	}
+	return &fw.resp, state.Load() == expired
+}
+
+// fallbackTarget resolves a model of a chain to its provider and returns the request carrying
+// the new route and upstream key, or a nil provider if the hop has to be skipped.
+func (cr *AICoreRouter) fallbackTarget(r *http.Request, model string, clientKey string, apiKeyService auth.ExternalAPIKeyProvider, userID string) (*ProviderConfig, *http.Request) {
+	logger := cr.requestLogger(r.Context())
+	providerName, actualModel := cr.resolveProviderAndModel(r.Context(), cr.routeModel(r, model), "")
+	if providerName == "" {
+		cached, ok := cr.loadResolvedModel(r.Context(), model)
+		if !ok {
+			logger.Warn("Skipping fallback model without a provider; use <provider>/<model> or a routing rule", zap.String("model", model))
+			return nil, r
+		}
+		providerName, actualModel = cached.ProviderName, cached.ActualModelName
+	}
+	cr.mu.RLock()
+	p, ok := cr.Providers[providerName]
+	cr.mu.RUnlock()
+	if !ok {
+		return nil, r
+	}
+	if !cr.modelAllowed(p, actualModel) {
+		logger.Warn("Skipping fallback model the router or provider doesn't serve", zap.String("provider", p.Name), zap.String("model", actualModel))
+		return nil, r
+	}
+	if cr.inMaintenance(r.Context(), p) {
+		logger.Debug("Skipping fallback model on a provider under maintenance", zap.String("provider", p.Name), zap.String("model", actualModel))
+		return nil, r
+	}
+
+	apiKey := clientKey
+	if !p.passthroughKey() {
+		var err error
+		if apiKey, err = providerAPIKey(r, apiKeyService, p, userID); err != nil {
+			logger.Warn("Skipping fallback model, failed to fetch upstream key", zap.String("provider", p.Name), zap.Error(err))
+			return nil, r
+		}
+	}
+	if apiKey == "" && p.passthroughKey() {
+		logger.Debug("Skipping fallback model, no client key for passthrough provider", zap.String("provider", p.Name))
+		return nil, r
+	}
+
+	ctx := context.WithValue(r.Context(), ProviderNameContextKeyString, p.Name)
+	ctx = context.WithValue(ctx, ActualModelNameContextKeyString, actualModel)
+	ctx = context.WithValue(ctx, ExternalAPIKeyProviderContextKeyString, apiKey)
+	ctx = context.WithValue(ctx, RouteSampleContextKeyString, &routeSample{key: latencyKey(p.Name, model)})
+	r = r.WithContext(ctx)
+	r.Header.Set("Authorization", "Bearer "+apiKey)
+	return p, r
+}
+
+// parseFallbackCaddyfile parses a `fallback <model> [->] <fallback_model>... { ... }` line.
+func parseFallbackCaddyfile(d *caddyfile.Dispenser) (*FallbackChain, error) {
+	chain := &FallbackChain{}
+	for _, arg := range d.RemainingArgs() {
+		if arg == "->" {
+			continue // Allowed for readability
+		}
+		if chain.Model == "" {
+			chain.Model = arg
+		} else {
+			chain.Chain = append(chain.Chain, arg)
+		}
+	}
+	if chain.Model == "" || len(chain.Chain) == 0 {
+		return nil, d.Errf("fallback expects <model> <fallback_model> [<fallback_model>...]")
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "on":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			chain.On = append(chain.On, args...)
+		case "timeout":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid fallback timeout '%s': %v", d.Val(), err)
+			}
+			chain.Timeout = caddy.Duration(dur)
+		default:
+			return nil, d.Errf("unrecognized fallback option '%s'", d.Val())
+		}
+	}
+	if err := chain.provision(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return chain, nil
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+	"go.uber.org/zap"
+)
+
+// Most parallel requests one completion may fan out to when n is emulated.
+const maxFanOut = 16
+
+// requestedChoices returns the n a unified completion request asks for (1 if unset).
+func requestedChoices(body []byte) int {
+	var req struct {
+		N *int `json:"n"`
+	}
+	if json.Unmarshal(body, &req) != nil || req.N == nil || *req.N < 1 {
+		return 1
+	}
+	return *req.N
+}
+
+// emulatesChoices reports whether a provider needs n > 1 emulated by the router.
+func (p *ProviderConfig) emulatesChoices() bool {
+	cp, ok := p.Provider.(providers.ChoicesProvider)
+	return ok && !cp.SupportsMultipleChoices()
+}
+
+// proxyFanOut emulates n > 1 for a provider returning a single choice per request: it sends n
+// parallel requests without n and merges their completions into one response, with the choices
+// indexed in order and the usage summed. The first failure is returned instead if any fails.
+// Streams can't be merged and are rejected.
+// The first request uses the caller's concurrency slot and upstream key; each other one takes
+// its own slot, failing if none is free, and its own key from the provider's key pool, so a
+// fanned out request counts as n against max_concurrent_requests and key_requests_per_minute.
+func (cr *AICoreRouter) proxyFanOut(w http.ResponseWriter, r *http.Request, p *ProviderConfig, body []byte, stream bool, n int) {
+	if stream {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "unsupported_parameter",
+			fmt.Sprintf("n > 1 is not supported for streaming requests to provider %s", p.Name))
+		return
+	}
+	if n > maxFanOut {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_parameter",
+			fmt.Sprintf("n must not exceed %d for provider %s", maxFanOut, p.Name))
+		return
+	}
+	if p.limiter != nil && n > p.MaxConcurrentRequests {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_parameter",
+			fmt.Sprintf("n must not exceed provider %s's max_concurrent_requests (%d)", p.Name, p.MaxConcurrentRequests))
+		return
+	}
+	branchBody, err := withoutParam(body, "n")
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request body")
+		return
+	}
+	cr.requestLogger(r.Context()).Debug("Fanning out request to emulate n", zap.String("provider", p.Name), zap.Int("n", n))
+
+	// Usage is recorded once, from the merged response
+	tracker, _ := r.Context().Value(UsageTrackerContextKeyString).(*usageTracker)
+	ctx := context.WithValue(r.Context(), UsageTrackerContextKeyString, (*usageTracker)(nil))
+
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey == "" {
+		idempotencyKey = requestID(ctx)
+	}
+	sample, _ := ctx.Value(RouteSampleContextKeyString).(*routeSample)
+
+	responses := make([]*bufferedResponse, n)
+	var wg sync.WaitGroup
+	for i := range responses {
+		responses[i] = &bufferedResponse{header: make(http.Header)}
+		branchCtx := ctx
+		if sample != nil {
+			branchCtx = context.WithValue(ctx, RouteSampleContextKeyString, &routeSample{key: sample.key})
+		}
+		branch := r.Clone(branchCtx)
+		if idempotencyKey != "" {
+			// Branches are distinct requests; a shared key would let the provider deduplicate them
+			branch.Header.Set("Idempotency-Key", fmt.Sprintf("%s-%d", idempotencyKey, i))
+		}
+		if i > 0 {
+			if p.limiter != nil && !p.limiter.tryAcquire() {
+				writeProviderOverloaded(responses[i], p, errQueueFull)
+				continue
+			}
+			if err := cr.pickBranchKey(branch, p); err != nil {
+				cr.requestLogger(ctx).Error("Failed to get an upstream key for a fanned out request", zap.String("provider", p.Name), zap.Error(err))
+				writeOpenAIError(responses[i], http.StatusInternalServerError, ErrorTypeAPI, "", "Internal server error: could not get an upstream key")
+				if p.limiter != nil {
+					p.limiter.release()
+				}
+				continue
+			}
+		}
+		wg.Add(1)
+		go func(i int, resp *bufferedResponse) {
+			defer wg.Done()
+			if i > 0 && p.limiter != nil {
+				defer p.limiter.release()
+			}
+			if proxyRecovering(func() { cr.proxyUpstream(resp, branch, p, branchBody, false) }) {
+				resp.header = make(http.Header)
+				resp.status = http.StatusBadGateway
+				resp.body.Reset()
+				resp.body.Write(openAIErrorBody(ErrorTypeAPI, "upstream_unavailable", "Upstream response was interrupted"))
+			}
+		}(i, responses[i])
+	}
+	wg.Wait()
+	if r.Context().Err() != nil {
+		return // Client is gone
+	}
+
+	for _, resp := range responses {
+		if resp.status != http.StatusOK {
+			resp.writeTo(w)
+			return
+		}
+	}
+	merged, err := mergeCompletions(responses)
+	if err != nil {
+		cr.requestLogger(r.Context()).Warn("Failed to merge fanned out completions", zap.String("provider", p.Name), zap.Error(err))
+		writeOpenAIError(w, http.StatusBadGateway, ErrorTypeAPI, "upstream_error", "Upstream returned an invalid completion")
+		return
+	}
+	if tracker != nil {
+		tracker.observeResponse(merged)
+	}
+	out := responses[0]
+	out.header.Del("Content-Length")
+	out.body.Reset()
+	out.body.Write(merged)
+	out.writeTo(w)
+}
+
+// pickBranchKey gives a fanned out request its own upstream key from the provider's key pool,
+// counting it against the key's budget. Passthrough and keyless providers keep the caller's.
+func (cr *AICoreRouter) pickBranchKey(branch *http.Request, p *ProviderConfig) error {
+	if p.passthroughKey() || p.keyless() {
+		return nil
+	}
+	userID, _ := branch.Context().Value(UserIDContextKeyString).(string)
+	apiKey, err := providerAPIKey(branch, cr.apiKeyServiceFor(branch), p, userID)
+	if err != nil || apiKey == "" {
+		return err
+	}
+	branch.Header.Set("Authorization", "Bearer "+apiKey)
+	return nil
+}
+
+// proxyRecovering runs a proxy call off the handler goroutine and reports whether it aborted.
+// The proxy panics with http.ErrAbortHandler when it can't finish copying a response, which
+// only the server recovers, and only on the handler goroutine.
+func proxyRecovering(proxy func()) (aborted bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			if rec != http.ErrAbortHandler {
+				panic(rec)
+			}
+			aborted = true
+		}
+	}()
+	proxy()
+	return false
+}
+
+// mergeCompletions merges single-choice unified completions into one with their choices in order.
+func mergeCompletions(responses []*bufferedResponse) ([]byte, error) {
+	var merged map[string]any
+	var choices []any
+	var usage map[string]int64
+	for _, resp := range responses {
+		decoder := json.NewDecoder(bytes.NewReader(resp.body.Bytes()))
+		decoder.UseNumber()
+		var completion map[string]any
+		if err := decoder.Decode(&completion); err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = completion
+		}
+		branchChoices, _ := completion["choices"].([]any)
+		for _, choice := range branchChoices {
+			if c, ok := choice.(map[string]any); ok {
+				c["index"] = len(choices)
+			}
+			choices = append(choices, choice)
+		}
+		if u, ok := completion["usage"].(map[string]any); ok {
+			if usage == nil {
+				usage = make(map[string]int64)
+			}
+			for key, value := range u {
+				if num, ok := value.(json.Number); ok {
+					if v, err := num.Int64(); err == nil {
+						usage[key] += v
+					}
+				}
+			}
+		}
+	}
+	merged["choices"] = choices
+	if usage != nil {
+		merged["usage"] = usage
+	}
+	return json.Marshal(merged)
+}
+
+// withoutParam returns a JSON object body without one of its top-level parameters.
+func withoutParam(body []byte, param string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var req map[string]any
+	if err := decoder.Decode(&req); err != nil {
+		return nil, err
+	}
+	delete(req, param)
+	return json.Marshal(req)
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// FaultInjectionEnv must be set to a true value (1, true) for configured faults to be injected;
+// without it they are ignored, so a staging config can't inject faults in production by accident.
+const FaultInjectionEnv = "AI_ROUTER_FAULT_INJECTION"
+
+// FaultHeader names the fault injected into a response.
+const FaultHeader = "X-AI-Fault"
+
+// Faults injected into provider responses, as counted by the faults_injected_total metric.
+const (
+	faultLatency   = "latency"
+	faultStatus    = "status"
+	faultTruncate  = "truncate"
+	faultMalformed = "malformed"
+)
+
+// FaultInjection makes a share of the requests to a provider slow or failed, to check how
+// clients and the router's retries and fallbacks cope. Rates are shares of requests, 0-1.
+type FaultInjection struct {
+	// Added before the request is sent
+	Latency     caddy.Duration `json:"latency,omitempty"`
+	LatencyRate float64        `json:"latency_rate,omitempty"`
+	// Answered with Status (default 429) without contacting the provider
+	Status     int     `json:"status,omitempty"`
+	StatusRate float64 `json:"status_rate,omitempty"`
+	// Response body or stream cut off partway
+	TruncateRate float64 `json:"truncate_rate,omitempty"`
+	// A JSON payload of the response body or stream made invalid
+	MalformedRate float64 `json:"malformed_rate,omitempty"`
+}
+
+func faultInjectionEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(FaultInjectionEnv))
+	return enabled
+}
+
+func (f *FaultInjection) validate() error {
+	for name, rate := range map[string]float64{
+		faultLatency:   f.LatencyRate,
+		faultStatus:    f.StatusRate,
+		faultTruncate:  f.TruncateRate,
+		faultMalformed: f.MalformedRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("faults: %s rate must be between 0 and 1, got %v", name, rate)
+		}
+	}
+	if f.Status != 0 && (f.Status < 400 || f.Status > 599) {
+		return fmt.Errorf("faults: status must be an HTTP error status, got %d", f.Status)
+	}
+	return nil
+}
+
+// injectFaults wraps a provider's transport with its configured faults, if fault injection
+// is enabled.
+func (cr *AICoreRouter) injectFaults(p *ProviderConfig) {
+	if !faultInjectionEnabled() {
+		cr.logger.Warn("Ignoring provider faults; set "+FaultInjectionEnv+"=1 to inject them", zap.String("provider", p.Name))
+		return
+	}
+	base := p.proxy.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	p.proxy.Transport = &faultTransport{router: cr, provider: p, base: base}
+	cr.logger.Warn("Injecting faults into provider responses", zap.String("provider", p.Name),
+		zap.Float64("latency_rate", p.Faults.LatencyRate), zap.Float64("status_rate", p.Faults.StatusRate),
+		zap.Float64("truncate_rate", p.Faults.TruncateRate), zap.Float64("malformed_rate", p.Faults.MalformedRate))
+}
+
+type faultTransport struct {
+	router   *AICoreRouter
+	provider *ProviderConfig
+	base     http.RoundTripper
+}
+
+func (t *faultTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f := t.provider.Faults
+	if roll(f.LatencyRate) {
+		t.injected(r, faultLatency)
+		select {
+		case <-time.After(time.Duration(f.Latency)):
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+	if roll(f.StatusRate) {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		t.injected(r, faultStatus)
+		return faultStatusResponse(r, f.Status), nil
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	switch {
+	case roll(f.TruncateRate):
+		t.injected(r, faultTruncate)
+		resp.Header.Set(FaultHeader, faultTruncate)
+		limit := int64(64 + rand.Intn(1024))
+		if resp.ContentLength > 0 {
+			limit = resp.ContentLength / 2
+		}
+		resp.Body = &truncatedBody{ReadCloser: resp.Body, remaining: limit}
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	case roll(f.MalformedRate):
+		t.injected(r, faultMalformed)
+		resp.Header.Set(FaultHeader, faultMalformed)
+		resp.Body = newMalformedBody(resp.Body)
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	}
+	return resp, nil
+}
+
+func (t *faultTransport) injected(r *http.Request, fault string) {
+	faultsInjected.WithLabelValues(t.router.Name, t.provider.Name, fault).Inc()
+	t.router.requestLogger(r.Context()).Debug("Injected fault", zap.String("provider", t.provider.Name), zap.String("fault", fault))
+}
+
+func roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// faultStatusResponse is an upstream error response in the OpenAI shape.
+func faultStatusResponse(r *http.Request, status int) *http.Response {
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	body, _ := json.Marshal(map[string]any{
+		"error": map[string]any{"message": "Injected fault", "type": "api_error", "param": nil, "code": "injected_fault"},
+	})
+	header := http.Header{"Content-Type": []string{"application/json"}, FaultHeader: []string{faultStatus}}
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		header.Set("Retry-After", "1")
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
+
+// truncatedBody ends a body with an unexpected EOF after a number of bytes.
+type truncatedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// malformedBody corrupts the first JSON object of a body: a whole JSON body, or the first
+// payload of an SSE stream.
+type malformedBody struct {
+	src    *bufio.Reader
+	closer io.Closer
+	done   bool
+	out    bytes.Buffer
+}
+
+func newMalformedBody(body io.ReadCloser) *malformedBody {
+	return &malformedBody{src: bufio.NewReader(body), closer: body}
+}
+
+func (b *malformedBody) Read(p []byte) (int, error) {
+	if b.done && b.out.Len() == 0 {
+		return b.src.Read(p)
+	}
+	for !b.done && b.out.Len() == 0 {
+		line, err := b.src.ReadBytes('\n')
+		if payload := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:"))); bytes.HasPrefix(payload, []byte("{")) {
+			line = corruptJSON(line)
+			b.done = true
+		}
+		b.out.Write(line)
+		if err != nil {
+			b.done = true
+			if b.out.Len() == 0 {
+				return 0, err
+			}
+		}
+	}
+	return b.out.Read(p)
+}
+
+func (b *malformedBody) Close() error {
+	return b.closer.Close()
+}
+
+// corruptJSON doubles the first comma of a JSON payload, or drops its closing brace if it has none.
+func corruptJSON(line []byte) []byte {
+	if i := bytes.IndexByte(line, ','); i >= 0 {
+		return append(line[:i+1:i+1], line[i:]...)
+	}
+	if i := bytes.LastIndexByte(line, '}'); i >= 0 {
+		return append(line[:i:i], line[i+1:]...)
+	}
+	return line
+}
+
+// parseFaultsCaddyfile parses a provider's `faults { ... }` block.
+func parseFaultsCaddyfile(d *caddyfile.Dispenser, providerName string) (*FaultInjection, error) {
+	f := &FaultInjection{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+		args := d.RemainingArgs()
+		var rate string
+		switch option {
+		case faultLatency, faultStatus:
+			if len(args) != 2 {
+				return nil, d.ArgErr()
+			}
+			rate = args[1]
+			if option == faultLatency {
+				latency, err := caddy.ParseDuration(args[0])
+				if err != nil || latency <= 0 {
+					return nil, d.Errf("provider %s: invalid fault latency '%s'", providerName, args[0])
+				}
+				f.Latency = caddy.Duration(latency)
+			} else {
+				status, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, d.Errf("provider %s: invalid fault status '%s'", providerName, args[0])
+				}
+				f.Status = status
+			}
+		case faultTruncate, faultMalformed:
+			if len(args) != 1 {
+				return nil, d.ArgErr()
+			}
+			rate = args[0]
+		default:
+			return nil, d.Errf("provider %s: unrecognized fault '%s'", providerName, option)
+		}
+		value, err := parseRate(rate)
+		if err != nil {
+			return nil, d.Errf("provider %s: fault %s: %v", providerName, option, err)
+		}
+		switch option {
+		case faultLatency:
+			f.LatencyRate = value
+		case faultStatus:
+			f.StatusRate = value
+		case faultTruncate:
+			f.TruncateRate = value
+		case faultMalformed:
+			f.MalformedRate = value
+		}
+	}
+	if err := f.validate(); err != nil {
+		return nil, d.Errf("provider %s: %v", providerName, err)
+	}
+	return f, nil
+}
+
+// parseRate reads a share of requests given as a percentage (5%) or a fraction (0.05).
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate '%s'", s)
+	}
+	if strings.HasSuffix(s, "%") {
+		rate /= 100
+	}
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate '%s' must be between 0%% and 100%%", s)
+	}
+	return rate, nil
+}
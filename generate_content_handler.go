@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(GenerateContentHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_generate_content", parseGenerateContentHandlerCaddyfile)
+}
+
+// GenerateContentHandler serves the Google GenerativeLanguage API methods
+// `models/{model}:generateContent` and `models/{model}:streamGenerateContent`, so Gemini SDK
+// clients can use the router unmodified. The model is taken from the path and may be any
+// model name the router understands.
+type GenerateContentHandler struct {
+	Router string `json:"router,omitempty"`
+	RouteOptions
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (GenerateContentHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_generate_content",
+		New: func() caddy.Module { return new(GenerateContentHandler) },
+	}
+}
+
+func (h *GenerateContentHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	if h.routers, err = routerScopeOf(ctx); err != nil {
+		return err
+	}
+	if err := h.RouteOptions.provision(h.logger); err != nil {
+		return fmt.Errorf("ai_generate_content: %v", err)
+	}
+	return nil
+}
+
+// parseGenerateContentPath extracts the model and method from a path ending in `models/{model}:{method}`.
+func parseGenerateContentPath(path string) (model string, method string, ok bool) {
+	idx := strings.Index(path, "models/")
+	if idx == -1 {
+		return "", "", false
+	}
+	rest := path[idx+len("models/"):]
+	colon := strings.LastIndex(rest, ":")
+	if colon <= 0 {
+		return "", "", false
+	}
+	return rest[:colon], rest[colon+1:], true
+}
+
+func (h *GenerateContentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	codec := &googleIngressCodec{logger: h.logger, sse: r.URL.Query().Get("alt") == "sse"}
+
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(codec.TransformError(http.StatusInternalServerError, openAIErrorBody(ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_generate_content: router '%s' not found", routerName))))
+		return nil
+	}
+	defer cr.track()()
+
+	cr.firePageviewEvent(r)
+
+	if r.Method != http.MethodPost {
+		return next.ServeHTTP(w, r)
+	}
+
+	modelName, method, ok := parseGenerateContentPath(r.URL.Path)
+	if !ok || (method != "generateContent" && method != "streamGenerateContent") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(codec.TransformError(http.StatusNotFound, openAIErrorBody(ErrorTypeNotFound, "unknown_method", fmt.Sprintf("Unsupported GenerativeLanguage method in path '%s'", r.URL.Path))))
+		return nil
+	}
+	stream := method == "streamGenerateContent"
+
+	iw := newIngressResponseWriter(w, codec)
+	defer iw.finish()
+
+	if h.MaxRequestSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxRequestSize)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeOpenAIError(iw, http.StatusRequestEntityTooLarge, ErrorTypeInvalidRequest, "request_too_large",
+				fmt.Sprintf("Request body exceeds the maximum allowed size of %d bytes", maxBytesErr.Limit))
+			return err
+		}
+		writeOpenAIError(iw, http.StatusInternalServerError, ErrorTypeAPI, "", "Failed to read request body")
+		return err
+	}
+	r.Body.Close()
+
+	unifiedBody, err := transforms.TransformGoogleRequestToUnified(body, modelName, stream, h.logger)
+	if err != nil {
+		writeOpenAIError(iw, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid GenerateContent request body")
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(unifiedBody))
+	r.ContentLength = int64(len(unifiedBody))
+	// Gemini clients may pass `key` and `alt` as query parameters; neither is meant for the upstream.
+	// The key is kept as x-goog-api-key so passthrough providers can still forward it.
+	if key := r.URL.Query().Get("key"); key != "" && r.Header.Get("x-goog-api-key") == "" {
+		r.Header.Set("x-goog-api-key", key)
+	}
+	r.URL.RawQuery = ""
+
+	return cr.handlePostInferenceRequest(iw, r, next, cr.apiKeyServiceFor(r), &h.RouteOptions)
+}
+
+// googleIngressCodec converts unified responses back to GenerateContentResponse objects.
+// Streams are framed as SSE with `alt=sse`, and as a single JSON array otherwise, like the Google API.
+type googleIngressCodec struct {
+	logger  *zap.Logger
+	sse     bool
+	started bool
+}
+
+func (c *googleIngressCodec) TransformResponse(body []byte) ([]byte, error) {
+	return transforms.TransformUnifiedResponseToGoogle(body, c.logger)
+}
+
+func (c *googleIngressCodec) TransformError(statusCode int, body []byte) []byte {
+	return transforms.TransformOpenAIErrorToGoogle(statusCode, body)
+}
+
+func (c *googleIngressCodec) TransformChunk(data []byte) ([]byte, error) {
+	out, err := transforms.TransformUnifiedChunkToGoogle(data)
+	if err != nil || out == nil {
+		return nil, err
+	}
+	if c.sse {
+		return []byte("data: " + string(out) + "\r\n\r\n"), nil
+	}
+	prefix := ",\r\n"
+	if !c.started {
+		prefix = "["
+	}
+	c.started = true
+	return append([]byte(prefix), out...), nil
+}
+
+func (c *googleIngressCodec) FinishStream() []byte {
+	if c.sse {
+		return nil
+	}
+	if !c.started {
+		return []byte("[]")
+	}
+	return []byte("]")
+}
+
+func (c *googleIngressCodec) StreamContentType() string {
+	if c.sse {
+		return "text/event-stream"
+	}
+	return "application/json"
+}
+
+func parseGenerateContentHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var gh GenerateContentHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				gh.Router = h.Val()
+			default:
+				if ok, err := gh.RouteOptions.unmarshalCaddyfileOption(h.Dispenser); err != nil {
+					return nil, err
+				} else if !ok {
+					return nil, h.Errf("unrecognized ai_generate_content option '%s'", h.Val())
+				}
+			}
+		}
+	}
+	return &gh, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*GenerateContentHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*GenerateContentHandler)(nil)
+)
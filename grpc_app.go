@@ -0,0 +1,416 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/grpcapi"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	caddy.RegisterModule(GRPCApp{})
+	httpcaddyfile.RegisterGlobalOption("ai_grpc", parseGRPCAppCaddyfile)
+}
+
+// How long Stop waits for in-flight calls before closing them.
+const grpcStopTimeout = 30 * time.Second
+
+// GRPCApp is the `ai_grpc` app. It serves the unified chat API as the gRPC service of
+// pkg/grpcapi/unified_chat.proto on its own listener, routing calls through a router like
+// ai_chat_completions does. Incoming metadata is passed on as request headers, so key
+// passthrough works; the listener has no client authentication of its own.
+type GRPCApp struct {
+	// Network address to listen on, e.g. ":50051"
+	Listen string `json:"listen"`
+	Router string `json:"router,omitempty"`
+	RouteOptions
+
+	server  *grpc.Server
+	ctx     caddy.Context
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (GRPCApp) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "ai_grpc",
+		New: func() caddy.Module { return new(GRPCApp) },
+	}
+}
+
+func (app *GRPCApp) Provision(ctx caddy.Context) error {
+	app.ctx = ctx
+	app.logger = handlerLogger(ctx, app)
+	var err error
+	if app.routers, err = routerScopeOf(ctx); err != nil {
+		return err
+	}
+	if app.Listen == "" {
+		return fmt.Errorf("ai_grpc: listen address is required")
+	}
+	if err := app.RouteOptions.provision(app.logger); err != nil {
+		return fmt.Errorf("ai_grpc: %v", err)
+	}
+	if app.Heartbeat > 0 {
+		return fmt.Errorf("ai_grpc: heartbeat only applies to SSE endpoints")
+	}
+	return nil
+}
+
+func (app *GRPCApp) Start() error {
+	addr, err := caddy.ParseNetworkAddress(app.Listen)
+	if err != nil {
+		return fmt.Errorf("ai_grpc: invalid listen address '%s': %v", app.Listen, err)
+	}
+	ln, err := addr.Listen(app.ctx, 0, net.ListenConfig{})
+	if err != nil {
+		return fmt.Errorf("ai_grpc: listening on %s: %v", app.Listen, err)
+	}
+	listener, ok := ln.(net.Listener)
+	if !ok {
+		return fmt.Errorf("ai_grpc: %s is not a stream listener", app.Listen)
+	}
+
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(grpcapi.Codec{})}
+	if app.MaxRequestSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(int(app.MaxRequestSize)))
+	}
+	app.server = grpc.NewServer(opts...)
+	grpcapi.RegisterChatServiceServer(app.server, &grpcChatServer{app: app})
+	go func() {
+		if err := app.server.Serve(listener); err != nil {
+			app.logger.Error("gRPC server stopped", zap.Error(err))
+		}
+	}()
+	app.logger.Info("Serving unified chat API over gRPC", zap.String("listen", app.Listen))
+	return nil
+}
+
+// Stop lets in-flight calls finish for up to grpcStopTimeout.
+func (app *GRPCApp) Stop() error {
+	if app.server == nil {
+		return nil
+	}
+	stopped := make(chan struct{})
+	go func() {
+		app.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(grpcStopTimeout):
+		app.server.Stop()
+	}
+	return nil
+}
+
+// grpcChatServer bridges gRPC calls to the router's HTTP request pipeline.
+type grpcChatServer struct {
+	app *GRPCApp
+}
+
+func (s *grpcChatServer) CreateChatCompletion(ctx context.Context, req *grpcapi.UnifiedChatRequest) (*grpcapi.UnifiedChatResponse, error) {
+	w := &grpcResponseWriter{header: make(http.Header)}
+	if err := s.serve(ctx, w, req, false); err != nil {
+		return nil, err
+	}
+	grpc.SetHeader(ctx, responseMetadata(w.header))
+	if w.status >= 400 || w.status == 0 {
+		return nil, grpcStatusError(w.status, w.buf.Bytes())
+	}
+	var resp grpcapi.UnifiedChatResponse
+	if err := json.Unmarshal(w.buf.Bytes(), &resp); err != nil {
+		return nil, status.Errorf(codes.Internal, "upstream returned an invalid completion: %v", err)
+	}
+	return &resp, nil
+}
+
+func (s *grpcChatServer) StreamChatCompletion(req *grpcapi.UnifiedChatRequest, stream grpcapi.ChatService_StreamChatCompletionServer) error {
+	w := &grpcResponseWriter{
+		header: make(http.Header),
+		onStream: func(header http.Header) {
+			stream.SendHeader(responseMetadata(header))
+		},
+		onChunk: func(data []byte) error {
+			var chunk grpcapi.UnifiedChatChunk
+			if json.Unmarshal(data, &chunk) != nil {
+				return nil // Skip chunks that don't fit the unified shape rather than breaking the stream
+			}
+			return stream.Send(&chunk)
+		},
+	}
+	if err := s.serve(stream.Context(), w, req, true); err != nil {
+		return err
+	}
+	if w.streaming {
+		return w.finish()
+	}
+	if w.status >= 400 || w.status == 0 {
+		return grpcStatusError(w.status, w.buf.Bytes())
+	}
+	// A complete response to a streaming call, from a provider that doesn't stream
+	stream.SendHeader(responseMetadata(w.header))
+	var resp grpcapi.UnifiedChatResponse
+	if err := json.Unmarshal(w.buf.Bytes(), &resp); err != nil {
+		return status.Errorf(codes.Internal, "upstream returned an invalid completion: %v", err)
+	}
+	chunk := &grpcapi.UnifiedChatChunk{ID: resp.ID, Created: resp.Created, Model: resp.Model, Usage: resp.Usage}
+	for _, choice := range resp.Choices {
+		chunk.Choices = append(chunk.Choices, &grpcapi.ChunkChoice{Index: choice.Index, Delta: choice.Message, FinishReason: choice.FinishReason})
+	}
+	return stream.Send(chunk)
+}
+
+// serve runs a call through the router as a POST of its unified JSON request.
+func (s *grpcChatServer) serve(ctx context.Context, w *grpcResponseWriter, req *grpcapi.UnifiedChatRequest, stream bool) error {
+	cr, ok := s.app.routers.router(s.app.Router)
+	if !ok {
+		return status.Errorf(codes.Unavailable, "ai_grpc: router '%s' not found", s.app.Router)
+	}
+	defer cr.track()()
+
+	body, err := unifiedRequestBody(req, stream)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+	ctx = context.WithValue(ctx, caddy.ReplacerCtxKey, caddy.NewReplacer())
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for name, values := range md {
+			if strings.HasPrefix(name, ":") || strings.HasPrefix(name, "grpc-") || name == "content-type" || name == "te" {
+				continue
+			}
+			for _, value := range values {
+				r.Header.Add(name, value)
+			}
+		}
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	noop := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error { return nil })
+	proxyRecovering(func() {
+		if err := cr.handlePostInferenceRequest(w, r, noop, cr.apiKeyServiceFor(r), &s.app.RouteOptions); err != nil {
+			s.app.logger.Debug("gRPC request failed", zap.Error(err))
+		}
+	})
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return nil
+}
+
+// unifiedRequestBody converts a gRPC request to the unified JSON request, with extra_json merged in.
+func unifiedRequestBody(req *grpcapi.UnifiedChatRequest, stream bool) ([]byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var unified map[string]any
+	if err := json.Unmarshal(body, &unified); err != nil {
+		return nil, err
+	}
+	if req.ExtraJSON != "" {
+		decoder := json.NewDecoder(strings.NewReader(req.ExtraJSON))
+		decoder.UseNumber()
+		var extra map[string]any
+		if err := decoder.Decode(&extra); err != nil || extra == nil {
+			return nil, fmt.Errorf("extra_json must be a JSON object")
+		}
+		for key, value := range extra {
+			unified[key] = mergeJSONValue(unified[key], value)
+		}
+	}
+	unified["stream"] = stream
+	return json.Marshal(unified)
+}
+
+// responseMetadata carries the router's X-AI-* response headers (request ID, fallbacks, ...) to gRPC clients.
+func responseMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for name, values := range header {
+		if strings.HasPrefix(strings.ToLower(name), "x-ai-") {
+			md.Append(name, values...)
+		}
+	}
+	return md
+}
+
+// grpcStatusError converts an OpenAI-style HTTP error into a gRPC status.
+func grpcStatusError(statusCode int, body []byte) error {
+	var envelope OpenAIErrorResponse
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Message != "" {
+		message = envelope.Error.Message
+	}
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	var code codes.Code
+	switch {
+	case statusCode == 0:
+		code, message = codes.Internal, "no response from the router"
+	case statusCode == http.StatusBadRequest, statusCode == http.StatusRequestEntityTooLarge, statusCode == http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case statusCode == http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case statusCode == http.StatusForbidden:
+		code = codes.PermissionDenied
+	case statusCode == http.StatusNotFound:
+		code = codes.NotFound
+	case statusCode == http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case statusCode == http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case statusCode == http.StatusGatewayTimeout, statusCode == http.StatusRequestTimeout:
+		code = codes.DeadlineExceeded
+	case statusCode == http.StatusBadGateway, statusCode == http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, message)
+}
+
+// grpcResponseWriter captures the router's response to a gRPC call. Streams are passed to
+// onChunk one data payload at a time; anything else is buffered for the caller.
+type grpcResponseWriter struct {
+	header   http.Header
+	onStream func(http.Header)
+	onChunk  func([]byte) error
+
+	status    int
+	streaming bool
+	err       error
+	buf       bytes.Buffer
+}
+
+func (w *grpcResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcResponseWriter) WriteHeader(statusCode int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = statusCode
+	if w.onChunk != nil && statusCode < 400 && strings.HasPrefix(w.header.Get("Content-Type"), "text/event-stream") {
+		w.streaming = true
+		w.onStream(w.header)
+	}
+}
+
+func (w *grpcResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(p)
+	if w.streaming {
+		for {
+			line, err := w.buf.ReadBytes('\n')
+			if err != nil {
+				w.buf.Write(line) // Incomplete line, wait for the rest
+				break
+			}
+			if w.err = w.sendLine(line); w.err != nil {
+				return 0, w.err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Flush is a no-op: chunks are sent as soon as their line is complete.
+func (w *grpcResponseWriter) Flush() {}
+
+func (w *grpcResponseWriter) sendLine(line []byte) error {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return nil
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("[DONE]")) {
+		return nil
+	}
+	return w.onChunk(data)
+}
+
+// finish sends a trailing partial line of a stream and returns the first send error.
+func (w *grpcResponseWriter) finish() error {
+	if w.err == nil {
+		if rest, _ := io.ReadAll(&w.buf); len(bytes.TrimSpace(rest)) > 0 {
+			w.err = w.sendLine(rest)
+		}
+	}
+	return w.err
+}
+
+// parseGRPCAppCaddyfile parses the `ai_grpc { listen <addr>; router <name>; ... }` global
+// option. It also takes the route options of ai_chat_completions.
+func parseGRPCAppCaddyfile(d *caddyfile.Dispenser, existingVal any) (any, error) {
+	if existingVal != nil {
+		return nil, d.Err("ai_grpc may only be declared once")
+	}
+	app := &GRPCApp{}
+	for d.Next() {
+		if d.NextArg() {
+			app.Listen = d.Val()
+		}
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "listen":
+				if !d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				app.Listen = d.Val()
+			case "router":
+				if !d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				app.Router = d.Val()
+			default:
+				if ok, err := app.RouteOptions.unmarshalCaddyfileOption(d); err != nil {
+					return nil, err
+				} else if !ok {
+					return nil, d.Errf("unrecognized ai_grpc option '%s'", d.Val())
+				}
+			}
+		}
+	}
+	return httpcaddyfile.App{
+		Name:  "ai_grpc",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+var (
+	_ caddy.App                 = (*GRPCApp)(nil)
+	_ caddy.Provisioner         = (*GRPCApp)(nil)
+	_ grpcapi.ChatServiceServer = (*grpcChatServer)(nil)
+)
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// heartbeatComment is the SSE comment sent to keep idle streams open. Clients ignore comments,
+// and it is written past the usage tracking and transforms, which never see it.
+const heartbeatComment = ": keep-alive\n\n"
+
+// sseCommentWriter is implemented by response writers that re-encode the unified stream for
+// the client and would drop SSE comments; heartbeats are handed to the client through it.
+type sseCommentWriter interface {
+	writeSSEComment(comment string) error
+}
+
+// sseErrorWriter is implemented by response writers that frame errors for their own stream
+// format; an error answered after a heartbeat started the stream is handed to it.
+type sseErrorWriter interface {
+	writeSSEError(statusCode int, body []byte)
+}
+
+// heartbeatWriter owns the client write path of a streaming request and sends an SSE comment
+// whenever the stream has been idle for the heartbeat interval. If nothing has been written by
+// then, for instance while waiting for a queue slot or a slow upstream, it starts the SSE
+// response itself; an error answered after that is sent as a final data event.
+//
+// The handler's headers go to a map of the writer's own, copied onto the client response when
+// it's written: the heartbeat goroutine may start the response while the handler or the
+// reverse proxy are still setting headers, and a shared map would be written concurrently.
+type heartbeatWriter struct {
+	http.ResponseWriter
+	interval time.Duration
+	header   http.Header
+
+	mu          sync.Mutex
+	lastWrite   time.Time
+	wroteHeader bool
+	streaming   bool          // The client response is an SSE stream
+	committed   bool          // Started by a heartbeat rather than the response
+	failed      bool          // An error response arrived after the stream was started
+	errStatus   int           // Status code of that error response
+	errBody     bytes.Buffer  // Body of that error response
+	stop        chan struct{} // Closed by finish
+}
+
+// startHeartbeats wraps a streaming request's response writer with heartbeats.
+func startHeartbeats(w http.ResponseWriter, interval time.Duration) *heartbeatWriter {
+	hw := &heartbeatWriter{ResponseWriter: w, interval: interval, header: w.Header().Clone(), lastWrite: time.Now(), stop: make(chan struct{})}
+	go hw.run()
+	return hw
+}
+
+func (w *heartbeatWriter) run() {
+	ticker := time.NewTicker(w.interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.beat()
+		}
+	}
+}
+
+// beat sends a heartbeat if the stream has been idle long enough.
+func (w *heartbeatWriter) beat() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.stop:
+		return
+	default:
+	}
+	if time.Since(w.lastWrite) < w.interval {
+		return
+	}
+	if !w.wroteHeader {
+		// Headers set since the heartbeats started are the handler's and can't be read here;
+		// the client gets those set before, plus the stream's
+		w.wroteHeader, w.committed, w.streaming = true, true, true
+		header := w.ResponseWriter.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Del("Content-Length")
+		w.ResponseWriter.WriteHeader(http.StatusOK)
+	}
+	if !w.streaming || w.failed {
+		return
+	}
+	if cw, ok := w.ResponseWriter.(sseCommentWriter); ok {
+		cw.writeSSEComment(heartbeatComment)
+	} else {
+		w.ResponseWriter.Write([]byte(heartbeatComment))
+	}
+	w.flushLocked()
+	w.lastWrite = time.Now()
+}
+
+// Header returns the headers of the response, which the client gets when it's written unless
+// a heartbeat started the stream first.
+func (w *heartbeatWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *heartbeatWriter) WriteHeader(statusCode int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeaderLocked(statusCode)
+}
+
+func (w *heartbeatWriter) writeHeaderLocked(statusCode int) {
+	if w.committed {
+		// The client already has a 200 stream; an error can only be reported inside it
+		if statusCode >= 400 && !w.failed {
+			w.failed, w.errStatus = true, statusCode
+		}
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.streaming = statusCode < 400 && strings.HasPrefix(w.header.Get("Content-Type"), "text/event-stream")
+	// Called from the handler's goroutine, which owns w.header
+	header := w.ResponseWriter.Header()
+	for name := range header {
+		if _, ok := w.header[name]; !ok {
+			delete(header, name)
+		}
+	}
+	for name, values := range w.header {
+		header[name] = values
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *heartbeatWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.wroteHeader {
+		w.writeHeaderLocked(http.StatusOK)
+	}
+	if w.failed {
+		return w.errBody.Write(p)
+	}
+	w.lastWrite = time.Now()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *heartbeatWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.failed {
+		w.flushLocked()
+	}
+}
+
+func (w *heartbeatWriter) flushLocked() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *heartbeatWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish stops the heartbeats and reports an error that arrived after the stream was started.
+func (w *heartbeatWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.stop)
+	if !w.failed || w.errBody.Len() == 0 {
+		return
+	}
+	if ew, ok := w.ResponseWriter.(sseErrorWriter); ok {
+		ew.writeSSEError(w.errStatus, w.errBody.Bytes())
+	} else {
+		var event bytes.Buffer
+		event.WriteString("data: ")
+		if err := json.Compact(&event, w.errBody.Bytes()); err != nil {
+			return
+		}
+		event.WriteString("\n\n")
+		w.ResponseWriter.Write(event.Bytes())
+	}
+	w.flushLocked()
+}
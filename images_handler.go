@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(ImagesHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_images", parseImagesHandlerCaddyfile)
+}
+
+// ImagesHandler serves OpenAI-style image generations (POST /v1/images/generations) under
+// any path, routed to providers that can generate images.
+type ImagesHandler struct {
+	Router string `json:"router,omitempty"`
+	// Maximum request body size in bytes (0 = unlimited)
+	MaxRequestSize int64 `json:"max_request_size,omitempty"`
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (ImagesHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_images",
+		New: func() caddy.Module { return new(ImagesHandler) },
+	}
+}
+
+func (h *ImagesHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	h.routers, err = routerScopeOf(ctx)
+	return err
+}
+
+func (h *ImagesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_images: router '%s' not found", routerName))
+		return nil
+	}
+	defer cr.track()()
+
+	cr.firePageviewEvent(r)
+
+	if r.Method != http.MethodPost {
+		return next.ServeHTTP(w, r)
+	}
+	return cr.handleImagesRequest(w, r, next, cr.apiKeyServiceFor(r), h.MaxRequestSize)
+}
+
+// supportsImages reports whether a provider can generate images.
+func supportsImages(p *ProviderConfig) bool {
+	_, ok := p.Provider.(providers.ImagesProvider)
+	return ok
+}
+
+// handleImagesRequest resolves an image generation request to a provider and proxies it.
+func (cr *AICoreRouter) handleImagesRequest(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, apiKeyService auth.ExternalAPIKeyProvider, maxRequestSize int64) error {
+	r = withRequestID(w, r)
+	logger := cr.requestLogger(r.Context())
+	userID, _ := r.Context().Value(UserIDContextKeyString).(string)
+	apiKeyID, _ := r.Context().Value(ApiKeyIDContextKeyString).(string)
+
+	if maxRequestSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	}
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, ErrorTypeInvalidRequest, "request_too_large",
+				fmt.Sprintf("Request body exceeds the maximum allowed size of %d bytes", maxBytesErr.Limit))
+			return err
+		}
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Failed to read request body")
+		return err
+	}
+	r.Body.Close()
+
+	var imagesReq transforms.UnifiedImagesRequest
+	if err := json.Unmarshal(bodyBytes, &imagesReq); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request body")
+		return err
+	}
+	if imagesReq.Model == "" || imagesReq.Prompt == "" {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "missing_required_parameter", "'model' and 'prompt' fields are required in JSON request body")
+		return fmt.Errorf("'model' and 'prompt' fields are required")
+	}
+	format := imagesReq.ResponseFormat
+	if format == "" {
+		format = transforms.ImageFormatURL
+	}
+	if format != transforms.ImageFormatURL && format != transforms.ImageFormatBase64 {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_value", fmt.Sprintf("Invalid response_format '%s', expected 'url' or 'b64_json'", format))
+		return fmt.Errorf("invalid response_format %s", format)
+	}
+
+	providerName, actualModelName, err := cr.resolveRoute(w, r, imagesReq.Model, "", apiKeyService, userID, supportsImages)
+	if err != nil {
+		return err
+	}
+	cr.mu.RLock()
+	providerConfig, ok := cr.Providers[providerName]
+	cr.mu.RUnlock()
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Internal server error: provider configuration missing")
+		return fmt.Errorf("internal: provider %s not found post-resolution", providerName)
+	}
+	if !supportsImages(providerConfig) {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "unsupported_model",
+			fmt.Sprintf("Provider %s does not support image generation", providerName))
+		return fmt.Errorf("provider %s does not support image generation", providerName)
+	}
+
+	apiKey, err := cr.upstreamAPIKey(w, r, apiKeyService, providerConfig, userID)
+	if err != nil {
+		return err
+	}
+
+	reqCtx := r.Context()
+	reqCtx = context.WithValue(reqCtx, ProviderNameContextKeyString, providerName)
+	reqCtx = context.WithValue(reqCtx, ActualModelNameContextKeyString, actualModelName)
+	reqCtx = context.WithValue(reqCtx, ExternalAPIKeyProviderContextKeyString, apiKey)
+	reqCtx = context.WithValue(reqCtx, common.StreamContextKeyString, false)
+	reqCtx = context.WithValue(reqCtx, common.RequestKindContextKeyString, common.RequestKindImages)
+	reqCtx = context.WithValue(reqCtx, common.ImageResponseFormatContextKeyString, format)
+	r = r.WithContext(reqCtx)
+	r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	r.ContentLength = int64(len(bodyBytes))
+	r.Header.Set("Authorization", "Bearer "+apiKey)
+
+	if providerConfig.limiter != nil {
+		if _, err := providerConfig.limiter.acquire(reqCtx, cr.requestPriority(r)); err != nil {
+			if reqCtx.Err() != nil {
+				return nil
+			}
+			writeProviderOverloaded(w, providerConfig, err)
+			return err
+		}
+		defer providerConfig.limiter.release()
+	}
+
+	logger.Info("Routing images request",
+		zap.String("original_model", imagesReq.Model),
+		zap.String("provider", providerName),
+		zap.String("actual_model", actualModelName),
+		zap.String("user_id", userID),
+	)
+
+	start := common.CaddyClock.Now()
+	defer func() {
+		n := 1
+		if imagesReq.N != nil {
+			n = *imagesReq.N
+		}
+		cr.observability.Fire(userID, "", "images_stop", map[string]any{
+			"$ip":         r.RemoteAddr,
+			"model":       imagesReq.Model,
+			"provider":    providerName,
+			"images":      n,
+			"duration_ms": common.CaddyClock.Now().Sub(start).Milliseconds(),
+			"user_id":     userID,
+			"api_key_id":  apiKeyID,
+			"request_id":  requestID(r.Context()),
+		})
+	}()
+
+	providerConfig.proxy.ServeHTTP(w, r)
+
+	if reqCtx.Err() != nil {
+		return nil
+	}
+	return next.ServeHTTP(w, r)
+}
+
+func parseImagesHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var ih ImagesHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				ih.Router = h.Val()
+			case "max_request_size":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				size, err := humanize.ParseBytes(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid max_request_size '%s': %v", h.Val(), err)
+				}
+				ih.MaxRequestSize = int64(size)
+			default:
+				return nil, h.Errf("unrecognized ai_images option '%s'", h.Val())
+			}
+		}
+	}
+	return &ih, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*ImagesHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*ImagesHandler)(nil)
+)
@@ -4,13 +4,14 @@ import (
 	"bytes"
 	"context" // For request context
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp" // Still needed for 'next' if we keep it
-	"github.com/hbollon/go-edlib"
 	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
 	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
 	"go.uber.org/zap"
@@ -23,8 +24,19 @@ import (
 // It assumes client auth has been validated and user details are in context (if AIKeysMiddleware is used).
 // It fetches upstream API keys (if ExternalAPIKeyProvider is available) and proxies the request.
 // Transaction logging is handled by a subsequent middleware.
-func (cr *AICoreRouter) handlePostInferenceRequest(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, apiKeyService auth.ExternalAPIKeyProvider) error {
+func (cr *AICoreRouter) handlePostInferenceRequest(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, apiKeyService auth.ExternalAPIKeyProvider, opts *RouteOptions) error {
+	r = withRequestID(w, r)
+	w, r, access := cr.newAccessRecord(w, r)
+	var tracker *usageTracker
+	var bodyBytes []byte
+	defer func() {
+		setUsagePlaceholders(r, tracker)
+		cr.logAccess(r.Context(), access, tracker)
+		cr.exportTrace(r, access, tracker, bodyBytes)
+		cr.accountUsage(r, access, tracker)
+	}()
 	reqCtx := r.Context()
+	logger := cr.requestLogger(reqCtx)
 
 	userIDVal := reqCtx.Value(UserIDContextKeyString)
 	apiKeyIDVal := reqCtx.Value(ApiKeyIDContextKeyString)
@@ -32,168 +44,262 @@ func (cr *AICoreRouter) handlePostInferenceRequest(w http.ResponseWriter, r *htt
 	apiKeyID, _ := apiKeyIDVal.(string)
 
 	if apiKeyService != nil && userID == "" {
-		cr.logger.Warn("ExternalAPIKeyProvider service is available, but userID not found in context for POST request.", zap.String("path", r.URL.Path))
+		logger.Warn("ExternalAPIKeyProvider service is available, but userID not found in context for POST request.", zap.String("path", r.URL.Path))
 	}
 
+	// Request hooks buffer the body, so one they couldn't hold is turned away here rather than
+	// failing in the proxy.
+	maxRequestSize := opts.MaxRequestSize
+	if common.MaxBufferedBody > 0 && (maxRequestSize <= 0 || common.MaxBufferedBody < maxRequestSize) {
+		maxRequestSize = common.MaxBufferedBody
+	}
+	if maxRequestSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	}
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
-		cr.logger.Error("Failed to read request body for POST", zap.Error(err))
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, ErrorTypeInvalidRequest, "request_too_large",
+				fmt.Sprintf("Request body exceeds the maximum allowed size of %d bytes", maxBytesErr.Limit))
+			return err
+		}
+		logger.Error("Failed to read request body for POST", zap.Error(err))
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Failed to read request body")
 		return err
 	}
 	r.Body.Close()
 	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
+	if err := cr.validateChatRequest(w, r, bodyBytes); err != nil {
+		logger.Debug("Rejected invalid chat request", zap.Error(err))
+		return err
+	}
 	var requestPayload struct {
-		Model string `json:"model"`
+		Model         string `json:"model"`
+		Stream        bool   `json:"stream"`
+		StreamOptions *struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
 	}
 	if err := json.Unmarshal(bodyBytes, &requestPayload); err != nil {
-		cr.logger.Error("Failed to parse JSON request body for POST", zap.Error(err), zap.ByteString("body", bodyBytes))
-		http.Error(w, "Invalid JSON request body", http.StatusBadRequest)
+		logger.Error("Failed to parse JSON request body for POST", zap.Error(err), zap.ByteString("body", bodyBytes))
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request body")
 		return err
 	}
 	if requestPayload.Model == "" {
-		http.Error(w, "'model' field is required in JSON request body", http.StatusBadRequest)
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "missing_model", "'model' field is required in JSON request body")
 		return fmt.Errorf("'model' field is required")
 	}
+	requestedModel := requestPayload.Model
 
-	providerName, actualModelName := cr.resolveProviderAndModel(requestPayload.Model)
-	if actualModelName == "" {
-		http.Error(w, "Could not resolve model name", http.StatusBadRequest)
-		return fmt.Errorf("could not resolve model name for %s", requestPayload.Model)
+	if err := cr.validateRequestLimits(w, opts, bodyBytes); err != nil {
+		return err
+	}
+	var conv *conversation
+	if bodyBytes, conv, err = cr.loadConversation(w, r, bodyBytes, userID); err != nil {
+		return err
 	}
 
-	if providerName == "" {
-		// Check cache for corrected model name
-		if cachedModel, ok := cr.knownModelsCache.Load(requestPayload.Model); ok {
-			cached := cachedModel.(map[string]string)
-			actualModelName = cached["actualModelName"]
-			providerName = cached["providerName"]
-			cr.logger.Debug("Using cached model name",
-				zap.String("original_model", requestPayload.Model),
-				zap.String("cached_model", actualModelName),
-				zap.String("provider", providerName),
-			)
-		} else {
-			var providerNamesToCheck []string
-			if pNames, ok := cr.DefaultProviderForModel[requestPayload.Model]; ok {
-				providerNamesToCheck = pNames
-			} else {
-				providerNamesToCheck = cr.ProviderOrder
-			}
-
-			var foundProvider bool
-			for _, pName := range providerNamesToCheck {
-				pConfig, pOk := cr.Providers[pName]
-				if !pOk {
-					continue
-				}
-
-				apiKey := ""
-				if apiKeyService != nil {
-					providerTarget := strings.ToLower(pConfig.Name)
-					fetchedKey, keyErr := apiKeyService.GetExternalAPIKey(providerTarget, userID)
-					if keyErr != nil {
-						cr.logger.Error("Failed to fetch upstream API key", zap.Error(keyErr), zap.String("provider", providerTarget))
-						http.Error(w, "Service Unavailable: Could not retrieve API credentials.", http.StatusServiceUnavailable)
-						return keyErr
-					}
-					if fetchedKey == "" {
-						http.Error(w, "Forbidden: Upstream API credentials not found.", http.StatusForbidden)
-						return fmt.Errorf("API key not found for target %s", providerTarget)
-					}
-					apiKey = fetchedKey
-				}
-
-				availableModels, fetchErr := pConfig.Provider.FetchModels(pConfig.APIBaseURL, apiKey, cr.httpClient, cr.logger)
-				if fetchErr != nil {
-					cr.logger.Error("Failed to fetch models for initial check", zap.Error(fetchErr), zap.String("provider", pName))
-					continue
-				}
+	if bodyBytes, err = applyParamPolicy(bodyBytes, opts.Defaults, opts.Limits); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request body")
+		return err
+	}
+	if bodyBytes, err = applySystemPrompt(r, bodyBytes, opts.SystemPrompt); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request body")
+		return err
+	}
+	if len(cr.transforms) > 0 {
+		if bodyBytes, err = cr.transformRequest(w, r, bodyBytes); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(bodyBytes, &requestPayload); err != nil || requestPayload.Model == "" {
+			writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "transform_failed", "Internal server error: request transform produced an invalid request")
+			return fmt.Errorf("request transform produced an invalid request")
+		}
+		requestedModel = requestPayload.Model
+	}
+	reqCtx, bodyBytes = cr.withPinnedProviderField(reqCtx, bodyBytes)
+	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	r.ContentLength = int64(len(bodyBytes))
+	reqCtx = cr.withRequestFeatures(reqCtx, bodyBytes)
+	tags, err := cr.requestTags(r, bodyBytes)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_tags", err.Error())
+		return err
+	}
+	reqCtx = context.WithValue(reqCtx, RequestTagsContextKeyString, tags)
+	r = r.WithContext(reqCtx)
+	setTagPlaceholders(r, tags)
+	if opts.Heartbeat > 0 && requestPayload.Stream {
+		heartbeats := startHeartbeats(w, time.Duration(opts.Heartbeat))
+		defer heartbeats.finish()
+		w = heartbeats
+	}
 
-				var closestModel string
-				minDist := -1
-
-				for _, model := range availableModels {
-					modelID := model["id"].(string)
-					if modelID == "" {
-						continue
-					}
-					if !strings.Contains(modelID, requestPayload.Model) {
-						continue
-					}
-					dist := edlib.DamerauLevenshteinDistance(requestPayload.Model, modelID)
-					if minDist == -1 || dist < minDist {
-						minDist = dist
-						closestModel = modelID
-					}
-				}
+	experiment := cr.assignExperiment(requestPayload.Model, userID)
+	if experiment != nil {
+		w.Header().Set(ExperimentHeader, experiment.headerValue())
+		if experiment.arm == ExperimentArmVariant {
+			logger.Debug("Routing request to experiment variant",
+				zap.String("experiment", experiment.experiment.Name),
+				zap.String("requested_model", requestPayload.Model),
+				zap.String("variant", experiment.experiment.Variant),
+			)
+			requestPayload.Model = experiment.experiment.Variant
+		}
+	}
+	canary := cr.assignCanary(reqCtx, requestPayload.Model)
+	if canary != nil {
+		w.Header().Set(CanaryHeader, canary.headerValue())
+		if canary.arm == CanaryArmCanary {
+			logger.Debug("Routing request to canary",
+				zap.String("canary", canary.canary.Name),
+				zap.String("requested_model", requestPayload.Model),
+				zap.String("target", canary.canary.Target),
+			)
+			requestPayload.Model = canary.canary.Target
+		}
+		reqCtx = context.WithValue(reqCtx, CanaryContextKeyString, canary)
+		r = r.WithContext(reqCtx)
+	}
 
-				if closestModel != "" {
-					actualModelName = closestModel
-					providerName = pName
-					cr.knownModelsCache.Store(requestPayload.Model, map[string]string{
-						"actualModelName": closestModel,
-						"providerName":    pName,
-					})
-					cr.logger.Info("Found closest model match and cached it",
-						zap.String("requested_model", requestPayload.Model),
-						zap.String("closest_model", closestModel),
-						zap.String("provider", pName),
-					)
-					foundProvider = true
-					break
-				}
-			}
+	promptClass, classModel := cr.classifyPrompt(r, requestPayload.Model, bodyBytes, apiKeyService, userID)
+	if classModel != "" {
+		if promptClass != "" {
+			w.Header().Set(PromptClassHeader, promptClass)
+		}
+		requestPayload.Model = classModel
+	}
 
-			if !foundProvider {
-				http.Error(w, fmt.Sprintf("Could not find any provider for model: %s", requestPayload.Model), http.StatusBadRequest)
-				return fmt.Errorf("no provider found for model %s", requestPayload.Model)
-			}
+	dryRun := isDryRun(r)
+	var moderation *moderationContext
+	if opts.moderator != nil && !dryRun {
+		moderationAPIKey, keyErr := moderationKey(opts.moderator, apiKeyService, userID)
+		if keyErr != nil {
+			logger.Warn("Failed to fetch moderation API key", zap.Error(keyErr))
+		}
+		moderation = &moderationContext{moderator: opts.moderator, apiKey: moderationAPIKey, userID: userID}
+		if !cr.moderateRequest(w, r, moderation, bodyBytes) {
+			return nil
 		}
 	}
 
+	providerName, actualModelName, err := cr.resolveRoute(w, r, requestPayload.Model, cr.conversationKey(r, bodyBytes), apiKeyService, userID, nil)
+	if err != nil {
+		return err
+	}
+
 	// If not in cache, fetch models, find closest match, and cache it
 	cr.mu.RLock()
 	providerConfig, ok := cr.Providers[providerName]
 	cr.mu.RUnlock()
 	if !ok {
-		http.Error(w, "Internal server error: provider configuration missing", http.StatusInternalServerError)
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Internal server error: provider configuration missing")
 		return fmt.Errorf("internal: provider %s not found post-resolution", providerName)
 	}
 
-	apiKey := ""
-	if apiKeyService != nil {
-		providerTarget := strings.ToLower(providerConfig.Name)
-		fetchedKey, keyErr := apiKeyService.GetExternalAPIKey(providerTarget, userID)
-		if keyErr != nil {
-			cr.logger.Error("Failed to fetch upstream API key", zap.Error(keyErr), zap.String("provider", providerTarget))
-			http.Error(w, "Service Unavailable: Could not retrieve API credentials.", http.StatusServiceUnavailable)
-			return keyErr
+	bodyBytes = cr.fitConversation(w, r, conv, providerConfig, actualModelName, bodyBytes, apiKeyService, userID)
+	if bodyBytes, providerName, actualModelName, err = cr.handleContextOverflow(w, r, requestPayload.Model, providerName, actualModelName, bodyBytes, apiKeyService, userID); err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	r.ContentLength = int64(len(bodyBytes))
+	if providerName, err = cr.checkCapabilities(w, r, requestPayload.Model, providerName, actualModelName, bodyBytes, apiKeyService, userID); err != nil {
+		return err
+	}
+	cr.mu.RLock()
+	providerConfig = cr.Providers[providerName]
+	cr.mu.RUnlock()
+
+	if matchedFrom := requestPayload.Model; matchedFrom != actualModelName && !strings.HasSuffix(matchedFrom, "/"+actualModelName) {
+		w.Header().Set(MatchedModelHeader, actualModelName)
+	}
+
+	if dryRun {
+		decision := routeDecision{
+			RequestedModel: requestedModel,
+			RoutedModel:    requestPayload.Model,
+			ActualModel:    actualModelName,
+			Stream:         requestPayload.Stream,
+			PromptClass:    promptClass,
 		}
-		if fetchedKey == "" {
-			http.Error(w, "Forbidden: Upstream API credentials not found.", http.StatusForbidden)
-			return fmt.Errorf("API key not found for target %s", providerTarget)
+		if experiment != nil {
+			decision.Experiment = experiment.headerValue()
 		}
-		apiKey = fetchedKey
+		if canary != nil {
+			decision.Canary = canary.headerValue()
+		}
+		return cr.writeRouteDecision(w, r, decision, providerConfig, apiKeyService, userID, bodyBytes)
+	}
+
+	apiKey, err := cr.upstreamAPIKey(w, r, apiKeyService, providerConfig, userID)
+	if err != nil {
+		return err
 	}
 
 	reqCtx = context.WithValue(reqCtx, ProviderNameContextKeyString, providerName)
 	reqCtx = context.WithValue(reqCtx, ActualModelNameContextKeyString, actualModelName)
 	reqCtx = context.WithValue(reqCtx, ExternalAPIKeyProviderContextKeyString, apiKey)
+	reqCtx = context.WithValue(reqCtx, common.StreamContextKeyString, requestPayload.Stream)
+	if moderation != nil {
+		reqCtx = context.WithValue(reqCtx, ModerationContextKeyString, moderation)
+	}
+	if opts.StripReasoning {
+		reqCtx = context.WithValue(reqCtx, StripReasoningContextKeyString, true)
+	}
+	includeUsage := requestPayload.StreamOptions != nil && requestPayload.StreamOptions.IncludeUsage
+	reqCtx = context.WithValue(reqCtx, RouteSampleContextKeyString, &routeSample{key: latencyKey(providerName, requestPayload.Model)})
+	tracker = newUsageTracker(cr.tokenizer, cr.requestPromptTokens(bodyBytes), includeUsage)
+	reqCtx = context.WithValue(reqCtx, UsageTrackerContextKeyString, tracker)
 	r = r.WithContext(reqCtx)
 
+	var race *RaceConfig
+	var fallback *FallbackChain
+	if cr.pinnedProvider(r) == "" { // Pinned requests stay on their provider
+		race = cr.raceFor(requestPayload.Model)
+		fallback = cr.fallbackChain(requestPayload.Model)
+		if fallback == nil && cr.QualityGuard.enabled() && !requestPayload.Stream {
+			fallback = cr.guardChain(r, requestPayload.Model, providerName, actualModelName)
+		}
+	}
+	var clientKey string
+	if race != nil || fallback != nil {
+		clientKey = clientAPIKey(r) // Replaced below, but passthrough providers down the chain need it
+	}
 	r.Header.Set("Authorization", "Bearer "+apiKey)
 
 	cr.mu.RLock()
 	providerConfig, ok = cr.Providers[providerName]
 	cr.mu.RUnlock()
 	if !ok {
-		http.Error(w, "Internal server error: provider configuration missing", http.StatusInternalServerError)
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Internal server error: provider configuration missing")
 		return fmt.Errorf("internal: provider %s not found post-resolution", providerName)
 	}
 
-	cr.logger.Info("Routing POST request",
+	var queueWait time.Duration
+	if providerConfig.limiter != nil {
+		waited, err := providerConfig.limiter.acquire(reqCtx, cr.requestPriority(r))
+		if err != nil {
+			if reqCtx.Err() != nil {
+				return nil // Client gave up while queued
+			}
+			logger.Warn("Provider concurrency limit reached, rejecting request",
+				zap.String("provider", providerConfig.Name),
+				zap.Duration("queue_wait", waited),
+				zap.Error(err),
+			)
+			writeProviderOverloaded(w, providerConfig, err)
+			return err
+		}
+		defer providerConfig.limiter.release()
+		queueWait = waited
+	}
+
+	access.routed(requestedModel, providerConfig.Name, actualModelName, queueWait)
+	setRoutedPlaceholders(r, requestedModel, providerConfig.Name, actualModelName, userID)
+
+	logger.Info("Routing POST request",
 		zap.String("original_model", requestPayload.Model),
 		zap.String("provider", providerConfig.Name),
 		zap.String("actual_model", actualModelName),
@@ -201,25 +307,261 @@ func (cr *AICoreRouter) handlePostInferenceRequest(w http.ResponseWriter, r *htt
 		zap.String("api_key_id", apiKeyID),
 	)
 
-	common.FireObservabilityEvent(userID, "", "inference_start", map[string]any{
-		"$ip":        r.RemoteAddr,
-		"model":      requestPayload.Model,
-		"user_id":    userID,
-		"api_key_id": apiKeyID,
-	})
+	cr.observability.Fire(userID, "", "inference_start", canary.observabilityProps(experiment.observabilityProps(tags.observabilityProps(map[string]any{
+		"$ip":           r.RemoteAddr,
+		"model":         requestPayload.Model,
+		"queue_wait_ms": queueWait.Milliseconds(),
+		"user_id":       userID,
+		"api_key_id":    apiKeyID,
+		"request_id":    requestID(reqCtx),
+	}))))
 
 	start_time := common.CaddyClock.Now()
 	defer func() {
-		common.FireObservabilityEvent(userID, "", "inference_stop", map[string]any{
+		props := map[string]any{
 			"$ip":         r.RemoteAddr,
 			"model":       requestPayload.Model,
 			"duration_ms": common.CaddyClock.Now().Sub(start_time).Milliseconds(),
 			"user_id":     userID,
 			"api_key_id":  apiKeyID,
-		})
+			"request_id":  requestID(reqCtx),
+		}
+		promptTokens, completionTokens, estimated := tracker.snapshot()
+		props["prompt_tokens"] = promptTokens
+		props["completion_tokens"] = completionTokens
+		props["tokens_estimated"] = estimated
+		cr.observability.Fire(userID, "", "inference_stop", canary.observabilityProps(experiment.observabilityProps(tags.observabilityProps(props))))
 	}()
 
-	providerConfig.proxy.ServeHTTP(w, r)
+	// The proxy aborts the handler with http.ErrAbortHandler if the client disconnects mid-stream, so check in a defer
+	defer func() {
+		if reqCtx.Err() == nil {
+			return
+		}
+		promptTokens, completionTokens, estimated := tracker.snapshot()
+		logger.Info("Client disconnected, upstream request cancelled",
+			zap.String("provider", providerConfig.Name),
+			zap.String("actual_model", actualModelName),
+			zap.Int("completion_tokens", completionTokens),
+		)
+		cr.observability.Fire(userID, "", "inference-aborted", canary.observabilityProps(experiment.observabilityProps(tags.observabilityProps(map[string]any{
+			"$ip":               r.RemoteAddr,
+			"model":             requestPayload.Model,
+			"provider":          providerConfig.Name,
+			"duration_ms":       common.CaddyClock.Now().Sub(start_time).Milliseconds(),
+			"prompt_tokens":     promptTokens,
+			"completion_tokens": completionTokens,
+			"tokens_estimated":  estimated,
+			"user_id":           userID,
+			"api_key_id":        apiKeyID,
+			"request_id":        requestID(reqCtx),
+		}))))
+	}()
 
+	if conv != nil {
+		statusWriter := &conversationStatusWriter{ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w}}
+		defer func() { cr.recordConversation(r, conv, statusWriter.status, tracker) }()
+		w = statusWriter
+	}
+
+	switch {
+	case race != nil:
+		cr.proxyRace(w, r, race, providerConfig, bodyBytes, requestPayload.Stream, clientKey, apiKeyService, userID)
+	case fallback != nil:
+		cr.proxyWithFallback(w, r, fallback, providerConfig, bodyBytes, requestPayload.Stream, clientKey, apiKeyService, userID)
+	default:
+		cr.proxyUpstream(w, r, providerConfig, bodyBytes, requestPayload.Stream)
+	}
+
+	if reqCtx.Err() != nil {
+		return nil // Client is gone; nothing downstream can still respond
+	}
 	return next.ServeHTTP(w, r) // Call next handler in chain if any
 }
+
+// resolveRoute picks the provider and upstream model for a requested model: the first matching
+// routing rule may rewrite the model and pick its providers; explicit provider prefixes and the
+// rule's providers come first, then cached resolutions, then model matching across providers. Providers rejected by accept (if given) are skipped during matching.
+// On failure it writes an OpenAI-style error and returns a non-nil error.
+func (cr *AICoreRouter) resolveRoute(w http.ResponseWriter, r *http.Request, requestedModel string, conversationKey string, apiKeyService auth.ExternalAPIKeyProvider, userID string, accept func(*ProviderConfig) bool) (providerName string, actualModelName string, err error) {
+	logger := cr.requestLogger(r.Context())
+	tier, err := cr.resolveTier(w, r, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if tier != nil {
+		accept = tier.filter(accept)
+		r = r.WithContext(context.WithValue(r.Context(), UserTierContextKeyString, tier.name))
+	}
+	if pinned := cr.pinnedProvider(r); pinned != "" {
+		if providerName, actualModelName, err = cr.resolvePinnedRoute(w, pinned, requestedModel, accept); err != nil {
+			return "", "", err
+		}
+		logger.Debug("Using provider pinned by the client", zap.String("provider", providerName), zap.String("model", actualModelName))
+		if err := cr.checkInRotation(w, r.Context(), providerName); err != nil {
+			return "", "", err
+		}
+		if err := cr.checkModelAccess(w, requestedModel, providerName, actualModelName); err != nil {
+			return "", "", err
+		}
+		if err := cr.checkTier(w, r, tier, userID, requestedModel, providerName, actualModelName); err != nil {
+			return "", "", err
+		}
+		return providerName, actualModelName, nil
+	}
+	budget, err := cr.requestLatencyBudget(r)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_latency_budget", err.Error())
+		return "", "", err
+	}
+	accept = cr.rotationFilter(r.Context(), accept)
+	route := cr.routeModel(r, requestedModel)
+	if route.rule != "" {
+		logger.Debug("Matched routing rule", zap.String("rule", route.rule), zap.String("requested_model", requestedModel), zap.String("model", route.model))
+	}
+	if len(route.providers) > 0 {
+		if allowed := cr.providersAllowing(route.model, route.providers); allowed != nil {
+			route.providers = allowed
+		}
+		if inRotation := cr.providersInRotation(r.Context(), route.providers); inRotation != nil {
+			route.providers = inRotation
+		}
+	}
+	if budget > 0 && len(route.providers) > 0 {
+		if within := cr.pickWithinLatencyBudget(requestedModel, route.providers, budget); within != nil {
+			route.providers = within
+		}
+	}
+	providerName, actualModelName = cr.resolveProviderAndModel(r.Context(), route, conversationKey)
+	if actualModelName == "" {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "model_not_found", "Could not resolve model name")
+		return "", "", fmt.Errorf("could not resolve model name for %s", requestedModel)
+	}
+
+	if providerName == "" && cr.ModelMatching.strategy() == ModelMatchingOff {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "model_not_found", fmt.Sprintf("Could not find any provider for model: %s", requestedModel))
+		return "", "", fmt.Errorf("no provider configured for model %s and model matching is off", requestedModel)
+	}
+
+	if providerName == "" {
+		// Check cache for corrected model name
+		if cached, ok := cr.loadResolvedModel(r.Context(), route.model); ok && cr.acceptsProvider(cached.ProviderName, accept) {
+			actualModelName = cached.ActualModelName
+			providerName = cached.ProviderName
+			logger.Debug("Using cached model name",
+				zap.String("original_model", requestedModel),
+				zap.String("cached_model", actualModelName),
+				zap.String("provider", providerName),
+			)
+		} else {
+			providerNamesToCheck := route.providers
+			if len(providerNamesToCheck) == 0 {
+				providerNamesToCheck = cr.ProviderOrder
+			}
+
+			var foundProvider bool
+			for _, pName := range providerNamesToCheck {
+				cr.mu.RLock()
+				pConfig, pOk := cr.Providers[pName]
+				cr.mu.RUnlock()
+				if !pOk || (accept != nil && !accept(pConfig)) {
+					continue
+				}
+
+				apiKey, keyErr := cr.upstreamAPIKey(w, r, apiKeyService, pConfig, userID)
+				if keyErr != nil {
+					return "", "", keyErr
+				}
+				if pConfig.passthroughKey() {
+					apiKey = "" // Client keys aren't kept by the models cache
+				}
+
+				availableModels, fetchErr := cr.modelsCache.Get(pConfig, apiKey)
+				if fetchErr != nil {
+					logger.Error("Failed to fetch models for initial check", zap.Error(fetchErr), zap.String("provider", pName))
+					continue
+				}
+
+				closestModel, matched := cr.ModelMatching.matchModel(route.model, cr.allowedModels(pConfig, availableModels))
+				if matched {
+					actualModelName = closestModel
+					providerName = pName
+					cr.storeResolvedModel(r.Context(), route.model, pConfig, closestModel)
+					logger.Info("Found closest model match and cached it",
+						zap.String("requested_model", route.model),
+						zap.String("closest_model", closestModel),
+						zap.String("provider", pName),
+					)
+					foundProvider = true
+					break
+				}
+			}
+
+			if !foundProvider {
+				writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "model_not_found", fmt.Sprintf("Could not find any provider for model: %s", requestedModel))
+				return "", "", fmt.Errorf("no provider found for model %s", requestedModel)
+			}
+		}
+	}
+	if err := cr.checkInRotation(w, r.Context(), providerName); err != nil {
+		return "", "", err
+	}
+	if err := cr.checkModelAccess(w, requestedModel, providerName, actualModelName); err != nil {
+		return "", "", err
+	}
+	if err := cr.checkTier(w, r, tier, userID, requestedModel, providerName, actualModelName); err != nil {
+		return "", "", err
+	}
+	if budget > 0 && !cr.fitsLatencyBudget(providerName, requestedModel, budget) {
+		logger.Info("No provider expected to respond within the latency budget",
+			zap.String("requested_model", requestedModel),
+			zap.String("provider", providerName),
+			zap.Duration("budget", budget),
+		)
+		writeLatencyBudgetExceeded(w, requestedModel, budget)
+		return "", "", fmt.Errorf("no provider of %s expected within latency budget %s", requestedModel, budget)
+	}
+
+	return providerName, actualModelName, nil
+}
+
+// acceptsProvider reports whether a configured provider passes an optional filter.
+func (cr *AICoreRouter) acceptsProvider(name string, accept func(*ProviderConfig) bool) bool {
+	if accept == nil {
+		return true
+	}
+	cr.mu.RLock()
+	p, ok := cr.Providers[name]
+	cr.mu.RUnlock()
+	return ok && accept(p)
+}
+
+// upstreamAPIKey fetches the key for calling a provider on behalf of a user: the client's own
+// key for passthrough providers, otherwise one from its key pool (see providerAPIKey). It returns an empty key if no key
+// service is configured; on failure it writes an OpenAI-style error.
+func (cr *AICoreRouter) upstreamAPIKey(w http.ResponseWriter, r *http.Request, apiKeyService auth.ExternalAPIKeyProvider, p *ProviderConfig, userID string) (string, error) {
+	if p.passthroughKey() {
+		apiKey := clientAPIKey(r)
+		if apiKey == "" {
+			writeOpenAIError(w, http.StatusUnauthorized, ErrorTypeAuthentication, "missing_api_key",
+				fmt.Sprintf("Provider %s requires your own API key in the Authorization header.", p.Name))
+			return "", fmt.Errorf("no client API key for passthrough provider %s", p.Name)
+		}
+		return apiKey, nil
+	}
+	if (apiKeyService == nil && len(p.APIKeys) == 0) || p.keyless() {
+		return "", nil
+	}
+	providerTarget := strings.ToLower(p.Name)
+	apiKey, err := providerAPIKey(r, apiKeyService, p, userID)
+	if err != nil {
+		cr.logger.Error("Failed to fetch upstream API key", zap.Error(err), zap.String("provider", providerTarget))
+		writeOpenAIError(w, http.StatusServiceUnavailable, ErrorTypeAPI, "credentials_unavailable", "Service Unavailable: Could not retrieve API credentials.")
+		return "", err
+	}
+	if apiKey == "" {
+		writeOpenAIError(w, http.StatusForbidden, ErrorTypePermission, "credentials_not_found", "Forbidden: Upstream API credentials not found.")
+		return "", fmt.Errorf("API key not found for target %s", providerTarget)
+	}
+	return apiKey, nil
+}
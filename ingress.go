@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// ingressCodec converts unified (OpenAI-style) responses into the wire format of a
+// non-OpenAI ingress API. A codec instance serves a single request and may keep stream state.
+type ingressCodec interface {
+	// TransformResponse converts a complete unified JSON response body.
+	TransformResponse(body []byte) ([]byte, error)
+	// TransformError converts an OpenAI-style error envelope.
+	TransformError(statusCode int, body []byte) []byte
+	// TransformChunk converts one unified SSE data payload into framed output bytes.
+	TransformChunk(data []byte) ([]byte, error)
+	// FinishStream returns trailing bytes once the unified stream ends.
+	FinishStream() []byte
+	// StreamContentType is the Content-Type of converted streams.
+	StreamContentType() string
+}
+
+// passthroughCodec is implemented by codecs whose upstream may already answer in the
+// ingress wire format; once passthrough reports true, responses are written unchanged.
+type passthroughCodec interface {
+	passthrough() bool
+}
+
+// ingressResponseWriter sits between the provider proxy and the client, re-encoding
+// unified responses with an ingressCodec. Streams are converted line by line as they
+// arrive; other bodies are buffered and converted in finish.
+type ingressResponseWriter struct {
+	http.ResponseWriter
+	codec ingressCodec
+
+	statusCode  int
+	wroteHeader bool
+	streaming   bool
+	passthrough bool
+	finished    bool
+	buf         bytes.Buffer
+}
+
+func newIngressResponseWriter(w http.ResponseWriter, codec ingressCodec) *ingressResponseWriter {
+	return &ingressResponseWriter{ResponseWriter: w, codec: codec}
+}
+
+func (w *ingressResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.statusCode = statusCode
+	if pc, ok := w.codec.(passthroughCodec); ok && pc.passthrough() {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	w.Header().Del("Content-Length")
+	if statusCode < 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		w.streaming = true
+		w.Header().Set("Content-Type", w.codec.StreamContentType())
+		w.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+func (w *ingressResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf.Write(p)
+	if w.streaming {
+		if err := w.drainLines(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// drainLines converts complete SSE lines in the buffer; with final set, a trailing partial line is converted too.
+func (w *ingressResponseWriter) drainLines(final bool) error {
+	for {
+		data := w.buf.Bytes()
+		idx := bytes.IndexByte(data, '\n')
+		if idx == -1 {
+			if !final || len(data) == 0 {
+				return nil
+			}
+			idx = len(data)
+		}
+		line := strings.TrimSpace(string(data[:idx]))
+		w.buf.Next(idx + 1)
+
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "[DONE]" {
+			w.finishStream()
+			continue
+		}
+		if w.finished || payload == "" {
+			continue
+		}
+		out, err := w.codec.TransformChunk([]byte(payload))
+		if err != nil {
+			continue // Skip chunks the codec can't understand rather than breaking the stream
+		}
+		if len(out) > 0 {
+			if _, err := w.ResponseWriter.Write(out); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (w *ingressResponseWriter) finishStream() {
+	if w.finished {
+		return
+	}
+	w.finished = true
+	if out := w.codec.FinishStream(); len(out) > 0 {
+		w.ResponseWriter.Write(out)
+	}
+	w.Flush()
+}
+
+// writeSSEComment passes an SSE comment to the client of a converted SSE stream.
+func (w *ingressResponseWriter) writeSSEComment(comment string) error {
+	if !w.streaming && !w.passthrough || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write([]byte(comment))
+	return err
+}
+
+// writeSSEError ends a converted SSE stream with an error event in the ingress format.
+func (w *ingressResponseWriter) writeSSEError(statusCode int, body []byte) {
+	if !w.streaming || w.finished || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	w.finished = true
+	body = bytes.TrimSpace(w.codec.TransformError(statusCode, body))
+	w.ResponseWriter.Write([]byte("event: error\ndata: " + string(body) + "\n\n"))
+}
+
+// Flush forwards flushes for streams and passthrough responses; buffered bodies are only written in finish.
+func (w *ingressResponseWriter) Flush() {
+	if !w.streaming && !w.passthrough {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *ingressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish converts and writes any buffered response. It must be called once the proxy returns.
+func (w *ingressResponseWriter) finish() {
+	if w.passthrough {
+		return
+	}
+	if w.streaming {
+		w.drainLines(true)
+		w.finishStream()
+		return
+	}
+	if !w.wroteHeader {
+		return // Nothing was written, e.g. the request was handed to the next handler
+	}
+
+	body := w.buf.Bytes()
+	if w.statusCode >= 400 {
+		body = w.codec.TransformError(w.statusCode, body)
+	} else if transformed, err := w.codec.TransformResponse(body); err == nil {
+		body = transformed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	w.ResponseWriter.Write(body)
+}
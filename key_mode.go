@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+)
+
+// Where a provider's upstream key comes from.
+const (
+	// KeyModeGateway substitutes a key held by the gateway (the key service) for the client's credential.
+	KeyModeGateway = "gateway"
+	// KeyModePassthrough forwards the client's own key, so calls are billed to the client's provider account.
+	KeyModePassthrough = "passthrough"
+)
+
+func validateKeyMode(mode string) error {
+	switch mode {
+	case "", KeyModeGateway, KeyModePassthrough:
+		return nil
+	}
+	return fmt.Errorf("invalid key_mode '%s' (expected gateway or passthrough)", mode)
+}
+
+func (p *ProviderConfig) passthroughKey() bool {
+	return p.KeyMode == KeyModePassthrough
+}
+
+// keyless reports whether the provider answers requests itself (e.g. the mock style, or
+// replayed recordings) and so needs no upstream key.
+func (p *ProviderConfig) keyless() bool {
+	_, ok := p.Provider.(providers.TransportProvider)
+	return ok || p.Replay != ""
+}
+
+// clientAPIKey returns the provider key a client sent: a bearer token, or the x-api-key and
+// x-goog-api-key headers Anthropic and Gemini clients use.
+func clientAPIKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if token = strings.TrimSpace(token); token != "" {
+			return token
+		}
+	}
+	if key := strings.TrimSpace(r.Header.Get("x-api-key")); key != "" {
+		return key
+	}
+	return strings.TrimSpace(r.Header.Get("x-goog-api-key"))
+}
+
+// discoveryAPIKey returns the key used to look up a provider's models. Passthrough providers
+// get none: client keys aren't kept by the models cache for background refreshes, so their
+// models come from the manifest or from unauthenticated discovery.
+func discoveryAPIKey(apiKeyService auth.ExternalAPIKeyProvider, p *ProviderConfig, userID string) (string, error) {
+	if len(p.APIKeys) > 0 && !p.passthroughKey() {
+		return p.APIKeys[0], nil
+	}
+	if apiKeyService == nil || p.passthroughKey() || p.keyless() {
+		return "", nil
+	}
+	return apiKeyService.GetExternalAPIKey(strings.ToLower(p.Name), userID)
+}
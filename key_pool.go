@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+)
+
+// How a provider with several upstream keys picks one for a request.
+const (
+	// KeyRotationRoundRobin cycles through the keys in order.
+	KeyRotationRoundRobin = "round_robin"
+	// KeyRotationLeastThrottled prefers the key whose last rate limit is the longest ago.
+	KeyRotationLeastThrottled = "least_throttled"
+)
+
+// Keys that haven't been used for this long are forgotten by a pool.
+const keyPoolIdleTTL = time.Hour
+
+func validateKeyRotation(rotation string) error {
+	switch rotation {
+	case "", KeyRotationRoundRobin, KeyRotationLeastThrottled:
+		return nil
+	}
+	return fmt.Errorf("invalid key_rotation '%s' (expected round_robin or least_throttled)", rotation)
+}
+
+// keyPool spreads a provider's requests over its upstream keys. A key that gets rate limited
+// is skipped until its cool-down ends, and with a per-minute budget a key that has used it up
+// is skipped until the next minute; if no key is usable, the one free again soonest is used.
+// State is kept per Caddy instance.
+type keyPool struct {
+	rotation  string
+	perMinute int
+
+	mu        sync.Mutex
+	next      int
+	members   []string // Keys of the last pick, to tell whether a throttled key has alternates
+	keys      map[string]*pooledKey
+	lastPrune time.Time
+}
+
+type pooledKey struct {
+	coolUntil     time.Time
+	lastThrottled time.Time
+	windowStart   time.Time
+	windowCount   int
+	lastUsed      time.Time
+}
+
+func newKeyPool(p *ProviderConfig) *keyPool {
+	return &keyPool{rotation: p.KeyRotation, perMinute: p.KeyRequestsPerMinute, keys: make(map[string]*pooledKey)}
+}
+
+// stateLocked returns the state of a key, creating it. Must be called with kp.mu held.
+func (kp *keyPool) stateLocked(key string) *pooledKey {
+	state, ok := kp.keys[key]
+	if !ok {
+		state = &pooledKey{}
+		kp.keys[key] = state
+	}
+	return state
+}
+
+// freeAt is when a key may next be used: after its cool-down and, once its budget for the
+// current minute is spent, after that minute.
+func (kp *keyPool) freeAt(state *pooledKey, now time.Time) time.Time {
+	free := state.coolUntil
+	if kp.perMinute > 0 && state.windowCount >= kp.perMinute && now.Sub(state.windowStart) < time.Minute {
+		if windowEnd := state.windowStart.Add(time.Minute); windowEnd.After(free) {
+			free = windowEnd
+		}
+	}
+	return free
+}
+
+// pick chooses the key for a request and counts it against the key's budget.
+func (kp *keyPool) pick(keys []string) string {
+	if len(keys) == 1 && kp.perMinute == 0 {
+		return keys[0]
+	}
+	now := time.Now()
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	kp.pruneLocked(now)
+	kp.members = keys
+
+	start := kp.next % len(keys)
+	kp.next++
+	chosen, fallback := -1, -1
+	var fallbackFree time.Time
+	for i := range keys {
+		idx := (start + i) % len(keys)
+		state := kp.stateLocked(keys[idx])
+		if free := kp.freeAt(state, now); free.After(now) {
+			if fallback < 0 || free.Before(fallbackFree) {
+				fallback, fallbackFree = idx, free
+			}
+			continue
+		}
+		if chosen < 0 {
+			chosen = idx
+			if kp.rotation != KeyRotationLeastThrottled {
+				break
+			}
+		} else if best := kp.keys[keys[chosen]]; state.lastThrottled.Before(best.lastThrottled) ||
+			(state.lastThrottled.Equal(best.lastThrottled) && state.lastUsed.Before(best.lastUsed)) {
+			chosen = idx // Ties go to the least recently used key
+		}
+	}
+	if chosen < 0 {
+		chosen = fallback
+	}
+
+	state := kp.keys[keys[chosen]]
+	if now.Sub(state.windowStart) >= time.Minute {
+		state.windowStart, state.windowCount = now, 0
+	}
+	state.windowCount++
+	state.lastUsed = now
+	return keys[chosen]
+}
+
+// throttle puts a key on cool-down. It reports whether the pool has another key that isn't
+// cooling down, in which case the provider itself needn't be.
+func (kp *keyPool) throttle(key string, until time.Time) bool {
+	if key == "" {
+		return false
+	}
+	now := time.Now()
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	state := kp.stateLocked(key)
+	state.lastThrottled = now
+	if until.After(state.coolUntil) {
+		state.coolUntil = until
+	}
+	for _, member := range kp.members {
+		if member == key {
+			continue
+		}
+		if other, ok := kp.keys[member]; !ok || !other.coolUntil.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneLocked forgets keys idle for longer than keyPoolIdleTTL, so per-user key lists from a
+// key service don't accumulate. Must be called with kp.mu held.
+func (kp *keyPool) pruneLocked(now time.Time) {
+	if now.Sub(kp.lastPrune) < time.Minute {
+		return
+	}
+	kp.lastPrune = now
+	for key, state := range kp.keys {
+		if now.Sub(state.lastUsed) > keyPoolIdleTTL && !state.coolUntil.After(now) {
+			delete(kp.keys, key)
+		}
+	}
+}
+
+// providerAPIKey returns the upstream key for a request to a provider: the client's own key for
+// passthrough providers, otherwise one of the provider's api_keys or of the keys the key service
+// holds for it, picked by the provider's key pool. It returns an empty key if there is none.
+func providerAPIKey(r *http.Request, apiKeyService auth.ExternalAPIKeyProvider, p *ProviderConfig, userID string) (string, error) {
+	if p.passthroughKey() {
+		return clientAPIKey(r), nil
+	}
+	if p.keyless() {
+		return "", nil
+	}
+	keys := p.APIKeys
+	if len(keys) == 0 {
+		switch svc := apiKeyService.(type) {
+		case nil:
+			return "", nil
+		case auth.ExternalAPIKeyPoolProvider:
+			var err error
+			if keys, err = svc.GetExternalAPIKeys(strings.ToLower(p.Name), userID); err != nil {
+				return "", err
+			}
+		default:
+			return svc.GetExternalAPIKey(strings.ToLower(p.Name), userID)
+		}
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+	return p.keys.pick(keys), nil
+}
+
+// keyFingerprint identifies an upstream key in logs and events without revealing it.
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key_" + hex.EncodeToString(sum[:4])
+}
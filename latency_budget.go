@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// DefaultMaxLatencyHeader is the header max_latency_header reads when no name is configured.
+const DefaultMaxLatencyHeader = "X-AI-Max-Latency"
+
+// requestLatencyBudget returns the latency budget a client sent, or 0 if it sent none or the
+// router doesn't read one. Budgets are durations ("1500ms", "2s") or plain milliseconds.
+func (cr *AICoreRouter) requestLatencyBudget(r *http.Request) (time.Duration, error) {
+	if cr.MaxLatencyHeader == "" {
+		return 0, nil
+	}
+	value := strings.TrimSpace(r.Header.Get(cr.MaxLatencyHeader))
+	if value == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	budget, err := caddy.ParseDuration(value)
+	if err != nil || budget <= 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", cr.MaxLatencyHeader, value)
+	}
+	return budget, nil
+}
+
+// fitsLatencyBudget reports whether a provider is expected to serve a model within budget: its
+// rolling p95 fits, or there are too few recent samples to tell.
+func (cr *AICoreRouter) fitsLatencyBudget(providerName, model string, budget time.Duration) bool {
+	h := cr.latency.health(latencyKey(providerName, model), cr.LatencyAware.window())
+	return h.samples < latencyMinSamples || h.p95 > 0 && h.p95 <= budget
+}
+
+// pickWithinLatencyBudget narrows the candidate providers of a model to the fastest one whose
+// rolling p95 fits the budget. Without one, candidates not measured enough yet stay in, in order;
+// nil means every candidate is known to be too slow or failing.
+func (cr *AICoreRouter) pickWithinLatencyBudget(model string, candidates []string, budget time.Duration) []string {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	window := cr.LatencyAware.window()
+	best, unmeasured := "", []string{}
+	var bestP95 time.Duration
+	for _, name := range candidates {
+		if _, ok := cr.Providers[name]; !ok {
+			continue
+		}
+		h := cr.latency.health(latencyKey(name, model), window)
+		switch {
+		case h.samples < latencyMinSamples:
+			unmeasured = append(unmeasured, name)
+		case h.p95 > 0 && h.p95 <= budget && (best == "" || h.p95 < bestP95):
+			best, bestP95 = name, h.p95
+		}
+	}
+	if best != "" {
+		return []string{best}
+	}
+	if len(unmeasured) > 0 {
+		return unmeasured
+	}
+	return nil
+}
+
+// writeLatencyBudgetExceeded answers a request no provider is expected to serve within its budget.
+func writeLatencyBudgetExceeded(w http.ResponseWriter, model string, budget time.Duration) {
+	writeOpenAIError(w, http.StatusGatewayTimeout, ErrorTypeAPI, "latency_budget_exceeded",
+		fmt.Sprintf("No provider of model %s is expected to respond within %s", model, budget))
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"go.uber.org/zap"
+)
+
+// Modes of log_bodies.
+const (
+	logBodiesOff        = "off"
+	logBodiesErrorsOnly = "errors_only"
+	logBodiesSampled    = "sampled"
+)
+
+// Bytes of a body logged by default.
+const defaultLogBodiesMaxLength = 4096
+
+// BodyLogging says which upstream request and response bodies a router logs and sends as
+// observability events. Bodies are message content, so they appear nowhere unless this is set,
+// whatever `unredacted` says; credentials in them are still redacted unless secrets are kept.
+type BodyLogging struct {
+	// off, errors_only (responses with an error status and requests that failed) or sampled
+	Mode string `json:"mode"`
+	// Share of requests whose bodies are logged in sampled mode, from 0 to 1
+	SampleRate float64 `json:"sample_rate,omitempty"`
+	// Bytes of each body logged, the rest being cut off; default 4096, -1 for no limit
+	MaxLength int `json:"max_length,omitempty"`
+	// Log the SHA-256 of each body instead of its content, to correlate identical prompts
+	Hash bool `json:"hash,omitempty"`
+}
+
+func (b *BodyLogging) validate() error {
+	switch b.Mode {
+	case "", logBodiesOff, logBodiesErrorsOnly, logBodiesSampled:
+	default:
+		return fmt.Errorf("log_bodies: unknown mode '%s'; use off, errors_only or sampled", b.Mode)
+	}
+	if b.SampleRate < 0 || b.SampleRate > 1 {
+		return fmt.Errorf("log_bodies: sample rate must be between 0 and 1, got %v", b.SampleRate)
+	}
+	if b.Mode == logBodiesSampled && b.SampleRate == 0 {
+		return fmt.Errorf("log_bodies: sampled needs a rate, e.g. sampled 5%%")
+	}
+	if b.MaxLength < -1 {
+		return fmt.Errorf("log_bodies: invalid max_length %d", b.MaxLength)
+	}
+	return nil
+}
+
+func (b *BodyLogging) enabled() bool {
+	return b != nil && b.Mode != "" && b.Mode != logBodiesOff
+}
+
+func (b *BodyLogging) maxLength() int {
+	if b.MaxLength == 0 {
+		return defaultLogBodiesMaxLength
+	}
+	return b.MaxLength
+}
+
+// logBodies wraps a provider's transport so the bodies of its requests are logged as the
+// router's log_bodies says. Model discovery isn't logged.
+func (cr *AICoreRouter) logBodies(p *ProviderConfig) {
+	base := p.proxy.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	p.proxy.Transport = &bodyLoggingTransport{router: cr, provider: p.Name, base: base}
+}
+
+type bodyLoggingTransport struct {
+	router   *AICoreRouter
+	provider string
+	base     http.RoundTripper
+}
+
+func (t *bodyLoggingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	cfg := t.router.LogBodies
+	// Whether a request is logged in errors_only mode is only known once it's answered, so
+	// its body is kept either way
+	if cfg.Mode == logBodiesSampled && !roll(cfg.SampleRate) {
+		return t.base.RoundTrip(r)
+	}
+	requestBody := newBodyCapture(cfg)
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		requestBody.Write(body)
+		// The request is the transport's caller's; send a copy
+		r = r.Clone(r.Context())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		t.log(r, 0, requestBody, nil, err)
+		return nil, err
+	}
+	if cfg.Mode == logBodiesErrorsOnly && resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+	responseBody := newBodyCapture(cfg)
+	resp.Body = &loggedBody{ReadCloser: resp.Body, capture: responseBody, done: func(err error) {
+		t.log(r, resp.StatusCode, requestBody, responseBody, err)
+	}}
+	return resp, nil
+}
+
+// log writes a request's bodies to the router's log and sends them as an observability event.
+// err is the error the request or the reading of its response failed with, if any.
+func (t *bodyLoggingTransport) log(r *http.Request, status int, request, response *bodyCapture, err error) {
+	ctx := r.Context()
+	fields := []zap.Field{
+		zap.String("provider", t.provider),
+		zap.String("target_url", r.URL.Path),
+		zap.Stringer("request_body", request.logged()),
+		zap.Int64("request_bytes", request.size),
+	}
+	userID, _ := ctx.Value(UserIDContextKeyString).(string)
+	apiKeyID, _ := ctx.Value(ApiKeyIDContextKeyString).(string)
+	properties := map[string]any{
+		"provider":      t.provider,
+		"request_body":  request.logged(),
+		"request_bytes": request.size,
+		"user_id":       userID,
+		"api_key_id":    apiKeyID,
+		"request_id":    requestID(ctx),
+	}
+	if response != nil {
+		fields = append(fields, zap.Int("status", status), zap.Stringer("response_body", response.logged()), zap.Int64("response_bytes", response.size))
+		properties["status_code"] = status
+		properties["response_body"] = response.logged()
+		properties["response_bytes"] = response.size
+	}
+	if err != nil && err != io.EOF {
+		fields = append(fields, zap.Error(err))
+		properties["error"] = err.Error()
+	}
+	t.router.requestLogger(ctx).Info("Upstream request and response bodies", fields...)
+	t.router.observability.Fire(userID, "", "inference_proxy_bodies", properties)
+}
+
+// bodyCapture keeps what's logged of a body as it's read: its start, or its hash.
+type bodyCapture struct {
+	limit int
+	head  []byte
+	hash  hash.Hash
+	size  int64
+}
+
+func newBodyCapture(cfg *BodyLogging) *bodyCapture {
+	c := &bodyCapture{limit: cfg.maxLength()}
+	if cfg.Hash {
+		c.hash = sha256.New()
+	}
+	return c
+}
+
+func (c *bodyCapture) Write(p []byte) {
+	c.size += int64(len(p))
+	switch {
+	case c.hash != nil:
+		c.hash.Write(p)
+	case c.limit < 0:
+		c.head = append(c.head, p...)
+	case len(c.head) < c.limit:
+		c.head = append(c.head, p[:min(len(p), c.limit-len(c.head))]...)
+	}
+}
+
+func (c *bodyCapture) logged() common.LoggedBody {
+	if c.hash != nil {
+		return common.LoggedBody("sha256:" + hex.EncodeToString(c.hash.Sum(nil)))
+	}
+	// Cutting a body may split a character
+	logged := strings.ToValidUTF8(string(c.head), "")
+	if rest := c.size - int64(len(c.head)); rest > 0 {
+		logged += "… (" + strconv.FormatInt(rest, 10) + " more bytes)"
+	}
+	return common.LoggedBody(logged)
+}
+
+// loggedBody captures a response body as it's read, and calls done once when it ends or is
+// closed, so streams are logged whole without being held back.
+type loggedBody struct {
+	io.ReadCloser
+	capture *bodyCapture
+	done    func(err error)
+	once    sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.capture.Write(p[:n])
+	if err != nil {
+		b.once.Do(func() { b.done(err) })
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(nil) })
+	return err
+}
+
+// parseBodyLoggingCaddyfile parses `log_bodies off|errors_only|sampled <rate> [{ ... }]`.
+func parseBodyLoggingCaddyfile(d *caddyfile.Dispenser) (*BodyLogging, error) {
+	b := &BodyLogging{}
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	b.Mode = d.Val()
+	if b.Mode == logBodiesSampled {
+		if !d.NextArg() {
+			return nil, d.Errf("log_bodies sampled needs a rate, e.g. sampled 5%%")
+		}
+		rate, err := parseRate(d.Val())
+		if err != nil {
+			return nil, d.Errf("log_bodies: %v", err)
+		}
+		b.SampleRate = rate
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "max_length":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			if d.Val() == "off" {
+				b.MaxLength = -1
+				continue
+			}
+			length, err := strconv.Atoi(d.Val())
+			if err != nil || length <= 0 {
+				return nil, d.Errf("log_bodies: invalid max_length '%s'", d.Val())
+			}
+			b.MaxLength = length
+		case "hash":
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			b.Hash = true
+		default:
+			return nil, d.Errf("log_bodies: unrecognized option '%s'", d.Val())
+		}
+	}
+	if err := b.validate(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return b, nil
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// providerMaintenance takes a provider out of rotation: indefinitely while disabled, or until
+// DrainUntil while draining. Requests already in flight finish normally.
+type providerMaintenance struct {
+	Disabled   bool      `json:"disabled"`
+	DrainUntil time.Time `json:"drain_until"`
+}
+
+// active reports whether the provider is out of rotation at a point in time.
+func (m providerMaintenance) active(now time.Time) bool {
+	return m.Disabled || now.Before(m.DrainUntil)
+}
+
+// parseDrainUntil reads a drain_until timestamp (RFC 3339); empty means no drain.
+func parseDrainUntil(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid drain_until '%s', expected an RFC 3339 timestamp", s)
+	}
+	return t, nil
+}
+
+func (cr *AICoreRouter) maintenanceKey(providerName string) string {
+	return cr.storeKey("maintenance", providerName)
+}
+
+// maintenanceCacheTTL is how long a provider's maintenance state is reused before the store is
+// read again. Routing checks it several times per request and for every candidate provider;
+// changes made through another instance's admin API take this long to apply here.
+const maintenanceCacheTTL = time.Second
+
+// maintenanceCache holds recently read maintenance states by provider name.
+type maintenanceCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedMaintenance
+}
+
+type cachedMaintenance struct {
+	state     providerMaintenance
+	override  bool
+	expiresAt time.Time
+}
+
+func (c *maintenanceCache) get(name string, now time.Time) (cachedMaintenance, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	return entry, ok && now.Before(entry.expiresAt)
+}
+
+func (c *maintenanceCache) put(name string, entry cachedMaintenance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedMaintenance)
+	}
+	c.entries[name] = entry
+}
+
+func (c *maintenanceCache) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// maintenance returns a provider's maintenance state: the one set through the admin API if
+// any, otherwise its configured disabled and drain_until. The second result reports whether
+// the state comes from the admin API.
+func (cr *AICoreRouter) maintenance(ctx context.Context, p *ProviderConfig) (providerMaintenance, bool) {
+	now := time.Now()
+	if entry, ok := cr.maintenanceCache.get(p.Name, now); ok {
+		return entry.state, entry.override
+	}
+	m, override := cr.loadMaintenance(ctx, p)
+	cr.maintenanceCache.put(p.Name, cachedMaintenance{state: m, override: override, expiresAt: now.Add(maintenanceCacheTTL)})
+	return m, override
+}
+
+// loadMaintenance reads a provider's maintenance state from the router store.
+func (cr *AICoreRouter) loadMaintenance(ctx context.Context, p *ProviderConfig) (providerMaintenance, bool) {
+	configured := providerMaintenance{Disabled: p.Disabled, DrainUntil: p.drainUntil}
+	raw, ok, err := cr.store.Get(ctx, cr.maintenanceKey(p.Name))
+	if err != nil {
+		cr.requestLogger(ctx).Warn("Failed to check provider maintenance", zap.Error(err), zap.String("provider", p.Name))
+		return configured, false
+	}
+	if !ok {
+		return configured, false
+	}
+	var m providerMaintenance
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return configured, false
+	}
+	return m, true
+}
+
+// setMaintenance overrides a provider's configured maintenance state in the router store, so
+// every instance sharing it takes the provider out of (or back into) rotation. A drain that
+// only sets drain_until expires with it, after which the configured state applies again.
+func (cr *AICoreRouter) setMaintenance(ctx context.Context, p *ProviderConfig, m providerMaintenance) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if !m.Disabled && !m.DrainUntil.IsZero() {
+		if ttl = time.Until(m.DrainUntil); ttl <= 0 {
+			return cr.clearMaintenance(ctx, p)
+		}
+	}
+	defer cr.maintenanceCache.forget(p.Name)
+	return cr.store.Set(ctx, cr.maintenanceKey(p.Name), raw, ttl)
+}
+
+// clearMaintenance drops a provider's admin API override, restoring its configured state.
+func (cr *AICoreRouter) clearMaintenance(ctx context.Context, p *ProviderConfig) error {
+	defer cr.maintenanceCache.forget(p.Name)
+	return cr.store.Delete(ctx, cr.maintenanceKey(p.Name))
+}
+
+// inMaintenance reports whether a provider is out of rotation right now.
+func (cr *AICoreRouter) inMaintenance(ctx context.Context, p *ProviderConfig) bool {
+	m, _ := cr.maintenance(ctx, p)
+	return m.active(time.Now())
+}
+
+// rotationFilter narrows an optional provider filter to providers in rotation.
+func (cr *AICoreRouter) rotationFilter(ctx context.Context, accept func(*ProviderConfig) bool) func(*ProviderConfig) bool {
+	return func(p *ProviderConfig) bool {
+		return !cr.inMaintenance(ctx, p) && (accept == nil || accept(p))
+	}
+}
+
+// providersInRotation narrows providers to those in rotation, or returns nil if none are.
+func (cr *AICoreRouter) providersInRotation(ctx context.Context, providerNames []string) []string {
+	var inRotation []string
+	for _, name := range providerNames {
+		cr.mu.RLock()
+		p, ok := cr.Providers[name]
+		cr.mu.RUnlock()
+		if ok && !cr.inMaintenance(ctx, p) {
+			inRotation = append(inRotation, name)
+		}
+	}
+	return inRotation
+}
+
+// checkInRotation rejects a resolved route whose provider is disabled or draining. On failure
+// the client has been answered and the error is returned.
+func (cr *AICoreRouter) checkInRotation(w http.ResponseWriter, ctx context.Context, providerName string) error {
+	cr.mu.RLock()
+	p, ok := cr.Providers[providerName]
+	cr.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	m, _ := cr.maintenance(ctx, p)
+	if !m.active(time.Now()) {
+		return nil
+	}
+	if !m.Disabled {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(m.DrainUntil).Seconds())+1))
+	}
+	writeOpenAIError(w, http.StatusServiceUnavailable, ErrorTypeAPI, "provider_unavailable",
+		fmt.Sprintf("Provider %s is under maintenance, please retry later", providerName))
+	return fmt.Errorf("provider %s is under maintenance", providerName)
+}
+
+// inheritMaintenance carries the admin API overrides of the previous version of the router
+// over a config reload when they live in a per-version memory store.
+func (cr *AICoreRouter) inheritMaintenance(previous *AICoreRouter) {
+	if previous == nil || previous.store == nil || (cr.Storage != nil && cr.Storage.Backend != storage.BackendMemory) {
+		return
+	}
+	ctx := context.Background()
+	for name := range cr.Providers {
+		raw, ok, err := previous.store.Get(ctx, previous.maintenanceKey(name))
+		if err != nil || !ok {
+			continue
+		}
+		var m providerMaintenance
+		if json.Unmarshal(raw, &m) != nil {
+			continue
+		}
+		if err := cr.setMaintenance(ctx, cr.Providers[name], m); err != nil {
+			cr.logger.Warn("Failed to carry over provider maintenance", zap.String("provider", name), zap.Error(err))
+		}
+	}
+}
+
+// logMaintenance records a change of a provider's maintenance state made through the admin API.
+func (cr *AICoreRouter) logMaintenance(p *ProviderConfig, m providerMaintenance) {
+	fields := []zap.Field{zap.String("provider", p.Name), zap.Bool("disabled", m.Disabled), zap.Bool("in_rotation", !m.active(time.Now()))}
+	props := map[string]any{"provider": p.Name, "disabled": m.Disabled}
+	if !m.DrainUntil.IsZero() {
+		fields = append(fields, zap.Time("drain_until", m.DrainUntil))
+		props["drain_until"] = m.DrainUntil.UTC().Format(time.RFC3339)
+	}
+	cr.logger.Info("Provider maintenance changed", fields...)
+	cr.observability.Fire("system", "", "provider_maintenance", props)
+}
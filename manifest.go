@@ -0,0 +1,148 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// ManifestModel is a model declared statically for a provider. Manifest models are listed and
+// routable even when the provider's models endpoint is unreachable or doesn't exist; when live
+// discovery succeeds, manifest fields override the discovered ones.
+type ManifestModel struct {
+	ID              string `json:"id"`
+	Name            string `json:"name,omitempty"`
+	Description     string `json:"description,omitempty"`
+	ContextLength   int    `json:"context_length,omitempty"`
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty"`
+	// Completion budget of requests that set none; not listed, only sent
+	DefaultOutputTokens int      `json:"default_output_tokens,omitempty"`
+	InputModalities     []string `json:"input_modalities,omitempty"`
+	SupportedParameters []string `json:"supported_parameters,omitempty"`
+	// Declared capabilities replace the ones derived from modalities and parameters
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Typical output speed, preferred by latency_aware routing when latency stats are even
+	TokensPerSecond int    `json:"tokens_per_second,omitempty"`
+	Quantization    string `json:"quantization,omitempty"`
+}
+
+// entry renders the manifest model in the shape FetchModels returns, so it flows through
+// the same normalization as discovered models.
+func (m ManifestModel) entry() map[string]any {
+	entry := map[string]any{"id": m.ID}
+	if m.Name != "" {
+		entry["name"] = m.Name
+	}
+	if m.Description != "" {
+		entry["description"] = m.Description
+	}
+	if m.ContextLength > 0 {
+		entry["context_length"] = m.ContextLength
+	}
+	if m.MaxOutputTokens > 0 {
+		entry["top_provider"] = map[string]any{
+			"context_length":        m.ContextLength,
+			"max_completion_tokens": m.MaxOutputTokens,
+		}
+	}
+	if len(m.InputModalities) > 0 {
+		entry["architecture"] = map[string]any{"input_modalities": m.InputModalities}
+	}
+	if len(m.SupportedParameters) > 0 {
+		entry["supported_parameters"] = m.SupportedParameters
+	}
+	if len(m.Capabilities) > 0 {
+		entry["capabilities"] = m.Capabilities
+	}
+	if m.TokensPerSecond > 0 {
+		entry["tokens_per_second"] = m.TokensPerSecond
+	}
+	if m.Quantization != "" {
+		entry["quantization"] = m.Quantization
+	}
+	return entry
+}
+
+// mergeManifest overlays the provider's manifest on discovered models. Discovered models
+// missing from the manifest are kept; manifest models missing upstream are appended.
+func mergeManifest(manifest []ManifestModel, discovered []map[string]any) []map[string]any {
+	if len(manifest) == 0 {
+		return discovered
+	}
+
+	merged := make([]map[string]any, 0, len(discovered)+len(manifest))
+	index := make(map[string]int, len(discovered))
+	for _, model := range discovered {
+		if id, ok := model["id"].(string); ok {
+			index[id] = len(merged)
+		}
+		merged = append(merged, model)
+	}
+	for _, m := range manifest {
+		idx, ok := index[m.ID]
+		if !ok {
+			merged = append(merged, m.entry())
+			continue
+		}
+		combined := make(map[string]any, len(merged[idx]))
+		for k, v := range merged[idx] {
+			combined[k] = v
+		}
+		for k, v := range m.entry() {
+			combined[k] = v
+		}
+		merged[idx] = combined
+	}
+	return merged
+}
+
+// parseModelManifestCaddyfile parses a provider's `models { <id> [<key> <value>]... }` block.
+// Keys are name, description, context, max_output, default_output, modalities, params,
+// capabilities, tps and quantization; lists are comma separated.
+func parseModelManifestCaddyfile(d *caddyfile.Dispenser, providerName string) ([]ManifestModel, error) {
+	var models []ManifestModel
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		m := ManifestModel{ID: d.Val()}
+		args := d.RemainingArgs()
+		if len(args)%2 != 0 {
+			return nil, d.Errf("provider %s: model %s: expected <key> <value> pairs", providerName, m.ID)
+		}
+		for i := 0; i < len(args); i += 2 {
+			key, value := args[i], args[i+1]
+			switch key {
+			case "name":
+				m.Name = value
+			case "description":
+				m.Description = value
+			case "context", "max_output", "default_output", "tps":
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					return nil, d.Errf("provider %s: model %s: invalid %s '%s'", providerName, m.ID, key, value)
+				}
+				switch key {
+				case "context":
+					m.ContextLength = n
+				case "max_output":
+					m.MaxOutputTokens = n
+				case "default_output":
+					m.DefaultOutputTokens = n
+				default:
+					m.TokensPerSecond = n
+				}
+			case "quantization":
+				m.Quantization = value
+			case "modalities":
+				m.InputModalities = strings.Split(value, ",")
+			case "params":
+				m.SupportedParameters = strings.Split(value, ",")
+			case "capabilities":
+				m.Capabilities = strings.Split(value, ",")
+			default:
+				return nil, d.Errf("provider %s: model %s: unrecognized key '%s'", providerName, m.ID, key)
+			}
+		}
+		models = append(models, m)
+	}
+	return models, nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(MessagesHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_messages", parseMessagesHandlerCaddyfile)
+}
+
+// MessagesHandler serves the Anthropic Messages API under any path, so Anthropic-native
+// clients can use the router unmodified. Requests are converted to the unified format,
+// routed like chat completions, and responses are converted back.
+type MessagesHandler struct {
+	Router string `json:"router,omitempty"`
+	RouteOptions
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (MessagesHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_messages",
+		New: func() caddy.Module { return new(MessagesHandler) },
+	}
+}
+
+func (h *MessagesHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	if h.routers, err = routerScopeOf(ctx); err != nil {
+		return err
+	}
+	if err := h.RouteOptions.provision(h.logger); err != nil {
+		return fmt.Errorf("ai_messages: %v", err)
+	}
+	return nil
+}
+
+func (h *MessagesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	codec := &anthropicIngressCodec{logger: h.logger}
+
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(codec.TransformError(http.StatusInternalServerError, openAIErrorBody(ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_messages: router '%s' not found", routerName))))
+		return nil
+	}
+	defer cr.track()()
+
+	cr.firePageviewEvent(r)
+
+	if r.Method != http.MethodPost {
+		return next.ServeHTTP(w, r)
+	}
+
+	iw := newIngressResponseWriter(w, codec)
+	defer iw.finish()
+
+	if h.MaxRequestSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxRequestSize)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeOpenAIError(iw, http.StatusRequestEntityTooLarge, ErrorTypeInvalidRequest, "request_too_large",
+				fmt.Sprintf("Request body exceeds the maximum allowed size of %d bytes", maxBytesErr.Limit))
+			return err
+		}
+		writeOpenAIError(iw, http.StatusInternalServerError, ErrorTypeAPI, "", "Failed to read request body")
+		return err
+	}
+	r.Body.Close()
+
+	unifiedBody, err := transforms.TransformAnthropicRequestToUnified(body, h.logger)
+	if err != nil {
+		writeOpenAIError(iw, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid Anthropic Messages request body")
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(unifiedBody))
+	r.ContentLength = int64(len(unifiedBody))
+
+	return cr.handlePostInferenceRequest(iw, r, next, cr.apiKeyServiceFor(r), &h.RouteOptions)
+}
+
+// anthropicIngressCodec converts unified responses back to the Anthropic Messages format.
+type anthropicIngressCodec struct {
+	logger  *zap.Logger
+	encoder transforms.AnthropicStreamEncoder
+}
+
+func (c *anthropicIngressCodec) TransformResponse(body []byte) ([]byte, error) {
+	return transforms.TransformUnifiedResponseToAnthropic(body, c.logger)
+}
+
+func (c *anthropicIngressCodec) TransformError(statusCode int, body []byte) []byte {
+	return transforms.TransformOpenAIErrorToAnthropic(body)
+}
+
+func (c *anthropicIngressCodec) TransformChunk(data []byte) ([]byte, error) {
+	return c.encoder.Encode(data)
+}
+
+func (c *anthropicIngressCodec) FinishStream() []byte {
+	return c.encoder.Finish()
+}
+
+func (c *anthropicIngressCodec) StreamContentType() string {
+	return "text/event-stream"
+}
+
+func parseMessagesHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var mh MessagesHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				mh.Router = h.Val()
+			default:
+				if ok, err := mh.RouteOptions.unmarshalCaddyfileOption(h.Dispenser); err != nil {
+					return nil, err
+				} else if !ok {
+					return nil, h.Errf("unrecognized ai_messages option '%s'", h.Val())
+				}
+			}
+		}
+	}
+	return &mh, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*MessagesHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*MessagesHandler)(nil)
+)
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Router metrics are registered with the default Prometheus registry, which Caddy serves on its admin /metrics endpoint.
+var (
+	providerInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "caddy_ai_router",
+		Name:      "provider_in_flight_requests",
+		Help:      "Requests currently being proxied to a provider.",
+	}, []string{"router", "provider"})
+
+	providerQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "caddy_ai_router",
+		Name:      "provider_queue_depth",
+		Help:      "Requests waiting for a provider concurrency slot.",
+	}, []string{"router", "provider"})
+
+	providerQueueWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "caddy_ai_router",
+		Name:      "provider_queue_wait_seconds",
+		Help:      "Time requests waited for a provider concurrency slot.",
+		Buckets:   []float64{0.005, 0.025, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"router", "provider", "outcome"})
+
+	upstreamRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy_ai_router",
+		Name:      "upstream_retries_total",
+		Help:      "Retries of non-streaming requests after transient upstream errors.",
+	}, []string{"router", "provider", "reason"})
+
+	upstreamFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy_ai_router",
+		Name:      "upstream_fallbacks_total",
+		Help:      "Requests moved to the next model of a fallback chain, by the provider that failed.",
+	}, []string{"router", "provider", "reason"})
+
+	raceOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy_ai_router",
+		Name:      "race_contenders_total",
+		Help:      "Contenders of raced requests, by whether they won, lost (were cancelled) or failed.",
+	}, []string{"router", "provider", "outcome"})
+
+	sseRepairs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy_ai_router",
+		Name:      "sse_repairs_total",
+		Help:      "Anomalies repaired in upstream SSE streams: split, concatenated, repaired or dropped payloads.",
+	}, []string{"router", "provider", "anomaly"})
+
+	faultsInjected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy_ai_router",
+		Name:      "faults_injected_total",
+		Help:      "Faults injected into provider responses: latency, status, truncate or malformed.",
+	}, []string{"router", "provider", "fault"})
+
+	warmUps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy_ai_router",
+		Name:      "warm_ups_total",
+		Help:      "Model warm-up requests by result: ok or failed.",
+	}, []string{"router", "provider", "model", "result"})
+)
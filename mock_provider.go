@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+)
+
+// MockConfig configures a provider of style mock, which answers chat completions itself so
+// routing, failover and clients can be tested without real providers or keys.
+type MockConfig struct {
+	// Completion returned for every request; may use {model} and {prompt} (the last user message)
+	Response string `json:"response,omitempty"`
+	// Wait before responding, like an upstream's time to first token
+	Latency caddy.Duration `json:"latency,omitempty"`
+	// Wait between the chunks of a stream, one per word
+	ChunkDelay caddy.Duration `json:"chunk_delay,omitempty"`
+	// Share of requests, 0-1, failed with ErrorStatus (default 500) instead
+	ErrorRate   float64 `json:"error_rate,omitempty"`
+	ErrorStatus int     `json:"error_status,omitempty"`
+}
+
+func newMockProvider(p *ProviderConfig) providers.Provider {
+	mock := &providers.MockProvider{Response: providers.DefaultMockResponse}
+	if c := p.Mock; c != nil {
+		if c.Response != "" {
+			mock.Response = c.Response
+		}
+		mock.Latency = time.Duration(c.Latency)
+		mock.ChunkDelay = time.Duration(c.ChunkDelay)
+		mock.ErrorRate = c.ErrorRate
+		mock.ErrorStatus = c.ErrorStatus
+	}
+	return mock
+}
+
+// parseMockCaddyfile parses a provider's `mock { ... }` block.
+func parseMockCaddyfile(d *caddyfile.Dispenser, providerName string) (*MockConfig, error) {
+	c := &MockConfig{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		switch option {
+		case "response":
+			c.Response = d.Val()
+		case "latency", "chunk_delay":
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil || dur < 0 {
+				return nil, d.Errf("provider %s: invalid mock %s '%s'", providerName, option, d.Val())
+			}
+			if option == "latency" {
+				c.Latency = caddy.Duration(dur)
+			} else {
+				c.ChunkDelay = caddy.Duration(dur)
+			}
+		case "error_rate":
+			rate, err := strconv.ParseFloat(strings.TrimSuffix(d.Val(), "%"), 64)
+			if strings.HasSuffix(d.Val(), "%") {
+				rate /= 100
+			}
+			if err != nil || rate < 0 || rate > 1 {
+				return nil, d.Errf("provider %s: invalid mock error_rate '%s', expected 0-1 or a percentage", providerName, d.Val())
+			}
+			c.ErrorRate = rate
+		case "error_status":
+			status, err := strconv.Atoi(d.Val())
+			if err != nil || status < 400 || status > 599 || http.StatusText(status) == "" {
+				return nil, d.Errf("provider %s: invalid mock error_status '%s'", providerName, d.Val())
+			}
+			c.ErrorStatus = status
+		default:
+			return nil, d.Errf("provider %s: unrecognized mock option '%s'", providerName, option)
+		}
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+	}
+	return c, nil
+}
+
+// validate checks a mock config that came from JSON.
+func (c *MockConfig) validate() error {
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("mock error_rate must be between 0 and 1, got %v", c.ErrorRate)
+	}
+	if c.ErrorStatus != 0 && (c.ErrorStatus < 400 || c.ErrorStatus > 599) {
+		return fmt.Errorf("mock error_status must be an HTTP error status, got %d", c.ErrorStatus)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// modelAccess is a compiled pair of allow_models and deny_models glob lists. A model is
+// permitted if no deny pattern matches it and, when there are allow patterns, one of them does.
+type modelAccess struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+func newModelAccess(allow, deny []string) modelAccess {
+	var access modelAccess
+	for _, glob := range allow {
+		access.allow = append(access.allow, globPattern(glob))
+	}
+	for _, glob := range deny {
+		access.deny = append(access.deny, globPattern(glob))
+	}
+	return access
+}
+
+// permits reports whether the lists let a model through.
+func (a modelAccess) permits(model string) bool {
+	if anyMatch(a.deny, model) {
+		return false
+	}
+	return len(a.allow) == 0 || anyMatch(a.allow, model)
+}
+
+// modelAllowed reports whether a provider may serve an upstream model under both the
+// router's and the provider's lists.
+func (cr *AICoreRouter) modelAllowed(p *ProviderConfig, model string) bool {
+	return cr.modelAccess.permits(model) && (p == nil || p.modelAccess.permits(model))
+}
+
+// allowedModels drops the models of a provider's list it may not serve, so model matching
+// never settles on one of them.
+func (cr *AICoreRouter) allowedModels(p *ProviderConfig, models []map[string]any) []map[string]any {
+	if len(cr.modelAccess.allow)+len(cr.modelAccess.deny)+len(p.modelAccess.allow)+len(p.modelAccess.deny) == 0 {
+		return models
+	}
+	allowed := make([]map[string]any, 0, len(models))
+	for _, m := range models {
+		if id, _ := m["id"].(string); cr.modelAllowed(p, id) {
+			allowed = append(allowed, m)
+		}
+	}
+	return allowed
+}
+
+// providersAllowing narrows providers to those that may serve a model, or returns nil if none may.
+func (cr *AICoreRouter) providersAllowing(model string, providerNames []string) []string {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	var allowed []string
+	for _, name := range providerNames {
+		if cr.modelAllowed(cr.Providers[name], model) {
+			allowed = append(allowed, name)
+		}
+	}
+	return allowed
+}
+
+// checkModelAccess rejects a resolved route whose model the router or provider doesn't serve.
+// On failure the client has been answered and the error is returned.
+func (cr *AICoreRouter) checkModelAccess(w http.ResponseWriter, requestedModel, providerName, model string) error {
+	cr.mu.RLock()
+	p := cr.Providers[providerName]
+	cr.mu.RUnlock()
+	if cr.modelAllowed(p, model) {
+		return nil
+	}
+	writeOpenAIError(w, http.StatusForbidden, ErrorTypePermission, "model_not_allowed",
+		fmt.Sprintf("The model '%s' is not available", requestedModel))
+	return fmt.Errorf("model %s is not allowed on provider %s", model, providerName)
+}
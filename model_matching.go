@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hbollon/go-edlib"
+)
+
+// Model matching strategies used when a requested model has no explicit provider or default.
+const (
+	ModelMatchingOff    = "off"    // Never search provider model lists
+	ModelMatchingExact  = "exact"  // Only route to a provider listing the exact model ID
+	ModelMatchingPrefix = "prefix" // Allow model IDs starting with the requested name
+	ModelMatchingFuzzy  = "fuzzy"  // Allow model IDs containing the requested name (the default)
+)
+
+// MatchedModelHeader tells clients which model a request was routed to when it differs from the requested one.
+const MatchedModelHeader = "X-AI-Matched-Model"
+
+// ModelMatching configures how requested model names are resolved against provider model lists.
+type ModelMatching struct {
+	Strategy string `json:"strategy,omitempty"`
+	// Minimum Damerau-Levenshtein similarity (0-1) between the requested and matched names for
+	// prefix and fuzzy matches
+	MinSimilarity float64 `json:"min_similarity,omitempty"`
+}
+
+func (m *ModelMatching) strategy() string {
+	if m == nil || m.Strategy == "" {
+		return ModelMatchingFuzzy
+	}
+	return m.Strategy
+}
+
+func (m *ModelMatching) validate() error {
+	if m == nil {
+		return nil
+	}
+	switch m.strategy() {
+	case ModelMatchingOff, ModelMatchingExact, ModelMatchingPrefix, ModelMatchingFuzzy:
+	default:
+		return fmt.Errorf("unsupported model_matching strategy '%s'", m.Strategy)
+	}
+	if m.MinSimilarity < 0 || m.MinSimilarity > 1 {
+		return fmt.Errorf("model_matching min_similarity must be between 0 and 1, got %v", m.MinSimilarity)
+	}
+	return nil
+}
+
+// providerModelID returns the ID of a provider model entry; Google lists models by "models/<id>" names.
+func providerModelID(model map[string]any) string {
+	if id, ok := model["id"].(string); ok {
+		return id
+	}
+	if name, ok := model["name"].(string); ok && strings.HasPrefix(name, "models/") {
+		return strings.TrimPrefix(name, "models/")
+	}
+	return ""
+}
+
+// matchModel picks the model from a provider's list that best matches the requested name under
+// the configured strategy. An exact ID match always wins; otherwise the candidate with the
+// smallest edit distance is chosen, subject to the minimum similarity.
+func (m *ModelMatching) matchModel(requested string, models []map[string]any) (string, bool) {
+	strategy := m.strategy()
+	if strategy == ModelMatchingOff {
+		return "", false
+	}
+
+	var closest string
+	minDist := -1
+	for _, model := range models {
+		modelID := providerModelID(model)
+		if modelID == "" {
+			continue
+		}
+		if modelID == requested {
+			return modelID, true
+		}
+
+		switch strategy {
+		case ModelMatchingExact:
+			continue
+		case ModelMatchingPrefix:
+			if !strings.HasPrefix(modelID, requested) {
+				continue
+			}
+		default:
+			if !strings.Contains(modelID, requested) {
+				continue
+			}
+		}
+		if m != nil && m.MinSimilarity > 0 {
+			similarity, err := edlib.StringsSimilarity(requested, modelID, edlib.DamerauLevenshtein)
+			if err != nil || float64(similarity) < m.MinSimilarity {
+				continue
+			}
+		}
+		dist := edlib.DamerauLevenshteinDistance(requested, modelID)
+		if minDist == -1 || dist < minDist {
+			minDist = dist
+			closest = modelID
+		}
+	}
+	return closest, closest != ""
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultModelsCacheTTL         = 10 * time.Minute
+	defaultModelsCacheNegativeTTL = time.Minute
+	// How long a request waits for a first-time models fetch before treating the provider as unavailable
+	defaultModelsCacheMissWait = 2 * time.Second
+)
+
+// modelsCacheEntry holds the last models discovery result for one provider.
+type modelsCacheEntry struct {
+	models    []map[string]any
+	err       error
+	expiresAt time.Time
+	apiKey    string        // Last key used, so background refreshes can reuse it
+	inflight  chan struct{} // Non-nil while a fetch is running; closed when it finishes
+}
+
+// ModelsCache caches provider model lists with per-provider TTLs, negative caching of
+// failed fetches, and stale-while-revalidate refreshes, so discovery never blocks requests
+// for longer than a short bounded wait.
+type ModelsCache struct {
+	router *AICoreRouter
+
+	mu      sync.Mutex
+	entries map[string]*modelsCacheEntry
+	stop    chan struct{}
+}
+
+func newModelsCache(cr *AICoreRouter) *ModelsCache {
+	return &ModelsCache{
+		router:  cr,
+		entries: make(map[string]*modelsCacheEntry),
+		stop:    make(chan struct{}),
+	}
+}
+
+// ttlFor returns the effective cache TTL for a provider.
+func (mc *ModelsCache) ttlFor(p *ProviderConfig) time.Duration {
+	if p.ModelsCacheTTL > 0 {
+		return time.Duration(p.ModelsCacheTTL)
+	}
+	if mc.router.ModelsCacheTTL > 0 {
+		return time.Duration(mc.router.ModelsCacheTTL)
+	}
+	return defaultModelsCacheTTL
+}
+
+func (mc *ModelsCache) negativeTTL() time.Duration {
+	if mc.router.ModelsCacheNegativeTTL > 0 {
+		return time.Duration(mc.router.ModelsCacheNegativeTTL)
+	}
+	return defaultModelsCacheNegativeTTL
+}
+
+// Get returns the cached models for a provider. Stale entries are served immediately while
+// a refresh runs in the background; missing entries wait at most the miss wait for a fetch.
+func (mc *ModelsCache) Get(p *ProviderConfig, apiKey string) ([]map[string]any, error) {
+	now := time.Now()
+
+	mc.mu.Lock()
+	entry, ok := mc.entries[p.Name]
+	if ok && (entry.models != nil || entry.err != nil) {
+		if apiKey != "" {
+			entry.apiKey = apiKey
+		}
+		if now.After(entry.expiresAt) {
+			mc.refreshLocked(p, entry)
+		}
+		models, err := entry.models, entry.err
+		mc.mu.Unlock()
+		return models, err
+	}
+	if !ok {
+		entry = &modelsCacheEntry{apiKey: apiKey}
+		mc.entries[p.Name] = entry
+	}
+	if entry.inflight == nil {
+		mc.refreshLocked(p, entry)
+	}
+	inflight := entry.inflight
+	mc.mu.Unlock()
+
+	select {
+	case <-inflight:
+	case <-time.After(defaultModelsCacheMissWait):
+		mc.router.logger.Warn("Models discovery still in progress, skipping provider for now", zap.String("provider", p.Name))
+		return nil, fmt.Errorf("models for provider %s are not available yet", p.Name)
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return entry.models, entry.err
+}
+
+// Peek returns the cached models for a provider without fetching or waiting, or nil if none
+// have been discovered yet.
+func (mc *ModelsCache) Peek(p *ProviderConfig) []map[string]any {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if entry, ok := mc.entries[p.Name]; ok {
+		return entry.models
+	}
+	return nil
+}
+
+// refreshLocked starts a background fetch for the entry. Must be called with mc.mu held.
+func (mc *ModelsCache) refreshLocked(p *ProviderConfig, entry *modelsCacheEntry) {
+	if entry.inflight != nil {
+		return
+	}
+	done := make(chan struct{})
+	entry.inflight = done
+	apiKey := entry.apiKey
+
+	go func() {
+		var models []map[string]any
+		var ttl time.Duration
+		var err error
+		if p.DisableModelsDiscovery {
+			models, ttl = []map[string]any{}, mc.ttlFor(p)
+		} else {
+			models, ttl, err = mc.loadShared(p)
+			if models == nil {
+				client := mc.router.httpClient
+				if p.client != nil {
+					client = p.client
+				}
+				models, err = p.Provider.FetchModels(p.APIBaseURL, apiKey, client, mc.router.logger)
+				ttl = mc.ttlFor(p)
+				if err == nil {
+					mc.storeShared(p, models)
+				}
+			}
+		}
+		if err == nil {
+			models = mergeManifest(p.Models, models)
+		}
+
+		mc.mu.Lock()
+		if err != nil {
+			mc.router.logger.Error("Failed to refresh models for provider", zap.String("provider", p.Name), zap.Error(err))
+			if entry.models == nil {
+				if len(p.Models) > 0 {
+					entry.models = mergeManifest(p.Models, nil) // The static manifest stands in until discovery recovers
+				} else {
+					entry.err = err // Negative cache; keep serving stale data if we have any
+				}
+			}
+			entry.expiresAt = time.Now().Add(mc.negativeTTL())
+		} else {
+			if models == nil {
+				models = []map[string]any{}
+			}
+			entry.models = models
+			entry.err = nil
+			entry.expiresAt = time.Now().Add(ttl)
+		}
+		entry.inflight = nil
+		mc.mu.Unlock()
+		close(done)
+	}()
+}
+
+// sharedModels is the form in which discovered models are shared through the router store.
+type sharedModels struct {
+	Models    []map[string]any `json:"models"`
+	ExpiresAt time.Time        `json:"expires_at"`
+}
+
+// loadShared returns models another instance discovered recently, with their remaining TTL.
+// It returns nil models if there are none.
+func (mc *ModelsCache) loadShared(p *ProviderConfig) ([]map[string]any, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultModelsCacheMissWait)
+	defer cancel()
+	value, ok, err := mc.router.store.Get(ctx, mc.router.storeKey("models", p.Name))
+	if err != nil {
+		mc.router.logger.Warn("Failed to load shared models", zap.String("provider", p.Name), zap.Error(err))
+		return nil, 0, nil
+	}
+	if !ok {
+		return nil, 0, nil
+	}
+	var shared sharedModels
+	if err := json.Unmarshal(value, &shared); err != nil || shared.Models == nil {
+		return nil, 0, nil
+	}
+	ttl := time.Until(shared.ExpiresAt)
+	if ttl <= 0 {
+		return nil, 0, nil
+	}
+	return shared.Models, ttl, nil
+}
+
+// storeShared publishes freshly discovered models so other instances can skip the fetch.
+func (mc *ModelsCache) storeShared(p *ProviderConfig, models []map[string]any) {
+	ttl := mc.ttlFor(p)
+	value, err := json.Marshal(sharedModels{Models: models, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultModelsCacheMissWait)
+	defer cancel()
+	if err := mc.router.store.Set(ctx, mc.router.storeKey("models", p.Name), value, ttl); err != nil {
+		mc.router.logger.Warn("Failed to share discovered models", zap.String("provider", p.Name), zap.Error(err))
+	}
+}
+
+// run refreshes entries shortly before they expire until the cache is stopped.
+func (mc *ModelsCache) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mc.stop:
+			return
+		case <-ticker.C:
+			mc.router.mu.RLock()
+			providerConfigs := make(map[string]*ProviderConfig, len(mc.router.Providers))
+			for name, p := range mc.router.Providers {
+				providerConfigs[name] = p
+			}
+			mc.router.mu.RUnlock()
+
+			now := time.Now()
+			mc.mu.Lock()
+			for name, entry := range mc.entries {
+				p, ok := providerConfigs[name]
+				if !ok || p.Provider == nil {
+					continue
+				}
+				if now.Add(interval).After(entry.expiresAt) {
+					mc.refreshLocked(p, entry)
+				}
+			}
+			mc.mu.Unlock()
+		}
+	}
+}
+
+// Start launches the background refresh loop.
+func (mc *ModelsCache) Start() {
+	interval := time.Minute
+	if ttl := time.Duration(mc.router.ModelsCacheTTL); ttl > 0 && ttl/2 < interval {
+		interval = ttl / 2
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go mc.run(interval)
+}
+
+// Stop terminates the background refresh loop.
+func (mc *ModelsCache) Stop() {
+	close(mc.stop)
+}
+
+// resolvedModel is a cached fuzzy resolution of a requested model name.
+type resolvedModel struct {
+	ActualModelName string `json:"actual_model_name"`
+	ProviderName    string `json:"provider_name"`
+}
+
+// storeKey namespaces a shared storage key by router name.
+func (cr *AICoreRouter) storeKey(parts ...string) string {
+	return cr.Name + ":" + strings.Join(parts, ":")
+}
+
+// loadResolvedModel returns a cached fuzzy resolution if it hasn't expired.
+func (cr *AICoreRouter) loadResolvedModel(ctx context.Context, requestedModel string) (resolvedModel, bool) {
+	value, ok, err := cr.store.Get(ctx, cr.storeKey("resolved", cr.ModelMatching.strategy(), requestedModel))
+	if err != nil {
+		cr.logger.Warn("Failed to load cached model resolution", zap.Error(err), zap.String("model", requestedModel))
+		return resolvedModel{}, false
+	}
+	if !ok {
+		return resolvedModel{}, false
+	}
+	var resolved resolvedModel
+	if err := json.Unmarshal(value, &resolved); err != nil {
+		return resolvedModel{}, false
+	}
+	return resolved, true
+}
+
+// storeResolvedModel caches a fuzzy resolution for the provider's models cache TTL.
+func (cr *AICoreRouter) storeResolvedModel(ctx context.Context, requestedModel string, p *ProviderConfig, actualModelName string) {
+	value, _ := json.Marshal(resolvedModel{ActualModelName: actualModelName, ProviderName: p.Name})
+	if err := cr.store.Set(ctx, cr.storeKey("resolved", cr.ModelMatching.strategy(), requestedModel), value, cr.modelsCache.ttlFor(p)); err != nil {
+		cr.logger.Warn("Failed to cache model resolution", zap.Error(err), zap.String("model", requestedModel))
+	}
+}
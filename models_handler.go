@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
@@ -42,17 +43,24 @@ type ModelTopProviderDetails struct {
 // ModelInfo represents a single AI model's details.
 type ModelInfo struct {
 	ID            string `json:"id"`
+	Object        string `json:"object"`   // Always "model", for OpenAI SDK compatibility
+	OwnedBy       string `json:"owned_by"` // Router provider serving the model
 	CanonicalSlug string `json:"canonical_slug"`
 	// HuggingFaceID string                `json:"hugging_face_id,omitempty"` // Optional
-	Name          string                `json:"name"`
-	Created       int64                 `json:"created"` // Assuming Unix timestamp
-	Description   string                `json:"description"`
-	ContextLength int                   `json:"context_length"`
-	Architecture  ModelArchitectureInfo `json:"architecture"`
-	// Pricing             ModelPricingInfo        `json:"pricing"`
-	// TopProvider         ModelTopProviderDetails `json:"top_provider"`
+	Name                string                   `json:"name"`
+	Created             int64                    `json:"created"` // Assuming Unix timestamp
+	Description         string                   `json:"description"`
+	ContextLength       int                      `json:"context_length"`
+	Architecture        ModelArchitectureInfo    `json:"architecture"`
+	Pricing             *ModelPricingInfo        `json:"pricing,omitempty"`
+	TopProvider         *ModelTopProviderDetails `json:"top_provider,omitempty"`
+	SupportedParameters []string                 `json:"supported_parameters,omitempty"` // Optional
 	// PerRequestLimits    any             `json:"per_request_limits"`             // Can be null or an object, use any
-	SupportedParameters []string `json:"supported_parameters,omitempty"` // Optional
+	Providers    []string `json:"providers,omitempty"` // All router providers serving the model, if more than one
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Typical output speed and weight quantization, for providers or manifests that publish them
+	TokensPerSecond float64 `json:"tokens_per_second,omitempty"`
+	Quantization    string  `json:"quantization,omitempty"`
 }
 
 // ProviderModelsResponse is the expected response structure from a provider's /models endpoint.
@@ -76,8 +84,12 @@ type providerModelResult struct {
 func (cr *AICoreRouter) handleGetManagedModels(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, apiKeyService auth.ExternalAPIKeyProvider) error {
 	cr.mu.RLock()
 	providerConfigs := make([]*ProviderConfig, 0, len(cr.Providers))
-	for _, pCfg := range cr.Providers {
-		providerConfigs = append(providerConfigs, pCfg)
+	providerOrder := make([]string, 0, len(cr.Providers))
+	for _, name := range cr.ProviderOrder {
+		if pCfg, ok := cr.Providers[name]; ok {
+			providerConfigs = append(providerConfigs, pCfg)
+			providerOrder = append(providerOrder, name)
+		}
 	}
 	cr.mu.RUnlock()
 
@@ -96,7 +108,7 @@ func (cr *AICoreRouter) handleGetManagedModels(w http.ResponseWriter, r *http.Re
 		go func(providerConfig *ProviderConfig) {
 			defer wg.Done()
 			var apiKey string
-			if apiKeyService != nil {
+			if apiKeyService != nil && !providerConfig.keyless() {
 				fetchedKey, err := apiKeyService.GetExternalAPIKey(providerConfig.Name, "")
 				if err != nil {
 					cr.logger.Warn("Failed to get API key for provider", zap.String("provider", providerConfig.Name), zap.Error(err))
@@ -110,7 +122,7 @@ func (cr *AICoreRouter) handleGetManagedModels(w http.ResponseWriter, r *http.Re
 				return
 			}
 
-			models, err := providerConfig.Provider.FetchModels(providerConfig.APIBaseURL, apiKey, cr.httpClient, cr.logger)
+			models, err := cr.modelsCache.Get(providerConfig, apiKey)
 			if err != nil {
 				resultsChan <- providerModelResult{providerName: providerConfig.Name, err: err}
 				return
@@ -118,26 +130,15 @@ func (cr *AICoreRouter) handleGetManagedModels(w http.ResponseWriter, r *http.Re
 
 			var modelInfos []ModelInfo
 			for _, model := range models {
-				var id string
-				var name string
-				if modelID, ok := model["id"].(string); ok {
-					id = modelID
-				} else {
+				modelInfo, ok := normalizeProviderModel(model)
+				if !ok {
 					cr.logger.Warn("Model ID is not a string", zap.Any("model", model), zap.String("provider", providerConfig.Name))
 					continue
 				}
-
-				if modelName, ok := model["name"].(string); ok {
-					name = modelName
-				} else {
-					cr.logger.Warn("Model name is not a string", zap.Any("model", model), zap.String("provider", providerConfig.Name))
-					name = id // Fallback to ID if name is not available
-				}
-
-				modelInfo := ModelInfo{
-					ID:   id,
-					Name: name,
+				if !cr.modelAllowed(providerConfig, modelInfo.ID) {
+					continue
 				}
+				modelInfo.OwnedBy = providerConfig.Name
 				modelInfos = append(modelInfos, modelInfo)
 			}
 
@@ -148,20 +149,55 @@ func (cr *AICoreRouter) handleGetManagedModels(w http.ResponseWriter, r *http.Re
 	wg.Wait()
 	close(resultsChan)
 
-	allModels := []ModelInfo{}
-	uniqueModelIDs := make(map[string]bool)
-
+	modelsByProvider := make(map[string][]ModelInfo, len(providerConfigs))
 	for result := range resultsChan {
 		if result.err != nil {
 			cr.logger.Error("Failed to fetch models from provider", zap.String("provider", result.providerName), zap.Error(result.err))
 			continue
 		}
-		for _, model := range result.models {
-			if _, exists := uniqueModelIDs[model.ID]; !exists {
-				allModels = append(allModels, model)
-				uniqueModelIDs[model.ID] = true
+		modelsByProvider[result.providerName] = result.models
+	}
+
+	providerFilter := queryList(r, "provider")
+	capabilityFilter := queryList(r, "capability")
+
+	allModels := []ModelInfo{}
+	modelIndex := make(map[string]int)
+
+	// Merge in provider order so the first configured provider owns duplicated models
+	for _, providerName := range providerOrder {
+		for _, model := range modelsByProvider[providerName] {
+			if len(capabilityFilter) > 0 && !hasAll(model.Capabilities, capabilityFilter) {
+				continue
+			}
+			if idx, exists := modelIndex[model.ID]; exists {
+				existing := &allModels[idx]
+				if len(existing.Providers) == 0 {
+					existing.Providers = []string{existing.OwnedBy}
+				}
+				existing.Providers = append(existing.Providers, providerName)
+				continue
+			}
+			modelIndex[model.ID] = len(allModels)
+			allModels = append(allModels, model)
+		}
+	}
+
+	if len(providerFilter) > 0 {
+		filtered := []ModelInfo{}
+		for _, model := range allModels {
+			servedBy := model.Providers
+			if len(servedBy) == 0 {
+				servedBy = []string{model.OwnedBy}
+			}
+			for _, providerName := range servedBy {
+				if contains(providerFilter, providerName) {
+					filtered = append(filtered, model)
+					break
+				}
 			}
 		}
+		allModels = filtered
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -170,3 +206,150 @@ func (cr *AICoreRouter) handleGetManagedModels(w http.ResponseWriter, r *http.Re
 
 	return next.ServeHTTP(w, r) // Call next handler in chain if any
 }
+
+// normalizeProviderModel converts a provider's model entry to ModelInfo. Entries shaped like
+// OpenRouter's schema decode directly; OpenAI, Google and the router's own provider metadata
+// keys are mapped onto the same fields.
+func normalizeProviderModel(model map[string]any) (ModelInfo, bool) {
+	var info ModelInfo
+	if raw, err := json.Marshal(model); err == nil {
+		_ = json.Unmarshal(raw, &info)
+	}
+
+	id, ok := model["id"].(string)
+	if !ok {
+		// Google lists models as {"name": "models/gemini-pro", "displayName": ...}
+		name, nameOk := model["name"].(string)
+		if !nameOk || !strings.HasPrefix(name, "models/") {
+			return ModelInfo{}, false
+		}
+		id = strings.TrimPrefix(name, "models/")
+		info.Name = id
+	}
+	info.ID = id
+	info.Object = "model"
+	if displayName, ok := model["displayName"].(string); ok && displayName != "" {
+		info.Name = displayName
+	}
+	if info.Name == "" {
+		info.Name = id // Fallback to ID if name is not available
+	}
+	if info.CanonicalSlug == "" {
+		info.CanonicalSlug = id
+	}
+
+	if info.ContextLength == 0 {
+		info.ContextLength = intFromAny(model["inputTokenLimit"])
+	}
+	if info.TopProvider == nil {
+		if outputLimit := intFromAny(model["outputTokenLimit"]); outputLimit > 0 {
+			info.TopProvider = &ModelTopProviderDetails{ContextLength: info.ContextLength, MaxCompletionTokens: &outputLimit}
+		}
+	}
+	if len(info.Architecture.InputModalities) == 0 {
+		if inputModalities, ok := model["input_modalities"].([]string); ok {
+			info.Architecture.InputModalities = inputModalities
+		} else {
+			info.Architecture.InputModalities = []string{"text"}
+		}
+	}
+	if len(info.Architecture.OutputModalities) == 0 {
+		info.Architecture.OutputModalities = []string{"text"}
+	}
+	if info.Architecture.Modality == "" {
+		info.Architecture.Modality = strings.Join(info.Architecture.InputModalities, "+") + "->" + strings.Join(info.Architecture.OutputModalities, "+")
+	}
+	if methods, ok := model["supportedGenerationMethods"].([]any); ok && len(info.SupportedParameters) == 0 {
+		for _, method := range methods {
+			if method == "generateContent" {
+				info.SupportedParameters = []string{"max_tokens", "temperature", "top_p", "stop"}
+			}
+		}
+	}
+
+	if !declaresCapabilities(model) {
+		info.Capabilities = modelCapabilities(info)
+	}
+	return info, true
+}
+
+// declaresCapabilities reports whether a model entry lists its capabilities explicitly,
+// as static manifest models with a capabilities key do.
+func declaresCapabilities(model map[string]any) bool {
+	switch model["capabilities"].(type) {
+	case []string, []any:
+		return true
+	}
+	return false
+}
+
+// modelCapabilities derives capability tags from model metadata for filtering and request
+// validation. Streaming is assumed unless a model declares its capabilities explicitly.
+func modelCapabilities(info ModelInfo) []string {
+	capabilities := []string{CapabilityStreaming}
+	if contains(info.Architecture.InputModalities, "image") {
+		capabilities = append(capabilities, CapabilityVision)
+	}
+	if contains(info.Architecture.InputModalities, "audio") {
+		capabilities = append(capabilities, CapabilityAudio)
+	}
+	if contains(info.SupportedParameters, "tools") {
+		capabilities = append(capabilities, CapabilityTools)
+	}
+	if contains(info.SupportedParameters, "response_format") || contains(info.SupportedParameters, "structured_outputs") {
+		capabilities = append(capabilities, CapabilityJSONMode)
+	}
+	if contains(info.SupportedParameters, "reasoning") || contains(info.SupportedParameters, "include_reasoning") {
+		capabilities = append(capabilities, CapabilityReasoning)
+	}
+	return capabilities
+}
+
+// queryList returns the comma separated, lower-cased values of the given query parameters.
+func queryList(r *http.Request, keys ...string) []string {
+	var values []string
+	for _, key := range keys {
+		for _, raw := range r.URL.Query()[key] {
+			for _, v := range strings.Split(raw, ",") {
+				if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+					values = append(values, v)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAll(list []string, values []string) bool {
+	for _, v := range values {
+		if !contains(list, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// intFromAny converts numeric values from decoded JSON or provider metadata to int.
+func intFromAny(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	}
+	return 0
+}
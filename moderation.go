@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/guardrails"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+const ModerationContextKeyString string = "ai_moderation"
+
+// moderationContext carries the route moderator and its credentials to the proxy response hook.
+type moderationContext struct {
+	moderator *guardrails.Moderator
+	apiKey    string
+	userID    string
+}
+
+// moderationKey fetches the API key for the moderation endpoint if a key target is configured.
+func moderationKey(moderator *guardrails.Moderator, apiKeyService auth.ExternalAPIKeyProvider, userID string) (string, error) {
+	target := moderator.Config().KeyTarget
+	if target == "" || apiKeyService == nil {
+		return "", nil
+	}
+	return apiKeyService.GetExternalAPIKey(strings.ToLower(target), userID)
+}
+
+// unifiedRequestText flattens the messages of a unified request into moderation input.
+func unifiedRequestText(body []byte) (string, error) {
+	var unifiedReq transforms.UnifiedChatRequest
+	if err := json.Unmarshal(body, &unifiedReq); err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(unifiedReq.Messages))
+	for _, msg := range unifiedReq.Messages {
+		parts = append(parts, msg.Content)
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// unifiedResponseText flattens the choices of a unified response into moderation input.
+func unifiedResponseText(body []byte) (string, error) {
+	var unifiedResp transforms.UnifiedChatResponse
+	if err := json.Unmarshal(body, &unifiedResp); err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(unifiedResp.Choices))
+	for _, choice := range unifiedResp.Choices {
+		parts = append(parts, choice.Message.Content)
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// moderateRequest checks the unified request body against the route moderator.
+// It writes the error response itself and returns false if the request must not be proxied.
+func (cr *AICoreRouter) moderateRequest(w http.ResponseWriter, r *http.Request, mc *moderationContext, body []byte) bool {
+	logger := cr.requestLogger(r.Context())
+	text, err := unifiedRequestText(body)
+	if err != nil {
+		logger.Warn("Failed to extract moderation input from request", zap.Error(err))
+		return true
+	}
+
+	verdict, err := mc.moderator.Check(r.Context(), guardrails.StageRequest, text, body, mc.apiKey)
+	if err != nil {
+		logger.Error("Moderation check failed", zap.Error(err), zap.String("stage", guardrails.StageRequest))
+		if mc.moderator.FailClosed() {
+			writeOpenAIError(w, http.StatusServiceUnavailable, ErrorTypeAPI, "moderation_unavailable", "Service Unavailable: moderation check failed.")
+			return false
+		}
+		return true
+	}
+	if !verdict.Flagged {
+		return true
+	}
+
+	cr.observability.Fire(mc.userID, "", "moderation_flagged", map[string]any{
+		"$ip":        r.RemoteAddr,
+		"stage":      guardrails.StageRequest,
+		"categories": verdict.Categories,
+		"blocked":    mc.moderator.Blocks(),
+		"user_id":    mc.userID,
+		"request_id": requestID(r.Context()),
+	})
+
+	if mc.moderator.Blocks() {
+		w.Header().Set("X-AI-Moderation", "blocked")
+		writeOpenAIError(w, http.StatusForbidden, ErrorTypePermission, "content_filter", "Request blocked by moderation: "+verdict.Reason)
+		return false
+	}
+	w.Header().Set("X-AI-Moderation", "flagged")
+	return true
+}
+
+// moderateResponse checks a non-streaming unified response against the route moderator, if any.
+func (cr *AICoreRouter) moderateResponse(resp *http.Response) error {
+	logger := cr.requestLogger(resp.Request.Context())
+	mc, ok := resp.Request.Context().Value(ModerationContextKeyString).(*moderationContext)
+	if !ok || mc == nil || !mc.moderator.Config().CheckResponse {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		logger.Debug("Skipping response moderation for non-JSON or unsuccessful response", zap.Int("status_code", resp.StatusCode))
+		return nil
+	}
+
+	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+		text, err := unifiedResponseText(body)
+		if err != nil {
+			logger.Warn("Failed to extract moderation input from response", zap.Error(err))
+			return body, nil
+		}
+
+		verdict, err := mc.moderator.Check(resp.Request.Context(), guardrails.StageResponse, text, body, mc.apiKey)
+		if err != nil {
+			logger.Error("Moderation check failed", zap.Error(err), zap.String("stage", guardrails.StageResponse))
+			if mc.moderator.FailClosed() {
+				resp.StatusCode = http.StatusServiceUnavailable
+				resp.Header.Set("Content-Type", "application/json")
+				return openAIErrorBody(ErrorTypeAPI, "moderation_unavailable", "Service Unavailable: moderation check failed."), nil
+			}
+			return body, nil
+		}
+		if !verdict.Flagged {
+			return body, nil
+		}
+
+		cr.observability.Fire(mc.userID, "", "moderation_flagged", map[string]any{
+			"$ip":        resp.Request.RemoteAddr,
+			"stage":      guardrails.StageResponse,
+			"categories": verdict.Categories,
+			"blocked":    mc.moderator.Blocks(),
+			"user_id":    mc.userID,
+			"request_id": requestID(resp.Request.Context()),
+		})
+
+		if mc.moderator.Blocks() {
+			resp.StatusCode = http.StatusForbidden
+			resp.Header.Set("X-AI-Moderation", "blocked")
+			resp.Header.Set("Content-Type", "application/json")
+			return openAIErrorBody(ErrorTypePermission, "content_filter", "Response blocked by moderation: "+verdict.Reason), nil
+		}
+		resp.Header.Set("X-AI-Moderation", "flagged")
+		return body, nil
+	})
+}
+
+// parseModerationCaddyfile parses a `moderation { ... }` block.
+func parseModerationCaddyfile(d *caddyfile.Dispenser) (*guardrails.ModerationConfig, error) {
+	cfg := &guardrails.ModerationConfig{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "endpoint":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Endpoint = d.Val()
+		case "style":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Style = strings.ToLower(d.Val())
+		case "model":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Model = d.Val()
+		case "key_target":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.KeyTarget = strings.ToLower(d.Val())
+		case "action":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Action = strings.ToLower(d.Val())
+		case "fail_mode":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.FailMode = strings.ToLower(d.Val())
+		case "check_response":
+			cfg.CheckResponse = true
+		case "timeout":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid moderation timeout '%s': %v", d.Val(), err)
+			}
+			cfg.Timeout = caddy.Duration(dur)
+		default:
+			return nil, d.Errf("unrecognized moderation option '%s'", d.Val())
+		}
+	}
+	if cfg.Endpoint == "" {
+		return nil, d.Err("moderation: endpoint is required")
+	}
+	return cfg, nil
+}
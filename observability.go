@@ -0,0 +1,78 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+)
+
+// parseObservabilityCaddyfile parses an `observability { sink <type> ... }` block.
+func parseObservabilityCaddyfile(d *caddyfile.Dispenser) (*common.ObservabilityConfig, error) {
+	cfg := &common.ObservabilityConfig{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "sink":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Sink = strings.ToLower(d.Val())
+			if d.NextArg() {
+				cfg.URL = d.Val()
+			}
+		case "url":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.URL = d.Val()
+		case "api_key":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.APIKey = d.Val()
+		case "topic":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Topic = d.Val()
+		case "header":
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				return nil, d.ArgErr()
+			}
+			if cfg.Headers == nil {
+				cfg.Headers = make(map[string]string)
+			}
+			cfg.Headers[args[0]] = args[1]
+		case "sample_rate":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			rate, err := strconv.ParseFloat(d.Val(), 64)
+			if err != nil {
+				return nil, d.Errf("invalid sample_rate '%s'", d.Val())
+			}
+			cfg.SampleRate = rate
+		case "properties":
+			cfg.Properties = append(cfg.Properties, d.RemainingArgs()...)
+		case "exclude_properties":
+			cfg.ExcludeProperties = append(cfg.ExcludeProperties, d.RemainingArgs()...)
+		case "unredacted":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			cfg.Unredacted = append(cfg.Unredacted, args...)
+		default:
+			return nil, d.Errf("unrecognized observability option '%s'", d.Val())
+		}
+	}
+	if cfg.Sink == "" {
+		return nil, d.Err("observability requires a sink")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return cfg, nil
+}
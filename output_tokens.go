@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"go.uber.org/zap"
+)
+
+// outputTokens returns the completion budget policy of a model on the provider: the budget set
+// when the client sends none, and the largest one sent. The model's manifest entry wins over the
+// provider's settings; 0 means none.
+func (p *ProviderConfig) outputTokens(modelName string) (defaultTokens, maxTokens int) {
+	defaultTokens, maxTokens = p.DefaultOutputTokens, p.MaxOutputTokens
+	for _, m := range p.Models {
+		if m.ID != modelName {
+			continue
+		}
+		if m.DefaultOutputTokens > 0 {
+			defaultTokens = m.DefaultOutputTokens
+		}
+		if m.MaxOutputTokens > 0 {
+			maxTokens = m.MaxOutputTokens
+		}
+		break
+	}
+	if maxTokens > 0 && defaultTokens > maxTokens {
+		defaultTokens = maxTokens
+	}
+	return defaultTokens, maxTokens
+}
+
+// applyOutputTokens fills in the completion budget of a unified chat request that has none and
+// lowers one above the model's maximum, before the provider converts the request. Budgets are
+// sent as max_tokens, which every style takes; max_completion_tokens is clamped where sent.
+func (p *ProviderConfig) applyOutputTokens(r *http.Request, modelName string, logger *zap.Logger) {
+	if passthrough, ok := r.Context().Value(common.ResponsesPassthroughContextKeyString).(*common.ResponsesPassthrough); ok && passthrough != nil && passthrough.Native {
+		return // Native Responses API requests name it max_output_tokens
+	}
+	defaultTokens, maxTokens := p.outputTokens(modelName)
+	if (defaultTokens == 0 && maxTokens == 0) || r.Body == nil {
+		return
+	}
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var req map[string]any
+		if decoder.Decode(&req) != nil || req == nil {
+			return body, nil
+		}
+		changed := false
+		if req["max_tokens"] == nil && req["max_completion_tokens"] == nil && defaultTokens > 0 {
+			req["max_tokens"] = defaultTokens
+			changed = true
+		}
+		if maxTokens > 0 {
+			for _, param := range []string{"max_tokens", "max_completion_tokens"} {
+				num, ok := req[param].(json.Number)
+				if !ok {
+					continue
+				}
+				if value, err := num.Int64(); err == nil && value > int64(maxTokens) {
+					logger.Debug("Lowered completion budget to the model's maximum",
+						zap.String("provider", p.Name),
+						zap.String("model", modelName),
+						zap.String("param", param),
+						zap.Int64("requested", value),
+						zap.Int("max_output_tokens", maxTokens),
+					)
+					req[param] = maxTokens
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			return body, nil
+		}
+		return json.Marshal(req)
+	})
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+)
+
+// DroppedParamsHeader lists the request parameters the serving provider doesn't support and
+// that were dropped instead of being sent.
+const DroppedParamsHeader = "X-AI-Dropped-Params"
+
+// ParamRange bounds a numeric request parameter; either end may be open.
+type ParamRange struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// ParamLimits is an operator policy enforced on unified requests before provider dispatch.
+type ParamLimits struct {
+	// Numeric parameters clamped into range, e.g. max_tokens <= 4096
+	Clamp map[string]ParamRange `json:"clamp,omitempty"`
+	// Parameters removed from every request, e.g. logit_bias
+	Strip []string `json:"strip,omitempty"`
+}
+
+// outputTokenParams are the two names of the completion budget. A default for either isn't
+// applied when the client sent the other, and a clamp on either bounds both.
+var outputTokenParams = map[string]string{
+	"max_tokens":            "max_completion_tokens",
+	"max_completion_tokens": "max_tokens",
+}
+
+// applyParamPolicy fills in route defaults for parameters the client didn't send, then strips
+// and clamps parameters per the route limits. It returns the body unchanged if there is no policy.
+func applyParamPolicy(body []byte, defaults map[string]json.RawMessage, limits *ParamLimits) ([]byte, error) {
+	if len(defaults) == 0 && limits == nil {
+		return body, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // Keep integers such as seeds exact
+	var req map[string]any
+	if err := decoder.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	for param, value := range defaults {
+		if _, ok := req[param]; ok {
+			continue
+		}
+		if alias, ok := outputTokenParams[param]; ok && req[alias] != nil {
+			continue
+		}
+		req[param] = value
+	}
+
+	if limits != nil {
+		for _, param := range limits.Strip {
+			delete(req, param)
+		}
+		for param, bounds := range limits.Clamp {
+			clampParam(req, param, bounds)
+			if alias, ok := outputTokenParams[param]; ok {
+				if _, ok := limits.Clamp[alias]; !ok {
+					clampParam(req, alias, bounds)
+				}
+			}
+		}
+	}
+
+	return json.Marshal(req)
+}
+
+// clampParam clamps a numeric parameter of a request into range, if the request has it.
+func clampParam(req map[string]any, param string, bounds ParamRange) {
+	num, ok := req[param].(json.Number)
+	if !ok {
+		return
+	}
+	value, err := num.Float64()
+	if err != nil {
+		return
+	}
+	clamped := value
+	if bounds.Min != nil && clamped < *bounds.Min {
+		clamped = *bounds.Min
+	}
+	if bounds.Max != nil && clamped > *bounds.Max {
+		clamped = *bounds.Max
+	}
+	if clamped != value {
+		req[param] = json.Number(strconv.FormatFloat(clamped, 'f', -1, 64))
+	}
+}
+
+// setDroppedParams reports in DroppedParamsHeader which parameters of a request the provider
+// drops, replacing any report of an earlier attempt.
+func setDroppedParams(w http.ResponseWriter, p *ProviderConfig, body []byte) {
+	w.Header().Del(DroppedParamsHeader)
+	pp, ok := p.Provider.(providers.ParamsProvider)
+	if !ok {
+		return
+	}
+	var req map[string]json.RawMessage
+	if json.Unmarshal(body, &req) != nil {
+		return
+	}
+	var dropped []string
+	for _, param := range pp.UnsupportedParams() {
+		if value, ok := req[param]; ok && string(value) != "null" {
+			dropped = append(dropped, param)
+		}
+	}
+	if len(dropped) > 0 {
+		w.Header().Set(DroppedParamsHeader, strings.Join(dropped, ", "))
+	}
+}
+
+// parseDefaultsCaddyfile parses a `defaults { <param> <value> }` block. Values are JSON
+// when they parse as such (numbers, booleans, arrays) and strings otherwise.
+func parseDefaultsCaddyfile(d *caddyfile.Dispenser) (map[string]json.RawMessage, error) {
+	defaults := make(map[string]json.RawMessage)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		param := d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		value := json.RawMessage(d.Val())
+		if !json.Valid(value) {
+			value, _ = json.Marshal(d.Val())
+		}
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		defaults[param] = value
+	}
+	return defaults, nil
+}
+
+// parseLimitsCaddyfile parses a `limits { ... }` block: `<param> <max>`, `<param> <min> <max>`
+// (use `-` for an open end) and `strip <param>...`.
+func parseLimitsCaddyfile(d *caddyfile.Dispenser) (*ParamLimits, error) {
+	limits := &ParamLimits{Clamp: make(map[string]ParamRange)}
+	parseBound := func(param, raw string) (*float64, error) {
+		if raw == "-" {
+			return nil, nil
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, d.Errf("limits: invalid bound '%s' for %s", raw, param)
+		}
+		return &v, nil
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		param := d.Val()
+		args := d.RemainingArgs()
+		if param == "strip" {
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			limits.Strip = append(limits.Strip, args...)
+			continue
+		}
+
+		var bounds ParamRange
+		var err error
+		switch len(args) {
+		case 1:
+			bounds.Max, err = parseBound(param, args[0])
+		case 2:
+			if bounds.Min, err = parseBound(param, args[0]); err == nil {
+				bounds.Max, err = parseBound(param, args[1])
+			}
+		default:
+			return nil, d.ArgErr()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bounds.Min != nil && bounds.Max != nil && *bounds.Min > *bounds.Max {
+			return nil, d.Errf("limits: min is greater than max for %s", param)
+		}
+		limits.Clamp[param] = bounds
+	}
+	if len(limits.Clamp) == 0 && len(limits.Strip) == 0 {
+		return nil, d.Err("limits: block is empty")
+	}
+	return limits, nil
+}
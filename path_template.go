@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+)
+
+var pathPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// validatePathTemplate checks that a path template is absolute and only uses known placeholders.
+func validatePathTemplate(template string) error {
+	if !strings.HasPrefix(template, "/") {
+		return fmt.Errorf("path template '%s' must start with /", template)
+	}
+	for _, placeholder := range pathPlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{model}", "{stream}":
+		default:
+			return fmt.Errorf("path template '%s': unknown placeholder %s; use {model} or {stream}", template, placeholder)
+		}
+	}
+	return nil
+}
+
+// applyPathTemplate replaces the path of a transformed chat completions request with the
+// provider's path template, if it has one. The query the provider set is kept.
+func (p *ProviderConfig) applyPathTemplate(r *http.Request, modelName string) {
+	if passthrough, ok := r.Context().Value(common.ResponsesPassthroughContextKeyString).(*common.ResponsesPassthrough); ok && passthrough != nil && passthrough.Native {
+		return // Native Responses API requests have their own path
+	}
+	stream, _ := r.Context().Value(common.StreamContextKeyString).(bool)
+	template := p.PathTemplate
+	if stream && p.StreamPathTemplate != "" {
+		template = p.StreamPathTemplate
+	}
+	if template == "" {
+		return
+	}
+	r.URL.Path = strings.NewReplacer("{model}", modelName, "{stream}", strconv.FormatBool(stream)).Replace(template)
+	r.URL.RawPath = ""
+}
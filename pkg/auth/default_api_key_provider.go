@@ -10,9 +10,10 @@ import (
 
 // DefaultEnvAPIKeyProvider implements the ExternalAPIKeyProvider interface
 // by fetching API keys from environment variables.
-// It expects environment variables in the format: TARGETIDENTIFIER_API_KEY
+// It expects environment variables in the format: [PREFIX]TARGETIDENTIFIER_API_KEY
 // For example, for targetIdentifier "openai", it looks for "OPENAI_API_KEY".
 type DefaultEnvAPIKeyProvider struct {
+	prefix string
 	logger *zap.Logger
 }
 
@@ -26,6 +27,21 @@ func NewDefaultEnvAPIKeyProvider(logger *zap.Logger) *DefaultEnvAPIKeyProvider {
 	return &DefaultEnvAPIKeyProvider{logger: logger}
 }
 
+// NewPrefixedEnvAPIKeyProvider creates a DefaultEnvAPIKeyProvider whose variable names start
+// with prefix, e.g. "TENANT_A_" to read "TENANT_A_OPENAI_API_KEY". It keeps the keys of
+// several routers (tenants) apart.
+func NewPrefixedEnvAPIKeyProvider(prefix string, logger *zap.Logger) *DefaultEnvAPIKeyProvider {
+	p := NewDefaultEnvAPIKeyProvider(logger)
+	p.prefix = strings.ToUpper(prefix)
+	return p
+}
+
+// EnvVarName returns the environment variable holding the key for a target,
+// e.g., "OPENAI_API_KEY" for "openai".
+func (p *DefaultEnvAPIKeyProvider) EnvVarName(targetIdentifier string) string {
+	return p.prefix + strings.ToUpper(targetIdentifier) + "_API_KEY"
+}
+
 // GetExternalAPIKey fetches an API key for a given target identifier from environment variables.
 // The userID parameter is ignored by this provider as keys are not user-specific.
 func (p *DefaultEnvAPIKeyProvider) GetExternalAPIKey(targetIdentifier string, userID string) (string, error) {
@@ -34,10 +50,14 @@ func (p *DefaultEnvAPIKeyProvider) GetExternalAPIKey(targetIdentifier string, us
 		return "", fmt.Errorf("target identifier cannot be empty")
 	}
 
-	// Construct the environment variable name, e.g., "OPENAI_API_KEY" from "openai"
-	envVarName := strings.ToUpper(targetIdentifier) + "_API_KEY"
+	envVarName := p.EnvVarName(targetIdentifier)
 
 	apiKey := os.Getenv(envVarName)
+	if apiKey == "" {
+		if keys := splitKeys(os.Getenv(envVarName + "S")); len(keys) > 0 {
+			apiKey = keys[0]
+		}
+	}
 
 	if apiKey == "" {
 		p.logger.Warn("API key not found in environment variable",
@@ -54,3 +74,28 @@ func (p *DefaultEnvAPIKeyProvider) GetExternalAPIKey(targetIdentifier string, us
 		zap.String("target_identifier", targetIdentifier))
 	return apiKey, nil
 }
+
+// GetExternalAPIKeys fetches the key pool for a target from a comma-separated [PREFIX]TARGET_API_KEYS
+// variable, falling back to the single key in [PREFIX]TARGET_API_KEY.
+func (p *DefaultEnvAPIKeyProvider) GetExternalAPIKeys(targetIdentifier string, userID string) ([]string, error) {
+	if targetIdentifier != "" {
+		if keys := splitKeys(os.Getenv(p.EnvVarName(targetIdentifier) + "S")); len(keys) > 0 {
+			return keys, nil
+		}
+	}
+	apiKey, err := p.GetExternalAPIKey(targetIdentifier, userID)
+	if err != nil {
+		return nil, err
+	}
+	return []string{apiKey}, nil
+}
+
+func splitKeys(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -7,3 +7,11 @@ type ExternalAPIKeyProvider interface {
 	// and an optional user ID (for user-specific keys).
 	GetExternalAPIKey(targetIdentifier string, userID string) (string, error)
 }
+
+// ExternalAPIKeyPoolProvider is implemented by key services that hold several keys per target.
+// The router rotates among the returned keys and steers around the ones being rate limited.
+type ExternalAPIKeyPoolProvider interface {
+	ExternalAPIKeyProvider
+	// GetExternalAPIKeys fetches every key usable for a target identifier and optional user ID.
+	GetExternalAPIKeys(targetIdentifier string, userID string) ([]string, error)
+}
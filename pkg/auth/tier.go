@@ -0,0 +1,8 @@
+package auth
+
+// TierResolver defines the interface for a service that can tell the tier (e.g. "free", "pro")
+// of a user, such as a key store holding it next to the user's keys.
+type TierResolver interface {
+	// GetUserTier fetches the tier of a user ID; an empty tier means the user has none.
+	GetUserTier(userID string) (string, error)
+}
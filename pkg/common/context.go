@@ -0,0 +1,40 @@
+package common
+
+import "context"
+
+// StreamContextKeyString marks whether the client asked for a streamed (SSE) response.
+// The router sets it before proxying so response hooks can tell without re-reading the body.
+const StreamContextKeyString string = "ai_stream"
+
+// ResponsesPassthroughContextKeyString carries a *ResponsesPassthrough for requests that arrived
+// through the OpenAI Responses API ingress.
+const ResponsesPassthroughContextKeyString string = "ai_responses_passthrough"
+
+// ResponsesPassthrough holds the original Responses API body, so providers that support the
+// Responses API natively can forward it untranslated. Native is set once a provider does so.
+type ResponsesPassthrough struct {
+	Body   []byte
+	Native bool
+}
+
+// RequestKindContextKeyString carries the kind of API call being proxied, so provider hooks
+// can tell image generations and reranks from chat completions. Unset means RequestKindChat.
+const RequestKindContextKeyString string = "ai_request_kind"
+
+const (
+	RequestKindChat   = "chat"
+	RequestKindImages = "images"
+	RequestKindRerank = "rerank"
+)
+
+// ImageResponseFormatContextKeyString carries the response_format ("url" or "b64_json")
+// an images client asked for, so response hooks can normalize provider output to it.
+const ImageResponseFormatContextKeyString string = "ai_image_response_format"
+
+// RequestKind returns the kind of API call a request context carries.
+func RequestKind(ctx context.Context) string {
+	if kind, ok := ctx.Value(RequestKindContextKeyString).(string); ok && kind != "" {
+		return kind
+	}
+	return RequestKindChat
+}
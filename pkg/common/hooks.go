@@ -1,48 +1,229 @@
 package common
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+// MaxBufferedBody caps how much of a body a hook holds in memory: a whole JSON body, or one line
+// of an event stream. Bodies over the cap fail with ErrBodyTooLarge. 0 means no cap.
+var MaxBufferedBody int64 = 64 << 20
+
+// ErrBodyTooLarge is returned by hooks for bodies over MaxBufferedBody.
+var ErrBodyTooLarge = errors.New("body exceeds the buffering limit")
+
+// Buffers larger than this aren't pooled, so one huge body doesn't stay in memory.
+const maxPooledBuffer = 1 << 20
+
+var (
+	bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	readerPool = sync.Pool{New: func() any { return bufio.NewReaderSize(nil, 16<<10) }}
+)
+
+// readBody reads a body into a pooled buffer, up to MaxBufferedBody. With ErrBodyTooLarge it also
+// returns the buffer, holding what was read, for the caller to put back or release.
+func readBody(body io.Reader) (*bytes.Buffer, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	src := body
+	if MaxBufferedBody > 0 {
+		src = io.LimitReader(body, MaxBufferedBody+1)
+	}
+	if _, err := buf.ReadFrom(src); err != nil {
+		releaseBuffer(buf, nil)
+		return nil, err
+	}
+	if MaxBufferedBody > 0 && int64(buf.Len()) > MaxBufferedBody {
+		return buf, ErrBodyTooLarge
+	}
+	return buf, nil
+}
+
+// releaseBuffer returns a buffer to the pool, unless kept is (part of) its contents, as when
+// a transform returns the body it was given.
+func releaseBuffer(buf *bytes.Buffer, kept []byte) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	if all := buf.Bytes()[:buf.Cap()]; cap(kept) > 0 && cap(all) > 0 && &kept[:cap(kept)][cap(kept)-1] == &all[cap(all)-1] {
+		return // Slices of the same array end at the same element
+	}
+	bufferPool.Put(buf)
+}
+
+// HookHttpRequestBody replaces a request body with its transformed version. The body passed to
+// transform is only valid until it returns, so transform must not keep it, but may return it.
+// If the body is over MaxBufferedBody or transform fails, the request keeps its body as it was,
+// so a caller that ignores the error still sends it whole.
 func HookHttpRequestBody(r *http.Request, transform func(r *http.Request, body []byte) ([]byte, error)) error {
-	bodyBytes, err := io.ReadAll(r.Body)
+	body := r.Body
+	buf, err := readBody(body)
+	if errors.Is(err, ErrBodyTooLarge) {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(buf, body), body} // buf isn't pooled again, as the request now reads it
+		return err
+	}
+	body.Close()
 	if err != nil {
 		return err
 	}
-	r.Body.Close()
 
-	transformedBody, err := transform(r, bodyBytes)
+	transformedBody, err := transform(r, buf.Bytes())
 	if err != nil {
+		r.Body = io.NopCloser(buf)
 		return err
 	}
+	releaseBuffer(buf, transformedBody)
 
-	r.Body = io.NopCloser(bytes.NewBuffer(transformedBody))
+	r.Body = io.NopCloser(bytes.NewReader(transformedBody))
 	r.ContentLength = int64(len(transformedBody))
 
 	return nil
 }
 
+// HookHttpResponseBody replaces a response body with its transformed version. The whole body is
+// read first; see HookHttpResponseJson for bodies that may be event streams. As for
+// HookHttpRequestBody, transform must not keep the body it's given.
 func HookHttpResponseBody(resp *http.Response, transform func(resp *http.Response, body []byte) ([]byte, error)) error {
-	bodyBytes, err := io.ReadAll(resp.Body)
+	buf, err := readBody(resp.Body)
+	resp.Body.Close()
 	if err != nil {
+		if buf != nil {
+			releaseBuffer(buf, nil)
+		}
 		return err
 	}
-	resp.Body.Close()
 
-	transformedBody, err := transform(resp, bodyBytes)
+	transformedBody, err := transform(resp, buf.Bytes())
+	releaseBuffer(buf, transformedBody)
 	if err != nil {
 		return err
 	}
 
-	resp.Body = io.NopCloser(bytes.NewBuffer(transformedBody))
+	resp.Body = io.NopCloser(bytes.NewReader(transformedBody))
 	resp.ContentLength = int64(len(transformedBody))
+	if resp.Header.Get("Content-Length") != "" {
+		resp.Header.Set("Content-Length", strconv.Itoa(len(transformedBody))) // The proxy copies headers as they are
+	}
+
+	return nil
+}
 
+// HookHttpResponseJson transforms a JSON response body, or every data payload of an event
+// stream. Streams are transformed as they are read, so only one line is held in memory at a time
+// and the client gets each event as soon as it arrives. Other bodies are left as they are.
+func HookHttpResponseJson(resp *http.Response, transform func(body []byte) ([]byte, error)) error {
+	contentType := resp.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		src := readerPool.Get().(*bufio.Reader)
+		src.Reset(resp.Body)
+		resp.Body = &sseTransformBody{src: src, closer: resp.Body, transform: transform}
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		return nil
+	case strings.HasPrefix(contentType, "application/json"):
+		return HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+			return transform(body)
+		})
+	}
 	return nil
 }
 
+// sseTransformBody rewrites an event stream as it's read: each data payload is transformed and
+// sent as its own event, [DONE] passes through, and other fields (event names, ids, comments)
+// are dropped, as the transformed stream is in the unified format. A payload that fails to
+// transform is sent as it is rather than ending the stream.
+type sseTransformBody struct {
+	src       *bufio.Reader
+	closer    io.Closer
+	transform func(body []byte) ([]byte, error)
+
+	line []byte // Reused for lines longer than the reader's buffer
+	out  bytes.Buffer
+	err  error
+}
+
+func (b *sseTransformBody) Read(p []byte) (int, error) {
+	for b.out.Len() == 0 && b.err == nil {
+		line, err := b.readLine()
+		if len(line) > 0 {
+			b.processLine(line)
+		}
+		b.err = err
+	}
+	if b.out.Len() > 0 {
+		return b.out.Read(p)
+	}
+	return 0, b.err
+}
+
+// readLine returns the next line, which is only valid until the next call.
+func (b *sseTransformBody) readLine() ([]byte, error) {
+	if b.src == nil {
+		return nil, io.ErrClosedPipe
+	}
+	line, err := b.src.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	b.line = append(b.line[:0], line...)
+	for err == bufio.ErrBufferFull {
+		if MaxBufferedBody > 0 && int64(len(b.line)) > MaxBufferedBody {
+			return nil, ErrBodyTooLarge
+		}
+		line, err = b.src.ReadSlice('\n')
+		b.line = append(b.line, line...)
+	}
+	return b.line, err
+}
+
+func (b *sseTransformBody) processLine(line []byte) {
+	data, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), []byte("data:"))
+	if !ok {
+		return
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return
+	}
+	if string(data) != "[DONE]" {
+		if transformed, err := b.transform(data); err == nil {
+			data = transformed
+		}
+		if len(data) == 0 {
+			return
+		}
+	}
+	b.out.WriteString("data: ")
+	b.out.Write(data)
+	b.out.WriteString("\n\n")
+}
+
+func (b *sseTransformBody) Close() error {
+	if b.src != nil {
+		b.src.Reset(nil)
+		readerPool.Put(b.src)
+		b.src = nil
+	}
+	if cap(b.line) > maxPooledBuffer {
+		b.line = nil
+	}
+	return b.closer.Close()
+}
+
+// HookHttpResponseJsonChunks returns a HookHttpResponseBody transform applying transform to a
+// JSON body or to each data payload of a complete event stream.
+//
+// Deprecated: it needs the whole stream in memory; use HookHttpResponseJson.
 func HookHttpResponseJsonChunks(transform func(body []byte) ([]byte, error)) func(resp *http.Response, body []byte) ([]byte, error) {
 	return func(resp *http.Response, body []byte) ([]byte, error) {
 		if resp.Header.Get("Content-Type") == "application/json" {
@@ -1,31 +1,144 @@
 package common
 
 import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
 	"os"
+	"time"
 
-	"github.com/posthog/posthog-go"
+	"go.uber.org/zap"
 )
 
-var posthogClient posthog.Client
+// Observability sink types selectable in the router config.
+const (
+	SinkPostHog = "posthog"
+	SinkWebhook = "webhook"
+	SinkKafka   = "kafka"
+	SinkStdout  = "stdout"
+	SinkNone    = "none"
+)
+
+// ObservabilityEvent is one event as handed to a sink.
+type ObservabilityEvent struct {
+	DistinctID string         `json:"distinct_id"`
+	Event      string         `json:"event"`
+	Properties map[string]any `json:"properties"`
+	Timestamp  time.Time      `json:"timestamp"`
+}
 
-func TryInstrumentAppObservability() bool {
+// ObservabilitySink delivers observability events to a backend. Capture must not block
+// request handling for long; sinks that talk to the network buffer and send in the background.
+type ObservabilitySink interface {
+	Capture(event ObservabilityEvent) error
+	Close() error
+}
+
+// ObservabilityConfig selects and tunes a router's observability sink.
+type ObservabilityConfig struct {
+	// posthog, webhook, kafka (through a Kafka REST Proxy), stdout or none
+	Sink string `json:"sink"`
+	// Webhook URL, Kafka REST Proxy base URL or PostHog endpoint
+	URL string `json:"url,omitempty"`
+	// PostHog project key (defaults to POSTHOG_API_KEY)
+	APIKey string `json:"api_key,omitempty"`
+	// Kafka topic events are produced to
+	Topic string `json:"topic,omitempty"`
+	// Extra headers for webhook and Kafka REST Proxy requests
+	Headers map[string]string `json:"headers,omitempty"`
+	// Fraction of requests whose events are kept (0 or 1 = all)
+	SampleRate float64 `json:"sample_rate,omitempty"`
+	// If set, only these event properties are sent
+	Properties []string `json:"properties,omitempty"`
+	// Event properties that are never sent, e.g. "body"
+	ExcludeProperties []string `json:"exclude_properties,omitempty"`
+	// Sensitive data ("secrets", "content") sent as is instead of redacted
+	Unredacted []string `json:"unredacted,omitempty"`
+}
+
+// Validate checks the config for a known sink and its required settings.
+func (c *ObservabilityConfig) Validate() error {
+	switch c.Sink {
+	case SinkPostHog, SinkStdout, SinkNone:
+	case SinkWebhook:
+		if c.URL == "" {
+			return fmt.Errorf("observability sink webhook requires a url")
+		}
+	case SinkKafka:
+		if c.URL == "" || c.Topic == "" {
+			return fmt.Errorf("observability sink kafka requires a REST Proxy url and a topic")
+		}
+	default:
+		return fmt.Errorf("unknown observability sink '%s' (expected posthog, webhook, kafka, stdout or none)", c.Sink)
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("observability sample_rate must be between 0 and 1")
+	}
+	if _, err := NewRedactionPolicy(c.Unredacted); err != nil {
+		return fmt.Errorf("observability unredacted: %v", err)
+	}
+	return nil
+}
+
+// Observability delivers the events of one router to its sink, sampled, filtered and redacted
+// as the router's config says. A nil *Observability drops events.
+type Observability struct {
+	sink   ObservabilitySink
+	cfg    *ObservabilityConfig
+	policy RedactionPolicy // The router's, from its observability unredacted; redacts all by default
+}
+
+// NewObservability builds the sink described by cfg. It returns nil for sink none.
+func NewObservability(cfg *ObservabilityConfig, logger *zap.Logger) (*Observability, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	var sink ObservabilitySink
+	switch cfg.Sink {
+	case SinkPostHog:
+		apiKey := cfg.APIKey
+		if apiKey == "" {
+			apiKey = os.Getenv("POSTHOG_API_KEY")
+		}
+		endpoint := cfg.URL
+		if endpoint == "" {
+			endpoint = os.Getenv("POSTHOG_BASE_URL")
+		}
+		posthogSink, err := newPostHogSink(apiKey, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		sink = posthogSink
+	case SinkWebhook:
+		sink = newHTTPBatchSink(cfg.URL, "application/json", cfg.Headers, encodeWebhookBatch, logger)
+	case SinkKafka:
+		sink = newHTTPBatchSink(cfg.URL+"/topics/"+cfg.Topic, "application/vnd.kafka.json.v2+json", cfg.Headers, encodeKafkaBatch, logger)
+	case SinkStdout:
+		sink = &stdoutSink{out: os.Stdout}
+	case SinkNone:
+		return nil, nil
+	}
+	policy, _ := NewRedactionPolicy(cfg.Unredacted) // Validated above
+	return &Observability{sink: sink, cfg: cfg, policy: policy}, nil
+}
+
+// NewDefaultObservability sends events to PostHog when POSTHOG_API_KEY is set; it is the
+// default of routers without an observability config. It returns nil otherwise.
+func NewDefaultObservability() *Observability {
 	key := os.Getenv("POSTHOG_API_KEY")
 	if key == "" {
-		return false // If no API key is set, we skip instrumentation
+		return nil
 	}
-
-	client, err := posthog.NewWithConfig(key, posthog.Config{Endpoint: os.Getenv("POSTHOG_BASE_URL")})
+	sink, err := newPostHogSink(key, os.Getenv("POSTHOG_BASE_URL"))
 	if err != nil {
-		return false // If we can't create the client, we just skip instrumentation
+		return nil
 	}
-	posthogClient = client
-	return true
-
-	// defer client.Close()
+	return &Observability{sink: sink}
 }
 
-func FireObservabilityEvent(userId, url, eventName string, properties map[string]any) error {
-	if posthogClient == nil {
+// Fire sends an event, unless sampling drops it.
+func (o *Observability) Fire(userId, url, eventName string, properties map[string]any) error {
+	if o == nil || o.sink == nil {
 		return nil
 	}
 
@@ -37,9 +150,77 @@ func FireObservabilityEvent(userId, url, eventName string, properties map[string
 		properties["$current_url"] = url
 	}
 
-	return posthogClient.Enqueue(posthog.Capture{
-		DistinctId: userId,
+	if o.cfg != nil {
+		if !sampled(o.cfg.SampleRate, properties) {
+			return nil
+		}
+		properties = filterProperties(properties, o.cfg.Properties, o.cfg.ExcludeProperties)
+	}
+	properties = o.policy.Properties(properties)
+
+	return o.sink.Capture(ObservabilityEvent{
+		DistinctID: userId,
 		Event:      eventName,
 		Properties: properties,
+		Timestamp:  time.Now(),
 	})
 }
+
+// Close flushes and closes the sink.
+func (o *Observability) Close() error {
+	if o == nil || o.sink == nil {
+		return nil
+	}
+	return o.sink.Close()
+}
+
+// sampled decides whether an event is kept. Events of one request share its request_id, so
+// they are kept or dropped together.
+func sampled(rate float64, properties map[string]any) bool {
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+	id, _ := properties["request_id"].(string)
+	return sampledID(rate, id)
+}
+
+// sampledID decides whether to keep the data of the request with the given ID; without an
+// ID the decision is random.
+func sampledID(rate float64, id string) bool {
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+	if id != "" {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		return float64(h.Sum64()%10000)/10000 < rate
+	}
+	return rand.Float64() < rate
+}
+
+// filterProperties applies the property allowlist and denylist.
+func filterProperties(properties map[string]any, allow, exclude []string) map[string]any {
+	if len(allow) == 0 && len(exclude) == 0 {
+		return properties
+	}
+	filtered := make(map[string]any, len(properties))
+	for key, value := range properties {
+		if len(allow) > 0 && !containsString(allow, key) {
+			continue
+		}
+		if containsString(exclude, key) {
+			continue
+		}
+		filtered[key] = value
+	}
+	return filtered
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
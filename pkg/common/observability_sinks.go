@@ -0,0 +1,198 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/posthog/posthog-go"
+	"go.uber.org/zap"
+)
+
+const (
+	observabilityBatchSize     = 100
+	observabilityQueueSize     = 10000
+	observabilityFlushInterval = time.Second
+)
+
+// postHogSink sends events to PostHog with its client's own batching.
+type postHogSink struct {
+	client posthog.Client
+}
+
+func newPostHogSink(apiKey, endpoint string) (*postHogSink, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("observability sink posthog requires an api_key or POSTHOG_API_KEY")
+	}
+	client, err := posthog.NewWithConfig(apiKey, posthog.Config{Endpoint: endpoint})
+	if err != nil {
+		return nil, err
+	}
+	return &postHogSink{client: client}, nil
+}
+
+func (s *postHogSink) Capture(event ObservabilityEvent) error {
+	return s.client.Enqueue(posthog.Capture{
+		DistinctId: event.DistinctID,
+		Event:      event.Event,
+		Properties: event.Properties,
+		Timestamp:  event.Timestamp,
+	})
+}
+
+func (s *postHogSink) Close() error {
+	return s.client.Close()
+}
+
+// stdoutSink writes each event as a JSON line.
+type stdoutSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (s *stdoutSink) Capture(event ObservabilityEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.out.Write(append(line, '\n'))
+	return err
+}
+
+func (s *stdoutSink) Close() error {
+	return nil
+}
+
+// httpBatchSink queues items (events or traces) and POSTs them in batches from a background
+// goroutine. When the queue is full, new items are dropped rather than slowing requests down.
+type httpBatchSink[T any] struct {
+	url         string
+	contentType string
+	headers     map[string]string
+	encode      func([]T) ([]byte, error)
+	client      *http.Client
+	logger      *zap.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan T
+	done   chan struct{}
+}
+
+func newHTTPBatchSink[T any](url, contentType string, headers map[string]string, encode func([]T) ([]byte, error), logger *zap.Logger) *httpBatchSink[T] {
+	s := &httpBatchSink[T]{
+		url:         url,
+		contentType: contentType,
+		headers:     headers,
+		encode:      encode,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		logger:      logger,
+		queue:       make(chan T, observabilityQueueSize),
+		done:        make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *httpBatchSink[T]) Capture(item T) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil
+	}
+	select {
+	case s.queue <- item:
+		return nil
+	default:
+		return fmt.Errorf("observability queue for %s is full, dropping", s.url)
+	}
+}
+
+// Close flushes queued items and stops the sender.
+func (s *httpBatchSink[T]) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+func (s *httpBatchSink[T]) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(observabilityFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]T, 0, observabilityBatchSize)
+	for {
+		select {
+		case item, ok := <-s.queue:
+			if !ok {
+				s.send(batch)
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= observabilityBatchSize {
+				s.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			s.send(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (s *httpBatchSink[T]) send(batch []T) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := s.encode(batch)
+	if err != nil {
+		s.logger.Error("Failed to encode observability batch", zap.Error(err))
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		s.logger.Error("Failed to create observability request", zap.Error(err))
+		return
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.logger.Warn("Failed to send observability batch", zap.String("url", s.url), zap.Int("items", len(batch)), zap.Error(err))
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		s.logger.Warn("Observability backend rejected batch", zap.String("url", s.url), zap.Int("status_code", resp.StatusCode), zap.Int("items", len(batch)))
+	}
+}
+
+// encodeWebhookBatch sends events as {"events": [...]}.
+func encodeWebhookBatch(batch []ObservabilityEvent) ([]byte, error) {
+	return json.Marshal(map[string]any{"events": batch})
+}
+
+// encodeKafkaBatch produces one record per event, keyed by distinct ID, for the
+// Kafka REST Proxy v2 API.
+func encodeKafkaBatch(batch []ObservabilityEvent) ([]byte, error) {
+	records := make([]map[string]any, len(batch))
+	for i, event := range batch {
+		records[i] = map[string]any{"key": event.DistinctID, "value": event}
+	}
+	return json.Marshal(map[string]any{"records": records})
+}
@@ -0,0 +1,240 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Kinds of sensitive data that are redacted from logs and observability events unless kept.
+const (
+	// SensitiveSecrets are credentials: Authorization headers, API keys in URLs, headers and bodies.
+	SensitiveSecrets = "secrets"
+	// SensitiveContent is what users send and get back: messages, prompts and request/response bodies.
+	SensitiveContent = "content"
+)
+
+const redacted = "[REDACTED]"
+
+// Log fields and event properties holding message content.
+var contentKeys = map[string]bool{
+	"body":             true,
+	"transformed_body": true,
+	"original_body":    true,
+	"request_body":     true,
+	"response_body":    true,
+	"messages":         true,
+	"prompt":           true,
+	"completion":       true,
+	"content":          true,
+	"input":            true,
+}
+
+// Log fields, event properties and headers holding credentials.
+var secretKeys = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"api_key":             true,
+	"apikey":              true,
+	"api-key":             true,
+	"x-api-key":           true,
+	"x-goog-api-key":      true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"secret":              true,
+	"secret_key":          true,
+	"password":            true,
+}
+
+// Credentials recognized inside free-form strings such as URLs, error messages and dumped headers.
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)([?&](?:key|api_key|api-key|apikey|access_token|token|sig|signature)=)[^&#\s"']+`), "${1}REDACTED"},
+	{regexp.MustCompile(`(?im)^((?:authorization|proxy-authorization|x-api-key|x-goog-api-key|api-key):[ \t]*)[^\r\n]+`), "${1}REDACTED"},
+	{regexp.MustCompile(`(?i)("(?:api_key|apikey|x-api-key|authorization|secret|password)"\s*:\s*")[^"]*"`), `${1}REDACTED"`},
+	{regexp.MustCompile(`(?i)\b(Bearer|Basic)\s+[A-Za-z0-9._~+/=-]{8,}`), "$1 REDACTED"},
+	{regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_-]{16,}|AIza[0-9A-Za-z_-]{30,})`), "REDACTED"},
+}
+
+// IsSecretKey reports whether a log field, event property or header of this name holds
+// credentials, whatever its case.
+func IsSecretKey(name string) bool {
+	return secretKeys[strings.ToLower(name)]
+}
+
+// RedactSecrets replaces the credentials recognized in s.
+func RedactSecrets(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// LoggedBody is a body that a router's log_bodies setting chose to log. It is kept whatever the
+// content redaction says, but credentials in it are still redacted.
+type LoggedBody string
+
+func (b LoggedBody) String() string {
+	return string(b)
+}
+
+// RedactionPolicy says which sensitive data is kept; the zero value redacts everything.
+type RedactionPolicy struct {
+	KeepSecrets bool
+	KeepContent bool
+}
+
+// NewRedactionPolicy builds a policy keeping the given kinds of sensitive data.
+func NewRedactionPolicy(keep []string) (RedactionPolicy, error) {
+	var p RedactionPolicy
+	for _, kind := range keep {
+		switch kind {
+		case SensitiveSecrets:
+			p.KeepSecrets = true
+		case SensitiveContent:
+			p.KeepContent = true
+		default:
+			return p, fmt.Errorf("unknown sensitive data kind '%s' (expected secrets or content)", kind)
+		}
+	}
+	return p, nil
+}
+
+// sensitive returns the replacement for a whole field or property, if its key marks it as sensitive.
+func (p RedactionPolicy) sensitive(key string) (string, bool) {
+	if !p.KeepContent && contentKeys[key] {
+		return redacted, true
+	}
+	if !p.KeepSecrets && IsSecretKey(key) {
+		return redacted, true
+	}
+	return "", false
+}
+
+func (p RedactionPolicy) scrub(s string) string {
+	if p.KeepSecrets {
+		return s
+	}
+	return RedactSecrets(s)
+}
+
+// Field redacts a log field.
+func (p RedactionPolicy) Field(f zapcore.Field) zapcore.Field {
+	if body, ok := f.Interface.(LoggedBody); ok {
+		return zap.String(f.Key, p.scrub(string(body)))
+	}
+	if replacement, ok := p.sensitive(f.Key); ok {
+		return zap.String(f.Key, replacement)
+	}
+	if p.KeepSecrets {
+		return f
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = RedactSecrets(f.String)
+	case zapcore.ByteStringType:
+		if b, ok := f.Interface.([]byte); ok {
+			return zap.String(f.Key, RedactSecrets(string(b)))
+		}
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok && s != nil {
+			return zap.String(f.Key, RedactSecrets(s.String()))
+		}
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && err != nil {
+			return zap.String(f.Key, RedactSecrets(err.Error()))
+		}
+	}
+	return f
+}
+
+func (p RedactionPolicy) fields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		out[i] = p.Field(f)
+	}
+	return out
+}
+
+// Properties returns a redacted copy of observability event properties, nested maps and lists
+// included.
+func (p RedactionPolicy) Properties(properties map[string]any) map[string]any {
+	out := make(map[string]any, len(properties))
+	for key, value := range properties {
+		if replacement, ok := p.sensitive(key); ok {
+			if _, isBody := value.(LoggedBody); !isBody {
+				out[key] = replacement
+				continue
+			}
+		}
+		out[key] = p.property(value)
+	}
+	return out
+}
+
+// property redacts one property value.
+func (p RedactionPolicy) property(value any) any {
+	switch v := value.(type) {
+	case LoggedBody:
+		return p.scrub(string(v))
+	case string:
+		return p.scrub(v)
+	case error:
+		return p.scrub(v.Error())
+	case []string:
+		out := make([]string, len(v))
+		for i, s := range v {
+			out[i] = p.scrub(s)
+		}
+		return out
+	case map[string]any:
+		return p.Properties(v)
+	case []map[string]any:
+		out := make([]map[string]any, len(v))
+		for i, m := range v {
+			out[i] = p.Properties(m)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = p.property(item)
+		}
+		return out
+	}
+	return value
+}
+
+// Logger returns a logger that applies the policy to everything written through it.
+func (p RedactionPolicy) Logger(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &redactingCore{Core: core, policy: p}
+	}))
+}
+
+// redactingCore applies a redaction policy to the messages and fields of every entry.
+type redactingCore struct {
+	zapcore.Core
+	policy RedactionPolicy
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.policy.fields(fields)), policy: c.policy}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.policy.scrub(entry.Message)
+	return c.Core.Write(entry, c.policy.fields(fields))
+}
@@ -0,0 +1,337 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Trace exporter types selectable in the router config.
+const (
+	TraceExporterLangfuse = "langfuse"
+	TraceExporterOTLP     = "otlp"
+)
+
+// Parts of a trace that can be redacted before export.
+const (
+	RedactPrompt     = "prompt"
+	RedactCompletion = "completion"
+)
+
+const (
+	redactedContent        = "[REDACTED]"
+	defaultLangfuseHost    = "https://cloud.langfuse.com"
+	defaultOTLPEndpoint    = "http://localhost:4318"
+	defaultTraceService    = "caddy-ai-router"
+	otlpSpanKindClient     = 3
+	otlpStatusCodeError    = 2
+	langfuseIngestionPath  = "/api/public/ingestion"
+	otlpTracesPath         = "/v1/traces"
+	langfuseUsageUnitToken = "TOKENS"
+)
+
+// LLMTrace describes one routed LLM call as exported to an LLM observability backend.
+type LLMTrace struct {
+	TraceID      string // 32 hex characters
+	SpanID       string // 16 hex characters
+	ParentSpanID string // From an incoming traceparent, if any
+	RequestID    string
+	Name         string
+	UserID       string
+
+	RequestedModel string
+	Model          string
+	Provider       string
+
+	Prompt           json.RawMessage // The request messages
+	Completion       string
+	PromptTokens     int
+	CompletionTokens int
+	Cost             *float64 // USD, if the model's pricing is known
+
+	Status   int // Sent to the client
+	Start    time.Time
+	End      time.Time
+	Metadata map[string]any
+}
+
+// TracingConfig selects where LLM traces are exported and what they contain.
+type TracingConfig struct {
+	// langfuse or otlp (OTLP/HTTP JSON)
+	Exporter string `json:"exporter"`
+	// Langfuse host (defaults to LANGFUSE_HOST) or OTLP endpoint (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)
+	URL string `json:"url,omitempty"`
+	// Langfuse project keys (default to LANGFUSE_PUBLIC_KEY and LANGFUSE_SECRET_KEY)
+	PublicKey string `json:"public_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+	// Extra headers for export requests, e.g. an OTLP collector token
+	Headers map[string]string `json:"headers,omitempty"`
+	// OTLP service.name resource attribute
+	ServiceName string `json:"service_name,omitempty"`
+	// Trace parts replaced by a placeholder before export: "prompt" and/or "completion"
+	Redact []string `json:"redact,omitempty"`
+	// Fraction of requests that are traced (0 or 1 = all)
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// Validate checks the config for a known exporter and valid settings.
+func (c *TracingConfig) Validate() error {
+	switch c.Exporter {
+	case TraceExporterLangfuse, TraceExporterOTLP:
+	default:
+		return fmt.Errorf("unknown trace exporter '%s' (expected langfuse or otlp)", c.Exporter)
+	}
+	for _, part := range c.Redact {
+		if part != RedactPrompt && part != RedactCompletion {
+			return fmt.Errorf("unknown redact target '%s' (expected prompt or completion)", part)
+		}
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("tracing sample_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// TraceExporter sends LLM traces to Langfuse or an OTLP collector in background batches.
+type TraceExporter struct {
+	cfg  *TracingConfig
+	sink *httpBatchSink[LLMTrace]
+}
+
+// NewTraceExporter starts an exporter for cfg.
+func NewTraceExporter(cfg *TracingConfig, logger *zap.Logger) (*TraceExporter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string, len(cfg.Headers)+1)
+	for k, v := range cfg.Headers {
+		headers[k] = v
+	}
+
+	var sink *httpBatchSink[LLMTrace]
+	switch cfg.Exporter {
+	case TraceExporterLangfuse:
+		host := firstNonEmpty(cfg.URL, os.Getenv("LANGFUSE_HOST"), defaultLangfuseHost)
+		publicKey := firstNonEmpty(cfg.PublicKey, os.Getenv("LANGFUSE_PUBLIC_KEY"))
+		secretKey := firstNonEmpty(cfg.SecretKey, os.Getenv("LANGFUSE_SECRET_KEY"))
+		if publicKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("trace exporter langfuse requires public_key and secret_key (or LANGFUSE_PUBLIC_KEY and LANGFUSE_SECRET_KEY)")
+		}
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(publicKey+":"+secretKey))
+		sink = newHTTPBatchSink(strings.TrimSuffix(host, "/")+langfuseIngestionPath, "application/json", headers, encodeLangfuseBatch, logger)
+	case TraceExporterOTLP:
+		endpoint := strings.TrimSuffix(firstNonEmpty(cfg.URL, os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), defaultOTLPEndpoint), "/")
+		if !strings.HasSuffix(endpoint, otlpTracesPath) {
+			endpoint += otlpTracesPath
+		}
+		service := firstNonEmpty(cfg.ServiceName, defaultTraceService)
+		sink = newHTTPBatchSink(endpoint, "application/json", headers, func(batch []LLMTrace) ([]byte, error) {
+			return encodeOTLPBatch(service, batch)
+		}, logger)
+	}
+	return &TraceExporter{cfg: cfg, sink: sink}, nil
+}
+
+// Export queues a trace, applying sampling and redaction first.
+func (e *TraceExporter) Export(trace LLMTrace) error {
+	if !sampledID(e.cfg.SampleRate, trace.RequestID) {
+		return nil
+	}
+	if containsString(e.cfg.Redact, RedactPrompt) {
+		trace.Prompt = json.RawMessage(strconv.Quote(redactedContent))
+	}
+	if containsString(e.cfg.Redact, RedactCompletion) {
+		trace.Completion = redactedContent
+	}
+	return e.sink.Capture(trace)
+}
+
+// Close flushes queued traces.
+func (e *TraceExporter) Close() error {
+	return e.sink.Close()
+}
+
+// encodeLangfuseBatch turns each trace into a Langfuse trace with one generation, for the
+// ingestion API.
+func encodeLangfuseBatch(batch []LLMTrace) ([]byte, error) {
+	events := make([]map[string]any, 0, 2*len(batch))
+	for _, trace := range batch {
+		prompt := rawOrNull(trace.Prompt)
+		traceID := firstNonEmpty(trace.RequestID, trace.TraceID)
+
+		metadata := make(map[string]any, len(trace.Metadata)+2)
+		for k, v := range trace.Metadata {
+			metadata[k] = v
+		}
+		metadata["provider"] = trace.Provider
+		metadata["otel_trace_id"] = trace.TraceID
+
+		usage := map[string]any{
+			"input":  trace.PromptTokens,
+			"output": trace.CompletionTokens,
+			"total":  trace.PromptTokens + trace.CompletionTokens,
+			"unit":   langfuseUsageUnitToken,
+		}
+		if trace.Cost != nil {
+			usage["totalCost"] = *trace.Cost
+		}
+		generation := map[string]any{
+			"id":        trace.SpanID,
+			"traceId":   traceID,
+			"name":      trace.Name,
+			"startTime": trace.Start.UTC().Format(time.RFC3339Nano),
+			"endTime":   trace.End.UTC().Format(time.RFC3339Nano),
+			"model":     trace.Model,
+			"input":     prompt,
+			"output":    trace.Completion,
+			"usage":     usage,
+			"metadata":  metadata,
+			"level":     "DEFAULT",
+		}
+		if trace.Status >= 400 {
+			generation["level"] = "ERROR"
+			generation["statusMessage"] = fmt.Sprintf("status %d", trace.Status)
+		}
+
+		timestamp := trace.End.UTC().Format(time.RFC3339Nano)
+		events = append(events,
+			map[string]any{
+				"id":        trace.SpanID + "-trace",
+				"type":      "trace-create",
+				"timestamp": timestamp,
+				"body": map[string]any{
+					"id":        traceID,
+					"name":      trace.Name,
+					"timestamp": trace.Start.UTC().Format(time.RFC3339Nano),
+					"userId":    trace.UserID,
+					"input":     prompt,
+					"output":    trace.Completion,
+					"metadata":  map[string]any{"requested_model": trace.RequestedModel},
+				},
+			},
+			map[string]any{
+				"id":        trace.SpanID + "-generation",
+				"type":      "generation-create",
+				"timestamp": timestamp,
+				"body":      generation,
+			},
+		)
+	}
+	return json.Marshal(map[string]any{"batch": events})
+}
+
+// encodeOTLPBatch turns traces into OTLP/HTTP JSON spans carrying the OpenTelemetry GenAI
+// (OpenLLMetry) attributes.
+func encodeOTLPBatch(service string, batch []LLMTrace) ([]byte, error) {
+	spans := make([]map[string]any, len(batch))
+	for i, trace := range batch {
+		attrs := []map[string]any{
+			otlpString("gen_ai.operation.name", trace.Name),
+			otlpString("gen_ai.system", trace.Provider),
+			otlpString("gen_ai.request.model", trace.RequestedModel),
+			otlpString("gen_ai.response.model", trace.Model),
+			otlpInt("gen_ai.usage.input_tokens", trace.PromptTokens),
+			otlpInt("gen_ai.usage.output_tokens", trace.CompletionTokens),
+			otlpInt("llm.usage.total_tokens", trace.PromptTokens+trace.CompletionTokens),
+			otlpInt("http.response.status_code", trace.Status),
+			otlpString("ai_router.request_id", trace.RequestID),
+		}
+		if trace.UserID != "" {
+			attrs = append(attrs, otlpString("user.id", trace.UserID))
+		}
+		if trace.Cost != nil {
+			attrs = append(attrs, map[string]any{"key": "gen_ai.usage.cost", "value": map[string]any{"doubleValue": *trace.Cost}})
+		}
+		attrs = append(attrs, otlpPromptAttributes(trace.Prompt)...)
+		attrs = append(attrs,
+			otlpString("gen_ai.completion.0.role", "assistant"),
+			otlpString("gen_ai.completion.0.content", trace.Completion),
+		)
+		for k, v := range trace.Metadata {
+			attrs = append(attrs, otlpString("ai_router."+k, fmt.Sprint(v)))
+		}
+
+		span := map[string]any{
+			"traceId":           trace.TraceID,
+			"spanId":            trace.SpanID,
+			"name":              trace.Name + " " + trace.Model,
+			"kind":              otlpSpanKindClient,
+			"startTimeUnixNano": strconv.FormatInt(trace.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(trace.End.UnixNano(), 10),
+			"attributes":        attrs,
+		}
+		if trace.ParentSpanID != "" {
+			span["parentSpanId"] = trace.ParentSpanID
+		}
+		if trace.Status >= 400 {
+			span["status"] = map[string]any{"code": otlpStatusCodeError, "message": fmt.Sprintf("status %d", trace.Status)}
+		}
+		spans[i] = span
+	}
+	return json.Marshal(map[string]any{
+		"resourceSpans": []map[string]any{{
+			"resource": map[string]any{"attributes": []map[string]any{otlpString("service.name", service)}},
+			"scopeSpans": []map[string]any{{
+				"scope": map[string]any{"name": defaultTraceService},
+				"spans": spans,
+			}},
+		}},
+	})
+}
+
+// otlpPromptAttributes flattens request messages into gen_ai.prompt.<i>.role/content attributes.
+// A redacted or unparseable prompt is sent whole as gen_ai.prompt.
+func otlpPromptAttributes(prompt json.RawMessage) []map[string]any {
+	var messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(prompt, &messages); err != nil {
+		var text string
+		if json.Unmarshal(prompt, &text) != nil {
+			text = string(prompt)
+		}
+		return []map[string]any{otlpString("gen_ai.prompt", text)}
+	}
+	attrs := make([]map[string]any, 0, 2*len(messages))
+	for i, msg := range messages {
+		var content string
+		if json.Unmarshal(msg.Content, &content) != nil {
+			content = string(msg.Content) // Content parts stay JSON
+		}
+		prefix := "gen_ai.prompt." + strconv.Itoa(i)
+		attrs = append(attrs, otlpString(prefix+".role", msg.Role), otlpString(prefix+".content", content))
+	}
+	return attrs
+}
+
+func otlpString(key, value string) map[string]any {
+	return map[string]any{"key": key, "value": map[string]any{"stringValue": value}}
+}
+
+// otlpInt encodes an int attribute; OTLP JSON carries 64-bit integers as strings.
+func otlpInt(key string, value int) map[string]any {
+	return map[string]any{"key": key, "value": map[string]any{"intValue": strconv.Itoa(value)}}
+}
+
+func rawOrNull(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("null")
+	}
+	return raw
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,474 @@
+// Package grpcapi holds the messages and service of unified_chat.proto. They are written by hand
+// against the protobuf wire format, in place of protoc output, and carry JSON tags matching the
+// unified (OpenAI-style) request and response shapes so they convert with encoding/json.
+package grpcapi
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// UnifiedChatRequest is a chat completion request.
+type UnifiedChatRequest struct {
+	Model       string         `json:"model"`
+	Messages    []*ChatMessage `json:"messages"`
+	Temperature *float64       `json:"temperature,omitempty"`
+	TopP        *float64       `json:"top_p,omitempty"`
+	MaxTokens   *int32         `json:"max_tokens,omitempty"`
+	Stop        []string       `json:"stop,omitempty"`
+	User        string         `json:"user,omitempty"`
+	// A JSON object whose fields are merged into the unified request
+	ExtraJSON string `json:"-"`
+}
+
+// ChatMessage is a message of a request, a completion choice, or a stream delta.
+type ChatMessage struct {
+	Role       string      `json:"role,omitempty"`
+	Content    string      `json:"content"`
+	Name       string      `json:"name,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+	ToolCalls  []*ToolCall `json:"tool_calls,omitempty"`
+}
+
+// ToolCall is a function call made by the model.
+type ToolCall struct {
+	ID       string        `json:"id,omitempty"`
+	Type     string        `json:"type,omitempty"`
+	Function *FunctionCall `json:"function,omitempty"`
+	Index    int32         `json:"index,omitempty"`
+}
+
+// FunctionCall is the function and JSON arguments of a tool call.
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// UnifiedChatResponse is a complete chat completion.
+type UnifiedChatResponse struct {
+	ID      string    `json:"id"`
+	Created int64     `json:"created"`
+	Model   string    `json:"model"`
+	Choices []*Choice `json:"choices"`
+	Usage   *Usage    `json:"usage,omitempty"`
+}
+
+// Choice is one completion of a response.
+type Choice struct {
+	Index        int32        `json:"index"`
+	Message      *ChatMessage `json:"message,omitempty"`
+	FinishReason string       `json:"finish_reason,omitempty"`
+}
+
+// UnifiedChatChunk is one chunk of a streamed chat completion.
+type UnifiedChatChunk struct {
+	ID      string         `json:"id"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []*ChunkChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"`
+}
+
+// ChunkChoice is the delta of one choice in a stream chunk.
+type ChunkChoice struct {
+	Index        int32        `json:"index"`
+	Delta        *ChatMessage `json:"delta,omitempty"`
+	FinishReason string       `json:"finish_reason,omitempty"`
+}
+
+// Usage is the token usage of a completion.
+type Usage struct {
+	PromptTokens     int32 `json:"prompt_tokens"`
+	CompletionTokens int32 `json:"completion_tokens"`
+	TotalTokens      int32 `json:"total_tokens"`
+}
+
+func (m *UnifiedChatRequest) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.Model)
+	for _, msg := range m.Messages {
+		b = appendMessage(b, 2, msg.append(nil))
+	}
+	if m.Temperature != nil {
+		b = appendDouble(b, 3, *m.Temperature)
+	}
+	if m.TopP != nil {
+		b = appendDouble(b, 4, *m.TopP)
+	}
+	if m.MaxTokens != nil {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(*m.MaxTokens)))
+	}
+	for _, stop := range m.Stop {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendString(b, stop)
+	}
+	b = appendString(b, 7, m.User)
+	b = appendString(b, 8, m.ExtraJSON)
+	return b, nil
+}
+
+func (m *UnifiedChatRequest) Unmarshal(b []byte) error {
+	*m = UnifiedChatRequest{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.Model)
+		case 2:
+			msg := &ChatMessage{}
+			m.Messages = append(m.Messages, msg)
+			return consumeMessage(typ, b, msg.Unmarshal)
+		case 3:
+			m.Temperature = new(float64)
+			return consumeDouble(typ, b, m.Temperature)
+		case 4:
+			m.TopP = new(float64)
+			return consumeDouble(typ, b, m.TopP)
+		case 5:
+			m.MaxTokens = new(int32)
+			return consumeInt32(typ, b, m.MaxTokens)
+		case 6:
+			var stop string
+			n, err := consumeString(typ, b, &stop)
+			if n > 0 {
+				m.Stop = append(m.Stop, stop)
+			}
+			return n, err
+		case 7:
+			return consumeString(typ, b, &m.User)
+		case 8:
+			return consumeString(typ, b, &m.ExtraJSON)
+		}
+		return 0, nil
+	})
+}
+
+func (m *ChatMessage) append(b []byte) []byte {
+	b = appendString(b, 1, m.Role)
+	b = appendString(b, 2, m.Content)
+	b = appendString(b, 3, m.Name)
+	b = appendString(b, 4, m.ToolCallID)
+	for _, call := range m.ToolCalls {
+		b = appendMessage(b, 5, call.append(nil))
+	}
+	return b
+}
+
+func (m *ChatMessage) Unmarshal(b []byte) error {
+	*m = ChatMessage{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.Role)
+		case 2:
+			return consumeString(typ, b, &m.Content)
+		case 3:
+			return consumeString(typ, b, &m.Name)
+		case 4:
+			return consumeString(typ, b, &m.ToolCallID)
+		case 5:
+			call := &ToolCall{}
+			m.ToolCalls = append(m.ToolCalls, call)
+			return consumeMessage(typ, b, call.Unmarshal)
+		}
+		return 0, nil
+	})
+}
+
+func (m *ToolCall) append(b []byte) []byte {
+	b = appendString(b, 1, m.ID)
+	b = appendString(b, 2, m.Type)
+	if m.Function != nil {
+		b = appendMessage(b, 3, m.Function.append(nil))
+	}
+	b = appendInt(b, 4, int64(m.Index))
+	return b
+}
+
+func (m *ToolCall) Unmarshal(b []byte) error {
+	*m = ToolCall{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.ID)
+		case 2:
+			return consumeString(typ, b, &m.Type)
+		case 3:
+			m.Function = &FunctionCall{}
+			return consumeMessage(typ, b, m.Function.Unmarshal)
+		case 4:
+			return consumeInt32(typ, b, &m.Index)
+		}
+		return 0, nil
+	})
+}
+
+func (m *FunctionCall) append(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Arguments)
+	return b
+}
+
+func (m *FunctionCall) Unmarshal(b []byte) error {
+	*m = FunctionCall{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.Name)
+		case 2:
+			return consumeString(typ, b, &m.Arguments)
+		}
+		return 0, nil
+	})
+}
+
+func (m *UnifiedChatResponse) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.ID)
+	b = appendInt(b, 2, m.Created)
+	b = appendString(b, 3, m.Model)
+	for _, choice := range m.Choices {
+		b = appendMessage(b, 4, choice.append(nil))
+	}
+	if m.Usage != nil {
+		b = appendMessage(b, 5, m.Usage.append(nil))
+	}
+	return b, nil
+}
+
+func (m *UnifiedChatResponse) Unmarshal(b []byte) error {
+	*m = UnifiedChatResponse{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.ID)
+		case 2:
+			return consumeInt64(typ, b, &m.Created)
+		case 3:
+			return consumeString(typ, b, &m.Model)
+		case 4:
+			choice := &Choice{}
+			m.Choices = append(m.Choices, choice)
+			return consumeMessage(typ, b, choice.Unmarshal)
+		case 5:
+			m.Usage = &Usage{}
+			return consumeMessage(typ, b, m.Usage.Unmarshal)
+		}
+		return 0, nil
+	})
+}
+
+func (m *Choice) append(b []byte) []byte {
+	b = appendInt(b, 1, int64(m.Index))
+	if m.Message != nil {
+		b = appendMessage(b, 2, m.Message.append(nil))
+	}
+	b = appendString(b, 3, m.FinishReason)
+	return b
+}
+
+func (m *Choice) Unmarshal(b []byte) error {
+	*m = Choice{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeInt32(typ, b, &m.Index)
+		case 2:
+			m.Message = &ChatMessage{}
+			return consumeMessage(typ, b, m.Message.Unmarshal)
+		case 3:
+			return consumeString(typ, b, &m.FinishReason)
+		}
+		return 0, nil
+	})
+}
+
+func (m *UnifiedChatChunk) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.ID)
+	b = appendInt(b, 2, m.Created)
+	b = appendString(b, 3, m.Model)
+	for _, choice := range m.Choices {
+		b = appendMessage(b, 4, choice.append(nil))
+	}
+	if m.Usage != nil {
+		b = appendMessage(b, 5, m.Usage.append(nil))
+	}
+	return b, nil
+}
+
+func (m *UnifiedChatChunk) Unmarshal(b []byte) error {
+	*m = UnifiedChatChunk{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.ID)
+		case 2:
+			return consumeInt64(typ, b, &m.Created)
+		case 3:
+			return consumeString(typ, b, &m.Model)
+		case 4:
+			choice := &ChunkChoice{}
+			m.Choices = append(m.Choices, choice)
+			return consumeMessage(typ, b, choice.Unmarshal)
+		case 5:
+			m.Usage = &Usage{}
+			return consumeMessage(typ, b, m.Usage.Unmarshal)
+		}
+		return 0, nil
+	})
+}
+
+func (m *ChunkChoice) append(b []byte) []byte {
+	b = appendInt(b, 1, int64(m.Index))
+	if m.Delta != nil {
+		b = appendMessage(b, 2, m.Delta.append(nil))
+	}
+	b = appendString(b, 3, m.FinishReason)
+	return b
+}
+
+func (m *ChunkChoice) Unmarshal(b []byte) error {
+	*m = ChunkChoice{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeInt32(typ, b, &m.Index)
+		case 2:
+			m.Delta = &ChatMessage{}
+			return consumeMessage(typ, b, m.Delta.Unmarshal)
+		case 3:
+			return consumeString(typ, b, &m.FinishReason)
+		}
+		return 0, nil
+	})
+}
+
+func (m *Usage) append(b []byte) []byte {
+	b = appendInt(b, 1, int64(m.PromptTokens))
+	b = appendInt(b, 2, int64(m.CompletionTokens))
+	b = appendInt(b, 3, int64(m.TotalTokens))
+	return b
+}
+
+func (m *Usage) Unmarshal(b []byte) error {
+	*m = Usage{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeInt32(typ, b, &m.PromptTokens)
+		case 2:
+			return consumeInt32(typ, b, &m.CompletionTokens)
+		case 3:
+			return consumeInt32(typ, b, &m.TotalTokens)
+		}
+		return 0, nil
+	})
+}
+
+// Proto3 leaves fields with zero values off the wire.
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// consumeFields walks the fields of an encoded message. field consumes the value of a field it
+// knows and returns its length; fields it returns 0 for are skipped.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeString(typ protowire.Type, b []byte, dst *string) (int, error) {
+	if typ != protowire.BytesType {
+		return 0, nil
+	}
+	s, n := protowire.ConsumeString(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	*dst = s
+	return n, nil
+}
+
+func consumeInt64(typ protowire.Type, b []byte, dst *int64) (int, error) {
+	if typ != protowire.VarintType {
+		return 0, nil
+	}
+	v, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	*dst = int64(v)
+	return n, nil
+}
+
+func consumeInt32(typ protowire.Type, b []byte, dst *int32) (int, error) {
+	var v int64
+	n, err := consumeInt64(typ, b, &v)
+	*dst = int32(v)
+	return n, err
+}
+
+func consumeDouble(typ protowire.Type, b []byte, dst *float64) (int, error) {
+	if typ != protowire.Fixed64Type {
+		return 0, nil
+	}
+	v, n := protowire.ConsumeFixed64(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	*dst = math.Float64frombits(v)
+	return n, nil
+}
+
+func consumeMessage(typ protowire.Type, b []byte, unmarshal func([]byte) error) (int, error) {
+	if typ != protowire.BytesType {
+		return 0, nil
+	}
+	msg, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	return n, unmarshal(msg)
+}
@@ -0,0 +1,109 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+// ChatServiceServer is the server API of caddy_ai_router.v1.ChatService.
+type ChatServiceServer interface {
+	CreateChatCompletion(context.Context, *UnifiedChatRequest) (*UnifiedChatResponse, error)
+	StreamChatCompletion(*UnifiedChatRequest, ChatService_StreamChatCompletionServer) error
+}
+
+// ChatService_StreamChatCompletionServer sends the chunks of a streamed completion.
+type ChatService_StreamChatCompletionServer interface {
+	Send(*UnifiedChatChunk) error
+	grpc.ServerStream
+}
+
+// RegisterChatServiceServer registers the chat service implementation with a gRPC server.
+func RegisterChatServiceServer(s grpc.ServiceRegistrar, srv ChatServiceServer) {
+	s.RegisterService(&ChatService_ServiceDesc, srv)
+}
+
+// ChatService_ServiceDesc describes caddy_ai_router.v1.ChatService for grpc.Server.
+var ChatService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "caddy_ai_router.v1.ChatService",
+	HandlerType: (*ChatServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateChatCompletion",
+			Handler:    createChatCompletionHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamChatCompletion",
+			Handler:       streamChatCompletionHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "unified_chat.proto",
+}
+
+func createChatCompletionHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(UnifiedChatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).CreateChatCompletion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/caddy_ai_router.v1.ChatService/CreateChatCompletion",
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(ChatServiceServer).CreateChatCompletion(ctx, req.(*UnifiedChatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func streamChatCompletionHandler(srv any, stream grpc.ServerStream) error {
+	in := new(UnifiedChatRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(ChatServiceServer).StreamChatCompletion(in, &chatStreamServer{stream})
+}
+
+type chatStreamServer struct {
+	grpc.ServerStream
+}
+
+func (s *chatStreamServer) Send(chunk *UnifiedChatChunk) error {
+	return s.ServerStream.SendMsg(chunk)
+}
+
+// Codec encodes the messages of this package on the wire. Install it with grpc.ForceServerCodec
+// (or grpc.ForceCodec on clients), since the messages don't implement proto.Message.
+type Codec struct{}
+
+type wireMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+func (Codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(wireMessage)
+	if !ok {
+		return nil, fmt.Errorf("grpcapi: cannot marshal %T", v)
+	}
+	return m.Marshal()
+}
+
+func (Codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(wireMessage)
+	if !ok {
+		return fmt.Errorf("grpcapi: cannot unmarshal into %T", v)
+	}
+	return m.Unmarshal(data)
+}
+
+// Name is "proto": the encoding is plain protobuf, so clients generated from the .proto interoperate.
+func (Codec) Name() string {
+	return "proto"
+}
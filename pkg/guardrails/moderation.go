@@ -0,0 +1,202 @@
+package guardrails
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	// StyleOpenAI talks to an OpenAI-compatible /moderations endpoint.
+	StyleOpenAI = "openai"
+	// StyleWebhook posts the payload to a custom webhook that returns a verdict.
+	StyleWebhook = "webhook"
+
+	// ActionBlock rejects flagged content.
+	ActionBlock = "block"
+	// ActionFlag lets flagged content through but marks it in headers and events.
+	ActionFlag = "flag"
+
+	// FailOpen lets traffic through when the moderation endpoint is unavailable.
+	FailOpen = "open"
+	// FailClosed rejects traffic when the moderation endpoint is unavailable.
+	FailClosed = "closed"
+
+	// StageRequest marks a check on the incoming unified request.
+	StageRequest = "request"
+	// StageResponse marks a check on the provider response.
+	StageResponse = "response"
+)
+
+// ModerationConfig configures an external moderation endpoint.
+type ModerationConfig struct {
+	Endpoint      string         `json:"endpoint,omitempty"`
+	Style         string         `json:"style,omitempty"`
+	Model         string         `json:"model,omitempty"`
+	KeyTarget     string         `json:"key_target,omitempty"`
+	Action        string         `json:"action,omitempty"`
+	FailMode      string         `json:"fail_mode,omitempty"`
+	CheckResponse bool           `json:"check_response,omitempty"`
+	Timeout       caddy.Duration `json:"timeout,omitempty"`
+}
+
+// Verdict is the normalized result of a moderation check.
+type Verdict struct {
+	Flagged    bool     `json:"flagged"`
+	Categories []string `json:"categories,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+// Moderator sends content to the configured moderation endpoint.
+type Moderator struct {
+	config     ModerationConfig
+	httpClient *http.Client
+	logger     *zap.Logger
+}
+
+// NewModerator creates a Moderator, filling defaults for unset options.
+func NewModerator(config ModerationConfig, logger *zap.Logger) (*Moderator, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("moderation endpoint is required")
+	}
+	if config.Style == "" {
+		config.Style = StyleOpenAI
+	}
+	if config.Style != StyleOpenAI && config.Style != StyleWebhook {
+		return nil, fmt.Errorf("unsupported moderation style '%s'", config.Style)
+	}
+	if config.Action == "" {
+		config.Action = ActionBlock
+	}
+	if config.Action != ActionBlock && config.Action != ActionFlag {
+		return nil, fmt.Errorf("unsupported moderation action '%s'", config.Action)
+	}
+	if config.FailMode == "" {
+		config.FailMode = FailOpen
+	}
+	if config.FailMode != FailOpen && config.FailMode != FailClosed {
+		return nil, fmt.Errorf("unsupported moderation fail_mode '%s'", config.FailMode)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = caddy.Duration(5 * time.Second)
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Moderator{
+		config:     config,
+		httpClient: &http.Client{Timeout: time.Duration(config.Timeout)},
+		logger:     logger,
+	}, nil
+}
+
+// Config returns the effective configuration of the moderator.
+func (m *Moderator) Config() ModerationConfig {
+	return m.config
+}
+
+// Blocks reports whether a flagged verdict should reject the content.
+func (m *Moderator) Blocks() bool {
+	return m.config.Action == ActionBlock
+}
+
+// FailClosed reports whether moderation errors should reject the content.
+func (m *Moderator) FailClosed() bool {
+	return m.config.FailMode == FailClosed
+}
+
+// Check sends the given text to the moderation endpoint. The payload is forwarded
+// as-is to webhooks so they can inspect the full unified request or response.
+func (m *Moderator) Check(ctx context.Context, stage string, text string, payload json.RawMessage, apiKey string) (*Verdict, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(m.config.Timeout))
+	defer cancel()
+
+	var reqBody []byte
+	var err error
+	switch m.config.Style {
+	case StyleWebhook:
+		reqBody, err = json.Marshal(map[string]any{
+			"stage":   stage,
+			"input":   text,
+			"payload": payload,
+		})
+	default:
+		body := map[string]any{"input": text}
+		if m.config.Model != "" {
+			body["model"] = m.config.Model
+		}
+		reqBody, err = json.Marshal(body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("marshal moderation request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.config.Endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", m.config.Endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", m.config.Endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", m.config.Endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request to %s returned status %d: %s", m.config.Endpoint, resp.StatusCode, string(respBody))
+	}
+
+	if m.config.Style == StyleWebhook {
+		var verdict Verdict
+		if err := json.Unmarshal(respBody, &verdict); err != nil {
+			return nil, fmt.Errorf("failed to decode response from %s: %w", m.config.Endpoint, err)
+		}
+		m.logger.Debug("Moderation verdict", zap.String("stage", stage), zap.Bool("flagged", verdict.Flagged), zap.String("reason", verdict.Reason))
+		return &verdict, nil
+	}
+
+	var openaiResp struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(respBody, &openaiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response from %s: %w", m.config.Endpoint, err)
+	}
+
+	verdict := &Verdict{}
+	for _, result := range openaiResp.Results {
+		if !result.Flagged {
+			continue
+		}
+		verdict.Flagged = true
+		for category, hit := range result.Categories {
+			if hit {
+				verdict.Categories = append(verdict.Categories, category)
+			}
+		}
+	}
+	if verdict.Flagged {
+		verdict.Reason = "flagged: " + strings.Join(verdict.Categories, ", ")
+	}
+	m.logger.Debug("Moderation verdict", zap.String("stage", stage), zap.Bool("flagged", verdict.Flagged), zap.Strings("categories", verdict.Categories))
+	return verdict, nil
+}
@@ -1,22 +1,55 @@
 package providers
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
 	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
 	"go.uber.org/zap"
 )
 
+// AnthropicAPIVersion is sent as anthropic-version unless the client or configuration sets one.
+const AnthropicAPIVersion = "2023-06-01"
+
 // AnthropicProvider implements the Provider interface for Anthropic.
-type AnthropicProvider struct{}
+type AnthropicProvider struct {
+	// API version pinned for every request, whatever the client asks for; empty keeps the
+	// client's anthropic-version, or AnthropicAPIVersion
+	Version string
+	// Beta features enabled on every request, besides those the client asks for
+	Betas []string
+}
 
 // Name returns the name of the provider.
 func (p *AnthropicProvider) Name() string {
 	return "anthropic"
 }
 
+// UnsupportedParams lists the unified request parameters dropped for Anthropic.
+func (p *AnthropicProvider) UnsupportedParams() []string {
+	return transforms.AnthropicUnsupportedParams
+}
+
+// SupportsMultipleChoices reports that n isn't honoured natively. The Messages API returns a single completion per request.
+func (p *AnthropicProvider) SupportsMultipleChoices() bool {
+	return false
+}
+
+// SupportsDeveloperRole reports that developer messages are taken, into the system prompt.
+func (p *AnthropicProvider) SupportsDeveloperRole() bool {
+	return true
+}
+
+// SupportsPrefill reports that a final assistant message is continued natively.
+func (p *AnthropicProvider) SupportsPrefill() bool {
+	return true
+}
+
 // ModifyCompletionRequest transforms the incoming request to a format Anthropic understands.
 func (p *AnthropicProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
 	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/v1/messages"
@@ -32,24 +65,141 @@ func (p *AnthropicProvider) ModifyCompletionRequest(r *http.Request, modelName s
 
 	r.Header.Set("Content-Type", "application/json")
 
-	// Anthropic specific headers
-	r.Header.Set("x-api-key", r.Header.Get("Authorization"))
-	// r.Header.Set("anthropic-version", "2023-06-01")
+	// Anthropic specific headers; header_up in the provider block can override any of these
+	if key := anthropicAPIKey(r.Header.Get("Authorization")); key != "" {
+		r.Header.Set("x-api-key", key)
+	}
 	r.Header.Del("Authorization")
+	switch {
+	case p.Version != "":
+		r.Header.Set("anthropic-version", p.Version)
+	case r.Header.Get("anthropic-version") == "":
+		r.Header.Set("anthropic-version", AnthropicAPIVersion)
+	}
+	if betas := mergeAnthropicBetas(r.Header.Values("anthropic-beta"), p.Betas); len(betas) > 0 {
+		r.Header.Set("anthropic-beta", strings.Join(betas, ","))
+	}
 
 	return nil
 }
 
+// anthropicAPIKey returns the key of an Authorization header, without its scheme: Anthropic
+// takes the bare key in x-api-key.
+func anthropicAPIKey(authorization string) string {
+	authorization = strings.TrimSpace(authorization)
+	if scheme, key, ok := strings.Cut(authorization, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(key)
+	}
+	return authorization
+}
+
+// mergeAnthropicBetas combines the client's anthropic-beta headers, each a comma-separated
+// list, with the configured betas, dropping duplicates.
+func mergeAnthropicBetas(headers []string, configured []string) []string {
+	var betas []string
+	seen := make(map[string]bool)
+	add := func(beta string) {
+		if beta = strings.TrimSpace(beta); beta != "" && !seen[beta] {
+			seen[beta] = true
+			betas = append(betas, beta)
+		}
+	}
+	for _, header := range headers {
+		for _, beta := range strings.Split(header, ",") {
+			add(beta)
+		}
+	}
+	for _, beta := range configured {
+		add(beta)
+	}
+	return betas
+}
+
+func (p *AnthropicProvider) version() string {
+	if p.Version != "" {
+		return p.Version
+	}
+	return AnthropicAPIVersion
+}
+
 // ModifyCompletionResponse transforms the Anthropic's response to the unified format.
 func (p *AnthropicProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
-	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
-		return common.HookHttpResponseJsonChunks(func(body []byte) ([]byte, error) {
-			return transforms.TransformResponseFromAnthropic(body, logger)
-		})(resp, body)
+	if resp.StatusCode >= 400 {
+		return nil // Error bodies are normalized by the router
+	}
+	return common.HookHttpResponseJson(resp, func(body []byte) ([]byte, error) {
+		return transforms.TransformResponseFromAnthropic(body, logger)
 	})
 }
 
-// FetchModels is a no-op for Anthropic as they don't have a models API.
+// FetchModels fetches the models from the Anthropic API, following pagination.
 func (p *AnthropicProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
-	return nil, nil
+	modelsURL := strings.TrimRight(baseURL, "/") + "/v1/models"
+
+	var models []map[string]any
+	afterID := ""
+	for {
+		req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request for %s: %w", modelsURL, err)
+		}
+		q := req.URL.Query()
+		q.Set("limit", "1000")
+		if afterID != "" {
+			q.Set("after_id", afterID)
+		}
+		req.URL.RawQuery = q.Encode()
+		req.Header.Set("User-Agent", "Caddy-AI-Router")
+		req.Header.Set("anthropic-version", p.version())
+		if apiKey != "" {
+			req.Header.Set("x-api-key", apiKey)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request to %s failed: %w", modelsURL, err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("request to %s returned status %d: %s", modelsURL, resp.StatusCode, string(bodyBytes))
+		}
+
+		var providerResp struct {
+			Data []struct {
+				ID          string `json:"id"`
+				DisplayName string `json:"display_name"`
+				CreatedAt   string `json:"created_at"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&providerResp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response from %s: %w", modelsURL, err)
+		}
+
+		for _, m := range providerResp.Data {
+			entry := map[string]any{
+				"id":       m.ID,
+				"object":   "model",
+				"name":     m.DisplayName,
+				"owned_by": "anthropic",
+			}
+			if created, err := time.Parse(time.RFC3339, m.CreatedAt); err == nil {
+				entry["created"] = created.Unix()
+			}
+			models = append(models, entry)
+		}
+
+		if !providerResp.HasMore || providerResp.LastID == "" || providerResp.LastID == afterID {
+			break
+		}
+		afterID = providerResp.LastID
+	}
+
+	logger.Debug("Fetched Anthropic models", zap.Int("count", len(models)))
+	return models, nil
 }
@@ -0,0 +1,117 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// AsyncJobState is the state of an upstream job as reported by a single poll.
+type AsyncJobState struct {
+	Done  bool   // The job reached a terminal state
+	Error string // Non-empty if the job terminated unsuccessfully
+}
+
+// AsyncPollConfig controls how PollAsyncJob waits for an upstream job.
+type AsyncPollConfig struct {
+	Interval    time.Duration // Initial delay between polls
+	MaxInterval time.Duration // Upper bound for the backed off delay
+	Timeout     time.Duration // Overall deadline for the job
+}
+
+// DefaultAsyncPollConfig is used by providers that don't need custom polling behavior.
+var DefaultAsyncPollConfig = AsyncPollConfig{
+	Interval:    500 * time.Millisecond,
+	MaxInterval: 5 * time.Second,
+	Timeout:     5 * time.Minute,
+}
+
+// AsyncPollHeader returns the headers for polling pollURL about a job started by the upstream
+// request r: r's credentials, but only if pollURL has r's scheme and host, so a job URL pointing
+// elsewhere never gets the provider's key.
+func AsyncPollHeader(r *http.Request, pollURL string) http.Header {
+	header := http.Header{}
+	u, err := url.Parse(pollURL)
+	if err != nil || u.Scheme != r.URL.Scheme || !strings.EqualFold(u.Host, r.URL.Host) {
+		return header
+	}
+	for _, name := range []string{"Authorization", "x-api-key"} {
+		if value := r.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	return header
+}
+
+// PollAsyncJob polls pollURL through client, the provider's, until check reports the job is
+// done, returning the final body. It is the shared machinery for upstreams that acknowledge a job
+// instead of returning a completion, so the provider only has to know how to read its own job
+// state. Deadlines come from the context and cfg.
+func PollAsyncJob(ctx context.Context, client *http.Client, pollURL string, header http.Header, cfg AsyncPollConfig, check func(body []byte) (AsyncJobState, error), logger *zap.Logger) ([]byte, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultAsyncPollConfig.Interval
+	}
+	if cfg.MaxInterval < cfg.Interval {
+		cfg.MaxInterval = cfg.Interval
+	}
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	interval := cfg.Interval
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("polling %s: %w", pollURL, ctx.Err())
+		case <-time.After(interval):
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pollURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request for %s: %w", pollURL, err)
+		}
+		req.Header = header.Clone()
+		req.Header.Set("User-Agent", "Caddy-AI-Router")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request to %s failed: %w", pollURL, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response from %s: %w", pollURL, err)
+		}
+		var state AsyncJobState
+		switch resp.StatusCode {
+		case http.StatusOK:
+			if state, err = check(body); err != nil {
+				return nil, err
+			}
+		case http.StatusAccepted:
+			// Still running, for upstreams that answer polls of unfinished jobs with 202
+		default:
+			return nil, fmt.Errorf("request to %s returned status %d: %s", pollURL, resp.StatusCode, string(body))
+		}
+		if state.Done {
+			if state.Error != "" {
+				return body, fmt.Errorf("async job failed: %s", state.Error)
+			}
+			logger.Debug("Async upstream job completed", zap.String("poll_url", pollURL), zap.Int("polls", attempt))
+			return body, nil
+		}
+
+		interval *= 2
+		if interval > cfg.MaxInterval {
+			interval = cfg.MaxInterval
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
@@ -14,19 +15,83 @@ import (
 )
 
 // CloudflareProvider implements the Provider interface for Cloudflare.
-type CloudflareProvider struct{}
+type CloudflareProvider struct {
+	// AccountID of the Workers AI models. When set, model discovery goes straight to the
+	// Cloudflare API even if requests are sent through an AI Gateway.
+	AccountID string
+	// Gateway is set when the base URL is an AI Gateway, whose Workers AI routes have no /run segment
+	Gateway bool
+	// OpenAICompatible reports whether a model is served through the OpenAI-compatible /v1
+	// endpoints instead of /run
+	OpenAICompatible func(modelName string) bool
+	// LoRA adapters applied to /run requests, by model
+	LoRA map[string]string
+}
+
+// cloudflareOpenAIPath is the OpenAI-compatible chat completions path of Workers AI.
+const cloudflareOpenAIPath = "/v1/chat/completions"
+
+// runPath returns the path of a model on the /run endpoint.
+func (p *CloudflareProvider) runPath(modelName string) string {
+	if p.Gateway {
+		return "/" + modelName
+	}
+	return "/run/" + modelName
+}
+
+// pathModel returns the model of a /run path.
+func (p *CloudflareProvider) pathModel(path string) string {
+	sep := "/run/"
+	if p.Gateway {
+		sep = "/workers-ai/"
+	}
+	_, modelName, _ := strings.Cut(path, sep)
+	return modelName
+}
+
+// openAICompatible reports whether a chat request for the model goes to the /v1 endpoint. LoRA
+// adapters are only applied on /run.
+func (p *CloudflareProvider) openAICompatible(modelName string) bool {
+	if p.OpenAICompatible == nil || p.LoRA[modelName] != "" {
+		return false
+	}
+	return p.OpenAICompatible(modelName)
+}
 
 // Name returns the name of the provider.
 func (p *CloudflareProvider) Name() string {
 	return "cloudflare"
 }
 
-// ModifyCompletionRequest sets the URL path for the completion request.
+// UnsupportedParams lists the unified request parameters dropped for Cloudflare.
+func (p *CloudflareProvider) UnsupportedParams() []string {
+	return transforms.CloudflareAIUnsupportedParams
+}
+
+// SupportsMultipleChoices reports that n isn't honoured natively. Workers AI returns a single completion per request.
+func (p *CloudflareProvider) SupportsMultipleChoices() bool {
+	return false
+}
+
+// ModifyCompletionRequest sets the URL path for the completion request: the model's /run path, or
+// the OpenAI-compatible endpoint for models configured to use it.
 func (p *CloudflareProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
-	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/run/" + modelName
+	if p.openAICompatible(modelName) {
+		r.URL.Path = strings.TrimRight(r.URL.Path, "/") + cloudflareOpenAIPath
+		return common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+			transformedBody, err := transforms.TransformRequestToCloudflareOpenAI(body, modelName, logger)
+			if err != nil {
+				logger.Error("Failed to transform request body for Cloudflare AI", zap.Error(err))
+				return nil, err
+			}
+			return transformedBody, nil
+		})
+	}
+
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + p.runPath(modelName)
 
 	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
-		transformedBody, err := transforms.TransformRequestToCloudflareAI(r, body, modelName, logger)
+		transformedBody, err := transforms.TransformRequestToCloudflareAI(r, body, modelName, p.LoRA[modelName], logger)
 		if err != nil {
 			logger.Error("Failed to transform request body for Cloudflare AI", zap.Error(err))
 			return nil, err
@@ -37,17 +102,96 @@ func (p *CloudflareProvider) ModifyCompletionRequest(r *http.Request, modelName
 	return nil
 }
 
-// ModifyCompletionResponse is a no-op for Cloudflare.
+// ModifyCompletionResponse converts Workers AI responses to the unified format.
 func (p *CloudflareProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	if resp.StatusCode >= 400 {
+		return nil // Error bodies are normalized by the router
+	}
+	if resp.Request != nil && strings.HasSuffix(resp.Request.URL.Path, cloudflareOpenAIPath) {
+		return nil // Already in the unified format
+	}
+	return common.HookHttpResponseJson(resp, func(body []byte) ([]byte, error) {
+		return transforms.TransformResponseFromCloudflareAI(body, logger)
+	})
+}
+
+// ModifyImagesRequest sets the URL path and body for Workers AI text-to-image models.
+func (p *CloudflareProvider) ModifyImagesRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + p.runPath(modelName)
+
+	return common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformImagesRequestToCloudflare(body, logger)
+		if err != nil {
+			logger.Error("Failed to transform images request body for Cloudflare AI", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+}
+
+// ModifyImagesResponse converts raw or base64 Workers AI images to the unified format.
+func (p *CloudflareProvider) ModifyImagesResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	format, _ := r.Context().Value(common.ImageResponseFormatContextKeyString).(string)
+	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformCloudflareImagesResponse(resp.Header.Get("Content-Type"), body, format)
+		if err != nil {
+			logger.Error("Failed to transform images response from Cloudflare AI", zap.Error(err))
+			return body, err
+		}
+		resp.Header.Set("Content-Type", "application/json")
+		return transformedBody, nil
+	})
+}
+
+// ModifyRerankRequest sets the URL path and body for Workers AI reranker models.
+func (p *CloudflareProvider) ModifyRerankRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + p.runPath(modelName)
+
+	return common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRerankRequestToCloudflare(body, logger)
+		if err != nil {
+			logger.Error("Failed to transform rerank request body for Cloudflare AI", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+}
+
+// ModifyRerankResponse converts Workers AI reranker scores to the unified format.
+func (p *CloudflareProvider) ModifyRerankResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	modelName := p.pathModel(r.URL.Path)
 	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
-		return common.HookHttpResponseJsonChunks(func(body []byte) ([]byte, error) {
-			return transforms.TransformResponseFromCloudflareAI(body, logger)
-		})(resp, body)
+		transformedBody, err := transforms.TransformCloudflareRerankResponse(body, modelName)
+		if err != nil {
+			logger.Error("Failed to transform rerank response from Cloudflare AI", zap.Error(err))
+			return body, err
+		}
+		return transformedBody, nil
 	})
 }
 
-// FetchModels fetches the models from the Cloudflare API.
+// CloudflareAccountURL returns the Workers AI base URL of an account on the Cloudflare API.
+func CloudflareAccountURL(accountID string) string {
+	return "https://api.cloudflare.com/client/v4/accounts/" + accountID + "/ai"
+}
+
+// CloudflareGatewayURL returns the Workers AI base URL of an account's AI Gateway.
+func CloudflareGatewayURL(accountID, gateway string) string {
+	return "https://gateway.ai.cloudflare.com/v1/" + accountID + "/" + gateway + "/workers-ai"
+}
+
+// FetchModels fetches the models from the Cloudflare API. AI Gateways don't serve the model
+// search, so it's asked of the account directly when one is configured.
 func (p *CloudflareProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	if p.AccountID != "" {
+		baseURL = CloudflareAccountURL(p.AccountID)
+	}
 	base := strings.TrimRight(baseURL, "/") + "/models/search"
 
 	type cursors struct {
@@ -116,10 +260,38 @@ func (p *CloudflareProvider) FetchModels(baseURL string, apiKey string, httpClie
 
 		for _, model := range pr.Result {
 			if name, ok := model["name"].(string); ok {
-				all = append(all, map[string]any{
+				entry := map[string]any{
 					"id":   name,
 					"name": name,
-				})
+				}
+				if description, ok := model["description"].(string); ok {
+					entry["description"] = description
+				}
+				// Text generation models without the function_calling property can't take tools
+				if task, ok := model["task"].(map[string]any); ok && task["name"] == "Text Generation" {
+					entry["supported_parameters"] = []string{"max_tokens", "temperature"}
+				}
+				// Workers AI exposes extra metadata as a list of {property_id, value} pairs
+				if properties, ok := model["properties"].([]any); ok {
+					for _, prop := range properties {
+						propMap, ok := prop.(map[string]any)
+						if !ok {
+							continue
+						}
+						value := fmt.Sprintf("%v", propMap["value"])
+						switch propMap["property_id"] {
+						case "context_window", "max_input_tokens":
+							if contextLength, err := strconv.Atoi(value); err == nil {
+								entry["context_length"] = contextLength
+							}
+						case "function_calling":
+							if value == "true" {
+								entry["supported_parameters"] = []string{"max_tokens", "temperature", "tools"}
+							}
+						}
+					}
+				}
+				all = append(all, entry)
 			}
 		}
 
@@ -0,0 +1,108 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// CohereProvider implements the Provider interface for Cohere's REST API (base URL
+// without a version, e.g. https://api.cohere.com). It only reranks; Cohere chat models
+// can be reached through its OpenAI-compatible endpoint with the default style.
+type CohereProvider struct{}
+
+// Name returns the name of the provider.
+func (p *CohereProvider) Name() string {
+	return "cohere"
+}
+
+// ModifyCompletionRequest rejects chat completions, which need Cohere's compatibility API.
+func (p *CohereProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	return errors.New("cohere style does not support chat completions, use Cohere's OpenAI-compatible endpoint instead")
+}
+
+// ModifyCompletionResponse is a no-op for Cohere.
+func (p *CohereProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// ModifyRerankRequest targets the v2 rerank endpoint with plain-text documents.
+func (p *CohereProvider) ModifyRerankRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/v2/rerank"
+
+	return common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRerankRequestToCohere(body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform rerank request body for Cohere", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+}
+
+// ModifyRerankResponse normalizes Cohere's billed units into unified rerank usage.
+func (p *CohereProvider) ModifyRerankResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRerankResponse(body)
+		if err != nil {
+			logger.Error("Failed to transform rerank response from Cohere", zap.Error(err))
+			return body, err
+		}
+		return transformedBody, nil
+	})
+}
+
+// FetchModels lists the Cohere models that serve the rerank endpoint.
+func (p *CohereProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	modelsURL := strings.TrimRight(baseURL, "/") + "/v1/models?endpoint=rerank"
+	req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", modelsURL, err)
+	}
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", modelsURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s returned status %d: %s", modelsURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	var response struct {
+		Models []struct {
+			Name          string `json:"name"`
+			ContextLength int    `json:"context_length"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response from %s: %w", modelsURL, err)
+	}
+
+	models := make([]map[string]any, 0, len(response.Models))
+	for _, model := range response.Models {
+		models = append(models, map[string]any{
+			"id":             model.Name,
+			"object":         "model",
+			"context_length": model.ContextLength,
+			"owned_by":       "cohere",
+		})
+	}
+	return models, nil
+}
@@ -0,0 +1,111 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// fetchOpenAICompatibleModels lists the models of an OpenAI-compatible /models endpoint as they
+// come.
+func fetchOpenAICompatibleModels(baseURL string, apiKey string, httpClient *http.Client) ([]map[string]any, error) {
+	modelsURL := strings.TrimRight(baseURL, "/") + "/models"
+	req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", modelsURL, err)
+	}
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", modelsURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s returned status %d: %s", modelsURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	var providerResp struct {
+		Data []map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&providerResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response from %s: %w", modelsURL, err)
+	}
+	return providerResp.Data, nil
+}
+
+// knownModel is metadata a vendor's models endpoint leaves out. Static model manifests override it.
+type knownModel struct {
+	ContextLength   int
+	MaxOutputTokens int
+	Params          []string // Supported parameters, which capabilities are derived from
+	TokensPerSecond int      // Typical output speed, which latency_aware routing prefers when stats are even
+}
+
+// applyKnownModels fills in context length, output limit and supported parameters for listed
+// models the table knows, unless the listing already has them.
+func applyKnownModels(models []map[string]any, table map[string]knownModel) {
+	for _, model := range models {
+		id, _ := model["id"].(string)
+		known, ok := table[id]
+		if !ok {
+			continue
+		}
+		if _, ok := model["context_length"]; !ok && known.ContextLength > 0 {
+			model["context_length"] = known.ContextLength
+		}
+		if _, ok := model["top_provider"]; !ok && known.MaxOutputTokens > 0 {
+			model["top_provider"] = map[string]any{
+				"context_length":        known.ContextLength,
+				"max_completion_tokens": known.MaxOutputTokens,
+			}
+		}
+		if _, ok := model["supported_parameters"]; !ok && len(known.Params) > 0 {
+			model["supported_parameters"] = known.Params
+		}
+		if _, ok := model["tokens_per_second"]; !ok && known.TokensPerSecond > 0 {
+			model["tokens_per_second"] = known.TokensPerSecond
+		}
+	}
+}
+
+// compatibleChat implements chat completions for vendors whose API is OpenAI-compatible as is.
+// Presets embed it and add their own model discovery.
+type compatibleChat struct{}
+
+// ModifyCompletionRequest sets the URL path for the completion request.
+func (compatibleChat) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/chat/completions"
+
+	return common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToOpenAI(r, body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform request body for OpenAI-compatible provider", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+}
+
+// ModifyCompletionResponse is a no-op, as responses are OpenAI-compatible.
+func (compatibleChat) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// perMillionPrice renders a price in USD per million tokens as USD per token, the way
+// OpenRouter publishes prices.
+func perMillionPrice(price float64) string {
+	return strconv.FormatFloat(price/1e6, 'g', -1, 64)
+}
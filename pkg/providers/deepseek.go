@@ -0,0 +1,68 @@
+package providers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// DeepSeekBaseURL is the API base URL of the deepseek style unless one is configured.
+const DeepSeekBaseURL = "https://api.deepseek.com"
+
+// deepSeekModels fills in the metadata DeepSeek's models endpoint doesn't list.
+var deepSeekModels = map[string]knownModel{
+	"deepseek-chat": {ContextLength: 128000, MaxOutputTokens: 8192, Params: []string{
+		"max_tokens", "temperature", "top_p", "stop", "presence_penalty", "frequency_penalty", "tools", "response_format", "logprobs",
+	}},
+	"deepseek-reasoner": {ContextLength: 128000, MaxOutputTokens: 65536, Params: []string{
+		"max_tokens", "stop", "tools", "response_format", "reasoning",
+	}},
+}
+
+// DeepSeekProvider implements the Provider interface for DeepSeek's OpenAI-compatible API.
+type DeepSeekProvider struct{}
+
+// Name returns the name of the provider.
+func (p *DeepSeekProvider) Name() string {
+	return "deepseek"
+}
+
+// UnsupportedParams lists the unified request parameters dropped for DeepSeek.
+func (p *DeepSeekProvider) UnsupportedParams() []string {
+	return transforms.DeepSeekUnsupportedParams
+}
+
+// ModifyCompletionRequest sets the URL path and adapts the body for DeepSeek's chat completions API.
+func (p *DeepSeekProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/chat/completions"
+
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToDeepSeek(body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform request body for DeepSeek", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+
+	r.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// ModifyCompletionResponse is a no-op for DeepSeek; reasoning_content is already the unified field.
+func (p *DeepSeekProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// FetchModels fetches the models from the DeepSeek API, which only lists their IDs.
+func (p *DeepSeekProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	models, err := fetchOpenAICompatibleModels(baseURL, apiKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	applyKnownModels(models, deepSeekModels)
+	return models, nil
+}
@@ -0,0 +1,49 @@
+package providers
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// FireworksBaseURL is the API base URL of the fireworks style unless one is configured.
+const FireworksBaseURL = "https://api.fireworks.ai/inference/v1"
+
+// FireworksProvider implements the Provider interface for Fireworks AI.
+type FireworksProvider struct {
+	compatibleChat
+}
+
+// Name returns the name of the provider.
+func (p *FireworksProvider) Name() string {
+	return "fireworks"
+}
+
+// FetchModels fetches the models from the Fireworks API, whose listing says which models
+// chat, take images and call tools.
+func (p *FireworksProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	models, err := fetchOpenAICompatibleModels(baseURL, apiKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, model := range models {
+		if supportsImages, ok := model["supports_image_input"].(bool); ok {
+			modalities := []string{"text"}
+			if supportsImages {
+				modalities = append(modalities, "image")
+			}
+			model["architecture"] = map[string]any{"input_modalities": modalities}
+		}
+		if supportsTools, ok := model["supports_tools"].(bool); ok {
+			params := []string{"max_tokens", "temperature", "top_p", "stop", "seed", "presence_penalty", "frequency_penalty", "response_format"}
+			if supportsTools {
+				params = append(params, "tools")
+			}
+			model["supported_parameters"] = params
+		}
+	}
+
+	logger.Debug("Fetched Fireworks models", zap.Int("count", len(models)))
+	return models, nil
+}
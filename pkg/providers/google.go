@@ -20,9 +20,32 @@ func (p *GoogleProvider) Name() string {
 	return "google"
 }
 
+// UnsupportedParams lists the unified request parameters dropped for Google AI.
+func (p *GoogleProvider) UnsupportedParams() []string {
+	return transforms.GoogleAIUnsupportedParams
+}
+
+// SupportsMultipleChoices reports that n isn't honoured natively. Requests are sent without candidateCount, so a single candidate comes back.
+func (p *GoogleProvider) SupportsMultipleChoices() bool {
+	return false
+}
+
+// SupportsDeveloperRole reports that developer messages are taken, into systemInstruction.
+func (p *GoogleProvider) SupportsDeveloperRole() bool {
+	return true
+}
+
 // ModifyCompletionRequest transforms the incoming request to a format Google AI understands.
+// Streams go to streamGenerateContent, which answers with SSE when asked with alt=sse.
 func (p *GoogleProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
-	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/models/" + modelName + ":generateContent"
+	if stream, _ := r.Context().Value(common.StreamContextKeyString).(bool); stream {
+		r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/models/" + modelName + ":streamGenerateContent"
+		q := r.URL.Query()
+		q.Set("alt", "sse")
+		r.URL.RawQuery = q.Encode()
+	} else {
+		r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/models/" + modelName + ":generateContent"
+	}
 
 	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
 		transformedBody, err := transforms.TransformRequestToGoogleAI(r, body, modelName, logger)
@@ -39,10 +62,16 @@ func (p *GoogleProvider) ModifyCompletionRequest(r *http.Request, modelName stri
 
 // ModifyCompletionResponse transforms the Google AI's response to the unified format.
 func (p *GoogleProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
-	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
-		return common.HookHttpResponseJsonChunks(func(body []byte) ([]byte, error) {
-			return transforms.TransformResponseFromGoogleAI(body, logger)
-		})(resp, body)
+	if resp.StatusCode >= 400 {
+		return nil // Error bodies are normalized by the router
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return common.HookHttpResponseJson(resp, func(body []byte) ([]byte, error) {
+			return transforms.TransformStreamChunkFromGoogleAI(body, logger)
+		})
+	}
+	return common.HookHttpResponseJson(resp, func(body []byte) ([]byte, error) {
+		return transforms.TransformResponseFromGoogleAI(body, logger)
 	})
 }
 
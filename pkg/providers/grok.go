@@ -0,0 +1,172 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// GrokBaseURL is the API base URL of the grok style unless one is configured.
+const GrokBaseURL = "https://api.x.ai/v1"
+
+// grokDeferredPollConfig waits longer than the default, as deferred completions are meant for
+// slow requests.
+var grokDeferredPollConfig = AsyncPollConfig{
+	Interval:    time.Second,
+	MaxInterval: 10 * time.Second,
+	Timeout:     10 * time.Minute,
+}
+
+// grokModels fills in the metadata xAI's language models endpoint doesn't list.
+var grokModels = map[string]knownModel{
+	"grok-4-0709": {ContextLength: 256000, Params: []string{
+		"max_tokens", "temperature", "top_p", "seed", "tools", "response_format", "logprobs", "reasoning",
+	}},
+	"grok-3": {ContextLength: 131072, Params: []string{
+		"max_tokens", "temperature", "top_p", "stop", "seed", "presence_penalty", "frequency_penalty", "tools", "response_format", "logprobs",
+	}},
+	"grok-3-mini": {ContextLength: 131072, Params: []string{
+		"max_tokens", "temperature", "top_p", "stop", "seed", "tools", "response_format", "logprobs", "reasoning",
+	}},
+}
+
+// GrokProvider implements the Provider interface for xAI's Grok models. Requests with
+// "deferred": true are sent as deferred completions, which the provider polls until the
+// completion is ready.
+type GrokProvider struct {
+	client *http.Client // Polls deferred completions
+}
+
+// SetHTTPClient sets the client polls go through.
+func (p *GrokProvider) SetHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// Name returns the name of the provider.
+func (p *GrokProvider) Name() string {
+	return "grok"
+}
+
+// ModifyCompletionRequest sets the URL path and adapts the body for xAI's chat completions API.
+func (p *GrokProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/chat/completions"
+
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToGrok(body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform request body for Grok", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+
+	r.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// ModifyCompletionResponse waits for deferred completions; other responses are already in the
+// unified format.
+func (p *GrokProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+
+	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+		var ack struct {
+			RequestID string            `json:"request_id"`
+			Choices   []json.RawMessage `json:"choices"`
+		}
+		if json.Unmarshal(body, &ack) != nil || ack.RequestID == "" || ack.Choices != nil {
+			return body, nil // A regular completion
+		}
+
+		pollURL := *r.URL
+		pollURL.Path = strings.TrimSuffix(r.URL.Path, "/chat/completions") + "/chat/deferred-completion/" + ack.RequestID
+		pollURL.RawQuery = ""
+		completion, err := PollAsyncJob(r.Context(), p.client, pollURL.String(), AsyncPollHeader(r, pollURL.String()), grokDeferredPollConfig, func([]byte) (AsyncJobState, error) {
+			return AsyncJobState{Done: true}, nil // xAI answers 202 until the completion is ready
+		}, logger)
+		if err != nil {
+			logger.Error("Grok deferred completion did not complete", zap.Error(err), zap.String("request_id", ack.RequestID))
+			resp.StatusCode = http.StatusBadGateway
+			resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+			return []byte(fmt.Sprintf("Grok deferred completion %s did not complete: %v\n", ack.RequestID, err)), nil
+		}
+		return completion, nil
+	})
+}
+
+// FetchModels fetches the language models from the xAI API, with their modalities and prices.
+func (p *GrokProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	modelsURL := strings.TrimRight(baseURL, "/") + "/language-models"
+	req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", modelsURL, err)
+	}
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", modelsURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s returned status %d: %s", modelsURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	// Prices are in hundredths of a cent per million tokens, i.e. 1e-10 USD per token
+	var providerResp struct {
+		Models []struct {
+			ID                       string   `json:"id"`
+			Created                  int64    `json:"created"`
+			OwnedBy                  string   `json:"owned_by"`
+			InputModalities          []string `json:"input_modalities"`
+			OutputModalities         []string `json:"output_modalities"`
+			PromptTextTokenPrice     float64  `json:"prompt_text_token_price"`
+			CompletionTextTokenPrice float64  `json:"completion_text_token_price"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&providerResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response from %s: %w", modelsURL, err)
+	}
+
+	models := make([]map[string]any, 0, len(providerResp.Models))
+	for _, m := range providerResp.Models {
+		models = append(models, map[string]any{
+			"id":       m.ID,
+			"object":   "model",
+			"created":  m.Created,
+			"owned_by": m.OwnedBy,
+			"architecture": map[string]any{
+				"input_modalities":  m.InputModalities,
+				"output_modalities": m.OutputModalities,
+			},
+			"pricing": map[string]any{
+				"prompt":     grokTokenPrice(m.PromptTextTokenPrice),
+				"completion": grokTokenPrice(m.CompletionTextTokenPrice),
+			},
+		})
+	}
+	applyKnownModels(models, grokModels)
+
+	logger.Debug("Fetched Grok models", zap.Int("count", len(models)))
+	return models, nil
+}
+
+// grokTokenPrice renders an xAI price as USD per token, the way OpenRouter publishes prices.
+func grokTokenPrice(price float64) string {
+	return strconv.FormatFloat(price/1e10, 'g', -1, 64)
+}
@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// GroqBaseURL is the API base URL of the groq style unless one is configured.
+const GroqBaseURL = "https://api.groq.com/openai/v1"
+
+// groqModels are the typical output speeds Groq publishes for its main models.
+var groqModels = map[string]knownModel{
+	"llama-3.1-8b-instant":    {TokensPerSecond: 560},
+	"llama-3.3-70b-versatile": {TokensPerSecond: 280},
+	"openai/gpt-oss-20b":      {TokensPerSecond: 1000},
+	"openai/gpt-oss-120b":     {TokensPerSecond: 500},
+}
+
+// GroqProvider implements the Provider interface for Groq.
+type GroqProvider struct{}
+
+// Name returns the name of the provider.
+func (p *GroqProvider) Name() string {
+	return "groq"
+}
+
+// UnsupportedParams lists the unified request parameters dropped for Groq.
+func (p *GroqProvider) UnsupportedParams() []string {
+	return transforms.GroqUnsupportedParams
+}
+
+// SupportsMultipleChoices reports that n isn't honoured natively. Groq only accepts n = 1.
+func (p *GroqProvider) SupportsMultipleChoices() bool {
+	return false
+}
+
+// ModifyCompletionRequest sets the URL path and adapts the body for Groq's chat completions API.
+func (p *GroqProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/chat/completions"
+
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToGroq(body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform request body for Groq", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+
+	r.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// ModifyCompletionResponse is a no-op for Groq, as responses are OpenAI-compatible.
+func (p *GroqProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// FetchModels fetches the active models from the Groq API, with their context window and
+// output limit.
+func (p *GroqProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	listed, err := fetchOpenAICompatibleModels(baseURL, apiKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]map[string]any, 0, len(listed))
+	for _, model := range listed {
+		if active, ok := model["active"].(bool); ok && !active {
+			continue
+		}
+		if contextWindow, ok := model["context_window"].(float64); ok {
+			model["context_length"] = contextWindow
+			if maxOutput, ok := model["max_completion_tokens"].(float64); ok {
+				model["top_provider"] = map[string]any{
+					"context_length":        contextWindow,
+					"max_completion_tokens": maxOutput,
+				}
+			}
+		}
+		models = append(models, model)
+	}
+	applyKnownModels(models, groqModels)
+
+	logger.Debug("Fetched Groq models", zap.Int("count", len(models)))
+	return models, nil
+}
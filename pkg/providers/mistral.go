@@ -0,0 +1,122 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// MistralProvider implements the Provider interface for Mistral AI (La Plateforme).
+type MistralProvider struct{}
+
+// Name returns the name of the provider.
+func (p *MistralProvider) Name() string {
+	return "mistral"
+}
+
+// UnsupportedParams lists the unified request parameters dropped for Mistral.
+func (p *MistralProvider) UnsupportedParams() []string {
+	return transforms.MistralUnsupportedParams
+}
+
+// SupportsPrefill reports that a final assistant message is continued, sent as a prefix.
+func (p *MistralProvider) SupportsPrefill() bool {
+	return true
+}
+
+// ModifyCompletionRequest sets the URL path and adapts the body for Mistral's chat completions API.
+func (p *MistralProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/chat/completions"
+
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToMistral(r, body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform request body for Mistral", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+
+	r.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// ModifyCompletionResponse is a no-op for Mistral, as responses are OpenAI-compatible.
+func (p *MistralProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// FetchModels fetches the models from the Mistral API, including context length and capabilities.
+func (p *MistralProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	modelsURL := strings.TrimRight(baseURL, "/") + "/models"
+	req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", modelsURL, err)
+	}
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", modelsURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s returned status %d: %s", modelsURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	var providerResp struct {
+		Data []struct {
+			ID               string          `json:"id"`
+			Name             string          `json:"name"`
+			Description      string          `json:"description"`
+			Created          int64           `json:"created"`
+			MaxContextLength int             `json:"max_context_length"`
+			Capabilities     map[string]bool `json:"capabilities"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&providerResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response from %s: %w", modelsURL, err)
+	}
+
+	models := make([]map[string]any, 0, len(providerResp.Data))
+	for _, m := range providerResp.Data {
+		if m.Capabilities != nil && !m.Capabilities["completion_chat"] {
+			continue // Embedding/OCR-only models can't serve chat completions
+		}
+		name := m.Name
+		if name == "" {
+			name = m.ID
+		}
+
+		inputModalities := []string{"text"}
+		if m.Capabilities["vision"] {
+			inputModalities = append(inputModalities, "image")
+		}
+		supportedParameters := []string{"max_tokens", "temperature", "top_p", "stop", "seed", "response_format"}
+		if m.Capabilities["function_calling"] {
+			supportedParameters = append(supportedParameters, "tools", "tool_choice")
+		}
+
+		models = append(models, map[string]any{
+			"id":                   m.ID,
+			"name":                 name,
+			"description":          m.Description,
+			"created":              m.Created,
+			"context_length":       m.MaxContextLength,
+			"input_modalities":     inputModalities,
+			"supported_parameters": supportedParameters,
+		})
+	}
+	return models, nil
+}
@@ -0,0 +1,238 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// MockBaseURL is the API base URL of the mock style unless one is configured. It is never dialed.
+const MockBaseURL = "http://mock.invalid/v1"
+
+// DefaultMockResponse is the completion the mock returns unless one is configured.
+const DefaultMockResponse = "This is a mock response from {model}."
+
+// MockProvider answers chat completions itself, with a canned or templated completion, so
+// routing, failover and clients can be exercised without an upstream or its keys. The response
+// may use {model} (the model requested upstream) and {prompt} (the last user message).
+type MockProvider struct {
+	Response string
+	// Wait before responding, like an upstream's time to first token
+	Latency time.Duration
+	// Wait between streamed chunks
+	ChunkDelay time.Duration
+	// Share of requests, 0-1, answered with ErrorStatus instead
+	ErrorRate   float64
+	ErrorStatus int
+}
+
+// Name returns the name of the provider.
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+// Transport returns the round tripper that answers the mock's requests.
+func (p *MockProvider) Transport() http.RoundTripper {
+	return mockTransport{p}
+}
+
+// ModifyCompletionRequest sets the URL path and the model, as for an OpenAI-compatible upstream.
+func (p *MockProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/chat/completions"
+
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		return transforms.TransformRequestToOpenAI(r, body, modelName, logger)
+	})
+	return nil
+}
+
+// ModifyCompletionResponse is a no-op for the mock, as responses are OpenAI-compatible.
+func (p *MockProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// FetchModels returns no models; the mock serves any model it is routed, and a static model
+// manifest lists them.
+func (p *MockProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	return []map[string]any{}, nil
+}
+
+type mockTransport struct {
+	p *MockProvider
+}
+
+func (t mockTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	if !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		return mockError(r, http.StatusNotFound, "The mock provider only serves chat completions"), nil
+	}
+	var req struct {
+		Model    string `json:"model"`
+		Stream   bool   `json:"stream"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+		StreamOptions *struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return mockError(r, http.StatusBadRequest, "Invalid JSON request body"), nil
+	}
+
+	if t.p.Latency > 0 {
+		select {
+		case <-time.After(t.p.Latency):
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+	if t.p.ErrorRate > 0 && rand.Float64() < t.p.ErrorRate {
+		status := t.p.ErrorStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		return mockError(r, status, "Injected mock provider error"), nil
+	}
+
+	prompt := ""
+	promptWords := 0
+	for _, m := range req.Messages {
+		text := mockMessageText(m.Content)
+		promptWords += len(strings.Fields(text))
+		if m.Role == "user" {
+			prompt = text
+		}
+	}
+	content := strings.NewReplacer("{model}", req.Model, "{prompt}", prompt).Replace(t.p.Response)
+	usage := map[string]int{
+		"prompt_tokens":     promptWords,
+		"completion_tokens": len(strings.Fields(content)),
+		"total_tokens":      promptWords + len(strings.Fields(content)),
+	}
+	id := "chatcmpl-mock" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	created := time.Now().Unix()
+
+	if !req.Stream {
+		completion, _ := json.Marshal(map[string]any{
+			"id":      id,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   req.Model,
+			"choices": []map[string]any{{
+				"index":         0,
+				"message":       map[string]any{"role": "assistant", "content": content},
+				"finish_reason": "stop",
+			}},
+			"usage": usage,
+		})
+		return mockResponse(r, http.StatusOK, "application/json", io.NopCloser(bytes.NewReader(completion))), nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		chunk := func(delta map[string]any, finishReason any) map[string]any {
+			return map[string]any{
+				"id":      id,
+				"object":  "chat.completion.chunk",
+				"created": created,
+				"model":   req.Model,
+				"choices": []map[string]any{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+			}
+		}
+		write := func(v any) error {
+			data, _ := json.Marshal(v)
+			_, err := fmt.Fprintf(pw, "data: %s\n\n", data)
+			return err
+		}
+		events := []any{chunk(map[string]any{"role": "assistant", "content": ""}, nil)}
+		for _, word := range strings.SplitAfter(content, " ") {
+			events = append(events, chunk(map[string]any{"content": word}, nil))
+		}
+		events = append(events, chunk(map[string]any{}, "stop"))
+		if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+			final := chunk(nil, nil)
+			final["choices"] = []any{}
+			final["usage"] = usage
+			events = append(events, final)
+		}
+		for i, event := range events {
+			if i > 0 && t.p.ChunkDelay > 0 {
+				select {
+				case <-time.After(t.p.ChunkDelay):
+				case <-r.Context().Done():
+					pw.CloseWithError(r.Context().Err())
+					return
+				}
+			}
+			if write(event) != nil {
+				return
+			}
+		}
+		io.WriteString(pw, "data: [DONE]\n\n")
+		pw.Close()
+	}()
+	return mockResponse(r, http.StatusOK, "text/event-stream", pr), nil
+}
+
+// mockMessageText returns the text of a message's content, a string or a list of parts.
+func mockMessageText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(content, &parts)
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func mockError(r *http.Request, status int, message string) *http.Response {
+	body, _ := json.Marshal(map[string]any{
+		"error": map[string]any{"message": message, "type": "api_error", "param": nil, "code": "mock_error"},
+	})
+	resp := mockResponse(r, status, "application/json", io.NopCloser(bytes.NewReader(body)))
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		resp.Header.Set("Retry-After", "1")
+	}
+	return resp
+}
+
+func mockResponse(r *http.Request, status int, contentType string, body io.ReadCloser) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          body,
+		ContentLength: -1,
+		Request:       r,
+	}
+}
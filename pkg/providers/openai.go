@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
@@ -13,7 +14,18 @@ import (
 )
 
 // OpenAIProvider implements the Provider interface for OpenAI.
-type OpenAIProvider struct{}
+type OpenAIProvider struct {
+	// NativeResponses forwards Responses API requests to /responses untranslated
+	NativeResponses bool
+	// DeveloperRole sends developer messages as they are; other OpenAI-compatible servers may
+	// only know system messages
+	DeveloperRole bool
+}
+
+// SupportsDeveloperRole reports whether the upstream takes developer messages.
+func (p *OpenAIProvider) SupportsDeveloperRole() bool {
+	return p.DeveloperRole
+}
 
 // Name returns the name of the provider.
 func (p *OpenAIProvider) Name() string {
@@ -22,6 +34,21 @@ func (p *OpenAIProvider) Name() string {
 
 // ModifyCompletionRequest sets the URL path for the completion request.
 func (p *OpenAIProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	if passthrough, ok := r.Context().Value(common.ResponsesPassthroughContextKeyString).(*common.ResponsesPassthrough); ok && passthrough != nil && p.NativeResponses {
+		r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/responses"
+		passthrough.Native = true
+
+		common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+			transformedBody, err := transforms.TransformRequestToOpenAI(r, passthrough.Body, modelName, logger)
+			if err != nil {
+				logger.Error("Failed to transform Responses API request body for OpenAI", zap.Error(err))
+				return nil, err
+			}
+			return transformedBody, nil
+		})
+		return nil
+	}
+
 	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/chat/completions"
 
 	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
@@ -41,6 +68,67 @@ func (p *OpenAIProvider) ModifyCompletionResponse(r *http.Request, resp *http.Re
 	return nil
 }
 
+// ModifyImagesRequest sets the URL path for image generations; the body is already in OpenAI's format.
+func (p *OpenAIProvider) ModifyImagesRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/images/generations"
+
+	return common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToOpenAI(r, body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform images request body for OpenAI", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+}
+
+// ModifyImagesResponse is a no-op for OpenAI.
+func (p *OpenAIProvider) ModifyImagesResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// RealtimeURL returns the /realtime WebSocket endpoint under the base URL.
+func (p *OpenAIProvider) RealtimeURL(baseURL *url.URL, modelName string) *url.URL {
+	u := *baseURL
+	u.Scheme = "wss"
+	if baseURL.Scheme == "http" {
+		u.Scheme = "ws"
+	}
+	u.Path, u.RawPath = strings.TrimRight(u.Path, "/")+"/realtime", ""
+	u.RawQuery = url.Values{"model": {modelName}}.Encode()
+	return &u
+}
+
+// ModifyRerankRequest targets the /rerank endpoint served by Jina, vLLM and other
+// OpenAI-compatible servers; the body is already in their format.
+func (p *OpenAIProvider) ModifyRerankRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/rerank"
+
+	return common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToOpenAI(r, body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform rerank request body for OpenAI", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+}
+
+// ModifyRerankResponse normalizes the rerank response to the unified format.
+func (p *OpenAIProvider) ModifyRerankResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRerankResponse(body)
+		if err != nil {
+			logger.Error("Failed to transform rerank response", zap.Error(err))
+			return body, err
+		}
+		return transformedBody, nil
+	})
+}
+
 // FetchModels fetches the models from the OpenAI API.
 func (p *OpenAIProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
 	modelsURL := strings.TrimRight(baseURL, "/") + "/models"
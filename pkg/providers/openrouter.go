@@ -0,0 +1,98 @@
+package providers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// OpenRouterProvider implements the Provider interface for OpenRouter. Requests are OpenAI
+// chat completions; OpenRouter's own fields (provider preferences, transforms, route and
+// models) are passed through untouched.
+type OpenRouterProvider struct{}
+
+// Name returns the name of the provider.
+func (p *OpenRouterProvider) Name() string {
+	return "openrouter"
+}
+
+// SupportsPrefill reports that a final assistant message is continued; OpenRouter passes it
+// on to models that support it.
+func (p *OpenRouterProvider) SupportsPrefill() bool {
+	return true
+}
+
+// ModifyCompletionRequest sets the URL path for the completion request.
+func (p *OpenRouterProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/chat/completions"
+
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToOpenRouter(body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform request body for OpenRouter", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+
+	r.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// ModifyCompletionResponse is a no-op for OpenRouter, as responses are OpenAI-compatible.
+func (p *OpenRouterProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// FetchModels fetches the models from the OpenRouter API. Its schema is the one the router
+// serves, so pricing, context length, architecture and supported parameters are kept as is.
+func (p *OpenRouterProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	data, err := fetchOpenAICompatibleModels(baseURL, apiKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]map[string]any, 0, len(data))
+	for _, model := range data {
+		id, ok := model["id"].(string)
+		if !ok {
+			continue
+		}
+		model["object"] = "model"
+		if author, _, found := strings.Cut(id, "/"); found {
+			model["owned_by"] = author
+		}
+		// Router models such as openrouter/auto are priced "-1": it depends on the model picked
+		if pricing, ok := model["pricing"].(map[string]any); ok && variablePricing(pricing) {
+			delete(model, "pricing")
+		}
+		if _, ok := model["context_length"].(float64); !ok {
+			if top, ok := model["top_provider"].(map[string]any); ok {
+				if contextLength, ok := top["context_length"].(float64); ok {
+					model["context_length"] = contextLength
+				}
+			}
+		}
+		models = append(models, model)
+	}
+
+	logger.Debug("Fetched OpenRouter models", zap.Int("count", len(models)))
+	return models, nil
+}
+
+// variablePricing reports whether any of a model's prices is negative, OpenRouter's marker for
+// prices that aren't known up front.
+func variablePricing(pricing map[string]any) bool {
+	for _, v := range pricing {
+		if s, ok := v.(string); ok {
+			if price, err := strconv.ParseFloat(s, 64); err == nil && price < 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
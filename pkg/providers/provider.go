@@ -2,6 +2,7 @@ package providers
 
 import (
 	"net/http"
+	"net/url"
 
 	"go.uber.org/zap"
 )
@@ -17,3 +18,70 @@ type Provider interface {
 	// FetchModels fetches the models from the provider.
 	FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error)
 }
+
+// ImagesProvider is implemented by providers that can generate images from a prompt.
+type ImagesProvider interface {
+	// ModifyImagesRequest transforms a unified (OpenAI-style) images request for the provider.
+	ModifyImagesRequest(r *http.Request, modelName string, logger *zap.Logger) error
+	// ModifyImagesResponse transforms the provider's response to the unified images format.
+	ModifyImagesResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error
+}
+
+// RerankProvider is implemented by providers that can rerank documents against a query.
+type RerankProvider interface {
+	// ModifyRerankRequest transforms a unified (Cohere/Jina-style) rerank request for the provider.
+	ModifyRerankRequest(r *http.Request, modelName string, logger *zap.Logger) error
+	// ModifyRerankResponse transforms the provider's response to the unified rerank format.
+	ModifyRerankResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error
+}
+
+// ChoicesProvider is implemented by providers that say whether a completion request may ask for
+// several choices (n > 1). The router emulates n for those that can't with parallel requests.
+type ChoicesProvider interface {
+	// SupportsMultipleChoices reports whether the provider honours n natively.
+	SupportsMultipleChoices() bool
+}
+
+// DeveloperRoleProvider is implemented by providers that say whether their API takes messages
+// with OpenAI's developer role. The router sends developer messages as system messages to
+// providers that don't implement it or answer false.
+type DeveloperRoleProvider interface {
+	// SupportsDeveloperRole reports whether developer messages may be sent as they are.
+	SupportsDeveloperRole() bool
+}
+
+// PrefillProvider is implemented by providers that say whether they continue a conversation's
+// final assistant message (prefill). The router emulates prefill for those that don't
+// implement it or answer false.
+type PrefillProvider interface {
+	// SupportsPrefill reports whether a final assistant message may be sent as it is.
+	SupportsPrefill() bool
+}
+
+// ParamsProvider is implemented by providers that can't honour every unified request parameter.
+// The router tells clients which of the parameters they sent were dropped.
+type ParamsProvider interface {
+	// UnsupportedParams lists the unified request parameters the provider drops.
+	UnsupportedParams() []string
+}
+
+// RealtimeProvider is implemented by providers that serve the OpenAI Realtime API over WebSocket.
+type RealtimeProvider interface {
+	// RealtimeURL returns the WebSocket URL of a realtime session with a model.
+	RealtimeURL(baseURL *url.URL, modelName string) *url.URL
+}
+
+// TransportProvider is implemented by providers that answer requests themselves instead of
+// through an upstream API, like the mock provider. They need no upstream key.
+type TransportProvider interface {
+	// Transport returns the round tripper requests to the provider are sent through.
+	Transport() http.RoundTripper
+}
+
+// ClientProvider is implemented by providers that send upstream requests of their own besides
+// the proxied one, e.g. to poll async jobs. They get the provider's client, which carries its
+// transport settings, request signing and recording.
+type ClientProvider interface {
+	// SetHTTPClient sets the client the provider's own upstream requests go through.
+	SetHTTPClient(client *http.Client)
+}
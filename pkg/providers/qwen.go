@@ -0,0 +1,72 @@
+package providers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// QwenBaseURL is the API base URL of the qwen style unless one is configured: the
+// OpenAI-compatible mode of DashScope's international endpoint. Mainland China accounts use
+// https://dashscope.aliyuncs.com/compatible-mode/v1.
+const QwenBaseURL = "https://dashscope-intl.aliyuncs.com/compatible-mode/v1"
+
+// qwenModels fills in the metadata DashScope's models endpoint doesn't list.
+var qwenModels = map[string]knownModel{
+	"qwen-max": {ContextLength: 32768, MaxOutputTokens: 8192, Params: []string{
+		"max_tokens", "temperature", "top_p", "stop", "seed", "presence_penalty", "tools", "response_format",
+	}},
+	"qwen-plus": {ContextLength: 131072, MaxOutputTokens: 16384, Params: []string{
+		"max_tokens", "temperature", "top_p", "stop", "seed", "presence_penalty", "tools", "response_format", "reasoning",
+	}},
+	"qwen-turbo": {ContextLength: 1000000, MaxOutputTokens: 16384, Params: []string{
+		"max_tokens", "temperature", "top_p", "stop", "seed", "presence_penalty", "tools", "response_format", "reasoning",
+	}},
+	"qwq-plus": {ContextLength: 131072, MaxOutputTokens: 8192, Params: []string{
+		"max_tokens", "tools", "reasoning",
+	}},
+}
+
+// QwenProvider implements the Provider interface for Qwen models on Alibaba Cloud Model Studio
+// (DashScope), through its OpenAI-compatible mode.
+type QwenProvider struct{}
+
+// Name returns the name of the provider.
+func (p *QwenProvider) Name() string {
+	return "qwen"
+}
+
+// ModifyCompletionRequest sets the URL path and adapts the body for DashScope's chat completions API.
+func (p *QwenProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/chat/completions"
+
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToQwen(body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform request body for Qwen", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+
+	r.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// ModifyCompletionResponse is a no-op for Qwen; reasoning_content is already the unified field.
+func (p *QwenProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// FetchModels fetches the models from DashScope, which only lists their IDs.
+func (p *QwenProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	models, err := fetchOpenAICompatibleModels(baseURL, apiKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	applyKnownModels(models, qwenModels)
+	return models, nil
+}
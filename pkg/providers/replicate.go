@@ -0,0 +1,167 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// ReplicateProvider implements the Provider interface for Replicate predictions.
+// Predictions are asynchronous, so the response hook polls until completion
+// and synthesizes a unified chat response (or SSE stream) from the final prediction.
+type ReplicateProvider struct {
+	client *http.Client // Polls predictions
+}
+
+// SetHTTPClient sets the client polls go through.
+func (p *ReplicateProvider) SetHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// Name returns the name of the provider.
+func (p *ReplicateProvider) Name() string {
+	return "replicate"
+}
+
+// UnsupportedParams lists the unified request parameters dropped for Replicate.
+func (p *ReplicateProvider) UnsupportedParams() []string {
+	return transforms.ReplicateUnsupportedParams
+}
+
+// SupportsMultipleChoices reports that n isn't honoured natively. Predictions produce a single output.
+func (p *ReplicateProvider) SupportsMultipleChoices() bool {
+	return false
+}
+
+// SupportsDeveloperRole reports that developer messages are taken, into the system prompt.
+func (p *ReplicateProvider) SupportsDeveloperRole() bool {
+	return true
+}
+
+// SupportsPrefill reports that a final assistant message is continued, as the end of the
+// transcript prompt.
+func (p *ReplicateProvider) SupportsPrefill() bool {
+	return true
+}
+
+// ModifyCompletionRequest targets the prediction creation endpoint for the model.
+func (p *ReplicateProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + transforms.ReplicatePredictionPath(modelName)
+
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformRequestToReplicate(r, body, modelName, logger)
+		if err != nil {
+			logger.Error("Failed to transform request body for Replicate", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+
+	r.Header.Set("Content-Type", "application/json")
+	// Ask Replicate to hold the connection until the prediction finishes, so polling is only a fallback
+	r.Header.Set("Prefer", "wait")
+	return nil
+}
+
+// ModifyCompletionResponse waits for the prediction to finish and converts it to the unified format.
+func (p *ReplicateProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil
+	}
+
+	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+		resp.Header.Del("Content-Length")
+
+		var prediction transforms.ReplicatePrediction
+		if err := json.Unmarshal(body, &prediction); err != nil {
+			logger.Error("Failed to unmarshal replicate prediction", zap.Error(err), zap.ByteString("body", body))
+			return body, nil
+		}
+
+		if !prediction.Done() {
+			finalBody, err := PollAsyncJob(r.Context(), p.client, prediction.URLs.Get, AsyncPollHeader(r, prediction.URLs.Get), DefaultAsyncPollConfig, func(pollBody []byte) (AsyncJobState, error) {
+				var polled transforms.ReplicatePrediction
+				if err := json.Unmarshal(pollBody, &polled); err != nil {
+					return AsyncJobState{}, fmt.Errorf("unmarshal replicate prediction: %w", err)
+				}
+				return AsyncJobState{Done: polled.Done(), Error: polled.ErrorMessage()}, nil
+			}, logger)
+			if err != nil {
+				logger.Error("Replicate prediction did not complete", zap.Error(err), zap.String("prediction_id", prediction.ID))
+				resp.StatusCode = http.StatusBadGateway
+				resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+				return []byte(fmt.Sprintf("Replicate prediction %s did not complete: %v\n", prediction.ID, err)), nil
+			}
+			body = finalBody
+		} else if msg := prediction.ErrorMessage(); msg != "" {
+			resp.StatusCode = http.StatusBadGateway
+			resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+			return []byte(fmt.Sprintf("Replicate prediction %s failed: %s\n", prediction.ID, msg)), nil
+		}
+
+		unifiedResp, err := transforms.TransformResponseFromReplicate(body, prediction.Model, logger)
+		if err != nil {
+			return body, nil
+		}
+
+		resp.StatusCode = http.StatusOK
+		if stream, _ := r.Context().Value(common.StreamContextKeyString).(bool); stream {
+			resp.Header.Set("Content-Type", "text/event-stream")
+			return transforms.SynthesizeUnifiedStream(*unifiedResp)
+		}
+		resp.Header.Set("Content-Type", "application/json")
+		return json.Marshal(unifiedResp)
+	})
+}
+
+// FetchModels fetches the curated language models collection from Replicate.
+func (p *ReplicateProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	modelsURL := strings.TrimRight(baseURL, "/") + "/collections/language-models"
+	req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", modelsURL, err)
+	}
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", modelsURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s returned status %d: %s", modelsURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	var providerResp struct {
+		Models []struct {
+			Owner       string `json:"owner"`
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&providerResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response from %s: %w", modelsURL, err)
+	}
+
+	models := make([]map[string]any, 0, len(providerResp.Models))
+	for _, m := range providerResp.Models {
+		id := m.Owner + "/" + m.Name
+		models = append(models, map[string]any{
+			"id":          id,
+			"name":        id,
+			"description": m.Description,
+		})
+	}
+	return models, nil
+}
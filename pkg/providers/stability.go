@@ -0,0 +1,111 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// StabilityProvider implements the Provider interface for Stability AI's v1 REST API.
+// It only generates images.
+type StabilityProvider struct{}
+
+// Name returns the name of the provider.
+func (p *StabilityProvider) Name() string {
+	return "stability"
+}
+
+// ModifyCompletionRequest rejects chat completions, which Stability doesn't offer.
+func (p *StabilityProvider) ModifyCompletionRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	return errors.New("stability does not support chat completions")
+}
+
+// ModifyCompletionResponse is a no-op for Stability.
+func (p *StabilityProvider) ModifyCompletionResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	return nil
+}
+
+// ModifyImagesRequest targets the engine's text-to-image endpoint and converts the body.
+func (p *StabilityProvider) ModifyImagesRequest(r *http.Request, modelName string, logger *zap.Logger) error {
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/generation/" + modelName + "/text-to-image"
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("Content-Type", "application/json")
+
+	return common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformImagesRequestToStability(body, logger)
+		if err != nil {
+			logger.Error("Failed to transform images request body for Stability", zap.Error(err))
+			return nil, err
+		}
+		return transformedBody, nil
+	})
+}
+
+// ModifyImagesResponse converts Stability artifacts to the unified images format.
+func (p *StabilityProvider) ModifyImagesResponse(r *http.Request, resp *http.Response, logger *zap.Logger) error {
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	format, _ := r.Context().Value(common.ImageResponseFormatContextKeyString).(string)
+	return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+		transformedBody, err := transforms.TransformStabilityImagesResponse(body, format)
+		if err != nil {
+			logger.Error("Failed to transform images response from Stability", zap.Error(err))
+			return body, err
+		}
+		return transformedBody, nil
+	})
+}
+
+// FetchModels lists the Stability engines.
+func (p *StabilityProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	modelsURL := strings.TrimRight(baseURL, "/") + "/engines/list"
+	req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", modelsURL, err)
+	}
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", modelsURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s returned status %d: %s", modelsURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	var engines []struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Type        string `json:"type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&engines); err != nil {
+		return nil, fmt.Errorf("failed to decode response from %s: %w", modelsURL, err)
+	}
+
+	models := make([]map[string]any, 0, len(engines))
+	for _, engine := range engines {
+		models = append(models, map[string]any{
+			"id":          engine.ID,
+			"object":      "model",
+			"name":        engine.Name,
+			"description": engine.Description,
+			"owned_by":    "stability",
+		})
+	}
+	return models, nil
+}
@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// TogetherBaseURL is the API base URL of the together style unless one is configured.
+const TogetherBaseURL = "https://api.together.xyz/v1"
+
+// TogetherProvider implements the Provider interface for Together AI.
+type TogetherProvider struct {
+	compatibleChat
+}
+
+// Name returns the name of the provider.
+func (p *TogetherProvider) Name() string {
+	return "together"
+}
+
+// FetchModels fetches the models from the Together API, with their context length, prices
+// (USD per million tokens) and, when listed, quantization.
+func (p *TogetherProvider) FetchModels(baseURL string, apiKey string, httpClient *http.Client, logger *zap.Logger) ([]map[string]any, error) {
+	modelsURL := strings.TrimRight(baseURL, "/") + "/models"
+	req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", modelsURL, err)
+	}
+	req.Header.Set("User-Agent", "Caddy-AI-Router")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", modelsURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s returned status %d: %s", modelsURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	// Together lists models as a bare array
+	var providerResp []struct {
+		ID            string `json:"id"`
+		Type          string `json:"type"` // "chat", "language", "code", "image", "embedding", "rerank", ...
+		DisplayName   string `json:"display_name"`
+		Organization  string `json:"organization"`
+		Created       int64  `json:"created"`
+		ContextLength int    `json:"context_length"`
+		Quantization  string `json:"quantization"`
+		Pricing       *struct {
+			Input  float64 `json:"input"`
+			Output float64 `json:"output"`
+		} `json:"pricing"`
+		Config *struct {
+			Quantization string `json:"quantization"`
+		} `json:"config"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&providerResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response from %s: %w", modelsURL, err)
+	}
+
+	models := make([]map[string]any, 0, len(providerResp))
+	for _, m := range providerResp {
+		entry := map[string]any{
+			"id":       m.ID,
+			"object":   "model",
+			"name":     m.DisplayName,
+			"created":  m.Created,
+			"owned_by": m.Organization,
+		}
+		if m.ContextLength > 0 {
+			entry["context_length"] = m.ContextLength
+		}
+		if m.Pricing != nil && (m.Pricing.Input > 0 || m.Pricing.Output > 0) {
+			entry["pricing"] = map[string]any{
+				"prompt":     perMillionPrice(m.Pricing.Input),
+				"completion": perMillionPrice(m.Pricing.Output),
+			}
+		}
+		quantization := m.Quantization
+		if quantization == "" && m.Config != nil {
+			quantization = m.Config.Quantization
+		}
+		if quantization != "" {
+			entry["quantization"] = quantization
+		}
+		if m.Type != "" {
+			entry["type"] = m.Type
+		}
+		models = append(models, entry)
+	}
+
+	logger.Debug("Fetched Together models", zap.Int("count", len(models)))
+	return models, nil
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const defaultRedisPrefix = "caddy_ai_router:"
+
+// RedisStore is a Store backed by Redis, shared by every instance pointing at the same server and prefix.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+	logger *zap.Logger
+}
+
+// incrByWithTTL increments a counter and sets its expiry only when the increment created it.
+var incrByWithTTL = redis.NewScript(`
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return value
+`)
+
+// hincrByWithTTL increments a hash field and sets the hash's expiry only when the increment created it.
+var hincrByWithTTL = redis.NewScript(`
+local value = redis.call("HINCRBY", KEYS[1], ARGV[1], ARGV[2])
+if tonumber(ARGV[3]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+end
+return value
+`)
+
+// hincrByFieldsWithTTL increments several hash fields, given as field and delta pairs after the
+// ttl, and sets the hash's expiry only when the increments created it.
+var hincrByFieldsWithTTL = redis.NewScript(`
+for i = 2, #ARGV, 2 do
+	redis.call("HINCRBY", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+if tonumber(ARGV[1]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// NewRedisStore connects to the Redis server in config and verifies the connection.
+func NewRedisStore(config Config, logger *zap.Logger) (*RedisStore, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("redis storage: address is required")
+	}
+	if config.Prefix == "" {
+		config.Prefix = defaultRedisPrefix
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	opts := &redis.Options{
+		Addr:     config.Address,
+		Username: config.Username,
+		Password: config.Password,
+		DB:       config.DB,
+	}
+	if config.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis storage: failed to connect to %s: %w", config.Address, err)
+	}
+	logger.Info("Connected to Redis storage", zap.String("address", config.Address), zap.Int("db", config.DB))
+
+	return &RedisStore{client: client, prefix: config.Prefix, logger: logger}, nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+func (s *RedisStore) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return incrByWithTTL.Run(ctx, s.client, []string{s.prefix + key}, delta, ttl.Milliseconds()).Int64()
+}
+
+func (s *RedisStore) HIncrBy(ctx context.Context, key, field string, delta int64, ttl time.Duration) (int64, error) {
+	return hincrByWithTTL.Run(ctx, s.client, []string{s.prefix + key}, field, delta, ttl.Milliseconds()).Int64()
+}
+
+func (s *RedisStore) HIncrByFields(ctx context.Context, key string, deltas map[string]int64, ttl time.Duration) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	args := make([]any, 0, 1+2*len(deltas))
+	args = append(args, ttl.Milliseconds())
+	for field, delta := range deltas {
+		args = append(args, field, delta)
+	}
+	return hincrByFieldsWithTTL.Run(ctx, s.client, []string{s.prefix + key}, args...).Err()
+}
+
+func (s *RedisStore) HGetAll(ctx context.Context, key string) (map[string]int64, error) {
+	values, err := s.client.HGetAll(ctx, s.prefix+key).Result()
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	fields := make(map[string]int64, len(values))
+	for field, value := range values {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %s of hash %s is not a counter", field, key)
+		}
+		fields[field] = n
+	}
+	return fields, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+var _ Store = (*RedisStore)(nil)
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// BackendMemory keeps state in the local process (the default).
+	BackendMemory = "memory"
+	// BackendRedis shares state across instances through Redis.
+	BackendRedis = "redis"
+)
+
+// Store is a small key/value abstraction for router state that may need to be shared
+// between Caddy instances: model resolution, discovered models, counters and usage data.
+type Store interface {
+	// Get returns the value for key; ok is false if it doesn't exist or has expired.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set stores value under key; a zero ttl means no expiry.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key.
+	Delete(ctx context.Context, key string) error
+	// IncrBy atomically adds delta to the counter at key and returns the new value.
+	// The ttl is applied when the counter is created.
+	IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// HIncrBy atomically adds delta to a field of the counter hash at key and returns the
+	// field's new value. The ttl is applied when the hash is created.
+	HIncrBy(ctx context.Context, key, field string, delta int64, ttl time.Duration) (int64, error)
+	// HIncrByFields adds deltas to several fields of the counter hash at key in one atomic
+	// round trip. The ttl is applied when the hash is created.
+	HIncrByFields(ctx context.Context, key string, deltas map[string]int64, ttl time.Duration) error
+	// HGetAll returns every field of the counter hash at key, or nil if it doesn't exist or has expired.
+	HGetAll(ctx context.Context, key string) (map[string]int64, error)
+	// Close releases the backend's resources.
+	Close() error
+}
+
+// Config selects and configures a storage backend.
+type Config struct {
+	Backend  string `json:"backend,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+	// Prefix for every key, so several deployments can share one Redis
+	Prefix string `json:"prefix,omitempty"`
+	TLS    bool   `json:"tls,omitempty"`
+}
+
+// New creates the store described by config; a nil config yields a memory store.
+func New(config *Config, logger *zap.Logger) (Store, error) {
+	if config == nil {
+		return NewMemoryStore(), nil
+	}
+	switch strings.ToLower(config.Backend) {
+	case "", BackendMemory:
+		return NewMemoryStore(), nil
+	case BackendRedis:
+		return NewRedisStore(*config, logger)
+	}
+	return nil, fmt.Errorf("unsupported storage backend '%s'", config.Backend)
+}
+
+type memoryEntry struct {
+	value     []byte
+	counter   int64
+	fields    map[string]int64 // Set for counter hashes
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// MemoryStore is a process-local Store. Expired entries are dropped lazily and swept periodically.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry), lastSweep: time.Now()}
+}
+
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// sweepLocked drops expired entries at most once a minute. Must be called with s.mu held.
+func (s *MemoryStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, entry := range s.entries {
+		if entry.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if entry.expired(time.Now()) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+	if entry.fields != nil {
+		return nil, false, fmt.Errorf("value at key %s is a hash", key)
+	}
+	if entry.value == nil {
+		return []byte(fmt.Sprint(entry.counter)), true, nil
+	}
+	return entry.value, true, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+	s.entries[key] = &memoryEntry{value: append([]byte(nil), value...), expiresAt: expiry(now, ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+	entry, ok := s.entries[key]
+	if !ok || entry.expired(now) {
+		entry = &memoryEntry{expiresAt: expiry(now, ttl)}
+		s.entries[key] = entry
+	} else if entry.value != nil || entry.fields != nil {
+		return 0, fmt.Errorf("value at key %s is not a counter", key)
+	}
+	entry.counter += delta
+	return entry.counter, nil
+}
+
+func (s *MemoryStore) HIncrBy(ctx context.Context, key, field string, delta int64, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+	entry, ok := s.entries[key]
+	if !ok || entry.expired(now) {
+		entry = &memoryEntry{fields: make(map[string]int64), expiresAt: expiry(now, ttl)}
+		s.entries[key] = entry
+	} else if entry.fields == nil {
+		return 0, fmt.Errorf("value at key %s is not a hash", key)
+	}
+	entry.fields[field] += delta
+	return entry.fields[field], nil
+}
+
+func (s *MemoryStore) HIncrByFields(ctx context.Context, key string, deltas map[string]int64, ttl time.Duration) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+	entry, ok := s.entries[key]
+	if !ok || entry.expired(now) {
+		entry = &memoryEntry{fields: make(map[string]int64), expiresAt: expiry(now, ttl)}
+		s.entries[key] = entry
+	} else if entry.fields == nil {
+		return fmt.Errorf("value at key %s is not a hash", key)
+	}
+	for field, delta := range deltas {
+		entry.fields[field] += delta
+	}
+	return nil
+}
+
+func (s *MemoryStore) HGetAll(ctx context.Context, key string) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		return nil, nil
+	}
+	if entry.fields == nil {
+		return nil, fmt.Errorf("value at key %s is not a hash", key)
+	}
+	fields := make(map[string]int64, len(entry.fields))
+	for field, value := range entry.fields {
+		fields[field] = value
+	}
+	return fields, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+var _ Store = (*MemoryStore)(nil)
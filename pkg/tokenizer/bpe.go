@@ -0,0 +1,143 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// pretokenize splits text the way cl100k_base does, minus the `\s+(?!\S)` lookahead that RE2
+// can't express; counts differ from tiktoken only in rare runs of trailing whitespace.
+var pretokenize = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// BPETokenizer is a byte pair encoding tokenizer using tiktoken rank files.
+type BPETokenizer struct {
+	name  string
+	ranks map[string]int
+
+	mu    sync.Mutex
+	cache map[string]int // Token counts of recently seen pieces
+}
+
+// LoadBPE reads a tiktoken ranks file: one "<base64 token> <rank>" pair per line.
+func LoadBPE(path string) (*BPETokenizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected '<token> <rank>'", path, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid token: %w", path, line, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid rank: %w", path, line, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("%s: no ranks found", path)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &BPETokenizer{name: name, ranks: ranks, cache: make(map[string]int)}, nil
+}
+
+func (t *BPETokenizer) Name() string {
+	return t.name
+}
+
+func (t *BPETokenizer) Count(text string) int {
+	count := 0
+	for _, piece := range pretokenize.FindAllString(text, -1) {
+		count += t.countPiece(piece)
+	}
+	return count
+}
+
+const maxCachedPieces = 1 << 16
+
+// maxMergeBytes bounds the pieces merged at once. The pretokenizer lets runs of letters,
+// punctuation or whitespace grow without limit and merging is quadratic in a piece's length, so
+// longer pieces are counted in chunks; tokens never span that many bytes anyway.
+const maxMergeBytes = 256
+
+func (t *BPETokenizer) countPiece(piece string) int {
+	if _, ok := t.ranks[piece]; ok {
+		return 1
+	}
+	if len(piece) > maxMergeBytes {
+		n := 0
+		for len(piece) > maxMergeBytes {
+			cut := maxMergeBytes
+			for cut > maxMergeBytes-utf8.UTFMax && !utf8.RuneStart(piece[cut]) {
+				cut--
+			}
+			n += t.countPiece(piece[:cut])
+			piece = piece[cut:]
+		}
+		return n + t.countPiece(piece)
+	}
+	t.mu.Lock()
+	n, ok := t.cache[piece]
+	t.mu.Unlock()
+	if ok {
+		return n
+	}
+
+	n = len(t.merge([]byte(piece)))
+
+	t.mu.Lock()
+	if len(t.cache) >= maxCachedPieces {
+		t.cache = make(map[string]int)
+	}
+	t.cache[piece] = n
+	t.mu.Unlock()
+	return n
+}
+
+// merge applies byte pair merges to a piece, always merging the lowest-ranked adjacent pair first.
+func (t *BPETokenizer) merge(piece []byte) []string {
+	parts := make([]string, len(piece))
+	for i := range piece {
+		parts[i] = string(piece[i : i+1])
+	}
+	for len(parts) > 1 {
+		best, bestRank := -1, -1
+		for i := 0; i < len(parts)-1; i++ {
+			if rank, ok := t.ranks[parts[i]+parts[i+1]]; ok && (bestRank == -1 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best == -1 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return parts
+}
+
+var _ Tokenizer = (*BPETokenizer)(nil)
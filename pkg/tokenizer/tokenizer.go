@@ -0,0 +1,72 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Tokenizer counts tokens in text.
+type Tokenizer interface {
+	// Name identifies the tokenizer, e.g. "heuristic" or "cl100k_base".
+	Name() string
+	// Count returns the number of tokens in text.
+	Count(text string) int
+}
+
+// Message is the part of a chat message that counts towards the prompt.
+type Message struct {
+	Role    string
+	Content string
+}
+
+// Per-message and reply-priming overheads of OpenAI chat formatting.
+const (
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+// CountMessages counts the prompt tokens of a chat conversation, including formatting overhead.
+func CountMessages(t Tokenizer, messages []Message) int {
+	if len(messages) == 0 {
+		return 0
+	}
+	tokens := tokensPerReply
+	for _, msg := range messages {
+		tokens += tokensPerMessage + t.Count(msg.Role) + t.Count(msg.Content)
+	}
+	return tokens
+}
+
+// HeuristicTokenizer estimates about four characters per token. It needs no data files.
+type HeuristicTokenizer struct{}
+
+func (HeuristicTokenizer) Name() string {
+	return "heuristic"
+}
+
+func (HeuristicTokenizer) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	return (len(text) + 3) / 4
+}
+
+// New returns the tokenizer for a spec: "" or "heuristic" for the heuristic, otherwise
+// the path of a tiktoken BPE ranks file (e.g. cl100k_base.tiktoken).
+func New(spec string, logger *zap.Logger) (Tokenizer, error) {
+	if spec == "" || strings.EqualFold(spec, "heuristic") {
+		return HeuristicTokenizer{}, nil
+	}
+	bpe, err := LoadBPE(spec)
+	if err != nil {
+		return nil, fmt.Errorf("tokenizer: %w", err)
+	}
+	if logger != nil {
+		logger.Info("Loaded BPE tokenizer", zap.String("file", spec), zap.Int("ranks", len(bpe.ranks)))
+	}
+	return bpe, nil
+}
+
+var _ Tokenizer = HeuristicTokenizer{}
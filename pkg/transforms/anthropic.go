@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
 	"go.uber.org/zap"
@@ -19,15 +20,49 @@ type AnthropicMessage struct {
 
 // AnthropicMessagesRequest defines the request for Anthropic's Messages API.
 type AnthropicMessagesRequest struct {
-	Model       string             `json:"model"`
-	Messages    []AnthropicMessage `json:"messages"`
-	System      string             `json:"system,omitempty"`
-	MaxTokens   int                `json:"max_tokens"`
-	Stream      bool               `json:"stream,omitempty"`
-	Temperature *float64           `json:"temperature,omitempty"`
-	// TopP, TopK, StopSequences, etc.
+	Model         string             `json:"model"`
+	Messages      []AnthropicMessage `json:"messages"`
+	System        string             `json:"system,omitempty"`
+	MaxTokens     int                `json:"max_tokens"`
+	Stream        bool               `json:"stream,omitempty"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Metadata      *AnthropicMetadata `json:"metadata,omitempty"`
+	Thinking      *AnthropicThinking `json:"thinking,omitempty"`
+	// TopK, etc.
 }
 
+// AnthropicThinking enables extended thinking with a token budget, which counts towards max_tokens.
+type AnthropicThinking struct {
+	Type         string `json:"type"` // "enabled"
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// anthropicMinThinkingBudget is the smallest thinking budget the Messages API accepts.
+const anthropicMinThinkingBudget = 1024
+
+// AnthropicDefaultMaxTokens returns the max_tokens sent for a model when neither the client nor
+// the provider configuration sets an output budget, which the Messages API requires: the
+// output limit of Claude 3 and older models, and 8192 for later ones, which allow at least that.
+func AnthropicDefaultMaxTokens(modelName string) int {
+	for _, legacy := range []string{"claude-3-haiku", "claude-3-sonnet", "claude-3-opus", "claude-2", "claude-instant"} {
+		if strings.Contains(modelName, legacy) {
+			return 4096
+		}
+	}
+	return 8192
+}
+
+// AnthropicMetadata defines the request metadata of Anthropic's Messages API.
+type AnthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+// AnthropicUnsupportedParams lists unified request parameters the Messages API has no
+// equivalent for; they are dropped.
+var AnthropicUnsupportedParams = []string{"seed", "presence_penalty", "frequency_penalty", "logprobs", "top_logprobs"}
+
 // AnthropicMessagesResponse defines the response from Anthropic's Messages API.
 type AnthropicMessagesResponse struct {
 	ID           string                  `json:"id"`
@@ -42,8 +77,9 @@ type AnthropicMessagesResponse struct {
 
 // AnthropicContentBlock defines a block of content in Anthropic's response.
 type AnthropicContentBlock struct {
-	Type string `json:"type"` // e.g., "text"
-	Text string `json:"text,omitempty"`
+	Type     string `json:"type"` // e.g., "text" or "thinking"
+	Text     string `json:"text,omitempty"`
+	Thinking string `json:"thinking,omitempty"`
 }
 
 // AnthropicUsage defines token usage for Anthropic.
@@ -62,22 +98,44 @@ func TransformRequestToAnthropic(r *http.Request, originalBody []byte, modelName
 	anthropicReq := AnthropicMessagesRequest{
 		Model:     modelName, // Anthropic expects model in the body
 		Messages:  make([]AnthropicMessage, 0, len(unifiedReq.Messages)),
-		MaxTokens: 1024, // Default, should come from unifiedReq if available
+		MaxTokens: AnthropicDefaultMaxTokens(modelName),
 		Stream:    unifiedReq.Stream,
 	}
-	if unifiedReq.MaxTokens != nil {
-		anthropicReq.MaxTokens = *unifiedReq.MaxTokens
+	if maxTokens := unifiedReq.OutputTokenLimit(); maxTokens != nil {
+		anthropicReq.MaxTokens = *maxTokens
 	}
 	if unifiedReq.Temperature != nil {
 		anthropicReq.Temperature = unifiedReq.Temperature
 	}
+	anthropicReq.TopP = unifiedReq.TopP
+	if budget := ReasoningBudget(unifiedReq.ReasoningEffort); budget > 0 {
+		if unifiedReq.OutputTokenLimit() == nil {
+			anthropicReq.MaxTokens += budget // Leave the default answer room on top of the thinking
+		}
+		// The budget must stay below max_tokens, which includes it; split a smaller max_tokens evenly
+		if budget >= anthropicReq.MaxTokens {
+			budget = anthropicReq.MaxTokens / 2
+		}
+		if budget >= anthropicMinThinkingBudget {
+			anthropicReq.Thinking = &AnthropicThinking{Type: "enabled", BudgetTokens: budget}
+			// Thinking doesn't work with modified temperature or top_p
+			anthropicReq.Temperature = nil
+			anthropicReq.TopP = nil
+		} else {
+			logger.Debug("max_tokens leaves no room for extended thinking; reasoning_effort ignored", zap.Int("max_tokens", anthropicReq.MaxTokens))
+		}
+	}
+	anthropicReq.StopSequences = unifiedReq.Stop
+	if unifiedReq.User != "" {
+		anthropicReq.Metadata = &AnthropicMetadata{UserID: unifiedReq.User}
+	}
 
+	// System and developer messages, wherever they appear, are joined into the system prompt
+	var system []string
 	for _, msg := range unifiedReq.Messages {
-		if msg.Role == "system" {
-			if anthropicReq.System != "" {
-				anthropicReq.System += "\n" + msg.Content
-			} else {
-				anthropicReq.System = msg.Content
+		if msg.IsSystem() {
+			if msg.Content != "" {
+				system = append(system, msg.Content)
 			}
 			continue
 		}
@@ -92,6 +150,15 @@ func TransformRequestToAnthropic(r *http.Request, originalBody []byte, modelName
 			Content: msg.Content,
 		})
 	}
+	anthropicReq.System = strings.Join(system, "\n\n")
+	// A final assistant message is continued; the API rejects it with trailing whitespace, and
+	// with empty content, so one that is only whitespace is dropped
+	if _, ok := unifiedReq.Prefill(); ok && len(anthropicReq.Messages) > 0 {
+		last := &anthropicReq.Messages[len(anthropicReq.Messages)-1]
+		if last.Content = strings.TrimRight(last.Content, " \t\r\n"); last.Content == "" {
+			anthropicReq.Messages = anthropicReq.Messages[:len(anthropicReq.Messages)-1]
+		}
+	}
 
 	transformedBody, err := json.Marshal(anthropicReq)
 	if err != nil {
@@ -123,13 +190,20 @@ func TransformResponseFromAnthropic(respBody []byte, logger *zap.Logger) ([]byte
 	}
 
 	if len(anthropicResp.Content) > 0 {
+		message := UnifiedChatMessage{Role: "assistant"}
+		// Extended thinking puts thinking blocks ahead of the text ones
+		for _, block := range anthropicResp.Content {
+			switch block.Type {
+			case "thinking":
+				message.ReasoningContent += block.Thinking
+			case "text":
+				message.Content += block.Text
+			}
+		}
 		unifiedResp.Choices = append(unifiedResp.Choices, UnifiedChoice{
-			Index: 0,
-			Message: UnifiedChatMessage{
-				Role:    "assistant",
-				Content: anthropicResp.Content[0].Text,
-			},
-			FinishReason: anthropicResp.StopReason,
+			Index:        0,
+			Message:      message,
+			FinishReason: NormalizeFinishReason(anthropicResp.StopReason),
 		})
 	}
 
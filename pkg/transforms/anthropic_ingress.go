@@ -0,0 +1,249 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// --- Anthropic Messages API ingress (Anthropic-native clients talking to the router) ---
+
+// AnthropicIngressRequest defines an incoming Anthropic Messages API request.
+// Content and system may be plain strings or arrays of content blocks.
+type AnthropicIngressRequest struct {
+	Model         string                    `json:"model"`
+	Messages      []AnthropicIngressMessage `json:"messages"`
+	System        json.RawMessage           `json:"system,omitempty"`
+	MaxTokens     *int                      `json:"max_tokens,omitempty"`
+	Stream        bool                      `json:"stream,omitempty"`
+	Temperature   *float64                  `json:"temperature,omitempty"`
+	TopP          *float64                  `json:"top_p,omitempty"`
+	StopSequences []string                  `json:"stop_sequences,omitempty"`
+	Metadata      *struct {
+		UserID string `json:"user_id,omitempty"`
+	} `json:"metadata,omitempty"`
+}
+
+// AnthropicIngressMessage defines a message of an incoming Anthropic request.
+type AnthropicIngressMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// anthropicText flattens a string or an array of content blocks into plain text.
+func anthropicText(raw json.RawMessage, logger *zap.Logger) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return ""
+	}
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if block.Type != "text" {
+			logger.Debug("Dropping unsupported Anthropic content block", zap.String("type", block.Type))
+			continue
+		}
+		parts = append(parts, block.Text)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// TransformAnthropicRequestToUnified converts an Anthropic Messages API request into the unified format.
+func TransformAnthropicRequestToUnified(body []byte, logger *zap.Logger) ([]byte, error) {
+	var anthropicReq AnthropicIngressRequest
+	if err := json.Unmarshal(body, &anthropicReq); err != nil {
+		return nil, fmt.Errorf("unmarshal Anthropic request: %w", err)
+	}
+
+	unifiedReq := map[string]any{
+		"model":  anthropicReq.Model,
+		"stream": anthropicReq.Stream,
+	}
+	messages := make([]UnifiedChatMessage, 0, len(anthropicReq.Messages)+1)
+	if system := anthropicText(anthropicReq.System, logger); system != "" {
+		messages = append(messages, UnifiedChatMessage{Role: "system", Content: system})
+	}
+	for _, msg := range anthropicReq.Messages {
+		messages = append(messages, UnifiedChatMessage{Role: msg.Role, Content: anthropicText(msg.Content, logger)})
+	}
+	unifiedReq["messages"] = messages
+
+	if anthropicReq.MaxTokens != nil {
+		unifiedReq["max_tokens"] = *anthropicReq.MaxTokens
+	}
+	if anthropicReq.Temperature != nil {
+		unifiedReq["temperature"] = *anthropicReq.Temperature
+	}
+	if anthropicReq.TopP != nil {
+		unifiedReq["top_p"] = *anthropicReq.TopP
+	}
+	if len(anthropicReq.StopSequences) > 0 {
+		unifiedReq["stop"] = anthropicReq.StopSequences
+	}
+	if anthropicReq.Metadata != nil && anthropicReq.Metadata.UserID != "" {
+		unifiedReq["user"] = anthropicReq.Metadata.UserID
+	}
+
+	return json.Marshal(unifiedReq)
+}
+
+// AnthropicStopReason maps a unified finish reason onto Anthropic's stop_reason vocabulary.
+func AnthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "length", "max_tokens", "MAX_TOKENS":
+		return "max_tokens"
+	case "tool_calls", "tool_use":
+		return "tool_use"
+	case "stop_sequence":
+		return "stop_sequence"
+	}
+	return "end_turn"
+}
+
+// TransformUnifiedResponseToAnthropic converts a unified chat completion into an Anthropic message.
+func TransformUnifiedResponseToAnthropic(body []byte, logger *zap.Logger) ([]byte, error) {
+	var unifiedResp UnifiedChatResponse
+	if err := json.Unmarshal(body, &unifiedResp); err != nil {
+		logger.Error("Failed to unmarshal unified response for Anthropic ingress", zap.Error(err))
+		return nil, fmt.Errorf("unmarshal unified response: %w", err)
+	}
+
+	anthropicResp := AnthropicMessagesResponse{
+		ID:      unifiedResp.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   unifiedResp.Model,
+		Content: []AnthropicContentBlock{},
+	}
+	if len(unifiedResp.Choices) > 0 {
+		choice := unifiedResp.Choices[0]
+		anthropicResp.Content = append(anthropicResp.Content, AnthropicContentBlock{Type: "text", Text: choice.Message.Content})
+		anthropicResp.StopReason = AnthropicStopReason(choice.FinishReason)
+	}
+	if unifiedResp.Usage != nil {
+		anthropicResp.Usage = AnthropicUsage{
+			InputTokens:  unifiedResp.Usage.PromptTokens,
+			OutputTokens: unifiedResp.Usage.CompletionTokens,
+		}
+	}
+	return json.Marshal(anthropicResp)
+}
+
+// TransformOpenAIErrorToAnthropic converts an OpenAI-style error envelope into Anthropic's error shape.
+// Both APIs share the same error type names.
+func TransformOpenAIErrorToAnthropic(body []byte) []byte {
+	var openaiErr struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	errType := "api_error"
+	if err := json.Unmarshal(body, &openaiErr); err == nil && openaiErr.Error.Message != "" {
+		message = openaiErr.Error.Message
+		if openaiErr.Error.Type != "" {
+			errType = openaiErr.Error.Type
+		}
+	}
+	out, _ := json.Marshal(map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errType,
+			"message": message,
+		},
+	})
+	return out
+}
+
+// AnthropicStreamEncoder converts unified SSE chunks into Anthropic Messages streaming events.
+type AnthropicStreamEncoder struct {
+	started      bool
+	stopReason   string
+	inputTokens  int
+	outputTokens int
+}
+
+func anthropicEvent(eventType string, payload map[string]any) []byte {
+	payload["type"] = eventType
+	data, _ := json.Marshal(payload)
+	return []byte("event: " + eventType + "\ndata: " + string(data) + "\n\n")
+}
+
+// Encode converts one unified chunk into zero or more Anthropic events.
+func (e *AnthropicStreamEncoder) Encode(data []byte) ([]byte, error) {
+	var chunk UnifiedChatChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if !e.started {
+		e.started = true
+		out = append(out, anthropicEvent("message_start", map[string]any{
+			"message": map[string]any{
+				"id":            chunk.ID,
+				"type":          "message",
+				"role":          "assistant",
+				"model":         chunk.Model,
+				"content":       []any{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         map[string]any{"input_tokens": 0, "output_tokens": 0},
+			},
+		})...)
+		out = append(out, anthropicEvent("content_block_start", map[string]any{
+			"index":         0,
+			"content_block": map[string]any{"type": "text", "text": ""},
+		})...)
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.Content != "" {
+			out = append(out, anthropicEvent("content_block_delta", map[string]any{
+				"index": 0,
+				"delta": map[string]any{"type": "text_delta", "text": choice.Delta.Content},
+			})...)
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			e.stopReason = AnthropicStopReason(*choice.FinishReason)
+		}
+	}
+	if chunk.Usage != nil {
+		e.inputTokens = chunk.Usage.PromptTokens
+		e.outputTokens = chunk.Usage.CompletionTokens
+	}
+	return out, nil
+}
+
+// Finish emits the closing events of the Anthropic stream.
+func (e *AnthropicStreamEncoder) Finish() []byte {
+	var out []byte
+	if !e.started {
+		return out
+	}
+	if e.stopReason == "" {
+		e.stopReason = "end_turn"
+	}
+	out = append(out, anthropicEvent("content_block_stop", map[string]any{"index": 0})...)
+	out = append(out, anthropicEvent("message_delta", map[string]any{
+		"delta": map[string]any{"stop_reason": e.stopReason, "stop_sequence": nil},
+		"usage": map[string]any{"input_tokens": e.inputTokens, "output_tokens": e.outputTokens},
+	})...)
+	out = append(out, anthropicEvent("message_stop", map[string]any{})...)
+	return out
+}
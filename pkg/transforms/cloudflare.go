@@ -7,8 +7,13 @@ import (
 	"go.uber.org/zap"
 )
 
+// CloudflareAIUnsupportedParams lists unified request parameters Workers AI text models don't
+// take; they are dropped. top_p, seed and the penalties are passed as is.
+var CloudflareAIUnsupportedParams = []string{"stop", "logprobs", "top_logprobs", "logit_bias", "user"}
+
 // TransformRequestToCloudflareAI is a no-op for the request body, as it's the unified format.
-func TransformRequestToCloudflareAI(r *http.Request, originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+// A LoRA adapter, if given, is applied unless the client already picked one.
+func TransformRequestToCloudflareAI(r *http.Request, originalBody []byte, modelName string, lora string, logger *zap.Logger) ([]byte, error) {
 	// we need to unset model from body since Cloudflare AI expects it in the URL path
 
 	var bodyMap map[string]any
@@ -20,6 +25,37 @@ func TransformRequestToCloudflareAI(r *http.Request, originalBody []byte, modelN
 	if _, ok := bodyMap["model"]; ok {
 		delete(bodyMap, "model") // Remove model from body as it's in the URL path
 	}
+	delete(bodyMap, "stream_options") // Not accepted by Workers AI; include_usage is emulated by the router
+	renameMaxCompletionTokens(bodyMap)
+	for _, param := range CloudflareAIUnsupportedParams {
+		delete(bodyMap, param)
+	}
+	if _, ok := bodyMap["lora"]; !ok && lora != "" {
+		bodyMap["lora"] = lora
+	}
+
+	transformedBody, err := json.Marshal(bodyMap)
+	if err != nil {
+		logger.Error("Failed to marshal transformed request body for Cloudflare AI", zap.Error(err))
+		return nil, err
+	}
+
+	return transformedBody, nil
+}
+
+// TransformRequestToCloudflareOpenAI prepares a request for the OpenAI-compatible Workers AI
+// endpoint, which takes the model in the body. Parameters the models don't take are dropped as on /run.
+func TransformRequestToCloudflareOpenAI(originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+	var bodyMap map[string]any
+	if err := json.Unmarshal(originalBody, &bodyMap); err != nil {
+		logger.Error("Failed to unmarshal request body for Cloudflare AI transformation", zap.Error(err))
+		return nil, err
+	}
+
+	bodyMap["model"] = modelName
+	for _, param := range CloudflareAIUnsupportedParams {
+		delete(bodyMap, param)
+	}
 
 	transformedBody, err := json.Marshal(bodyMap)
 	if err != nil {
@@ -59,7 +95,7 @@ func TransformResponseFromCloudflareAI(respBody []byte, logger *zap.Logger) ([]b
 				},
 				"index":         0,
 				"logprobs":      nil,
-				"finish_reason": "",
+				"finish_reason": FinishReasonStop, // Workers AI doesn't report one
 			},
 		},
 	}
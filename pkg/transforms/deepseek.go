@@ -0,0 +1,53 @@
+package transforms
+
+import (
+	"encoding/json"
+
+	"go.uber.org/zap"
+)
+
+// DeepSeekUnsupportedParams lists unified request parameters DeepSeek has no equivalent for;
+// they are dropped.
+var DeepSeekUnsupportedParams = []string{"seed", "reasoning_effort"}
+
+// deepSeekReasonerUnsupportedFields are rejected with an error by deepseek-reasoner.
+var deepSeekReasonerUnsupportedFields = []string{"logprobs", "top_logprobs"}
+
+// TransformRequestToDeepSeek adapts the unified request to DeepSeek's chat completions API.
+// reasoning_content of earlier assistant turns is removed, as DeepSeek rejects it in input.
+func TransformRequestToDeepSeek(originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+	var bodyMap map[string]any
+	if err := json.Unmarshal(originalBody, &bodyMap); err != nil {
+		logger.Error("Failed to unmarshal request body for DeepSeek transformation", zap.Error(err))
+		return nil, err
+	}
+
+	bodyMap["model"] = modelName
+	renameMaxCompletionTokens(bodyMap)
+	for _, param := range DeepSeekUnsupportedParams {
+		delete(bodyMap, param)
+	}
+	if modelName == "deepseek-reasoner" {
+		for _, field := range deepSeekReasonerUnsupportedFields {
+			if _, ok := bodyMap[field]; ok {
+				logger.Debug("Dropping field unsupported by deepseek-reasoner", zap.String("field", field))
+				delete(bodyMap, field)
+			}
+		}
+	}
+	if messages, ok := bodyMap["messages"].([]any); ok {
+		for _, msg := range messages {
+			if msgMap, ok := msg.(map[string]any); ok {
+				delete(msgMap, "reasoning_content")
+			}
+		}
+	}
+
+	transformedBody, err := json.Marshal(bodyMap)
+	if err != nil {
+		logger.Error("Failed to marshal transformed request body for DeepSeek", zap.Error(err))
+		return nil, err
+	}
+
+	return transformedBody, nil
+}
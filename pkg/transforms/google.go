@@ -14,23 +14,57 @@ import (
 
 // GoogleAIPart defines a part of a Google AI content message.
 type GoogleAIPart struct {
-	Text string `json:"text,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Thought bool   `json:"thought,omitempty"` // Set on thought summaries of thinking models
 	// InlineData, FileData etc. could be added here
 }
 
 // GoogleAIContent defines a content block in a Google AI request/response.
 type GoogleAIContent struct {
-	Role  string         `json:"role"` // "user" or "model"
+	Role  string         `json:"role,omitempty"` // "user" or "model"; unset for system instructions
 	Parts []GoogleAIPart `json:"parts"`
 }
 
 // GoogleAIGenerateContentRequest defines the request structure for Google AI's generateContent.
 type GoogleAIGenerateContentRequest struct {
-	Contents []GoogleAIContent `json:"contents"`
-	// GenerationConfig, SafetySettings, etc. can be added here.
+	Contents          []GoogleAIContent         `json:"contents"`
+	SystemInstruction *GoogleAIContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GoogleAIGenerationConfig `json:"generationConfig,omitempty"`
+	// SafetySettings, etc. can be added here.
 	// Model name is typically part of the URL for Google AI.
 }
 
+// GoogleAIGenerationConfig defines the sampling options of a Google AI request.
+type GoogleAIGenerationConfig struct {
+	Temperature      *float64                `json:"temperature,omitempty"`
+	TopP             *float64                `json:"topP,omitempty"`
+	TopK             *int                    `json:"topK,omitempty"`
+	MaxOutputTokens  *int                    `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string                `json:"stopSequences,omitempty"`
+	CandidateCount   *int                    `json:"candidateCount,omitempty"`
+	PresencePenalty  *float64                `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64                `json:"frequencyPenalty,omitempty"`
+	Seed             *int64                  `json:"seed,omitempty"`
+	ThinkingConfig   *GoogleAIThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+// GoogleAIThinkingConfig sets the thinking budget of Gemini thinking models.
+type GoogleAIThinkingConfig struct {
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+}
+
+// GoogleAIUnsupportedParams lists unified request parameters the router doesn't carry over to
+// Gemini; they are dropped.
+var GoogleAIUnsupportedParams = []string{"logprobs", "top_logprobs", "user"}
+
+// GoogleAIUsageMetadata defines token usage reported by Google AI.
+type GoogleAIUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
 // GoogleAICandidate defines a candidate response from Google AI.
 type GoogleAICandidate struct {
 	Content      GoogleAIContent `json:"content"`
@@ -49,8 +83,10 @@ type GoogleAIPromptFeedback struct {
 type GoogleAIGenerateContentResponse struct {
 	Candidates     []GoogleAICandidate     `json:"candidates"`
 	PromptFeedback *GoogleAIPromptFeedback `json:"promptFeedback,omitempty"`
-	// UsageMetadata (for token counts) would be part of this if available directly.
-	// Often token counts for Google AI are estimated or provided differently.
+	UsageMetadata  *GoogleAIUsageMetadata  `json:"usageMetadata,omitempty"`
+	ModelVersion   string                  `json:"modelVersion,omitempty"`
+	// Set on streamed responses, the same on every chunk of a stream
+	ResponseID string `json:"responseId,omitempty"`
 }
 
 func TransformRequestToGoogleAI(r *http.Request, originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
@@ -74,30 +110,32 @@ func TransformRequestToGoogleAI(r *http.Request, originalBody []byte, modelName
 	}
 
 	googleReq := GoogleAIGenerateContentRequest{
-		Contents: make([]GoogleAIContent, 0, len(unifiedReq.Messages)),
+		Contents:         make([]GoogleAIContent, 0, len(unifiedReq.Messages)),
+		GenerationConfig: googleGenerationConfig(unifiedReq),
 	}
 
+	// System and developer messages, wherever they appear, go to systemInstruction; Gemini has no
+	// system turns
+	var system []string
 	for _, msg := range unifiedReq.Messages {
+		if msg.IsSystem() {
+			if msg.Content != "" {
+				system = append(system, msg.Content)
+			}
+			continue
+		}
 		role := "user" // Default for Google
 		if msg.Role == "assistant" {
 			role = "model"
-		} else if msg.Role == "system" {
-			// Google's Gemini API handles system instructions differently (often via a specific field or by prepending to the first user message).
-			// For simplicity, we'll convert a system message to a user message if it's the first one,
-			// or a model message (as context) if it's not. This is a simplification.
-			// A more robust solution would involve checking Google's specific model capabilities.
-			logger.Info("Transforming system message for Google AI", zap.String("content", msg.Content))
-			if len(googleReq.Contents) == 0 {
-				role = "user" // Treat as initial user prompt part
-			} else {
-				role = "model" // Treat as part of the ongoing conversation history
-			}
 		}
 		googleReq.Contents = append(googleReq.Contents, GoogleAIContent{
 			Role:  role,
 			Parts: []GoogleAIPart{{Text: msg.Content}},
 		})
 	}
+	if len(system) > 0 {
+		googleReq.SystemInstruction = &GoogleAIContent{Parts: []GoogleAIPart{{Text: strings.Join(system, "\n\n")}}}
+	}
 
 	transformedBody, err := json.Marshal(googleReq)
 	if err != nil {
@@ -108,6 +146,28 @@ func TransformRequestToGoogleAI(r *http.Request, originalBody []byte, modelName
 	return transformedBody, nil
 }
 
+// googleGenerationConfig maps the sampling options of a unified request onto Gemini's
+// generationConfig, or returns nil if the request sets none.
+func googleGenerationConfig(unifiedReq UnifiedChatRequest) *GoogleAIGenerationConfig {
+	cfg := GoogleAIGenerationConfig{
+		Temperature:      unifiedReq.Temperature,
+		TopP:             unifiedReq.TopP,
+		MaxOutputTokens:  unifiedReq.OutputTokenLimit(),
+		StopSequences:    unifiedReq.Stop,
+		PresencePenalty:  unifiedReq.PresencePenalty,
+		FrequencyPenalty: unifiedReq.FrequencyPenalty,
+		Seed:             unifiedReq.Seed,
+	}
+	if budget := ReasoningBudget(unifiedReq.ReasoningEffort); budget > 0 {
+		cfg.ThinkingConfig = &GoogleAIThinkingConfig{ThinkingBudget: &budget, IncludeThoughts: true}
+	}
+	if cfg.Temperature == nil && cfg.TopP == nil && cfg.MaxOutputTokens == nil && len(cfg.StopSequences) == 0 &&
+		cfg.PresencePenalty == nil && cfg.FrequencyPenalty == nil && cfg.Seed == nil && cfg.ThinkingConfig == nil {
+		return nil
+	}
+	return &cfg
+}
+
 func TransformResponseFromGoogleAI(respBody []byte, logger *zap.Logger) ([]byte, error) {
 	var googleResp GoogleAIGenerateContentResponse
 	if err := json.Unmarshal(respBody, &googleResp); err != nil {
@@ -132,10 +192,17 @@ func TransformResponseFromGoogleAI(respBody []byte, logger *zap.Logger) ([]byte,
 		unifiedResp.Choices = append(unifiedResp.Choices, UnifiedChoice{
 			Index: 0,
 			Message: UnifiedChatMessage{
-				Role:    "assistant",
-				Content: candidate.Content.Parts[0].Text,
+				Role:             "assistant",
+				Content:          googlePartsText(candidate.Content.Parts), // Blocked candidates have no parts
+				ReasoningContent: googleThoughtsText(candidate.Content.Parts),
 			},
-			FinishReason: candidate.FinishReason,
+			FinishReason: NormalizeFinishReason(candidate.FinishReason),
+		})
+	} else if googleResp.PromptFeedback != nil && googleResp.PromptFeedback.BlockReason != "" {
+		// A blocked prompt gets no candidates at all
+		unifiedResp.Choices = append(unifiedResp.Choices, UnifiedChoice{
+			Message:      UnifiedChatMessage{Role: "assistant"},
+			FinishReason: FinishReasonContentFilter,
 		})
 	}
 
@@ -151,3 +218,65 @@ func TransformResponseFromGoogleAI(respBody []byte, logger *zap.Logger) ([]byte,
 
 	return transformedBytes, nil
 }
+
+// TransformStreamChunkFromGoogleAI converts one event of a streamGenerateContent stream, a
+// partial GenerateContentResponse, into a unified stream chunk. Usage is reported with the
+// chunk that finishes the candidate, as Google repeats running totals on every event.
+func TransformStreamChunkFromGoogleAI(chunkBody []byte, logger *zap.Logger) ([]byte, error) {
+	var googleResp GoogleAIGenerateContentResponse
+	if err := json.Unmarshal(chunkBody, &googleResp); err != nil {
+		logger.Error("Failed to unmarshal google stream chunk", zap.Error(err), zap.ByteString("body", chunkBody))
+		return chunkBody, nil
+	}
+
+	id := googleResp.ResponseID
+	if id == "" {
+		id = "gen-" + fmt.Sprintf("%d", common.CaddyClock.Now().Unix())
+	}
+	chunk := UnifiedChatChunk{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Created: common.CaddyClock.Now().Unix(),
+		Model:   googleResp.ModelVersion,
+		Choices: make([]UnifiedChunkChoice, 0, 1),
+	}
+
+	finished := false
+	if len(googleResp.Candidates) > 0 {
+		candidate := googleResp.Candidates[0]
+		choice := UnifiedChunkChoice{
+			Delta: UnifiedDelta{
+				Role:             "assistant",
+				Content:          googlePartsText(candidate.Content.Parts),
+				ReasoningContent: googleThoughtsText(candidate.Content.Parts),
+			},
+		}
+		if candidate.FinishReason != "" {
+			finishReason := NormalizeFinishReason(candidate.FinishReason)
+			choice.FinishReason = &finishReason
+			finished = true
+		}
+		chunk.Choices = append(chunk.Choices, choice)
+	} else if googleResp.PromptFeedback != nil && googleResp.PromptFeedback.BlockReason != "" {
+		finishReason := FinishReasonContentFilter
+		chunk.Choices = append(chunk.Choices, UnifiedChunkChoice{
+			Delta:        UnifiedDelta{Role: "assistant"},
+			FinishReason: &finishReason,
+		})
+		finished = true
+	}
+	if finished && googleResp.UsageMetadata != nil {
+		chunk.Usage = &UnifiedUsage{
+			PromptTokens:     googleResp.UsageMetadata.PromptTokenCount,
+			CompletionTokens: googleResp.UsageMetadata.CandidatesTokenCount,
+			TotalTokens:      googleResp.UsageMetadata.TotalTokenCount,
+		}
+	}
+
+	transformedBytes, err := json.Marshal(chunk)
+	if err != nil {
+		logger.Error("Failed to marshal unified stream chunk from google", zap.Error(err))
+		return nil, fmt.Errorf("marshaling unified stream chunk from google: %w", err)
+	}
+	return transformedBytes, nil
+}
@@ -0,0 +1,205 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// --- Google GenerativeLanguage API ingress (Gemini SDK clients talking to the router) ---
+
+// GoogleIngressRequest defines an incoming generateContent / streamGenerateContent request.
+type GoogleIngressRequest struct {
+	Contents          []GoogleAIContent         `json:"contents"`
+	SystemInstruction *GoogleAIContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GoogleAIGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+func googlePartsText(parts []GoogleAIPart) string {
+	return googleJoinParts(parts, false)
+}
+
+// googleThoughtsText joins the thought summaries among the parts of a thinking model's answer.
+func googleThoughtsText(parts []GoogleAIPart) string {
+	return googleJoinParts(parts, true)
+}
+
+func googleJoinParts(parts []GoogleAIPart, thoughts bool) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Text != "" && part.Thought == thoughts {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// TransformGoogleRequestToUnified converts a Gemini generateContent request for the given model into the unified format.
+func TransformGoogleRequestToUnified(body []byte, modelName string, stream bool, logger *zap.Logger) ([]byte, error) {
+	var googleReq GoogleIngressRequest
+	if err := json.Unmarshal(body, &googleReq); err != nil {
+		return nil, fmt.Errorf("unmarshal Google AI request: %w", err)
+	}
+
+	messages := make([]UnifiedChatMessage, 0, len(googleReq.Contents)+1)
+	if googleReq.SystemInstruction != nil {
+		if system := googlePartsText(googleReq.SystemInstruction.Parts); system != "" {
+			messages = append(messages, UnifiedChatMessage{Role: "system", Content: system})
+		}
+	}
+	for _, content := range googleReq.Contents {
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+		messages = append(messages, UnifiedChatMessage{Role: role, Content: googlePartsText(content.Parts)})
+	}
+
+	unifiedReq := map[string]any{
+		"model":    modelName,
+		"messages": messages,
+		"stream":   stream,
+	}
+	if cfg := googleReq.GenerationConfig; cfg != nil {
+		if cfg.Temperature != nil {
+			unifiedReq["temperature"] = *cfg.Temperature
+		}
+		if cfg.TopP != nil {
+			unifiedReq["top_p"] = *cfg.TopP
+		}
+		if cfg.MaxOutputTokens != nil {
+			unifiedReq["max_tokens"] = *cfg.MaxOutputTokens
+		}
+		if len(cfg.StopSequences) > 0 {
+			unifiedReq["stop"] = cfg.StopSequences
+		}
+		if cfg.CandidateCount != nil && *cfg.CandidateCount > 1 {
+			unifiedReq["n"] = *cfg.CandidateCount
+		}
+	}
+	return json.Marshal(unifiedReq)
+}
+
+// GoogleFinishReason maps a unified finish reason onto Google's finishReason vocabulary.
+func GoogleFinishReason(finishReason string) string {
+	switch finishReason {
+	case "":
+		return ""
+	case "length", "max_tokens", "MAX_TOKENS":
+		return "MAX_TOKENS"
+	case "content_filter", "SAFETY":
+		return "SAFETY"
+	}
+	return "STOP"
+}
+
+// TransformUnifiedResponseToGoogle converts a unified chat completion into a Gemini GenerateContentResponse.
+func TransformUnifiedResponseToGoogle(body []byte, logger *zap.Logger) ([]byte, error) {
+	var unifiedResp UnifiedChatResponse
+	if err := json.Unmarshal(body, &unifiedResp); err != nil {
+		logger.Error("Failed to unmarshal unified response for Google AI ingress", zap.Error(err))
+		return nil, fmt.Errorf("unmarshal unified response: %w", err)
+	}
+
+	googleResp := GoogleAIGenerateContentResponse{
+		Candidates:   make([]GoogleAICandidate, 0, len(unifiedResp.Choices)),
+		ModelVersion: unifiedResp.Model,
+	}
+	for _, choice := range unifiedResp.Choices {
+		googleResp.Candidates = append(googleResp.Candidates, GoogleAICandidate{
+			Content:      GoogleAIContent{Role: "model", Parts: []GoogleAIPart{{Text: choice.Message.Content}}},
+			FinishReason: GoogleFinishReason(choice.FinishReason),
+			Index:        int32(choice.Index),
+		})
+	}
+	if unifiedResp.Usage != nil {
+		googleResp.UsageMetadata = &GoogleAIUsageMetadata{
+			PromptTokenCount:     unifiedResp.Usage.PromptTokens,
+			CandidatesTokenCount: unifiedResp.Usage.CompletionTokens,
+			TotalTokenCount:      unifiedResp.Usage.TotalTokens,
+		}
+	}
+	return json.Marshal(googleResp)
+}
+
+// TransformUnifiedChunkToGoogle converts a unified SSE chunk into a streamed GenerateContentResponse.
+// It returns nil if the chunk carries nothing Gemini clients would see.
+func TransformUnifiedChunkToGoogle(data []byte) ([]byte, error) {
+	var chunk UnifiedChatChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return nil, err
+	}
+
+	googleResp := GoogleAIGenerateContentResponse{
+		Candidates:   make([]GoogleAICandidate, 0, len(chunk.Choices)),
+		ModelVersion: chunk.Model,
+	}
+	for _, choice := range chunk.Choices {
+		candidate := GoogleAICandidate{
+			Content: GoogleAIContent{Role: "model", Parts: []GoogleAIPart{{Text: choice.Delta.Content}}},
+			Index:   int32(choice.Index),
+		}
+		if choice.FinishReason != nil {
+			candidate.FinishReason = GoogleFinishReason(*choice.FinishReason)
+		}
+		googleResp.Candidates = append(googleResp.Candidates, candidate)
+	}
+	if chunk.Usage != nil {
+		googleResp.UsageMetadata = &GoogleAIUsageMetadata{
+			PromptTokenCount:     chunk.Usage.PromptTokens,
+			CandidatesTokenCount: chunk.Usage.CompletionTokens,
+			TotalTokenCount:      chunk.Usage.TotalTokens,
+		}
+	}
+	if len(googleResp.Candidates) == 0 && googleResp.UsageMetadata == nil {
+		return nil, nil
+	}
+	return json.Marshal(googleResp)
+}
+
+// googleErrorStatus maps HTTP status codes onto google.rpc status names.
+func googleErrorStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusRequestEntityTooLarge:
+		return "INVALID_ARGUMENT"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		return "DEADLINE_EXCEEDED"
+	}
+	return "INTERNAL"
+}
+
+// TransformOpenAIErrorToGoogle converts an OpenAI-style error envelope into Google's error shape.
+func TransformOpenAIErrorToGoogle(statusCode int, body []byte) []byte {
+	var openaiErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &openaiErr); err == nil && openaiErr.Error.Message != "" {
+		message = openaiErr.Error.Message
+	}
+	out, _ := json.Marshal(map[string]any{
+		"error": map[string]any{
+			"code":    statusCode,
+			"message": message,
+			"status":  googleErrorStatus(statusCode),
+		},
+	})
+	return out
+}
@@ -0,0 +1,51 @@
+package transforms
+
+import (
+	"encoding/json"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// grok4UnsupportedFields are rejected with an error by Grok 4 models, which always reason.
+var grok4UnsupportedFields = []string{"presence_penalty", "frequency_penalty", "stop", "reasoning_effort"}
+
+// TransformRequestToGrok adapts the unified request to xAI's chat completions API. Streams can't
+// be deferred, so deferred is dropped from streaming requests.
+func TransformRequestToGrok(originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+	var bodyMap map[string]any
+	if err := json.Unmarshal(originalBody, &bodyMap); err != nil {
+		logger.Error("Failed to unmarshal request body for Grok transformation", zap.Error(err))
+		return nil, err
+	}
+
+	bodyMap["model"] = modelName
+	if stream, _ := bodyMap["stream"].(bool); stream {
+		delete(bodyMap, "deferred")
+	}
+	if strings.HasPrefix(modelName, "grok-4") {
+		for _, field := range grok4UnsupportedFields {
+			if _, ok := bodyMap[field]; ok {
+				logger.Debug("Dropping field unsupported by Grok 4", zap.String("field", field))
+				delete(bodyMap, field)
+			}
+		}
+	} else if effort, ok := bodyMap["reasoning_effort"].(string); ok {
+		switch {
+		case !strings.HasPrefix(modelName, "grok-3-mini"):
+			delete(bodyMap, "reasoning_effort") // Only the mini models take it
+		case ReasoningBudget(effort) > ReasoningBudgetLow:
+			bodyMap["reasoning_effort"] = "high" // Grok 3 mini only knows low and high
+		default:
+			bodyMap["reasoning_effort"] = "low"
+		}
+	}
+
+	transformedBody, err := json.Marshal(bodyMap)
+	if err != nil {
+		logger.Error("Failed to marshal transformed request body for Grok", zap.Error(err))
+		return nil, err
+	}
+
+	return transformedBody, nil
+}
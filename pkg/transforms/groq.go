@@ -0,0 +1,32 @@
+package transforms
+
+import (
+	"encoding/json"
+
+	"go.uber.org/zap"
+)
+
+// GroqUnsupportedParams lists unified request parameters Groq rejects; they are dropped.
+var GroqUnsupportedParams = []string{"logprobs", "top_logprobs", "logit_bias"}
+
+// TransformRequestToGroq adapts the unified request to Groq's chat completions API.
+func TransformRequestToGroq(originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+	var bodyMap map[string]any
+	if err := json.Unmarshal(originalBody, &bodyMap); err != nil {
+		logger.Error("Failed to unmarshal request body for Groq transformation", zap.Error(err))
+		return nil, err
+	}
+
+	bodyMap["model"] = modelName
+	for _, param := range GroqUnsupportedParams {
+		delete(bodyMap, param)
+	}
+
+	transformedBody, err := json.Marshal(bodyMap)
+	if err != nil {
+		logger.Error("Failed to marshal transformed request body for Groq", zap.Error(err))
+		return nil, err
+	}
+
+	return transformedBody, nil
+}
@@ -0,0 +1,185 @@
+package transforms
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Image response formats of the OpenAI images API.
+const (
+	ImageFormatURL    = "url"
+	ImageFormatBase64 = "b64_json"
+)
+
+// UnifiedImagesRequest defines an OpenAI-style image generation request.
+type UnifiedImagesRequest struct {
+	Model          string `json:"model"`
+	Prompt         string `json:"prompt"`
+	N              *int   `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"`
+	Quality        string `json:"quality,omitempty"`
+	Style          string `json:"style,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+	User           string `json:"user,omitempty"`
+}
+
+// UnifiedImage is one generated image.
+type UnifiedImage struct {
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+// UnifiedImagesResponse defines an OpenAI-style image generation response.
+type UnifiedImagesResponse struct {
+	Created int64          `json:"created"`
+	Data    []UnifiedImage `json:"data"`
+}
+
+// ParseImageSize splits an OpenAI size such as "1024x768" into width and height.
+func ParseImageSize(size string) (width int, height int, ok bool) {
+	w, h, found := strings.Cut(strings.ToLower(size), "x")
+	if !found {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil {
+		return 0, 0, false
+	}
+	height, err = strconv.Atoi(h)
+	if err != nil {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// NewImage wraps raw image bytes in the requested response format. Without a hosted copy to
+// link to, "url" responses carry a data URL.
+func NewImage(data []byte, mimeType string, format string) UnifiedImage {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return NewImageFromBase64(encoded, mimeType, format)
+}
+
+// NewImageFromBase64 wraps base64 image data in the requested response format.
+func NewImageFromBase64(encoded string, mimeType string, format string) UnifiedImage {
+	if format == ImageFormatBase64 {
+		return UnifiedImage{B64JSON: encoded}
+	}
+	if mimeType == "" {
+		mimeType = "image/png"
+	}
+	return UnifiedImage{URL: "data:" + mimeType + ";base64," + encoded}
+}
+
+// MarshalImagesResponse renders a unified images response created now.
+func MarshalImagesResponse(images []UnifiedImage) ([]byte, error) {
+	return json.Marshal(UnifiedImagesResponse{Created: time.Now().Unix(), Data: images})
+}
+
+// --- Stability AI (v1 text-to-image) ---
+
+// StabilityTextToImageRequest defines a Stability v1 text-to-image request.
+type StabilityTextToImageRequest struct {
+	TextPrompts []StabilityTextPrompt `json:"text_prompts"`
+	Samples     int                   `json:"samples,omitempty"`
+	Width       int                   `json:"width,omitempty"`
+	Height      int                   `json:"height,omitempty"`
+	StylePreset string                `json:"style_preset,omitempty"`
+}
+
+// StabilityTextPrompt is one weighted prompt of a Stability request.
+type StabilityTextPrompt struct {
+	Text   string  `json:"text"`
+	Weight float64 `json:"weight,omitempty"`
+}
+
+// TransformImagesRequestToStability converts a unified images request into a Stability text-to-image request.
+func TransformImagesRequestToStability(body []byte, logger *zap.Logger) ([]byte, error) {
+	var imagesReq UnifiedImagesRequest
+	if err := json.Unmarshal(body, &imagesReq); err != nil {
+		return nil, fmt.Errorf("unmarshal images request: %w", err)
+	}
+	stabilityReq := StabilityTextToImageRequest{
+		TextPrompts: []StabilityTextPrompt{{Text: imagesReq.Prompt}},
+	}
+	if imagesReq.N != nil {
+		stabilityReq.Samples = *imagesReq.N
+	}
+	if width, height, ok := ParseImageSize(imagesReq.Size); ok {
+		stabilityReq.Width, stabilityReq.Height = width, height
+	}
+	if imagesReq.Style != "" {
+		logger.Debug("Dropping OpenAI image style unsupported by Stability", zap.String("style", imagesReq.Style))
+	}
+	return json.Marshal(stabilityReq)
+}
+
+// TransformStabilityImagesResponse converts a Stability text-to-image response into the unified format.
+func TransformStabilityImagesResponse(body []byte, format string) ([]byte, error) {
+	var stabilityResp struct {
+		Artifacts []struct {
+			Base64       string `json:"base64"`
+			FinishReason string `json:"finishReason"`
+		} `json:"artifacts"`
+	}
+	if err := json.Unmarshal(body, &stabilityResp); err != nil {
+		return nil, fmt.Errorf("unmarshal Stability response: %w", err)
+	}
+	images := make([]UnifiedImage, 0, len(stabilityResp.Artifacts))
+	for _, artifact := range stabilityResp.Artifacts {
+		if artifact.FinishReason == "ERROR" {
+			continue
+		}
+		images = append(images, NewImageFromBase64(artifact.Base64, "image/png", format))
+	}
+	return MarshalImagesResponse(images)
+}
+
+// --- Cloudflare Workers AI image models ---
+
+// TransformImagesRequestToCloudflare converts a unified images request for Workers AI text-to-image models.
+func TransformImagesRequestToCloudflare(body []byte, logger *zap.Logger) ([]byte, error) {
+	var imagesReq UnifiedImagesRequest
+	if err := json.Unmarshal(body, &imagesReq); err != nil {
+		return nil, fmt.Errorf("unmarshal images request: %w", err)
+	}
+	cfReq := map[string]any{"prompt": imagesReq.Prompt}
+	if width, height, ok := ParseImageSize(imagesReq.Size); ok {
+		cfReq["width"], cfReq["height"] = width, height
+	}
+	if imagesReq.N != nil && *imagesReq.N > 1 {
+		logger.Debug("Workers AI generates one image per request, ignoring n", zap.Int("n", *imagesReq.N))
+	}
+	return json.Marshal(cfReq)
+}
+
+// TransformCloudflareImagesResponse converts a Workers AI image response, which is either raw
+// image bytes or JSON with a base64 "image", into the unified format.
+func TransformCloudflareImagesResponse(contentType string, body []byte, format string) ([]byte, error) {
+	if strings.HasPrefix(contentType, "image/") {
+		return MarshalImagesResponse([]UnifiedImage{NewImage(body, contentType, format)})
+	}
+	var cfResp struct {
+		Result struct {
+			Image string `json:"image"`
+		} `json:"result"`
+		Image string `json:"image"`
+	}
+	if err := json.Unmarshal(body, &cfResp); err != nil {
+		return nil, fmt.Errorf("unmarshal Workers AI image response: %w", err)
+	}
+	image := cfResp.Result.Image
+	if image == "" {
+		image = cfResp.Image
+	}
+	if image == "" {
+		return nil, fmt.Errorf("no image in Workers AI response")
+	}
+	return MarshalImagesResponse([]UnifiedImage{NewImageFromBase64(image, "image/png", format)})
+}
@@ -0,0 +1,56 @@
+package transforms
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// mistralUnsupportedFields lists OpenAI request fields that La Plateforme rejects as extra inputs.
+// Mistral always reports usage on the final stream chunk, so stream_options is emulated by the router.
+var mistralUnsupportedFields = []string{"user", "logit_bias", "logprobs", "top_logprobs", "reasoning_effort", "store", "metadata", "service_tier", "stream_options"}
+
+// MistralUnsupportedParams lists the sampling-related parameters among them.
+var MistralUnsupportedParams = []string{"user", "logit_bias", "logprobs", "top_logprobs", "reasoning_effort"}
+
+// TransformRequestToMistral adapts the unified request to Mistral's chat completions API.
+func TransformRequestToMistral(r *http.Request, originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+	var bodyMap map[string]any
+	if err := json.Unmarshal(originalBody, &bodyMap); err != nil {
+		logger.Error("Failed to unmarshal request body for Mistral transformation", zap.Error(err))
+		return nil, err
+	}
+
+	bodyMap["model"] = modelName
+
+	// Mistral names the sampling seed "random_seed"
+	if seed, ok := bodyMap["seed"]; ok {
+		bodyMap["random_seed"] = seed
+		delete(bodyMap, "seed")
+	}
+
+	renameMaxCompletionTokens(bodyMap)
+
+	// Mistral continues a final assistant message flagged as a prefix, instead of rejecting it
+	if messages, ok := bodyMap["messages"].([]any); ok && len(messages) > 0 {
+		if last, ok := messages[len(messages)-1].(map[string]any); ok && last["role"] == "assistant" {
+			last["prefix"] = true
+		}
+	}
+
+	for _, field := range mistralUnsupportedFields {
+		if _, ok := bodyMap[field]; ok {
+			logger.Debug("Dropping field unsupported by Mistral", zap.String("field", field))
+			delete(bodyMap, field)
+		}
+	}
+
+	transformedBody, err := json.Marshal(bodyMap)
+	if err != nil {
+		logger.Error("Failed to marshal transformed request body for Mistral", zap.Error(err))
+		return nil, err
+	}
+
+	return transformedBody, nil
+}
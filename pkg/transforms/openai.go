@@ -18,6 +18,13 @@ func TransformRequestToOpenAI(r *http.Request, originalBody []byte, modelName st
 	if _, ok := bodyMap["model"]; ok {
 		bodyMap["model"] = modelName // Ensure the model name is set correctly
 	}
+	// Reasoning models reject max_tokens in favour of max_completion_tokens
+	if maxTokens, ok := bodyMap["max_tokens"]; ok && IsOpenAIReasoningModel(modelName) {
+		if _, ok := bodyMap["max_completion_tokens"]; !ok {
+			bodyMap["max_completion_tokens"] = maxTokens
+		}
+		delete(bodyMap, "max_tokens")
+	}
 
 	transformedBody, err := json.Marshal(bodyMap)
 	if err != nil {
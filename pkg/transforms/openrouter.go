@@ -0,0 +1,32 @@
+package transforms
+
+import (
+	"encoding/json"
+
+	"go.uber.org/zap"
+)
+
+// TransformRequestToOpenRouter sets the model of an OpenAI chat request for OpenRouter. Everything
+// else, including OpenRouter's provider preferences, transforms, route and fallback models, is
+// left untouched, with numbers kept exactly as sent.
+func TransformRequestToOpenRouter(originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+	var bodyMap map[string]json.RawMessage
+	if err := json.Unmarshal(originalBody, &bodyMap); err != nil {
+		logger.Error("Failed to unmarshal request body for OpenRouter transformation", zap.Error(err))
+		return nil, err
+	}
+
+	model, err := json.Marshal(modelName)
+	if err != nil {
+		return nil, err
+	}
+	bodyMap["model"] = model
+
+	transformedBody, err := json.Marshal(bodyMap)
+	if err != nil {
+		logger.Error("Failed to marshal transformed request body for OpenRouter", zap.Error(err))
+		return nil, err
+	}
+
+	return transformedBody, nil
+}
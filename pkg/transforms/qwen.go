@@ -0,0 +1,36 @@
+package transforms
+
+import (
+	"encoding/json"
+
+	"go.uber.org/zap"
+)
+
+// TransformRequestToQwen adapts the unified request to DashScope's OpenAI-compatible chat
+// completions API. reasoning_effort turns on thinking (enable_thinking) with the matching
+// thinking_budget.
+func TransformRequestToQwen(originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+	var bodyMap map[string]any
+	if err := json.Unmarshal(originalBody, &bodyMap); err != nil {
+		logger.Error("Failed to unmarshal request body for Qwen transformation", zap.Error(err))
+		return nil, err
+	}
+
+	bodyMap["model"] = modelName
+	renameMaxCompletionTokens(bodyMap)
+	if effort, ok := bodyMap["reasoning_effort"].(string); ok {
+		if budget := ReasoningBudget(effort); budget > 0 {
+			bodyMap["enable_thinking"] = true
+			bodyMap["thinking_budget"] = budget
+		}
+		delete(bodyMap, "reasoning_effort")
+	}
+
+	transformedBody, err := json.Marshal(bodyMap)
+	if err != nil {
+		logger.Error("Failed to marshal transformed request body for Qwen", zap.Error(err))
+		return nil, err
+	}
+
+	return transformedBody, nil
+}
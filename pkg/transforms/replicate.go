@@ -0,0 +1,196 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"go.uber.org/zap"
+)
+
+// --- Replicate Style Structures ---
+
+// ReplicatePredictionRequest defines the request to create a Replicate prediction.
+type ReplicatePredictionRequest struct {
+	Version string         `json:"version,omitempty"` // Only for "owner/name:version" models
+	Input   map[string]any `json:"input"`
+}
+
+// ReplicatePrediction defines a Replicate prediction as returned by create and get calls.
+type ReplicatePrediction struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Status  string `json:"status"` // "starting", "processing", "succeeded", "failed", "canceled"
+	Output  any    `json:"output"` // Usually an array of string tokens for language models
+	Error   any    `json:"error"`
+	Metrics *struct {
+		InputTokenCount  int `json:"input_token_count"`
+		OutputTokenCount int `json:"output_token_count"`
+	} `json:"metrics,omitempty"`
+	URLs struct {
+		Get    string `json:"get"`
+		Cancel string `json:"cancel"`
+		Stream string `json:"stream"`
+	} `json:"urls"`
+}
+
+// Done reports whether the prediction reached a terminal state.
+func (p *ReplicatePrediction) Done() bool {
+	return p.Status == "succeeded" || p.Status == "failed" || p.Status == "canceled"
+}
+
+// ErrorMessage returns the failure reason for unsuccessful terminal predictions.
+func (p *ReplicatePrediction) ErrorMessage() string {
+	if p.Status == "succeeded" {
+		return ""
+	}
+	if p.Error != nil {
+		return fmt.Sprintf("%v", p.Error)
+	}
+	return "prediction " + p.Status
+}
+
+// ReplicatePredictionPath returns the prediction creation path for a Replicate model reference.
+func ReplicatePredictionPath(modelName string) string {
+	if strings.Contains(modelName, ":") {
+		return "/predictions"
+	}
+	return "/models/" + modelName + "/predictions"
+}
+
+// ReplicateUnsupportedParams lists unified request parameters Replicate language models don't
+// take; they are dropped.
+var ReplicateUnsupportedParams = []string{"logprobs", "top_logprobs", "user", "reasoning_effort"}
+
+func TransformRequestToReplicate(r *http.Request, originalBody []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+	var unifiedReq UnifiedChatRequest
+	if err := json.Unmarshal(originalBody, &unifiedReq); err != nil {
+		logger.Error("Failed to unmarshal original request for Replicate transformation", zap.Error(err), zap.ByteString("body", originalBody))
+		return nil, fmt.Errorf("unmarshal original request for Replicate: %w", err)
+	}
+
+	var systemPrompt string
+	var turns []UnifiedChatMessage
+	for _, msg := range unifiedReq.Messages {
+		if msg.IsSystem() {
+			if systemPrompt != "" {
+				systemPrompt += "\n"
+			}
+			systemPrompt += msg.Content
+			continue
+		}
+		turns = append(turns, msg)
+	}
+
+	// Replicate language models take a single prompt; render multi-turn chats as a transcript.
+	var prompt string
+	if len(turns) == 1 && turns[0].Role == "user" {
+		prompt = turns[0].Content
+	} else {
+		var sb strings.Builder
+		prefill, continued := unifiedReq.Prefill()
+		if continued {
+			turns = turns[:len(turns)-1]
+		}
+		for _, msg := range turns {
+			role := "User"
+			if msg.Role == "assistant" {
+				role = "Assistant"
+			}
+			sb.WriteString(role + ": " + msg.Content + "\n")
+		}
+		sb.WriteString("Assistant:")
+		if continued {
+			// The model continues the final assistant message
+			sb.WriteString(" " + prefill)
+		}
+		prompt = sb.String()
+	}
+
+	input := map[string]any{"prompt": prompt}
+	if systemPrompt != "" {
+		input["system_prompt"] = systemPrompt
+	}
+	if maxTokens := unifiedReq.OutputTokenLimit(); maxTokens != nil {
+		input["max_tokens"] = *maxTokens
+	}
+	if unifiedReq.Temperature != nil {
+		input["temperature"] = *unifiedReq.Temperature
+	}
+	if unifiedReq.TopP != nil {
+		input["top_p"] = *unifiedReq.TopP
+	}
+	if len(unifiedReq.Stop) > 0 {
+		input["stop_sequences"] = strings.Join(unifiedReq.Stop, ",")
+	}
+	if unifiedReq.PresencePenalty != nil {
+		input["presence_penalty"] = *unifiedReq.PresencePenalty
+	}
+	if unifiedReq.FrequencyPenalty != nil {
+		input["frequency_penalty"] = *unifiedReq.FrequencyPenalty
+	}
+	if unifiedReq.Seed != nil {
+		input["seed"] = *unifiedReq.Seed
+	}
+
+	replicateReq := ReplicatePredictionRequest{Input: input}
+	if idx := strings.Index(modelName, ":"); idx != -1 {
+		replicateReq.Version = modelName[idx+1:]
+	}
+
+	transformedBody, err := json.Marshal(replicateReq)
+	if err != nil {
+		logger.Error("Failed to marshal request for Replicate transformation", zap.Error(err))
+		return nil, fmt.Errorf("marshal Replicate request: %w", err)
+	}
+	logger.Debug("Transformed request to Replicate style", zap.ByteString("transformed_body", transformedBody))
+	return transformedBody, nil
+}
+
+// TransformResponseFromReplicate converts a terminal Replicate prediction into a unified response.
+func TransformResponseFromReplicate(respBody []byte, modelName string, logger *zap.Logger) (*UnifiedChatResponse, error) {
+	var prediction ReplicatePrediction
+	if err := json.Unmarshal(respBody, &prediction); err != nil {
+		logger.Error("Failed to unmarshal replicate prediction", zap.Error(err), zap.ByteString("body", respBody))
+		return nil, fmt.Errorf("unmarshal replicate prediction: %w", err)
+	}
+
+	var content string
+	switch output := prediction.Output.(type) {
+	case string:
+		content = output
+	case []any:
+		var sb strings.Builder
+		for _, part := range output {
+			if s, ok := part.(string); ok {
+				sb.WriteString(s)
+			}
+		}
+		content = sb.String()
+	}
+
+	unifiedResp := &UnifiedChatResponse{
+		ID:      prediction.ID,
+		Object:  "chat.completion",
+		Created: common.CaddyClock.Now().Unix(),
+		Model:   modelName,
+		Choices: []UnifiedChoice{{
+			Index: 0,
+			Message: UnifiedChatMessage{
+				Role:    "assistant",
+				Content: content,
+			},
+			FinishReason: FinishReasonStop,
+		}},
+	}
+	if prediction.Metrics != nil {
+		unifiedResp.Usage = &UnifiedUsage{
+			PromptTokens:     prediction.Metrics.InputTokenCount,
+			CompletionTokens: prediction.Metrics.OutputTokenCount,
+			TotalTokens:      prediction.Metrics.InputTokenCount + prediction.Metrics.OutputTokenCount,
+		}
+	}
+	return unifiedResp, nil
+}
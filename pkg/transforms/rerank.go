@@ -0,0 +1,142 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// UnifiedRerankRequest defines a Cohere/Jina-style rerank request. Documents may be plain
+// strings or objects with a "text" field.
+type UnifiedRerankRequest struct {
+	Model           string            `json:"model"`
+	Query           string            `json:"query"`
+	Documents       []json.RawMessage `json:"documents"`
+	TopN            *int              `json:"top_n,omitempty"`
+	ReturnDocuments bool              `json:"return_documents,omitempty"`
+}
+
+// UnifiedRerankResult is the relevance of one document, by its index in the request.
+type UnifiedRerankResult struct {
+	Index          int             `json:"index"`
+	RelevanceScore float64         `json:"relevance_score"`
+	Document       json.RawMessage `json:"document,omitempty"`
+}
+
+// UnifiedRerankUsage reports what a rerank call consumed, in the units the provider bills.
+type UnifiedRerankUsage struct {
+	TotalTokens int `json:"total_tokens,omitempty"`
+	SearchUnits int `json:"search_units,omitempty"`
+}
+
+// UnifiedRerankResponse defines a rerank response, results ordered by decreasing relevance.
+type UnifiedRerankResponse struct {
+	ID      string                `json:"id,omitempty"`
+	Model   string                `json:"model,omitempty"`
+	Results []UnifiedRerankResult `json:"results"`
+	Usage   *UnifiedRerankUsage   `json:"usage,omitempty"`
+}
+
+// RerankDocumentText returns the text of a string or {"text": ...} document.
+func RerankDocumentText(doc json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(doc, &text); err == nil {
+		return text
+	}
+	var obj struct {
+		Text string `json:"text"`
+	}
+	_ = json.Unmarshal(doc, &obj)
+	return obj.Text
+}
+
+// TransformRerankResponse normalizes a Cohere- or Jina-style rerank response. Cohere reports
+// usage as billed search units, Jina (and most OpenAI-compatible servers) as tokens.
+func TransformRerankResponse(body []byte) ([]byte, error) {
+	var upstream struct {
+		UnifiedRerankResponse
+		Meta *struct {
+			BilledUnits struct {
+				SearchUnits int `json:"search_units"`
+			} `json:"billed_units"`
+		} `json:"meta,omitempty"`
+	}
+	if err := json.Unmarshal(body, &upstream); err != nil {
+		return nil, fmt.Errorf("unmarshal rerank response: %w", err)
+	}
+	resp := upstream.UnifiedRerankResponse
+	if upstream.Meta != nil && upstream.Meta.BilledUnits.SearchUnits > 0 {
+		if resp.Usage == nil {
+			resp.Usage = &UnifiedRerankUsage{}
+		}
+		resp.Usage.SearchUnits = upstream.Meta.BilledUnits.SearchUnits
+	}
+	if resp.Results == nil {
+		resp.Results = []UnifiedRerankResult{}
+	}
+	return json.Marshal(resp)
+}
+
+// TransformRerankRequestToCohere converts a rerank request for Cohere's v2 API, which only
+// takes plain-text documents and never echoes them back.
+func TransformRerankRequestToCohere(body []byte, modelName string, logger *zap.Logger) ([]byte, error) {
+	var rerankReq UnifiedRerankRequest
+	if err := json.Unmarshal(body, &rerankReq); err != nil {
+		return nil, fmt.Errorf("unmarshal rerank request: %w", err)
+	}
+	documents := make([]string, 0, len(rerankReq.Documents))
+	for _, doc := range rerankReq.Documents {
+		documents = append(documents, RerankDocumentText(doc))
+	}
+	cohereReq := map[string]any{"model": modelName, "query": rerankReq.Query, "documents": documents}
+	if rerankReq.TopN != nil {
+		cohereReq["top_n"] = *rerankReq.TopN
+	}
+	if rerankReq.ReturnDocuments {
+		logger.Debug("Cohere v2 rerank doesn't return documents, ignoring return_documents")
+	}
+	return json.Marshal(cohereReq)
+}
+
+// TransformRerankRequestToCloudflare converts a rerank request for Workers AI reranker models.
+func TransformRerankRequestToCloudflare(body []byte, logger *zap.Logger) ([]byte, error) {
+	var rerankReq UnifiedRerankRequest
+	if err := json.Unmarshal(body, &rerankReq); err != nil {
+		return nil, fmt.Errorf("unmarshal rerank request: %w", err)
+	}
+	contexts := make([]map[string]string, 0, len(rerankReq.Documents))
+	for _, doc := range rerankReq.Documents {
+		contexts = append(contexts, map[string]string{"text": RerankDocumentText(doc)})
+	}
+	cfReq := map[string]any{"query": rerankReq.Query, "contexts": contexts}
+	if rerankReq.TopN != nil {
+		cfReq["top_k"] = *rerankReq.TopN
+	}
+	if rerankReq.ReturnDocuments {
+		logger.Debug("Workers AI rerankers don't return documents, ignoring return_documents")
+	}
+	return json.Marshal(cfReq)
+}
+
+// TransformCloudflareRerankResponse converts a Workers AI reranker response into the unified format.
+func TransformCloudflareRerankResponse(body []byte, model string) ([]byte, error) {
+	var cfResp struct {
+		Result struct {
+			Response []struct {
+				ID    int     `json:"id"`
+				Score float64 `json:"score"`
+			} `json:"response"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &cfResp); err != nil {
+		return nil, fmt.Errorf("unmarshal Workers AI rerank response: %w", err)
+	}
+	results := make([]UnifiedRerankResult, 0, len(cfResp.Result.Response))
+	for _, r := range cfResp.Result.Response {
+		results = append(results, UnifiedRerankResult{Index: r.ID, RelevanceScore: r.Score})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].RelevanceScore > results[j].RelevanceScore })
+	return json.Marshal(UnifiedRerankResponse{Model: model, Results: results})
+}
@@ -0,0 +1,469 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// --- OpenAI Responses API ingress (clients using /v1/responses) ---
+
+// ResponsesIngressRequest defines an incoming Responses API request.
+// Input may be a plain string or an array of input items.
+type ResponsesIngressRequest struct {
+	Model           string          `json:"model"`
+	Input           json.RawMessage `json:"input"`
+	Instructions    string          `json:"instructions,omitempty"`
+	Stream          bool            `json:"stream,omitempty"`
+	Tools           []ResponsesTool `json:"tools,omitempty"`
+	ToolChoice      json.RawMessage `json:"tool_choice,omitempty"`
+	Temperature     *float64        `json:"temperature,omitempty"`
+	TopP            *float64        `json:"top_p,omitempty"`
+	MaxOutputTokens *int            `json:"max_output_tokens,omitempty"`
+	User            string          `json:"user,omitempty"`
+	Text            *struct {
+		Format json.RawMessage `json:"format,omitempty"`
+	} `json:"text,omitempty"`
+	Reasoning *struct {
+		Effort string `json:"effort,omitempty"`
+	} `json:"reasoning,omitempty"`
+	PreviousResponseID string `json:"previous_response_id,omitempty"`
+}
+
+// ResponsesTool defines a tool of a Responses API request. Only function tools can be translated.
+type ResponsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// responsesInputItem covers the input item types that map onto chat messages.
+type responsesInputItem struct {
+	Type      string          `json:"type,omitempty"`
+	Role      string          `json:"role,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	CallID    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    json.RawMessage `json:"output,omitempty"`
+}
+
+// responsesText flattens a string or an array of input/output text parts into plain text.
+func responsesText(raw json.RawMessage, logger *zap.Logger) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return ""
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "input_text" && part.Type != "output_text" && part.Type != "text" {
+			logger.Debug("Dropping unsupported Responses content part", zap.String("type", part.Type))
+			continue
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// TransformResponsesRequestToUnified converts a Responses API request into the unified (chat completions) format.
+func TransformResponsesRequestToUnified(body []byte, logger *zap.Logger) ([]byte, error) {
+	var responsesReq ResponsesIngressRequest
+	if err := json.Unmarshal(body, &responsesReq); err != nil {
+		return nil, fmt.Errorf("unmarshal Responses request: %w", err)
+	}
+	if responsesReq.PreviousResponseID != "" {
+		logger.Debug("Ignoring previous_response_id for translated Responses request", zap.String("previous_response_id", responsesReq.PreviousResponseID))
+	}
+
+	messages := []map[string]any{}
+	if responsesReq.Instructions != "" {
+		messages = append(messages, map[string]any{"role": "system", "content": responsesReq.Instructions})
+	}
+
+	var input string
+	if err := json.Unmarshal(responsesReq.Input, &input); err == nil {
+		messages = append(messages, map[string]any{"role": "user", "content": input})
+	} else if len(responsesReq.Input) > 0 {
+		var items []responsesInputItem
+		if err := json.Unmarshal(responsesReq.Input, &items); err != nil {
+			return nil, fmt.Errorf("unmarshal Responses input: %w", err)
+		}
+		for _, item := range items {
+			switch item.Type {
+			case "", "message":
+				messages = append(messages, map[string]any{"role": item.Role, "content": responsesText(item.Content, logger)})
+			case "function_call":
+				messages = append(messages, map[string]any{
+					"role":    "assistant",
+					"content": nil,
+					"tool_calls": []map[string]any{{
+						"id":       item.CallID,
+						"type":     "function",
+						"function": map[string]any{"name": item.Name, "arguments": item.Arguments},
+					}},
+				})
+			case "function_call_output":
+				messages = append(messages, map[string]any{
+					"role":         "tool",
+					"tool_call_id": item.CallID,
+					"content":      responsesText(item.Output, logger),
+				})
+			default:
+				logger.Debug("Dropping unsupported Responses input item", zap.String("type", item.Type))
+			}
+		}
+	}
+
+	unifiedReq := map[string]any{
+		"model":    responsesReq.Model,
+		"messages": messages,
+		"stream":   responsesReq.Stream,
+	}
+
+	tools := make([]map[string]any, 0, len(responsesReq.Tools))
+	for _, tool := range responsesReq.Tools {
+		if tool.Type != "function" {
+			logger.Debug("Dropping unsupported Responses tool", zap.String("type", tool.Type))
+			continue
+		}
+		function := map[string]any{"name": tool.Name}
+		if tool.Description != "" {
+			function["description"] = tool.Description
+		}
+		if len(tool.Parameters) > 0 {
+			function["parameters"] = tool.Parameters
+		}
+		if tool.Strict != nil {
+			function["strict"] = *tool.Strict
+		}
+		tools = append(tools, map[string]any{"type": "function", "function": function})
+	}
+	if len(tools) > 0 {
+		unifiedReq["tools"] = tools
+	}
+	if len(responsesReq.ToolChoice) > 0 {
+		var choice struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(responsesReq.ToolChoice, &choice); err == nil && choice.Type == "function" {
+			unifiedReq["tool_choice"] = map[string]any{"type": "function", "function": map[string]any{"name": choice.Name}}
+		} else {
+			unifiedReq["tool_choice"] = responsesReq.ToolChoice
+		}
+	}
+
+	if responsesReq.Temperature != nil {
+		unifiedReq["temperature"] = *responsesReq.Temperature
+	}
+	if responsesReq.TopP != nil {
+		unifiedReq["top_p"] = *responsesReq.TopP
+	}
+	if responsesReq.MaxOutputTokens != nil {
+		unifiedReq["max_tokens"] = *responsesReq.MaxOutputTokens
+	}
+	if responsesReq.User != "" {
+		unifiedReq["user"] = responsesReq.User
+	}
+	if responsesReq.Reasoning != nil && responsesReq.Reasoning.Effort != "" {
+		unifiedReq["reasoning_effort"] = responsesReq.Reasoning.Effort
+	}
+	if responsesReq.Text != nil && len(responsesReq.Text.Format) > 0 {
+		var format struct {
+			Type   string          `json:"type"`
+			Name   string          `json:"name"`
+			Schema json.RawMessage `json:"schema"`
+			Strict *bool           `json:"strict"`
+		}
+		if err := json.Unmarshal(responsesReq.Text.Format, &format); err == nil {
+			switch format.Type {
+			case "json_schema":
+				jsonSchema := map[string]any{"name": format.Name, "schema": format.Schema}
+				if format.Strict != nil {
+					jsonSchema["strict"] = *format.Strict
+				}
+				unifiedReq["response_format"] = map[string]any{"type": "json_schema", "json_schema": jsonSchema}
+			case "json_object":
+				unifiedReq["response_format"] = map[string]any{"type": "json_object"}
+			}
+		}
+	}
+
+	return json.Marshal(unifiedReq)
+}
+
+// responsesToolCall is a tool call of a unified chat completion message or delta.
+type responsesToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// responsesChatResponse is a unified chat completion including tool calls.
+type responsesChatResponse struct {
+	ID      string `json:"id"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content   string              `json:"content"`
+			ToolCalls []responsesToolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *UnifiedUsage `json:"usage,omitempty"`
+}
+
+func responsesUsage(usage *UnifiedUsage) map[string]any {
+	if usage == nil {
+		return nil
+	}
+	return map[string]any{
+		"input_tokens":  usage.PromptTokens,
+		"output_tokens": usage.CompletionTokens,
+		"total_tokens":  usage.TotalTokens,
+	}
+}
+
+func responsesMessageItem(id string, text string) map[string]any {
+	return map[string]any{
+		"type":    "message",
+		"id":      id,
+		"status":  "completed",
+		"role":    "assistant",
+		"content": []map[string]any{{"type": "output_text", "text": text, "annotations": []any{}}},
+	}
+}
+
+func responsesFunctionCallItem(call responsesToolCall) map[string]any {
+	return map[string]any{
+		"type":      "function_call",
+		"id":        "fc_" + call.ID,
+		"call_id":   call.ID,
+		"name":      call.Function.Name,
+		"arguments": call.Function.Arguments,
+		"status":    "completed",
+	}
+}
+
+// responsesObject assembles a Responses API response object.
+func responsesObject(id string, createdAt int64, model string, status string, finishReason string, output []map[string]any, usage *UnifiedUsage) map[string]any {
+	obj := map[string]any{
+		"id":                 "resp_" + id,
+		"object":             "response",
+		"created_at":         createdAt,
+		"status":             status,
+		"model":              model,
+		"output":             output,
+		"usage":              responsesUsage(usage),
+		"incomplete_details": nil,
+	}
+	if finishReason == "length" {
+		obj["status"] = "incomplete"
+		obj["incomplete_details"] = map[string]any{"reason": "max_output_tokens"}
+	} else if finishReason == "content_filter" {
+		obj["status"] = "incomplete"
+		obj["incomplete_details"] = map[string]any{"reason": "content_filter"}
+	}
+	return obj
+}
+
+// TransformUnifiedResponseToResponses converts a unified chat completion into a Responses API response.
+func TransformUnifiedResponseToResponses(body []byte, logger *zap.Logger) ([]byte, error) {
+	var chatResp responsesChatResponse
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		logger.Error("Failed to unmarshal unified response for Responses ingress", zap.Error(err))
+		return nil, fmt.Errorf("unmarshal unified response: %w", err)
+	}
+
+	output := []map[string]any{}
+	finishReason := ""
+	if len(chatResp.Choices) > 0 {
+		choice := chatResp.Choices[0]
+		finishReason = choice.FinishReason
+		if choice.Message.Content != "" {
+			output = append(output, responsesMessageItem("msg_"+chatResp.ID, choice.Message.Content))
+		}
+		for _, call := range choice.Message.ToolCalls {
+			output = append(output, responsesFunctionCallItem(call))
+		}
+	}
+	createdAt := chatResp.Created
+	if createdAt == 0 {
+		createdAt = time.Now().Unix()
+	}
+	return json.Marshal(responsesObject(chatResp.ID, createdAt, chatResp.Model, "completed", finishReason, output, chatResp.Usage))
+}
+
+// ResponsesStreamEncoder converts unified SSE chunks into Responses API streaming events.
+// Text is streamed as output_text deltas; tool calls are accumulated and emitted when the stream ends.
+type ResponsesStreamEncoder struct {
+	started      bool
+	textStarted  bool
+	sequence     int
+	id           string
+	model        string
+	createdAt    int64
+	text         strings.Builder
+	toolCalls    []responsesToolCall
+	finishReason string
+	usage        *UnifiedUsage
+}
+
+func (e *ResponsesStreamEncoder) event(eventType string, payload map[string]any) []byte {
+	payload["type"] = eventType
+	payload["sequence_number"] = e.sequence
+	e.sequence++
+	data, _ := json.Marshal(payload)
+	return []byte("event: " + eventType + "\ndata: " + string(data) + "\n\n")
+}
+
+func (e *ResponsesStreamEncoder) messageID() string {
+	return "msg_" + e.id
+}
+
+// Encode converts one unified chunk into zero or more Responses events.
+func (e *ResponsesStreamEncoder) Encode(data []byte) ([]byte, error) {
+	var chunk struct {
+		ID      string `json:"id"`
+		Created int64  `json:"created"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Index int `json:"index"`
+			Delta struct {
+				Content   string              `json:"content"`
+				ToolCalls []responsesToolCall `json:"tool_calls"`
+			} `json:"delta"`
+			FinishReason *string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *UnifiedUsage `json:"usage,omitempty"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if !e.started {
+		e.started = true
+		e.id = chunk.ID
+		e.model = chunk.Model
+		e.createdAt = chunk.Created
+		if e.createdAt == 0 {
+			e.createdAt = time.Now().Unix()
+		}
+		response := responsesObject(e.id, e.createdAt, e.model, "in_progress", "", []map[string]any{}, nil)
+		out = append(out, e.event("response.created", map[string]any{"response": response})...)
+		out = append(out, e.event("response.in_progress", map[string]any{"response": response})...)
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.Content != "" {
+			if !e.textStarted {
+				e.textStarted = true
+				out = append(out, e.event("response.output_item.added", map[string]any{
+					"output_index": 0,
+					"item": map[string]any{
+						"type": "message", "id": e.messageID(), "status": "in_progress", "role": "assistant", "content": []any{},
+					},
+				})...)
+				out = append(out, e.event("response.content_part.added", map[string]any{
+					"item_id":       e.messageID(),
+					"output_index":  0,
+					"content_index": 0,
+					"part":          map[string]any{"type": "output_text", "text": "", "annotations": []any{}},
+				})...)
+			}
+			e.text.WriteString(choice.Delta.Content)
+			out = append(out, e.event("response.output_text.delta", map[string]any{
+				"item_id":       e.messageID(),
+				"output_index":  0,
+				"content_index": 0,
+				"delta":         choice.Delta.Content,
+			})...)
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			for len(e.toolCalls) <= call.Index {
+				e.toolCalls = append(e.toolCalls, responsesToolCall{Index: len(e.toolCalls)})
+			}
+			acc := &e.toolCalls[call.Index]
+			if call.ID != "" {
+				acc.ID = call.ID
+			}
+			if call.Function.Name != "" {
+				acc.Function.Name = call.Function.Name
+			}
+			acc.Function.Arguments += call.Function.Arguments
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			e.finishReason = *choice.FinishReason
+		}
+	}
+	if chunk.Usage != nil {
+		e.usage = chunk.Usage
+	}
+	return out, nil
+}
+
+// Finish emits the closing events of the Responses stream.
+func (e *ResponsesStreamEncoder) Finish() []byte {
+	var out []byte
+	if !e.started {
+		return out
+	}
+
+	output := []map[string]any{}
+	if e.textStarted {
+		text := e.text.String()
+		item := responsesMessageItem(e.messageID(), text)
+		out = append(out, e.event("response.output_text.done", map[string]any{
+			"item_id": e.messageID(), "output_index": 0, "content_index": 0, "text": text,
+		})...)
+		out = append(out, e.event("response.content_part.done", map[string]any{
+			"item_id":       e.messageID(),
+			"output_index":  0,
+			"content_index": 0,
+			"part":          map[string]any{"type": "output_text", "text": text, "annotations": []any{}},
+		})...)
+		out = append(out, e.event("response.output_item.done", map[string]any{"output_index": 0, "item": item})...)
+		output = append(output, item)
+	}
+	for _, call := range e.toolCalls {
+		item := responsesFunctionCallItem(call)
+		outputIndex := len(output)
+		out = append(out, e.event("response.output_item.added", map[string]any{"output_index": outputIndex, "item": item})...)
+		out = append(out, e.event("response.function_call_arguments.done", map[string]any{
+			"item_id": item["id"], "output_index": outputIndex, "arguments": call.Function.Arguments,
+		})...)
+		out = append(out, e.event("response.output_item.done", map[string]any{"output_index": outputIndex, "item": item})...)
+		output = append(output, item)
+	}
+
+	response := responsesObject(e.id, e.createdAt, e.model, "completed", e.finishReason, output, e.usage)
+	eventType := "response.completed"
+	if response["status"] == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	out = append(out, e.event(eventType, map[string]any{"response": response})...)
+	return out
+}
@@ -0,0 +1,81 @@
+package transforms
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// UnifiedDelta defines the incremental message content of a streamed chunk.
+type UnifiedDelta struct {
+	Role             string `json:"role,omitempty"`
+	Content          string `json:"content,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+// UnifiedChunkChoice defines a single choice in a streamed chat completion chunk.
+type UnifiedChunkChoice struct {
+	Index        int          `json:"index"`
+	Delta        UnifiedDelta `json:"delta"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+// UnifiedChatChunk defines the structure for a streamed chat completion chunk.
+type UnifiedChatChunk struct {
+	ID      string               `json:"id"`
+	Object  string               `json:"object"` // "chat.completion.chunk"
+	Created int64                `json:"created"`
+	Model   string               `json:"model"`
+	Choices []UnifiedChunkChoice `json:"choices"`
+	Usage   *UnifiedUsage        `json:"usage,omitempty"`
+}
+
+// SynthesizeUnifiedStream renders a complete unified response as an SSE stream,
+// for upstreams that can only produce a final result but were asked to stream.
+func SynthesizeUnifiedStream(resp UnifiedChatResponse) ([]byte, error) {
+	var out []byte
+	for _, choice := range resp.Choices {
+		finishReason := choice.FinishReason
+		if finishReason == "" {
+			finishReason = FinishReasonStop
+		}
+		chunk := UnifiedChatChunk{
+			ID:      resp.ID,
+			Object:  "chat.completion.chunk",
+			Created: resp.Created,
+			Model:   resp.Model,
+			Choices: []UnifiedChunkChoice{{
+				Index:        choice.Index,
+				Delta:        UnifiedDelta{Role: "assistant", Content: choice.Message.Content},
+				FinishReason: &finishReason,
+			}},
+		}
+		chunkBytes, err := json.Marshal(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("marshal unified chunk: %w", err)
+		}
+		out = append(out, "data: "...)
+		out = append(out, chunkBytes...)
+		out = append(out, "\n\n"...)
+	}
+
+	if resp.Usage != nil {
+		usageChunk := UnifiedChatChunk{
+			ID:      resp.ID,
+			Object:  "chat.completion.chunk",
+			Created: resp.Created,
+			Model:   resp.Model,
+			Choices: []UnifiedChunkChoice{},
+			Usage:   resp.Usage,
+		}
+		chunkBytes, err := json.Marshal(usageChunk)
+		if err != nil {
+			return nil, fmt.Errorf("marshal unified usage chunk: %w", err)
+		}
+		out = append(out, "data: "...)
+		out = append(out, chunkBytes...)
+		out = append(out, "\n\n"...)
+	}
+
+	out = append(out, "data: [DONE]\n\n"...)
+	return out, nil
+}
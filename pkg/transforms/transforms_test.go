@@ -0,0 +1,99 @@
+package transforms
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const benchChatRequest = `{
+	"model": "gpt-4o",
+	"messages": [
+		{"role": "system", "content": "You are a helpful assistant."},
+		{"role": "user", "content": "What is the capital of France?"},
+		{"role": "assistant", "content": "Paris."},
+		{"role": "user", "content": "And of Italy?"}
+	],
+	"temperature": 0.7,
+	"max_tokens": 256,
+	"stream": false
+}`
+
+const benchAnthropicResponse = `{
+	"id": "msg_01",
+	"type": "message",
+	"role": "assistant",
+	"model": "claude-3-5-sonnet-latest",
+	"content": [{"type": "text", "text": "Rome."}],
+	"stop_reason": "end_turn",
+	"usage": {"input_tokens": 32, "output_tokens": 3}
+}`
+
+const benchGoogleResponse = `{
+	"candidates": [{"content": {"role": "model", "parts": [{"text": "Rome."}]}, "finishReason": "STOP", "index": 0}],
+	"usageMetadata": {"promptTokenCount": 32, "candidatesTokenCount": 3, "totalTokenCount": 35}
+}`
+
+const benchGoogleStreamChunk = `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Ro"}]}, "index": 0}], "responseId": "resp_01"}`
+
+func benchmarkRequestTransform(b *testing.B, transform func([]byte) ([]byte, error)) {
+	body := []byte(benchChatRequest)
+	if _, err := transform(body); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := transform(body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkResponseTransform(b *testing.B, body string, transform func([]byte, *zap.Logger) ([]byte, error)) {
+	logger := zap.NewNop()
+	if _, err := transform([]byte(body), logger); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := transform([]byte(body), logger); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTransformRequestToOpenAI(b *testing.B) {
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	benchmarkRequestTransform(b, func(body []byte) ([]byte, error) {
+		return TransformRequestToOpenAI(r, body, "gpt-4o", zap.NewNop())
+	})
+}
+
+func BenchmarkTransformRequestToAnthropic(b *testing.B) {
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	benchmarkRequestTransform(b, func(body []byte) ([]byte, error) {
+		return TransformRequestToAnthropic(r, body, "claude-3-5-sonnet-latest", zap.NewNop())
+	})
+}
+
+func BenchmarkTransformRequestToGoogleAI(b *testing.B) {
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	benchmarkRequestTransform(b, func(body []byte) ([]byte, error) {
+		return TransformRequestToGoogleAI(r, body, "gemini-1.5-pro", zap.NewNop())
+	})
+}
+
+func BenchmarkTransformResponseFromAnthropic(b *testing.B) {
+	benchmarkResponseTransform(b, benchAnthropicResponse, TransformResponseFromAnthropic)
+}
+
+func BenchmarkTransformResponseFromGoogleAI(b *testing.B) {
+	benchmarkResponseTransform(b, benchGoogleResponse, TransformResponseFromGoogleAI)
+}
+
+func BenchmarkTransformStreamChunkFromGoogleAI(b *testing.B) {
+	benchmarkResponseTransform(b, benchGoogleStreamChunk, TransformStreamChunkFromGoogleAI)
+}
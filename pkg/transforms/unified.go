@@ -1,23 +1,152 @@
 package transforms
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // --- Unified (OpenAI-like) Structures ---
 
 // UnifiedChatMessage defines the structure for a single message in a chat.
 type UnifiedChatMessage struct {
-	Role    string `json:"role"` // e.g., "user", "assistant", "system"
+	Role    string `json:"role"` // e.g., "user", "assistant", "system", "developer"
 	Content string `json:"content"`
+	// Reasoning of thinking models (DeepSeek's reasoning_content, Anthropic thinking blocks,
+	// Gemini thoughts); only set on responses
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+// IsSystem reports whether the message instructs the model rather than takes part in the
+// conversation: a system message, or a developer one, which newer OpenAI models take instead.
+func (m UnifiedChatMessage) IsSystem() bool {
+	return m.Role == "system" || m.Role == "developer"
 }
 
 // UnifiedChatRequest defines the structure for a chat completion request.
 type UnifiedChatRequest struct {
-	Model       string               `json:"model"`
-	Messages    []UnifiedChatMessage `json:"messages"`
-	Stream      bool                 `json:"stream,omitempty"`
-	MaxTokens   *int                 `json:"max_tokens,omitempty"` // Pointer to distinguish between not set and 0
-	Temperature *float64             `json:"temperature,omitempty"`
+	Model               string               `json:"model"`
+	Messages            []UnifiedChatMessage `json:"messages"`
+	Stream              bool                 `json:"stream,omitempty"`
+	MaxTokens           *int                 `json:"max_tokens,omitempty"`            // Pointer to distinguish between not set and 0
+	MaxCompletionTokens *int                 `json:"max_completion_tokens,omitempty"` // Includes reasoning tokens; wins over max_tokens
+	ReasoningEffort     string               `json:"reasoning_effort,omitempty"`      // "low", "medium" or "high"
+	Temperature         *float64             `json:"temperature,omitempty"`
+	TopP                *float64             `json:"top_p,omitempty"`
+	Stop                StopSequences        `json:"stop,omitempty"`
+	PresencePenalty     *float64             `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64             `json:"frequency_penalty,omitempty"`
+	Seed                *int64               `json:"seed,omitempty"`
+	Logprobs            *bool                `json:"logprobs,omitempty"`
+	User                string               `json:"user,omitempty"`
 	// Add other common fields as needed
 }
 
+// Prefill returns the content of the assistant message the conversation ends with, which the
+// model is to continue rather than answer, and whether there is one.
+func (r UnifiedChatRequest) Prefill() (string, bool) {
+	if len(r.Messages) == 0 || r.Messages[len(r.Messages)-1].Role != "assistant" {
+		return "", false
+	}
+	return r.Messages[len(r.Messages)-1].Content, true
+}
+
+// OutputTokenLimit returns the completion budget of the request: max_completion_tokens, or
+// max_tokens for clients that send the older field. Nil if neither is set.
+func (r UnifiedChatRequest) OutputTokenLimit() *int {
+	if r.MaxCompletionTokens != nil {
+		return r.MaxCompletionTokens
+	}
+	return r.MaxTokens
+}
+
+// Thinking budgets, in tokens, that reasoning_effort maps to for providers configured by budget.
+const (
+	ReasoningBudgetLow    = 1024
+	ReasoningBudgetMedium = 4096
+	ReasoningBudgetHigh   = 16384
+)
+
+// ReasoningBudget returns the thinking budget for a reasoning_effort, or 0 if it's not one of
+// low, medium or high.
+func ReasoningBudget(effort string) int {
+	switch strings.ToLower(effort) {
+	case "low", "minimal":
+		return ReasoningBudgetLow
+	case "medium":
+		return ReasoningBudgetMedium
+	case "high":
+		return ReasoningBudgetHigh
+	}
+	return 0
+}
+
+// renameMaxCompletionTokens moves max_completion_tokens to max_tokens in a request body, for
+// providers that only know the older field.
+func renameMaxCompletionTokens(bodyMap map[string]any) {
+	if maxTokens, ok := bodyMap["max_completion_tokens"]; ok {
+		bodyMap["max_tokens"] = maxTokens
+		delete(bodyMap, "max_completion_tokens")
+	}
+}
+
+// IsOpenAIReasoningModel reports whether an OpenAI model is a reasoning model (o1, o3, o4-mini,
+// gpt-5 and their variants), which take max_completion_tokens and reasoning_effort.
+func IsOpenAIReasoningModel(modelName string) bool {
+	modelName = strings.TrimPrefix(modelName, "openai/")
+	if strings.HasPrefix(modelName, "gpt-5") {
+		return true
+	}
+	return len(modelName) >= 2 && modelName[0] == 'o' && modelName[1] >= '1' && modelName[1] <= '9'
+}
+
+// StopSequences holds the stop field of a chat request, which clients send as a single
+// string or an array of strings.
+type StopSequences []string
+
+// UnmarshalJSON accepts a string or an array of strings.
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		if single == "" {
+			*s = nil
+		} else {
+			*s = StopSequences{single}
+		}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*s = many
+	return nil
+}
+
+// Finish reasons of the unified (OpenAI) vocabulary.
+const (
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonToolCalls     = "tool_calls"
+	FinishReasonContentFilter = "content_filter"
+)
+
+// NormalizeFinishReason maps a provider's finish or stop reason onto the unified vocabulary:
+// Anthropic's end_turn/max_tokens/tool_use, Google's STOP/MAX_TOKENS/SAFETY and the like.
+// Unknown reasons count as stop; an empty reason stays empty.
+func NormalizeFinishReason(reason string) string {
+	switch strings.ToLower(reason) {
+	case "":
+		return ""
+	case "length", "max_tokens", "model_context_window_exceeded":
+		return FinishReasonLength
+	case "tool_calls", "tool_use", "function_call":
+		return FinishReasonToolCalls
+	case "content_filter", "safety", "recitation", "blocklist", "prohibited_content", "spii", "image_safety", "refusal":
+		return FinishReasonContentFilter
+	}
+	return FinishReasonStop
+}
+
 // UnifiedChoice defines a single choice in a chat completion response.
 type UnifiedChoice struct {
 	Index        int                `json:"index"`
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Placeholders set on the Caddy replacer of inference requests, so log formats, header
+// manipulation and matchers of later directives can use routing outcomes.
+const (
+	PlaceholderRequestID        = "ai.request_id"
+	PlaceholderProvider         = "ai.provider"
+	PlaceholderModelRequested   = "ai.model_requested"
+	PlaceholderModelServed      = "ai.model_served"
+	PlaceholderUserID           = "ai.user_id"
+	PlaceholderTokensPrompt     = "ai.tokens_prompt"
+	PlaceholderTokensCompletion = "ai.tokens_completion"
+	PlaceholderTokensTotal      = "ai.tokens_total"
+	PlaceholderTokensEstimated  = "ai.tokens_estimated"
+	PlaceholderTags             = "ai.tags"
+	PlaceholderTagPrefix        = "ai.tag." // Followed by the tag key
+)
+
+// setPlaceholder sets an ai.* placeholder if the request has a replacer.
+func setPlaceholder(r *http.Request, name string, value any) {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		repl.Set(name, value)
+	}
+}
+
+// setRoutedPlaceholders publishes where a request was routed.
+func setRoutedPlaceholders(r *http.Request, requestedModel, provider, model, userID string) {
+	setPlaceholder(r, PlaceholderModelRequested, requestedModel)
+	setPlaceholder(r, PlaceholderProvider, provider)
+	setPlaceholder(r, PlaceholderModelServed, model)
+	setPlaceholder(r, PlaceholderUserID, userID)
+}
+
+// setServedPlaceholders updates the provider and model after a fallback or race moved the request.
+func setServedPlaceholders(r *http.Request, provider, model string) {
+	setPlaceholder(r, PlaceholderProvider, provider)
+	setPlaceholder(r, PlaceholderModelServed, model)
+}
+
+// setUsagePlaceholders publishes the token usage of a finished request.
+func setUsagePlaceholders(r *http.Request, tracker *usageTracker) {
+	if tracker == nil {
+		return
+	}
+	promptTokens, completionTokens, estimated := tracker.snapshot()
+	setPlaceholder(r, PlaceholderTokensPrompt, promptTokens)
+	setPlaceholder(r, PlaceholderTokensCompletion, completionTokens)
+	setPlaceholder(r, PlaceholderTokensTotal, promptTokens+completionTokens)
+	setPlaceholder(r, PlaceholderTokensEstimated, estimated)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+)
+
+// Prefill modes of a provider.
+const (
+	PrefillNative  = "native"
+	PrefillEmulate = "emulate"
+)
+
+// prefillInstruction asks a model that doesn't continue assistant messages to continue one.
+const prefillInstruction = "Continue the following response from exactly where it stops. Reply with the continuation only, without repeating any of it:\n\n"
+
+// emulatesPrefill reports whether a conversation ending in an assistant message is turned into
+// an instruction for the provider, rather than sent as it is.
+func (p *ProviderConfig) emulatesPrefill() bool {
+	switch p.Prefill {
+	case PrefillNative:
+		return false
+	case PrefillEmulate:
+		return true
+	}
+	pp, ok := p.Provider.(providers.PrefillProvider)
+	return !ok || !pp.SupportsPrefill()
+}
+
+// emulatePrefill rewrites a unified chat request ending in an assistant message, for providers
+// that would reject it or answer it instead of continuing it: the message is moved into the
+// last user turn, with an instruction to continue it.
+func (p *ProviderConfig) emulatePrefill(r *http.Request) {
+	if !p.emulatesPrefill() || r.Body == nil {
+		return
+	}
+	if passthrough, ok := r.Context().Value(common.ResponsesPassthroughContextKeyString).(*common.ResponsesPassthrough); ok && passthrough != nil && passthrough.Native {
+		return
+	}
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var req map[string]any
+		if decoder.Decode(&req) != nil || req == nil {
+			return body, nil
+		}
+		messages, _ := req["messages"].([]any)
+		if len(messages) < 2 {
+			return body, nil // A lone assistant message has nothing to continue from
+		}
+		last, ok := messages[len(messages)-1].(map[string]any)
+		if !ok || last["role"] != "assistant" || last["tool_calls"] != nil {
+			return body, nil
+		}
+		prefill, ok := last["content"].(string)
+		if !ok || prefill == "" {
+			return body, nil
+		}
+		messages = messages[:len(messages)-1]
+		instruction := prefillInstruction + prefill
+		if user, ok := messages[len(messages)-1].(map[string]any); ok && user["role"] == "user" {
+			if content, ok := user["content"].(string); ok {
+				user["content"] = joinPromptParts(content, instruction)
+			} else if parts, ok := user["content"].([]any); ok {
+				user["content"] = append(parts, map[string]any{"type": "text", "text": instruction})
+			} else {
+				messages = append(messages, map[string]any{"role": "user", "content": instruction})
+			}
+		} else {
+			messages = append(messages, map[string]any{"role": "user", "content": instruction})
+		}
+		req["messages"] = messages
+		return json.Marshal(req)
+	})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// PriorityContextKeyString is the context key an authentication layer stores a request's
+// priority class ("high", "normal" or "low") under, e.g. from an attribute of the client's key.
+const PriorityContextKeyString string = "ai_priority"
+
+// DefaultPriorityHeader is the header priority_header reads when no name is configured.
+const DefaultPriorityHeader = "X-AI-Priority"
+
+// Priority classes, in increasing order. Under load, waiting requests of a higher class get
+// provider slots first and shed the lower classes from full queues.
+const (
+	PriorityLow = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// parsePriority maps a priority class name to its level.
+func parsePriority(name string) (int, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "high":
+		return PriorityHigh, true
+	case "normal":
+		return PriorityNormal, true
+	case "low":
+		return PriorityLow, true
+	}
+	return 0, false
+}
+
+// requestPriority returns the priority of a request: the class set by the authentication
+// layer, else the one sent in the priority header when the router trusts it, else normal.
+func (cr *AICoreRouter) requestPriority(r *http.Request) int {
+	if name, ok := r.Context().Value(PriorityContextKeyString).(string); ok {
+		if priority, ok := parsePriority(name); ok {
+			return priority
+		}
+	}
+	if cr.PriorityHeader != "" {
+		if priority, ok := parsePriority(r.Header.Get(cr.PriorityHeader)); ok {
+			return priority
+		}
+	}
+	return PriorityNormal
+}
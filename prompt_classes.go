@@ -0,0 +1,412 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"go.uber.org/zap"
+)
+
+// PromptClassHeader tells the client which task class its prompt was routed as.
+const PromptClassHeader = "X-AI-Prompt-Class"
+
+// Task classes with built-in detection.
+const (
+	PromptClassCode       = "code"
+	PromptClassChat       = "chat"
+	PromptClassExtraction = "extraction"
+	PromptClassLongDoc    = "long_doc"
+)
+
+const (
+	defaultClassModel         = "auto"
+	defaultLongDocTokens      = 8000
+	defaultClassMinSimilarity = 0.3
+	classifiedTextLimit       = 8000 // Characters of the prompt that are embedded
+)
+
+// builtinClassKeywords are matched, case-insensitively, against the last user message.
+var builtinClassKeywords = map[string][]string{
+	PromptClassCode: {"```", "function", "code", "bug", "compile", "refactor", "stack trace", "traceback", "exception",
+		"python", "javascript", "typescript", "golang", "rust", "java", "sql", "regex", "script", "unit test"},
+	PromptClassExtraction: {"extract", "parse", "fields", "json", "csv", "table", "entities", "structured", "fill in", "classify"},
+}
+
+// ClassRouting routes requests for a virtual model (like "auto") to a model picked by the task
+// class of the prompt. Prompts over long_doc_tokens are long_doc; otherwise the class comes from
+// the most similar examples when an embedding model is configured, else from keyword hits, and
+// falls back to chat. Classes without a target model are never picked.
+type ClassRouting struct {
+	// Glob patterns of the requested models this applies to (defaults to "auto")
+	Models []string `json:"models,omitempty"`
+	// Model, as "provider/model" or any name routing resolves, per class
+	Targets map[string]string `json:"targets"`
+	// Model for prompts no targeted class fits; without it they keep the requested model
+	Default string `json:"default,omitempty"`
+	// Keywords and embedding examples per class, on top of the built-in keywords
+	Keywords map[string][]string `json:"keywords,omitempty"`
+	Examples map[string][]string `json:"examples,omitempty"`
+	// Estimated prompt tokens from which a prompt is long_doc (defaults to 8000)
+	LongDocTokens int `json:"long_doc_tokens,omitempty"`
+	// OpenAI-compatible embeddings used to compare prompts with the examples
+	Embedding *ClassEmbedding `json:"embedding,omitempty"`
+
+	models  []*regexp.Regexp
+	classes []string // Targeted keyword classes in a stable order
+
+	mu       sync.Mutex
+	examples map[string][][]float64 // Embedded examples, once fetched
+}
+
+// ClassEmbedding names the embedding model used to classify prompts.
+type ClassEmbedding struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// Cosine similarity below which the examples don't decide the class (defaults to 0.3)
+	MinSimilarity float64 `json:"min_similarity,omitempty"`
+}
+
+func (c *ClassRouting) provision(cr *AICoreRouter) error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("route_by_class requires at least one class")
+	}
+	if c.LongDocTokens < 0 {
+		return fmt.Errorf("route_by_class long_doc_tokens must not be negative")
+	}
+	models := c.Models
+	if len(models) == 0 {
+		models = []string{defaultClassModel}
+	}
+	c.models = nil
+	for _, glob := range models {
+		c.models = append(c.models, globPattern(glob))
+	}
+	var custom []string
+	for class := range c.Targets {
+		switch class {
+		case PromptClassLongDoc, PromptClassCode, PromptClassExtraction, PromptClassChat:
+		default:
+			custom = append(custom, class)
+		}
+	}
+	sort.Strings(custom)
+	c.classes = nil
+	for _, class := range []string{PromptClassCode, PromptClassExtraction} {
+		if c.Targets[class] != "" {
+			c.classes = append(c.classes, class)
+		}
+	}
+	c.classes = append(c.classes, custom...)
+	if e := c.Embedding; e != nil {
+		if _, ok := cr.Providers[e.Provider]; !ok {
+			return fmt.Errorf("route_by_class embedding: provider '%s' is not configured", e.Provider)
+		}
+		if e.Model == "" {
+			return fmt.Errorf("route_by_class embedding requires a model")
+		}
+		if len(c.Examples) == 0 {
+			return fmt.Errorf("route_by_class embedding requires examples")
+		}
+	}
+	c.examples = nil
+	return nil
+}
+
+func (c *ClassRouting) longDocTokens() int {
+	if c.LongDocTokens > 0 {
+		return c.LongDocTokens
+	}
+	return defaultLongDocTokens
+}
+
+// classifyPrompt picks the class and model for a request whose requested model is routed by
+// class. It returns empty strings for other requests and for prompts nothing fits.
+func (cr *AICoreRouter) classifyPrompt(r *http.Request, requestedModel string, body []byte, apiKeyService auth.ExternalAPIKeyProvider, userID string) (class string, model string) {
+	c := cr.RouteByClass
+	if c == nil || !anyMatch(c.models, requestedModel) {
+		return "", ""
+	}
+	logger := cr.requestLogger(r.Context())
+	class = c.classify(r, body, func(texts []string) ([][]float64, error) {
+		return cr.embedTexts(r, apiKeyService, userID, texts)
+	}, logger)
+	if class == "" {
+		if c.Default == "" {
+			return "", ""
+		}
+		return "", c.Default
+	}
+	logger.Debug("Classified prompt", zap.String("requested_model", requestedModel), zap.String("class", class), zap.String("model", c.Targets[class]))
+	return class, c.Targets[class]
+}
+
+func (c *ClassRouting) classify(r *http.Request, body []byte, embed func([]string) ([][]float64, error), logger *zap.Logger) string {
+	if c.Targets[PromptClassLongDoc] != "" {
+		if features, _ := r.Context().Value(RequestFeaturesContextKeyString).(*requestFeatures); features != nil && features.promptTokens() >= c.longDocTokens() {
+			return PromptClassLongDoc
+		}
+	}
+
+	var req struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+		ResponseFormat *struct {
+			Type string `json:"type"`
+		} `json:"response_format"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	text := ""
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			text = completionText(req.Messages[i].Content)
+			break
+		}
+	}
+
+	if c.Embedding != nil && strings.TrimSpace(text) != "" {
+		class, err := c.classifyByEmbedding(text, embed)
+		if err != nil {
+			logger.Warn("Failed to classify prompt by embedding, using keywords", zap.Error(err))
+		} else if class != "" {
+			return class
+		}
+	}
+
+	if c.Targets[PromptClassExtraction] != "" && req.ResponseFormat != nil && strings.HasPrefix(req.ResponseFormat.Type, "json") {
+		return PromptClassExtraction
+	}
+	lower := strings.ToLower(text)
+	best, bestHits := "", 0
+	for _, class := range c.classes {
+		hits := 0
+		for _, keyword := range append(builtinClassKeywords[class], c.Keywords[class]...) {
+			if strings.Contains(lower, strings.ToLower(keyword)) {
+				hits++
+			}
+		}
+		if hits > bestHits {
+			best, bestHits = class, hits
+		}
+	}
+	if best != "" {
+		return best
+	}
+	if c.Targets[PromptClassChat] != "" {
+		return PromptClassChat
+	}
+	return ""
+}
+
+// classifyByEmbedding returns the targeted class with the example most similar to the text,
+// if it is similar enough. Examples are embedded on first use.
+func (c *ClassRouting) classifyByEmbedding(text string, embed func([]string) ([][]float64, error)) (string, error) {
+	examples, err := c.exampleEmbeddings(embed)
+	if err != nil {
+		return "", err
+	}
+	if len(text) > classifiedTextLimit {
+		text = text[len(text)-classifiedTextLimit:]
+	}
+	vectors, err := embed([]string{text})
+	if err != nil {
+		return "", err
+	}
+	minSimilarity := c.Embedding.MinSimilarity
+	if minSimilarity <= 0 {
+		minSimilarity = defaultClassMinSimilarity
+	}
+	best, bestSimilarity := "", minSimilarity
+	for class, vecs := range examples {
+		if c.Targets[class] == "" {
+			continue
+		}
+		for _, v := range vecs {
+			if s := cosineSimilarity(vectors[0], v); s >= bestSimilarity {
+				best, bestSimilarity = class, s
+			}
+		}
+	}
+	return best, nil
+}
+
+func (c *ClassRouting) exampleEmbeddings(embed func([]string) ([][]float64, error)) (map[string][][]float64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.examples != nil {
+		return c.examples, nil
+	}
+	var classes, texts []string
+	for class, examples := range c.Examples {
+		for _, example := range examples {
+			classes = append(classes, class)
+			texts = append(texts, example)
+		}
+	}
+	vectors, err := embed(texts)
+	if err != nil {
+		return nil, fmt.Errorf("embedding examples: %v", err)
+	}
+	embedded := make(map[string][][]float64, len(c.Examples))
+	for i, class := range classes {
+		embedded[class] = append(embedded[class], vectors[i])
+	}
+	c.examples = embedded
+	return embedded, nil
+}
+
+// embedTexts calls the classifier's embedding model, an OpenAI-compatible /embeddings endpoint.
+func (cr *AICoreRouter) embedTexts(r *http.Request, apiKeyService auth.ExternalAPIKeyProvider, userID string, texts []string) ([][]float64, error) {
+	e := cr.RouteByClass.Embedding
+	cr.mu.RLock()
+	p := cr.Providers[e.Provider]
+	cr.mu.RUnlock()
+	apiKey, err := providerAPIKey(r, apiKeyService, p, userID)
+	if err != nil {
+		return nil, err
+	}
+	payload, _ := json.Marshal(map[string]any{"model": e.Model, "input": texts})
+	ctx, cancel := context.WithTimeout(r.Context(), cr.httpClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, SingleJoiningSlash(p.APIBaseURL, "embeddings"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	// The provider's client carries its forward proxy, TLS, local address and request signing
+	client := cr.httpClient
+	if p.client != nil {
+		client = p.client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings returned status %d: %s", resp.StatusCode, upstreamErrorMessage(body))
+	}
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings returned %d vectors for %d inputs", len(result.Data), len(texts))
+	}
+	vectors := make([][]float64, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embeddings returned an invalid index %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// parseClassRoutingCaddyfile parses a `route_by_class [<model_glob>...] { <class> <model> ... }` block.
+func parseClassRoutingCaddyfile(d *caddyfile.Dispenser) (*ClassRouting, error) {
+	c := &ClassRouting{Targets: make(map[string]string)}
+	c.Models = d.RemainingArgs()
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch option := d.Val(); option {
+		case "default":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			c.Default = d.Val()
+		case "keywords", "examples":
+			args := d.RemainingArgs()
+			if len(args) < 2 {
+				return nil, d.Errf("%s expects <class> <text>...", option)
+			}
+			values := &c.Keywords
+			if option == "examples" {
+				values = &c.Examples
+			}
+			if *values == nil {
+				*values = make(map[string][]string)
+			}
+			(*values)[args[0]] = append((*values)[args[0]], args[1:]...)
+		case "long_doc_tokens":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil || n <= 0 {
+				return nil, d.Errf("invalid long_doc_tokens '%s'", d.Val())
+			}
+			c.LongDocTokens = n
+		case "embedding":
+			args := d.RemainingArgs()
+			if len(args) != 2 && len(args) != 4 {
+				return nil, d.Errf("embedding expects <provider> <model> [min_similarity <0-1>]")
+			}
+			c.Embedding = &ClassEmbedding{Provider: strings.ToLower(args[0]), Model: args[1]}
+			if len(args) == 4 {
+				similarity, err := strconv.ParseFloat(args[3], 64)
+				if args[2] != "min_similarity" || err != nil || similarity <= 0 || similarity > 1 {
+					return nil, d.Errf("embedding expects <provider> <model> [min_similarity <0-1>]")
+				}
+				c.Embedding.MinSimilarity = similarity
+			}
+		default:
+			if !d.NextArg() {
+				return nil, d.Errf("route_by_class expects <class> <model>, got '%s'", option)
+			}
+			c.Targets[option] = d.Val()
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+		}
+	}
+	if len(c.Targets) == 0 {
+		return nil, d.Err("route_by_class requires at least one class")
+	}
+	return c, nil
+}
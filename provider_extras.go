@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+)
+
+// applyProviderExtras adds a provider's configured knobs to a transformed upstream request: the
+// OpenAI organization and project headers, extra_headers, and extra_body merged into JSON bodies.
+func applyProviderExtras(r *http.Request, p *ProviderConfig) error {
+	if p.Organization != "" {
+		r.Header.Set("OpenAI-Organization", p.Organization)
+	}
+	if p.Project != "" {
+		r.Header.Set("OpenAI-Project", p.Project)
+	}
+	for name, value := range p.ExtraHeaders {
+		r.Header.Set(name, value)
+	}
+	if len(p.ExtraBody) == 0 || r.Body == nil || r.Method != http.MethodPost {
+		return nil
+	}
+	return common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var req map[string]any
+		if decoder.Decode(&req) != nil || req == nil {
+			return body, nil // Not a JSON object, e.g. a multipart upload
+		}
+		for key, raw := range p.ExtraBody {
+			var value any
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, err
+			}
+			req[key] = mergeJSONValue(req[key], value)
+		}
+		return json.Marshal(req)
+	})
+}
+
+// mergeJSONValue merges an extra_body value into the request's: objects are merged key by key,
+// anything else is replaced.
+func mergeJSONValue(existing, extra any) any {
+	existingObj, ok := existing.(map[string]any)
+	extraObj, ok2 := extra.(map[string]any)
+	if !ok || !ok2 {
+		return extra
+	}
+	for key, value := range extraObj {
+		existingObj[key] = mergeJSONValue(existingObj[key], value)
+	}
+	return existingObj
+}
+
+// parseExtraHeadersCaddyfile parses an `extra_headers { <name> <value> }` block.
+func parseExtraHeadersCaddyfile(d *caddyfile.Dispenser) (map[string]string, error) {
+	extra := make(map[string]string)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		name := http.CanonicalHeaderKey(d.Val())
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		extra[name] = d.Val()
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+	}
+	return extra, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// UpstreamRequestIDHeader carries the router request ID in place of the provider's own request
+// ID when providers are hidden; OpenAI SDKs read it from this header.
+const UpstreamRequestIDHeader = "X-Request-Id"
+
+// neutralResponseHeaders are upstream response headers that don't identify the provider.
+var neutralResponseHeaders = map[string]bool{
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Cache-Control":     true,
+	"Retry-After":       true,
+}
+
+// ProviderHiding keeps clients from telling which provider served them. Upstream response
+// headers other than a few neutral ones (request IDs, rate limits, CF-Ray, Server, cookies and
+// any vendor-specific header) are dropped, the provider's request ID is replaced by the router's,
+// and router headers and errors name models only. Off unless configured.
+type ProviderHiding struct {
+	// Upstream response headers passed through anyway
+	Keep []string `json:"keep,omitempty"`
+}
+
+func (h *ProviderHiding) enabled() bool {
+	return h != nil
+}
+
+// apply strips identifying headers from an upstream response.
+func (h *ProviderHiding) apply(resp *http.Response) {
+	if !h.enabled() {
+		return
+	}
+	for name := range resp.Header {
+		if !neutralResponseHeaders[name] && !h.keeps(name) {
+			resp.Header.Del(name)
+		}
+	}
+	if id := requestID(resp.Request.Context()); id != "" {
+		resp.Header.Set(UpstreamRequestIDHeader, id)
+	}
+}
+
+func (h *ProviderHiding) keeps(name string) bool {
+	for _, keep := range h.Keep {
+		if strings.EqualFold(keep, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// servedBy labels the provider and model that served a request for client-facing headers.
+func (h *ProviderHiding) servedBy(provider string, model string) string {
+	if h.enabled() {
+		return model
+	}
+	return provider + "/" + model
+}
+
+// parseProviderHidingCaddyfile parses a `hide_provider { keep <header>... }` block.
+func parseProviderHidingCaddyfile(d *caddyfile.Dispenser) (*ProviderHiding, error) {
+	h := &ProviderHiding{}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "keep":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			h.Keep = append(h.Keep, args...)
+		default:
+			return nil, d.Errf("unrecognized hide_provider option '%s'", d.Val())
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultProviderHeader is the header provider_header reads when no name is configured.
+const DefaultProviderHeader = "X-AI-Provider"
+
+// pinnedProviderContextKeyString is the context key the provider named in a chat request's
+// body is kept under once the field has been stripped.
+const pinnedProviderContextKeyString string = "ai_pinned_provider"
+
+// withPinnedProviderField strips a `provider` string from a chat request body and keeps it in
+// the request context. Object values (OpenRouter's provider preferences) are left alone, as is
+// every body when the router doesn't allow pinning.
+func (cr *AICoreRouter) withPinnedProviderField(ctx context.Context, body []byte) (context.Context, []byte) {
+	if cr.ProviderHeader == "" {
+		return ctx, body
+	}
+	var bodyMap map[string]json.RawMessage
+	if err := json.Unmarshal(body, &bodyMap); err != nil {
+		return ctx, body
+	}
+	var name string
+	if err := json.Unmarshal(bodyMap["provider"], &name); err != nil {
+		return ctx, body
+	}
+	delete(bodyMap, "provider")
+	stripped, err := json.Marshal(bodyMap)
+	if err != nil {
+		return ctx, body
+	}
+	if name = strings.TrimSpace(name); name != "" {
+		ctx = context.WithValue(ctx, pinnedProviderContextKeyString, name)
+	}
+	return ctx, stripped
+}
+
+// pinnedProvider returns the provider a client pinned its request to, by body field or header,
+// or "" if it pinned none or the router doesn't allow pinning.
+func (cr *AICoreRouter) pinnedProvider(r *http.Request) string {
+	if cr.ProviderHeader == "" {
+		return ""
+	}
+	if name, ok := r.Context().Value(pinnedProviderContextKeyString).(string); ok {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(strings.TrimSpace(r.Header.Get(cr.ProviderHeader)))
+}
+
+// resolvePinnedRoute routes a request to the provider the client pinned, bypassing routing
+// rules and model matching: the model goes upstream as requested, less a "<provider>/" prefix.
+// The tier's provider restrictions (accept) still apply. On failure it writes an OpenAI-style error.
+func (cr *AICoreRouter) resolvePinnedRoute(w http.ResponseWriter, pinned, requestedModel string, accept func(*ProviderConfig) bool) (string, string, error) {
+	cr.mu.RLock()
+	p, ok := cr.Providers[pinned]
+	cr.mu.RUnlock()
+	if !ok {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "unknown_provider",
+			fmt.Sprintf("Provider '%s' is not configured", pinned))
+		return "", "", fmt.Errorf("pinned provider %s not configured", pinned)
+	}
+	if accept != nil && !accept(p) {
+		writeOpenAIError(w, http.StatusForbidden, ErrorTypePermission, "provider_not_allowed",
+			fmt.Sprintf("Provider '%s' can't serve this request", pinned))
+		return "", "", fmt.Errorf("pinned provider %s not accepted", pinned)
+	}
+	model := requestedModel
+	if prefix, rest, found := strings.Cut(requestedModel, "/"); found && strings.EqualFold(prefix, pinned) {
+		model = rest
+	}
+	return pinned, model, nil
+}
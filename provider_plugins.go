@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+)
+
+// Provider styles beyond the built-in ones come from Caddy modules in the ai.providers namespace:
+// a module whose ID is ai.providers.<style> and which implements providers.Provider (and any of the
+// optional capability interfaces) serves every provider configured with that style. Its
+// configuration is the provider's style_options, decoded like any other Caddy module.
+
+// builtinProviderStyles constructs the providers shipped with the router, by style.
+var builtinProviderStyles = map[string]func(p *ProviderConfig) providers.Provider{
+	"openai": func(p *ProviderConfig) providers.Provider {
+		return &providers.OpenAIProvider{
+			NativeResponses: p.NativeResponses || p.parsedURL.Host == "api.openai.com",
+			DeveloperRole:   p.parsedURL.Host == "api.openai.com",
+		}
+	},
+	"google": func(*ProviderConfig) providers.Provider { return &providers.GoogleProvider{} },
+	"anthropic": func(p *ProviderConfig) providers.Provider {
+		return &providers.AnthropicProvider{Version: p.AnthropicVersion, Betas: p.AnthropicBeta}
+	},
+	"cloudflare": newCloudflareProvider,
+	"mistral":    func(*ProviderConfig) providers.Provider { return &providers.MistralProvider{} },
+	"replicate":  func(*ProviderConfig) providers.Provider { return &providers.ReplicateProvider{} },
+	"stability":  func(*ProviderConfig) providers.Provider { return &providers.StabilityProvider{} },
+	"cohere":     func(*ProviderConfig) providers.Provider { return &providers.CohereProvider{} },
+	"openrouter": func(*ProviderConfig) providers.Provider { return &providers.OpenRouterProvider{} },
+	"deepseek":   func(*ProviderConfig) providers.Provider { return &providers.DeepSeekProvider{} },
+	"qwen":       func(*ProviderConfig) providers.Provider { return &providers.QwenProvider{} },
+	"dashscope":  func(*ProviderConfig) providers.Provider { return &providers.QwenProvider{} },
+	"grok":       func(*ProviderConfig) providers.Provider { return &providers.GrokProvider{} },
+	"together":   func(*ProviderConfig) providers.Provider { return &providers.TogetherProvider{} },
+	"fireworks":  func(*ProviderConfig) providers.Provider { return &providers.FireworksProvider{} },
+	"groq":       func(*ProviderConfig) providers.Provider { return &providers.GroqProvider{} },
+	"mock":       newMockProvider,
+}
+
+// styleBaseURLs are the api_base_url defaults of styles that serve a single vendor.
+var styleBaseURLs = map[string]string{
+	"deepseek":  providers.DeepSeekBaseURL,
+	"qwen":      providers.QwenBaseURL,
+	"dashscope": providers.QwenBaseURL,
+	"grok":      providers.GrokBaseURL,
+	"together":  providers.TogetherBaseURL,
+	"fireworks": providers.FireworksBaseURL,
+	"groq":      providers.GroqBaseURL,
+	"mock":      providers.MockBaseURL,
+}
+
+// newProvider returns the implementation of a provider's style: a built-in one, or an
+// ai.providers module. An empty style is openai.
+func newProvider(ctx caddy.Context, p *ProviderConfig) (providers.Provider, error) {
+	style := p.Style
+	if style == "" {
+		style = "openai"
+	}
+	if build, ok := builtinProviderStyles[style]; ok {
+		if len(p.StyleOptions) > 0 {
+			return nil, fmt.Errorf("style_options are only supported by ai.providers modules, not the built-in style '%s'", style)
+		}
+		return build(p), nil
+	}
+	id := "ai.providers." + style
+	if _, err := caddy.GetModule(id); err != nil {
+		return nil, fmt.Errorf("unknown style '%s': not built in and no %s module is registered", style, id)
+	}
+	config := p.StyleOptions
+	if len(config) == 0 {
+		config = json.RawMessage("{}")
+	}
+	mod, err := ctx.LoadModuleByID(id, config)
+	if err != nil {
+		return nil, fmt.Errorf("loading style '%s': %v", style, err)
+	}
+	provider, ok := mod.(providers.Provider)
+	if !ok {
+		return nil, fmt.Errorf("module %s does not implement providers.Provider", id)
+	}
+	return provider, nil
+}
+
+// parseStyleCaddyfile parses `style <name>`, with a block of options when the style is an
+// ai.providers module; the module's own UnmarshalCaddyfile reads them.
+func parseStyleCaddyfile(d *caddyfile.Dispenser, p *ProviderConfig) error {
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	p.Style = strings.ToLower(d.Val())
+	if _, builtin := builtinProviderStyles[p.Style]; builtin {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		return nil
+	}
+	info, err := caddy.GetModule("ai.providers." + p.Style)
+	if err != nil {
+		return d.Errf("unknown style '%s': %v", p.Style, err)
+	}
+	if _, ok := info.New().(caddyfile.Unmarshaler); !ok {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		return nil
+	}
+	unm, err := caddyfile.UnmarshalModule(d, "ai.providers."+p.Style)
+	if err != nil {
+		return err
+	}
+	if options := caddyconfig.JSON(unm, nil); string(options) != "{}" {
+		p.StyleOptions = options
+	}
+	return nil
+}
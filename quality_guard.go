@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Reasons a completion fails the quality guard.
+const (
+	QualityEmptyCompletion = "empty_completion"
+	QualityRefusalPattern  = "refusal_pattern"
+)
+
+// Retries one request may spend on the quality guard unless configured otherwise.
+const defaultQualityGuardRetries = 1
+
+// QualityGuard checks non-streaming completions before they are returned and retries the request
+// on the next model of its fallback chain, or the next provider serving the model, when the
+// completion is empty or matches a refusal pattern. Off unless configured.
+type QualityGuard struct {
+	// Regular expressions matched against the completion text that mark a refusal
+	RefusalPatterns []string `json:"refusal_patterns,omitempty"`
+	// Retries a request may spend on failed checks (defaults to 1)
+	MaxRetries int `json:"max_retries,omitempty"`
+
+	patterns []*regexp.Regexp
+}
+
+func (g *QualityGuard) provision() error {
+	if g == nil {
+		return nil
+	}
+	if g.MaxRetries < 0 {
+		return fmt.Errorf("quality_guard max_retries must not be negative")
+	}
+	g.patterns = nil
+	for _, pattern := range g.RefusalPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("quality_guard: invalid refusal_pattern '%s': %v", pattern, err)
+		}
+		g.patterns = append(g.patterns, re)
+	}
+	return nil
+}
+
+func (g *QualityGuard) enabled() bool {
+	return g != nil
+}
+
+func (g *QualityGuard) maxRetries() int {
+	if g == nil {
+		return 0
+	}
+	if g.MaxRetries > 0 {
+		return g.MaxRetries
+	}
+	return defaultQualityGuardRetries
+}
+
+// holds reports whether a response must be held back for the guard to check it.
+func (g *QualityGuard) holds(status int, stream bool) bool {
+	return g.enabled() && !stream && status == http.StatusOK
+}
+
+// check returns why a held completion fails the guard, or "" if it passes or isn't a completion.
+func (g *QualityGuard) check(resp *bufferedResponse) string {
+	if !g.enabled() || resp.status != http.StatusOK {
+		return ""
+	}
+	var completion struct {
+		Choices *[]struct {
+			Message struct {
+				Content   json.RawMessage   `json:"content"`
+				ToolCalls []json.RawMessage `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage *struct {
+			CompletionTokens *int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal(resp.body.Bytes(), &completion) != nil || completion.Choices == nil {
+		return ""
+	}
+	if completion.Usage != nil && completion.Usage.CompletionTokens != nil && *completion.Usage.CompletionTokens == 0 {
+		return QualityEmptyCompletion
+	}
+	empty := true
+	for _, choice := range *completion.Choices {
+		text := completionText(choice.Message.Content)
+		if strings.TrimSpace(text) != "" || len(choice.Message.ToolCalls) > 0 {
+			empty = false
+		}
+		for _, re := range g.patterns {
+			if re.MatchString(text) {
+				return QualityRefusalPattern
+			}
+		}
+	}
+	if empty {
+		return QualityEmptyCompletion
+	}
+	return ""
+}
+
+// completionText returns the text of a message's content, given as a string or as content parts.
+func completionText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(content, &parts) != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteString(part.Text)
+	}
+	return b.String()
+}
+
+// guardChain builds the chain the quality guard retries a request on when no fallback chain
+// matches its model: the other providers the routing rule lists for it, under the same model,
+// or else the same provider again.
+func (cr *AICoreRouter) guardChain(r *http.Request, requestedModel, providerName, actualModelName string) *FallbackChain {
+	var hops []string
+	for _, pName := range cr.routeModel(r, requestedModel).providers {
+		if pName != providerName {
+			hops = append(hops, pName+"/"+actualModelName)
+		}
+	}
+	if len(hops) == 0 {
+		hops = []string{providerName + "/" + actualModelName}
+	}
+	if limit := cr.QualityGuard.maxRetries(); len(hops) > limit {
+		hops = hops[:limit]
+	}
+	return &FallbackChain{Chain: hops, on: map[string]bool{}}
+}
+
+// parseQualityGuardCaddyfile parses a `quality_guard [max_retries] { ... }` block.
+func parseQualityGuardCaddyfile(d *caddyfile.Dispenser) (*QualityGuard, error) {
+	g := &QualityGuard{}
+	if d.NextArg() {
+		retries, err := strconv.Atoi(d.Val())
+		if err != nil {
+			return nil, d.Errf("invalid quality_guard max_retries '%s'", d.Val())
+		}
+		g.MaxRetries = retries
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "refusal_pattern":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			g.RefusalPatterns = append(g.RefusalPatterns, args...)
+		case "max_retries":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			retries, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid quality_guard max_retries '%s'", d.Val())
+			}
+			g.MaxRetries = retries
+		default:
+			return nil, d.Errf("unrecognized quality_guard option '%s'", d.Val())
+		}
+	}
+	if err := g.provision(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return g, nil
+}
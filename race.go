@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"go.uber.org/zap"
+)
+
+// RaceWinnerHeader tells the client which provider/model won a raced request.
+const RaceWinnerHeader = "X-AI-Race-Winner"
+
+// RaceConfig races requests for matching models: the request goes to its resolved provider and,
+// at the same time, to each rival; the first successful response is streamed back and the other
+// requests are cancelled. This trades extra upstream spend for latency.
+type RaceConfig struct {
+	// Glob pattern ("*" and "?") matched against the requested model
+	Model string `json:"model"`
+	// Models raced against the resolved one, as "provider/model" or names routing rules resolve
+	Rivals []string `json:"rivals"`
+
+	model *regexp.Regexp
+}
+
+func (c *RaceConfig) provision() error {
+	if c.Model == "" || len(c.Rivals) == 0 {
+		return fmt.Errorf("race requires a model and at least one rival")
+	}
+	c.model = globPattern(c.Model)
+	return nil
+}
+
+// raceFor returns the first race matching a requested model, if any.
+func (cr *AICoreRouter) raceFor(requestedModel string) *RaceConfig {
+	for _, c := range cr.Races {
+		if c.model.MatchString(requestedModel) {
+			return c
+		}
+	}
+	return nil
+}
+
+// raceState is shared by the contenders of one raced request.
+type raceState struct {
+	mu         sync.Mutex
+	w          http.ResponseWriter
+	hiding     *ProviderHiding
+	winner     *raceContender
+	contenders []*raceContender
+}
+
+// raceContender is one upstream request of a race and the response writer it proxies into. The
+// first contender whose response starts with a success status writes straight to the client;
+// failures are held in case every contender fails, anything else is discarded.
+type raceContender struct {
+	race    *raceState
+	p       *ProviderConfig
+	model   string
+	r       *http.Request
+	cancel  context.CancelFunc
+	tracker *usageTracker
+	resp    bufferedResponse
+	won     bool
+	aborted bool
+}
+
+func (c *raceContender) label() string {
+	return c.p.Name + "/" + c.model
+}
+
+func (c *raceContender) Header() http.Header {
+	if c.won {
+		return c.race.w.Header()
+	}
+	return c.resp.header
+}
+
+func (c *raceContender) WriteHeader(status int) {
+	c.race.mu.Lock()
+	defer c.race.mu.Unlock()
+	if c.resp.status != 0 {
+		return
+	}
+	c.resp.status = status
+	if c.race.winner != nil || status >= 400 {
+		return
+	}
+	c.race.winner, c.won = c, true
+	for name, values := range c.resp.header {
+		c.race.w.Header()[name] = values
+	}
+	c.race.w.Header().Set(RaceWinnerHeader, c.race.hiding.servedBy(c.p.Name, c.model))
+	c.race.w.WriteHeader(status)
+	for _, other := range c.race.contenders {
+		if other != c {
+			other.cancel()
+		}
+	}
+}
+
+func (c *raceContender) Write(p []byte) (int, error) {
+	if c.resp.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.won {
+		return c.race.w.Write(p)
+	}
+	return c.resp.body.Write(p)
+}
+
+func (c *raceContender) Flush() {
+	if c.won {
+		http.NewResponseController(c.race.w).Flush()
+	}
+}
+
+// proxyRace sends a request to its resolved provider p and to each rival of the race at once,
+// and streams back the first successful response, cancelling the others. Rivals that can't be
+// resolved, have no key or whose provider has no free slot sit the race out. If every contender
+// fails, the client gets the failure of the first one in race order. The request records the
+// usage of the winner; the losers' usage, as far as it got, is logged and sent as race_lost events.
+func (cr *AICoreRouter) proxyRace(w http.ResponseWriter, r *http.Request, race *RaceConfig, p *ProviderConfig, body []byte, stream bool, clientKey string, apiKeyService auth.ExternalAPIKeyProvider, userID string) {
+	logger := cr.requestLogger(r.Context())
+	tracker, _ := r.Context().Value(UsageTrackerContextKeyString).(*usageTracker)
+	state := &raceState{w: w, hiding: cr.HideProvider}
+	addContender := func(cp *ProviderConfig, req *http.Request) {
+		model, _ := req.Context().Value(ActualModelNameContextKeyString).(string)
+		c := &raceContender{race: state, p: cp, model: model, resp: bufferedResponse{header: make(http.Header)}}
+		ctx, cancel := context.WithCancel(req.Context())
+		if tracker != nil {
+			c.tracker = tracker.fork()
+			ctx = context.WithValue(ctx, UsageTrackerContextKeyString, c.tracker)
+		}
+		ctx = context.WithValue(ctx, RouteSampleContextKeyString, &routeSample{key: latencyKey(cp.Name, model)})
+		c.r, c.cancel = req.WithContext(ctx), cancel
+		state.contenders = append(state.contenders, c)
+	}
+
+	addContender(p, r.Clone(r.Context()))
+	for _, model := range race.Rivals {
+		rival, rr := cr.fallbackTarget(r.Clone(r.Context()), model, clientKey, apiKeyService, userID)
+		if rival == nil {
+			continue
+		}
+		if rival.limiter != nil {
+			if !rival.limiter.tryAcquire() {
+				logger.Debug("Rival provider has no free slot, racing without it", zap.String("provider", rival.Name))
+				continue
+			}
+			defer rival.limiter.release()
+		}
+		addContender(rival, rr)
+	}
+	if len(state.contenders) == 1 {
+		cr.proxyUpstream(w, r, p, body, stream)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range state.contenders {
+		wg.Add(1)
+		go func(c *raceContender) {
+			defer wg.Done()
+			defer c.cancel()
+			c.aborted = proxyRecovering(func() { cr.proxyUpstream(c, c.r, c.p, body, stream) })
+		}(c)
+	}
+	wg.Wait()
+
+	winner := state.winner
+	for _, c := range state.contenders {
+		outcome := "lost"
+		switch {
+		case c == winner:
+			outcome = "won"
+		case c.resp.status >= 400:
+			outcome = "failed"
+		}
+		raceOutcomes.WithLabelValues(cr.Name, c.p.Name, outcome).Inc()
+		if c == winner || c.tracker == nil {
+			continue
+		}
+		promptTokens, completionTokens, estimated := c.tracker.snapshot()
+		logger.Debug("Race contender did not serve the request",
+			zap.String("provider", c.p.Name),
+			zap.String("actual_model", c.model),
+			zap.String("outcome", outcome),
+			zap.Int("status_code", c.resp.status),
+			zap.Int("prompt_tokens", promptTokens),
+			zap.Int("completion_tokens", completionTokens),
+		)
+		if winner != nil {
+			cr.observability.Fire(userID, "", "race_lost", map[string]any{
+				"provider":          c.p.Name,
+				"model":             c.model,
+				"winner":            winner.label(),
+				"prompt_tokens":     promptTokens,
+				"completion_tokens": completionTokens,
+				"tokens_estimated":  estimated,
+				"user_id":           userID,
+				"request_id":        requestID(r.Context()),
+			})
+		}
+	}
+
+	if winner != nil {
+		if tracker != nil && winner.tracker != nil {
+			tracker.absorb(winner.tracker)
+		}
+		accessRecordFrom(r.Context()).fellBack(winner.p.Name, winner.model)
+		setServedPlaceholders(r, winner.p.Name, winner.model)
+		if winner.aborted {
+			panic(http.ErrAbortHandler) // Re-raised on the handler goroutine, where the server expects it
+		}
+		return
+	}
+	if r.Context().Err() != nil {
+		return // Client is gone
+	}
+	for _, c := range state.contenders {
+		if c.resp.status != 0 {
+			c.resp.writeTo(w)
+			return
+		}
+	}
+	writeOpenAIError(w, http.StatusBadGateway, ErrorTypeAPI, "upstream_unavailable", "No upstream provider answered the request")
+}
+
+// parseRaceCaddyfile parses a `race <model> <rival>...` line.
+func parseRaceCaddyfile(d *caddyfile.Dispenser) (*RaceConfig, error) {
+	args := d.RemainingArgs()
+	if len(args) < 2 {
+		return nil, d.Errf("race expects <model> <rival_model> [<rival_model>...]")
+	}
+	race := &RaceConfig{Model: args[0], Rivals: args[1:]}
+	if err := race.provision(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return race, nil
+}
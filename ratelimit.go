@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Cool-down when a provider rate-limits us without saying for how long
+	defaultRateLimitCooldown = 15 * time.Second
+	maxRateLimitCooldown     = 10 * time.Minute
+)
+
+// rateLimitQuotas pairs the headers in which providers report a remaining quota with the
+// header that says when it resets. A remaining value of 0 means the next request will be limited.
+var rateLimitQuotas = []struct{ remaining, reset string }{
+	{"X-Ratelimit-Remaining", "X-Ratelimit-Reset"},
+	{"X-Ratelimit-Remaining-Requests", "X-Ratelimit-Reset-Requests"},
+	{"X-Ratelimit-Remaining-Tokens", "X-Ratelimit-Reset-Tokens"},
+	{"Anthropic-Ratelimit-Requests-Remaining", "Anthropic-Ratelimit-Requests-Reset"},
+	{"Anthropic-Ratelimit-Tokens-Remaining", "Anthropic-Ratelimit-Tokens-Reset"},
+}
+
+// parseRateLimitReset interprets one reset header value relative to now. Providers use
+// seconds, milliseconds, Go-style durations ("6m0s"), Unix timestamps, RFC 3339 and HTTP dates.
+func parseRateLimitReset(name, value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if name == "Retry-After-Ms" {
+		ms, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds > 1e9 {
+			return time.Unix(int64(seconds), 0).Sub(now), true // A Unix timestamp
+		}
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Sub(now), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// rateLimitCooldown works out whether a response means the provider is rate limited, and for how long.
+// An explicit Retry-After wins; otherwise the cool-down lasts until the exhausted quotas reset.
+func rateLimitCooldown(resp *http.Response, now time.Time) (time.Duration, bool) {
+	limited := resp.StatusCode == http.StatusTooManyRequests
+	retryAfter := time.Duration(0)
+	for _, name := range []string{"Retry-After", "Retry-After-Ms"} {
+		if d, ok := parseRateLimitReset(name, resp.Header.Get(name), now); ok && d > retryAfter {
+			retryAfter = d
+		}
+	}
+	cooldown := time.Duration(0)
+	for _, quota := range rateLimitQuotas {
+		if strings.TrimSpace(resp.Header.Get(quota.remaining)) != "0" {
+			continue
+		}
+		limited = true
+		if d, ok := parseRateLimitReset(quota.reset, resp.Header.Get(quota.reset), now); ok && d > cooldown {
+			cooldown = d
+		}
+	}
+	if retryAfter > 0 {
+		cooldown = retryAfter
+	}
+	if !limited {
+		return 0, false
+	}
+	if cooldown <= 0 {
+		cooldown = defaultRateLimitCooldown
+	}
+	if cooldown > maxRateLimitCooldown {
+		cooldown = maxRateLimitCooldown
+	}
+	return cooldown, true
+}
+
+// recordRateLimit puts the upstream key of a rate-limited response on cool-down in the provider's
+// key pool. Unless the pool has another key to rotate to, the provider/model goes on cool-down
+// too, shared through the router store.
+func (cr *AICoreRouter) recordRateLimit(resp *http.Response, p *ProviderConfig) {
+	logger := cr.requestLogger(resp.Request.Context())
+	cooldown, limited := rateLimitCooldown(resp, time.Now())
+	if !limited {
+		return
+	}
+	ctx := resp.Request.Context()
+	providerName := p.Name
+	model, _ := ctx.Value(ActualModelNameContextKeyString).(string)
+	until := time.Now().Add(cooldown)
+	userID, _ := ctx.Value(UserIDContextKeyString).(string)
+	if apiKey, _ := ctx.Value(ExternalAPIKeyProviderContextKeyString).(string); p.keys != nil && p.keys.throttle(apiKey, until) {
+		logger.Warn("Upstream key rate limited, rotating to the provider's other keys",
+			zap.String("provider", providerName),
+			zap.String("key", keyFingerprint(apiKey)),
+			zap.Int("status_code", resp.StatusCode),
+			zap.Duration("cooldown", cooldown),
+		)
+		cr.observability.Fire(userID, "", "provider_key_rate_limited", map[string]any{
+			"provider":    providerName,
+			"model":       model,
+			"key":         keyFingerprint(apiKey),
+			"status_code": resp.StatusCode,
+			"cooldown_ms": cooldown.Milliseconds(),
+			"request_id":  requestID(ctx),
+		})
+		return
+	}
+	if err := cr.store.Set(context.WithoutCancel(ctx), cr.storeKey("cooldown", providerName, model), []byte(until.Format(time.RFC3339Nano)), cooldown); err != nil {
+		logger.Warn("Failed to record provider cool-down", zap.Error(err), zap.String("provider", providerName))
+	}
+	logger.Warn("Provider rate limited, cooling down",
+		zap.String("provider", providerName),
+		zap.String("model", model),
+		zap.Int("status_code", resp.StatusCode),
+		zap.Duration("cooldown", cooldown),
+	)
+
+	cr.observability.Fire(userID, "", "provider_rate_limited", map[string]any{
+		"provider":    providerName,
+		"model":       model,
+		"status_code": resp.StatusCode,
+		"cooldown_ms": cooldown.Milliseconds(),
+		"request_id":  requestID(ctx),
+	})
+}
+
+// coolingDown reports whether a provider/model is on rate-limit cool-down.
+func (cr *AICoreRouter) coolingDown(ctx context.Context, providerName, model string) bool {
+	logger := cr.requestLogger(ctx)
+	_, ok, err := cr.store.Get(ctx, cr.storeKey("cooldown", providerName, model))
+	if err != nil {
+		logger.Warn("Failed to check provider cool-down", zap.Error(err), zap.String("provider", providerName))
+		return false
+	}
+	return ok
+}
+
+// withoutCoolingDown drops candidates that are cooling down for the model, or on which its
+// warm-up failed. If that drops every candidate it returns them all, so the request still gets
+// a provider.
+// Must be called with cr.mu held.
+func (cr *AICoreRouter) withoutCoolingDown(ctx context.Context, model string, candidates []string) []string {
+	logger := cr.requestLogger(ctx)
+	available := make([]string, 0, len(candidates))
+	for _, name := range candidates {
+		if cr.coolingDown(ctx, name, model) {
+			logger.Debug("Skipping provider on rate-limit cool-down", zap.String("provider", name), zap.String("model", model))
+			continue
+		}
+		if p, ok := cr.Providers[name]; ok && cr.degraded(ctx, p, model) {
+			logger.Debug("Skipping provider whose model warm-up failed", zap.String("provider", name), zap.String("model", model))
+			continue
+		}
+		available = append(available, name)
+	}
+	if len(available) == 0 {
+		return candidates
+	}
+	return available
+}
@@ -0,0 +1,411 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+func init() {
+	caddy.RegisterModule(RealtimeHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_realtime", parseRealtimeHandlerCaddyfile)
+}
+
+// Largest event relayed in either direction; audio is sent as base64 inside JSON events.
+const realtimeMaxFrame = 32 << 20
+
+// How long connecting to the provider and its WebSocket handshake may take.
+const realtimeDialTimeout = 15 * time.Second
+
+// RealtimeHandler proxies OpenAI Realtime API sessions (GET /v1/realtime?model=... upgraded to
+// a WebSocket). The model is routed like any other, the client's credential is replaced with the
+// provider's upstream key, and events are relayed unchanged in both directions while the usage
+// each response.done reports is sent as observability events.
+type RealtimeHandler struct {
+	Router string `json:"router,omitempty"`
+	// Origins browsers may connect from, e.g. "https://app.example.com" (any if empty)
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (RealtimeHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_realtime",
+		New: func() caddy.Module { return new(RealtimeHandler) },
+	}
+}
+
+func (h *RealtimeHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	h.routers, err = routerScopeOf(ctx)
+	return err
+}
+
+func (h *RealtimeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return next.ServeHTTP(w, r)
+	}
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_realtime: router '%s' not found", routerName))
+		return nil
+	}
+	defer cr.track()()
+
+	cr.firePageviewEvent(r)
+
+	return cr.handleRealtimeSession(w, r, h.AllowedOrigins)
+}
+
+// supportsRealtime reports whether a provider serves the Realtime API.
+func supportsRealtime(p *ProviderConfig) bool {
+	_, ok := p.Provider.(providers.RealtimeProvider)
+	return ok
+}
+
+// handleRealtimeSession resolves a realtime session to a provider, connects upstream and then
+// accepts the client's upgrade, so routing and upstream errors still get an HTTP response.
+func (cr *AICoreRouter) handleRealtimeSession(w http.ResponseWriter, r *http.Request, allowedOrigins []string) error {
+	r = withRequestID(w, r)
+	logger := cr.requestLogger(r.Context())
+	apiKeyService := cr.apiKeyServiceFor(r)
+	userID, _ := r.Context().Value(UserIDContextKeyString).(string)
+	apiKeyID, _ := r.Context().Value(ApiKeyIDContextKeyString).(string)
+
+	model := r.URL.Query().Get("model")
+	if model == "" {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "missing_model", "'model' query parameter is required")
+		return fmt.Errorf("'model' query parameter is required")
+	}
+
+	providerName, actualModelName, err := cr.resolveRoute(w, r, model, "", apiKeyService, userID, supportsRealtime)
+	if err != nil {
+		return err
+	}
+	cr.mu.RLock()
+	providerConfig, ok := cr.Providers[providerName]
+	cr.mu.RUnlock()
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Internal server error: provider configuration missing")
+		return fmt.Errorf("internal: provider %s not found post-resolution", providerName)
+	}
+	realtime, ok := providerConfig.Provider.(providers.RealtimeProvider)
+	if !ok {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "unsupported_model",
+			fmt.Sprintf("Provider %s does not support the Realtime API", providerName))
+		return fmt.Errorf("provider %s does not support the Realtime API", providerName)
+	}
+
+	apiKey, err := cr.upstreamAPIKey(w, r, apiKeyService, providerConfig, userID)
+	if err != nil {
+		return err
+	}
+
+	// A session holds its slot for as long as it stays open
+	if providerConfig.limiter != nil {
+		if _, err := providerConfig.limiter.acquire(r.Context(), cr.requestPriority(r)); err != nil {
+			if r.Context().Err() != nil {
+				return nil
+			}
+			writeProviderOverloaded(w, providerConfig, err)
+			return err
+		}
+		defer providerConfig.limiter.release()
+	}
+
+	upstreamURL := realtime.RealtimeURL(providerConfig.parsedURL, actualModelName)
+	upstream, err := dialRealtime(r, providerConfig, upstreamURL.String(), apiKey)
+	if err != nil {
+		logger.Warn("Failed to connect realtime session upstream", zap.String("provider", providerName), zap.Error(err))
+		writeOpenAIError(w, http.StatusBadGateway, ErrorTypeAPI, "upstream_unavailable",
+			fmt.Sprintf("Could not open a realtime session with provider %s", providerName))
+		return err
+	}
+	defer upstream.Close()
+
+	logger.Info("Routing realtime session",
+		zap.String("original_model", model),
+		zap.String("provider", providerName),
+		zap.String("actual_model", actualModelName),
+		zap.String("user_id", userID),
+		zap.String("api_key_id", apiKeyID),
+	)
+	props := func(extra map[string]any) map[string]any {
+		extra["$ip"] = r.RemoteAddr
+		extra["model"] = model
+		extra["provider"] = providerName
+		extra["actual_model"] = actualModelName
+		extra["user_id"] = userID
+		extra["api_key_id"] = apiKeyID
+		extra["request_id"] = requestID(r.Context())
+		return extra
+	}
+	cr.observability.Fire(userID, "", "realtime_start", props(map[string]any{}))
+
+	session := &realtimeSession{}
+	start := common.CaddyClock.Now()
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			config.Protocol = realtimeSubprotocol(config.Protocol)
+			return checkWebSocketOrigin(allowedOrigins, config, req)
+		},
+		Handler: func(client *websocket.Conn) {
+			session.relay(client, upstream, func(usage realtimeUsage) {
+				cr.observability.Fire(userID, "", "realtime_response", props(usage.properties()))
+			})
+		},
+	}
+	server.ServeHTTP(w, r)
+
+	total := session.usage()
+	logger.Info("Realtime session ended",
+		zap.String("provider", providerName),
+		zap.String("actual_model", actualModelName),
+		zap.Duration("duration", common.CaddyClock.Now().Sub(start)),
+		zap.Int("responses", total.Responses),
+		zap.Int("input_tokens", total.InputTokens),
+		zap.Int("output_tokens", total.OutputTokens),
+	)
+	stop := props(total.properties())
+	stop["duration_ms"] = common.CaddyClock.Now().Sub(start).Milliseconds()
+	cr.observability.Fire(userID, "", "realtime_stop", stop)
+	return nil
+}
+
+// dialRealtime opens the upstream session with the provider's key in place of the client's. The
+// connection is made with the provider's transport settings and the handshake is signed like
+// its other requests.
+func dialRealtime(r *http.Request, p *ProviderConfig, upstreamURL, apiKey string) (*websocket.Conn, error) {
+	origin := "https://" + p.parsedURL.Host
+	config, err := websocket.NewConfig(upstreamURL, origin)
+	if err != nil {
+		return nil, err
+	}
+	config.Header = http.Header{}
+	if apiKey != "" {
+		config.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	beta := r.Header.Get("OpenAI-Beta")
+	if beta == "" {
+		beta = "realtime=v1"
+	}
+	config.Header.Set("OpenAI-Beta", beta)
+	if p.Organization != "" {
+		config.Header.Set("OpenAI-Organization", p.Organization)
+	}
+	if p.Project != "" {
+		config.Header.Set("OpenAI-Project", p.Project)
+	}
+	for name, value := range p.ExtraHeaders {
+		config.Header.Set(name, value)
+	}
+	if id := requestID(r.Context()); id != "" {
+		config.Header.Set(RequestIDHeader, id)
+	}
+	if p.SignRequests != nil {
+		handshake := &http.Request{Method: http.MethodGet, URL: config.Location}
+		for name, value := range p.SignRequests.headers(handshake, nil, time.Now()) {
+			config.Header.Set(name, value)
+		}
+	}
+
+	transport := p.transport
+	if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), realtimeDialTimeout)
+	defer cancel()
+	conn, err := dialUpstream(ctx, transport, config.Location)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(realtimeDialTimeout))
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ws, nil
+}
+
+// realtimeSubprotocol picks the subprotocol to answer a client with. Browser clients offer
+// "realtime" next to others carrying their key, which must not be echoed back.
+func realtimeSubprotocol(offered []string) []string {
+	for _, protocol := range offered {
+		if protocol == "realtime" {
+			return []string{protocol}
+		}
+	}
+	return nil
+}
+
+// realtimeFrame is a relayed WebSocket message with its frame type, so binary frames stay binary.
+type realtimeFrame struct {
+	data        []byte
+	payloadType byte
+}
+
+var realtimeCodec = websocket.Codec{
+	Marshal: func(v any) ([]byte, byte, error) {
+		f := v.(realtimeFrame)
+		return f.data, f.payloadType, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		f := v.(*realtimeFrame)
+		f.data, f.payloadType = data, payloadType
+		return nil
+	},
+}
+
+// realtimeSession relays one session and totals the usage its responses report.
+type realtimeSession struct {
+	mu    sync.Mutex
+	total realtimeUsage
+}
+
+// relay copies events both ways until either side closes, then closes the other.
+func (s *realtimeSession) relay(client, upstream *websocket.Conn, onResponse func(realtimeUsage)) {
+	for _, ws := range []*websocket.Conn{client, upstream} {
+		ws.MaxPayloadBytes = realtimeMaxFrame
+	}
+	var wg sync.WaitGroup
+	pipe := func(from, to *websocket.Conn, observe func([]byte)) {
+		defer wg.Done()
+		defer to.Close()
+		defer from.Close()
+		for {
+			var frame realtimeFrame
+			if err := realtimeCodec.Receive(from, &frame); err != nil {
+				return
+			}
+			if observe != nil && frame.payloadType == websocket.TextFrame {
+				observe(frame.data)
+			}
+			if err := realtimeCodec.Send(to, frame); err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go pipe(client, upstream, nil)
+	go pipe(upstream, client, func(event []byte) {
+		if usage, ok := responseUsage(event); ok {
+			s.mu.Lock()
+			s.total.add(usage)
+			s.mu.Unlock()
+			onResponse(usage)
+		}
+	})
+	wg.Wait()
+}
+
+func (s *realtimeSession) usage() realtimeUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// realtimeUsage is the usage of a realtime response, or the total of a session.
+type realtimeUsage struct {
+	Responses         int `json:"-"`
+	InputTokens       int `json:"input_tokens"`
+	OutputTokens      int `json:"output_tokens"`
+	InputTokenDetails struct {
+		TextTokens   int `json:"text_tokens"`
+		AudioTokens  int `json:"audio_tokens"`
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_token_details"`
+	OutputTokenDetails struct {
+		TextTokens  int `json:"text_tokens"`
+		AudioTokens int `json:"audio_tokens"`
+	} `json:"output_token_details"`
+}
+
+func (u *realtimeUsage) add(other realtimeUsage) {
+	u.Responses += other.Responses
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.InputTokenDetails.TextTokens += other.InputTokenDetails.TextTokens
+	u.InputTokenDetails.AudioTokens += other.InputTokenDetails.AudioTokens
+	u.InputTokenDetails.CachedTokens += other.InputTokenDetails.CachedTokens
+	u.OutputTokenDetails.TextTokens += other.OutputTokenDetails.TextTokens
+	u.OutputTokenDetails.AudioTokens += other.OutputTokenDetails.AudioTokens
+}
+
+func (u realtimeUsage) properties() map[string]any {
+	return map[string]any{
+		"responses":           u.Responses,
+		"prompt_tokens":       u.InputTokens,
+		"completion_tokens":   u.OutputTokens,
+		"input_text_tokens":   u.InputTokenDetails.TextTokens,
+		"input_audio_tokens":  u.InputTokenDetails.AudioTokens,
+		"cached_tokens":       u.InputTokenDetails.CachedTokens,
+		"output_text_tokens":  u.OutputTokenDetails.TextTokens,
+		"output_audio_tokens": u.OutputTokenDetails.AudioTokens,
+	}
+}
+
+// responseUsage returns the usage of a response.done server event.
+func responseUsage(event []byte) (realtimeUsage, bool) {
+	if !bytes.Contains(event, []byte(`"response.done"`)) {
+		return realtimeUsage{}, false
+	}
+	var done struct {
+		Type     string `json:"type"`
+		Response struct {
+			Usage *realtimeUsage `json:"usage"`
+		} `json:"response"`
+	}
+	if json.Unmarshal(event, &done) != nil || done.Type != "response.done" || done.Response.Usage == nil {
+		return realtimeUsage{}, false
+	}
+	usage := *done.Response.Usage
+	usage.Responses = 1
+	return usage, true
+}
+
+func parseRealtimeHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var rh RealtimeHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				rh.Router = h.Val()
+			case "allowed_origins":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, h.ArgErr()
+				}
+				rh.AllowedOrigins = append(rh.AllowedOrigins, args...)
+			default:
+				return nil, h.Errf("unrecognized ai_realtime option '%s'", h.Val())
+			}
+		}
+	}
+	return &rh, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*RealtimeHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*RealtimeHandler)(nil)
+)
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+)
+
+// StripReasoningContextKeyString marks requests whose responses have reasoning removed.
+const StripReasoningContextKeyString string = "ai_strip_reasoning"
+
+// reasoningFields are the message fields reasoning models put their thinking in: the unified
+// reasoning_content (DeepSeek's name), and OpenRouter's reasoning and reasoning_details.
+var reasoningFields = []string{"reasoning_content", "reasoning", "reasoning_details"}
+
+// stripReasoning removes reasoning from the choices of a completion, or of every chunk of a
+// stream, for routes with strip_reasoning. Streams are rewritten as they are read.
+func (cr *AICoreRouter) stripReasoning(resp *http.Response) error {
+	if strip, _ := resp.Request.Context().Value(StripReasoningContextKeyString).(bool); !strip || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	contentType := resp.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Body = &reasoningStripper{src: bufio.NewReader(resp.Body), closer: resp.Body}
+		return nil
+	case strings.HasPrefix(contentType, "application/json"):
+		return common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+			return stripReasoningFields(body, "message"), nil
+		})
+	}
+	return nil
+}
+
+// stripReasoningFields removes reasoningFields from the given member ("message" or "delta") of
+// every choice. Bodies without reasoning are returned as they are.
+func stripReasoningFields(body []byte, member string) []byte {
+	var payload map[string]json.RawMessage
+	if json.Unmarshal(body, &payload) != nil {
+		return body
+	}
+	var choices []map[string]json.RawMessage
+	if json.Unmarshal(payload["choices"], &choices) != nil {
+		return body
+	}
+	stripped := false
+	for _, choice := range choices {
+		var message map[string]json.RawMessage
+		if json.Unmarshal(choice[member], &message) != nil {
+			continue
+		}
+		for _, field := range reasoningFields {
+			if _, ok := message[field]; ok {
+				delete(message, field)
+				stripped = true
+			}
+		}
+		choice[member], _ = json.Marshal(message)
+	}
+	if !stripped {
+		return body
+	}
+	payload["choices"], _ = json.Marshal(choices)
+	out, err := json.Marshal(payload)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// reasoningStripper rewrites the data lines of an SSE stream without reasoning deltas.
+type reasoningStripper struct {
+	src     *bufio.Reader
+	closer  io.Closer
+	pending []byte
+	err     error
+}
+
+func (s *reasoningStripper) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		var line []byte
+		line, s.err = s.src.ReadBytes('\n')
+		if data, ok := bytes.CutPrefix(line, []byte("data: ")); ok {
+			trimmed := bytes.TrimRight(data, "\r\n")
+			if stripped := stripReasoningFields(trimmed, "delta"); !bytes.Equal(stripped, trimmed) {
+				line = append(append([]byte("data: "), stripped...), data[len(trimmed):]...)
+			}
+		}
+		s.pending = line
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *reasoningStripper) Close() error {
+	return s.closer.Close()
+}
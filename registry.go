@@ -0,0 +1,264 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// How long a replaced router keeps serving its in-flight requests before it is stopped anyway
+const defaultDrainTimeout = 10 * time.Minute
+
+// routerRegistry maps router names to every provisioned version of that router, oldest first.
+// On a config reload the new version registers while the old one still serves in-flight
+// requests; lookups return the newest version, and a version that is unloaded (or whose
+// config failed to load) drops out, so lookups fall back to the one still running. Endpoint
+// handlers look routers up in their config's routerScope instead; the registry serves
+// lookups from outside any config, like the admin API's.
+var routerRegistry = struct {
+	mu          sync.RWMutex
+	versions    map[string][]*AICoreRouter
+	lastVersion uint64
+}{versions: make(map[string][]*AICoreRouter)}
+
+func registerRouter(name string, r *AICoreRouter) {
+	name = strings.ToLower(name)
+	routerRegistry.mu.Lock()
+	defer routerRegistry.mu.Unlock()
+	routerRegistry.lastVersion++
+	r.version = routerRegistry.lastVersion
+	routerRegistry.versions[name] = append(routerRegistry.versions[name], r)
+}
+
+func unregisterRouter(name string, r *AICoreRouter) {
+	name = strings.ToLower(name)
+	routerRegistry.mu.Lock()
+	defer routerRegistry.mu.Unlock()
+	versions := routerRegistry.versions[name]
+	for i, v := range versions {
+		if v == r {
+			versions = append(versions[:i:i], versions[i+1:]...)
+			break
+		}
+	}
+	if len(versions) == 0 {
+		delete(routerRegistry.versions, name)
+	} else {
+		routerRegistry.versions[name] = versions
+	}
+}
+
+// getRouter returns the newest version of a router.
+func getRouter(name string) (*AICoreRouter, bool) {
+	return lookupRouter(name, 0)
+}
+
+// lookupRouter returns a specific version of a router, or the newest one for version 0.
+func lookupRouter(name string, version uint64) (*AICoreRouter, bool) {
+	if strings.TrimSpace(name) == "" {
+		name = "default"
+	}
+	routerRegistry.mu.RLock()
+	defer routerRegistry.mu.RUnlock()
+	versions := routerRegistry.versions[strings.ToLower(name)]
+	if len(versions) == 0 {
+		return nil, false
+	}
+	if version == 0 {
+		return versions[len(versions)-1], true
+	}
+	for _, cr := range versions {
+		if cr.version == version {
+			return cr, true
+		}
+	}
+	return nil, false
+}
+
+// routerScope holds the routers of one config. Endpoint handlers look routers up in the scope
+// of the config they were loaded with, so routers can be referenced across server blocks
+// while a reload's new routers never shadow those of the config still serving, and two
+// routers of one config can't share a name.
+type routerScope struct {
+	mu      sync.RWMutex
+	routers map[string]*AICoreRouter
+}
+
+func newRouterScope() *routerScope {
+	return &routerScope{routers: make(map[string]*AICoreRouter)}
+}
+
+// routerScopeOf returns the router scope of the config being loaded. It's held by the config's
+// ai_router app, which Caddy instantiates empty if the config declares no global routers.
+func routerScopeOf(ctx caddy.Context) (*routerScope, error) {
+	app, err := ctx.App("ai_router")
+	if err != nil {
+		return nil, err
+	}
+	return app.(*RouterApp).scope, nil
+}
+
+// add registers a router in the scope, failing if the config already has one of that name.
+func (s *routerScope) add(cr *AICoreRouter) error {
+	name := strings.ToLower(cr.Name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, taken := s.routers[name]; taken {
+		return fmt.Errorf("router '%s' is declared more than once in this config (e.g. by ai_router in two sites); "+
+			"give each router its own name, or declare it once in the global ai_router options and select it with 'router %s'", name, name)
+	}
+	s.routers[name] = cr
+	return nil
+}
+
+// router returns the router of a name, "default" if empty. Without a scope (a handler that
+// wasn't provisioned by Caddy) it returns the newest version registered by any config.
+func (s *routerScope) router(name string) (*AICoreRouter, bool) {
+	if s == nil {
+		return getRouter(name)
+	}
+	if strings.TrimSpace(name) == "" {
+		name = "default"
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cr, ok := s.routers[strings.ToLower(name)]
+	return cr, ok
+}
+
+// jwtClaimPlaceholder matches {ai.jwt.<claim>} in router names.
+var jwtClaimPlaceholder = regexp.MustCompile(`\{ai\.jwt\.([A-Za-z0-9_:.-]+)\}`)
+
+// routerFor returns the router a handler serves a request with, and its resolved name. The
+// configured name may contain placeholders, so one handler can pick a tenant's router per
+// request, e.g. {http.request.host}, {http.request.header.X-Tenant} or a claim of the
+// request's bearer JWT such as {ai.jwt.tenant}. Routers are looked up in the handler's scope.
+func routerFor(r *http.Request, scope *routerScope, name string) (*AICoreRouter, string, bool) {
+	if strings.Contains(name, "{") {
+		name = jwtClaimPlaceholder.ReplaceAllStringFunc(name, func(placeholder string) string {
+			return jwtClaim(r, jwtClaimPlaceholder.FindStringSubmatch(placeholder)[1])
+		})
+		if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+			name = repl.ReplaceAll(name, "")
+		}
+	}
+	cr, ok := scope.router(name)
+	return cr, name, ok
+}
+
+// jwtClaim returns a top-level claim of the bearer JWT in the Authorization header, or "".
+// The token's signature is NOT verified here; tenant selection by claim must sit behind an
+// authentication layer that has validated the token.
+func jwtClaim(r *http.Request, claim string) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	switch value := claims[claim].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// routerDrain counts the requests a router version is serving, so a replaced version can
+// finish them before its background work and shared resources are shut down.
+type routerDrain struct {
+	mu      sync.Mutex
+	active  int
+	retired bool
+	idle    chan struct{} // Closed once retired with no requests in flight
+}
+
+// track marks a request as in flight on the router; the returned func ends it.
+func (cr *AICoreRouter) track() func() {
+	d := &cr.drain
+	d.mu.Lock()
+	d.active++
+	d.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.active--
+			if d.retired && d.active == 0 {
+				d.closeIdleLocked()
+			}
+		})
+	}
+}
+
+// retire stops new lookups from finding the router and returns a channel that is closed
+// once its in-flight requests are done; first is false if it was already retired.
+func (cr *AICoreRouter) retire() (idle <-chan struct{}, first bool) {
+	unregisterRouter(cr.Name, cr)
+	d := &cr.drain
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.retired {
+		return d.idle, false
+	}
+	d.retired = true
+	d.idle = make(chan struct{})
+	if d.active == 0 {
+		d.closeIdleLocked()
+	}
+	return d.idle, true
+}
+
+// closeIdleLocked closes the idle channel unless it already is. Must be called with d.mu held;
+// requests that looked the router up just before it was retired may still come and go.
+func (d *routerDrain) closeIdleLocked() {
+	select {
+	case <-d.idle:
+	default:
+		close(d.idle)
+	}
+}
+
+// drainAndStop waits for in-flight requests (up to the drain timeout) and then stops the router.
+func (cr *AICoreRouter) drainAndStop(idle <-chan struct{}) {
+	timeout := defaultDrainTimeout
+	if cr.DrainTimeout > 0 {
+		timeout = time.Duration(cr.DrainTimeout)
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+		cr.drain.mu.Lock()
+		active := cr.drain.active
+		cr.drain.mu.Unlock()
+		cr.logger.Warn("Drain timeout reached, stopping router with requests still in flight",
+			zap.String("router", cr.Name),
+			zap.Uint64("router_version", cr.version),
+			zap.Int("active_requests", active),
+		)
+	}
+	cr.stop()
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RequestIDHeader carries the per-request ID on client responses and upstream requests.
+// Clients may send their own; it is kept if it looks like an ID.
+const RequestIDHeader = "X-AI-Request-Id"
+
+const (
+	RequestIDContextKeyString    string = "ai_request_id"
+	AccessRecordContextKeyString string = "ai_access_record"
+)
+
+// withRequestID assigns the request its ID, unless it already has one, and echoes it on the response.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if requestID(r.Context()) != "" {
+		return r
+	}
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRandomID("req_")
+	}
+	w.Header().Set(RequestIDHeader, id)
+	setPlaceholder(r, PlaceholderRequestID, id)
+	return r.WithContext(context.WithValue(r.Context(), RequestIDContextKeyString, id))
+}
+
+// validRequestID accepts short IDs made of URL- and log-safe characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of the request a context belongs to, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(RequestIDContextKeyString).(string)
+	return id
+}
+
+// handlerLogger returns the logger of an endpoint handler. Handlers don't know which router
+// serves a request until it arrives, so their logs are always fully redacted.
+func handlerLogger(ctx caddy.Context, h caddy.Module) *zap.Logger {
+	return common.RedactionPolicy{}.Logger(ctx.Logger(h))
+}
+
+// requestLogger returns the router logger annotated with the request ID, if the context has one.
+func (cr *AICoreRouter) requestLogger(ctx context.Context) *zap.Logger {
+	if id := requestID(ctx); id != "" {
+		return cr.logger.With(zap.String("request_id", id))
+	}
+	return cr.logger
+}
+
+// accessRecord collects the lifecycle of one inference request for the access log.
+type accessRecord struct {
+	mu             sync.Mutex
+	start          time.Time
+	requestedModel string
+	model          string
+	provider       string
+	attempts       int
+	status         int // Sent to the client
+	upstreamStatus int // Of the last upstream attempt
+	queueWait      time.Duration
+	upstreamStart  time.Time
+	upstream       time.Duration // Until upstream response headers
+}
+
+// newAccessRecord starts an access record for a request if the access log, tracing, alerts,
+// usage accounting or canaries are enabled, wrapping the response writer to capture the status sent to the client.
+func (cr *AICoreRouter) newAccessRecord(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, *accessRecord) {
+	if cr.AccessLog == "" && cr.tracer == nil && cr.alerts == nil && cr.Usage == nil && len(cr.Canaries) == 0 {
+		return w, r, nil
+	}
+	rec := &accessRecord{start: time.Now()}
+	w = &accessResponseWriter{ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w}, rec: rec}
+	return w, r.WithContext(context.WithValue(r.Context(), AccessRecordContextKeyString, rec)), rec
+}
+
+func accessRecordFrom(ctx context.Context) *accessRecord {
+	rec, _ := ctx.Value(AccessRecordContextKeyString).(*accessRecord)
+	return rec
+}
+
+// routed notes where the request was routed and how long it queued for the provider.
+func (rec *accessRecord) routed(requestedModel, provider, model string, queueWait time.Duration) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.requestedModel = requestedModel
+	rec.provider = provider
+	rec.model = model
+	rec.queueWait = queueWait
+}
+
+// fellBack notes the provider and model a fallback chain moved the request to.
+func (rec *accessRecord) fellBack(provider, model string) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.provider = provider
+	rec.model = model
+}
+
+// attemptStarted notes that a request is about to be sent upstream.
+func (rec *accessRecord) attemptStarted() {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.attempts++
+	rec.upstreamStart = time.Now()
+}
+
+// attemptFinished notes the upstream (or proxy error) status of the last attempt.
+func (rec *accessRecord) attemptFinished(status int) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.upstreamStatus = status
+	if !rec.upstreamStart.IsZero() {
+		rec.upstream = time.Since(rec.upstreamStart)
+	}
+}
+
+// accessResponseWriter records the status code of the response sent to the client.
+type accessResponseWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+	rec *accessRecord
+}
+
+func (w *accessResponseWriter) WriteHeader(statusCode int) {
+	w.rec.mu.Lock()
+	if w.rec.status == 0 {
+		w.rec.status = statusCode
+	}
+	w.rec.mu.Unlock()
+	w.ResponseWriterWrapper.WriteHeader(statusCode)
+}
+
+func (w *accessResponseWriter) Write(p []byte) (int, error) {
+	w.rec.mu.Lock()
+	if w.rec.status == 0 {
+		w.rec.status = http.StatusOK
+	}
+	w.rec.mu.Unlock()
+	return w.ResponseWriterWrapper.Write(p)
+}
+
+// logAccess writes the access log record of a finished request.
+func (cr *AICoreRouter) logAccess(ctx context.Context, rec *accessRecord, tracker *usageTracker) {
+	if rec == nil || cr.AccessLog == "" {
+		return
+	}
+	level := zapcore.InfoLevel
+	if cr.AccessLog == "debug" {
+		level = zapcore.DebugLevel
+	}
+	logger := cr.requestLogger(ctx).Named("access")
+	if ce := logger.Check(level, "Handled inference request"); ce != nil {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		total := time.Since(rec.start)
+		fields := []zap.Field{
+			zap.String("requested_model", rec.requestedModel),
+			zap.String("model", rec.model),
+			zap.String("provider", rec.provider),
+			zap.Int("status", rec.status),
+			zap.Int("upstream_status", rec.upstreamStatus),
+			zap.Int("attempts", rec.attempts),
+			zap.Int("retries", max(rec.attempts-1, 0)),
+			zap.Duration("queue_wait", rec.queueWait),
+			zap.Duration("upstream", rec.upstream),
+			zap.Duration("total", total),
+		}
+		if tags := tagsFrom(ctx); len(tags) > 0 {
+			fields = append(fields, zap.String("tags", tags.String()))
+		}
+		if tracker != nil {
+			promptTokens, completionTokens, estimated := tracker.snapshot()
+			fields = append(fields,
+				zap.Int("prompt_tokens", promptTokens),
+				zap.Int("completion_tokens", completionTokens),
+				zap.Bool("tokens_estimated", estimated),
+			)
+		}
+		ce.Write(fields...)
+	}
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// UnknownParamsHeader lists the top-level fields of a chat request that aren't chat completions
+// parameters the router knows. They are sent to the provider as is; the header helps clients
+// spot misspelled parameters, which providers often ignore silently.
+const UnknownParamsHeader = "X-AI-Unknown-Params"
+
+// JSON types a request field may take.
+type jsonType int
+
+const (
+	jsonString jsonType = 1 << iota
+	jsonNumber
+	jsonInteger
+	jsonBool
+	jsonObject
+	jsonArray
+)
+
+func (t jsonType) String() string {
+	var names []string
+	for _, kind := range []struct {
+		t    jsonType
+		name string
+	}{{jsonString, "a string"}, {jsonNumber, "a number"}, {jsonInteger, "an integer"}, {jsonBool, "a boolean"}, {jsonObject, "an object"}, {jsonArray, "an array"}} {
+		if t&kind.t != 0 {
+			names = append(names, kind.name)
+		}
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// chatRequestSchema is the type of each chat completions parameter the router knows, OpenAI's
+// and its own. null is accepted for all of them but model and messages.
+var chatRequestSchema = map[string]jsonType{
+	"model":                 jsonString,
+	"messages":              jsonArray,
+	"stream":                jsonBool,
+	"stream_options":        jsonObject,
+	"max_tokens":            jsonInteger,
+	"max_completion_tokens": jsonInteger,
+	"temperature":           jsonNumber,
+	"top_p":                 jsonNumber,
+	"n":                     jsonInteger,
+	"stop":                  jsonString | jsonArray,
+	"presence_penalty":      jsonNumber,
+	"frequency_penalty":     jsonNumber,
+	"logit_bias":            jsonObject,
+	"logprobs":              jsonBool,
+	"top_logprobs":          jsonInteger,
+	"seed":                  jsonInteger,
+	"user":                  jsonString,
+	"tools":                 jsonArray,
+	"tool_choice":           jsonString | jsonObject,
+	"parallel_tool_calls":   jsonBool,
+	"functions":             jsonArray,
+	"function_call":         jsonString | jsonObject,
+	"response_format":       jsonObject,
+	"reasoning_effort":      jsonString,
+	"metadata":              jsonObject,
+	"store":                 jsonBool,
+	"modalities":            jsonArray,
+	"audio":                 jsonObject,
+	"prediction":            jsonObject,
+	"service_tier":          jsonString,
+	"web_search_options":    jsonObject,
+	// The router's own: conversation history and the pinned provider field
+	"conversation_id": jsonString,
+	"provider":        jsonString | jsonObject,
+}
+
+// chatMessageRoles are the roles a chat message may have.
+var chatMessageRoles = []string{"system", "developer", "user", "assistant", "tool", "function"}
+
+// fieldError is a problem with one field of a request, by its path (e.g. messages[1].role).
+type fieldError struct {
+	field   string
+	problem string
+}
+
+func (e fieldError) String() string {
+	return e.field + ": " + e.problem
+}
+
+// typeOf returns the JSON type of a decoded value, 0 for null.
+func typeOf(value any) jsonType {
+	switch v := value.(type) {
+	case string:
+		return jsonString
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return jsonInteger | jsonNumber
+		}
+		return jsonNumber
+	case bool:
+		return jsonBool
+	case map[string]any:
+		return jsonObject
+	case []any:
+		return jsonArray
+	}
+	return 0
+}
+
+// checkChatRequest checks a chat request against the chat completions schema: field types,
+// a non-empty messages array and message roles. It returns the problems found and the unknown
+// top-level fields, or an error if the body isn't a JSON object.
+func checkChatRequest(body []byte) (problems []fieldError, unknown []string, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var req map[string]any
+	if err := decoder.Decode(&req); err != nil {
+		return nil, nil, err
+	}
+	if req == nil {
+		return nil, nil, errors.New("request body must be a JSON object")
+	}
+
+	fields := make([]string, 0, len(req))
+	for field := range req {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		value := req[field]
+		want, ok := chatRequestSchema[field]
+		if !ok {
+			unknown = append(unknown, field)
+			continue
+		}
+		if value == nil && field != "model" && field != "messages" {
+			continue
+		}
+		if typeOf(value)&want == 0 {
+			problems = append(problems, fieldError{field, fmt.Sprintf("must be %s, got %s", want, describeJSON(value))})
+		}
+	}
+	if messages, ok := req["messages"].([]any); ok {
+		if len(messages) == 0 {
+			problems = append(problems, fieldError{"messages", "must contain at least one message"})
+		}
+		for i, message := range messages {
+			problems = append(problems, checkChatMessage(fmt.Sprintf("messages[%d]", i), message)...)
+		}
+	} else if _, ok := req["messages"]; !ok {
+		problems = append(problems, fieldError{"messages", "is required"})
+	}
+	return problems, unknown, nil
+}
+
+// checkChatMessage checks one message of a chat request.
+func checkChatMessage(path string, value any) []fieldError {
+	message, ok := value.(map[string]any)
+	if !ok {
+		return []fieldError{{path, "must be an object, got " + describeJSON(value)}}
+	}
+	var problems []fieldError
+	role, ok := message["role"].(string)
+	switch {
+	case message["role"] == nil:
+		problems = append(problems, fieldError{path + ".role", "is required"})
+	case !ok:
+		problems = append(problems, fieldError{path + ".role", "must be a string, got " + describeJSON(message["role"])})
+	default:
+		known := false
+		for _, r := range chatMessageRoles {
+			known = known || role == r
+		}
+		if !known {
+			problems = append(problems, fieldError{path + ".role", fmt.Sprintf("must be one of %s, got %q", strings.Join(chatMessageRoles, ", "), role)})
+		}
+	}
+
+	content, hasContent := message["content"]
+	switch typeOf(content) {
+	case jsonString:
+	case jsonArray:
+		for i, part := range content.([]any) {
+			partPath := fmt.Sprintf("%s.content[%d]", path, i)
+			if p, ok := part.(map[string]any); !ok {
+				problems = append(problems, fieldError{partPath, "must be an object, got " + describeJSON(part)})
+			} else if _, ok := p["type"].(string); !ok {
+				problems = append(problems, fieldError{partPath + ".type", "is required"})
+			}
+		}
+	case 0:
+		// Assistant messages that only call tools have no content
+		if role != "assistant" {
+			if hasContent {
+				problems = append(problems, fieldError{path + ".content", "must not be null"})
+			} else {
+				problems = append(problems, fieldError{path + ".content", "is required"})
+			}
+		}
+	default:
+		problems = append(problems, fieldError{path + ".content", "must be a string or an array of content parts, got " + describeJSON(content)})
+	}
+
+	if role == "tool" {
+		if _, ok := message["tool_call_id"].(string); !ok {
+			problems = append(problems, fieldError{path + ".tool_call_id", "is required for tool messages"})
+		}
+	}
+	if name, ok := message["name"]; ok && name != nil && typeOf(name) != jsonString {
+		problems = append(problems, fieldError{path + ".name", "must be a string, got " + describeJSON(name)})
+	}
+	if toolCalls, ok := message["tool_calls"]; ok && toolCalls != nil && typeOf(toolCalls) != jsonArray {
+		problems = append(problems, fieldError{path + ".tool_calls", "must be an array, got " + describeJSON(toolCalls)})
+	}
+	return problems
+}
+
+// describeJSON names the type of a decoded value for error messages.
+func describeJSON(value any) string {
+	switch t := typeOf(value); {
+	case t == 0:
+		return "null"
+	case t&jsonInteger != 0:
+		return "a number"
+	default:
+		return t.String()
+	}
+}
+
+// validateChatRequest rejects chat requests that don't match the schema with a 400 listing the
+// offending fields, and reports unknown fields in UnknownParamsHeader. On rejection it writes
+// the error and returns a non-nil error.
+func (cr *AICoreRouter) validateChatRequest(w http.ResponseWriter, r *http.Request, body []byte) error {
+	problems, unknown, err := checkChatRequest(body)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request body: "+describeJSONError(body, err))
+		return err
+	}
+	if len(unknown) > 0 {
+		w.Header().Set(UnknownParamsHeader, strings.Join(unknown, ", "))
+		cr.requestLogger(r.Context()).Debug("Request has unknown parameters, sending them as is", zap.Strings("params", unknown))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	messages := make([]string, len(problems))
+	for i, problem := range problems {
+		messages[i] = problem.String()
+	}
+	message := "Invalid request: " + strings.Join(messages, "; ")
+	writeOpenAIParamError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_request_body", problems[0].field, message)
+	return errors.New(message)
+}
+
+// describeJSONError says where a JSON body stops parsing.
+func describeJSONError(body []byte, err error) string {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column := 1, 1
+		// Offset is just past the offending byte
+		for _, b := range body[:min(max(int(syntaxErr.Offset)-1, 0), len(body))] {
+			if b == '\n' {
+				line, column = line+1, 1
+			} else {
+				column++
+			}
+		}
+		return fmt.Sprintf("%v (line %d, column %d)", err, line, column)
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return "the body ends before the JSON value does"
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return "request body must be a JSON object"
+	}
+	return err.Error()
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// RerankUsageContextKeyString carries the *rerankUsage a rerank response is accounted into.
+const RerankUsageContextKeyString string = "ai_rerank_usage"
+
+func init() {
+	caddy.RegisterModule(RerankHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_rerank", parseRerankHandlerCaddyfile)
+}
+
+// RerankHandler serves a Cohere/Jina-style rerank API (POST /v1/rerank) under any path,
+// routed to providers that can rerank documents.
+type RerankHandler struct {
+	Router string `json:"router,omitempty"`
+	// Maximum request body size in bytes (0 = unlimited)
+	MaxRequestSize int64 `json:"max_request_size,omitempty"`
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (RerankHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_rerank",
+		New: func() caddy.Module { return new(RerankHandler) },
+	}
+}
+
+func (h *RerankHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	h.routers, err = routerScopeOf(ctx)
+	return err
+}
+
+func (h *RerankHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_rerank: router '%s' not found", routerName))
+		return nil
+	}
+	defer cr.track()()
+
+	cr.firePageviewEvent(r)
+
+	if r.Method != http.MethodPost {
+		return next.ServeHTTP(w, r)
+	}
+	return cr.handleRerankRequest(w, r, next, cr.apiKeyServiceFor(r), h.MaxRequestSize)
+}
+
+// supportsRerank reports whether a provider can rerank documents.
+func supportsRerank(p *ProviderConfig) bool {
+	_, ok := p.Provider.(providers.RerankProvider)
+	return ok
+}
+
+// rerankUsage accumulates what a rerank call consumed. Providers that report no usage
+// are accounted with the locally counted tokens of the query and documents.
+type rerankUsage struct {
+	estimatedTokens int
+	totalTokens     int
+	searchUnits     int
+	results         int
+	reported        bool
+}
+
+// trackRerankUsage reads usage from a normalized rerank response.
+func (cr *AICoreRouter) trackRerankUsage(resp *http.Response) {
+	usage, ok := resp.Request.Context().Value(RerankUsageContextKeyString).(*rerankUsage)
+	if !ok || usage == nil || resp.StatusCode != http.StatusOK {
+		return
+	}
+	common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+		var rerankResp transforms.UnifiedRerankResponse
+		if err := json.Unmarshal(body, &rerankResp); err != nil {
+			cr.logger.Warn("Failed to read usage from rerank response", zap.Error(err))
+			return body, nil
+		}
+		usage.results = len(rerankResp.Results)
+		if rerankResp.Usage != nil && (rerankResp.Usage.TotalTokens > 0 || rerankResp.Usage.SearchUnits > 0) {
+			usage.totalTokens = rerankResp.Usage.TotalTokens
+			usage.searchUnits = rerankResp.Usage.SearchUnits
+			usage.reported = true
+		}
+		return body, nil
+	})
+}
+
+// handleRerankRequest resolves a rerank request to a provider and proxies it.
+func (cr *AICoreRouter) handleRerankRequest(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, apiKeyService auth.ExternalAPIKeyProvider, maxRequestSize int64) error {
+	r = withRequestID(w, r)
+	logger := cr.requestLogger(r.Context())
+	userID, _ := r.Context().Value(UserIDContextKeyString).(string)
+	apiKeyID, _ := r.Context().Value(ApiKeyIDContextKeyString).(string)
+
+	if maxRequestSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	}
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, ErrorTypeInvalidRequest, "request_too_large",
+				fmt.Sprintf("Request body exceeds the maximum allowed size of %d bytes", maxBytesErr.Limit))
+			return err
+		}
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Failed to read request body")
+		return err
+	}
+	r.Body.Close()
+
+	var rerankReq transforms.UnifiedRerankRequest
+	if err := json.Unmarshal(bodyBytes, &rerankReq); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request body")
+		return err
+	}
+	if rerankReq.Model == "" || rerankReq.Query == "" || len(rerankReq.Documents) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "missing_required_parameter", "'model', 'query' and 'documents' fields are required in JSON request body")
+		return fmt.Errorf("'model', 'query' and 'documents' fields are required")
+	}
+	if rerankReq.TopN != nil && *rerankReq.TopN <= 0 {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_value", "'top_n' must be a positive integer")
+		return fmt.Errorf("invalid top_n %d", *rerankReq.TopN)
+	}
+
+	providerName, actualModelName, err := cr.resolveRoute(w, r, rerankReq.Model, "", apiKeyService, userID, supportsRerank)
+	if err != nil {
+		return err
+	}
+	cr.mu.RLock()
+	providerConfig, ok := cr.Providers[providerName]
+	cr.mu.RUnlock()
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Internal server error: provider configuration missing")
+		return fmt.Errorf("internal: provider %s not found post-resolution", providerName)
+	}
+	if !supportsRerank(providerConfig) {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "unsupported_model",
+			fmt.Sprintf("Provider %s does not support reranking", providerName))
+		return fmt.Errorf("provider %s does not support reranking", providerName)
+	}
+
+	apiKey, err := cr.upstreamAPIKey(w, r, apiKeyService, providerConfig, userID)
+	if err != nil {
+		return err
+	}
+
+	usage := &rerankUsage{estimatedTokens: cr.tokenizer.Count(rerankReq.Query)}
+	for _, doc := range rerankReq.Documents {
+		usage.estimatedTokens += cr.tokenizer.Count(transforms.RerankDocumentText(doc))
+	}
+
+	reqCtx := r.Context()
+	reqCtx = context.WithValue(reqCtx, ProviderNameContextKeyString, providerName)
+	reqCtx = context.WithValue(reqCtx, ActualModelNameContextKeyString, actualModelName)
+	reqCtx = context.WithValue(reqCtx, ExternalAPIKeyProviderContextKeyString, apiKey)
+	reqCtx = context.WithValue(reqCtx, common.StreamContextKeyString, false)
+	reqCtx = context.WithValue(reqCtx, common.RequestKindContextKeyString, common.RequestKindRerank)
+	reqCtx = context.WithValue(reqCtx, RerankUsageContextKeyString, usage)
+	r = r.WithContext(reqCtx)
+	r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	r.ContentLength = int64(len(bodyBytes))
+	r.Header.Set("Authorization", "Bearer "+apiKey)
+
+	if providerConfig.limiter != nil {
+		if _, err := providerConfig.limiter.acquire(reqCtx, cr.requestPriority(r)); err != nil {
+			if reqCtx.Err() != nil {
+				return nil
+			}
+			writeProviderOverloaded(w, providerConfig, err)
+			return err
+		}
+		defer providerConfig.limiter.release()
+	}
+
+	logger.Info("Routing rerank request",
+		zap.String("original_model", rerankReq.Model),
+		zap.String("provider", providerName),
+		zap.String("actual_model", actualModelName),
+		zap.Int("documents", len(rerankReq.Documents)),
+		zap.String("user_id", userID),
+	)
+
+	start := common.CaddyClock.Now()
+	defer func() {
+		totalTokens := usage.totalTokens
+		if !usage.reported {
+			totalTokens = usage.estimatedTokens
+		}
+		cr.observability.Fire(userID, "", "rerank_stop", map[string]any{
+			"$ip":              r.RemoteAddr,
+			"model":            rerankReq.Model,
+			"provider":         providerName,
+			"documents":        len(rerankReq.Documents),
+			"results":          usage.results,
+			"total_tokens":     totalTokens,
+			"search_units":     usage.searchUnits,
+			"tokens_estimated": !usage.reported,
+			"duration_ms":      common.CaddyClock.Now().Sub(start).Milliseconds(),
+			"user_id":          userID,
+			"api_key_id":       apiKeyID,
+			"request_id":       requestID(r.Context()),
+		})
+	}()
+
+	providerConfig.proxy.ServeHTTP(w, r)
+
+	if reqCtx.Err() != nil {
+		return nil
+	}
+	return next.ServeHTTP(w, r)
+}
+
+func parseRerankHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var rh RerankHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				rh.Router = h.Val()
+			case "max_request_size":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				size, err := humanize.ParseBytes(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid max_request_size '%s': %v", h.Val(), err)
+				}
+				rh.MaxRequestSize = int64(size)
+			default:
+				return nil, h.Errf("unrecognized ai_rerank option '%s'", h.Val())
+			}
+		}
+	}
+	return &rh, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*RerankHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*RerankHandler)(nil)
+)
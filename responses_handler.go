@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(ResponsesHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_responses", parseResponsesHandlerCaddyfile)
+}
+
+// ResponsesHandler serves the OpenAI Responses API under any path. Requests are converted to
+// the unified (chat completions) format and routed like chat completions; providers that support
+// the Responses API natively receive the original request instead, and their responses are
+// passed through unchanged.
+type ResponsesHandler struct {
+	Router string `json:"router,omitempty"`
+	RouteOptions
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (ResponsesHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_responses",
+		New: func() caddy.Module { return new(ResponsesHandler) },
+	}
+}
+
+func (h *ResponsesHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	if h.routers, err = routerScopeOf(ctx); err != nil {
+		return err
+	}
+	if err := h.RouteOptions.provision(h.logger); err != nil {
+		return fmt.Errorf("ai_responses: %v", err)
+	}
+	return nil
+}
+
+func (h *ResponsesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	codec := &responsesIngressCodec{logger: h.logger, passthroughState: &common.ResponsesPassthrough{}}
+
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(codec.TransformError(http.StatusInternalServerError, openAIErrorBody(ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_responses: router '%s' not found", routerName))))
+		return nil
+	}
+	defer cr.track()()
+
+	cr.firePageviewEvent(r)
+
+	if r.Method != http.MethodPost {
+		return next.ServeHTTP(w, r)
+	}
+
+	iw := newIngressResponseWriter(w, codec)
+	defer iw.finish()
+
+	if h.MaxRequestSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxRequestSize)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeOpenAIError(iw, http.StatusRequestEntityTooLarge, ErrorTypeInvalidRequest, "request_too_large",
+				fmt.Sprintf("Request body exceeds the maximum allowed size of %d bytes", maxBytesErr.Limit))
+			return err
+		}
+		writeOpenAIError(iw, http.StatusInternalServerError, ErrorTypeAPI, "", "Failed to read request body")
+		return err
+	}
+	r.Body.Close()
+
+	unifiedBody, err := transforms.TransformResponsesRequestToUnified(body, h.logger)
+	if err != nil {
+		writeOpenAIError(iw, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid Responses API request body")
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(unifiedBody))
+	r.ContentLength = int64(len(unifiedBody))
+	codec.passthroughState.Body = body
+	r = r.WithContext(context.WithValue(r.Context(), common.ResponsesPassthroughContextKeyString, codec.passthroughState))
+
+	return cr.handlePostInferenceRequest(iw, r, next, cr.apiKeyServiceFor(r), &h.RouteOptions)
+}
+
+// responsesIngressCodec converts unified responses back to the Responses API format.
+type responsesIngressCodec struct {
+	logger           *zap.Logger
+	encoder          transforms.ResponsesStreamEncoder
+	passthroughState *common.ResponsesPassthrough
+}
+
+func (c *responsesIngressCodec) TransformResponse(body []byte) ([]byte, error) {
+	return transforms.TransformUnifiedResponseToResponses(body, c.logger)
+}
+
+// Responses API errors share the OpenAI error envelope.
+func (c *responsesIngressCodec) TransformError(statusCode int, body []byte) []byte {
+	return body
+}
+
+func (c *responsesIngressCodec) TransformChunk(data []byte) ([]byte, error) {
+	return c.encoder.Encode(data)
+}
+
+func (c *responsesIngressCodec) FinishStream() []byte {
+	return c.encoder.Finish()
+}
+
+func (c *responsesIngressCodec) StreamContentType() string {
+	return "text/event-stream"
+}
+
+func (c *responsesIngressCodec) passthrough() bool {
+	return c.passthroughState.Native
+}
+
+func parseResponsesHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var rh ResponsesHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				rh.Router = h.Val()
+			default:
+				if ok, err := rh.RouteOptions.unmarshalCaddyfileOption(h.Dispenser); err != nil {
+					return nil, err
+				} else if !ok {
+					return nil, h.Errf("unrecognized ai_responses option '%s'", h.Val())
+				}
+			}
+		}
+	}
+	return &rh, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*ResponsesHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*ResponsesHandler)(nil)
+)
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// RetriesHeader tells the client how many times a request was retried upstream.
+const RetriesHeader = "X-AI-Retries"
+
+// Defaults for retries on transient upstream errors.
+const (
+	defaultRetryBackoff    = 250 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+	defaultRetryDeadline   = 30 * time.Second
+)
+
+// 502 also covers connection errors, which the proxy answers with a 502; 529 is Anthropic's overloaded_error.
+var defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, 529}
+
+// RetryConfig retries non-streaming completions on transient upstream errors. Attempts are
+// buffered, so the client only sees the final one; streams are never retried once started.
+type RetryConfig struct {
+	// Retries after the first attempt (0 disables retries)
+	Attempts int `json:"attempts,omitempty"`
+	// Delay before the first retry, doubled for each further one up to MaxBackoff
+	Backoff    caddy.Duration `json:"backoff,omitempty"`
+	MaxBackoff caddy.Duration `json:"max_backoff,omitempty"`
+	// Cap on the total time spent on a request, retries included
+	Deadline caddy.Duration `json:"deadline,omitempty"`
+	// Upstream statuses that are retried (defaults to 502, 503, 504 and 529)
+	Statuses []int `json:"statuses,omitempty"`
+}
+
+func (c *RetryConfig) enabled() bool {
+	return c != nil && c.Attempts > 0
+}
+
+func (c *RetryConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Attempts < 0 {
+		return fmt.Errorf("retry attempts must not be negative")
+	}
+	if c.MaxBackoff > 0 && c.Backoff > c.MaxBackoff {
+		return fmt.Errorf("retry backoff exceeds max_backoff")
+	}
+	return nil
+}
+
+func (c *RetryConfig) deadline() time.Duration {
+	if c.Deadline > 0 {
+		return time.Duration(c.Deadline)
+	}
+	return defaultRetryDeadline
+}
+
+// delay is the wait before retry n (0-based): exponential backoff with jitter over its upper half.
+func (c *RetryConfig) delay(n int) time.Duration {
+	base, ceiling := defaultRetryBackoff, defaultRetryMaxBackoff
+	if c.Backoff > 0 {
+		base = time.Duration(c.Backoff)
+	}
+	if c.MaxBackoff > 0 {
+		ceiling = time.Duration(c.MaxBackoff)
+	}
+	d := base << min(n, 16)
+	if d <= 0 || d > ceiling {
+		d = ceiling
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryReason returns why a buffered upstream response is worth retrying, or "" if it isn't.
+func (c *RetryConfig) retryReason(resp *bufferedResponse) string {
+	statuses := c.Statuses
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	for _, status := range statuses {
+		if resp.status == status {
+			return strconv.Itoa(status)
+		}
+	}
+	if resp.status >= 500 && strings.Contains(strings.ToLower(upstreamErrorMessage(resp.body.Bytes())), "overloaded") {
+		return "overloaded"
+	}
+	return ""
+}
+
+// bufferedResponse holds an upstream attempt until it is known whether it will be retried.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// writeTo sends the buffered response to the client.
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for name, values := range b.header {
+		w.Header()[name] = values
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
+
+// proxyWithRetry proxies a non-streaming request, retrying transient upstream errors with
+// backoff while attempts are left and the next one would start before the deadline, and
+// sends the client the final attempt.
+// The request ID doubles as Idempotency-Key, so providers that honour it can deduplicate.
+func (cr *AICoreRouter) proxyWithRetry(w http.ResponseWriter, r *http.Request, p *ProviderConfig, body []byte) {
+	logger := cr.requestLogger(r.Context())
+	if r.Header.Get("Idempotency-Key") == "" {
+		r.Header.Set("Idempotency-Key", requestID(r.Context()))
+	}
+	deadline := time.Now().Add(cr.Retry.deadline())
+
+	for retries := 0; ; retries++ {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		resp := &bufferedResponse{header: make(http.Header)}
+		p.proxy.ServeHTTP(resp, r)
+
+		reason := cr.Retry.retryReason(resp)
+		retry := reason != "" && retries < cr.Retry.Attempts && r.Context().Err() == nil
+		var wait time.Duration
+		if retry {
+			wait = cr.Retry.delay(retries)
+			if retryAfter, ok := parseRateLimitReset("Retry-After", resp.header.Get("Retry-After"), time.Now()); ok && retryAfter > wait {
+				wait = retryAfter
+			}
+			if time.Now().Add(wait).After(deadline) {
+				logger.Debug("Not retrying upstream error, deadline would be exceeded",
+					zap.String("provider", p.Name),
+					zap.String("reason", reason),
+					zap.Int("retries", retries),
+				)
+				retry = false
+			}
+		}
+		if !retry {
+			if retries > 0 {
+				w.Header().Set(RetriesHeader, strconv.Itoa(retries))
+			}
+			resp.writeTo(w)
+			return
+		}
+
+		upstreamRetries.WithLabelValues(cr.Name, p.Name, reason).Inc()
+		logger.Info("Retrying transient upstream error",
+			zap.String("provider", p.Name),
+			zap.String("reason", reason),
+			zap.Int("status_code", resp.status),
+			zap.Int("retry", retries+1),
+			zap.Duration("backoff", wait),
+		)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return // Client is gone
+		}
+	}
+}
+
+// parseRetryCaddyfile parses a `retry <attempts> { ... }` block.
+func parseRetryCaddyfile(d *caddyfile.Dispenser) (*RetryConfig, error) {
+	cfg := &RetryConfig{}
+	if d.NextArg() {
+		attempts, err := strconv.Atoi(d.Val())
+		if err != nil {
+			return nil, d.Errf("invalid retry attempts '%s'", d.Val())
+		}
+		cfg.Attempts = attempts
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "attempts":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			attempts, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid retry attempts '%s'", d.Val())
+			}
+			cfg.Attempts = attempts
+		case "backoff":
+			args := d.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return nil, d.Errf("backoff expects <initial> [<max>]")
+			}
+			for i, arg := range args {
+				dur, err := caddy.ParseDuration(arg)
+				if err != nil {
+					return nil, d.Errf("invalid retry backoff '%s': %v", arg, err)
+				}
+				if i == 0 {
+					cfg.Backoff = caddy.Duration(dur)
+				} else {
+					cfg.MaxBackoff = caddy.Duration(dur)
+				}
+			}
+		case "deadline":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid retry deadline '%s': %v", d.Val(), err)
+			}
+			cfg.Deadline = caddy.Duration(dur)
+		case "status":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			for _, arg := range args {
+				status, err := strconv.Atoi(arg)
+				if err != nil || status < 400 || status > 599 {
+					return nil, d.Errf("invalid retry status '%s'", arg)
+				}
+				cfg.Statuses = append(cfg.Statuses, status)
+			}
+		default:
+			return nil, d.Errf("unrecognized retry option '%s'", d.Val())
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return cfg, nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/dustin/go-humanize"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/guardrails"
+	"go.uber.org/zap"
+)
+
+// RouteOptions holds per-route request policy for inference endpoint handlers.
+type RouteOptions struct {
+	// Optional external moderation applied to requests (and responses) on this route
+	Moderation *guardrails.ModerationConfig `json:"moderation,omitempty"`
+	// Maximum request body size in bytes (0 = unlimited)
+	MaxRequestSize int64 `json:"max_request_size,omitempty"`
+	// Maximum number of messages in a request (0 = unlimited)
+	MaxMessages int `json:"max_messages,omitempty"`
+	// Maximum estimated prompt tokens in a request (0 = unlimited)
+	MaxPromptTokens int `json:"max_prompt_tokens,omitempty"`
+	// Request parameters filled in when the client doesn't send them
+	Defaults map[string]json.RawMessage `json:"defaults,omitempty"`
+	// Parameter clamping and stripping enforced on every request
+	Limits *ParamLimits `json:"limits,omitempty"`
+	// Operator text injected into the system prompt of every request
+	SystemPrompt *SystemPromptConfig `json:"system_prompt,omitempty"`
+	// Idle time after which streams get an SSE keep-alive comment (0 = off)
+	Heartbeat caddy.Duration `json:"heartbeat,omitempty"`
+	// Remove the reasoning of thinking models from responses
+	StripReasoning bool `json:"strip_reasoning,omitempty"`
+
+	moderator *guardrails.Moderator
+}
+
+// provision prepares runtime helpers for the configured options.
+func (o *RouteOptions) provision(logger *zap.Logger) error {
+	if o.Moderation != nil {
+		moderator, err := guardrails.NewModerator(*o.Moderation, logger)
+		if err != nil {
+			return err
+		}
+		o.moderator = moderator
+	}
+	return nil
+}
+
+// unmarshalCaddyfileOption parses a single route option at the dispenser's current token.
+// It returns false if the option is not a route option.
+func (o *RouteOptions) unmarshalCaddyfileOption(d *caddyfile.Dispenser) (bool, error) {
+	switch d.Val() {
+	case "moderation":
+		cfg, err := parseModerationCaddyfile(d)
+		if err != nil {
+			return true, err
+		}
+		o.Moderation = cfg
+	case "max_request_size":
+		if !d.NextArg() {
+			return true, d.ArgErr()
+		}
+		size, err := humanize.ParseBytes(d.Val())
+		if err != nil {
+			return true, d.Errf("invalid max_request_size '%s': %v", d.Val(), err)
+		}
+		o.MaxRequestSize = int64(size)
+	case "max_messages":
+		if !d.NextArg() {
+			return true, d.ArgErr()
+		}
+		n, err := strconv.Atoi(d.Val())
+		if err != nil || n < 0 {
+			return true, d.Errf("invalid max_messages '%s'", d.Val())
+		}
+		o.MaxMessages = n
+	case "max_prompt_tokens":
+		if !d.NextArg() {
+			return true, d.ArgErr()
+		}
+		n, err := strconv.Atoi(d.Val())
+		if err != nil || n < 0 {
+			return true, d.Errf("invalid max_prompt_tokens '%s'", d.Val())
+		}
+		o.MaxPromptTokens = n
+	case "defaults":
+		defaults, err := parseDefaultsCaddyfile(d)
+		if err != nil {
+			return true, err
+		}
+		o.Defaults = defaults
+	case "limits":
+		limits, err := parseLimitsCaddyfile(d)
+		if err != nil {
+			return true, err
+		}
+		o.Limits = limits
+	case "system_prompt":
+		cfg, err := parseSystemPromptCaddyfile(d)
+		if err != nil {
+			return true, err
+		}
+		o.SystemPrompt = cfg
+	case "heartbeat":
+		if !d.NextArg() {
+			return true, d.ArgErr()
+		}
+		interval, err := caddy.ParseDuration(d.Val())
+		if err != nil || interval <= 0 {
+			return true, d.Errf("invalid heartbeat interval '%s'", d.Val())
+		}
+		o.Heartbeat = caddy.Duration(interval)
+	case "strip_reasoning":
+		if d.NextArg() {
+			return true, d.ArgErr()
+		}
+		o.StripReasoning = true
+	default:
+		return false, nil
+	}
+	return true, nil
+}
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,9 +17,12 @@ import (
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/headers"
 	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
 	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
 	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/storage"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/tokenizer"
 	"go.uber.org/zap"
 )
 
@@ -45,21 +52,204 @@ type AICoreRouter struct {
 	Providers               map[string]*ProviderConfig `json:"providers,omitempty"`
 	DefaultProviderForModel map[string][]string        `json:"default_provider_for_model,omitempty"`
 	ProviderOrder           []string                   `json:"provider_order,omitempty"`
+	// Ordered routing rules; the first one matching a request picks its providers and model
+	Rules []*RoutingRule `json:"rules,omitempty"`
+	// How a provider is picked among several configured for a model: failover (default), weighted or latency_aware
+	Strategy string `json:"strategy,omitempty"`
+	// Tuning for the latency_aware strategy
+	LatencyAware *LatencyAwareConfig `json:"latency_aware,omitempty"`
+	// Conversation identifier sources ("header", "user", "system") for sticky provider selection
+	StickyRouting []string `json:"sticky_routing,omitempty"`
+	// How long discovered provider models (and fuzzy resolutions) stay fresh
+	ModelsCacheTTL caddy.Duration `json:"models_cache_ttl,omitempty"`
+	// How long a failed models discovery is remembered before retrying
+	ModelsCacheNegativeTTL caddy.Duration `json:"models_cache_negative_ttl,omitempty"`
+	// How requested models without a provider prefix or default are matched against provider model lists
+	ModelMatching *ModelMatching `json:"model_matching,omitempty"`
+	// A/B experiments; the first one matching the requested model applies
+	Experiments []*Experiment `json:"experiments,omitempty"`
+	// Canary rollouts of new model versions; the first one for the requested model applies
+	Canaries []*Canary `json:"canaries,omitempty"`
+	// Backend for state shared across instances (model resolutions, discovered models, counters)
+	Storage *storage.Config `json:"storage,omitempty"`
+	// Token counting for synthesized usage and prompt limits: "heuristic" (default) or a tiktoken ranks file
+	Tokenizer string `json:"tokenizer,omitempty"`
+	// What happens when the resolved model lacks a capability the request uses: reroute (default), reject or off
+	CapabilityCheck string `json:"capability_check,omitempty"`
+	// What happens when a prompt exceeds the resolved model's context window (unset = capability check)
+	ContextOverflow *ContextOverflowConfig `json:"context_overflow,omitempty"`
+	// Server-side history for requests naming a conversation_id, kept in the router store
+	Conversations *ConversationConfig `json:"conversations,omitempty"`
+	// Level of the per-request access log record ("info" or "debug"); empty disables it
+	AccessLog string `json:"access_log,omitempty"`
+	// Process-wide observability sink; without it, PostHog is used if POSTHOG_API_KEY is set
+	Observability *common.ObservabilityConfig `json:"observability,omitempty"`
+	// Export of LLM traces (prompt, completion, tokens, cost, latency) to Langfuse or OTLP
+	Tracing *common.TracingConfig `json:"tracing,omitempty"`
+	// Retries of non-streaming completions on transient upstream errors
+	Retry *RetryConfig `json:"retry,omitempty"`
+	// Chains of models, usually on different providers, tried in turn when a model fails
+	Fallbacks []*FallbackChain `json:"fallbacks,omitempty"`
+	// Models raced against rivals on other providers, the first successful response winning
+	Races []*RaceConfig `json:"races,omitempty"`
+	// Check of non-streaming completions that retries empty or refused ones elsewhere
+	QualityGuard *QualityGuard `json:"quality_guard,omitempty"`
+	// Routes requests for virtual models like "auto" to a model picked by the task class of the prompt
+	RouteByClass *ClassRouting `json:"route_by_class,omitempty"`
+	// Models, providers and request rates allowed per user tier
+	Tiers *TierPolicy `json:"tiers,omitempty"`
+	// Glob patterns of the upstream models served (empty allows every model), and of those never served
+	AllowModels []string `json:"allow_models,omitempty"`
+	DenyModels  []string `json:"deny_models,omitempty"`
+	// Webhooks fired when the daily spend of a user or provider, or a provider's error rate, crosses a threshold
+	Alerts *AlertsConfig `json:"alerts,omitempty"`
+	// Keeps daily usage per user, API key, provider and model for the ai_usage endpoints
+	Usage *UsageConfig `json:"usage,omitempty"`
+	// Chargeback tag keys kept from X-AI-Tags and the metadata field; empty keeps every key
+	TagKeys []string `json:"tag_keys,omitempty"`
+	// ai.transforms modules run on unified requests, responses and stream chunks
+	TransformsRaw []json.RawMessage `json:"transforms,omitempty" caddy:"namespace=ai.transforms inline_key=transform"`
+	// Sensitive data ("secrets", "content") this router logs as is instead of redacted
+	Unredacted []string `json:"unredacted,omitempty"`
+	// Which upstream request and response bodies are logged; by default none are
+	LogBodies *BodyLogging `json:"log_bodies,omitempty"`
+	// Prefix of the environment variables upstream keys are read from, e.g. "TENANT_A_" for TENANT_A_OPENAI_API_KEY
+	APIKeyEnvPrefix string `json:"api_key_env_prefix,omitempty"`
+	// Header clients may send their priority class in (high, normal or low); unset ignores it
+	PriorityHeader string `json:"priority_header,omitempty"`
+	// Strips provider-identifying headers from responses and provider names from router headers
+	HideProvider *ProviderHiding `json:"hide_provider,omitempty"`
+	// Header clients may send a latency budget in; providers whose rolling p95 exceeds it are avoided
+	MaxLatencyHeader string `json:"max_latency_header,omitempty"`
+	// Header (or chat `provider` field) clients may pin a configured provider with; unset ignores both
+	ProviderHeader string `json:"provider_header,omitempty"`
+	// How long a router replaced by a config reload may keep serving in-flight requests (default 10m)
+	DrainTimeout caddy.Duration `json:"drain_timeout,omitempty"`
 
 	logger     *zap.Logger
-	mu         sync.RWMutex
+	mu         sync.RWMutex // Held to read Providers while serving; it's only written while the router is set up
 	httpClient *http.Client
 
-	knownModelsCache *sync.Map
+	store         storage.Store
+	tokenizer     tokenizer.Tokenizer
+	latency       *latencyTracker
+	modelsCache   *ModelsCache
+	tracer        *common.TraceExporter
+	observability *common.Observability // Sink of the router's events, nil if they are dropped
+	alerts        *alerter
+	warmer        *warmer
+
+	routingRules []*RoutingRule // Rules followed by the per-model defaults
+	modelAccess  modelAccess    // Compiled from AllowModels and DenyModels
+	transforms   []any          // Loaded from TransformsRaw
+
+	maintenanceCache maintenanceCache
+	canaryCache      canaryStateCache
+
+	version uint64       // Registry version, assigned on registration
+	scope   *routerScope // Of the config declaring the router; set by RouterApp for global routers
+	drain   routerDrain
 }
 
 type ProviderConfig struct {
 	Name       string `json:"-"`
 	APIBaseURL string `json:"api_base_url,omitempty"`
 	Style      string `json:"style,omitempty"`
-	Provider   providers.Provider
-	proxy      *httputil.ReverseProxy
-	parsedURL  *url.URL
+	// Configuration of the ai.providers module implementing a non-built-in style
+	StyleOptions json.RawMessage `json:"style_options,omitempty"`
+	// Relative share of sticky conversations routed to this provider (defaults to 1)
+	Weight float64 `json:"weight,omitempty"`
+	// Overrides the router-wide models_cache_ttl for this provider
+	ModelsCacheTTL caddy.Duration `json:"models_cache_ttl,omitempty"`
+	// Where the upstream key comes from: "gateway" (default) or "passthrough" (the client's own key)
+	KeyMode string `json:"key_mode,omitempty"`
+	// Upstream keys held in config; overrides the key service when set
+	APIKeys []string `json:"api_keys,omitempty"`
+	// How a key is picked when several are available: round_robin (default) or least_throttled
+	KeyRotation string `json:"key_rotation,omitempty"`
+	// Requests per minute each key may take before the pool prefers the others (0 = unlimited)
+	KeyRequestsPerMinute int `json:"key_requests_per_minute,omitempty"`
+	// Forward Responses API requests natively (openai style only; on by default for api.openai.com)
+	NativeResponses bool `json:"native_responses,omitempty"`
+	// How a conversation ending in an assistant message is sent: native (as it is, for servers
+	// that continue it) or emulate (asking the model to continue it); defaults to the style's
+	Prefill string `json:"prefill,omitempty"`
+	// Statically declared models, merged with live discovery
+	Models []ManifestModel `json:"models,omitempty"`
+	// Completion budget of chat requests that set none, and the largest one sent; a model's
+	// default_output and max_output in the manifest win over these
+	DefaultOutputTokens int `json:"default_output_tokens,omitempty"`
+	MaxOutputTokens     int `json:"max_output_tokens,omitempty"`
+	// Skip the provider's models endpoint and rely on the manifest only
+	DisableModelsDiscovery bool `json:"disable_models_discovery,omitempty"`
+	// Pass the provider's SSE streams on without buffering and repairing their JSON payloads
+	DisableSSERepair bool `json:"disable_sse_repair,omitempty"`
+	// Narrow the router-wide allow_models and deny_models for this provider
+	AllowModels []string `json:"allow_models,omitempty"`
+	DenyModels  []string `json:"deny_models,omitempty"`
+	// OpenAI-Organization and OpenAI-Project sent with every request to this provider
+	Organization string `json:"organization,omitempty"`
+	Project      string `json:"project,omitempty"`
+	// Workers AI account (cloudflare style); api_base_url defaults to the account's endpoint and
+	// may reference it as {account_id}
+	AccountID string `json:"account_id,omitempty"`
+	// AI Gateway the Workers AI requests go through (cloudflare style; requires account_id)
+	Gateway string `json:"gateway,omitempty"`
+	// Models sent to the OpenAI-compatible /v1 endpoints instead of /run (cloudflare style; globs)
+	OpenAICompatible []string `json:"openai_compatible,omitempty"`
+	// LoRA adapters applied to Workers AI models, by model (cloudflare style)
+	LoRA map[string]string `json:"lora,omitempty"`
+	// anthropic-version sent with every request, overriding the client's (anthropic style)
+	AnthropicVersion string `json:"anthropic_version,omitempty"`
+	// Beta features added to the anthropic-beta header of every request (anthropic style)
+	AnthropicBeta []string `json:"anthropic_beta,omitempty"`
+	// Canned response, delays and injected errors of a mock style provider
+	Mock *MockConfig `json:"mock,omitempty"`
+	// Periodic requests that keep the provider's models loaded (self-hosted providers)
+	WarmUp *WarmUp `json:"warm_up,omitempty"`
+	// Faults injected into this provider's responses; ignored unless AI_ROUTER_FAULT_INJECTION is set
+	Faults *FaultInjection `json:"faults,omitempty"`
+	// Directory to record upstream interactions to, or to replay them from instead of contacting the upstream
+	Record string `json:"record,omitempty"`
+	Replay string `json:"replay,omitempty"`
+	// Upstream path of chat completions requests, replacing the api_base_url path and the one the
+	// style builds; {model} and {stream} are filled in. Streams use StreamPathTemplate if set
+	PathTemplate       string `json:"path_template,omitempty"`
+	StreamPathTemplate string `json:"stream_path_template,omitempty"`
+	// Connection pool, timeouts, HTTP versions and compression of requests to this provider
+	Transport *UpstreamTransport `json:"transport,omitempty"`
+	// Client certificate, trusted CAs and server name for TLS to this provider
+	TLS *UpstreamTLS `json:"tls,omitempty"`
+	// Proxy to reach this provider through (http, https or socks5 URL, or "none" to ignore
+	// HTTP_PROXY and HTTPS_PROXY), and local IP address to connect from
+	ForwardProxy string `json:"forward_proxy,omitempty"`
+	LocalAddress string `json:"local_address,omitempty"`
+	// HMAC signature added to every request to this provider
+	SignRequests *RequestSigning `json:"sign_requests,omitempty"`
+	// Headers set on, and top-level fields merged into the body of, every transformed request
+	ExtraHeaders map[string]string          `json:"extra_headers,omitempty"`
+	ExtraBody    map[string]json.RawMessage `json:"extra_body,omitempty"`
+	// Header manipulations for requests to and responses from this provider
+	HeadersUp   *headers.HeaderOps `json:"headers_up,omitempty"`
+	HeadersDown *headers.HeaderOps `json:"headers_down,omitempty"`
+	// Cap on requests in flight to this provider (0 means unlimited)
+	MaxConcurrentRequests int `json:"max_concurrent_requests,omitempty"`
+	// How many requests may wait for a slot once the cap is reached, and for how long
+	QueueSize    int            `json:"queue_size,omitempty"`
+	QueueTimeout caddy.Duration `json:"queue_timeout,omitempty"`
+	// Keep the provider out of rotation, indefinitely or until a time (RFC 3339); the admin API
+	// can override both at runtime
+	Disabled    bool   `json:"disabled,omitempty"`
+	DrainUntil  string `json:"drain_until,omitempty"`
+	Provider    providers.Provider
+	proxy       *httputil.ReverseProxy
+	parsedURL   *url.URL
+	limiter     *concurrencyLimiter
+	client      *http.Client    // For model discovery, when it differs from the router's
+	transport   *http.Transport // Upstream connection settings, for realtime sessions' own dials
+	keys        *keyPool
+	modelAccess modelAccess
+	drainUntil  time.Time
 }
 
 func (*AICoreRouter) CaddyModule() caddy.ModuleInfo {
@@ -70,22 +260,66 @@ func (*AICoreRouter) CaddyModule() caddy.ModuleInfo {
 }
 
 func (cr *AICoreRouter) Provision(ctx caddy.Context) error {
-	cr.logger = ctx.Logger(cr)
+	redaction, err := common.NewRedactionPolicy(cr.Unredacted)
+	if err != nil {
+		return fmt.Errorf("unredacted: %v", err)
+	}
+	cr.logger = redaction.Logger(ctx.Logger(cr))
+	if cr.LogBodies != nil {
+		if err := cr.LogBodies.validate(); err != nil {
+			return err
+		}
+	}
 	cr.httpClient = &http.Client{Timeout: 15 * time.Second}
-	cr.knownModelsCache = &sync.Map{}
+	cr.modelsCache = newModelsCache(cr)
+	cr.latency = newLatencyTracker()
 	cr.mu.Lock()
 	defer cr.mu.Unlock()
 
 	if strings.TrimSpace(cr.Name) == "" {
 		cr.Name = "default"
 	}
+	if cr.scope == nil {
+		if cr.scope, err = routerScopeOf(ctx); err != nil {
+			return err
+		}
+	}
+	if err := cr.scope.add(cr); err != nil {
+		return err
+	}
+
+	store, err := storage.New(cr.Storage, cr.logger)
+	if err != nil {
+		return fmt.Errorf("storage: %v", err)
+	}
+	cr.store = store
+
+	tok, err := tokenizer.New(cr.Tokenizer, cr.logger)
+	if err != nil {
+		return err
+	}
+	cr.tokenizer = tok
 
-	if common.TryInstrumentAppObservability() {
+	if cr.Observability != nil {
+		if cr.observability, err = common.NewObservability(cr.Observability, cr.logger.Named("observability")); err != nil {
+			return fmt.Errorf("observability: %v", err)
+		}
+		cr.logger.Info("Observability sink configured", zap.String("sink", cr.Observability.Sink))
+	} else if cr.observability = common.NewDefaultObservability(); cr.observability != nil {
 		cr.logger.Info("PostHog observability instrumentation enabled")
 	} else {
 		cr.logger.Warn("Failed to initialize PostHog observability instrumentation, skipping")
 	}
 
+	if cr.Tracing != nil {
+		tracer, err := common.NewTraceExporter(cr.Tracing, cr.logger.Named("tracing"))
+		if err != nil {
+			return fmt.Errorf("tracing: %v", err)
+		}
+		cr.tracer = tracer
+		cr.logger.Info("LLM trace export enabled", zap.String("exporter", cr.Tracing.Exporter))
+	}
+
 	if cr.Providers == nil {
 		cr.Providers = make(map[string]*ProviderConfig)
 	}
@@ -93,27 +327,94 @@ func (cr *AICoreRouter) Provision(ctx caddy.Context) error {
 		cr.DefaultProviderForModel = make(map[string][]string)
 	}
 
+	cr.modelAccess = newModelAccess(cr.AllowModels, cr.DenyModels)
+	for i, key := range cr.TagKeys {
+		cr.TagKeys[i] = strings.ToLower(key)
+	}
 	for _, name := range cr.ProviderOrder {
 		p := cr.Providers[name]
 		p.Name = name
+		p.modelAccess = newModelAccess(p.AllowModels, p.DenyModels)
+		if p.drainUntil, err = parseDrainUntil(p.DrainUntil); err != nil {
+			return fmt.Errorf("provider %s: %v", name, err)
+		}
+		if err := p.resolveWorkersAIBaseURL(); err != nil {
+			return fmt.Errorf("provider %s: %v", name, err)
+		}
+		switch p.Prefill {
+		case "", PrefillNative, PrefillEmulate:
+		default:
+			return fmt.Errorf("provider %s: unknown prefill mode '%s' (expected native or emulate)", name, p.Prefill)
+		}
+		if p.Style != "anthropic" && (p.AnthropicVersion != "" || len(p.AnthropicBeta) > 0) {
+			return fmt.Errorf("provider %s: anthropic_version and anthropic_beta are only supported by the anthropic style", name)
+		}
+		if p.APIBaseURL == "" {
+			p.APIBaseURL = styleBaseURLs[p.Style]
+		}
 		if p.APIBaseURL == "" {
 			return fmt.Errorf("provider %s: api_base_url is required", name)
 		}
+		if err := validateKeyMode(p.KeyMode); err != nil {
+			return fmt.Errorf("provider %s: %v", name, err)
+		}
+		if err := validateKeyRotation(p.KeyRotation); err != nil {
+			return fmt.Errorf("provider %s: %v", name, err)
+		}
+		p.keys = newKeyPool(p)
 		parsedURL, err := url.Parse(p.APIBaseURL)
 		if err != nil {
 			return fmt.Errorf("provider %s: invalid api_base_url '%s': %v", name, p.APIBaseURL, err)
 		}
 		p.parsedURL = parsedURL
+		for _, template := range []string{p.PathTemplate, p.StreamPathTemplate} {
+			if template == "" {
+				continue
+			}
+			if err := validatePathTemplate(template); err != nil {
+				return fmt.Errorf("provider %s: %v", name, err)
+			}
+		}
+		if p.HeadersUp != nil {
+			if err := p.HeadersUp.Provision(ctx); err != nil {
+				return fmt.Errorf("provider %s: header_up: %v", name, err)
+			}
+		}
+		if p.HeadersDown != nil {
+			if err := p.HeadersDown.Provision(ctx); err != nil {
+				return fmt.Errorf("provider %s: header_down: %v", name, err)
+			}
+		}
 
-		switch p.Style {
-		case "google":
-			p.Provider = &providers.GoogleProvider{}
-		case "anthropic":
-			p.Provider = &providers.AnthropicProvider{}
-		case "cloudflare":
-			p.Provider = &providers.CloudflareProvider{}
-		default:
-			p.Provider = &providers.OpenAIProvider{}
+		if p.Mock != nil {
+			if p.Style != "mock" {
+				return fmt.Errorf("provider %s: mock options only apply to style mock", name)
+			}
+			if err := p.Mock.validate(); err != nil {
+				return fmt.Errorf("provider %s: %v", name, err)
+			}
+		}
+		if p.Faults != nil {
+			if err := p.Faults.validate(); err != nil {
+				return fmt.Errorf("provider %s: %v", name, err)
+			}
+		}
+		if p.SignRequests != nil {
+			if err := p.SignRequests.validate(); err != nil {
+				return fmt.Errorf("provider %s: %v", name, err)
+			}
+		}
+		if err := validateCassette(p); err != nil {
+			return fmt.Errorf("provider %s: %v", name, err)
+		}
+		provider, err := newProvider(ctx, p)
+		if err != nil {
+			return fmt.Errorf("provider %s: %v", name, err)
+		}
+		p.Provider = provider
+
+		if p.MaxConcurrentRequests > 0 {
+			p.limiter = newConcurrencyLimiter(cr.Name, p)
 		}
 
 		p.proxy = &httputil.ReverseProxy{
@@ -121,27 +422,138 @@ func (cr *AICoreRouter) Provision(ctx caddy.Context) error {
 			ModifyResponse: cr.getModifyResponse(p),
 			ErrorHandler:   cr.getErrorHandler(p),
 		}
+		transport, err := cr.newUpstreamTransport(p)
+		if err != nil {
+			return fmt.Errorf("provider %s: %v", name, err)
+		}
+		p.proxy.Transport = transport
+		p.transport = transport
+		p.client = &http.Client{Timeout: cr.httpClient.Timeout, Transport: transport}
+		if tp, ok := provider.(providers.TransportProvider); ok {
+			p.proxy.Transport = tp.Transport()
+		}
+		if p.SignRequests != nil {
+			cr.signRequests(p)
+		}
+		if p.Record != "" || p.Replay != "" {
+			cr.useCassette(p)
+		}
+		if p.Faults != nil {
+			cr.injectFaults(p)
+		}
+		if cr.LogBodies.enabled() {
+			cr.logBodies(p)
+		}
+		if cp, ok := provider.(providers.ClientProvider); ok {
+			cp.SetHTTPClient(p.client)
+		}
+		if p.WarmUp != nil {
+			if err := p.WarmUp.validate(); err != nil {
+				return fmt.Errorf("provider %s: %v", name, err)
+			}
+			if len(p.WarmUp.models(p)) == 0 {
+				return fmt.Errorf("provider %s: warm_up needs models, listed in it or in the provider's models", name)
+			}
+			if cr.warmer == nil {
+				cr.warmer = newWarmer(cr)
+			}
+		}
 		cr.logger.Info("Provisioned provider for core router", zap.String("name", name), zap.String("base_url", p.APIBaseURL))
 	}
 
-	for model, providerNames := range cr.DefaultProviderForModel {
-		for _, providerName := range providerNames {
-			if _, ok := cr.Providers[providerName]; !ok {
-				return fmt.Errorf("default provider '%s' for model '%s' is not a configured provider", providerName, model)
-			}
+	if err := cr.ModelMatching.validate(); err != nil {
+		return err
+	}
+	if err := validateRoutingStrategy(cr.Strategy); err != nil {
+		return err
+	}
+	if err := validateCapabilityCheck(cr.CapabilityCheck); err != nil {
+		return err
+	}
+	if err := cr.ContextOverflow.validate(); err != nil {
+		return err
+	}
+	if err := cr.Conversations.validate(); err != nil {
+		return err
+	}
+	if err := cr.Retry.validate(); err != nil {
+		return err
+	}
+	for _, chain := range cr.Fallbacks {
+		if err := chain.provision(); err != nil {
+			return err
 		}
 	}
+	for _, race := range cr.Races {
+		if err := race.provision(); err != nil {
+			return err
+		}
+	}
+	if err := cr.QualityGuard.provision(); err != nil {
+		return err
+	}
+	if err := cr.provisionTransforms(ctx); err != nil {
+		return err
+	}
+	if err := cr.Tiers.provision(cr); err != nil {
+		return err
+	}
+	if err := cr.RouteByClass.provision(cr); err != nil {
+		return err
+	}
+	if err := cr.Alerts.validate(); err != nil {
+		return err
+	}
+	if err := cr.Usage.validate(); err != nil {
+		return err
+	}
+	if cr.Alerts != nil {
+		cr.alerts = newAlerter(cr)
+	}
+
+	for _, e := range cr.Experiments {
+		if err := e.validate(); err != nil {
+			return err
+		}
+	}
+	canaries := make(map[string]bool, len(cr.Canaries))
+	for _, c := range cr.Canaries {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		if canaries[strings.ToLower(c.Name)] {
+			return fmt.Errorf("canary %s declared more than once", c.Name)
+		}
+		canaries[strings.ToLower(c.Name)] = true
+	}
+
+	if err := cr.provisionRoutingRules(); err != nil {
+		return err
+	}
 
 	cr.logger.Info("AI Core Router provisioned",
 		zap.String("version", APP_VERSION),
 		zap.Int("num_providers", len(cr.Providers)),
 		zap.Int("num_model_defaults", len(cr.DefaultProviderForModel)),
+		zap.Int("num_routing_rules", len(cr.Rules)),
 	)
 
+	if previous, ok := getRouter(cr.Name); ok {
+		cr.inheritMaintenance(previous)
+	}
+
 	// Make this router discoverable by endpoint handlers
 	registerRouter(cr.Name, cr)
 
-	common.FireObservabilityEvent("system", "", "router_start", map[string]any{
+	cr.modelsCache.Start()
+	if cr.alerts != nil {
+		cr.alerts.Start()
+	}
+	if cr.warmer != nil {
+		cr.warmer.Start()
+	}
+
+	cr.observability.Fire("system", "", "router_start", map[string]any{
 		"version":            APP_VERSION,
 		"num_providers":      len(cr.Providers),
 		"num_model_defaults": len(cr.DefaultProviderForModel),
@@ -160,6 +572,37 @@ func (cr *AICoreRouter) Validate() error {
 	return nil
 }
 
+// Cleanup retires the router when its config is unloaded. Lookups move on to the newer
+// version right away; this one finishes its in-flight requests in the background and then
+// stops its background work.
+func (cr *AICoreRouter) Cleanup() error {
+	if idle, first := cr.retire(); first {
+		go cr.drainAndStop(idle)
+	}
+	return nil
+}
+
+// stop shuts down the router's background work and shared resources.
+func (cr *AICoreRouter) stop() error {
+	if cr.modelsCache != nil {
+		cr.modelsCache.Stop()
+	}
+	if cr.tracer != nil {
+		cr.tracer.Close()
+	}
+	cr.observability.Close()
+	if cr.alerts != nil {
+		cr.alerts.Stop()
+	}
+	if cr.warmer != nil {
+		cr.warmer.Stop()
+	}
+	if cr.store != nil {
+		return cr.store.Close()
+	}
+	return nil
+}
+
 func (cr *AICoreRouter) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	// No-op handler; exists only to provision router config at top-level.
 	// Always pass through.
@@ -181,7 +624,8 @@ func (cr *AICoreRouter) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	}
 
 	for d.Next() {
-		if d.Val() == "ai_router" && !d.NextArg() {
+		if d.Val() == "ai_router" && d.NextArg() {
+			cr.Name = strings.ToLower(strings.TrimSpace(d.Val())) // ai_router <name> { ... }
 		}
 		for d.NextBlock(0) {
 			switch d.Val() {
@@ -207,19 +651,585 @@ func (cr *AICoreRouter) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 						}
 						p.APIBaseURL = d.Val()
 					case "style":
+						if err := parseStyleCaddyfile(d, p); err != nil {
+							return err
+						}
+					case "weight":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						weight, err := strconv.ParseFloat(d.Val(), 64)
+						if err != nil || weight <= 0 {
+							return d.Errf("provider %s: invalid weight '%s'", providerName, d.Val())
+						}
+						p.Weight = weight
+					case "models_cache_ttl":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						ttl, err := caddy.ParseDuration(d.Val())
+						if err != nil {
+							return d.Errf("provider %s: invalid models_cache_ttl '%s': %v", providerName, d.Val(), err)
+						}
+						p.ModelsCacheTTL = caddy.Duration(ttl)
+					case "max_concurrent_requests", "queue_size":
+						option := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						n, err := strconv.Atoi(d.Val())
+						if err != nil || n < 0 {
+							return d.Errf("provider %s: invalid %s '%s'", providerName, option, d.Val())
+						}
+						if option == "queue_size" {
+							p.QueueSize = n
+						} else {
+							p.MaxConcurrentRequests = n
+						}
+					case "queue_timeout":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						timeout, err := caddy.ParseDuration(d.Val())
+						if err != nil {
+							return d.Errf("provider %s: invalid queue_timeout '%s': %v", providerName, d.Val(), err)
+						}
+						p.QueueTimeout = caddy.Duration(timeout)
+					case "key_mode":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.KeyMode = strings.ToLower(d.Val())
+						if err := validateKeyMode(p.KeyMode); err != nil {
+							return d.Errf("provider %s: %v", providerName, err)
+						}
+					case "api_keys":
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return d.ArgErr()
+						}
+						p.APIKeys = append(p.APIKeys, args...)
+					case "key_rotation":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.KeyRotation = strings.ToLower(d.Val())
+						if err := validateKeyRotation(p.KeyRotation); err != nil {
+							return d.Errf("provider %s: %v", providerName, err)
+						}
+					case "key_requests_per_minute":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						perMinute, err := strconv.Atoi(d.Val())
+						if err != nil || perMinute < 0 {
+							return d.Errf("provider %s: invalid key_requests_per_minute '%s'", providerName, d.Val())
+						}
+						p.KeyRequestsPerMinute = perMinute
+					case "native_responses":
+						p.NativeResponses = true
+					case "prefill":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.Prefill = strings.ToLower(d.Val())
+					case "disabled":
+						p.Disabled = true
+					case "drain_until":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						if _, err := parseDrainUntil(d.Val()); err != nil {
+							return d.Errf("provider %s: %v", providerName, err)
+						}
+						p.DrainUntil = d.Val()
+					case "organization", "project":
+						option := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						if option == "organization" {
+							p.Organization = d.Val()
+						} else {
+							p.Project = d.Val()
+						}
+					case "account_id", "gateway":
+						option := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						if option == "account_id" {
+							p.AccountID = d.Val()
+						} else {
+							p.Gateway = d.Val()
+						}
+					case "openai_compatible":
+						p.OpenAICompatible = d.RemainingArgs()
+						if len(p.OpenAICompatible) == 0 {
+							p.OpenAICompatible = []string{"*"}
+						}
+					case "lora":
+						args := d.RemainingArgs()
+						if len(args) != 2 {
+							return d.ArgErr()
+						}
+						if p.LoRA == nil {
+							p.LoRA = make(map[string]string)
+						}
+						p.LoRA[args[0]] = args[1]
+					case "anthropic_version":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.AnthropicVersion = d.Val()
+					case "anthropic_beta":
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return d.ArgErr()
+						}
+						p.AnthropicBeta = append(p.AnthropicBeta, args...)
+					case "extra_headers":
+						extra, err := parseExtraHeadersCaddyfile(d)
+						if err != nil {
+							return err
+						}
+						if p.ExtraHeaders == nil {
+							p.ExtraHeaders = make(map[string]string)
+						}
+						for name, value := range extra {
+							p.ExtraHeaders[name] = value
+						}
+					case "extra_body":
+						extra, err := parseDefaultsCaddyfile(d)
+						if err != nil {
+							return err
+						}
+						if p.ExtraBody == nil {
+							p.ExtraBody = make(map[string]json.RawMessage)
+						}
+						for key, value := range extra {
+							p.ExtraBody[key] = value
+						}
+					case "mock":
+						mock, err := parseMockCaddyfile(d, providerName)
+						if err != nil {
+							return err
+						}
+						p.Mock = mock
+					case "path_template", "stream_path_template":
+						option := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						if err := validatePathTemplate(d.Val()); err != nil {
+							return d.Errf("provider %s: %s: %v", providerName, option, err)
+						}
+						if option == "path_template" {
+							p.PathTemplate = d.Val()
+						} else {
+							p.StreamPathTemplate = d.Val()
+						}
+					case "warm_up":
+						warmUp, err := parseWarmUpCaddyfile(d, providerName)
+						if err != nil {
+							return err
+						}
+						p.WarmUp = warmUp
+					case "faults":
+						faults, err := parseFaultsCaddyfile(d, providerName)
+						if err != nil {
+							return err
+						}
+						p.Faults = faults
+					case "record":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.Record = d.Val()
+					case "replay":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.Replay = d.Val()
+					case "transport":
+						transport, err := parseUpstreamTransportCaddyfile(d, providerName)
+						if err != nil {
+							return err
+						}
+						p.Transport = transport
+					case "tls":
+						tlsConfig, err := parseUpstreamTLSCaddyfile(d, providerName)
+						if err != nil {
+							return err
+						}
+						p.TLS = tlsConfig
+					case "forward_proxy":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.ForwardProxy = d.Val()
+					case "local_address":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						p.LocalAddress = d.Val()
+					case "sign_requests":
+						signing, err := parseSigningCaddyfile(d, providerName)
+						if err != nil {
+							return err
+						}
+						p.SignRequests = signing
+					case "models":
+						models, err := parseModelManifestCaddyfile(d, providerName)
+						if err != nil {
+							return err
+						}
+						p.Models = append(p.Models, models...)
+					case "default_output_tokens", "max_output_tokens":
+						option := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						n, err := strconv.Atoi(d.Val())
+						if err != nil || n <= 0 {
+							return d.Errf("provider %s: invalid %s '%s'", providerName, option, d.Val())
+						}
+						if option == "default_output_tokens" {
+							p.DefaultOutputTokens = n
+						} else {
+							p.MaxOutputTokens = n
+						}
+					case "allow_models", "deny_models":
+						option := d.Val()
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return d.ArgErr()
+						}
+						if option == "allow_models" {
+							p.AllowModels = append(p.AllowModels, args...)
+						} else {
+							p.DenyModels = append(p.DenyModels, args...)
+						}
+					case "models_discovery":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						switch d.Val() {
+						case "on":
+							p.DisableModelsDiscovery = false
+						case "off":
+							p.DisableModelsDiscovery = true
+						default:
+							return d.Errf("provider %s: models_discovery expects 'on' or 'off', got '%s'", providerName, d.Val())
+						}
+					case "sse_repair":
 						if !d.NextArg() {
 							return d.ArgErr()
 						}
-						p.Style = strings.ToLower(d.Val())
+						switch d.Val() {
+						case "on":
+							p.DisableSSERepair = false
+						case "off":
+							p.DisableSSERepair = true
+						default:
+							return d.Errf("provider %s: sse_repair expects 'on' or 'off', got '%s'", providerName, d.Val())
+						}
+					case "header_up", "header_down":
+						option := d.Val()
+						args := d.RemainingArgs()
+						if len(args) < 1 || len(args) > 3 {
+							return d.ArgErr()
+						}
+						args = append(args, "", "")
+						ops := &p.HeadersUp
+						if option == "header_down" {
+							ops = &p.HeadersDown
+						}
+						if *ops == nil {
+							*ops = &headers.HeaderOps{}
+						}
+						if err := headers.CaddyfileHeaderOp(*ops, args[0], args[1], args[2]); err != nil {
+							return d.Errf("provider %s: %s: %v", providerName, option, err)
+						}
 					default:
 						return d.Errf("unrecognized provider option '%s' for provider '%s'", d.Val(), providerName)
 					}
 				}
-				if p.APIBaseURL == "" {
-					return d.Errf("provider %s: api_base_url is required", providerName)
+				if p.APIBaseURL == "" && p.AccountID == "" && styleBaseURLs[p.Style] == "" {
+					return d.Errf("provider %s: api_base_url or account_id is required", providerName)
 				}
 				cr.Providers[providerName] = p
 				cr.ProviderOrder = append(cr.ProviderOrder, providerName)
+			case "sticky_routing":
+				sources := d.RemainingArgs()
+				if len(sources) == 0 {
+					return d.ArgErr()
+				}
+				for _, source := range sources {
+					source = strings.ToLower(source)
+					if source != StickySourceHeader && source != StickySourceUser && source != StickySourceSystem {
+						return d.Errf("unrecognized sticky_routing source '%s'", source)
+					}
+					cr.StickyRouting = append(cr.StickyRouting, source)
+				}
+			case "api_key_env_prefix":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				cr.APIKeyEnvPrefix = d.Val()
+			case "priority_header":
+				cr.PriorityHeader = DefaultPriorityHeader
+				if d.NextArg() {
+					cr.PriorityHeader = d.Val()
+				}
+			case "max_latency_header":
+				cr.MaxLatencyHeader = DefaultMaxLatencyHeader
+				if d.NextArg() {
+					cr.MaxLatencyHeader = d.Val()
+				}
+			case "provider_header":
+				cr.ProviderHeader = DefaultProviderHeader
+				if d.NextArg() {
+					cr.ProviderHeader = d.Val()
+				}
+			case "hide_provider":
+				hiding, err := parseProviderHidingCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.HideProvider = hiding
+			case "drain_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				timeout, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid drain_timeout '%s': %v", d.Val(), err)
+				}
+				cr.DrainTimeout = caddy.Duration(timeout)
+			case "models_cache_ttl", "models_cache_negative_ttl":
+				option := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				ttl, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid %s '%s': %v", option, d.Val(), err)
+				}
+				if option == "models_cache_ttl" {
+					cr.ModelsCacheTTL = caddy.Duration(ttl)
+				} else {
+					cr.ModelsCacheNegativeTTL = caddy.Duration(ttl)
+				}
+			case "storage":
+				cfg, err := parseStorageCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Storage = cfg
+			case "observability":
+				cfg, err := parseObservabilityCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Observability = cfg
+			case "tracing":
+				cfg, err := parseTracingCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Tracing = cfg
+			case "access_log":
+				cr.AccessLog = "info"
+				if d.NextArg() {
+					cr.AccessLog = strings.ToLower(d.Val())
+				}
+				if cr.AccessLog != "info" && cr.AccessLog != "debug" {
+					return d.Errf("invalid access_log level '%s' (expected info or debug)", d.Val())
+				}
+			case "context_overflow":
+				cfg, err := parseContextOverflowCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.ContextOverflow = cfg
+			case "conversations":
+				cfg, err := parseConversationsCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Conversations = cfg
+			case "capability_check":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				cr.CapabilityCheck = strings.ToLower(d.Val())
+				if err := validateCapabilityCheck(cr.CapabilityCheck); err != nil {
+					return d.Err(err.Error())
+				}
+			case "strategy":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				cr.Strategy = strings.ToLower(d.Val())
+				if err := validateRoutingStrategy(cr.Strategy); err != nil {
+					return d.Err(err.Error())
+				}
+				for d.NextArg() {
+					if cr.Strategy != RoutingStrategyLatencyAware {
+						return d.ArgErr()
+					}
+					if cr.LatencyAware == nil {
+						cr.LatencyAware = &LatencyAwareConfig{}
+					}
+					option := d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					switch option {
+					case "window":
+						window, err := caddy.ParseDuration(d.Val())
+						if err != nil || window <= 0 {
+							return d.Errf("invalid latency_aware window '%s'", d.Val())
+						}
+						cr.LatencyAware.Window = caddy.Duration(window)
+					case "max_error_rate":
+						rate, err := strconv.ParseFloat(d.Val(), 64)
+						if err != nil || rate <= 0 || rate > 1 {
+							return d.Errf("invalid latency_aware max_error_rate '%s'", d.Val())
+						}
+						cr.LatencyAware.MaxErrorRate = rate
+					default:
+						return d.Errf("strategy latency_aware expects [window <duration>] [max_error_rate <0-1>], got '%s'", option)
+					}
+				}
+			case "tokenizer":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				cr.Tokenizer = d.Val()
+			case "model_matching":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				cr.ModelMatching = &ModelMatching{Strategy: strings.ToLower(d.Val())}
+				for d.NextArg() {
+					if d.Val() != "min_similarity" || !d.NextArg() {
+						return d.Errf("model_matching expects <strategy> [min_similarity <0-1>]")
+					}
+					similarity, err := strconv.ParseFloat(d.Val(), 64)
+					if err != nil {
+						return d.Errf("invalid model_matching min_similarity '%s'", d.Val())
+					}
+					cr.ModelMatching.MinSimilarity = similarity
+				}
+				if err := cr.ModelMatching.validate(); err != nil {
+					return d.Err(err.Error())
+				}
+			case "experiment":
+				e, err := parseExperimentCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Experiments = append(cr.Experiments, e)
+			case "canary":
+				c, err := parseCanaryCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Canaries = append(cr.Canaries, c)
+			case "retry":
+				cfg, err := parseRetryCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Retry = cfg
+			case "fallback":
+				chain, err := parseFallbackCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Fallbacks = append(cr.Fallbacks, chain)
+			case "race":
+				race, err := parseRaceCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Races = append(cr.Races, race)
+			case "quality_guard":
+				guard, err := parseQualityGuardCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.QualityGuard = guard
+			case "route_by_class":
+				classes, err := parseClassRoutingCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.RouteByClass = classes
+			case "tiers":
+				policy, err := parseTierPolicyCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Tiers = policy
+			case "alerts":
+				alerts, err := parseAlertsCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Alerts = alerts
+			case "usage":
+				usage, err := parseUsageCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Usage = usage
+			case "tag_keys":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				cr.TagKeys = append(cr.TagKeys, args...)
+			case "allow_models", "deny_models":
+				option := d.Val()
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				if option == "allow_models" {
+					cr.AllowModels = append(cr.AllowModels, args...)
+				} else {
+					cr.DenyModels = append(cr.DenyModels, args...)
+				}
+			case "transform":
+				transform, err := parseTransformCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.TransformsRaw = append(cr.TransformsRaw, transform)
+			case "unredacted":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				if _, err := common.NewRedactionPolicy(args); err != nil {
+					return d.Err(err.Error())
+				}
+				cr.Unredacted = append(cr.Unredacted, args...)
+			case "log_bodies":
+				logBodies, err := parseBodyLoggingCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.LogBodies = logBodies
+			case "rule":
+				rule, err := parseRoutingRuleCaddyfile(d)
+				if err != nil {
+					return err
+				}
+				cr.Rules = append(cr.Rules, rule)
 			case "default_provider_for_model":
 				args := d.RemainingArgs()
 				if len(args) < 2 {
@@ -239,23 +1249,64 @@ func (cr *AICoreRouter) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	return nil
 }
 
+// rewriteUpstreamRequest points a request at the provider and converts it to the provider's API.
+func (cr *AICoreRouter) rewriteUpstreamRequest(p *ProviderConfig, r *http.Request, logger *zap.Logger) {
+	r.URL.Scheme = p.parsedURL.Scheme
+	r.URL.Host = p.parsedURL.Host
+	r.URL.Path = p.parsedURL.Path
+	r.Host = p.parsedURL.Host
+	r.Header.Del("X-Forwarded-Proto")
+
+	modelName, _ := r.Context().Value(ActualModelNameContextKeyString).(string)
+
+	if p.Provider != nil {
+		var err error
+		images, isImages := p.Provider.(providers.ImagesProvider)
+		rerank, isRerank := p.Provider.(providers.RerankProvider)
+		switch kind := common.RequestKind(r.Context()); {
+		case isImages && kind == common.RequestKindImages:
+			err = images.ModifyImagesRequest(r, modelName, logger)
+		case isRerank && kind == common.RequestKindRerank:
+			err = rerank.ModifyRerankRequest(r, modelName, logger)
+		default:
+			p.applyOutputTokens(r, modelName, logger)
+			p.downgradeDeveloperRole(r)
+			p.emulatePrefill(r)
+			if err = p.Provider.ModifyCompletionRequest(r, modelName, logger); err == nil {
+				p.applyPathTemplate(r, modelName)
+			}
+		}
+		if err != nil {
+			logger.Error("failed to modify request", zap.Error(err), zap.String("provider", p.Name))
+		}
+	}
+	if err := applyProviderExtras(r, p); err != nil {
+		logger.Error("failed to apply provider extras", zap.Error(err), zap.String("provider", p.Name))
+	}
+	if p.HeadersUp != nil {
+		if _, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+			p.HeadersUp.ApplyToRequest(r)
+		}
+	}
+}
+
 func (cr *AICoreRouter) getDirector(p *ProviderConfig) func(req *http.Request) {
 	return func(r *http.Request) {
-		r.URL.Scheme = p.parsedURL.Scheme
-		r.URL.Host = p.parsedURL.Host
-		r.URL.Path = p.parsedURL.Path
-		r.Host = p.parsedURL.Host
-		r.Header.Del("X-Forwarded-Proto")
-
+		logger := cr.requestLogger(r.Context())
 		modelName, _ := r.Context().Value(ActualModelNameContextKeyString).(string)
-
-		if p.Provider != nil {
-			if err := p.Provider.ModifyCompletionRequest(r, modelName, cr.logger); err != nil {
-				cr.logger.Error("failed to modify request", zap.Error(err), zap.String("provider", p.Name))
-			}
+		cr.rewriteUpstreamRequest(p, r, logger)
+		if sample, ok := r.Context().Value(RouteSampleContextKeyString).(*routeSample); ok {
+			sample.start = time.Now()
 		}
+		if id := requestID(r.Context()); id != "" {
+			r.Header.Set(RequestIDHeader, id)
+		}
+		if p.Transport != nil && p.Transport.DisableCompression {
+			r.Header.Del("Accept-Encoding") // Or the client's would still ask for compression
+		}
+		accessRecordFrom(r.Context()).attemptStarted()
 
-		cr.logger.Info("Proxying request to provider",
+		logger.Info("Proxying request to provider",
 			zap.String("provider", p.Name),
 			zap.String("target_url", r.URL.String()),
 			zap.String("model", modelName),
@@ -268,18 +1319,24 @@ func (cr *AICoreRouter) getDirector(p *ProviderConfig) func(req *http.Request) {
 		userID, _ := userIDVal.(string)
 		apiKeyID, _ := apiKeyIDVal.(string)
 
-		common.FireObservabilityEvent(userID, "", "inference_proxy_request", map[string]any{
+		cr.observability.Fire(userID, "", "inference_proxy_request", map[string]any{
 			"$ip":        r.RemoteAddr,
 			"provider":   r.Context().Value(ProviderNameContextKeyString).(string),
 			"model":      r.Context().Value(ActualModelNameContextKeyString).(string),
 			"user_id":    userID,
 			"api_key_id": apiKeyID,
+			"request_id": requestID(r.Context()),
 		})
 	}
 }
 
 func (cr *AICoreRouter) getModifyResponse(p *ProviderConfig) func(resp *http.Response) error {
 	return func(resp *http.Response) error {
+		logger := cr.requestLogger(resp.Request.Context())
+		sample, _ := resp.Request.Context().Value(RouteSampleContextKeyString).(*routeSample)
+		cr.latency.finish(sample, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500)
+		accessRecordFrom(resp.Request.Context()).attemptFinished(resp.StatusCode)
+		cr.repairStream(resp, p)
 		if p.Provider != nil {
 			if resp.Header.Get("X-Provider-Name") == "" {
 				modelName, _ := resp.Request.Context().Value(ActualModelNameContextKeyString).(string)
@@ -289,41 +1346,73 @@ func (cr *AICoreRouter) getModifyResponse(p *ProviderConfig) func(resp *http.Res
 				userID, _ := resp.Request.Context().Value(UserIDContextKeyString).(string)
 				apiKeyID, _ := resp.Request.Context().Value(ApiKeyIDContextKeyString).(string)
 
-				body := ""
-				if resp.StatusCode >= 299 {
-					bodyBytes, err := httputil.DumpResponse(resp, false)
-					if err == nil {
-						body = string(bodyBytes)
-					}
-				}
-
-				common.FireObservabilityEvent(userID, "", "inference_proxy_response", map[string]any{
+				cr.observability.Fire(userID, "", "inference_proxy_response", map[string]any{
 					"$ip":          resp.Request.RemoteAddr,
 					"status_code":  resp.StatusCode,
 					"content_type": resp.Header.Get("Content-Type"),
-					"body":         body, // todo: if OBSERVE_PROXY_RESPONSE_BODY
 					"provider":     p.Name,
 					"model":        modelName,
 					"user_id":      userID,
 					"api_key_id":   apiKeyID,
+					"request_id":   requestID(resp.Request.Context()),
 				})
 			}
-			if err := p.Provider.ModifyCompletionResponse(resp.Request, resp, cr.logger); err != nil {
-				cr.logger.Error("failed to modify response", zap.Error(err), zap.String("provider", p.Name))
+			var err error
+			images, isImages := p.Provider.(providers.ImagesProvider)
+			rerank, isRerank := p.Provider.(providers.RerankProvider)
+			switch kind := common.RequestKind(resp.Request.Context()); {
+			case isImages && kind == common.RequestKindImages:
+				err = images.ModifyImagesResponse(resp.Request, resp, logger)
+			case isRerank && kind == common.RequestKindRerank:
+				err = rerank.ModifyRerankResponse(resp.Request, resp, logger)
+			default:
+				err = p.Provider.ModifyCompletionResponse(resp.Request, resp, logger)
 			}
+			if err != nil {
+				logger.Error("failed to modify response", zap.Error(err), zap.String("provider", p.Name))
+			}
+		}
+		if err := cr.moderateResponse(resp); err != nil {
+			logger.Error("failed to moderate response", zap.Error(err), zap.String("provider", p.Name))
+		}
+		cr.recordRateLimit(resp, p)
+		if err := cr.normalizeUpstreamError(resp, p.Name); err != nil {
+			logger.Error("failed to normalize upstream error", zap.Error(err), zap.String("provider", p.Name))
 		}
+		cr.transformResponse(resp)
+		if err := cr.stripReasoning(resp); err != nil {
+			logger.Error("failed to strip reasoning", zap.Error(err), zap.String("provider", p.Name))
+		}
+		cr.HideProvider.apply(resp)
+		if p.HeadersDown != nil {
+			if repl, ok := resp.Request.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+				p.HeadersDown.ApplyTo(resp.Header, repl)
+			}
+		}
+		cr.trackUsage(resp)
+		cr.trackRerankUsage(resp)
 		return nil
 	}
 }
 
 func (cr *AICoreRouter) getErrorHandler(p *ProviderConfig) func(rw http.ResponseWriter, r *http.Request, err error) {
 	return func(rw http.ResponseWriter, r *http.Request, err error) {
+		logger := cr.requestLogger(r.Context())
+		if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+			// The client went away; the upstream request was cancelled with it and there is no one to answer
+			logger.Debug("Upstream request cancelled by client disconnect", zap.String("provider", p.Name))
+			return
+		}
+		sample, _ := r.Context().Value(RouteSampleContextKeyString).(*routeSample)
+		cr.latency.finish(sample, true)
+		accessRecordFrom(r.Context()).attemptFinished(http.StatusBadGateway)
+
 		urlWithoutQs := r.URL.String()
 		if r.URL.RawQuery != "" {
 			urlWithoutQs = urlWithoutQs[:len(urlWithoutQs)-len(r.URL.RawQuery)-1]
 		}
 
-		cr.logger.Error("Upstream proxy error",
+		logger.Error("Upstream proxy error",
 			zap.String("provider", p.Name),
 			zap.String("target_url", urlWithoutQs),
 			zap.Error(err),
@@ -336,7 +1425,7 @@ func (cr *AICoreRouter) getErrorHandler(p *ProviderConfig) func(rw http.Response
 		userID, _ := userIDVal.(string)
 		apiKeyID, _ := apiKeyIDVal.(string)
 
-		common.FireObservabilityEvent(userID, urlWithoutQs, "$exception", map[string]any{
+		cr.observability.Fire(userID, urlWithoutQs, "$exception", map[string]any{
 			"$exception_list": []map[string]any{
 				{
 					"type":  "ProxyError",
@@ -351,9 +1440,14 @@ func (cr *AICoreRouter) getErrorHandler(p *ProviderConfig) func(rw http.Response
 			"model":      r.Context().Value(ActualModelNameContextKeyString).(string),
 			"user_id":    userID,
 			"api_key_id": apiKeyID,
+			"request_id": requestID(r.Context()),
 		})
 
-		http.Error(rw, fmt.Sprintf("Error proxying to upstream provider %s: %v", p.Name, err), http.StatusBadGateway)
+		message := fmt.Sprintf("Error proxying to upstream provider %s: %v", p.Name, err)
+		if cr.HideProvider.enabled() {
+			message = "Error proxying to upstream provider"
+		}
+		writeOpenAIError(rw, http.StatusBadGateway, ErrorTypeAPI, "upstream_unavailable", message)
 	}
 }
 
@@ -369,35 +1463,42 @@ func parseAIRouterCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler
 var (
 	_ caddy.Provisioner           = (*AICoreRouter)(nil)
 	_ caddy.Validator             = (*AICoreRouter)(nil)
+	_ caddy.CleanerUpper          = (*AICoreRouter)(nil)
 	_ caddyhttp.MiddlewareHandler = (*AICoreRouter)(nil)
 	_ caddyfile.Unmarshaler       = (*AICoreRouter)(nil)
 )
 
 // --- Shared registry and decoupled endpoint handlers ---
 
-// simple in-process registry mapping router names to instances
-var routerRegistry sync.Map // map[string]*AICoreRouter
-
-func registerRouter(name string, r *AICoreRouter) {
-	routerRegistry.Store(strings.ToLower(name), r)
+// firePageviewEvent fires a pageview event for observability (without query string).
+func (cr *AICoreRouter) firePageviewEvent(r *http.Request) {
+	urlWithoutQs := r.URL.String()
+	if r.URL.RawQuery != "" {
+		urlWithoutQs = urlWithoutQs[:len(urlWithoutQs)-len(r.URL.RawQuery)-1]
+	}
+	cr.observability.Fire("system", urlWithoutQs, "$pageview", map[string]any{
+		"$ip": r.RemoteAddr,
+	})
 }
 
-func getRouter(name string) (*AICoreRouter, bool) {
-	if strings.TrimSpace(name) == "" {
-		name = "default"
-	}
-	if v, ok := routerRegistry.Load(strings.ToLower(name)); ok {
-		if cr, ok2 := v.(*AICoreRouter); ok2 {
-			return cr, true
+// apiKeyServiceFor returns the API key provider from the request context, or the env-based default.
+func (cr *AICoreRouter) apiKeyServiceFor(r *http.Request) auth.ExternalAPIKeyProvider {
+	if val := r.Context().Value(ExternalAPIKeyProviderContextKeyString); val != nil {
+		if svc, ok := val.(auth.ExternalAPIKeyProvider); ok {
+			return svc
 		}
 	}
-	return nil, false
+	if cr.APIKeyEnvPrefix != "" {
+		return auth.NewPrefixedEnvAPIKeyProvider(cr.APIKeyEnvPrefix, cr.logger)
+	}
+	return auth.NewDefaultEnvAPIKeyProvider(cr.logger)
 }
 
 // ModelsEndpointHandler serves aggregated models under any path.
 type ModelsEndpointHandler struct {
-	Router string `json:"router,omitempty"`
-	logger *zap.Logger
+	Router  string `json:"router,omitempty"`
+	logger  *zap.Logger
+	routers *routerScope
 }
 
 func (ModelsEndpointHandler) CaddyModule() caddy.ModuleInfo {
@@ -408,36 +1509,24 @@ func (ModelsEndpointHandler) CaddyModule() caddy.ModuleInfo {
 }
 
 func (h *ModelsEndpointHandler) Provision(ctx caddy.Context) error {
-	h.logger = ctx.Logger(h)
-	return nil
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	h.routers, err = routerScopeOf(ctx)
+	return err
 }
 
 func (h *ModelsEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	cr, ok := getRouter(h.Router)
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
 	if !ok {
-		http.Error(w, fmt.Sprintf("ai_models: router '%s' not found", h.Router), http.StatusInternalServerError)
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_models: router '%s' not found", routerName))
 		return nil
 	}
+	defer cr.track()()
 
-	// Fire a pageview event for observability (without query string)
-	urlWithoutQs := r.URL.String()
-	if r.URL.RawQuery != "" {
-		urlWithoutQs = urlWithoutQs[:len(urlWithoutQs)-len(r.URL.RawQuery)-1]
-	}
-	common.FireObservabilityEvent("system", urlWithoutQs, "$pageview", map[string]any{
-		"$ip": r.RemoteAddr,
-	})
+	cr.firePageviewEvent(r)
 
 	// Discover API key provider from context if present
-	var apiKeyService auth.ExternalAPIKeyProvider
-	if val := r.Context().Value(ExternalAPIKeyProviderContextKeyString); val != nil {
-		if svc, ok := val.(auth.ExternalAPIKeyProvider); ok {
-			apiKeyService = svc
-		}
-	}
-	if apiKeyService == nil {
-		apiKeyService = auth.NewDefaultEnvAPIKeyProvider(cr.logger)
-	}
+	apiKeyService := cr.apiKeyServiceFor(r)
 
 	if r.Method == http.MethodGet {
 		return cr.handleGetManagedModels(w, r, next, apiKeyService)
@@ -467,7 +1556,10 @@ func parseModelsHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHa
 // ChatCompletionsHandler serves chat completions under any path.
 type ChatCompletionsHandler struct {
 	Router string `json:"router,omitempty"`
-	logger *zap.Logger
+	RouteOptions
+
+	logger  *zap.Logger
+	routers *routerScope
 }
 
 func (ChatCompletionsHandler) CaddyModule() caddy.ModuleInfo {
@@ -478,38 +1570,31 @@ func (ChatCompletionsHandler) CaddyModule() caddy.ModuleInfo {
 }
 
 func (h *ChatCompletionsHandler) Provision(ctx caddy.Context) error {
-	h.logger = ctx.Logger(h)
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	if h.routers, err = routerScopeOf(ctx); err != nil {
+		return err
+	}
+	if err := h.RouteOptions.provision(h.logger); err != nil {
+		return fmt.Errorf("ai_chat_completions: %v", err)
+	}
 	return nil
 }
 
 func (h *ChatCompletionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	cr, ok := getRouter(h.Router)
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
 	if !ok {
-		http.Error(w, fmt.Sprintf("ai_chat_completions: router '%s' not found", h.Router), http.StatusInternalServerError)
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_chat_completions: router '%s' not found", routerName))
 		return nil
 	}
+	defer cr.track()()
 
-	// Fire a pageview event for observability (without query string)
-	urlWithoutQs := r.URL.String()
-	if r.URL.RawQuery != "" {
-		urlWithoutQs = urlWithoutQs[:len(urlWithoutQs)-len(r.URL.RawQuery)-1]
-	}
-	common.FireObservabilityEvent("system", urlWithoutQs, "$pageview", map[string]any{
-		"$ip": r.RemoteAddr,
-	})
+	cr.firePageviewEvent(r)
 
-	var apiKeyService auth.ExternalAPIKeyProvider
-	if val := r.Context().Value(ExternalAPIKeyProviderContextKeyString); val != nil {
-		if svc, ok := val.(auth.ExternalAPIKeyProvider); ok {
-			apiKeyService = svc
-		}
-	}
-	if apiKeyService == nil {
-		apiKeyService = auth.NewDefaultEnvAPIKeyProvider(cr.logger)
-	}
+	apiKeyService := cr.apiKeyServiceFor(r)
 
 	if r.Method == http.MethodPost {
-		return cr.handlePostInferenceRequest(w, r, next, apiKeyService)
+		return cr.handlePostInferenceRequest(w, r, next, apiKeyService, &h.RouteOptions)
 	}
 	return next.ServeHTTP(w, r)
 }
@@ -525,7 +1610,11 @@ func parseChatHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHand
 				}
 				ch.Router = h.Val()
 			default:
-				return nil, h.Errf("unrecognized ai_chat_completions option '%s'", h.Val())
+				if ok, err := ch.RouteOptions.unmarshalCaddyfileOption(h.Dispenser); err != nil {
+					return nil, err
+				} else if !ok {
+					return nil, h.Errf("unrecognized ai_chat_completions option '%s'", h.Val())
+				}
 			}
 		}
 	}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"strings"
 
 	"go.uber.org/zap"
@@ -8,8 +9,13 @@ import (
 
 // resolveProviderAndModel determines the provider and actual model name from a requested model string.
 // It handles explicit provider prefixes (e.g., "provider#model_name"),
-// model-specific defaults, and a super default provider.
-func (cr *AICoreRouter) resolveProviderAndModel(requestedModel string) (providerName string, actualModelName string) { // Receiver changed to AICoreRouter (cr)
+// and the providers listed by the routing rule that matched the request (route), which
+// include the model-specific defaults. When a conversation key is given and sticky routing is enabled, model defaults with several
+// providers are resolved consistently per conversation; otherwise the routing strategy picks one.
+// Providers on rate-limit cool-down for the model are skipped while alternates remain.
+func (cr *AICoreRouter) resolveProviderAndModel(ctx context.Context, route modelRoute, conversationKey string) (providerName string, actualModelName string) { // Receiver changed to AICoreRouter (cr)
+	logger := cr.requestLogger(ctx)
+	requestedModel := route.model
 	cr.mu.RLock() // Ensure read lock for accessing shared provider maps
 	defer cr.mu.RUnlock()
 
@@ -21,26 +27,46 @@ func (cr *AICoreRouter) resolveProviderAndModel(requestedModel string) (provider
 		pName := strings.ToLower(parts[0])
 		model := parts[1]
 		if _, ok := cr.Providers[pName]; ok { // Check if the prefixed provider is configured
-			cr.logger.Debug("Found explicit provider by prefix", zap.String("prefix", pName), zap.String("model", model)) // Changed to Debug
+			logger.Debug("Found explicit provider by prefix", zap.String("prefix", pName), zap.String("model", model)) // Changed to Debug
 			return pName, model
 		}
 		// Log if prefix is found but provider isn't recognized, then proceed to other checks
-		cr.logger.Debug("Prefix found but provider not recognized, checking defaults", zap.String("prefix", pName), zap.String("requested_model", requestedModel)) // Changed to Debug
+		logger.Debug("Prefix found but provider not recognized, checking defaults", zap.String("prefix", pName), zap.String("requested_model", requestedModel)) // Changed to Debug
 	}
 
-	// Check for model-specific default provider
-	if pNames, ok := cr.DefaultProviderForModel[requestedModel]; ok {
+	// Check for the providers of the matching routing rule or model-specific default
+	if pNames := route.providers; len(pNames) > 0 {
+		pNames = cr.withoutCoolingDown(ctx, requestedModel, pNames)
+		if conversationKey != "" && len(pNames) > 1 {
+			if pName := cr.pickStickyProvider(conversationKey, pNames); pName != "" {
+				logger.Debug("Found sticky provider for conversation", zap.String("model", requestedModel), zap.String("provider", pName))
+				return pName, requestedModel
+			}
+		}
+		if len(pNames) > 1 {
+			var pName string
+			switch cr.routingStrategy() {
+			case RoutingStrategyWeighted:
+				pName = cr.pickWeighted(pNames)
+			case RoutingStrategyLatencyAware:
+				pName = cr.pickLatencyAware(requestedModel, pNames)
+			}
+			if pName != "" {
+				logger.Debug("Picked provider by routing strategy", zap.String("model", requestedModel), zap.String("provider", pName), zap.String("strategy", cr.routingStrategy()))
+				return pName, requestedModel
+			}
+		}
 		for _, pName := range pNames {
 			if _, providerExists := cr.Providers[pName]; providerExists {
-				cr.logger.Debug("Found default provider for model", zap.String("model", requestedModel), zap.String("provider", pName)) // Changed to Debug
-				return pName, requestedModel                                                                                            // Model name remains as requested
+				logger.Debug("Found default provider for model", zap.String("model", requestedModel), zap.String("provider", pName), zap.String("rule", route.rule)) // Changed to Debug
+				return pName, requestedModel                                                                                                                         // Model name remains as requested
 			}
-			cr.logger.Warn("Default provider for model configured but provider itself not found", zap.String("model", requestedModel), zap.String("configured_provider", pName))
+			logger.Warn("Default provider for model configured but provider itself not found", zap.String("model", requestedModel), zap.String("configured_provider", pName))
 		}
 	}
 
 	// If no provider could be resolved
-	cr.logger.Warn("Could not resolve provider for model", zap.String("model", requestedModel))
+	logger.Warn("Could not resolve provider for model", zap.String("model", requestedModel))
 	return "", requestedModel // Return empty provider name, model name as is
 }
 
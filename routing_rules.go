@@ -0,0 +1,367 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/dustin/go-humanize"
+)
+
+// UserTierContextKeyString is the context key an authentication layer stores the user's
+// tier (e.g. "free", "pro") under, next to ai_user_id; routing rules can match on it.
+const UserTierContextKeyString string = "ai_user_tier"
+
+// RequestFeaturesContextKeyString holds the *requestFeatures of a unified request body.
+const RequestFeaturesContextKeyString string = "ai_request_features"
+
+// RoutingRule sends requests for matching models to a list of providers, optionally under a
+// different model name. Rules are evaluated in order and the first match applies; every
+// condition given must hold. Per-model defaults (default_provider_for_model) act as exact
+// model rules evaluated after the configured ones.
+type RoutingRule struct {
+	Name string `json:"name,omitempty"`
+	// Glob patterns ("*" and "?") matched against the requested model; any may match
+	Models []string `json:"models,omitempty"`
+	// Regular expression matched against the requested model
+	ModelRegex string `json:"model_regex,omitempty"`
+	// Request headers that must be present, each with a value matching one of the globs
+	Headers map[string][]string `json:"headers,omitempty"`
+	// User tiers (ai_user_tier) the rule applies to
+	Tiers []string `json:"tiers,omitempty"`
+	// Bounds on the request body size in bytes (0 = unbounded)
+	MinRequestSize int64 `json:"min_request_size,omitempty"`
+	MaxRequestSize int64 `json:"max_request_size,omitempty"`
+	// Whether the request streams, and whether it offers tools (or legacy functions); unset matches either
+	Stream *bool `json:"stream,omitempty"`
+	Tools  *bool `json:"tools,omitempty"`
+	// Bounds on the estimated prompt tokens of the request (0 = unbounded)
+	MinPromptTokens int `json:"min_prompt_tokens,omitempty"`
+	MaxPromptTokens int `json:"max_prompt_tokens,omitempty"`
+	// Providers serving matching requests, in order; empty resolves the (rewritten) model as usual
+	Providers []string `json:"providers,omitempty"`
+	// Model name requests are routed under instead of the requested one
+	Rewrite string `json:"rewrite,omitempty"`
+
+	models  []*regexp.Regexp
+	regex   *regexp.Regexp
+	headers map[string][]*regexp.Regexp
+}
+
+// modelRoute is the outcome of routing rules for one request.
+type modelRoute struct {
+	model     string   // Model to resolve, after any rewrite
+	providers []string // Candidate providers in order; empty if no rule lists any
+	rule      string   // Name of the rule that matched, if any
+}
+
+// globPattern compiles a glob where "*" matches any run of characters (slashes included,
+// since model names often contain them) and "?" matches a single character.
+func globPattern(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func (rule *RoutingRule) provision() error {
+	if len(rule.Providers) == 0 && rule.Rewrite == "" {
+		return fmt.Errorf("routing rule %s: providers or rewrite is required", rule.Name)
+	}
+	if rule.MaxRequestSize > 0 && rule.MinRequestSize > rule.MaxRequestSize {
+		return fmt.Errorf("routing rule %s: min_request_size exceeds max_request_size", rule.Name)
+	}
+	if rule.MaxPromptTokens > 0 && rule.MinPromptTokens > rule.MaxPromptTokens {
+		return fmt.Errorf("routing rule %s: min_prompt_tokens exceeds max_prompt_tokens", rule.Name)
+	}
+	rule.models = nil
+	for _, glob := range rule.Models {
+		rule.models = append(rule.models, globPattern(glob))
+	}
+	rule.regex = nil
+	if rule.ModelRegex != "" {
+		re, err := regexp.Compile(rule.ModelRegex)
+		if err != nil {
+			return fmt.Errorf("routing rule %s: invalid model_regex: %v", rule.Name, err)
+		}
+		rule.regex = re
+	}
+	rule.headers = make(map[string][]*regexp.Regexp, len(rule.Headers))
+	for name, globs := range rule.Headers {
+		for _, glob := range globs {
+			rule.headers[name] = append(rule.headers[name], globPattern(glob))
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to a request for a model.
+func (rule *RoutingRule) matches(r *http.Request, model string) bool {
+	if len(rule.models) > 0 && !anyMatch(rule.models, model) {
+		return false
+	}
+	if rule.regex != nil && !rule.regex.MatchString(model) {
+		return false
+	}
+	if r == nil {
+		return len(rule.headers) == 0 && len(rule.Tiers) == 0 && rule.MinRequestSize == 0 && rule.MaxRequestSize == 0 && !rule.inspectsBody()
+	}
+	for name, globs := range rule.headers {
+		if !anyMatch(globs, r.Header.Get(name)) {
+			return false
+		}
+	}
+	if len(rule.Tiers) > 0 {
+		tier, _ := r.Context().Value(UserTierContextKeyString).(string)
+		found := false
+		for _, t := range rule.Tiers {
+			if strings.EqualFold(t, tier) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.MinRequestSize > 0 && r.ContentLength < rule.MinRequestSize {
+		return false
+	}
+	if rule.MaxRequestSize > 0 && (r.ContentLength < 0 || r.ContentLength > rule.MaxRequestSize) {
+		return false
+	}
+	if rule.inspectsBody() {
+		features, _ := r.Context().Value(RequestFeaturesContextKeyString).(*requestFeatures)
+		if features == nil {
+			return false
+		}
+		if rule.Stream != nil && *rule.Stream != features.stream {
+			return false
+		}
+		if rule.Tools != nil && *rule.Tools != features.tools {
+			return false
+		}
+		if rule.MinPromptTokens > 0 && features.promptTokens() < rule.MinPromptTokens {
+			return false
+		}
+		if rule.MaxPromptTokens > 0 && features.promptTokens() > rule.MaxPromptTokens {
+			return false
+		}
+	}
+	return true
+}
+
+// inspectsBody reports whether the rule has conditions on the request body.
+func (rule *RoutingRule) inspectsBody() bool {
+	return rule.Stream != nil || rule.Tools != nil || rule.MinPromptTokens > 0 || rule.MaxPromptTokens > 0
+}
+
+// requestFeatures are the properties of a unified request body routing rules can match on.
+// Prompt tokens are only counted once a rule asks for them.
+type requestFeatures struct {
+	stream bool
+	tools  bool
+
+	countOnce sync.Once
+	count     func() int
+	tokens    int
+}
+
+// withRequestFeatures stores the features of a unified request body in a context.
+func (cr *AICoreRouter) withRequestFeatures(ctx context.Context, body []byte) context.Context {
+	var req struct {
+		Stream    bool              `json:"stream"`
+		Tools     []json.RawMessage `json:"tools"`
+		Functions []json.RawMessage `json:"functions"`
+	}
+	_ = json.Unmarshal(body, &req)
+	features := &requestFeatures{
+		stream: req.Stream,
+		tools:  len(req.Tools) > 0 || len(req.Functions) > 0,
+		count:  func() int { return cr.requestPromptTokens(body) },
+	}
+	return context.WithValue(ctx, RequestFeaturesContextKeyString, features)
+}
+
+func (f *requestFeatures) promptTokens() int {
+	f.countOnce.Do(func() { f.tokens = f.count() })
+	return f.tokens
+}
+
+func anyMatch(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// provisionRoutingRules compiles the configured rules, followed by one exact-match rule per
+// default_provider_for_model entry. Must be called after providers are provisioned.
+func (cr *AICoreRouter) provisionRoutingRules() error {
+	rules := make([]*RoutingRule, 0, len(cr.Rules)+len(cr.DefaultProviderForModel))
+	for i, rule := range cr.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule_%d", i)
+		}
+		rules = append(rules, rule)
+	}
+	models := make([]string, 0, len(cr.DefaultProviderForModel))
+	for model := range cr.DefaultProviderForModel {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		rules = append(rules, &RoutingRule{
+			Name:       "default_provider_for_model " + model,
+			ModelRegex: "^" + regexp.QuoteMeta(model) + "$",
+			Providers:  cr.DefaultProviderForModel[model],
+		})
+	}
+	for _, rule := range rules {
+		if err := rule.provision(); err != nil {
+			return err
+		}
+		for _, providerName := range rule.Providers {
+			if _, ok := cr.Providers[providerName]; !ok {
+				return fmt.Errorf("routing rule %s: provider '%s' is not configured", rule.Name, providerName)
+			}
+		}
+	}
+	cr.routingRules = rules
+	return nil
+}
+
+// routeModel applies the first matching routing rule to a requested model. The request may
+// be nil, in which case only rules without request conditions can match.
+func (cr *AICoreRouter) routeModel(r *http.Request, requestedModel string) modelRoute {
+	for _, rule := range cr.routingRules {
+		if !rule.matches(r, requestedModel) {
+			continue
+		}
+		route := modelRoute{model: requestedModel, providers: rule.Providers, rule: rule.Name}
+		if rule.Rewrite != "" {
+			route.model = rule.Rewrite
+		}
+		return route
+	}
+	return modelRoute{model: requestedModel}
+}
+
+// parseRoutingRuleCaddyfile parses a `rule [name] { ... }` block.
+func parseRoutingRuleCaddyfile(d *caddyfile.Dispenser) (*RoutingRule, error) {
+	rule := &RoutingRule{}
+	if d.NextArg() {
+		rule.Name = d.Val()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "model":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			rule.Models = append(rule.Models, args...)
+		case "model_regex":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			rule.ModelRegex = d.Val()
+		case "header":
+			args := d.RemainingArgs()
+			if len(args) < 2 {
+				return nil, d.Errf("header expects <name> <value_glob> [<value_glob>...]")
+			}
+			if rule.Headers == nil {
+				rule.Headers = make(map[string][]string)
+			}
+			name := http.CanonicalHeaderKey(args[0])
+			rule.Headers[name] = append(rule.Headers[name], args[1:]...)
+		case "tier":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			rule.Tiers = append(rule.Tiers, args...)
+		case "min_request_size", "max_request_size":
+			option := d.Val()
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid %s '%s': %v", option, d.Val(), err)
+			}
+			if option == "min_request_size" {
+				rule.MinRequestSize = int64(size)
+			} else {
+				rule.MaxRequestSize = int64(size)
+			}
+		case "stream", "tools":
+			option := d.Val()
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			value, err := strconv.ParseBool(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid %s '%s': expected true or false", option, d.Val())
+			}
+			if option == "stream" {
+				rule.Stream = &value
+			} else {
+				rule.Tools = &value
+			}
+		case "min_prompt_tokens", "max_prompt_tokens":
+			option := d.Val()
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil || n < 0 {
+				return nil, d.Errf("invalid %s '%s'", option, d.Val())
+			}
+			if option == "min_prompt_tokens" {
+				rule.MinPromptTokens = n
+			} else {
+				rule.MaxPromptTokens = n
+			}
+		case "providers":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			for _, pName := range args {
+				rule.Providers = append(rule.Providers, strings.ToLower(pName))
+			}
+		case "rewrite":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			rule.Rewrite = d.Val()
+		default:
+			return nil, d.Errf("unrecognized rule option '%s'", d.Val())
+		}
+	}
+	if len(rule.Providers) == 0 && rule.Rewrite == "" {
+		return nil, d.Err("rule requires providers or rewrite")
+	}
+	return rule, nil
+}
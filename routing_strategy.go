@@ -0,0 +1,261 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Strategies for picking among the providers configured for a model.
+const (
+	RoutingStrategyFailover     = "failover"      // First configured provider (default)
+	RoutingStrategyWeighted     = "weighted"      // Random, proportional to provider weights
+	RoutingStrategyLatencyAware = "latency_aware" // Fastest healthy provider by rolling p95 latency
+)
+
+const RouteSampleContextKeyString string = "ai_route_sample"
+
+const (
+	latencyWindowSize        = 128
+	defaultLatencyWindow     = 5 * time.Minute
+	latencyMinSamples        = 5   // Below this, a provider's stats aren't trusted yet
+	defaultMaxErrorRate      = 0.5 // Providers failing more often than this are deprioritized
+	latencyExplorationChance = 0.05
+)
+
+// LatencyAwareConfig tunes the latency_aware strategy.
+type LatencyAwareConfig struct {
+	// How far back samples count (defaults to 5m)
+	Window caddy.Duration `json:"window,omitempty"`
+	// Error rate above which a provider is treated as unhealthy (defaults to 0.5)
+	MaxErrorRate float64 `json:"max_error_rate,omitempty"`
+}
+
+func (c *LatencyAwareConfig) window() time.Duration {
+	if c == nil || c.Window <= 0 {
+		return defaultLatencyWindow
+	}
+	return time.Duration(c.Window)
+}
+
+func (c *LatencyAwareConfig) maxErrorRate() float64 {
+	if c == nil || c.MaxErrorRate <= 0 {
+		return defaultMaxErrorRate
+	}
+	return c.MaxErrorRate
+}
+
+// routingStrategy returns the configured strategy, defaulting to failover.
+func (cr *AICoreRouter) routingStrategy() string {
+	if cr.Strategy == "" {
+		return RoutingStrategyFailover
+	}
+	return cr.Strategy
+}
+
+func validateRoutingStrategy(strategy string) error {
+	switch strategy {
+	case "", RoutingStrategyFailover, RoutingStrategyWeighted, RoutingStrategyLatencyAware:
+		return nil
+	}
+	return fmt.Errorf("unknown routing strategy '%s' (expected failover, weighted or latency_aware)", strategy)
+}
+
+// routeSample measures one proxied request for the latency stats. The director stamps the
+// start so queueing and request transforms don't count towards provider latency.
+type routeSample struct {
+	key   string
+	start time.Time
+	done  bool
+}
+
+type latencyObservation struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// latencyStats keeps a ring of recent observations for one provider/model pair.
+type latencyStats struct {
+	observations [latencyWindowSize]latencyObservation
+	next         int
+	count        int
+}
+
+// providerHealth summarizes the recent observations of a provider/model pair.
+type providerHealth struct {
+	samples   int
+	errorRate float64
+	p95       time.Duration
+}
+
+// latencyTracker collects latencies and failures per provider/model for latency_aware routing.
+type latencyTracker struct {
+	mu    sync.Mutex
+	stats map[string]*latencyStats
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{stats: make(map[string]*latencyStats)}
+}
+
+func latencyKey(providerName, model string) string {
+	return providerName + "/" + model
+}
+
+// finish records the outcome of a sample once; time to response headers is what's measured.
+func (lt *latencyTracker) finish(sample *routeSample, failed bool) {
+	if sample == nil || sample.done || sample.start.IsZero() {
+		return
+	}
+	sample.done = true
+	now := time.Now()
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	s, ok := lt.stats[sample.key]
+	if !ok {
+		s = &latencyStats{}
+		lt.stats[sample.key] = s
+	}
+	s.observations[s.next] = latencyObservation{at: now, latency: now.Sub(sample.start), failed: failed}
+	s.next = (s.next + 1) % latencyWindowSize
+	if s.count < latencyWindowSize {
+		s.count++
+	}
+}
+
+// health summarizes observations newer than the window.
+func (lt *latencyTracker) health(key string, window time.Duration) providerHealth {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	s, ok := lt.stats[key]
+	if !ok {
+		return providerHealth{}
+	}
+	cutoff := time.Now().Add(-window)
+	latencies := make([]time.Duration, 0, s.count)
+	failures := 0
+	for i := 0; i < s.count; i++ {
+		o := s.observations[i]
+		if o.at.Before(cutoff) {
+			continue
+		}
+		if o.failed {
+			failures++
+			continue
+		}
+		latencies = append(latencies, o.latency)
+	}
+	h := providerHealth{samples: len(latencies) + failures}
+	if h.samples == 0 {
+		return h
+	}
+	h.errorRate = float64(failures) / float64(h.samples)
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		h.p95 = latencies[(len(latencies)*95-1)/100]
+	}
+	return h
+}
+
+// pickLatencyAware returns the provider with the lowest p95 among the healthy candidates.
+// Providers without enough recent samples are tried first so they get measured, and a small
+// share of traffic explores at random to keep stale stats fresh.
+// Must be called with cr.mu held.
+func (cr *AICoreRouter) pickLatencyAware(model string, candidates []string) string {
+	available := make([]string, 0, len(candidates))
+	for _, name := range candidates {
+		if _, ok := cr.Providers[name]; ok {
+			available = append(available, name)
+		}
+	}
+	if len(available) == 0 {
+		return ""
+	}
+	if len(available) > 1 && rand.Float64() < latencyExplorationChance {
+		return available[rand.Intn(len(available))]
+	}
+	// Faster advertised throughput goes first, so it's measured first and wins ties
+	sort.SliceStable(available, func(i, j int) bool {
+		return cr.advertisedThroughput(available[i], model) > cr.advertisedThroughput(available[j], model)
+	})
+
+	window, maxErrorRate := cr.LatencyAware.window(), cr.LatencyAware.maxErrorRate()
+	best, bestHealthy := "", false
+	var bestHealth providerHealth
+	better := func(h providerHealth, healthy bool) bool {
+		switch {
+		case best == "":
+			return true
+		case healthy != bestHealthy:
+			return healthy
+		case healthy:
+			return h.p95 < bestHealth.p95
+		}
+		return h.errorRate < bestHealth.errorRate
+	}
+	for _, name := range available {
+		h := cr.latency.health(latencyKey(name, model), window)
+		if h.samples < latencyMinSamples {
+			return name
+		}
+		if healthy := h.errorRate <= maxErrorRate; better(h, healthy) {
+			best, bestHealthy, bestHealth = name, healthy, h
+		}
+	}
+	return best
+}
+
+// advertisedThroughput returns the tokens per second a provider's cached model list (or
+// manifest) gives for the model, or 0 if unknown. It never waits for a models fetch.
+// Must be called with cr.mu held.
+func (cr *AICoreRouter) advertisedThroughput(providerName, model string) int {
+	p, ok := cr.Providers[providerName]
+	if !ok || cr.modelsCache == nil {
+		return 0
+	}
+	for _, m := range cr.modelsCache.Peek(p) {
+		if id, _ := m["id"].(string); id == model {
+			return intFromAny(m["tokens_per_second"])
+		}
+	}
+	return 0
+}
+
+// pickWeighted returns a random candidate with probability proportional to its weight.
+// Must be called with cr.mu held.
+func (cr *AICoreRouter) pickWeighted(candidates []string) string {
+	total := 0.0
+	for _, name := range candidates {
+		if p, ok := cr.Providers[name]; ok {
+			total += providerWeight(p)
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	target := rand.Float64() * total
+	last := ""
+	for _, name := range candidates {
+		p, ok := cr.Providers[name]
+		if !ok {
+			continue
+		}
+		last = name
+		if target -= providerWeight(p); target < 0 {
+			return name
+		}
+	}
+	return last
+}
+
+func providerWeight(p *ProviderConfig) float64 {
+	if p.Weight <= 0 {
+		return 1
+	}
+	return p.Weight
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// maxPendingSSEData caps how much of an incomplete JSON payload is buffered before it is dropped.
+const maxPendingSSEData = 1 << 20
+
+// Anomalies repaired in upstream SSE streams, as counted by the sse_repairs_total metric.
+const (
+	sseAnomalySplit        = "split"        // A JSON payload spread over several data lines or events
+	sseAnomalyConcatenated = "concatenated" // Several JSON payloads in one event
+	sseAnomalyRepaired     = "repaired"     // An invalid payload fixed up (trailing commas, control characters, truncation)
+	sseAnomalyDropped      = "dropped"      // Bytes that couldn't be made into JSON
+)
+
+// repairStream makes an upstream SSE stream well-formed before it's transformed for the client:
+// JSON payloads are buffered until complete, payloads sent together are split into their own
+// events, common defects are repaired, and what can't be repaired is dropped instead of
+// corrupting the client's stream. Anomalies are logged once per stream and counted.
+func (cr *AICoreRouter) repairStream(resp *http.Response, p *ProviderConfig) {
+	if p.DisableSSERepair || resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Body = &sseRepairBody{
+		src:       bufio.NewReader(resp.Body),
+		closer:    resp.Body,
+		anomalies: make(map[string]int),
+		report: func(anomalies map[string]int) {
+			fields := []zap.Field{zap.String("provider", p.Name)}
+			for anomaly, n := range anomalies {
+				fields = append(fields, zap.Int(anomaly, n))
+				sseRepairs.WithLabelValues(cr.Name, p.Name, anomaly).Add(float64(n))
+			}
+			cr.requestLogger(resp.Request.Context()).Warn("Repaired malformed upstream SSE stream", fields...)
+		},
+	}
+}
+
+// sseRepairBody rewrites an SSE stream event by event; see repairStream.
+type sseRepairBody struct {
+	src    *bufio.Reader
+	closer io.Closer
+	report func(map[string]int)
+
+	pending   []byte // Data of an incomplete JSON payload
+	lineStart int    // Where the latest data line starts in pending
+	open      bool   // Field lines written without the blank line ending their event
+	out       bytes.Buffer
+	anomalies map[string]int
+	err       error
+}
+
+func (b *sseRepairBody) Read(p []byte) (int, error) {
+	for b.out.Len() == 0 && b.err == nil {
+		line, err := b.src.ReadBytes('\n')
+		if len(line) > 0 {
+			b.processLine(bytes.TrimRight(line, "\r\n"))
+		}
+		if err != nil {
+			b.flushPending()
+			b.err = err
+			if len(b.anomalies) > 0 {
+				b.report(b.anomalies)
+			}
+		}
+	}
+	if b.out.Len() > 0 {
+		return b.out.Read(p)
+	}
+	return 0, b.err
+}
+
+func (b *sseRepairBody) Close() error {
+	return b.closer.Close()
+}
+
+func (b *sseRepairBody) processLine(line []byte) {
+	switch {
+	case len(line) == 0:
+		if len(b.pending) > 0 {
+			return // The payload continues in the next event
+		}
+		if b.open {
+			b.out.WriteByte('\n')
+			b.open = false
+		}
+	case bytes.HasPrefix(line, []byte("data:")):
+		data := line
+		// Some upstreams repeat the field name
+		for bytes.HasPrefix(data, []byte("data:")) {
+			data = bytes.TrimLeft(data[len("data:"):], " ")
+		}
+		if string(bytes.TrimSpace(data)) == "[DONE]" {
+			b.flushPending()
+			b.writeEvent(bytes.TrimSpace(data))
+			return
+		}
+		b.lineStart = len(b.pending)
+		b.pending = append(b.pending, data...)
+		b.drain()
+	case line[0] == ':':
+		b.out.Write(line) // Comments, e.g. keep-alives
+		b.out.WriteByte('\n')
+		b.open = true
+	default:
+		b.flushPending() // A new event starts; what's pending won't be completed
+		b.out.Write(line)
+		b.out.WriteByte('\n')
+		b.open = true
+	}
+}
+
+// drain writes out every complete JSON payload at the start of pending.
+func (b *sseRepairBody) drain() {
+	payloads := 0
+	for {
+		b.consume(len(b.pending) - len(bytes.TrimLeft(b.pending, " \t")))
+		if len(b.pending) == 0 {
+			break
+		}
+		dec := json.NewDecoder(bytes.NewReader(b.pending))
+		var payload json.RawMessage
+		err := dec.Decode(&payload)
+		if err == nil {
+			if b.lineStart > 0 && payloads == 0 {
+				b.anomalies[sseAnomalySplit]++
+			}
+			payloads++
+			b.writeEvent(payload)
+			b.consume(int(dec.InputOffset()))
+			continue
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			if len(b.pending) > maxPendingSSEData {
+				b.anomalies[sseAnomalyDropped]++
+				b.consume(len(b.pending))
+			}
+			break
+		}
+		if b.lineStart > 0 {
+			// The payload of earlier lines was cut off and the latest line starts a new one
+			b.flush(b.pending[:b.lineStart])
+			b.consume(b.lineStart)
+			continue
+		}
+		if c := b.pending[0]; c != '{' && c != '[' {
+			// Garbage before the payload
+			skip := bytes.IndexAny(b.pending, "{[")
+			if skip < 0 {
+				skip = len(b.pending)
+			}
+			b.anomalies[sseAnomalyDropped]++
+			b.consume(skip)
+			continue
+		}
+		end := valueEnd(b.pending)
+		if end < 0 {
+			// Not closed yet; the next line may complete it, else it's repaired when the event ends
+			break
+		}
+		b.flush(b.pending[:end])
+		payloads++
+		b.consume(end)
+	}
+	if payloads > 1 {
+		b.anomalies[sseAnomalyConcatenated] += payloads - 1
+	}
+}
+
+// consume drops n bytes from the start of pending.
+func (b *sseRepairBody) consume(n int) {
+	b.pending = b.pending[n:]
+	if b.lineStart = max(b.lineStart-n, 0); len(b.pending) == 0 {
+		b.pending = nil
+	}
+}
+
+// flush repairs and writes out, or drops, a payload that will not be completed.
+func (b *sseRepairBody) flush(data []byte) {
+	if repaired, ok := repairJSON(data); ok {
+		b.anomalies[sseAnomalyRepaired]++
+		b.writeEvent(repaired)
+	} else {
+		b.anomalies[sseAnomalyDropped]++
+	}
+}
+
+// flushPending repairs or drops what's pending when its event can't continue.
+func (b *sseRepairBody) flushPending() {
+	if len(bytes.TrimSpace(b.pending)) > 0 {
+		b.flush(b.pending)
+	}
+	b.pending, b.lineStart = nil, 0
+}
+
+func (b *sseRepairBody) writeEvent(data []byte) {
+	b.out.WriteString("data: ")
+	b.out.Write(data)
+	b.out.WriteString("\n\n")
+	b.open = false
+}
+
+// repairJSON fixes the defects upstreams commonly stream: raw control characters in strings,
+// trailing commas, and objects cut off before their strings and brackets were closed.
+func repairJSON(data []byte) ([]byte, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || (data[0] != '{' && data[0] != '[') {
+		return nil, false
+	}
+	var out []byte
+	var closers []byte
+	inString, escaped := false, false
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			case c < ' ':
+				out = append(out, []byte(`\u00`)...)
+				out = append(out, "0123456789abcdef"[c>>4], "0123456789abcdef"[c&0xf])
+				continue
+			}
+			out = append(out, c)
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			closers = append(closers, '}')
+		case '[':
+			closers = append(closers, ']')
+		case '}', ']':
+			out = bytes.TrimRight(out, " \t\r\n,")
+			if len(closers) == 0 || closers[len(closers)-1] != c {
+				return nil, false
+			}
+			closers = closers[:len(closers)-1]
+		}
+		out = append(out, c)
+	}
+	if escaped {
+		out = out[:len(out)-1]
+	}
+	if inString {
+		out = append(out, '"')
+	}
+	if len(closers) > 0 {
+		out = bytes.TrimRight(out, " \t\r\n,:")
+		// A key cut off before its value can't be kept
+		if bytes.HasSuffix(out, []byte(`"`)) && closers[len(closers)-1] == '}' {
+			if start := lastKeyStart(out); start >= 0 {
+				out = bytes.TrimRight(out[:start], " \t\r\n,")
+			}
+		}
+	}
+	for i := len(closers) - 1; i >= 0; i-- {
+		out = append(out, closers[i])
+	}
+	if !json.Valid(out) {
+		return nil, false
+	}
+	return out, true
+}
+
+// lastKeyStart returns where the trailing string of an object starts if it's a key without a
+// value, i.e. the string follows '{' or ','; otherwise -1.
+func lastKeyStart(out []byte) int {
+	i := len(out) - 2
+	for ; i >= 0; i-- {
+		if out[i] == '"' && (i == 0 || out[i-1] != '\\') {
+			break
+		}
+	}
+	if i < 0 {
+		return -1
+	}
+	before := bytes.TrimRight(out[:i], " \t\r\n")
+	if len(before) > 0 && (before[len(before)-1] == '{' || before[len(before)-1] == ',') {
+		return i
+	}
+	return -1
+}
+
+// valueEnd returns the index just past the object or array data starts with, or -1 if it isn't
+// closed.
+func valueEnd(data []byte) int {
+	depth := 0
+	inString, escaped := false, false
+	for i, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
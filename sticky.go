@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+)
+
+// Conversation identifier sources for sticky routing, tried in configured order.
+const (
+	StickySourceHeader = "header" // X-Conversation-Id request header
+	StickySourceUser   = "user"   // "user" field of the request body
+	StickySourceSystem = "system" // content of the first system message
+)
+
+const ConversationIDHeader = "X-Conversation-Id"
+
+// conversationKey derives the sticky routing key for a request from the configured sources.
+// It returns an empty string if sticky routing is disabled or no source yields a value.
+func (cr *AICoreRouter) conversationKey(r *http.Request, body []byte) string {
+	if len(cr.StickyRouting) == 0 {
+		return ""
+	}
+
+	var payload struct {
+		User     string                          `json:"user"`
+		Messages []transforms.UnifiedChatMessage `json:"messages"`
+	}
+	_ = json.Unmarshal(body, &payload)
+
+	for _, source := range cr.StickyRouting {
+		switch source {
+		case StickySourceHeader:
+			if id := strings.TrimSpace(r.Header.Get(ConversationIDHeader)); id != "" {
+				return "conversation:" + id
+			}
+		case StickySourceUser:
+			if payload.User != "" {
+				return "user:" + payload.User
+			}
+		case StickySourceSystem:
+			for _, msg := range payload.Messages {
+				if msg.IsSystem() && msg.Content != "" {
+					return "system:" + msg.Content
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// pickStickyProvider selects one of the candidate providers for a conversation key using
+// weighted rendezvous hashing, so a conversation keeps hitting the same provider (and its
+// prompt cache) and only conversations mapped to a removed provider move elsewhere.
+// Must be called with cr.mu held.
+func (cr *AICoreRouter) pickStickyProvider(key string, candidates []string) string {
+	best := ""
+	bestScore := math.Inf(-1)
+	for _, name := range candidates {
+		p, ok := cr.Providers[name]
+		if !ok {
+			continue
+		}
+		weight := providerWeight(p)
+
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(name))
+		// Map the hash to (0, 1) and score it; larger weights win proportionally more keys
+		u := (float64(h.Sum64()>>11) + 0.5) / float64(uint64(1)<<53)
+		score := -weight / math.Log(u)
+		if score > bestScore {
+			bestScore = score
+			best = name
+		}
+	}
+	return best
+}
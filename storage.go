@@ -0,0 +1,61 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/storage"
+)
+
+// parseStorageCaddyfile parses a `storage <backend> [{ ... }]` option.
+func parseStorageCaddyfile(d *caddyfile.Dispenser) (*storage.Config, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	cfg := &storage.Config{Backend: strings.ToLower(d.Val())}
+	if cfg.Backend != storage.BackendMemory && cfg.Backend != storage.BackendRedis {
+		return nil, d.Errf("unsupported storage backend '%s'", d.Val())
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "address":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Address = d.Val()
+		case "username":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Username = d.Val()
+		case "password":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Password = d.Val()
+		case "db":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			db, err := strconv.Atoi(d.Val())
+			if err != nil || db < 0 {
+				return nil, d.Errf("invalid storage db '%s'", d.Val())
+			}
+			cfg.DB = db
+		case "prefix":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Prefix = d.Val()
+		case "tls":
+			cfg.TLS = true
+		default:
+			return nil, d.Errf("unrecognized storage option '%s'", d.Val())
+		}
+	}
+	if cfg.Backend == storage.BackendRedis && cfg.Address == "" {
+		return nil, d.Err("storage redis: address is required")
+	}
+	return cfg, nil
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+)
+
+// SystemPromptConfig injects operator text into the system prompt of every request on a route.
+// All fields may contain Caddy placeholders such as {http.request.header.X-Tenant}.
+type SystemPromptConfig struct {
+	// Text placed before the client's system prompt
+	Prefix string `json:"prefix,omitempty"`
+	// Text placed after the client's system prompt
+	Suffix string `json:"suffix,omitempty"`
+	// Replaces the client's system prompt entirely (prefix and suffix still apply)
+	Override string `json:"override,omitempty"`
+}
+
+// joinPromptParts joins the non-empty parts with blank lines.
+func joinPromptParts(parts ...string) string {
+	nonEmpty := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, "\n\n")
+}
+
+// applySystemPrompt rewrites the first system (or developer) message of a unified request,
+// adding one at the start if the client sent none.
+func applySystemPrompt(r *http.Request, body []byte, cfg *SystemPromptConfig) ([]byte, error) {
+	if cfg == nil {
+		return body, nil
+	}
+
+	expand := func(s string) string { return s }
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		expand = func(s string) string { return repl.ReplaceKnown(s, "") }
+	}
+	prefix, suffix := expand(cfg.Prefix), expand(cfg.Suffix)
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var req map[string]any
+	if err := decoder.Decode(&req); err != nil {
+		return nil, err
+	}
+	messages, _ := req["messages"].([]any)
+
+	systemIdx := -1
+	for i, m := range messages {
+		if msg, ok := m.(map[string]any); ok && (msg["role"] == "system" || msg["role"] == "developer") {
+			systemIdx = i
+			break
+		}
+	}
+
+	if systemIdx == -1 {
+		content := joinPromptParts(prefix, expand(cfg.Override), suffix)
+		if content == "" {
+			return body, nil
+		}
+		messages = append([]any{map[string]any{"role": "system", "content": content}}, messages...)
+	} else {
+		msg := messages[systemIdx].(map[string]any)
+		if cfg.Override != "" {
+			msg["content"] = joinPromptParts(prefix, expand(cfg.Override), suffix)
+		} else if parts, ok := msg["content"].([]any); ok {
+			// Content parts: add the injected text as separate text parts
+			if prefix != "" {
+				parts = append([]any{map[string]any{"type": "text", "text": prefix}}, parts...)
+			}
+			if suffix != "" {
+				parts = append(parts, map[string]any{"type": "text", "text": suffix})
+			}
+			msg["content"] = parts
+		} else {
+			existing, _ := msg["content"].(string)
+			msg["content"] = joinPromptParts(prefix, existing, suffix)
+		}
+	}
+	req["messages"] = messages
+
+	return json.Marshal(req)
+}
+
+// downgradeDeveloperRole turns the developer messages of a unified chat request into system
+// messages, unless the provider takes the developer role.
+func (p *ProviderConfig) downgradeDeveloperRole(r *http.Request) {
+	if dp, ok := p.Provider.(providers.DeveloperRoleProvider); ok && dp.SupportsDeveloperRole() {
+		return
+	}
+	if passthrough, ok := r.Context().Value(common.ResponsesPassthroughContextKeyString).(*common.ResponsesPassthrough); ok && passthrough != nil && passthrough.Native {
+		return
+	}
+	if r.Body == nil {
+		return
+	}
+	common.HookHttpRequestBody(r, func(r *http.Request, body []byte) ([]byte, error) {
+		if !bytes.Contains(body, []byte(`"developer"`)) {
+			return body, nil
+		}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var req map[string]any
+		if decoder.Decode(&req) != nil || req == nil {
+			return body, nil
+		}
+		messages, _ := req["messages"].([]any)
+		changed := false
+		for _, m := range messages {
+			if msg, ok := m.(map[string]any); ok && msg["role"] == "developer" {
+				msg["role"] = "system"
+				changed = true
+			}
+		}
+		if !changed {
+			return body, nil
+		}
+		return json.Marshal(req)
+	})
+}
+
+// parseSystemPromptCaddyfile parses `system_prompt <text>` (a prefix) or a `system_prompt { ... }` block.
+func parseSystemPromptCaddyfile(d *caddyfile.Dispenser) (*SystemPromptConfig, error) {
+	cfg := &SystemPromptConfig{}
+	if d.NextArg() {
+		cfg.Prefix = d.Val()
+		if d.NextArg() {
+			return nil, d.ArgErr()
+		}
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		switch option {
+		case "prefix":
+			cfg.Prefix = d.Val()
+		case "suffix":
+			cfg.Suffix = d.Val()
+		case "override":
+			cfg.Override = d.Val()
+		default:
+			return nil, d.Errf("unrecognized system_prompt option '%s'", option)
+		}
+	}
+	if cfg.Prefix == "" && cfg.Suffix == "" && cfg.Override == "" {
+		return nil, d.Err("system_prompt: prefix, suffix or override is required")
+	}
+	return cfg, nil
+}
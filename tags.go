@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TagsHeader carries chargeback tags as comma separated key=value pairs, e.g.
+// "project=search,cost-center=cc-42,feature=autocomplete".
+const TagsHeader = "X-AI-Tags"
+
+// RequestTagsContextKeyString carries the requestTags of an inference request.
+const RequestTagsContextKeyString string = "ai_request_tags"
+
+const (
+	maxRequestTags    = 16
+	maxTagValueLength = 256
+)
+
+var tagKeyPattern = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+// requestTags attribute a request's usage to projects, cost centers or features.
+type requestTags map[string]string
+
+// requestTags reads a request's tags from the string values of the body's metadata object and
+// from the tags header, which wins for keys set in both. Keys are lower-cased; with tag_keys
+// configured, other keys are ignored.
+func (cr *AICoreRouter) requestTags(r *http.Request, body []byte) (requestTags, error) {
+	tags := requestTags{}
+	var payload struct {
+		Metadata map[string]any `json:"metadata"`
+	}
+	json.Unmarshal(body, &payload)
+	for key, value := range payload.Metadata {
+		if s, ok := value.(string); ok {
+			tags[strings.ToLower(key)] = s
+		}
+	}
+	if header := r.Header.Get(TagsHeader); header != "" {
+		for _, pair := range strings.Split(header, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s: '%s' is not a key=value pair", TagsHeader, pair)
+			}
+			tags[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+
+	for key, value := range tags {
+		if len(cr.TagKeys) > 0 && !contains(cr.TagKeys, key) {
+			delete(tags, key)
+			continue
+		}
+		if !tagKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid tag key '%s': use up to 64 letters, digits, '_', '.' or '-'", key)
+		}
+		if len(value) > maxTagValueLength || strings.ContainsAny(value, ",=") || strings.ContainsFunc(value, func(r rune) bool { return r < ' ' }) {
+			return nil, fmt.Errorf("invalid value of tag '%s': use up to %d characters without ',', '=' or control characters", key, maxTagValueLength)
+		}
+	}
+	if len(tags) > maxRequestTags {
+		return nil, fmt.Errorf("too many tags: %d, at most %d are allowed", len(tags), maxRequestTags)
+	}
+	return tags, nil
+}
+
+// tagsFrom returns the tags of the request a context belongs to.
+func tagsFrom(ctx context.Context) requestTags {
+	tags, _ := ctx.Value(RequestTagsContextKeyString).(requestTags)
+	return tags
+}
+
+// String renders the tags in the header format, sorted by key.
+func (t requestTags) String() string {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + t[key]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// parseTags reads tags rendered by String.
+func parseTags(s string) requestTags {
+	tags := requestTags{}
+	for _, pair := range strings.Split(s, ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// observabilityProps adds the tags to observability event properties.
+func (t requestTags) observabilityProps(props map[string]any) map[string]any {
+	if len(t) > 0 {
+		props["tags"] = map[string]string(t)
+	}
+	return props
+}
+
+// setTagPlaceholders publishes the tags as {ai.tags} and one {ai.tag.<key>} per tag.
+func setTagPlaceholders(r *http.Request, tags requestTags) {
+	setPlaceholder(r, PlaceholderTags, tags.String())
+	for key, value := range tags {
+		setPlaceholder(r, PlaceholderTagPrefix+key, value)
+	}
+}
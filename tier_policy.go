@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/auth"
+	"go.uber.org/zap"
+)
+
+// TierResolverContextKeyString is the context key an authentication layer stores an
+// auth.TierResolver under, like the ExternalAPIKeyProvider; it takes precedence over ai_user_tier.
+const TierResolverContextKeyString string = "ai_tier_resolver"
+
+// Where a tier policy reads the user's tier from.
+const (
+	TierSourceContext = "context" // A TierResolver in the request context, else ai_user_tier
+	TierSourceHeader  = "header"
+	TierSourceJWT     = "jwt"
+)
+
+// TierPolicy limits the models, providers and request rate of users by tier. Requests whose
+// tier isn't configured use the default tier, and are rejected if there is none.
+type TierPolicy struct {
+	// Where the tier comes from: context (default), header or jwt
+	Source string `json:"source,omitempty"`
+	// Header name or JWT claim holding the tier
+	Key string `json:"key,omitempty"`
+	// Tier of users whose own tier is missing or not configured
+	DefaultTier string `json:"default_tier,omitempty"`
+	// Access of each tier, by tier name
+	Tiers map[string]*TierAccess `json:"tiers,omitempty"`
+}
+
+// TierAccess is what the users of one tier may do.
+type TierAccess struct {
+	// Glob patterns of the models the tier may be served; empty allows every model
+	Models []string `json:"models,omitempty"`
+	// Providers the tier may be served by; empty allows every provider
+	Providers []string `json:"providers,omitempty"`
+	// Requests each user of the tier may make per minute (0 = unlimited)
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+
+	models []*regexp.Regexp
+}
+
+func (p *TierPolicy) provision(cr *AICoreRouter) error {
+	if p == nil {
+		return nil
+	}
+	switch p.Source {
+	case "", TierSourceContext:
+	case TierSourceHeader, TierSourceJWT:
+		if p.Key == "" {
+			return fmt.Errorf("tiers: source %s requires a header name or claim", p.Source)
+		}
+	default:
+		return fmt.Errorf("tiers: unsupported source '%s'", p.Source)
+	}
+	if p.DefaultTier != "" && p.Tiers[p.DefaultTier] == nil {
+		return fmt.Errorf("tiers: default_tier '%s' is not configured", p.DefaultTier)
+	}
+	for name, access := range p.Tiers {
+		if access == nil {
+			return fmt.Errorf("tiers: tier %s has no configuration", name)
+		}
+		if access.RequestsPerMinute < 0 {
+			return fmt.Errorf("tiers: tier %s: requests_per_minute must not be negative", name)
+		}
+		for _, providerName := range access.Providers {
+			if _, ok := cr.Providers[providerName]; !ok {
+				return fmt.Errorf("tiers: tier %s: provider '%s' is not configured", name, providerName)
+			}
+		}
+		access.models = nil
+		for _, glob := range access.Models {
+			access.models = append(access.models, globPattern(glob))
+		}
+	}
+	return nil
+}
+
+// userTier returns the tier a request's user claims, before defaults apply.
+func (p *TierPolicy) userTier(r *http.Request, userID string) (string, error) {
+	switch p.Source {
+	case TierSourceHeader:
+		return strings.TrimSpace(r.Header.Get(p.Key)), nil
+	case TierSourceJWT:
+		return jwtClaim(r, p.Key), nil
+	}
+	if resolver, ok := r.Context().Value(TierResolverContextKeyString).(auth.TierResolver); ok {
+		return resolver.GetUserTier(userID)
+	}
+	tier, _ := r.Context().Value(UserTierContextKeyString).(string)
+	return tier, nil
+}
+
+// tierGrant is the tier a request was admitted under.
+type tierGrant struct {
+	name   string
+	access *TierAccess
+}
+
+// resolveTier returns the tier a request is served under, or nil without a tier policy. On
+// failure the client has been answered and the error is returned.
+func (cr *AICoreRouter) resolveTier(w http.ResponseWriter, r *http.Request, userID string) (*tierGrant, error) {
+	policy := cr.Tiers
+	if policy == nil {
+		return nil, nil
+	}
+	tier, err := policy.userTier(r, userID)
+	if err != nil {
+		cr.requestLogger(r.Context()).Error("Failed to resolve user tier", zap.String("user_id", userID), zap.Error(err))
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Internal server error: could not resolve user tier")
+		return nil, err
+	}
+	access := policy.Tiers[tier]
+	if access == nil && policy.DefaultTier != "" {
+		tier, access = policy.DefaultTier, policy.Tiers[policy.DefaultTier]
+	}
+	if access == nil {
+		writeOpenAIError(w, http.StatusForbidden, ErrorTypePermission, "tier_not_allowed", "Your account tier does not have access to this API")
+		return nil, fmt.Errorf("tier '%s' is not configured", tier)
+	}
+	return &tierGrant{name: tier, access: access}, nil
+}
+
+// checkTier rejects a resolved route the tier may not use, then counts the request against
+// the tier's rate limit. On failure the client has been answered and the error is returned.
+func (cr *AICoreRouter) checkTier(w http.ResponseWriter, r *http.Request, grant *tierGrant, userID, requestedModel, providerName, model string) error {
+	if grant == nil {
+		return nil
+	}
+	if !grant.allowsProvider(providerName) || !grant.allowsModel(model) {
+		writeOpenAIError(w, http.StatusForbidden, ErrorTypePermission, "model_not_allowed",
+			fmt.Sprintf("The model '%s' is not available on your tier (%s)", requestedModel, grant.name))
+		return fmt.Errorf("tier %s may not use %s/%s", grant.name, providerName, model)
+	}
+	if grant.access.RequestsPerMinute > 0 {
+		if retryAfter, limited := cr.tierRateLimited(r, grant, userID); limited {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			writeOpenAIError(w, http.StatusTooManyRequests, ErrorTypeRateLimit, "tier_rate_limited",
+				fmt.Sprintf("Rate limit of %d requests per minute reached for tier %s, please retry later", grant.access.RequestsPerMinute, grant.name))
+			return fmt.Errorf("tier %s rate limit reached", grant.name)
+		}
+	}
+	return nil
+}
+
+// tierRateLimited counts a request against its user's per-minute budget in the router store
+// and reports whether the budget is spent, with the time until the next minute.
+func (cr *AICoreRouter) tierRateLimited(r *http.Request, grant *tierGrant, userID string) (time.Duration, bool) {
+	subject := userID
+	if subject == "" {
+		subject, _ = r.Context().Value(ApiKeyIDContextKeyString).(string)
+	}
+	if subject == "" {
+		subject, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	now := time.Now()
+	window := now.Truncate(time.Minute)
+	count, err := cr.store.IncrBy(context.WithoutCancel(r.Context()), cr.storeKey("tier_rpm", grant.name, subject, strconv.FormatInt(window.Unix(), 10)), 1, time.Minute)
+	if err != nil {
+		cr.requestLogger(r.Context()).Warn("Failed to count tier rate limit, allowing request", zap.Error(err))
+		return 0, false
+	}
+	return window.Add(time.Minute).Sub(now), count > int64(grant.access.RequestsPerMinute)
+}
+
+// allowsProvider reports whether the tier may be served by a provider.
+func (g *tierGrant) allowsProvider(name string) bool {
+	if g == nil || len(g.access.Providers) == 0 {
+		return true
+	}
+	for _, p := range g.access.Providers {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsModel reports whether the tier may be served a model.
+func (g *tierGrant) allowsModel(model string) bool {
+	return g == nil || len(g.access.models) == 0 || anyMatch(g.access.models, model)
+}
+
+// filter narrows a provider filter to the providers the tier may be served by.
+func (g *tierGrant) filter(accept func(*ProviderConfig) bool) func(*ProviderConfig) bool {
+	if g == nil || len(g.access.Providers) == 0 {
+		return accept
+	}
+	return func(p *ProviderConfig) bool {
+		return g.allowsProvider(p.Name) && (accept == nil || accept(p))
+	}
+}
+
+// parseTierPolicyCaddyfile parses a `tiers { ... }` block.
+func parseTierPolicyCaddyfile(d *caddyfile.Dispenser) (*TierPolicy, error) {
+	p := &TierPolicy{Tiers: make(map[string]*TierAccess)}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "source":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			p.Source = strings.ToLower(d.Val())
+			if p.Source == TierSourceHeader || p.Source == TierSourceJWT {
+				if !d.NextArg() {
+					return nil, d.Errf("tiers source %s expects a header name or claim", p.Source)
+				}
+				p.Key = d.Val()
+			}
+		case "default_tier":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			p.DefaultTier = d.Val()
+		case "tier":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			name := d.Val()
+			if _, ok := p.Tiers[name]; ok {
+				return nil, d.Errf("tier %s already defined", name)
+			}
+			access := &TierAccess{}
+			for tierNesting := d.Nesting(); d.NextBlock(tierNesting); {
+				switch d.Val() {
+				case "models":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return nil, d.ArgErr()
+					}
+					access.Models = append(access.Models, args...)
+				case "providers":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return nil, d.ArgErr()
+					}
+					for _, pName := range args {
+						access.Providers = append(access.Providers, strings.ToLower(pName))
+					}
+				case "requests_per_minute":
+					if !d.NextArg() {
+						return nil, d.ArgErr()
+					}
+					n, err := strconv.Atoi(d.Val())
+					if err != nil || n < 0 {
+						return nil, d.Errf("tier %s: invalid requests_per_minute '%s'", name, d.Val())
+					}
+					access.RequestsPerMinute = n
+				default:
+					return nil, d.Errf("unrecognized tier option '%s'", d.Val())
+				}
+			}
+			p.Tiers[name] = access
+		default:
+			return nil, d.Errf("unrecognized tiers option '%s'", d.Val())
+		}
+	}
+	return p, nil
+}
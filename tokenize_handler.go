@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(TokenizeHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_tokenize", parseTokenizeHandlerCaddyfile)
+}
+
+// TokenizeHandler counts the tokens of text or a chat conversation with a router's tokenizer
+// (POST under any path), so clients can budget prompts the way the router counts them for
+// max_prompt_tokens and context window checks. No completion is requested, but a request naming
+// a model resolves it like a chat request, which may fetch a provider's model list.
+type TokenizeHandler struct {
+	Router string `json:"router,omitempty"`
+	// Maximum request body size in bytes (0 = unlimited)
+	MaxRequestSize int64 `json:"max_request_size,omitempty"`
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (TokenizeHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_tokenize",
+		New: func() caddy.Module { return new(TokenizeHandler) },
+	}
+}
+
+func (h *TokenizeHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	h.routers, err = routerScopeOf(ctx)
+	return err
+}
+
+// tokenizeRequest is counted either as chat messages, with their formatting overhead, or as
+// input: one text or a list of them.
+type tokenizeRequest struct {
+	Model    string          `json:"model,omitempty"`
+	Messages json.RawMessage `json:"messages,omitempty"`
+	Input    json.RawMessage `json:"input,omitempty"`
+}
+
+type tokenizeResponse struct {
+	Object    string `json:"object"`
+	Model     string `json:"model,omitempty"`
+	Tokenizer string `json:"tokenizer"`
+	// Tokens of the messages or of all inputs
+	PromptTokens int `json:"prompt_tokens"`
+	// Tokens of each input, when input is a list
+	InputTokens []int `json:"input_tokens,omitempty"`
+	// For messages: the prompt plus the completion budget (max_completion_tokens, max_tokens or
+	// the context overflow reserve), which is what must fit the context window
+	RequestTokens int `json:"request_tokens,omitempty"`
+	// Context window of the model, when its provider lists it
+	ContextLength int `json:"context_length,omitempty"`
+}
+
+func (h *TokenizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_tokenize: router '%s' not found", routerName))
+		return nil
+	}
+	defer cr.track()()
+
+	if r.Method != http.MethodPost {
+		return next.ServeHTTP(w, r)
+	}
+	return cr.handleTokenizeRequest(w, r, h.MaxRequestSize)
+}
+
+// handleTokenizeRequest counts the tokens of a tokenize request and writes the counts.
+func (cr *AICoreRouter) handleTokenizeRequest(w http.ResponseWriter, r *http.Request, maxRequestSize int64) error {
+	r = withRequestID(w, r)
+	if maxRequestSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, ErrorTypeInvalidRequest, "request_too_large",
+				fmt.Sprintf("Request body exceeds the maximum allowed size of %d bytes", maxBytesErr.Limit))
+			return err
+		}
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Failed to read request body")
+		return err
+	}
+	r.Body.Close()
+
+	var req tokenizeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request body")
+		return err
+	}
+	if (len(req.Messages) == 0) == (len(req.Input) == 0) {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "missing_required_parameter", "Exactly one of 'messages' and 'input' is required in JSON request body")
+		return fmt.Errorf("exactly one of 'messages' and 'input' is required")
+	}
+
+	resp := tokenizeResponse{Object: "tokenize", Model: req.Model, Tokenizer: cr.tokenizer.Name()}
+	if len(req.Messages) > 0 {
+		var messages []json.RawMessage
+		if err := json.Unmarshal(req.Messages, &messages); err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_value", "'messages' must be an array of chat messages")
+			return err
+		}
+		resp.PromptTokens = cr.requestPromptTokens(body)
+		resp.RequestTokens = cr.requestTokens(body)
+	} else {
+		var text string
+		var texts []string
+		switch {
+		case json.Unmarshal(req.Input, &text) == nil:
+			resp.PromptTokens = cr.tokenizer.Count(text)
+		case json.Unmarshal(req.Input, &texts) == nil:
+			resp.InputTokens = make([]int, len(texts))
+			for i, text := range texts {
+				resp.InputTokens[i] = cr.tokenizer.Count(text)
+				resp.PromptTokens += resp.InputTokens[i]
+			}
+		default:
+			writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_value", "'input' must be a string or an array of strings")
+			return fmt.Errorf("invalid input")
+		}
+	}
+
+	if req.Model != "" {
+		// The tokenizer is the router's whatever the model; the model only tells the context window
+		userID, _ := r.Context().Value(UserIDContextKeyString).(string)
+		providerName, actualModelName := cr.resolveProviderAndModel(r.Context(), cr.routeModel(r, req.Model), "")
+		cr.mu.RLock()
+		p, ok := cr.Providers[providerName]
+		cr.mu.RUnlock()
+		if ok && cr.modelAllowed(p, actualModelName) {
+			resp.ContextLength = cr.contextLength(p, cr.apiKeyServiceFor(r), userID, actualModelName)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+func parseTokenizeHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var th TokenizeHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				th.Router = h.Val()
+			case "max_request_size":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				size, err := humanize.ParseBytes(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid max_request_size '%s': %v", h.Val(), err)
+				}
+				th.MaxRequestSize = int64(size)
+			default:
+				return nil, h.Errf("unrecognized ai_tokenize option '%s'", h.Val())
+			}
+		}
+	}
+	return &th, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*TokenizeHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*TokenizeHandler)(nil)
+)
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"go.uber.org/zap"
+)
+
+// exportTrace sends the LLM trace of a finished inference request, if tracing is enabled.
+// Requests that never reached a provider aren't traced.
+func (cr *AICoreRouter) exportTrace(r *http.Request, rec *accessRecord, tracker *usageTracker, body []byte) {
+	if cr.tracer == nil || rec == nil || tracker == nil {
+		return
+	}
+	var req struct {
+		Messages json.RawMessage `json:"messages"`
+		Stream   bool            `json:"stream"`
+	}
+	json.Unmarshal(body, &req)
+	userID, _ := r.Context().Value(UserIDContextKeyString).(string)
+	promptTokens, completionTokens, estimated := tracker.snapshot()
+
+	rec.mu.Lock()
+	trace := common.LLMTrace{
+		RequestID:        requestID(r.Context()),
+		Name:             "chat",
+		UserID:           userID,
+		RequestedModel:   rec.requestedModel,
+		Model:            rec.model,
+		Provider:         rec.provider,
+		Prompt:           req.Messages,
+		Completion:       tracker.completionText(),
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Status:           rec.status,
+		Start:            rec.start,
+		End:              time.Now(),
+		Metadata: map[string]any{
+			"attempts":         rec.attempts,
+			"upstream_status":  rec.upstreamStatus,
+			"stream":           req.Stream,
+			"tokens_estimated": estimated,
+			"aborted":          r.Context().Err() != nil,
+		},
+	}
+	if tags := tagsFrom(r.Context()); len(tags) > 0 {
+		trace.Metadata["tags"] = map[string]string(tags)
+	}
+	rec.mu.Unlock()
+
+	trace.TraceID, trace.ParentSpanID = traceParent(r.Header.Get("traceparent"))
+	if trace.TraceID == "" {
+		sum := sha256.Sum256([]byte(trace.RequestID))
+		trace.TraceID = hex.EncodeToString(sum[:16]) // Derived from the request ID, so it can be found again
+	}
+	spanID := make([]byte, 8)
+	rand.Read(spanID)
+	trace.SpanID = hex.EncodeToString(spanID)
+	trace.Cost = cr.modelCost(trace.Provider, trace.Model, promptTokens, completionTokens)
+
+	if err := cr.tracer.Export(trace); err != nil {
+		cr.requestLogger(r.Context()).Warn("Failed to export trace", zap.Error(err))
+	}
+}
+
+// traceParent returns the trace and parent span IDs of a W3C traceparent header, or empty strings.
+func traceParent(header string) (traceID string, parentSpanID string) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", ""
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return "", ""
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "" // All-zero IDs are invalid
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2])
+}
+
+// modelCost prices a call from the per-token prompt and completion prices the provider
+// publishes for the model (OpenRouter-style "pricing"), or returns nil if they're unknown.
+func (cr *AICoreRouter) modelCost(providerName, modelName string, promptTokens, completionTokens int) *float64 {
+	cr.mu.RLock()
+	p, ok := cr.Providers[providerName]
+	cr.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	model, ok := cr.findModel(p, "", modelName)
+	if !ok {
+		return nil
+	}
+	pricing, ok := model["pricing"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	promptPrice, okPrompt := pricePerToken(pricing["prompt"])
+	completionPrice, okCompletion := pricePerToken(pricing["completion"])
+	if !okPrompt && !okCompletion {
+		return nil
+	}
+	cost := float64(promptTokens)*promptPrice + float64(completionTokens)*completionPrice
+	return &cost
+}
+
+func pricePerToken(v any) (float64, bool) {
+	switch price := v.(type) {
+	case float64:
+		return price, true
+	case string:
+		f, err := strconv.ParseFloat(price, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// parseTracingCaddyfile parses a `tracing { exporter <langfuse|otlp> [url] ... }` block.
+func parseTracingCaddyfile(d *caddyfile.Dispenser) (*common.TracingConfig, error) {
+	cfg := &common.TracingConfig{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "exporter":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Exporter = strings.ToLower(d.Val())
+			if d.NextArg() {
+				cfg.URL = d.Val()
+			}
+		case "url":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.URL = d.Val()
+		case "public_key":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.PublicKey = d.Val()
+		case "secret_key":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.SecretKey = d.Val()
+		case "service_name":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.ServiceName = d.Val()
+		case "header":
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				return nil, d.ArgErr()
+			}
+			if cfg.Headers == nil {
+				cfg.Headers = make(map[string]string)
+			}
+			cfg.Headers[args[0]] = args[1]
+		case "redact":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			for _, arg := range args {
+				if arg == "all" {
+					cfg.Redact = append(cfg.Redact, common.RedactPrompt, common.RedactCompletion)
+				} else {
+					cfg.Redact = append(cfg.Redact, arg)
+				}
+			}
+		case "sample_rate":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			rate, err := strconv.ParseFloat(d.Val(), 64)
+			if err != nil {
+				return nil, d.Errf("invalid sample_rate '%s'", d.Val())
+			}
+			cfg.SampleRate = rate
+		default:
+			return nil, d.Errf("unrecognized tracing option '%s'", d.Val())
+		}
+	}
+	if cfg.Exporter == "" {
+		return nil, d.Err("tracing requires an exporter")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, d.Err(err.Error())
+	}
+	return cfg, nil
+}
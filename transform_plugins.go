@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"go.uber.org/zap"
+)
+
+// Transform plugins are Caddy modules in the ai.transforms namespace that rewrite chat traffic
+// in the unified (OpenAI-style) format: requests before they are routed and handed to a provider,
+// responses and stream chunks once the provider's reply has been converted back. A module
+// implements any of the interfaces below; routers run them in the order they are configured.
+//
+// Requests may be rejected by returning an error made with caddyhttp.Error, whose status code is
+// sent to the client; any other error is answered with a 500.
+
+// RequestTransformer rewrites a unified chat completion request body.
+type RequestTransformer interface {
+	TransformRequest(r *http.Request, body []byte) ([]byte, error)
+}
+
+// ResponseTransformer rewrites a successful, complete unified chat completion.
+type ResponseTransformer interface {
+	TransformResponse(r *http.Request, body []byte) ([]byte, error)
+}
+
+// ChunkTransformer rewrites the data payload of each chunk of a successful unified stream.
+type ChunkTransformer interface {
+	TransformChunk(r *http.Request, chunk []byte) ([]byte, error)
+}
+
+// provisionTransforms loads the router's ai.transforms modules.
+func (cr *AICoreRouter) provisionTransforms(ctx caddy.Context) error {
+	cr.transforms = nil
+	for i, raw := range cr.TransformsRaw {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return fmt.Errorf("transform %d: %v", i, err)
+		}
+		var name string
+		if err := json.Unmarshal(fields["transform"], &name); err != nil || name == "" {
+			return fmt.Errorf("transform %d: module name missing from 'transform' key", i)
+		}
+		delete(fields, "transform")
+		config, err := json.Marshal(fields)
+		if err != nil {
+			return fmt.Errorf("transform %d: %v", i, err)
+		}
+		mod, err := ctx.LoadModuleByID("ai.transforms."+name, config)
+		if err != nil {
+			return fmt.Errorf("loading transform '%s': %v", name, err)
+		}
+		_, isRequest := mod.(RequestTransformer)
+		_, isResponse := mod.(ResponseTransformer)
+		_, isChunk := mod.(ChunkTransformer)
+		if !isRequest && !isResponse && !isChunk {
+			return fmt.Errorf("transform module %T implements none of the transform interfaces", mod)
+		}
+		cr.transforms = append(cr.transforms, mod)
+	}
+	return nil
+}
+
+// transformRequest runs a unified request through the request transforms. On failure the
+// client has been answered and the error is returned.
+func (cr *AICoreRouter) transformRequest(w http.ResponseWriter, r *http.Request, body []byte) ([]byte, error) {
+	for _, mod := range cr.transforms {
+		t, ok := mod.(RequestTransformer)
+		if !ok {
+			continue
+		}
+		transformed, err := t.TransformRequest(r, body)
+		if err != nil {
+			var handlerErr caddyhttp.HandlerError
+			if errors.As(err, &handlerErr) && handlerErr.StatusCode >= 400 && handlerErr.StatusCode < 500 {
+				message := handlerErr.Error()
+				if handlerErr.Err != nil {
+					message = handlerErr.Err.Error()
+				}
+				writeOpenAIError(w, handlerErr.StatusCode, errorTypeForStatus(handlerErr.StatusCode), "request_rejected", message)
+				return nil, err
+			}
+			cr.requestLogger(r.Context()).Error("Request transform failed", zap.String("transform", transformName(mod)), zap.Error(err))
+			writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "transform_failed", "Internal server error: request transform failed")
+			return nil, err
+		}
+		body = transformed
+	}
+	return body, nil
+}
+
+// transformResponse hooks the response transforms into a successful unified completion or stream.
+func (cr *AICoreRouter) transformResponse(resp *http.Response) {
+	if len(cr.transforms) == 0 || resp.StatusCode >= 400 || common.RequestKind(resp.Request.Context()) != common.RequestKindChat {
+		return
+	}
+	if passthrough, ok := resp.Request.Context().Value(common.ResponsesPassthroughContextKeyString).(*common.ResponsesPassthrough); ok && passthrough != nil && passthrough.Native {
+		return // Not in the unified format
+	}
+	contentType := resp.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		var chunkers []ChunkTransformer
+		for _, mod := range cr.transforms {
+			if t, ok := mod.(ChunkTransformer); ok {
+				chunkers = append(chunkers, t)
+			}
+		}
+		if len(chunkers) > 0 {
+			resp.Body = &chunkTransformBody{ReadCloser: resp.Body, r: resp.Request, transforms: chunkers, logger: cr.requestLogger(resp.Request.Context())}
+			resp.ContentLength = -1
+			resp.Header.Del("Content-Length")
+		}
+	case strings.HasPrefix(contentType, "application/json"):
+		common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+			for _, mod := range cr.transforms {
+				t, ok := mod.(ResponseTransformer)
+				if !ok {
+					continue
+				}
+				transformed, err := t.TransformResponse(resp.Request, body)
+				if err != nil {
+					cr.requestLogger(resp.Request.Context()).Warn("Response transform failed, returning the response as is",
+						zap.String("transform", transformName(mod)), zap.Error(err))
+					continue
+				}
+				body = transformed
+			}
+			return body, nil
+		})
+	}
+}
+
+// chunkTransformBody passes a unified SSE stream through chunk transforms line by line.
+type chunkTransformBody struct {
+	io.ReadCloser
+	r          *http.Request
+	transforms []ChunkTransformer
+	logger     *zap.Logger
+
+	pending []byte
+	out     bytes.Buffer
+	buf     []byte
+	err     error
+}
+
+func (b *chunkTransformBody) Read(p []byte) (int, error) {
+	for b.out.Len() == 0 && b.err == nil {
+		if len(b.buf) < len(p) {
+			b.buf = make([]byte, len(p))
+		}
+		n, err := b.ReadCloser.Read(b.buf[:len(p)])
+		if n > 0 {
+			b.pending = append(b.pending, b.buf[:n]...)
+			for {
+				idx := bytes.IndexByte(b.pending, '\n')
+				if idx == -1 {
+					break
+				}
+				b.processLine(b.pending[:idx+1])
+				b.pending = b.pending[idx+1:]
+			}
+		}
+		if err != nil {
+			b.err = err
+			if len(b.pending) > 0 {
+				b.processLine(b.pending)
+				b.pending = nil
+			}
+		}
+	}
+	if b.out.Len() > 0 {
+		return b.out.Read(p)
+	}
+	return 0, b.err
+}
+
+func (b *chunkTransformBody) processLine(line []byte) {
+	payload, isData := strings.CutPrefix(strings.TrimSpace(string(line)), "data:")
+	payload = strings.TrimSpace(payload)
+	if !isData || payload == "" || payload == "[DONE]" {
+		b.out.Write(line)
+		return
+	}
+	data := []byte(payload)
+	for _, t := range b.transforms {
+		transformed, err := t.TransformChunk(b.r, data)
+		if err != nil {
+			b.logger.Warn("Chunk transform failed, passing the chunk on as is", zap.String("transform", transformName(t)), zap.Error(err))
+			continue
+		}
+		data = transformed
+	}
+	b.out.WriteString("data: ")
+	b.out.Write(data)
+	b.out.WriteByte('\n')
+}
+
+func transformName(mod any) string {
+	if m, ok := mod.(caddy.Module); ok {
+		return string(m.CaddyModule().ID)
+	}
+	return fmt.Sprintf("%T", mod)
+}
+
+// parseTransformCaddyfile parses a `transform <name> ...` line or block into the JSON of an
+// ai.transforms.<name> module; the module's own UnmarshalCaddyfile reads its arguments.
+func parseTransformCaddyfile(d *caddyfile.Dispenser) (json.RawMessage, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	name := d.Val()
+	unm, err := caddyfile.UnmarshalModule(d, "ai.transforms."+name)
+	if err != nil {
+		return nil, err
+	}
+	return caddyconfig.JSONModuleObject(unm, "transform", name, nil), nil
+}
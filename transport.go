@@ -0,0 +1,440 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+	"golang.org/x/net/proxy"
+)
+
+// UpstreamTLS configures TLS to a provider, e.g. a private inference endpoint behind mTLS or
+// with a certificate from an internal CA. Field names follow reverse_proxy's.
+type UpstreamTLS struct {
+	// Client certificate and key (PEM files) to present to the provider
+	ClientCertificateFile    string `json:"client_certificate_file,omitempty"`
+	ClientCertificateKeyFile string `json:"client_certificate_key_file,omitempty"`
+	// CA certificates (PEM files) to trust instead of the system's
+	RootCAPEMFiles []string `json:"root_ca_pem_files,omitempty"`
+	// Server name sent in SNI and verified, if not the api_base_url's host
+	ServerName string `json:"server_name,omitempty"`
+	// Accept any certificate; for lab environments only
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// UpstreamTransport tunes the connections to a provider. Option names follow reverse_proxy's
+// http transport; zero values take the defaults below.
+type UpstreamTransport struct {
+	DialTimeout caddy.Duration `json:"dial_timeout,omitempty"`
+	// How long idle connections are kept (-1 disables keep-alives), and the TCP keep-alive
+	// probe interval
+	KeepAlive         caddy.Duration `json:"keepalive,omitempty"`
+	KeepAliveInterval caddy.Duration `json:"keepalive_interval,omitempty"`
+	// Idle connections kept in total and to each host
+	MaxIdleConns        int `json:"keepalive_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int `json:"keepalive_idle_conns_per_host,omitempty"`
+	// Cap on connections to each host, 0 for none
+	MaxConnsPerHost       int            `json:"max_conns_per_host,omitempty"`
+	ResponseHeaderTimeout caddy.Duration `json:"response_header_timeout,omitempty"`
+	// HTTP versions to use, "1.1" and/or "2" (default both)
+	Versions []string `json:"versions,omitempty"`
+	// Don't ask for gzip responses, e.g. so SSE chunks aren't held back by compression
+	DisableCompression bool `json:"disable_compression,omitempty"`
+}
+
+// Transport defaults. Go's default of 2 idle connections per host makes busy providers churn
+// connections.
+const (
+	defaultDialTimeout         = 10 * time.Second
+	defaultKeepAlive           = 90 * time.Second
+	defaultKeepAliveInterval   = 30 * time.Second
+	defaultMaxIdleConns        = 256
+	defaultMaxIdleConnsPerHost = 64
+)
+
+// With forward_proxy set to noForwardProxy, the provider is connected to directly, even if
+// HTTP_PROXY or HTTPS_PROXY is set.
+const noForwardProxy = "none"
+
+// newUpstreamTransport returns the transport of a provider, with its own connection pool, TLS
+// settings, forward proxy and local address.
+func (cr *AICoreRouter) newUpstreamTransport(p *ProviderConfig) (*http.Transport, error) {
+	tuning := p.Transport
+	if tuning == nil {
+		tuning = &UpstreamTransport{}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if err := tuning.apply(transport); err != nil {
+		return nil, fmt.Errorf("transport: %v", err)
+	}
+	if p.TLS != nil {
+		tlsConfig, err := p.TLS.config()
+		if err != nil {
+			return nil, fmt.Errorf("tls: %v", err)
+		}
+		if p.TLS.InsecureSkipVerify {
+			cr.logger.Warn("TLS certificate verification is disabled for provider", zap.String("provider", p.Name))
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	switch p.ForwardProxy {
+	case "":
+	case noForwardProxy:
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(p.ForwardProxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid forward_proxy '%s'", p.ForwardProxy)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("forward_proxy: unsupported scheme '%s'; use http, https or socks5", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+		cr.logger.Info("Provider traffic goes through a forward proxy", zap.String("provider", p.Name), zap.String("proxy", proxyURL.Redacted()))
+	}
+	dialer := tuning.dialer()
+	if p.LocalAddress != "" {
+		ip := net.ParseIP(p.LocalAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local_address '%s'; expected an IP address", p.LocalAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	transport.DialContext = dialer.DialContext
+	return transport, nil
+}
+
+func (t *UpstreamTransport) dialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultKeepAliveInterval}
+	if t.DialTimeout > 0 {
+		dialer.Timeout = time.Duration(t.DialTimeout)
+	}
+	if t.KeepAliveInterval != 0 {
+		dialer.KeepAlive = time.Duration(t.KeepAliveInterval)
+	}
+	return dialer
+}
+
+// dialUpstream opens a connection to target (an http, https, ws or wss URL) with a transport's
+// settings, for protocols the transport can't carry itself, such as WebSockets: through its
+// forward proxy, from its local address and, for https and wss, with its TLS configuration.
+func dialUpstream(ctx context.Context, transport *http.Transport, target *url.URL) (net.Conn, error) {
+	secure := target.Scheme == "https" || target.Scheme == "wss"
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if secure {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(target.Hostname(), port)
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: defaultDialTimeout}).DialContext
+	}
+
+	var proxyURL *url.URL
+	if transport.Proxy != nil {
+		// Proxy functions pick the proxy by the scheme of an HTTP request
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		var err error
+		proxyURL, err = transport.Proxy(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	switch {
+	case proxyURL == nil:
+		conn, err = dial(ctx, "tcp", addr)
+	case proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		var socks proxy.Dialer
+		if socks, err = proxy.SOCKS5("tcp", proxyURL.Host, auth, contextDialer(dial)); err == nil {
+			conn, err = socks.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+		}
+	default:
+		conn, err = dialConnectTunnel(ctx, dial, proxyURL, addr)
+	}
+	if err != nil || !secure {
+		return conn, err
+	}
+
+	tlsConfig := &tls.Config{}
+	if transport.TLSClientConfig != nil {
+		tlsConfig = transport.TLSClientConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = target.Hostname()
+	}
+	// WebSockets upgrade an HTTP/1.1 request
+	tlsConfig.NextProtos = []string{"http/1.1"}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// dialConnectTunnel opens a tunnel to addr through an HTTP or HTTPS forward proxy.
+func dialConnectTunnel(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err := dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		// The provider's TLS settings are for the provider, not its proxy
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The response has no length: its body is the tunnel, which mustn't be drained
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("forward proxy refused the tunnel: %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("forward proxy sent data before the tunnel was used")
+	}
+	return conn, nil
+}
+
+// contextDialer adapts a dial function to the proxy package's dialers.
+type contextDialer func(context.Context, string, string) (net.Conn, error)
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// apply sets the pool, timeout, protocol and compression settings on a transport.
+func (t *UpstreamTransport) apply(transport *http.Transport) error {
+	transport.MaxIdleConns = defaultMaxIdleConns
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	transport.IdleConnTimeout = defaultKeepAlive
+	if t.MaxIdleConns > 0 {
+		transport.MaxIdleConns = t.MaxIdleConns
+	}
+	if t.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+	}
+	switch {
+	case t.KeepAlive < 0:
+		transport.DisableKeepAlives = true
+	case t.KeepAlive > 0:
+		transport.IdleConnTimeout = time.Duration(t.KeepAlive)
+	}
+	transport.MaxConnsPerHost = t.MaxConnsPerHost
+	transport.ResponseHeaderTimeout = time.Duration(t.ResponseHeaderTimeout)
+	transport.DisableCompression = t.DisableCompression
+
+	if len(t.Versions) > 0 {
+		http1, http2 := false, false
+		for _, version := range t.Versions {
+			switch version {
+			case "1.1":
+				http1 = true
+			case "2":
+				http2 = true
+			default:
+				return fmt.Errorf("unsupported HTTP version '%s'; use 1.1 and/or 2", version)
+			}
+		}
+		if !http2 {
+			// A non-nil, empty map turns HTTP/2 off
+			transport.ForceAttemptHTTP2 = false
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		} else if !http1 {
+			return fmt.Errorf("HTTP/2 only isn't supported; use versions 1.1 2")
+		}
+	}
+	return nil
+}
+
+func (t *UpstreamTLS) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	switch {
+	case t.ClientCertificateFile != "" && t.ClientCertificateKeyFile != "":
+		cert, err := tls.LoadX509KeyPair(t.ClientCertificateFile, t.ClientCertificateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	case t.ClientCertificateFile != "" || t.ClientCertificateKeyFile != "":
+		return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+	}
+	if len(t.RootCAPEMFiles) > 0 {
+		pool := x509.NewCertPool()
+		for _, file := range t.RootCAPEMFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA certificates: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM certificates found in %s", file)
+			}
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// parseUpstreamTransportCaddyfile parses a provider's `transport { ... }` block.
+func parseUpstreamTransportCaddyfile(d *caddyfile.Dispenser, providerName string) (*UpstreamTransport, error) {
+	t := &UpstreamTransport{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+		args := d.RemainingArgs()
+		switch option {
+		case "versions":
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			t.Versions = args
+			continue
+		case "compression":
+			if len(args) != 1 || args[0] != "off" {
+				return nil, d.Errf("provider %s: compression only takes 'off'", providerName)
+			}
+			t.DisableCompression = true
+			continue
+		}
+		if len(args) != 1 {
+			return nil, d.ArgErr()
+		}
+		switch option {
+		case "dial_timeout", "keepalive", "keepalive_interval", "response_header_timeout":
+			var value time.Duration
+			if option == "keepalive" && args[0] == "off" {
+				value = -1
+			} else if parsed, err := caddy.ParseDuration(args[0]); err != nil || parsed <= 0 {
+				return nil, d.Errf("provider %s: invalid %s '%s'", providerName, option, args[0])
+			} else {
+				value = parsed
+			}
+			switch option {
+			case "dial_timeout":
+				t.DialTimeout = caddy.Duration(value)
+			case "keepalive":
+				t.KeepAlive = caddy.Duration(value)
+			case "keepalive_interval":
+				t.KeepAliveInterval = caddy.Duration(value)
+			case "response_header_timeout":
+				t.ResponseHeaderTimeout = caddy.Duration(value)
+			}
+		case "keepalive_idle_conns", "keepalive_idle_conns_per_host", "max_conns_per_host":
+			value, err := strconv.Atoi(args[0])
+			if err != nil || value < 0 {
+				return nil, d.Errf("provider %s: invalid %s '%s'", providerName, option, args[0])
+			}
+			switch option {
+			case "keepalive_idle_conns":
+				t.MaxIdleConns = value
+			case "keepalive_idle_conns_per_host":
+				t.MaxIdleConnsPerHost = value
+			case "max_conns_per_host":
+				t.MaxConnsPerHost = value
+			}
+		default:
+			return nil, d.Errf("provider %s: unrecognized transport option '%s'", providerName, option)
+		}
+	}
+	return t, nil
+}
+
+// parseUpstreamTLSCaddyfile parses a provider's `tls { ... }` block.
+func parseUpstreamTLSCaddyfile(d *caddyfile.Dispenser, providerName string) (*UpstreamTLS, error) {
+	t := &UpstreamTLS{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "client_auth":
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				return nil, d.ArgErr()
+			}
+			t.ClientCertificateFile, t.ClientCertificateKeyFile = args[0], args[1]
+		case "trusted_ca_certs":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			t.RootCAPEMFiles = append(t.RootCAPEMFiles, args...)
+		case "server_name":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			t.ServerName = d.Val()
+		case "insecure_skip_verify":
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			t.InsecureSkipVerify = true
+		default:
+			return nil, d.Errf("provider %s: unrecognized tls option '%s'", providerName, d.Val())
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// usageRecord is what one finished inference request used and cost.
+type usageRecord struct {
+	Time             time.Time
+	UserID           string
+	APIKeyID         string
+	RequestedModel   string
+	Provider         string
+	Model            string
+	Latency          time.Duration // Until the response was complete
+	Status           int           // Sent to the client
+	Failed           bool          // Answered with a 5xx, or the last upstream attempt failed with a 5xx or 429
+	PromptTokens     int
+	CompletionTokens int
+	Cost             *float64 // USD, nil if the model has no published pricing
+	Tags             requestTags
+}
+
+// accountUsage builds the usage record of a finished request and hands it to the router's
+// usage consumers. Requests that never reached a provider aren't accounted.
+func (cr *AICoreRouter) accountUsage(r *http.Request, rec *accessRecord, tracker *usageTracker) {
+	if (cr.alerts == nil && cr.Usage == nil && len(cr.Canaries) == 0) || rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	record := usageRecord{
+		Time:           time.Now(),
+		Latency:        time.Since(rec.start),
+		RequestedModel: rec.requestedModel,
+		Provider:       rec.provider,
+		Model:          rec.model,
+		Status:         rec.status,
+		Failed:         rec.status >= 500 || rec.upstreamStatus >= 500 || rec.upstreamStatus == http.StatusTooManyRequests,
+	}
+	rec.mu.Unlock()
+	if record.Provider == "" {
+		return
+	}
+	record.UserID, _ = r.Context().Value(UserIDContextKeyString).(string)
+	record.APIKeyID, _ = r.Context().Value(ApiKeyIDContextKeyString).(string)
+	record.Tags = tagsFrom(r.Context())
+	if tracker != nil {
+		record.PromptTokens, record.CompletionTokens, _ = tracker.snapshot()
+		if record.PromptTokens+record.CompletionTokens > 0 {
+			record.Cost = cr.modelCost(record.Provider, record.Model, record.PromptTokens, record.CompletionTokens)
+		}
+	}
+	if cr.Usage != nil {
+		cr.recordUsage(r.Context(), record)
+	}
+	if cr.alerts != nil {
+		cr.alerts.observe(r.Context(), record)
+	}
+	if canary := canaryFrom(r.Context()); canary != nil {
+		cr.recordCanary(r.Context(), canary, record)
+	}
+}
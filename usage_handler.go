@@ -0,0 +1,351 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultUsageDays = 30  // Range of a usage query without start
+	maxUsageDays     = 366 // Longest range a usage query may cover
+)
+
+func init() {
+	caddy.RegisterModule(UsageHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_usage", parseUsageHandlerCaddyfile)
+}
+
+// UsageHandler exports the usage a router accounted (see UsageConfig) under any path, in the
+// shape of OpenAI's usage APIs so billing scripts written against them work unchanged:
+// paths ending in /dashboard/billing/usage get the legacy billing format, paths ending in
+// /costs the organization costs API, any other path the organization completions usage API.
+// Paths ending in .csv, or format=csv, get the rows as CSV instead.
+type UsageHandler struct {
+	Router string `json:"router,omitempty"`
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (UsageHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_usage",
+		New: func() caddy.Module { return new(UsageHandler) },
+	}
+}
+
+func (h *UsageHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	h.routers, err = routerScopeOf(ctx)
+	return err
+}
+
+func (h *UsageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_usage: router '%s' not found", routerName))
+		return nil
+	}
+	defer cr.track()()
+
+	if r.Method != http.MethodGet {
+		return next.ServeHTTP(w, r)
+	}
+	if cr.Usage == nil {
+		writeOpenAIError(w, http.StatusNotFound, ErrorTypeNotFound, "usage_disabled", fmt.Sprintf("Usage accounting is not enabled on router '%s'", cr.Name))
+		return nil
+	}
+	start, end, err := usageRange(r)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_usage_range", err.Error())
+		return nil
+	}
+	filter := usageFilter{
+		Users:     queryList(r, "user_ids", "user_id", "user"),
+		APIKeys:   queryList(r, "api_key_ids", "api_key_id"),
+		Providers: queryList(r, "providers", "provider"),
+		Models:    queryList(r, "models", "model"),
+		Tags:      requestTags{},
+	}
+	for _, pair := range queryList(r, "tags", "tag") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			filter.Tags[key] = value
+		}
+	}
+	for _, project := range queryList(r, "project_ids", "project_id") {
+		filter.Tags["project"] = project
+	}
+	rows, err := cr.usageRows(r.Context(), start, end, filter)
+	if err != nil {
+		cr.logger.Error("Failed to load usage", zap.Error(err))
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "", "Internal server error: could not load usage")
+		return nil
+	}
+
+	path := strings.TrimRight(r.URL.Path, "/")
+	switch {
+	case strings.HasSuffix(path, ".csv") || r.URL.Query().Get("format") == "csv":
+		writeUsageCSV(w, rows)
+	case strings.HasSuffix(path, "/dashboard/billing/usage"):
+		writeUsageJSON(w, billingUsage(start, end, rows))
+	case strings.HasSuffix(path, "/costs"):
+		writeUsageJSON(w, usagePage(start, end, rows, queryList(r, "group_by"), costsResult))
+	default:
+		writeUsageJSON(w, usagePage(start, end, rows, queryList(r, "group_by"), completionsResult))
+	}
+	return nil
+}
+
+// usageRange reads the queried days from start_date and end_date (YYYY-MM-DD, as the billing
+// API takes them) or start_time and end_time (Unix seconds, as the organization APIs do). The
+// end is exclusive and defaults to the end of today; the start defaults to 30 days earlier.
+func usageRange(r *http.Request) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	parse := func(dateKey, timeKey string) (time.Time, error) {
+		if v := query.Get(dateKey); v != "" {
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid %s '%s', expected YYYY-MM-DD", dateKey, v)
+			}
+			return t, nil
+		}
+		if v := query.Get(timeKey); v != "" {
+			secs, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid %s '%s', expected Unix seconds", timeKey, v)
+			}
+			return time.Unix(secs, 0).UTC(), nil
+		}
+		return time.Time{}, nil
+	}
+	start, err := parse("start_date", "start_time")
+	if err != nil {
+		return start, start, err
+	}
+	end, err := parse("end_date", "end_time")
+	if err != nil {
+		return start, end, err
+	}
+	if end.IsZero() {
+		end = time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	}
+	if start.IsZero() {
+		start = end.Add(-defaultUsageDays * 24 * time.Hour)
+	}
+	start = start.Truncate(24 * time.Hour)
+	if !start.Before(end) {
+		return start, end, fmt.Errorf("the range ends before it starts")
+	}
+	if end.Sub(start) > maxUsageDays*24*time.Hour {
+		return start, end, fmt.Errorf("the range may cover at most %d days", maxUsageDays)
+	}
+	return start, end, nil
+}
+
+func writeUsageJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeUsageCSV(w http.ResponseWriter, rows []usageRow) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+	w.WriteHeader(http.StatusOK)
+	out := csv.NewWriter(w)
+	out.Write([]string{"date", "user_id", "api_key_id", "provider", "model", "tags", "requests", "prompt_tokens", "completion_tokens", "cost_usd"})
+	for _, row := range rows {
+		out.Write([]string{
+			row.Day.Format(time.DateOnly),
+			row.User,
+			row.APIKey,
+			row.Provider,
+			row.Model,
+			row.Tags,
+			strconv.FormatInt(row.Requests, 10),
+			strconv.FormatInt(row.PromptTokens, 10),
+			strconv.FormatInt(row.CompletionTokens, 10),
+			strconv.FormatFloat(row.Cost, 'f', 6, 64),
+		})
+	}
+	out.Flush()
+}
+
+// billingUsage renders rows in the legacy /dashboard/billing/usage format: daily costs per
+// model, in cents.
+func billingUsage(start, end time.Time, rows []usageRow) map[string]any {
+	type lineItem struct {
+		Name string  `json:"name"`
+		Cost float64 `json:"cost"`
+	}
+	type dailyCost struct {
+		Timestamp float64    `json:"timestamp"`
+		LineItems []lineItem `json:"line_items"`
+	}
+	var days []dailyCost
+	total := 0.0
+	for day := start; day.Before(end); day = day.Add(24 * time.Hour) {
+		daily := dailyCost{Timestamp: float64(day.Unix()), LineItems: []lineItem{}}
+		index := make(map[string]int)
+		for _, row := range rows {
+			if !row.Day.Equal(day) {
+				continue
+			}
+			cents := row.Cost * 100
+			total += cents
+			if i, ok := index[row.Model]; ok {
+				daily.LineItems[i].Cost += cents
+				continue
+			}
+			index[row.Model] = len(daily.LineItems)
+			daily.LineItems = append(daily.LineItems, lineItem{Name: row.Model, Cost: cents})
+		}
+		days = append(days, daily)
+	}
+	return map[string]any{"object": "list", "daily_costs": days, "total_usage": total}
+}
+
+// usageBucket is a day of the organization usage and costs APIs.
+type usageBucket struct {
+	Object    string           `json:"object"`
+	StartTime int64            `json:"start_time"`
+	EndTime   int64            `json:"end_time"`
+	Results   []map[string]any `json:"results"`
+}
+
+// usagePage renders rows as a page of daily buckets, one result per combination of the
+// group_by fields (user_id, api_key_id, model, provider, tags; line_item is the model and
+// project_id the project tag).
+func usagePage(start, end time.Time, rows []usageRow, groupBy []string, result func(usageGroup, []usageRow) map[string]any) map[string]any {
+	grouped := func(g usageGroup) usageGroup {
+		var key usageGroup
+		for _, field := range groupBy {
+			switch field {
+			case "user_id":
+				key.User = g.User
+			case "api_key_id":
+				key.APIKey = g.APIKey
+			case "model", "line_item":
+				key.Model = g.Model
+			case "provider":
+				key.Provider = g.Provider
+			case "project_id":
+				if project := parseTags(g.Tags)["project"]; project != "" && key.Tags == "" {
+					key.Tags = requestTags{"project": project}.String()
+				}
+			case "tags":
+				key.Tags = g.Tags
+			}
+		}
+		return key
+	}
+	var buckets []usageBucket
+	for day := start; day.Before(end); day = day.Add(24 * time.Hour) {
+		bucket := usageBucket{Object: "bucket", StartTime: day.Unix(), EndTime: day.Add(24 * time.Hour).Unix(), Results: []map[string]any{}}
+		var order []usageGroup
+		byGroup := make(map[usageGroup][]usageRow)
+		for _, row := range rows {
+			if !row.Day.Equal(day) {
+				continue
+			}
+			key := grouped(row.usageGroup)
+			if _, ok := byGroup[key]; !ok {
+				order = append(order, key)
+			}
+			byGroup[key] = append(byGroup[key], row)
+		}
+		for _, key := range order {
+			res := result(key, byGroup[key])
+			res["project_id"] = nullable(parseTags(key.Tags)["project"])
+			for _, field := range groupBy {
+				switch field {
+				case "provider":
+					res["provider"] = key.Provider
+				case "tags":
+					res["tags"] = parseTags(key.Tags)
+				}
+			}
+			bucket.Results = append(bucket.Results, res)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return map[string]any{"object": "page", "data": buckets, "has_more": false, "next_page": nil}
+}
+
+// nullable renders an ungrouped field as null, as the OpenAI APIs do.
+func nullable(v string) any {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+func completionsResult(key usageGroup, rows []usageRow) map[string]any {
+	var requests, input, output int64
+	for _, row := range rows {
+		requests += row.Requests
+		input += row.PromptTokens
+		output += row.CompletionTokens
+	}
+	return map[string]any{
+		"object":              "organization.usage.completions.result",
+		"input_tokens":        input,
+		"output_tokens":       output,
+		"input_cached_tokens": 0,
+		"input_audio_tokens":  0,
+		"output_audio_tokens": 0,
+		"num_model_requests":  requests,
+		"project_id":          nil,
+		"user_id":             nullable(key.User),
+		"api_key_id":          nullable(key.APIKey),
+		"model":               nullable(key.Model),
+		"batch":               nil,
+	}
+}
+
+func costsResult(key usageGroup, rows []usageRow) map[string]any {
+	cost := 0.0
+	for _, row := range rows {
+		cost += row.Cost
+	}
+	return map[string]any{
+		"object":     "organization.costs.result",
+		"amount":     map[string]any{"value": cost, "currency": "usd"},
+		"line_item":  nullable(key.Model),
+		"project_id": nil,
+	}
+}
+
+func parseUsageHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var uh UsageHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				uh.Router = h.Val()
+			default:
+				return nil, h.Errf("unrecognized ai_usage option '%s'", h.Val())
+			}
+		}
+	}
+	return &uh, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*UsageHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*UsageHandler)(nil)
+)
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// defaultUsageRetention is how long daily usage is kept unless configured otherwise.
+const defaultUsageRetention = 90 * 24 * time.Hour
+
+// Usage metrics kept per day and group.
+const (
+	usageMetricRequests         = "requests"
+	usageMetricPromptTokens     = "prompt_tokens"
+	usageMetricCompletionTokens = "completion_tokens"
+	usageMetricCostMicros       = "cost_micros" // Millionths of a USD
+)
+
+// UsageConfig keeps the usage of chat requests in the router store, summed per UTC day, user,
+// API key, provider, model and tags, for the ai_usage endpoints.
+type UsageConfig struct {
+	// How long daily usage is kept (default 90 days)
+	Retention caddy.Duration `json:"retention,omitempty"`
+}
+
+func (c *UsageConfig) validate() error {
+	if c != nil && c.Retention < 0 {
+		return fmt.Errorf("usage: retention must not be negative")
+	}
+	return nil
+}
+
+func (c *UsageConfig) retention() time.Duration {
+	if c.Retention > 0 {
+		return time.Duration(c.Retention)
+	}
+	return defaultUsageRetention
+}
+
+// usageGroup is what daily usage is summed per.
+type usageGroup struct {
+	User     string `json:"u,omitempty"`
+	APIKey   string `json:"k,omitempty"`
+	Provider string `json:"p,omitempty"`
+	Model    string `json:"m,omitempty"`
+	Tags     string `json:"t,omitempty"` // requestTags.String()
+}
+
+// usageField is the field of a day's usage hash holding one metric of one group.
+type usageField struct {
+	Metric string `json:"metric"`
+	usageGroup
+}
+
+// usageRow is the usage of one group on one day.
+type usageRow struct {
+	Day time.Time
+	usageGroup
+	Requests         int64
+	PromptTokens     int64
+	CompletionTokens int64
+	Cost             float64 // USD
+}
+
+func (cr *AICoreRouter) usageKey(day time.Time) string {
+	return cr.storeKey("usage", day.UTC().Format(time.DateOnly))
+}
+
+// recordUsage adds a request's usage to its day in the router store.
+func (cr *AICoreRouter) recordUsage(ctx context.Context, record usageRecord) {
+	ctx = context.WithoutCancel(ctx)
+	group := usageGroup{User: record.UserID, APIKey: record.APIKeyID, Provider: record.Provider, Model: record.Model, Tags: record.Tags.String()}
+	metrics := map[string]int64{
+		usageMetricRequests:         1,
+		usageMetricPromptTokens:     int64(record.PromptTokens),
+		usageMetricCompletionTokens: int64(record.CompletionTokens),
+	}
+	if record.Cost != nil {
+		metrics[usageMetricCostMicros] = int64(math.Round(*record.Cost * 1e6))
+	}
+	key := cr.usageKey(record.Time)
+	for metric, delta := range metrics {
+		if delta == 0 {
+			continue
+		}
+		field, _ := json.Marshal(usageField{Metric: metric, usageGroup: group})
+		if _, err := cr.store.HIncrBy(ctx, key, string(field), delta, cr.Usage.retention()); err != nil {
+			cr.logger.Warn("Failed to record usage", zap.String("metric", metric), zap.Error(err))
+			return
+		}
+	}
+}
+
+// usageFilter selects the usage rows of a query. Empty lists match everything; values are
+// compared case-insensitively.
+type usageFilter struct {
+	Users     []string
+	APIKeys   []string
+	Providers []string
+	Models    []string
+	Tags      requestTags // Rows need every one of these tags
+}
+
+func (f usageFilter) matches(g usageGroup) bool {
+	if !matchesAnyFold(f.Users, g.User) || !matchesAnyFold(f.APIKeys, g.APIKey) ||
+		!matchesAnyFold(f.Providers, g.Provider) || !matchesAnyFold(f.Models, g.Model) {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		tags := parseTags(g.Tags)
+		for key, value := range f.Tags {
+			if !strings.EqualFold(tags[key], value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchesAnyFold(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// usageRows returns the usage of the days from start up to, but excluding, end, ordered by day
+// and group.
+func (cr *AICoreRouter) usageRows(ctx context.Context, start, end time.Time, filter usageFilter) ([]usageRow, error) {
+	var rows []usageRow
+	for day := start.UTC().Truncate(24 * time.Hour); day.Before(end); day = day.Add(24 * time.Hour) {
+		fields, err := cr.store.HGetAll(ctx, cr.usageKey(day))
+		if err != nil {
+			return nil, fmt.Errorf("loading usage of %s: %w", day.Format(time.DateOnly), err)
+		}
+		byGroup := make(map[usageGroup]*usageRow)
+		for raw, value := range fields {
+			var field usageField
+			if json.Unmarshal([]byte(raw), &field) != nil || !filter.matches(field.usageGroup) {
+				continue
+			}
+			row := byGroup[field.usageGroup]
+			if row == nil {
+				row = &usageRow{Day: day, usageGroup: field.usageGroup}
+				byGroup[field.usageGroup] = row
+			}
+			switch field.Metric {
+			case usageMetricRequests:
+				row.Requests += value
+			case usageMetricPromptTokens:
+				row.PromptTokens += value
+			case usageMetricCompletionTokens:
+				row.CompletionTokens += value
+			case usageMetricCostMicros:
+				row.Cost += float64(value) / 1e6
+			}
+		}
+		dayRows := make([]usageRow, 0, len(byGroup))
+		for _, row := range byGroup {
+			dayRows = append(dayRows, *row)
+		}
+		sort.Slice(dayRows, func(i, j int) bool {
+			a, b := dayRows[i].usageGroup, dayRows[j].usageGroup
+			if a.User != b.User {
+				return a.User < b.User
+			}
+			if a.APIKey != b.APIKey {
+				return a.APIKey < b.APIKey
+			}
+			if a.Provider != b.Provider {
+				return a.Provider < b.Provider
+			}
+			if a.Model != b.Model {
+				return a.Model < b.Model
+			}
+			return a.Tags < b.Tags
+		})
+		rows = append(rows, dayRows...)
+	}
+	return rows, nil
+}
+
+// parseUsageCaddyfile parses `usage [<retention>]`.
+func parseUsageCaddyfile(d *caddyfile.Dispenser) (*UsageConfig, error) {
+	cfg := &UsageConfig{}
+	if d.NextArg() {
+		retention, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return nil, d.Errf("invalid usage retention '%s': %v", d.Val(), err)
+		}
+		cfg.Retention = caddy.Duration(retention)
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	return cfg, nil
+}
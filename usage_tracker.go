@@ -0,0 +1,331 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/tokenizer"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+)
+
+const UsageTrackerContextKeyString string = "ai_usage_tracker"
+
+// usageTracker accumulates token usage of a unified streamed response as it is relayed,
+// so partial counts are known if the client disconnects before the end.
+type usageTracker struct {
+	tokenizer    tokenizer.Tokenizer
+	promptTokens int  // Counted locally from the request
+	includeUsage bool // The client asked for stream_options.include_usage
+
+	mu               sync.Mutex
+	completion       strings.Builder
+	upstreamPrompt   int
+	upstreamComplete int
+	sawUsage         bool
+}
+
+func newUsageTracker(tok tokenizer.Tokenizer, promptTokens int, includeUsage bool) *usageTracker {
+	return &usageTracker{tokenizer: tok, promptTokens: promptTokens, includeUsage: includeUsage}
+}
+
+// observeChunk records one unified SSE data payload and returns the parsed chunk, or nil if it isn't one.
+func (t *usageTracker) observeChunk(data []byte) *transforms.UnifiedChatChunk {
+	var chunk transforms.UnifiedChatChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, choice := range chunk.Choices {
+		t.completion.WriteString(choice.Delta.Content)
+	}
+	if chunk.Usage != nil {
+		t.sawUsage = true
+		t.upstreamPrompt = chunk.Usage.PromptTokens
+		t.upstreamComplete = chunk.Usage.CompletionTokens
+	}
+	return &chunk
+}
+
+// observeResponse records the usage of a complete unified (non-streamed) response.
+func (t *usageTracker) observeResponse(body []byte) {
+	var resp transforms.UnifiedChatResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, choice := range resp.Choices {
+		t.completion.WriteString(choice.Message.Content)
+	}
+	if resp.Usage != nil {
+		t.sawUsage = true
+		t.upstreamPrompt = resp.Usage.PromptTokens
+		t.upstreamComplete = resp.Usage.CompletionTokens
+	}
+}
+
+// fork returns an empty tracker for another upstream request made for the same client request.
+func (t *usageTracker) fork() *usageTracker {
+	return newUsageTracker(t.tokenizer, t.promptTokens, t.includeUsage)
+}
+
+// absorb takes over what another tracker recorded, for the upstream request that served the client.
+func (t *usageTracker) absorb(other *usageTracker) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.completion.Reset()
+	t.completion.WriteString(other.completion.String())
+	t.upstreamPrompt = other.upstreamPrompt
+	t.upstreamComplete = other.upstreamComplete
+	t.sawUsage = other.sawUsage
+}
+
+// chunkFinishes reports whether any choice of the chunk carries a finish reason.
+func chunkFinishes(chunk *transforms.UnifiedChatChunk) bool {
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot returns the usage seen so far. Without upstream usage, tokens are counted
+// locally from the request and the relayed content.
+func (t *usageTracker) snapshot() (promptTokens int, completionTokens int, estimated bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sawUsage {
+		return t.upstreamPrompt, t.upstreamComplete, false
+	}
+	return t.promptTokens, t.tokenizer.Count(t.completion.String()), true
+}
+
+// completionText returns the completion content relayed so far.
+func (t *usageTracker) completionText() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.completion.String()
+}
+
+// usageBody relays an SSE response body, feeding each chunk to a usageTracker. The chunk that
+// finishes the response is held back until it's clear whether upstream reports usage; if it
+// doesn't, a locally counted usage object is attached to that chunk before it's released.
+// Clients that asked for include_usage instead always get OpenAI's final usage-only chunk,
+// either the upstream's own or one built here.
+type usageBody struct {
+	io.ReadCloser
+	tracker *usageTracker
+
+	meta      transforms.UnifiedChatChunk // Stream id, model and creation time, for a built usage chunk
+	sawChunk  bool
+	usageSent bool // A usage-only chunk was relayed
+
+	pending []byte // Upstream bytes not yet split into lines
+	held    []byte // Lines from the finishing chunk onwards, while waiting for usage
+	heldIdx int    // Offset of the finishing chunk's payload line in held
+	holding bool
+	out     bytes.Buffer
+	buf     []byte
+	err     error
+}
+
+func (b *usageBody) Read(p []byte) (int, error) {
+	for b.out.Len() == 0 && b.err == nil {
+		if len(b.buf) < len(p) {
+			b.buf = make([]byte, len(p))
+		}
+		n, err := b.ReadCloser.Read(b.buf[:len(p)])
+		if n > 0 {
+			b.pending = append(b.pending, b.buf[:n]...)
+			b.processLines()
+		}
+		if err != nil {
+			b.err = err
+			if len(b.pending) > 0 {
+				b.processLine(b.pending)
+				b.pending = nil
+			}
+			b.end()
+		}
+	}
+	if b.out.Len() > 0 {
+		return b.out.Read(p)
+	}
+	return 0, b.err
+}
+
+func (b *usageBody) processLines() {
+	for {
+		idx := bytes.IndexByte(b.pending, '\n')
+		if idx == -1 {
+			return
+		}
+		b.processLine(b.pending[:idx+1])
+		b.pending = b.pending[idx+1:]
+	}
+}
+
+func (b *usageBody) processLine(line []byte) {
+	payload, isData := strings.CutPrefix(strings.TrimSpace(string(line)), "data:")
+	payload = strings.TrimSpace(payload)
+	if !isData || payload == "" {
+		b.emit(line)
+		return
+	}
+	if payload == "[DONE]" {
+		b.end()
+		b.out.Write(line)
+		return
+	}
+
+	chunk := b.tracker.observeChunk([]byte(payload))
+	if chunk == nil {
+		b.emit(line)
+		return
+	}
+	b.remember(chunk)
+	switch {
+	case chunk.Usage != nil:
+		b.release(false) // Upstream reports usage itself
+		b.usageSent = b.usageSent || len(chunk.Choices) == 0
+		b.out.Write(line)
+	case chunkFinishes(chunk) && !b.tracker.includeUsage:
+		// With several choices, usage goes on the last one to finish
+		b.holding = true
+		b.heldIdx = len(b.held)
+		b.held = append(b.held, line...)
+	default:
+		b.emit(line)
+	}
+}
+
+// remember keeps the stream metadata a chunk carries; providers often send it on the first chunk only.
+func (b *usageBody) remember(chunk *transforms.UnifiedChatChunk) {
+	b.sawChunk = true
+	if chunk.ID != "" {
+		b.meta.ID = chunk.ID
+	}
+	if chunk.Created != 0 {
+		b.meta.Created = chunk.Created
+	}
+	if chunk.Model != "" {
+		b.meta.Model = chunk.Model
+	}
+}
+
+// end completes the stream's usage reporting once upstream is done.
+func (b *usageBody) end() {
+	b.release(true)
+	if !b.tracker.includeUsage || b.usageSent || !b.sawChunk {
+		return
+	}
+	b.usageSent = true
+	promptTokens, completionTokens, _ := b.tracker.snapshot()
+	data, err := json.Marshal(transforms.UnifiedChatChunk{
+		ID:      b.meta.ID,
+		Object:  "chat.completion.chunk",
+		Created: b.meta.Created,
+		Model:   b.meta.Model,
+		Choices: []transforms.UnifiedChunkChoice{},
+		Usage: &transforms.UnifiedUsage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	})
+	if err != nil {
+		return
+	}
+	b.out.WriteString("data: " + string(data) + "\n\n")
+}
+
+// emit writes a line, or queues it behind the held chunk.
+func (b *usageBody) emit(line []byte) {
+	if b.holding {
+		b.held = append(b.held, line...)
+		return
+	}
+	b.out.Write(line)
+}
+
+// release writes the held lines, first attaching locally counted usage to the finishing chunk if asked to.
+func (b *usageBody) release(synthesize bool) {
+	if !b.holding {
+		return
+	}
+	b.holding = false
+	held := b.held
+	b.held = nil
+	if synthesize {
+		end := b.heldIdx + bytes.IndexByte(held[b.heldIdx:], '\n')
+		if end < b.heldIdx {
+			end = len(held)
+		}
+		if line, ok := b.withUsage(held[b.heldIdx:end]); ok {
+			b.out.Write(held[:b.heldIdx])
+			b.out.Write(line)
+			b.out.Write(held[end:])
+			return
+		}
+	}
+	b.out.Write(held)
+}
+
+// withUsage returns the data line with a synthesized usage object added to its chunk.
+func (b *usageBody) withUsage(line []byte) ([]byte, bool) {
+	payload := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(line)), "data:"))
+	var chunk map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+		return nil, false
+	}
+	promptTokens, completionTokens, _ := b.tracker.snapshot()
+	usage, _ := json.Marshal(transforms.UnifiedUsage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	})
+	chunk["usage"] = usage
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return nil, false
+	}
+	return []byte("data: " + string(data)), true
+}
+
+// trackUsage feeds the request's usage tracker: streamed bodies are wrapped so it sees every
+// chunk, complete JSON bodies are read once.
+func (cr *AICoreRouter) trackUsage(resp *http.Response) {
+	tracker, ok := resp.Request.Context().Value(UsageTrackerContextKeyString).(*usageTracker)
+	if !ok || tracker == nil || resp.StatusCode >= 400 {
+		return
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		resp.Body = &usageBody{ReadCloser: resp.Body, tracker: tracker}
+		return
+	}
+	if strings.HasPrefix(contentType, "application/json") {
+		common.HookHttpResponseBody(resp, func(resp *http.Response, body []byte) ([]byte, error) {
+			tracker.observeResponse(body)
+			return body, nil
+		})
+	}
+}
+
+// requestPromptTokens counts the prompt tokens of a unified request body.
+func (cr *AICoreRouter) requestPromptTokens(body []byte) int {
+	var unifiedReq transforms.UnifiedChatRequest
+	if err := json.Unmarshal(body, &unifiedReq); err != nil {
+		return 0
+	}
+	return cr.countPromptTokens(unifiedReq.Messages)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/tokenizer"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/transforms"
+)
+
+// countPromptTokens counts the prompt tokens of a conversation with the router's tokenizer.
+func (cr *AICoreRouter) countPromptTokens(messages []transforms.UnifiedChatMessage) int {
+	counted := make([]tokenizer.Message, len(messages))
+	for i, msg := range messages {
+		counted[i] = tokenizer.Message{Role: msg.Role, Content: msg.Content}
+	}
+	return tokenizer.CountMessages(cr.tokenizer, counted)
+}
+
+// validateRequestLimits enforces the route's message and prompt size limits before transformation.
+// On violation it writes an OpenAI-style 400 error and returns a non-nil error.
+func (cr *AICoreRouter) validateRequestLimits(w http.ResponseWriter, opts *RouteOptions, body []byte) error {
+	if opts.MaxMessages <= 0 && opts.MaxPromptTokens <= 0 {
+		return nil
+	}
+
+	var unifiedReq transforms.UnifiedChatRequest
+	if err := json.Unmarshal(body, &unifiedReq); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_request", "Invalid JSON request body")
+		return err
+	}
+
+	if opts.MaxMessages > 0 && len(unifiedReq.Messages) > opts.MaxMessages {
+		writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "too_many_messages",
+			fmt.Sprintf("Request has %d messages, exceeding the maximum of %d", len(unifiedReq.Messages), opts.MaxMessages))
+		return fmt.Errorf("request has %d messages, max %d", len(unifiedReq.Messages), opts.MaxMessages)
+	}
+
+	if opts.MaxPromptTokens > 0 {
+		if tokens := cr.countPromptTokens(unifiedReq.Messages); tokens > opts.MaxPromptTokens {
+			writeOpenAIError(w, http.StatusBadRequest, ErrorTypeInvalidRequest, "prompt_too_long",
+				fmt.Sprintf("Prompt is estimated at %d tokens, exceeding the maximum of %d", tokens, opts.MaxPromptTokens))
+			return fmt.Errorf("prompt estimated at %d tokens, max %d", tokens, opts.MaxPromptTokens)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/neutrome-labs/caddy-ai-router/pkg/common"
+	"go.uber.org/zap"
+)
+
+// Warm-up defaults. Ollama unloads idle models after 5 minutes, so warming up more often keeps
+// them loaded.
+const (
+	defaultWarmUpPrompt   = "Hi"
+	defaultWarmUpInterval = 4 * time.Minute
+	defaultWarmUpTimeout  = 2 * time.Minute
+)
+
+// WarmUp sends a provider small chat completions requests, one per model, when the router
+// starts and then periodically, so self-hosted servers such as Ollama or vLLM load the models
+// before real requests need them. A model whose warm-up fails is marked degraded: routing
+// avoids it while another provider can serve the model, until a warm-up succeeds.
+type WarmUp struct {
+	// Models to warm up; defaults to the models declared in the provider's manifest
+	Models []string `json:"models,omitempty"`
+	// User message of the warm-up requests (default "Hi")
+	Prompt string `json:"prompt,omitempty"`
+	// Time between warm-ups of a model (default 4m)
+	Interval caddy.Duration `json:"interval,omitempty"`
+	// How long a warm-up may take, model load included (default 2m)
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+}
+
+func (w *WarmUp) validate() error {
+	if w.Interval < 0 || w.Timeout < 0 {
+		return fmt.Errorf("warm_up: interval and timeout must be positive")
+	}
+	return nil
+}
+
+func (w *WarmUp) prompt() string {
+	if w.Prompt == "" {
+		return defaultWarmUpPrompt
+	}
+	return w.Prompt
+}
+
+func (w *WarmUp) interval() time.Duration {
+	if w.Interval > 0 {
+		return time.Duration(w.Interval)
+	}
+	return defaultWarmUpInterval
+}
+
+func (w *WarmUp) timeout() time.Duration {
+	if w.Timeout > 0 {
+		return time.Duration(w.Timeout)
+	}
+	return defaultWarmUpTimeout
+}
+
+// models returns the models to warm up.
+func (w *WarmUp) models(p *ProviderConfig) []string {
+	if len(w.Models) > 0 {
+		return w.Models
+	}
+	models := make([]string, 0, len(p.Models))
+	for _, m := range p.Models {
+		models = append(models, m.ID)
+	}
+	return models
+}
+
+// warmer runs the warm-ups of a router's providers in the background.
+type warmer struct {
+	cr   *AICoreRouter
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newWarmer(cr *AICoreRouter) *warmer {
+	return &warmer{cr: cr, stop: make(chan struct{})}
+}
+
+// Start warms up every configured model right away, then every interval.
+func (w *warmer) Start() {
+	for _, p := range w.cr.Providers {
+		if p.WarmUp == nil {
+			continue
+		}
+		for _, model := range p.WarmUp.models(p) {
+			w.wg.Add(1)
+			go w.run(p, model)
+		}
+	}
+}
+
+// Stop ends the warm-ups, cancelling those in flight.
+func (w *warmer) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+func (w *warmer) run(p *ProviderConfig, model string) {
+	defer w.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-w.stop
+		cancel()
+	}()
+	ticker := time.NewTicker(p.WarmUp.interval())
+	defer ticker.Stop()
+	for {
+		w.cr.warmUp(ctx, p, model)
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// warmUp sends one warm-up request and marks the model degraded, or healthy again, by its outcome.
+func (cr *AICoreRouter) warmUp(ctx context.Context, p *ProviderConfig, model string) {
+	ctx, cancel := context.WithTimeout(ctx, p.WarmUp.timeout())
+	defer cancel()
+	start := time.Now()
+	err := cr.sendWarmUp(ctx, p, model)
+	if ctx.Err() == context.Canceled {
+		return // The router is stopping
+	}
+	elapsed := time.Since(start)
+	key := cr.storeKey("degraded", p.Name, model)
+	storeCtx := context.WithoutCancel(ctx)
+	if err != nil {
+		warmUps.WithLabelValues(cr.Name, p.Name, model, "failed").Inc()
+		// The mark outlives the next warm-up, so it only lapses if warm-ups stop
+		ttl := p.WarmUp.interval() + p.WarmUp.timeout()
+		if err := cr.store.Set(storeCtx, key, []byte(time.Now().UTC().Format(time.RFC3339Nano)), ttl); err != nil {
+			cr.logger.Warn("Failed to mark model degraded", zap.Error(err), zap.String("provider", p.Name))
+		}
+		cr.logger.Warn("Model warm-up failed, marking it degraded",
+			zap.String("provider", p.Name),
+			zap.String("model", model),
+			zap.Duration("elapsed", elapsed),
+			zap.Error(err),
+		)
+		cr.observability.Fire("system", "", "model_warm_up_failed", map[string]any{
+			"provider":   p.Name,
+			"model":      model,
+			"elapsed_ms": elapsed.Milliseconds(),
+			"error":      err.Error(),
+		})
+		return
+	}
+	warmUps.WithLabelValues(cr.Name, p.Name, model, "ok").Inc()
+	if cr.degraded(storeCtx, p, model) {
+		cr.logger.Info("Model warmed up, no longer degraded", zap.String("provider", p.Name), zap.String("model", model))
+	}
+	if err := cr.store.Delete(storeCtx, key); err != nil {
+		cr.logger.Warn("Failed to clear degraded model", zap.Error(err), zap.String("provider", p.Name))
+	}
+	cr.logger.Debug("Model warmed up", zap.String("provider", p.Name), zap.String("model", model), zap.Duration("elapsed", elapsed))
+}
+
+// sendWarmUp sends a one-token chat completion for the model through the provider's transport,
+// converted to its API as a proxied request would be.
+func (cr *AICoreRouter) sendWarmUp(ctx context.Context, p *ProviderConfig, model string) error {
+	body, err := json.Marshal(map[string]any{
+		"model":      model,
+		"messages":   []map[string]string{{"role": "user", "content": p.WarmUp.prompt()}},
+		"max_tokens": 1,
+		"stream":     false,
+	})
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	apiKey, err := discoveryAPIKey(cr.apiKeyServiceFor(r), p, "")
+	if err != nil {
+		return fmt.Errorf("no API key: %v", err)
+	}
+	ctx = context.WithValue(ctx, ProviderNameContextKeyString, p.Name)
+	ctx = context.WithValue(ctx, ActualModelNameContextKeyString, model)
+	ctx = context.WithValue(ctx, ExternalAPIKeyProviderContextKeyString, apiKey)
+	ctx = context.WithValue(ctx, common.StreamContextKeyString, false)
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+apiKey)
+	cr.rewriteUpstreamRequest(p, r, cr.logger)
+
+	transport := p.proxy.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// degraded reports whether the model's last warm-up on the provider failed.
+func (cr *AICoreRouter) degraded(ctx context.Context, p *ProviderConfig, model string) bool {
+	if p.WarmUp == nil {
+		return false
+	}
+	_, ok, err := cr.store.Get(ctx, cr.storeKey("degraded", p.Name, model))
+	if err != nil {
+		cr.requestLogger(ctx).Warn("Failed to check degraded model", zap.Error(err), zap.String("provider", p.Name))
+		return false
+	}
+	return ok
+}
+
+// degradedModels lists the provider's warmed-up models whose last warm-up failed.
+func (cr *AICoreRouter) degradedModels(ctx context.Context, p *ProviderConfig) []string {
+	if p.WarmUp == nil {
+		return nil
+	}
+	var degraded []string
+	for _, model := range p.WarmUp.models(p) {
+		if cr.degraded(ctx, p, model) {
+			degraded = append(degraded, model)
+		}
+	}
+	return degraded
+}
+
+// parseWarmUpCaddyfile parses `warm_up [<models...>] [{ ... }]`.
+func parseWarmUpCaddyfile(d *caddyfile.Dispenser, providerName string) (*WarmUp, error) {
+	w := &WarmUp{Models: d.RemainingArgs()}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "models":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			w.Models = append(w.Models, args...)
+		case "prompt":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			w.Prompt = d.Val()
+		case "interval", "timeout":
+			option := d.Val()
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			value, err := caddy.ParseDuration(d.Val())
+			if err != nil || value <= 0 {
+				return nil, d.Errf("provider %s: invalid warm_up %s '%s'", providerName, option, d.Val())
+			}
+			if option == "interval" {
+				w.Interval = caddy.Duration(value)
+			} else {
+				w.Timeout = caddy.Duration(value)
+			}
+		default:
+			return nil, d.Errf("provider %s: unrecognized warm_up option '%s'", providerName, d.Val())
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+func init() {
+	caddy.RegisterModule(WebSocketHandler{})
+	httpcaddyfile.RegisterHandlerDirective("ai_websocket", parseWebSocketHandlerCaddyfile)
+}
+
+// Largest frame a client may send unless max_request_size says otherwise.
+const defaultWebSocketMaxFrame = 32 << 20
+
+// WebSocketHandler serves chat completions over WebSocket, for clients whose HTTP stacks can't
+// consume SSE. Each text frame a client sends is a chat completion request, served in order
+// like one to ai_chat_completions (streamed unless it sets "stream": false); unified chunks come
+// back as one JSON frame each, followed by a {"type":"done"} frame. Errors are sent as
+// {"type":"error","status":...,"error":{...}} frames, and {"type":"cancel"} cancels the request
+// in progress.
+type WebSocketHandler struct {
+	Router string `json:"router,omitempty"`
+	// Origins browsers may connect from, e.g. "https://app.example.com" (any if empty)
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+	RouteOptions
+
+	logger  *zap.Logger
+	routers *routerScope
+}
+
+func (WebSocketHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.ai_websocket",
+		New: func() caddy.Module { return new(WebSocketHandler) },
+	}
+}
+
+func (h *WebSocketHandler) Provision(ctx caddy.Context) error {
+	h.logger = handlerLogger(ctx, h)
+	var err error
+	if h.routers, err = routerScopeOf(ctx); err != nil {
+		return err
+	}
+	if err := h.RouteOptions.provision(h.logger); err != nil {
+		return fmt.Errorf("ai_websocket: %v", err)
+	}
+	if h.Heartbeat > 0 {
+		return fmt.Errorf("ai_websocket: heartbeat only applies to SSE endpoints")
+	}
+	return nil
+}
+
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return next.ServeHTTP(w, r)
+	}
+	cr, routerName, ok := routerFor(r, h.routers, h.Router)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, ErrorTypeAPI, "router_not_found", fmt.Sprintf("ai_websocket: router '%s' not found", routerName))
+		return nil
+	}
+	defer cr.track()()
+
+	cr.firePageviewEvent(r)
+
+	server := websocket.Server{
+		Handshake: h.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			h.serveConn(ws, cr, r)
+		},
+	}
+	server.ServeHTTP(w, r)
+	return nil
+}
+
+// checkOrigin accepts non-browser clients and browsers on allowed origins. No subprotocol is
+// selected.
+func (h *WebSocketHandler) checkOrigin(config *websocket.Config, r *http.Request) error {
+	config.Protocol = nil
+	return checkWebSocketOrigin(h.AllowedOrigins, config, r)
+}
+
+// checkWebSocketOrigin accepts non-browser clients, which send no Origin, and browsers on one of
+// the allowed origins (any if none are listed).
+func checkWebSocketOrigin(allowedOrigins []string, config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(allowedOrigins) == 0 {
+		return nil
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			var err error
+			config.Origin, err = url.ParseRequestURI(origin)
+			return err
+		}
+	}
+	return fmt.Errorf("origin %s not allowed", origin)
+}
+
+// serveConn reads frames until the client goes away, serving requests one at a time. Frames are
+// read in the background so cancel frames and disconnects are noticed mid-request.
+func (h *WebSocketHandler) serveConn(ws *websocket.Conn, cr *AICoreRouter, upgrade *http.Request) {
+	ws.PayloadType = websocket.TextFrame
+	ws.MaxPayloadBytes = defaultWebSocketMaxFrame
+	if h.MaxRequestSize > 0 {
+		ws.MaxPayloadBytes = int(h.MaxRequestSize)
+	}
+	ctx, cancel := context.WithCancel(upgrade.Context())
+	defer cancel()
+
+	var mu sync.Mutex
+	cancelRequest := func() {}
+	frames := make(chan []byte)
+	go func() {
+		defer cancel()
+		defer close(frames)
+		for {
+			var frame []byte
+			if err := websocket.Message.Receive(ws, &frame); err != nil {
+				return
+			}
+			var control struct {
+				Type string `json:"type"`
+			}
+			if json.Unmarshal(frame, &control) == nil && control.Type == "cancel" {
+				mu.Lock()
+				cancelRequest()
+				mu.Unlock()
+				continue
+			}
+			select {
+			case frames <- frame:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for frame := range frames {
+		reqCtx, cancelReq := context.WithCancel(ctx)
+		mu.Lock()
+		cancelRequest = cancelReq
+		mu.Unlock()
+		h.serveFrame(ws, cr, upgrade.Clone(reqCtx), frame)
+		cancelReq()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// serveFrame serves one request frame as a POST on the upgrade request's path and headers.
+func (h *WebSocketHandler) serveFrame(ws *websocket.Conn, cr *AICoreRouter, r *http.Request, frame []byte) {
+	fw := &webSocketResponseWriter{ws: ws, header: make(http.Header)}
+	defer fw.finish()
+
+	body, err := withStreamDefault(frame)
+	if err != nil {
+		writeOpenAIError(fw, http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid_json", "Invalid JSON request frame")
+		return
+	}
+	r.Method = http.MethodPost
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	for _, name := range []string{"Upgrade", "Connection", "Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Protocol"} {
+		r.Header.Del(name)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	noop := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error { return nil })
+	aborted := proxyRecovering(func() {
+		if err := cr.handlePostInferenceRequest(fw, r, noop, cr.apiKeyServiceFor(r), &h.RouteOptions); err != nil {
+			h.logger.Debug("WebSocket request failed", zap.Error(err))
+		}
+	})
+	if aborted {
+		fw.buf.Reset() // Cancelled or cut off mid-stream; the partial line is dropped
+	}
+}
+
+// withStreamDefault turns on streaming for request frames that don't say otherwise.
+func withStreamDefault(frame []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(frame))
+	decoder.UseNumber()
+	var req map[string]any
+	if err := decoder.Decode(&req); err != nil || req == nil {
+		return nil, fmt.Errorf("request frame is not a JSON object")
+	}
+	if _, ok := req["stream"]; ok {
+		return frame, nil
+	}
+	req["stream"] = true
+	return json.Marshal(req)
+}
+
+// webSocketResponseWriter turns the response to one request into frames: each data payload of
+// a unified stream becomes a frame, and a complete body becomes a single frame.
+type webSocketResponseWriter struct {
+	ws     *websocket.Conn
+	header http.Header
+
+	status    int
+	streaming bool
+	closed    bool
+	buf       bytes.Buffer
+}
+
+func (w *webSocketResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *webSocketResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	w.streaming = status < 400 && strings.HasPrefix(w.header.Get("Content-Type"), "text/event-stream")
+}
+
+func (w *webSocketResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	w.buf.Write(p)
+	if w.streaming {
+		for {
+			line, err := w.buf.ReadBytes('\n')
+			if err != nil {
+				w.buf.Write(line) // Incomplete line, wait for the rest
+				break
+			}
+			if err := w.sendLine(line); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Flush is a no-op: stream frames are sent as soon as their line is complete.
+func (w *webSocketResponseWriter) Flush() {}
+
+func (w *webSocketResponseWriter) sendLine(line []byte) error {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return nil // Blank lines, comments and event names
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("[DONE]")) {
+		return nil
+	}
+	return w.send(data)
+}
+
+func (w *webSocketResponseWriter) send(frame []byte) error {
+	if err := websocket.Message.Send(w.ws, string(frame)); err != nil {
+		w.closed = true
+		return err
+	}
+	return nil
+}
+
+// finish sends what is left of the response, then the done frame.
+func (w *webSocketResponseWriter) finish() {
+	if w.closed {
+		return
+	}
+	switch {
+	case w.streaming:
+		if rest := w.buf.Bytes(); len(bytes.TrimSpace(rest)) > 0 {
+			w.sendLine(rest)
+		}
+	case w.status >= 400:
+		var envelope struct {
+			Error json.RawMessage `json:"error"`
+		}
+		if json.Unmarshal(w.buf.Bytes(), &envelope) != nil || len(envelope.Error) == 0 {
+			envelope.Error, _ = json.Marshal(OpenAIError{Message: strings.TrimSpace(w.buf.String()), Type: errorTypeForStatus(w.status)})
+		}
+		frame, _ := json.Marshal(map[string]any{"type": "error", "status": w.status, "error": envelope.Error})
+		w.send(frame)
+	case w.buf.Len() > 0:
+		w.send(bytes.TrimSpace(w.buf.Bytes()))
+	}
+	if !w.closed {
+		w.send([]byte(`{"type":"done"}`))
+	}
+}
+
+func parseWebSocketHandlerCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var wh WebSocketHandler
+	for h.Next() {
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "router":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				wh.Router = h.Val()
+			case "allowed_origins":
+				args := h.RemainingArgs()
+				if len(args) == 0 {
+					return nil, h.ArgErr()
+				}
+				wh.AllowedOrigins = append(wh.AllowedOrigins, args...)
+			default:
+				if ok, err := wh.RouteOptions.unmarshalCaddyfileOption(h.Dispenser); err != nil {
+					return nil, err
+				} else if !ok {
+					return nil, h.Errf("unrecognized ai_websocket option '%s'", h.Val())
+				}
+			}
+		}
+	}
+	return &wh, nil
+}
+
+var (
+	_ caddy.Provisioner           = (*WebSocketHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*WebSocketHandler)(nil)
+)
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/neutrome-labs/caddy-ai-router/pkg/providers"
+)
+
+// accountIDPlaceholder is replaced by a provider's account_id in its api_base_url.
+const accountIDPlaceholder = "{account_id}"
+
+// resolveWorkersAIBaseURL fills in the api_base_url of a cloudflare provider from its account_id
+// and gateway: the account's Workers AI endpoint on the Cloudflare API, or on the AI Gateway.
+func (p *ProviderConfig) resolveWorkersAIBaseURL() error {
+	if p.Style != "cloudflare" {
+		if p.AccountID != "" || p.Gateway != "" || len(p.OpenAICompatible) > 0 || len(p.LoRA) > 0 {
+			return fmt.Errorf("account_id, gateway, openai_compatible and lora are only supported by the cloudflare style")
+		}
+		return nil
+	}
+	if p.Gateway != "" && p.AccountID == "" {
+		return fmt.Errorf("gateway requires account_id")
+	}
+	switch {
+	case p.APIBaseURL == "" && p.Gateway != "":
+		p.APIBaseURL = providers.CloudflareGatewayURL(p.AccountID, p.Gateway)
+	case p.APIBaseURL == "" && p.AccountID != "":
+		p.APIBaseURL = providers.CloudflareAccountURL(p.AccountID)
+	case strings.Contains(p.APIBaseURL, accountIDPlaceholder):
+		if p.AccountID == "" {
+			return fmt.Errorf("api_base_url references %s but no account_id is set", accountIDPlaceholder)
+		}
+		p.APIBaseURL = strings.ReplaceAll(p.APIBaseURL, accountIDPlaceholder, p.AccountID)
+	}
+	return nil
+}
+
+// newCloudflareProvider builds the Workers AI provider with its account, gateway, endpoint and
+// LoRA options.
+func newCloudflareProvider(p *ProviderConfig) providers.Provider {
+	cf := &providers.CloudflareProvider{
+		AccountID: p.AccountID,
+		Gateway:   p.Gateway != "" || p.parsedURL.Host == "gateway.ai.cloudflare.com",
+		LoRA:      p.LoRA,
+	}
+	if len(p.OpenAICompatible) > 0 {
+		patterns := make([]*regexp.Regexp, 0, len(p.OpenAICompatible))
+		for _, glob := range p.OpenAICompatible {
+			patterns = append(patterns, globPattern(glob))
+		}
+		cf.OpenAICompatible = func(modelName string) bool {
+			for _, pattern := range patterns {
+				if pattern.MatchString(modelName) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return cf
+}